@@ -0,0 +1,401 @@
+// Command seed loads a running server with synthetic tenants and measures
+// how it holds up, for tuning pgvector/HNSW settings before onboarding a
+// big tenant.
+//
+//	seed -target http://localhost:8080 -orgs 5 -docs 200 -concurrency 16 -duration 1m
+//
+// It registers -orgs new orgs, uploads -docs documents of synthetic text to
+// each and waits for them to be ingested, then runs -concurrency workers
+// sending queries for -duration. Everything goes through the public HTTP
+// API, so the numbers include what clients see. The report (upload and
+// query latency percentiles, ingestion throughput, errors by status) is
+// printed as JSON.
+//
+// Documents are drawn from topics of made-up words and queries from the
+// same topics, so retrieval has real matches to find; -seed makes a run
+// reproducible. With -queries-only, the orgs of an earlier run are reused
+// from the credentials file it wrote (-creds).
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// seedOrg is a generated tenant and how to act as it.
+type seedOrg struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Token string `json:"token"`
+	// Topics are the topics its documents were drawn from.
+	Topics []int `json:"topics"`
+}
+
+// latencies summarizes the durations of one kind of request.
+type latencies struct {
+	Count  int            `json:"count"`
+	Errors int            `json:"errors"`
+	ByCode map[string]int `json:"errors_by_status,omitempty"`
+	P50Ms  int64          `json:"p50_ms"`
+	P90Ms  int64          `json:"p90_ms"`
+	P95Ms  int64          `json:"p95_ms"`
+	P99Ms  int64          `json:"p99_ms"`
+	MaxMs  int64          `json:"max_ms"`
+	PerSec float64        `json:"per_second"`
+}
+
+// ingestion is how the uploaded documents fared.
+type ingestion struct {
+	Ready     int     `json:"ready"`
+	Failed    int     `json:"failed"`
+	Pending   int     `json:"pending"`
+	ElapsedMs int64   `json:"elapsed_ms"`
+	DocsSec   float64 `json:"documents_per_second"`
+}
+
+type report struct {
+	Target    string     `json:"target"`
+	Orgs      int        `json:"orgs"`
+	Documents int        `json:"documents,omitempty"`
+	Uploads   *latencies `json:"uploads,omitempty"`
+	Ingestion *ingestion `json:"ingestion,omitempty"`
+	Queries   *latencies `json:"queries,omitempty"`
+}
+
+// recorder collects outcomes from concurrent workers.
+type recorder struct {
+	mu     sync.Mutex
+	took   []time.Duration
+	errors map[string]int
+}
+
+func (r *recorder) add(took time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.errors == nil {
+			r.errors = map[string]int{}
+		}
+		var se *statusError
+		if errors.As(err, &se) {
+			r.errors[fmt.Sprint(se.status)]++
+		} else {
+			r.errors["transport"]++
+		}
+		return
+	}
+	r.took = append(r.took, took)
+}
+
+func (r *recorder) summary(elapsed time.Duration) *latencies {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := &latencies{Count: len(r.took), ByCode: r.errors}
+	for _, n := range r.errors {
+		l.Errors += n
+	}
+	if elapsed > 0 {
+		l.PerSec = float64(l.Count) / elapsed.Seconds()
+	}
+	if len(r.took) == 0 {
+		return l
+	}
+	sorted := slices.Clone(r.took)
+	slices.Sort(sorted)
+	at := func(q float64) int64 {
+		return sorted[min(int(q*float64(len(sorted))), len(sorted)-1)].Milliseconds()
+	}
+	l.P50Ms, l.P90Ms, l.P95Ms, l.P99Ms = at(0.50), at(0.90), at(0.95), at(0.99)
+	l.MaxMs = sorted[len(sorted)-1].Milliseconds()
+	return l
+}
+
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, strings.TrimSpace(e.body))
+}
+
+// client talks to the target server.
+type client struct {
+	base string
+	http *http.Client
+}
+
+// do sends a JSON request and decodes a JSON response into out (if not
+// nil). Non-2xx responses are a *statusError.
+func (c *client) do(ctx context.Context, method, path, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{status: resp.StatusCode, body: string(data)}
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "base URL of the server under test")
+	orgs := flag.Int("orgs", 5, "orgs to create")
+	docs := flag.Int("docs", 100, "documents per org")
+	words := flag.Int("words", 600, "words per document")
+	topics := flag.Int("topics", 40, "distinct topics documents are drawn from")
+	concurrency := flag.Int("concurrency", 8, "concurrent uploads and query workers")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send queries (0 skips the query phase)")
+	topK := flag.Int("top-k", 5, "top_k sent with each query")
+	ingestTimeout := flag.Duration("ingest-timeout", 30*time.Minute, "how long to wait for ingestion")
+	seed := flag.Uint64("seed", 1, "random seed for text, questions and org names")
+	creds := flag.String("creds", "seed-orgs.json", "where the created orgs' tokens are written, or read with -queries-only")
+	queriesOnly := flag.Bool("queries-only", false, "skip seeding and query the orgs in -creds")
+	flag.Parse()
+
+	if *orgs <= 0 || *docs < 0 || *words <= 0 || *topics <= 0 || *concurrency <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := &client{base: strings.TrimRight(*target, "/"), http: &http.Client{Timeout: 2 * time.Minute}}
+	gen := newGenerator(*seed, *topics)
+	rep := report{Target: c.base}
+
+	var tenants []seedOrg
+	if *queriesOnly {
+		data, err := os.ReadFile(*creds)
+		if err == nil {
+			err = json.Unmarshal(data, &tenants)
+		}
+		if err != nil {
+			slog.Error("failed to read orgs", "file", *creds, "error", err)
+			os.Exit(1)
+		}
+	} else {
+		var err error
+		tenants, err = registerOrgs(ctx, c, gen, *orgs, *seed)
+		if err != nil {
+			slog.Error("failed to create orgs", "error", err)
+			os.Exit(1)
+		}
+		if data, err := json.MarshalIndent(tenants, "", "  "); err == nil {
+			if err := os.WriteFile(*creds, data, 0o600); err != nil {
+				slog.Warn("failed to save org credentials", "file", *creds, "error", err)
+			}
+		}
+
+		start := time.Now()
+		rep.Documents = len(tenants) * *docs
+		rep.Uploads = upload(ctx, c, gen, tenants, *docs, *words, *concurrency)
+		rep.Uploads.PerSec = float64(rep.Uploads.Count) / time.Since(start).Seconds()
+		slog.Info("uploaded documents", "count", rep.Uploads.Count, "errors", rep.Uploads.Errors)
+
+		ready, failed, pending := waitIngested(ctx, c, tenants, *ingestTimeout)
+		elapsed := time.Since(start)
+		rep.Ingestion = &ingestion{
+			Ready: ready, Failed: failed, Pending: pending,
+			ElapsedMs: elapsed.Milliseconds(), DocsSec: float64(ready) / elapsed.Seconds(),
+		}
+		slog.Info("ingestion settled", "ready", ready, "failed", failed, "pending", pending, "elapsed", elapsed)
+	}
+	rep.Orgs = len(tenants)
+
+	if *duration > 0 && ctx.Err() == nil {
+		rep.Queries = queryLoad(ctx, c, gen, tenants, *concurrency, *duration, *topK)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
+
+// registerOrgs creates n orgs, each owning a few topics, and returns their
+// admin tokens.
+func registerOrgs(ctx context.Context, c *client, gen *generator, n int, seed uint64) ([]seedOrg, error) {
+	run := time.Now().Unix()
+	tenants := make([]seedOrg, n)
+	for i := range tenants {
+		email := fmt.Sprintf("seed-%d-%d-%d@seed.invalid", run, seed, i)
+		var resp struct {
+			Token string `json:"token"`
+			Org   struct {
+				ID string `json:"id"`
+			} `json:"org"`
+		}
+		err := c.do(ctx, http.MethodPost, "/api/v1/auth/register", "", map[string]string{
+			"org_name": fmt.Sprintf("Seed %d-%d", run, i),
+			"email":    email,
+			"password": gen.password(),
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("register org %d: %w", i, err)
+		}
+		tenants[i] = seedOrg{ID: resp.Org.ID, Email: email, Token: resp.Token, Topics: gen.topicsFor(i)}
+	}
+	return tenants, nil
+}
+
+// upload sends docs documents to every org with concurrency uploads in
+// flight.
+func upload(ctx context.Context, c *client, gen *generator, tenants []seedOrg, docs, words, concurrency int) *latencies {
+	type job struct {
+		org  seedOrg
+		i    int
+		text string
+	}
+	jobs := make(chan job)
+	rec := &recorder{}
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				start := time.Now()
+				err := c.do(ctx, http.MethodPost, "/api/v1/documents", j.org.Token, map[string]any{
+					"name":    fmt.Sprintf("seed-%04d.txt", j.i),
+					"content": j.text,
+					"tags":    []string{"seed"},
+				}, nil)
+				if err != nil && ctx.Err() == nil {
+					slog.Debug("upload failed", "org_id", j.org.ID, "error", err)
+				}
+				rec.add(time.Since(start), err)
+			}
+		}()
+	}
+	// Text is generated here, on one goroutine, so a seed always yields the
+	// same corpus.
+feed:
+	for i := range docs {
+		for _, o := range tenants {
+			select {
+			case jobs <- job{org: o, i: i, text: gen.document(o.Topics, words)}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return rec.summary(0)
+}
+
+// waitIngested polls every org's stats until no document is pending or
+// processing, and returns the final counts.
+func waitIngested(ctx context.Context, c *client, tenants []seedOrg, timeout time.Duration) (ready, failed, pending int) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tick := time.NewTicker(2 * time.Second)
+	defer tick.Stop()
+	for {
+		ready, failed, pending = 0, 0, 0
+		for _, o := range tenants {
+			var st struct {
+				ByStatus map[string]int `json:"by_status"`
+			}
+			if err := c.do(ctx, http.MethodGet, "/api/v1/stats", o.Token, nil, &st); err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				slog.Warn("failed to read stats", "org_id", o.ID, "error", err)
+				continue
+			}
+			for status, n := range st.ByStatus {
+				switch status {
+				case "ready":
+					ready += n
+				case "failed":
+					failed += n
+				default:
+					pending += n
+				}
+			}
+		}
+		if pending == 0 || ctx.Err() != nil {
+			return ready, failed, pending
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ready, failed, pending
+		}
+	}
+}
+
+// queryLoad runs concurrency workers, each sending one query at a time to
+// a random org about one of its topics, until duration has passed.
+func queryLoad(ctx context.Context, c *client, gen *generator, tenants []seedOrg, concurrency int, duration time.Duration, topK int) *latencies {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	rec := &recorder{}
+	var sent atomic.Int64
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := gen.worker(w)
+			for ctx.Err() == nil {
+				o := tenants[rng.IntN(len(tenants))]
+				question := gen.question(rng, o.Topics)
+				begin := time.Now()
+				err := c.do(ctx, http.MethodPost, "/api/v1/query/sync", o.Token, map[string]any{
+					"question": question,
+					"top_k":    topK,
+				}, nil)
+				if ctx.Err() != nil {
+					return // cut off by the end of the run, not the server
+				}
+				rec.add(time.Since(begin), err)
+				if n := sent.Add(1); n%1000 == 0 {
+					slog.Info("queries sent", "count", n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return rec.summary(time.Since(start))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/rand/v2"
+	"strings"
+)
+
+// topicWords is how many words make up one topic's vocabulary.
+const topicWords = 60
+
+// topicsPerOrg is how many topics one org's documents cover.
+const topicsPerOrg = 4
+
+var syllables = []string{
+	"ka", "lo", "mi", "ne", "ru", "sa", "ti", "vo", "zen", "dra", "pli", "quo",
+	"ber", "cor", "fen", "gal", "hex", "jun", "mar", "nor", "pax", "ros", "tul", "vex",
+}
+
+// fillers keep sentences from being bare lists of topic words.
+var fillers = []string{
+	"the", "a", "of", "and", "to", "in", "is", "for", "with", "on", "that", "by",
+	"this", "from", "when", "each", "uses", "requires", "supports", "returns",
+}
+
+// generator produces deterministic synthetic text: documents mostly use
+// the words of their org's topics, questions pick words from one of them.
+type generator struct {
+	seed   uint64
+	rng    *rand.Rand
+	topics [][]string
+}
+
+func newGenerator(seed uint64, topics int) *generator {
+	g := &generator{seed: seed, rng: rand.New(rand.NewPCG(seed, 0))}
+	seen := map[string]bool{}
+	g.topics = make([][]string, topics)
+	for t := range g.topics {
+		for len(g.topics[t]) < topicWords {
+			var w strings.Builder
+			for range 2 + g.rng.IntN(3) {
+				w.WriteString(syllables[g.rng.IntN(len(syllables))])
+			}
+			if !seen[w.String()] {
+				seen[w.String()] = true
+				g.topics[t] = append(g.topics[t], w.String())
+			}
+		}
+	}
+	return g
+}
+
+// worker returns a random source for one query worker.
+func (g *generator) worker(i int) *rand.Rand {
+	return rand.New(rand.NewPCG(g.seed, uint64(i)+1))
+}
+
+// topicsFor picks the topics of the i-th org.
+func (g *generator) topicsFor(i int) []int {
+	ts := make([]int, topicsPerOrg)
+	for k := range ts {
+		ts[k] = (i*topicsPerOrg + k) % len(g.topics)
+	}
+	return ts
+}
+
+func (g *generator) password() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(g.rng.Uint32())
+	}
+	return hex.EncodeToString(b)
+}
+
+// document writes about words words, in paragraphs, on one of topics.
+func (g *generator) document(topics []int, words int) string {
+	vocab := g.topics[topics[g.rng.IntN(len(topics))]]
+	var b strings.Builder
+	for n := 0; n < words; {
+		sentence := 6 + g.rng.IntN(12)
+		for k := range sentence {
+			w := fillers[g.rng.IntN(len(fillers))]
+			if g.rng.IntN(10) < 6 {
+				w = vocab[g.rng.IntN(len(vocab))]
+			}
+			if k == 0 {
+				w = strings.ToUpper(w[:1]) + w[1:]
+			} else {
+				b.WriteByte(' ')
+			}
+			b.WriteString(w)
+		}
+		b.WriteString(". ")
+		n += sentence
+		if g.rng.IntN(6) == 0 {
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
+
+// question asks about a few words of one of topics.
+func (g *generator) question(rng *rand.Rand, topics []int) string {
+	vocab := g.topics[topics[rng.IntN(len(topics))]]
+	words := make([]string, 3+rng.IntN(4))
+	for i := range words {
+		words[i] = vocab[rng.IntN(len(vocab))]
+	}
+	return "How does " + strings.Join(words, " ") + " work?"
+}
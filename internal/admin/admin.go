@@ -0,0 +1,394 @@
+// Package admin is the platform administration surface used by
+// super-admins: every org with its size and activity, suspension and
+// reactivation, and user impersonation for support. Each action is
+// recorded in an audit log, as is every change made while impersonating.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+)
+
+var (
+	ErrNotFound = errors.New("organization not found")
+	ErrInvalid  = errors.New("invalid admin request")
+	// ErrConflict is returned for suspending a suspended org, or
+	// reactivating an active one.
+	ErrConflict = errors.New("organization is already in that state")
+)
+
+// Action names an audit log entry.
+type Action string
+
+const (
+	ActionSuspend          Action = "org.suspend"
+	ActionReactivate       Action = "org.reactivate"
+	ActionImpersonate      Action = "user.impersonate"
+	ActionImpersonatedCall Action = "user.impersonated_request"
+	ActionGrantSuperAdmin  Action = "super_admin.grant"
+	ActionRevokeSuperAdmin Action = "super_admin.revoke"
+)
+
+// OperatorActor is the actor of entries made with the operator token.
+const OperatorActor = "operator"
+
+const (
+	maxOrgsPage  = 200
+	maxAuditPage = 200
+	maxReason    = 1000
+)
+
+// Org is an org as super-admins see it: its state, how many users it has
+// and its activity in the current month.
+type Org struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	SuspendedAt     *time.Time `json:"suspended_at,omitempty"`
+	SuspendedReason string     `json:"suspended_reason,omitempty"`
+	// Users counts its users, deactivated ones included.
+	Users    int     `json:"users"`
+	Queries  int64   `json:"queries"`
+	SpendUSD float64 `json:"spend_usd"`
+}
+
+// ListOrgsRequest filters the org list. Query matches the name or ID.
+type ListOrgsRequest struct {
+	Query     string
+	Suspended *bool
+	Limit     int
+	Offset    int
+}
+
+// Entry is an audit log entry.
+type Entry struct {
+	ID           int64           `json:"id"`
+	ActorID      string          `json:"actor_id"`
+	Action       Action          `json:"action"`
+	OrgID        string          `json:"org_id,omitempty"`
+	TargetUserID string          `json:"target_user_id,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows the audit log; empty fields match everything.
+type AuditFilter struct {
+	OrgID   string
+	ActorID string
+	Action  Action
+	Limit   int
+	Offset  int
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx}
+}
+
+// orgColumns is the SELECT list scanOrg expects, over organizations o.
+const orgColumns = `o.id, o.name, o.created_at, o.suspended_at, o.suspended_reason,
+	(SELECT count(*) FROM users u WHERE u.org_id = o.id),
+	COALESCE(c.queries, 0), COALESCE(c.spend_usd, 0)`
+
+// orgFrom joins the current month's usage counters.
+const orgFrom = `organizations o
+	LEFT JOIN usage_counters c ON c.org_id = o.id AND c.period = date_trunc('month', NOW() AT TIME ZONE 'UTC')::date`
+
+func scanOrg(row pgx.Row) (*Org, error) {
+	o := &Org{}
+	err := row.Scan(&o.ID, &o.Name, &o.CreatedAt, &o.SuspendedAt, &o.SuspendedReason, &o.Users, &o.Queries, &o.SpendUSD)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return o, err
+}
+
+func (r *Repository) ListOrgs(ctx context.Context, req ListOrgsRequest) ([]*Org, int, error) {
+	where := []string{"TRUE"}
+	var args []any
+	if req.Query != "" {
+		args = append(args, "%"+escapeLike(req.Query)+"%")
+		where = append(where, fmt.Sprintf("(o.name ILIKE $%d OR o.id ILIKE $%[1]d)", len(args)))
+	}
+	if req.Suspended != nil {
+		args = append(args, *req.Suspended)
+		where = append(where, fmt.Sprintf("(o.suspended_at IS NOT NULL) = $%d", len(args)))
+	}
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRow(ctx, `SELECT count(*) FROM organizations o WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, req.Limit, req.Offset)
+	rows, err := r.db.Query(ctx,
+		`SELECT `+orgColumns+` FROM `+orgFrom+` WHERE `+cond+
+			fmt.Sprintf(` ORDER BY o.created_at DESC, o.id LIMIT $%d OFFSET $%d`, len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	orgs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Org, error) {
+		return scanOrg(row)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return orgs, total, nil
+}
+
+func (r *Repository) GetOrg(ctx context.Context, id string) (*Org, error) {
+	return scanOrg(r.db.QueryRow(ctx, `SELECT `+orgColumns+` FROM `+orgFrom+` WHERE o.id = $1`, id))
+}
+
+// setSuspended suspends an active org, or reactivates a suspended one.
+func (r *Repository) setSuspended(ctx context.Context, id string, suspend bool, reason string) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE organizations SET
+			 suspended_at = CASE WHEN $2 THEN NOW() END,
+			 suspended_reason = CASE WHEN $2 THEN $3 ELSE '' END
+		 WHERE id = $1 AND (suspended_at IS NOT NULL) <> $2`,
+		id, suspend, reason)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *Repository) Record(ctx context.Context, e *Entry) error {
+	details := e.Details
+	if details == nil {
+		details = json.RawMessage(`{}`)
+	}
+	return r.db.QueryRow(ctx,
+		`INSERT INTO admin_audit_log (actor_id, action, org_id, target_user_id, reason, details)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		e.ActorID, e.Action, e.OrgID, e.TargetUserID, e.Reason, details,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+func (r *Repository) ListAudit(ctx context.Context, f AuditFilter) ([]*Entry, int, error) {
+	const cond = `($1 = '' OR org_id = $1) AND ($2 = '' OR actor_id = $2) AND ($3 = '' OR action = $3)`
+	var total int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM admin_audit_log WHERE `+cond, f.OrgID, f.ActorID, f.Action,
+	).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT id, actor_id, action, org_id, target_user_id, reason, details, created_at
+		 FROM admin_audit_log WHERE `+cond+`
+		 ORDER BY id DESC LIMIT $4 OFFSET $5`,
+		f.OrgID, f.ActorID, f.Action, f.Limit, f.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Entry, error) {
+		e := &Entry{}
+		err := row.Scan(&e.ID, &e.ActorID, &e.Action, &e.OrgID, &e.TargetUserID, &e.Reason, &e.Details, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type Service struct {
+	repo    *Repository
+	uow     *database.UnitOfWork
+	tenants *tenant.Service
+}
+
+func NewService(repo *Repository, uow *database.UnitOfWork, tenants *tenant.Service) *Service {
+	return &Service{repo: repo, uow: uow, tenants: tenants}
+}
+
+// platform makes ctx act outside any org, as the server's own role, so
+// row-level security doesn't hide other orgs' users.
+func platform(ctx context.Context) context.Context {
+	return tenancy.WithOrg(ctx, "")
+}
+
+func (s *Service) ListOrgs(ctx context.Context, req ListOrgsRequest) ([]*Org, int, error) {
+	if req.Limit <= 0 || req.Limit > maxOrgsPage {
+		req.Limit = maxOrgsPage
+	}
+	req.Offset = max(req.Offset, 0)
+	return s.repo.ListOrgs(platform(ctx), req)
+}
+
+func (s *Service) GetOrg(ctx context.Context, id string) (*Org, error) {
+	return s.repo.GetOrg(platform(ctx), id)
+}
+
+// Suspend suspends an org: its users' tokens and logins, API keys and
+// public sites are refused until it is reactivated. Its data is kept.
+func (s *Service) Suspend(ctx context.Context, actorID, orgID, reason string) (*Org, error) {
+	reason, err := checkReason(reason)
+	if err != nil {
+		return nil, err
+	}
+	return s.setSuspended(platform(ctx), actorID, orgID, true, reason)
+}
+
+func (s *Service) Reactivate(ctx context.Context, actorID, orgID, reason string) (*Org, error) {
+	reason, err := checkReason(reason)
+	if err != nil {
+		return nil, err
+	}
+	return s.setSuspended(platform(ctx), actorID, orgID, false, reason)
+}
+
+func (s *Service) setSuspended(ctx context.Context, actorID, orgID string, suspend bool, reason string) (*Org, error) {
+	action := ActionReactivate
+	if suspend {
+		action = ActionSuspend
+	}
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.setSuspended(ctx, orgID, suspend, reason); err != nil {
+			return err
+		}
+		return repo.Record(ctx, &Entry{ActorID: actorID, Action: action, OrgID: orgID, Reason: reason})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetOrg(ctx, orgID)
+}
+
+type ImpersonateRequest struct {
+	// UserID defaults to the org's longest-standing active admin.
+	UserID     string `json:"user_id"`
+	Reason     string `json:"reason"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+// Impersonate issues actorID a short-lived token acting as a user of
+// orgID, after recording why.
+func (s *Service) Impersonate(ctx context.Context, actorID, orgID string, req ImpersonateRequest) (*tenant.Impersonation, error) {
+	reason, err := checkReason(req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	if req.TTLSeconds < 0 {
+		return nil, fmt.Errorf("%w: ttl_seconds can't be negative", ErrInvalid)
+	}
+	ctx = platform(ctx)
+	if _, err := s.repo.GetOrg(ctx, orgID); err != nil {
+		return nil, err
+	}
+
+	imp, err := s.tenants.Impersonate(ctx, orgID, req.UserID, actorID, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	details, _ := json.Marshal(map[string]any{"expires_at": imp.ExpiresAt})
+	if err := s.repo.Record(ctx, &Entry{
+		ActorID:      actorID,
+		Action:       ActionImpersonate,
+		OrgID:        orgID,
+		TargetUserID: imp.User.ID,
+		Reason:       reason,
+		Details:      details,
+	}); err != nil {
+		// A token nobody can account for isn't handed out.
+		return nil, err
+	}
+	return imp, nil
+}
+
+// RecordImpersonated records a request made with an impersonation token.
+func (s *Service) RecordImpersonated(ctx context.Context, claims *auth.Claims, method, path string) error {
+	details, _ := json.Marshal(map[string]string{"method": method, "path": path})
+	return s.repo.Record(platform(ctx), &Entry{
+		ActorID:      claims.Impersonator,
+		Action:       ActionImpersonatedCall,
+		OrgID:        claims.OrgID,
+		TargetUserID: claims.UserID,
+		Details:      details,
+	})
+}
+
+// SetSuperAdmin grants or revokes a user's super-admin flag on the
+// operator's behalf.
+func (s *Service) SetSuperAdmin(ctx context.Context, userID string, on bool) (*tenant.User, error) {
+	ctx = platform(ctx)
+	user, err := s.tenants.SetSuperAdmin(ctx, userID, on)
+	if err != nil {
+		return nil, err
+	}
+	action := ActionRevokeSuperAdmin
+	if on {
+		action = ActionGrantSuperAdmin
+	}
+	if err := s.repo.Record(ctx, &Entry{
+		ActorID:      OperatorActor,
+		Action:       action,
+		OrgID:        user.OrgID,
+		TargetUserID: user.ID,
+	}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) ListSuperAdmins(ctx context.Context) ([]*tenant.User, error) {
+	return s.tenants.ListSuperAdmins(platform(ctx))
+}
+
+func (s *Service) Audit(ctx context.Context, f AuditFilter) ([]*Entry, int, error) {
+	if f.Limit <= 0 || f.Limit > maxAuditPage {
+		f.Limit = maxAuditPage
+	}
+	f.Offset = max(f.Offset, 0)
+	return s.repo.ListAudit(platform(ctx), f)
+}
+
+// checkReason requires a reason for the audit log.
+func checkReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	switch {
+	case reason == "":
+		return "", fmt.Errorf("%w: reason is required", ErrInvalid)
+	case len(reason) > maxReason:
+		return "", fmt.Errorf("%w: reason is longer than %d bytes", ErrInvalid, maxReason)
+	}
+	return reason, nil
+}
@@ -0,0 +1,198 @@
+// Package analytics sends anonymized product usage events to PostHog,
+// PostHog Cloud or a self-hosted instance, so hosted deployments learn
+// which features orgs adopt. It is opt-in: without a project key nothing
+// is built, and a deployment can switch it off for good (see the server's
+// ANALYTICS_DISABLED), whatever else is configured.
+//
+// Events name features, never content: an org appears only as a keyed
+// hash of its ID, and nothing about its users, documents or questions is
+// sent. Delivery is best effort; events are dropped rather than slowing
+// requests down when PostHog can't keep up.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHost is PostHog Cloud's US ingestion host.
+const DefaultHost = "https://us.i.posthog.com"
+
+const (
+	// queueSize events wait for the next flush; more are dropped.
+	queueSize = 10000
+	// maxBatch events go in one request.
+	maxBatch    = 500
+	sendTimeout = 10 * time.Second
+	// library identifies this server as the events' source.
+	library = "multi-tenant-ai"
+)
+
+var ErrInvalidConfig = errors.New("invalid analytics config")
+
+type Config struct {
+	// Host is the PostHog instance's base URL; DefaultHost when empty.
+	Host string
+	// APIKey is the PostHog project API key.
+	APIKey string
+	// Salt keys the hash that stands in for org IDs. It must stay the
+	// same for an org to keep its identity across restarts.
+	Salt []byte
+	// HTTPClient defaults to one with a 10s timeout.
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+type event struct {
+	Event      string         `json:"event"`
+	DistinctID string         `json:"distinct_id"`
+	Properties map[string]any `json:"properties"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
+
+// Client queues events and sends them in batches from Run. A nil *Client
+// tracks nothing.
+type Client struct {
+	cfg      Config
+	endpoint string
+	queue    chan event
+	dropped  atomic.Int64
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("%w: a PostHog project API key is required", ErrInvalidConfig)
+	}
+	if len(cfg.Salt) == 0 {
+		return nil, fmt.Errorf("%w: a salt is required to anonymize orgs", ErrInvalidConfig)
+	}
+	if cfg.Host == "" {
+		cfg.Host = DefaultHost
+	}
+	u, err := url.Parse(cfg.Host)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: host must be an http(s) URL", ErrInvalidConfig)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: sendTimeout}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Client{
+		cfg:      cfg,
+		endpoint: strings.TrimRight(cfg.Host, "/") + "/batch/",
+		queue:    make(chan event, queueSize),
+	}, nil
+}
+
+// OrgID is the anonymous ID that stands in for orgID in events.
+func (c *Client) OrgID(orgID string) string {
+	mac := hmac.New(sha256.New, c.cfg.Salt)
+	mac.Write([]byte("org:" + orgID))
+	return "org_" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Track queues an event of orgID's use of a feature. props must not hold
+// identifiers or content: feature names, modes and outcomes only.
+func (c *Client) Track(orgID, name string, props map[string]any) {
+	if c == nil {
+		return
+	}
+	properties := map[string]any{
+		"$lib": library,
+		// Events aren't tied to people, and the sender's address (ours)
+		// says nothing about where the org is.
+		"$process_person_profile": false,
+		"$geoip_disable":          true,
+	}
+	for k, v := range props {
+		properties[k] = v
+	}
+	e := event{Event: name, DistinctID: c.OrgID(orgID), Properties: properties, Timestamp: time.Now().UTC()}
+	select {
+	case c.queue <- e:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Run sends queued events every interval, and sooner when a batch fills
+// up, until ctx is done; what is queued then is sent once more.
+func (c *Client) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]event, 0, maxBatch)
+	flush := func(ctx context.Context) {
+		if n := c.dropped.Swap(0); n > 0 {
+			c.cfg.Logger.Warn("analytics events dropped, queue full", "dropped", n)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := c.send(ctx, batch); err != nil {
+			c.cfg.Logger.Warn("analytics events not delivered", "events", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// Send what was queued before the shutdown.
+			ctx := context.WithoutCancel(ctx)
+			for n := len(c.queue); n > 0; n-- {
+				batch = append(batch, <-c.queue)
+				if len(batch) == maxBatch {
+					flush(ctx)
+				}
+			}
+			flush(ctx)
+			return
+		case e := <-c.queue:
+			batch = append(batch, e)
+			if len(batch) == maxBatch {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, batch []event) error {
+	body, err := json.Marshal(map[string]any{"api_key": c.cfg.APIKey, "batch": batch})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("posthog returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/pixell07/multi-tenant-ai/internal/admin"
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+)
+
+// Platform administration handlers (super-admin only), and the operator
+// routes that grant the role. Every change is recorded in the audit log.
+
+// requireSuperAdmin writes a 403 unless the caller is a super-admin. The
+// access policy can't grant the role.
+func requireSuperAdmin(w http.ResponseWriter, claims *auth.Claims) bool {
+	if !claims.SuperAdmin {
+		writeError(w, http.StatusForbidden, "super-admin role required")
+		return false
+	}
+	return true
+}
+
+// listAdminOrgs pages through every org, newest first. ?q= matches the
+// name or ID, ?suspended=true|false filters by state.
+func (h *handlers) listAdminOrgs(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	q := r.URL.Query()
+	req := admin.ListOrgsRequest{Query: q.Get("q")}
+	var err error
+	if req.Limit, err = queryInt(q, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Offset, err = queryInt(q, "offset"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if raw := q.Get("suspended"); raw != "" {
+		suspended, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "suspended must be true or false")
+			return
+		}
+		req.Suspended = &suspended
+	}
+
+	orgs, total, err := h.deps.AdminService.ListOrgs(r.Context(), req)
+	if err != nil {
+		h.deps.Logger.Error("list orgs failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list organizations")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orgs": orgs, "count": len(orgs), "total": total})
+}
+
+func (h *handlers) getAdminOrg(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	org, err := h.deps.AdminService.GetOrg(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, err, "failed to load organization")
+		return
+	}
+	writeJSON(w, http.StatusOK, org)
+}
+
+// getAdminOrgUsage reports an org's usage in the current month, with its
+// quota and budget, and its credits.
+func (h *handlers) getAdminOrgUsage(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	orgID := r.PathValue("id")
+	if _, err := h.deps.AdminService.GetOrg(r.Context(), orgID); err != nil {
+		writeAdminError(w, err, "failed to load organization")
+		return
+	}
+	u, err := h.deps.UsageService.Usage(r.Context(), orgID)
+	if err != nil {
+		h.deps.Logger.Error("load usage failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load usage")
+		return
+	}
+	c, err := h.deps.UsageService.Credits(r.Context(), orgID)
+	if err != nil {
+		h.deps.Logger.Error("load credits failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load credits")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"usage": u, "credits": c})
+}
+
+type adminReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+// suspendOrg suspends an org. Body: {"reason"}.
+func (h *handlers) suspendOrg(w http.ResponseWriter, r *http.Request) {
+	h.setOrgSuspended(w, r, true)
+}
+
+// reactivateOrg lifts an org's suspension. Body: {"reason"}.
+func (h *handlers) reactivateOrg(w http.ResponseWriter, r *http.Request) {
+	h.setOrgSuspended(w, r, false)
+}
+
+func (h *handlers) setOrgSuspended(w http.ResponseWriter, r *http.Request, suspend bool) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	var req adminReasonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	orgID := r.PathValue("id")
+	var (
+		org *admin.Org
+		err error
+	)
+	if suspend {
+		org, err = h.deps.AdminService.Suspend(r.Context(), claims.UserID, orgID, req.Reason)
+	} else {
+		org, err = h.deps.AdminService.Reactivate(r.Context(), claims.UserID, orgID, req.Reason)
+	}
+	if err != nil {
+		writeAdminError(w, err, "failed to change organization state")
+		return
+	}
+	h.deps.Logger.Info("organization state changed", "org_id", orgID, "suspended", suspend, "by", claims.UserID)
+	writeJSON(w, http.StatusOK, org)
+}
+
+// impersonateUser issues the caller a short-lived access token of a user
+// of the org. Body: {"user_id", "reason", "ttl_seconds"}; user_id defaults
+// to the org's longest-standing active admin.
+func (h *handlers) impersonateUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	var req admin.ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	orgID := r.PathValue("id")
+	imp, err := h.deps.AdminService.Impersonate(r.Context(), claims.UserID, orgID, req)
+	if err != nil {
+		writeAdminError(w, err, "failed to impersonate user")
+		return
+	}
+	h.deps.Logger.Info("impersonation started", "org_id", orgID, "user_id", imp.User.ID, "by", claims.UserID)
+	writeJSON(w, http.StatusCreated, imp)
+}
+
+// listAdminAudit pages through the audit log, newest first, filtered by
+// ?org_id=, ?actor_id= and ?action=.
+func (h *handlers) listAdminAudit(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireSuperAdmin(w, claims) {
+		return
+	}
+
+	q := r.URL.Query()
+	f := admin.AuditFilter{
+		OrgID:   q.Get("org_id"),
+		ActorID: q.Get("actor_id"),
+		Action:  admin.Action(q.Get("action")),
+	}
+	var err error
+	if f.Limit, err = queryInt(q, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f.Offset, err = queryInt(q, "offset"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := h.deps.AdminService.Audit(r.Context(), f)
+	if err != nil {
+		h.deps.Logger.Error("list audit log failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list audit log")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries, "count": len(entries), "total": total})
+}
+
+// auditImpersonated records a request made with an impersonation token,
+// reads included, before it is handled, refusing the request when it
+// can't be recorded.
+func (h *handlers) auditImpersonated(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if err := h.deps.AdminService.RecordImpersonated(r.Context(), claims, r.Method, r.URL.Path); err != nil {
+		h.deps.Logger.Error("record impersonated request failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to record impersonated request")
+		return false
+	}
+	return true
+}
+
+// Operator routes
+
+func (h *handlers) listSuperAdmins(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	users, err := h.deps.AdminService.ListSuperAdmins(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list super-admins")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": users, "count": len(users)})
+}
+
+// grantSuperAdmin makes a user a super-admin. Their current tokens stop
+// working; the next login carries the role.
+func (h *handlers) grantSuperAdmin(w http.ResponseWriter, r *http.Request) {
+	h.setSuperAdmin(w, r, true)
+}
+
+func (h *handlers) revokeSuperAdmin(w http.ResponseWriter, r *http.Request) {
+	h.setSuperAdmin(w, r, false)
+}
+
+func (h *handlers) setSuperAdmin(w http.ResponseWriter, r *http.Request, on bool) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	userID := r.PathValue("user_id")
+	user, err := h.deps.AdminService.SetSuperAdmin(r.Context(), userID, on)
+	if err != nil {
+		writeAdminError(w, err, "failed to change super-admin role")
+		return
+	}
+	h.deps.Logger.Info("super-admin role changed", "user_id", userID, "super_admin", on)
+	writeJSON(w, http.StatusOK, user)
+}
+
+func writeAdminError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, admin.ErrNotFound), errors.Is(err, tenant.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, admin.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, admin.ErrConflict), errors.Is(err, tenant.ErrUserDeactivated),
+		errors.Is(err, tenant.ErrNoImpersonationTarget):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// withAnalytics reports each successful authenticated request to product
+// analytics as a feature its org used. Only the route that matched in
+// routes is sent, such as "POST /api/v1/documents", never the path or
+// query, so no IDs or content leave. Requests made while impersonating
+// are support, not the org's usage, and aren't reported.
+func (h *handlers) withAnalytics(routes *http.ServeMux, next http.Handler) http.Handler {
+	if h.deps.Analytics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := routes.Handler(r)
+		claims := claimsFromCtx(r.Context())
+		if pattern == "" || claims.Impersonator != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		if rw.status >= http.StatusBadRequest {
+			return
+		}
+		caller := "user"
+		if claims.APIKeyID != "" {
+			caller = "api_key"
+		}
+		h.deps.Analytics.Track(claims.OrgID, "feature_used", map[string]any{
+			"route":  strings.Join(strings.Fields(pattern), " "),
+			"caller": caller,
+			"role":   claims.Role,
+		})
+	})
+}
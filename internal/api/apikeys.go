@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/apikey"
+)
+
+// API key management (admin only)
+
+func (h *handlers) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	keys, err := h.deps.APIKeyService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"api_keys": keys, "count": len(keys)})
+}
+
+// createAPIKey mints a key. The plaintext is in this response only.
+// Keys can't mint keys: a leaked key must not be able to outlive its
+// revocation by minting successors.
+func (h *handlers) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "api keys cannot be minted with an api key")
+		return
+	}
+
+	var req apikey.MintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	resp, err := h.deps.APIKeyService.Mint(r.Context(), claims.OrgID, claims.Actor(), req)
+	switch {
+	case errors.Is(err, apikey.ErrInvalidRole):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to create api key")
+	default:
+		writeJSON(w, http.StatusCreated, resp)
+	}
+}
+
+func (h *handlers) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.APIKeyService.Revoke(r.Context(), r.PathValue("id"), claims.OrgID)
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to revoke api key")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/assistant"
+	"github.com/pixell07/multi-tenant-ai/internal/conversation"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+// Assistant profile handlers
+
+func (h *handlers) listAssistants(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	list, err := h.deps.AssistantService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list assistants")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"assistants": list, "count": len(list)})
+}
+
+func (h *handlers) getAssistant(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	a, err := h.deps.AssistantService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeAssistantError(w, err, "failed to get assistant")
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *handlers) createAssistant(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var in assistant.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !h.checkModel(w, in.Model) {
+		return
+	}
+
+	a, err := h.deps.AssistantService.Create(r.Context(), claims.OrgID, in)
+	if err != nil {
+		writeAssistantError(w, err, "failed to create assistant")
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+func (h *handlers) updateAssistant(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var in assistant.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !h.checkModel(w, in.Model) {
+		return
+	}
+
+	a, err := h.deps.AssistantService.Update(r.Context(), r.PathValue("id"), claims.OrgID, in)
+	if err != nil {
+		writeAssistantError(w, err, "failed to update assistant")
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *handlers) deleteAssistant(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	if err := h.deps.AssistantService.Delete(r.Context(), r.PathValue("id"), claims.OrgID); err != nil {
+		writeAssistantError(w, err, "failed to delete assistant")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// assistantQuery streams (SSE) an answer from a specific assistant;
+// assistantQuerySync is its one-shot JSON counterpart.
+func (h *handlers) assistantQuery(w http.ResponseWriter, r *http.Request) {
+	req, conv, ok := h.assistantQueryRequest(w, r)
+	if !ok {
+		return
+	}
+	h.streamQuery(w, r, req, conv)
+}
+
+func (h *handlers) assistantQuerySync(w http.ResponseWriter, r *http.Request) {
+	req, conv, ok := h.assistantQueryRequest(w, r)
+	if !ok {
+		return
+	}
+	h.answerQuery(w, r, req, conv)
+}
+
+func (h *handlers) assistantQueryRequest(w http.ResponseWriter, r *http.Request) (retrieval.QueryRequest, *conversation.Conversation, bool) {
+	claims := claimsFromCtx(r.Context())
+
+	a, err := h.deps.AssistantService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeAssistantError(w, err, "failed to load assistant")
+		return retrieval.QueryRequest{}, nil, false
+	}
+
+	var body queryBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return retrieval.QueryRequest{}, nil, false
+	}
+	req, ok := h.queryRequest(w, r, &body)
+	if !ok {
+		return retrieval.QueryRequest{}, nil, false
+	}
+	a.Apply(&req)
+	if body.Model != "" {
+		req.Model = body.Model
+	}
+
+	conv, ok := h.loadHistory(w, r, body.ConversationID, &req)
+	if !ok {
+		return retrieval.QueryRequest{}, nil, false
+	}
+	return req, conv, true
+}
+
+func writeAssistantError(w http.ResponseWriter, err error, fallbackMsg string) {
+	switch {
+	case errors.Is(err, assistant.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, assistant.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, assistant.ErrDuplicateName):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallbackMsg)
+	}
+}
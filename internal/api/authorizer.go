@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+	"github.com/pixell07/multi-tenant-ai/internal/authorizer"
+)
+
+// Authorization webhook handlers (admin only). The webhook is the org's own
+// policy engine, asked before sensitive operations (see authorize).
+
+func (h *handlers) getAuthorizer(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	wh, err := h.deps.AuthorizerService.Get(r.Context(), claims.OrgID)
+	if err != nil {
+		writeAuthorizerError(w, err, "failed to load authorization webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// setAuthorizer registers or changes the webhook. The signing secret is in
+// the response only when it was generated.
+func (h *handlers) setAuthorizer(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req authorizer.SetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	resp, err := h.deps.AuthorizerService.Set(r.Context(), claims.OrgID, req)
+	if err != nil {
+		writeAuthorizerError(w, err, "failed to save authorization webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *handlers) deleteAuthorizer(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	if err := h.deps.AuthorizerService.Delete(r.Context(), claims.OrgID); err != nil {
+		writeAuthorizerError(w, err, "failed to delete authorization webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize asks the org's authorization webhook, if it has one, whether
+// the caller may perform action on the resource. It writes the error
+// response and returns false when not.
+func (h *handlers) authorize(w http.ResponseWriter, r *http.Request, claims *auth.Claims, action authorizer.Action, resource authorizer.Resource, attrs map[string]string) bool {
+	if h.deps.AuthorizerService == nil {
+		return true
+	}
+	err := h.deps.AuthorizerService.Authorize(r.Context(), authorizer.Check{
+		OrgID:      claims.OrgID,
+		UserID:     claims.UserID,
+		APIKeyID:   claims.APIKeyID,
+		Role:       claims.Role,
+		Action:     action,
+		Resource:   resource,
+		Attributes: attrs,
+	})
+	if err != nil {
+		writeAuthorizerError(w, err, "failed to authorize request")
+		return false
+	}
+	return true
+}
+
+func writeAuthorizerError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, authorizer.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, authorizer.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, authorizer.ErrDenied), errors.Is(err, authorizer.ErrPolicyDenied):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, authorizer.ErrUnavailable), errors.Is(err, authorizer.ErrPolicyUnavailable):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// Billing handlers: monthly usage statements and who they are mailed to.
+// Admin only, like budgets.
+
+func (h *handlers) listStatements(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	list, err := h.deps.UsageService.Statements(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list statements")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"statements": list, "count": len(list)})
+}
+
+// getStatement returns the statement of {period} (YYYY-MM) as JSON, or as
+// a PDF download with ?format=pdf. The current month is a preview.
+func (h *handlers) getStatement(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "pdf" {
+		writeError(w, http.StatusBadRequest, `format must be "json" or "pdf"`)
+		return
+	}
+	p, err := usage.ParsePeriod(r.PathValue("period"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	st, err := h.deps.UsageService.Statement(r.Context(), claims.OrgID, p)
+	switch {
+	case errors.Is(err, usage.ErrNoStatement):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		h.deps.Logger.Error("load statement failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load statement")
+		return
+	}
+	if format != "pdf" {
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="statement-`+r.PathValue("period")+`.pdf"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(st.PDF())
+}
+
+func (h *handlers) getBillingContacts(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	emails, err := h.deps.UsageService.BillingContacts(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load billing contacts")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"emails": emails})
+}
+
+// setBillingContacts replaces the addresses statements are mailed to;
+// an empty list mails the org's admins.
+func (h *handlers) setBillingContacts(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var body struct {
+		Emails []string `json:"emails"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	emails, err := h.deps.UsageService.SetBillingContacts(r.Context(), claims.OrgID, body.Emails)
+	switch {
+	case errors.Is(err, usage.ErrInvalidContacts):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to set billing contacts")
+	default:
+		h.deps.Logger.Info("billing contacts set", "org_id", claims.OrgID, "count", len(emails), "actor", claims.Actor())
+		writeJSON(w, http.StatusOK, map[string]any{"emails": emails})
+	}
+}
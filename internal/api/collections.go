@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/collection"
+)
+
+// Collection handlers. Collections organize documents, so like documents
+// any member of the org can manage them.
+
+func (h *handlers) listCollections(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	list, err := h.deps.CollectionService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list collections")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"collections": list, "count": len(list)})
+}
+
+func (h *handlers) createCollection(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	var in collection.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	c, err := h.deps.CollectionService.Create(r.Context(), claims.OrgID, in)
+	if err != nil {
+		writeCollectionError(w, err, "failed to create collection")
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (h *handlers) getCollection(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	c, err := h.deps.CollectionService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeCollectionError(w, err, "failed to get collection")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *handlers) updateCollection(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	var in collection.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	c, err := h.deps.CollectionService.Update(r.Context(), r.PathValue("id"), claims.OrgID, in)
+	if err != nil {
+		writeCollectionError(w, err, "failed to update collection")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// deleteCollection removes a collection but not its documents.
+func (h *handlers) deleteCollection(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	if err := h.deps.CollectionService.Delete(r.Context(), r.PathValue("id"), claims.OrgID); err != nil {
+		writeCollectionError(w, err, "failed to delete collection")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) listCollectionDocuments(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	ids, err := h.deps.CollectionService.DocumentIDs(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeCollectionError(w, err, "failed to list collection documents")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"document_ids": ids, "count": len(ids)})
+}
+
+// addCollectionDocuments adds {"document_ids": [...]} to a collection;
+// documents already in it are skipped.
+func (h *handlers) addCollectionDocuments(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	var body struct {
+		DocumentIDs []string `json:"document_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	c, err := h.deps.CollectionService.AddDocuments(r.Context(), r.PathValue("id"), claims.OrgID, body.DocumentIDs)
+	if err != nil {
+		writeCollectionError(w, err, "failed to add documents")
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *handlers) removeCollectionDocument(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	err := h.deps.CollectionService.RemoveDocument(r.Context(), r.PathValue("id"), claims.OrgID, r.PathValue("document_id"))
+	if err != nil {
+		writeCollectionError(w, err, "failed to remove document")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkCollections answers a query's request itself when it names a
+// collection the caller's org doesn't have.
+func (h *handlers) checkCollections(w http.ResponseWriter, r *http.Request, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	err := h.deps.CollectionService.Check(r.Context(), claimsFromCtx(r.Context()).OrgID, ids)
+	if err != nil {
+		writeCollectionError(w, err, "failed to load collections")
+		return false
+	}
+	return true
+}
+
+func writeCollectionError(w http.ResponseWriter, err error, fallbackMsg string) {
+	switch {
+	case errors.Is(err, collection.ErrNotFound), errors.Is(err, collection.ErrUnknownDocument):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, collection.ErrInvalid), errors.Is(err, collection.ErrNoDocuments):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, collection.ErrDuplicateName):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallbackMsg)
+	}
+}
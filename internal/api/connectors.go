@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pixell07/multi-tenant-ai/internal/connector"
+)
+
+// Connector handlers (admin only). Connector configs hold
+// third-party credentials, so members can't see or change them.
+
+func (h *handlers) listConnectors(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	list, err := h.deps.ConnectorService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list connectors")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"connectors": list, "count": len(list)})
+}
+
+func (h *handlers) createConnector(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req connector.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	c, err := h.deps.ConnectorService.Create(r.Context(), claims.OrgID, req)
+	if err != nil {
+		writeConnectorError(w, err, "failed to create connector")
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (h *handlers) deleteConnector(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	if err := h.deps.ConnectorService.Delete(r.Context(), r.PathValue("id"), claims.OrgID); err != nil {
+		writeConnectorError(w, err, "failed to delete connector")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncConnector runs a sync now instead of waiting for the scheduler.
+func (h *handlers) syncConnector(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	res, err := h.deps.ConnectorService.Sync(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeConnectorError(w, err, "failed to sync connector")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// listConnectorItems shows each synced ticket/issue with its source status
+// and the document holding it.
+func (h *handlers) listConnectorItems(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	items, err := h.deps.ConnectorService.Items(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeConnectorError(w, err, "failed to list connector items")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "count": len(items)})
+}
+
+// startGitHubInstall returns the URL at which the caller installs the
+// GitHub App for their org. The installation is bound to the org when
+// GitHub redirects back to githubInstallCallback.
+func (h *handlers) startGitHubInstall(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	url, err := h.deps.ConnectorService.StartGitHubInstall(r.Context(), claims.OrgID, claims.UserID)
+	if err != nil {
+		writeConnectorError(w, err, "failed to start github install")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"install_url": url})
+}
+
+func (h *handlers) listGitHubInstallations(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	list, err := h.deps.ConnectorService.GitHubInstallations(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list github installations")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"installations": list, "count": len(list)})
+}
+
+// githubInstallCallback is the GitHub App's callback URL. It is public:
+// the single-use state names the org, and GitHub's OAuth code proves the
+// installing user has the installation.
+func (h *handlers) githubInstallCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	installationID, err := strconv.ParseInt(q.Get("installation_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "installation_id must be a number")
+		return
+	}
+
+	inst, err := h.deps.ConnectorService.FinishGitHubInstall(r.Context(), q.Get("state"), q.Get("code"), installationID)
+	if err != nil {
+		writeConnectorError(w, err, "failed to bind github installation")
+		return
+	}
+	writeJSON(w, http.StatusOK, inst)
+}
+
+// maxWebhookBytes caps GitHub webhook bodies; push payloads with many
+// commits run to a few hundred KB.
+const maxWebhookBytes = 5 << 20
+
+// githubWebhook receives GitHub App deliveries. It is public; requests are
+// authenticated by their HMAC signature instead of a JWT.
+func (h *handlers) githubWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+
+	err = h.deps.ConnectorService.GitHubPush(r.Context(),
+		r.Header.Get("X-GitHub-Event"), r.Header.Get("X-Hub-Signature-256"), body)
+	switch {
+	case errors.Is(err, connector.ErrBadSignature):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case err != nil:
+		writeConnectorError(w, err, "failed to handle webhook")
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func writeConnectorError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, connector.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, connector.ErrDuplicateName), errors.Is(err, connector.ErrSyncRunning),
+		errors.Is(err, connector.ErrInstallationTaken):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, connector.ErrInstallationUnverified):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, connector.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/conversation"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+// Conversation handlers. Conversations are private to the user (or API
+// key) that created them, even from admins of the same org.
+
+func (h *handlers) listConversations(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	list, err := h.deps.ConversationService.List(r.Context(), claims.OrgID, claims.Actor())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list conversations")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"conversations": list, "count": len(list)})
+}
+
+// createConversation starts a conversation; the body is optional
+// ({"title": "..."}). Pass the returned id as conversation_id on queries.
+func (h *handlers) createConversation(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	var body struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	c, err := h.deps.ConversationService.Create(r.Context(), claims.OrgID, claims.Actor(), body.Title)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create conversation")
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (h *handlers) listConversationMessages(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	msgs, err := h.deps.ConversationService.Messages(r.Context(), r.PathValue("id"), claims.OrgID, claims.Actor())
+	if errors.Is(err, conversation.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list messages")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"messages": msgs, "count": len(msgs)})
+}
+
+// loadHistory resolves a query's conversation_id (if any) and loads its
+// recent turns into req. It writes the error response itself and reports
+// whether the query can proceed.
+func (h *handlers) loadHistory(w http.ResponseWriter, r *http.Request, id string, req *retrieval.QueryRequest) (*conversation.Conversation, bool) {
+	if id == "" {
+		return nil, true
+	}
+	claims := claimsFromCtx(r.Context())
+
+	c, err := h.deps.ConversationService.Get(r.Context(), id, claims.OrgID, claims.Actor())
+	if errors.Is(err, conversation.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load conversation")
+		return nil, false
+	}
+
+	req.History, err = h.deps.ConversationService.History(r.Context(), c)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load conversation")
+		return nil, false
+	}
+	return c, true
+}
+
+// recordTurn appends an answered question to its conversation. The answer
+// has already been sent, so a failure is only logged; the write outlives a
+// client that disconnects right after the last token.
+func (h *handlers) recordTurn(ctx context.Context, c *conversation.Conversation, question, answer string, askedAt time.Time) {
+	if c == nil || answer == "" {
+		return
+	}
+	if err := h.deps.ConversationService.Record(context.WithoutCancel(ctx), c, question, answer, askedAt); err != nil {
+		h.deps.Logger.Error("failed to record conversation turn", "conversation_id", c.ID, "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// Prepaid credits. The operator grants them, since they are paid for;
+// the org's admins see the balance and the ledger.
+
+// grantCredits gives an org prepaid credits.
+func (h *handlers) grantCredits(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+
+	var in usage.GrantInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	orgID := r.PathValue("id")
+	g, err := h.deps.UsageService.Grant(r.Context(), orgID, in)
+	switch {
+	case errors.Is(err, usage.ErrInvalidGrant):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, usage.ErrOrgNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("grant credits failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to grant credits")
+	default:
+		h.deps.Logger.Info("credits granted", "org_id", orgID, "grant_id", g.ID, "amount_usd", g.AmountUSD)
+		writeJSON(w, http.StatusCreated, g)
+	}
+}
+
+// getCredits returns the caller's org credit balance. Admin only.
+func (h *handlers) getCredits(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	c, err := h.deps.UsageService.Credits(r.Context(), claims.OrgID)
+	switch {
+	case err != nil:
+		h.deps.Logger.Error("load credits failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load credits")
+	case c == nil:
+		writeError(w, http.StatusNotFound, "organization is not prepaid")
+	default:
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// listCreditTransactions pages through the caller's org credit ledger,
+// newest first. Admin only.
+func (h *handlers) listCreditTransactions(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(q, "offset")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	txs, total, err := h.deps.UsageService.Transactions(r.Context(), claims.OrgID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list credit transactions")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"transactions": txs, "count": len(txs), "total": total})
+}
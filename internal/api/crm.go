@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/crm"
+)
+
+// CRM integration handlers (admin only). Integrations hold the org's CRM
+// credentials; agents use them through the get_customer_context MCP tool.
+
+func (h *handlers) listCRMIntegrations(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	list, err := h.deps.CRMService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list crm integrations")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"integrations": list, "count": len(list)})
+}
+
+// setCRMIntegration creates or replaces the credentials for one provider.
+func (h *handlers) setCRMIntegration(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var cfg crm.Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	in, err := h.deps.CRMService.Set(r.Context(), claims.OrgID, crm.Provider(r.PathValue("provider")), cfg)
+	if err != nil {
+		writeCRMError(w, err, "failed to save crm integration")
+		return
+	}
+	writeJSON(w, http.StatusOK, in)
+}
+
+func (h *handlers) deleteCRMIntegration(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.CRMService.Delete(r.Context(), claims.OrgID, crm.Provider(r.PathValue("provider")))
+	if err != nil {
+		writeCRMError(w, err, "failed to delete crm integration")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeCRMError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, crm.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, crm.ErrUnknownProvider), errors.Is(err, crm.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import "github.com/pixell07/multi-tenant-ai/internal/retrieval"
+
+// providerSwitch is a fallback handed from the query goroutine to the loop
+// reading the answer; handled is closed once the loop has dealt with it.
+type providerSwitch struct {
+	retrieval.ProviderSwitch
+	handled chan struct{}
+}
+
+// switchesTo returns an OnProviderSwitch that passes each switch to c and
+// waits until it is handled. The answer's tokens are buffered on their way
+// to the reader, so without the wait the next provider's tokens could
+// overtake the switch, or the switch the previous provider's tokens.
+func switchesTo(c chan<- providerSwitch) func(retrieval.ProviderSwitch) {
+	return func(sw retrieval.ProviderSwitch) {
+		ps := providerSwitch{sw, make(chan struct{})}
+		c <- ps
+		<-ps.handled
+	}
+}
+
+// drainTokens passes the tokens already buffered in out to fn. Called
+// when a switch arrives, it delivers everything the previous provider
+// sent before the switch.
+func drainTokens(out <-chan string, fn func(token string)) {
+	for {
+		select {
+		case token, ok := <-out:
+			if !ok {
+				return
+			}
+			fn(token)
+		default:
+			return
+		}
+	}
+}
+
+func (sw providerSwitch) payload() map[string]any {
+	return map[string]any{"from": sw.From, "to": sw.To, "reason": sw.Reason, "restart": sw.Restart}
+}
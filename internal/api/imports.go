@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/extract"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// Archive imports. POST /api/v1/documents/imports takes a multipart ZIP or
+// TAR (.tar, .tar.gz, .tgz) "file", with the "tags" and "metadata" fields
+// and ?ingest=batch of a regular upload, and creates one document per file
+// in it. GET /api/v1/documents/imports/{id} reports each file's status.
+
+func (h *handlers) importArchive(w http.ResponseWriter, r *http.Request) {
+	req := document.UploadRequest{OrgID: claimsFromCtx(r.Context()).OrgID}
+	switch r.URL.Query().Get("ingest") {
+	case "":
+	case "batch":
+		req.Batch = true
+	default:
+		writeError(w, http.StatusBadRequest, `ingest must be "batch" or omitted`)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "file too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "multipart upload requires a file part")
+		return
+	}
+	defer file.Close()
+	if !extract.IsArchive(header.Filename) {
+		writeError(w, http.StatusUnsupportedMediaType, "file must be a .zip, .tar, .tar.gz or .tgz archive")
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read file")
+		return
+	}
+	if req.Tags, req.Metadata, err = formLabels(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Name = header.Filename
+
+	entries, err := extract.Archive(header.Filename, data)
+	if errors.Is(err, extract.ErrArchiveTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	var files []document.ImportFile
+	var skipped []document.SkippedFile
+	for _, e := range entries {
+		contentType := ""
+		if document.CodeLanguage(e.Path) != "" {
+			contentType = "text/plain"
+		}
+		text, err := extract.Text(e.Path, contentType, e.Data)
+		if err != nil {
+			skipped = append(skipped, document.SkippedFile{Path: e.Path, Reason: err.Error()})
+			continue
+		}
+		files = append(files, document.ImportFile{Path: e.Path, Content: text})
+	}
+	// withinQuota only saw that the org had room for one more document;
+	// the archive needs room for all of them.
+	room := h.deps.UsageService.CheckRoom(r.Context(), req.OrgID, usage.Documents, int64(len(files)))
+	if !h.quotaAllows(w, req.OrgID, room) {
+		return
+	}
+
+	imp, docs, err := h.deps.DocumentService.Import(r.Context(), req, files, skipped)
+	switch {
+	case errors.Is(err, document.ErrEmptyImport):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error(), "skipped": skipped})
+	case errors.Is(err, document.ErrBatchDisabled), errors.Is(err, document.ErrInvalidLabels):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, document.ErrReindexing):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("archive import failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to import archive")
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]any{"import": imp, "documents": docs})
+	}
+}
+
+func (h *handlers) getImport(w http.ResponseWriter, r *http.Request) {
+	st, err := h.deps.DocumentService.GetImport(r.Context(), r.PathValue("id"), claimsFromCtx(r.Context()).OrgID)
+	if errors.Is(err, document.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "import not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get import")
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance mode. The switch is shared by every replica and operated
+// with the deployment's OPERATOR_TOKEN rather than an org login, since it
+// affects every tenant. The routes are not mounted when no token is
+// configured.
+
+type maintenanceStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Message           string     `json:"message,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"`
+	Since             *time.Time `json:"since,omitempty"`
+	// InFlightRequests and PendingIngestion reach zero once the instance
+	// has drained.
+	InFlightRequests int64 `json:"in_flight_requests"`
+	PendingIngestion int64 `json:"pending_ingestion"`
+}
+
+func (h *handlers) maintenanceStatus() maintenanceStatus {
+	state := h.deps.Maintenance.State()
+	st := maintenanceStatus{
+		Enabled:          state.Enabled,
+		Message:          state.Message,
+		InFlightRequests: h.deps.Maintenance.InFlight(),
+		PendingIngestion: h.deps.DocumentService.PendingJobs(),
+	}
+	if state.Enabled {
+		st.RetryAfterSeconds = int(state.RetryAfter.Seconds())
+		st.Since = &state.Since
+	}
+	return st
+}
+
+func (h *handlers) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.maintenanceStatus())
+}
+
+// enableMaintenance turns maintenance on; the body is optional
+// ({"message": "...", "retry_after_seconds": 300}).
+func (h *handlers) enableMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+
+	var body struct {
+		Message           string `json:"message"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.RetryAfterSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "retry_after_seconds must not be negative")
+		return
+	}
+
+	if _, err := h.deps.Maintenance.Enable(r.Context(), body.Message, time.Duration(body.RetryAfterSeconds)*time.Second); err != nil {
+		h.deps.Logger.Error("enable maintenance failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to enable maintenance mode")
+		return
+	}
+	h.deps.Logger.Warn("maintenance mode enabled", "message", body.Message)
+	writeJSON(w, http.StatusOK, h.maintenanceStatus())
+}
+
+func (h *handlers) disableMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	if _, err := h.deps.Maintenance.Disable(r.Context()); err != nil {
+		h.deps.Logger.Error("disable maintenance failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to disable maintenance mode")
+		return
+	}
+	h.deps.Logger.Info("maintenance mode disabled")
+	writeJSON(w, http.StatusOK, h.maintenanceStatus())
+}
+
+// requireOperator checks the operator token (Authorization: Bearer
+// <token>), and the client certificate if OperatorClientCert is set,
+// writing a 401 and returning false if either is missing.
+func (h *handlers) requireOperator(w http.ResponseWriter, r *http.Request) bool {
+	if h.deps.OperatorClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		writeError(w, http.StatusUnauthorized, "client certificate required")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.deps.OperatorToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid operator token")
+		return false
+	}
+	return true
+}
+
+// drainable wraps a handler that starts new work (a query or an upload):
+// during maintenance it is refused with 503 and Retry-After, otherwise it
+// counts as in flight until it returns.
+func (h *handlers) drainable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		done, state, ok := h.deps.Maintenance.Begin()
+		if !ok {
+			msg := state.Message
+			if msg == "" {
+				msg = "service is under maintenance, retry later"
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(state.RetryAfter.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, msg)
+			return
+		}
+		defer done()
+		next(w, r)
+	}
+}
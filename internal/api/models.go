@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/modelpolicy"
+)
+
+// listModels lists the LLM models the caller can pass as an assistant's
+// or a query's model, by ID or alias, and the embedding models the org can
+// choose, so clients can offer a picker.
+func (h *handlers) listModels(w http.ResponseWriter, r *http.Request) {
+	models := h.deps.Models.Models()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"models":           models,
+		"count":            len(models),
+		"embedding_models": h.deps.EmbeddingModels.Models(),
+	})
+}
+
+// checkModel answers the request with a 400 when model is set and not in
+// the catalog.
+func (h *handlers) checkModel(w http.ResponseWriter, model string) bool {
+	if model == "" {
+		return true
+	}
+	if _, ok := h.deps.Models.Resolve(model); !ok {
+		writeError(w, http.StatusBadRequest, "unknown model "+model+"; see GET /api/v1/models")
+		return false
+	}
+	return true
+}
+
+// getModelSettings returns the org's model choices; empty means the
+// deployment's default.
+func (h *handlers) getModelSettings(w http.ResponseWriter, r *http.Request) {
+	st, err := h.deps.ModelService.Settings(r.Context(), claimsFromCtx(r.Context()).OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load model settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// setModelSettings replaces the org's model choices. Admin only. A new
+// embedding model makes the org's vectors incomparable with its queries,
+// so it starts a reindex; when one can't start, the change is undone and
+// the request refused with 409.
+func (h *handlers) setModelSettings(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	var body modelpolicy.Settings
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	prev, err := h.deps.ModelService.Settings(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load model settings")
+		return
+	}
+	st := &modelpolicy.Settings{LLMModel: body.LLMModel, EmbeddingModel: body.EmbeddingModel}
+	err = h.deps.ModelService.Set(r.Context(), claims.OrgID, st)
+	if errors.Is(err, modelpolicy.ErrUnknownModel) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save model settings")
+		return
+	}
+	if !h.deps.ModelService.EmbeddingChanged(prev, st) {
+		writeJSON(w, http.StatusOK, map[string]any{"settings": st})
+		return
+	}
+
+	reindex, err := h.deps.DocumentService.StartReindex(r.Context(), claims.OrgID, claims.UserID)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]any{"settings": st, "reindex": reindex})
+	case errors.Is(err, document.ErrNothingToReindex):
+		writeJSON(w, http.StatusOK, map[string]any{"settings": st})
+	default:
+		if rerr := h.deps.ModelService.Set(r.Context(), claims.OrgID, prev); rerr != nil {
+			h.deps.Logger.Error("restore model settings failed", "org_id", claims.OrgID, "error", rerr)
+		}
+		if errors.Is(err, document.ErrReindexing) || errors.Is(err, document.ErrIngestionBusy) {
+			writeError(w, http.StatusConflict, "changing the embedding model needs a reindex: "+err.Error())
+			return
+		}
+		h.deps.Logger.Error("start reindex for embedding model failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to start reindex")
+	}
+}
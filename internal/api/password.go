@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+)
+
+// Password handlers
+
+// forgotPassword emails a reset link. It answers 202 whether or not the
+// email has an account.
+func (h *handlers) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	err := h.deps.TenantService.ForgotPassword(r.Context(), body.Email)
+	switch {
+	case errors.Is(err, tenant.ErrResetDisabled):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("password reset failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to request password reset")
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// resetPassword sets a new password with a reset token. Every session of
+// the user ends; they log in again with the new password.
+func (h *handlers) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req tenant.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	err := h.deps.TenantService.ResetPassword(r.Context(), req)
+	switch {
+	case errors.Is(err, tenant.ErrInvalidReset):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, tenant.ErrSSORequired):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// changePassword replaces the caller's password and returns a new token
+// pair; every other session ends. Not with an API key, which has no
+// password, nor by a super-admin impersonating the user.
+func (h *handlers) changePassword(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "api keys have no password")
+		return
+	}
+	if claims.Impersonator != "" {
+		writeError(w, http.StatusForbidden, "can't change a password while impersonating")
+		return
+	}
+
+	var req tenant.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	resp, err := h.deps.TenantService.ChangePassword(r.Context(), claims.UserID, req)
+	switch {
+	case errors.Is(err, tenant.ErrWrongPassword), errors.Is(err, tenant.ErrSSORequired):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		h.deps.Logger.Info("password changed", "org_id", claims.OrgID, "user_id", claims.UserID)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/prompt"
+)
+
+// Prompt template handlers
+
+func (h *handlers) listPrompts(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	list, err := h.deps.PromptService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list prompt templates")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"prompts": list, "count": len(list)})
+}
+
+func (h *handlers) getPrompt(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	t, err := h.deps.PromptService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writePromptError(w, err, "failed to get prompt template")
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *handlers) createPrompt(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var in prompt.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	t, err := h.deps.PromptService.Create(r.Context(), claims.OrgID, in)
+	if err != nil {
+		writePromptError(w, err, "failed to create prompt template")
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (h *handlers) updatePrompt(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var in prompt.Input
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	t, err := h.deps.PromptService.Update(r.Context(), r.PathValue("id"), claims.OrgID, in)
+	if err != nil {
+		writePromptError(w, err, "failed to update prompt template")
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *handlers) deletePrompt(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	if err := h.deps.PromptService.Delete(r.Context(), r.PathValue("id"), claims.OrgID); err != nil {
+		writePromptError(w, err, "failed to delete prompt template")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePromptError(w http.ResponseWriter, err error, fallbackMsg string) {
+	switch {
+	case errors.Is(err, prompt.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, prompt.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, prompt.ErrDuplicateName):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallbackMsg)
+	}
+}
+
+// getGeneration returns the org's generation defaults and caps, so
+// clients know what their queries will run with.
+func (h *handlers) getGeneration(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	g, err := h.deps.PromptService.GenerationSettings(r.Context(), claims.OrgID)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to load generation settings")
+	case g == nil:
+		writeError(w, http.StatusNotFound, prompt.ErrNoGenerationSettings.Error())
+	default:
+		writeJSON(w, http.StatusOK, g)
+	}
+}
+
+// setGeneration replaces the org's generation defaults and caps. Admin only.
+func (h *handlers) setGeneration(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var g prompt.GenerationSettings
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := h.deps.PromptService.SetGenerationSettings(r.Context(), claims.OrgID, &g); err != nil {
+		writePromptError(w, err, "failed to set generation settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (h *handlers) deleteGeneration(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.PromptService.DeleteGenerationSettings(r.Context(), claims.OrgID)
+	switch {
+	case errors.Is(err, prompt.ErrNoGenerationSettings):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to delete generation settings")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
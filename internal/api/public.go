@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/pixell07/multi-tenant-ai/internal/authorizer"
+	"github.com/pixell07/multi-tenant-ai/internal/collection"
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/publickb"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// Public knowledge base endpoints are unauthenticated, so they are rate
+// limited per token+IP far below what a logged-in user gets.
+const (
+	publicQueriesPerMinute = 10
+	publicQueryBurst       = 3
+	publicMaxQuestionLen   = 1000
+	publicMaxTopK          = 5
+)
+
+// publicQuery answers a question against the org behind a public token.
+// Only the site's scope of the org's own documents is searched: documents
+// other orgs shared privately, and anything the site wasn't scoped to,
+// must never be exposed to anonymous visitors.
+func (h *handlers) publicQuery(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if !h.publicLimits.limit(w, token+"|"+clientIP(r)) {
+		return
+	}
+
+	site, ok := h.resolvePublicSite(w, r, token)
+	if !ok {
+		return
+	}
+	if !site.Scoped() {
+		writeError(w, http.StatusForbidden, publickb.ErrUnscoped.Error())
+		return
+	}
+	r = r.WithContext(tenancy.WithOrg(r.Context(), site.OrgID))
+	if !h.checkQuota(w, r, site.OrgID, usage.LLMTokens) {
+		return
+	}
+
+	var body struct {
+		Question     string `json:"question"`
+		TopK         int    `json:"top_k"`
+		Stream       bool   `json:"stream"`
+		OutputFormat string `json:"output_format"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Question == "" || len(body.Question) > publicMaxQuestionLen {
+		writeError(w, http.StatusBadRequest, "question is required and must be under 1000 characters")
+		return
+	}
+	format, err := retrieval.ParseOutputFormat(body.OutputFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := retrieval.QueryRequest{
+		OrgID:        site.OrgID,
+		Question:     body.Question,
+		TopK:         min(body.TopK, publicMaxTopK),
+		SkipGrants:   true,
+		OutputFormat: format,
+	}
+	if site.AssistantID != nil {
+		a, err := h.deps.AssistantService.Get(r.Context(), *site.AssistantID, site.OrgID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load assistant")
+			return
+		}
+		a.Apply(&req)
+	}
+	// The site's scope bounds the assistant's.
+	req.CollectionIDs = site.CollectionIDs
+	if len(site.DocumentIDs) > 0 {
+		if len(req.DocumentIDs) > 0 {
+			req.DocumentIDs = slices.DeleteFunc(slices.Clone(req.DocumentIDs), func(id string) bool {
+				return !slices.Contains(site.DocumentIDs, id)
+			})
+			if len(req.DocumentIDs) == 0 {
+				writeError(w, http.StatusForbidden, "the assistant's documents are outside the public site's scope")
+				return
+			}
+		} else {
+			req.DocumentIDs = site.DocumentIDs
+		}
+	}
+	if body.Stream {
+		h.streamQuery(w, r, req, nil)
+		return
+	}
+	h.answerQuery(w, r, req, nil)
+}
+
+// publicPreflight answers CORS preflights for the public query endpoint.
+func (h *handlers) publicPreflight(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.resolvePublicSite(w, r, r.PathValue("token")); !ok {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolvePublicSite looks up an enabled site by token and enforces its
+// origin allow-list, setting CORS headers for allowed browser origins.
+func (h *handlers) resolvePublicSite(w http.ResponseWriter, r *http.Request, token string) (*publickb.Site, bool) {
+	site, err := h.deps.PublicKBService.Resolve(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, publickb.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "unknown public token")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "failed to resolve public token")
+		return nil, false
+	}
+
+	origin := r.Header.Get("Origin")
+	if !site.AllowsOrigin(origin) {
+		writeError(w, http.StatusForbidden, "origin not allowed")
+		return nil, false
+	}
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	return site, true
+}
+
+// Public site management (admin only)
+
+func (h *handlers) listPublicSites(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	sites, err := h.deps.PublicKBService.List(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list public sites")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sites": sites, "count": len(sites)})
+}
+
+func (h *handlers) createPublicSite(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req publickb.CreateSiteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if req.AssistantID != nil {
+		if _, err := h.deps.AssistantService.Get(r.Context(), *req.AssistantID, claims.OrgID); err != nil {
+			writeError(w, http.StatusBadRequest, "assistant not found")
+			return
+		}
+	}
+	if !h.checkSiteScope(w, r, claims.OrgID, req.CollectionIDs, req.DocumentIDs) {
+		return
+	}
+	attrs := map[string]string{"name": req.Name}
+	if req.AssistantID != nil {
+		attrs["assistant_id"] = *req.AssistantID
+	}
+	if !h.authorize(w, r, claims, authorizer.ActionPublicSiteCreate, authorizer.Resource{Type: "public_site"}, attrs) {
+		return
+	}
+
+	site, err := h.deps.PublicKBService.Create(r.Context(), claims.OrgID, req)
+	switch {
+	case errors.Is(err, publickb.ErrInvalidOrigin), errors.Is(err, publickb.ErrUnscoped):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to create public site")
+	default:
+		writeJSON(w, http.StatusCreated, site)
+	}
+}
+
+func (h *handlers) updatePublicSite(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var upd publickb.SiteUpdate
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !h.checkSiteScope(w, r, claims.OrgID, upd.CollectionIDs, upd.DocumentIDs) {
+		return
+	}
+
+	site, err := h.deps.PublicKBService.Update(r.Context(), r.PathValue("id"), claims.OrgID, upd)
+	switch {
+	case errors.Is(err, publickb.ErrInvalidOrigin), errors.Is(err, publickb.ErrUnscoped):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, publickb.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to update public site")
+	default:
+		writeJSON(w, http.StatusOK, site)
+	}
+}
+
+// checkSiteScope writes a 400 unless every collection and document of a
+// site's scope belongs to orgID.
+func (h *handlers) checkSiteScope(w http.ResponseWriter, r *http.Request, orgID string, collectionIDs, documentIDs []string) bool {
+	for _, id := range collectionIDs {
+		_, err := h.deps.CollectionService.Get(r.Context(), id, orgID)
+		if errors.Is(err, collection.ErrNotFound) {
+			writeError(w, http.StatusBadRequest, "collection "+id+" not found")
+			return false
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load collection")
+			return false
+		}
+	}
+	for _, id := range documentIDs {
+		_, err := h.deps.DocumentService.Get(r.Context(), id, orgID)
+		if errors.Is(err, document.ErrNotFound) {
+			writeError(w, http.StatusBadRequest, "document "+id+" not found")
+			return false
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load document")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *handlers) deletePublicSite(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.PublicKBService.Delete(r.Context(), r.PathValue("id"), claims.OrgID)
+	switch {
+	case errors.Is(err, publickb.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to delete public site")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
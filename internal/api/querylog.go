@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/querylog"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// getQuery returns a query from the log with its answer so far, so a
+// client whose stream broke (it was dropped, or the server went down) can
+// read back what was generated; status says whether the answer is
+// complete. Only whoever asked can read it, and admins (or callers the
+// policy approves, as for requireAdmin).
+func (h *handlers) getQuery(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	e, err := h.deps.QueryLogService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err == nil && claims.Role != tenant.RoleAdmin && !claims.PolicyApproved && e.Actor != tenancy.ActorFrom(r.Context()) {
+		err = querylog.ErrNotFound
+	}
+	switch {
+	case errors.Is(err, querylog.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to get query")
+	default:
+		writeJSON(w, http.StatusOK, e)
+	}
+}
+
+// answerSaver saves req's answer into the query log as it streams; nil
+// without a log.
+func (h *handlers) answerSaver(req retrieval.QueryRequest) *querylog.AnswerSaver {
+	if h.deps.QueryLogService == nil {
+		return nil
+	}
+	return h.deps.QueryLogService.SaveAnswer(req.QueryID, req.OrgID)
+}
+
+// answerStatus is the logged status of an answer that ended with err.
+func answerStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return querylog.StatusAnswered
+	case ctx.Err() != nil:
+		return querylog.StatusCancelled
+	}
+	return querylog.StatusFailed
+}
+
+// listQueryLog lists the org's latest questions with their intent, newest
+// first; ?intent= keeps one intent and ?limit= caps the list. Admin only,
+// since it shows what every member asked.
+func (h *handlers) listQueryLog(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := h.deps.QueryLogService.List(r.Context(), claims.OrgID, q.Get("intent"), limit)
+	switch {
+	case errors.Is(err, querylog.ErrUnknownIntent):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to list queries")
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"queries": entries, "count": len(entries)})
+	}
+}
+
+// queryIntents counts the org's questions per intent in a month
+// (?period=YYYY-MM, the current one by default). Admin only.
+func (h *handlers) queryIntents(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	now := time.Now().UTC()
+	p := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if s := r.URL.Query().Get("period"); s != "" {
+		var err error
+		if p, err = usage.ParsePeriod(s); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	counts, err := h.deps.QueryLogService.IntentCounts(r.Context(), claims.OrgID, p)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to count intents")
+		return
+	}
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"period": p.Format("2006-01"), "intents": counts, "total": total})
+}
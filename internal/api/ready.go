@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+)
+
+// Read-your-writes. ?wait_for_ready=true on an upload, replacement or
+// append holds the response until the document is ingested, so a script
+// can query it straight away. The answer is 201 (200 for a replacement or
+// append) with the ready document, 422 if ingestion failed, or the usual
+// 202 if it takes longer than maxReadyWait, after which the client polls.
+// Only content up to maxWaitBytes can be waited for, and not batch
+// uploads.
+
+const (
+	// maxReadyWait stays under the server's write timeout.
+	maxReadyWait = 45 * time.Second
+	maxWaitBytes = 1 << 20
+)
+
+// wantsReady reads ?wait_for_ready for a write of content. It answers the
+// request itself when the flag is invalid or can't be honoured.
+func wantsReady(w http.ResponseWriter, r *http.Request, content string, batch bool) (wait, ok bool) {
+	switch r.URL.Query().Get("wait_for_ready") {
+	case "", "false":
+		return false, true
+	case "true":
+	default:
+		writeError(w, http.StatusBadRequest, "wait_for_ready must be true or false")
+		return false, false
+	}
+	if batch {
+		writeError(w, http.StatusBadRequest, "wait_for_ready can't be used with batch ingestion")
+		return false, false
+	}
+	if len(content) > maxWaitBytes {
+		writeError(w, http.StatusBadRequest, "wait_for_ready is limited to 1 MB of text; poll the document instead")
+		return false, false
+	}
+	return true, true
+}
+
+// respondQueued answers a write that queued doc for ingestion: 202 right
+// away, or once ingestion settles when wait is set (see wantsReady), with
+// readyStatus for a ready document.
+func (h *handlers) respondQueued(w http.ResponseWriter, r *http.Request, doc *document.Document, wait bool, readyStatus int) {
+	setETag(w, doc.Version)
+	if !wait {
+		writeJSON(w, http.StatusAccepted, doc)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxReadyWait)
+	defer cancel()
+	settled, err := h.deps.DocumentService.WaitReady(ctx, doc.ID, doc.OrgID)
+	switch {
+	case err == nil:
+		writeJSON(w, readyStatus, settled)
+	case errors.Is(err, document.ErrIngestionFailed):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error(), "document": settled})
+	case errors.Is(err, document.ErrNotFound):
+		writeError(w, http.StatusNotFound, "document was deleted before it was ingested")
+	case r.Context().Err() != nil:
+		// The client is gone.
+	case errors.Is(err, context.DeadlineExceeded):
+		if settled != nil {
+			doc = settled
+		}
+		writeJSON(w, http.StatusAccepted, doc)
+	default:
+		h.deps.Logger.Error("wait for ingestion failed", "doc_id", doc.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to wait for ingestion")
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+)
+
+// Reindexing. POST /api/v1/reindex drops the org's vectors and rebuilds
+// them from its stored documents through the ingestion queue; document
+// changes are refused with 409 until it finishes. GET /api/v1/reindex
+// reports the progress and ETA of the latest one. Both are admin only.
+
+func (h *handlers) startReindex(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	p, err := h.deps.DocumentService.StartReindex(r.Context(), claims.OrgID, claims.UserID)
+	switch {
+	case errors.Is(err, document.ErrReindexing), errors.Is(err, document.ErrIngestionBusy):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, document.ErrNothingToReindex):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("start reindex failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to start reindex")
+	default:
+		h.deps.Logger.Info("reindex started", "org_id", claims.OrgID, "reindex_id", p.ID,
+			"documents", p.Documents, "vectors_dropped", p.VectorsDropped)
+		writeJSON(w, http.StatusAccepted, p)
+	}
+}
+
+func (h *handlers) getReindex(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	p, err := h.deps.DocumentService.LatestReindex(r.Context(), claims.OrgID)
+	switch {
+	case errors.Is(err, document.ErrNoReindex):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to get reindex")
+	default:
+		writeJSON(w, http.StatusOK, p)
+	}
+}
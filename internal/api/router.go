@@ -3,16 +3,46 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/pixell07/multi-tenant-ai/internal/admin"
+	"github.com/pixell07/multi-tenant-ai/internal/analytics"
+	"github.com/pixell07/multi-tenant-ai/internal/apikey"
+	"github.com/pixell07/multi-tenant-ai/internal/assistant"
 	"github.com/pixell07/multi-tenant-ai/internal/auth"
+	"github.com/pixell07/multi-tenant-ai/internal/auth/oidc"
+	"github.com/pixell07/multi-tenant-ai/internal/authorizer"
+	"github.com/pixell07/multi-tenant-ai/internal/collection"
+	"github.com/pixell07/multi-tenant-ai/internal/connector"
+	"github.com/pixell07/multi-tenant-ai/internal/conversation"
+	"github.com/pixell07/multi-tenant-ai/internal/crm"
 	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/extract"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/maintenance"
+	"github.com/pixell07/multi-tenant-ai/internal/modelpolicy"
+	"github.com/pixell07/multi-tenant-ai/internal/prompt"
+	"github.com/pixell07/multi-tenant-ai/internal/publickb"
+	"github.com/pixell07/multi-tenant-ai/internal/querylog"
 	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/routing"
+	"github.com/pixell07/multi-tenant-ai/internal/sharing"
+	"github.com/pixell07/multi-tenant-ai/internal/slo"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
 	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+	"github.com/pixell07/multi-tenant-ai/internal/websocket"
 )
 
 type contextKey string
@@ -20,32 +50,222 @@ type contextKey string
 const claimsKey contextKey = "claims"
 
 type RouterDeps struct {
-	TenantService   *tenant.Service
-	DocumentService *document.Service
-	RAGService      *retrieval.RAGService
-	JWTManager      *auth.JWTManager
-	Logger          *slog.Logger
+	TenantService       *tenant.Service
+	DocumentService     *document.Service
+	RAGService          *retrieval.RAGService
+	SharingService      *sharing.Service
+	PublicKBService     *publickb.Service
+	APIKeyService       *apikey.Service
+	AssistantService    *assistant.Service
+	PromptService       *prompt.Service
+	ModelService        *modelpolicy.Service
+	CollectionService   *collection.Service
+	ConnectorService    *connector.Service
+	ConversationService *conversation.Service
+	QueryLogService     *querylog.Service
+	CRMService          *crm.Service
+	UsageService        *usage.Service
+	// SSOService signs users in through their org's identity provider;
+	// nil leaves the SSO routes unmounted.
+	SSOService      *oidc.Service
+	Models          *llm.Catalog
+	EmbeddingModels *embedding.Router
+	QueryRouter     *routing.Router
+	MCPHandler      http.Handler // API-key authenticated, mounted at /mcp
+	Maintenance     *maintenance.Mode
+	// Status tracks query and ingestion health for GET /status.
+	Status  *slo.Tracker
+	Tenancy *tenancy.Resolver
+	// OperatorToken guards the deployment-wide operator routes; empty
+	// leaves them unmounted.
+	OperatorToken string
+	JWTManager    *auth.JWTManager
+	Logger        *slog.Logger
+
+	// OperatorClientCert additionally requires operator routes to come
+	// with a client certificate the listener verified, for listeners that
+	// only ask for one (TLS_CLIENT_AUTH=optional).
+	OperatorClientCert bool
+
+	// AuthorizerService consults orgs' authorization webhooks before
+	// sensitive operations; nil leaves its routes unmounted and allows
+	// whatever our roles do.
+	AuthorizerService *authorizer.Service
+	// Policy puts every authenticated API request to the deployment's
+	// access policy; nil leaves authorization to the roles.
+	Policy *authorizer.Policy
+
+	// AdminService serves super-admins' /api/v1/admin routes.
+	AdminService *admin.Service
+	// Analytics receives anonymized feature usage; nil (the default, and
+	// always with ANALYTICS_DISABLED) sends nothing.
+	Analytics *analytics.Client
+
+	// WebSocketOrigins lists the origins, besides the server's own, whose
+	// pages may open /api/v1/query/ws (host patterns, e.g. "*.acme.com").
+	WebSocketOrigins []string
 }
 
 func NewRouter(deps RouterDeps) http.Handler {
 	mux := http.NewServeMux()
 
-	h := &handlers{deps: deps}
+	h := &handlers{
+		deps:         deps,
+		publicLimits: newRateLimiter(publicQueriesPerMinute, publicQueryBurst),
+		widgetLimits: newRateLimiter(widgetConfigsPerMinute, widgetConfigBurst),
+	}
 
 	// Public routes
 	mux.HandleFunc("POST /api/v1/auth/register", h.register)
 	mux.HandleFunc("POST /api/v1/auth/login", h.login)
+	mux.HandleFunc("POST /api/v1/auth/refresh", h.refresh)
+	mux.HandleFunc("POST /api/v1/auth/logout", h.logout)
+	mux.HandleFunc("POST /api/v1/auth/accept-invite", h.acceptInvite)
+	mux.HandleFunc("POST /api/v1/auth/forgot-password", h.forgotPassword)
+	mux.HandleFunc("POST /api/v1/auth/reset-password", h.resetPassword)
+	if deps.SSOService != nil {
+		mux.HandleFunc("POST /api/v1/auth/sso/start", h.startSSO)
+		mux.HandleFunc("POST /api/v1/auth/sso/callback", h.finishSSO)
+	}
 	mux.HandleFunc("GET  /api/v1/health", h.health)
+	mux.HandleFunc("GET /status", h.status)
+	mux.HandleFunc("POST /api/v1/public/{token}/query", h.drainable(h.publicQuery))
+	mux.HandleFunc("OPTIONS /api/v1/public/{token}/query", h.publicPreflight)
+	mux.HandleFunc("GET /widget/widget.js", h.widgetScript)
+	mux.HandleFunc("GET /widget/config.json", h.widgetConfig)
+	mux.Handle("/mcp", h.drainable(deps.MCPHandler.ServeHTTP))
+	mux.HandleFunc("POST /webhooks/github", h.drainable(h.githubWebhook))
+	mux.HandleFunc("GET /github/install/callback", h.githubInstallCallback)
+
+	// Operator routes (deployment-wide, OPERATOR_TOKEN)
+	if deps.OperatorToken != "" {
+		mux.HandleFunc("GET /api/v1/ops/maintenance", h.getMaintenance)
+		mux.HandleFunc("PUT /api/v1/ops/maintenance", h.enableMaintenance)
+		mux.HandleFunc("DELETE /api/v1/ops/maintenance", h.disableMaintenance)
+		mux.HandleFunc("GET /api/v1/ops/usage", h.usage)
+		mux.HandleFunc("GET /api/v1/ops/orgs/{id}/storage", h.getOrgStorage)
+		mux.HandleFunc("PUT /api/v1/ops/orgs/{id}/storage", h.setOrgStorage)
+		mux.HandleFunc("GET /api/v1/ops/orgs/{id}/quota", h.getOrgQuota)
+		mux.HandleFunc("PUT /api/v1/ops/orgs/{id}/quota", h.setOrgQuota)
+		mux.HandleFunc("POST /api/v1/ops/orgs/{id}/credits", h.grantCredits)
+		mux.HandleFunc("GET /api/v1/ops/super-admins", h.listSuperAdmins)
+		mux.HandleFunc("PUT /api/v1/ops/super-admins/{user_id}", h.grantSuperAdmin)
+		mux.HandleFunc("DELETE /api/v1/ops/super-admins/{user_id}", h.revokeSuperAdmin)
+	}
 
 	// Protected routes (wrapped with auth middleware)
 	protected := http.NewServeMux()
 	protected.HandleFunc("GET  /api/v1/documents", h.listDocuments)
-	protected.HandleFunc("POST /api/v1/documents", h.uploadDocument)
+	protected.HandleFunc("POST /api/v1/documents", h.drainable(h.withinQuota(h.uploadDocument, usage.IngestKinds...)))
+	protected.HandleFunc("POST /api/v1/documents/estimate", h.estimateDocument)
+	protected.HandleFunc("GET /api/v1/documents/folders", h.listFolders)
+	protected.HandleFunc("GET /api/v1/documents/{id}", h.getDocument)
+	protected.HandleFunc("PUT /api/v1/documents/{id}", h.drainable(h.withinQuota(h.replaceDocument, usage.IngestKinds...)))
+	protected.HandleFunc("PATCH /api/v1/documents/{id}", h.renameDocument)
 	protected.HandleFunc("DELETE /api/v1/documents/{id}", h.deleteDocument)
-	protected.HandleFunc("POST /api/v1/query", h.query)          // SSE streaming
-	protected.HandleFunc("POST /api/v1/query/sync", h.querySync) // one-shot for testing
+	protected.HandleFunc("POST /api/v1/documents/{id}/append", h.drainable(h.withinQuota(h.appendDocument, usage.IngestKinds...)))
+	protected.HandleFunc("POST /api/v1/documents/uploads", h.drainable(h.withinQuota(h.startUpload, usage.IngestKinds...)))
+	protected.HandleFunc("POST /api/v1/documents/{id}/complete", h.drainable(h.completeUpload))
+	protected.HandleFunc("POST /api/v1/documents/imports", h.drainable(h.withinQuota(h.importArchive, usage.IngestKinds...)))
+	protected.HandleFunc("GET /api/v1/documents/imports/{id}", h.getImport)
+	protected.HandleFunc("POST /api/v1/reindex", h.drainable(h.withinQuota(h.startReindex, usage.EmbeddingTokens)))
+	protected.HandleFunc("GET /api/v1/reindex", h.getReindex)
+	protected.HandleFunc("POST /api/v1/auth/change-password", h.changePassword)
+	protected.HandleFunc("GET /api/v1/stats", h.orgStats)
+	protected.HandleFunc("GET /api/v1/models", h.listModels)
+	protected.HandleFunc("GET /api/v1/models/settings", h.getModelSettings)
+	protected.HandleFunc("PUT /api/v1/models/settings", h.setModelSettings)
+	protected.HandleFunc("GET /api/v1/usage", h.orgUsage)
+	protected.HandleFunc("GET /api/v1/usage/breakdown", h.usageBreakdown)
+	protected.HandleFunc("GET /api/v1/usage/budget", h.getBudget)
+	protected.HandleFunc("PUT /api/v1/usage/budget", h.setBudget)
+	protected.HandleFunc("DELETE /api/v1/usage/budget", h.deleteBudget)
+	protected.HandleFunc("GET /api/v1/credits", h.getCredits)
+	protected.HandleFunc("GET /api/v1/credits/transactions", h.listCreditTransactions)
+	protected.HandleFunc("GET /api/v1/billing/statements", h.listStatements)
+	protected.HandleFunc("GET /api/v1/billing/statements/{period}", h.getStatement)
+	protected.HandleFunc("GET /api/v1/billing/contacts", h.getBillingContacts)
+	protected.HandleFunc("PUT /api/v1/billing/contacts", h.setBillingContacts)
+	protected.HandleFunc("GET /api/v1/users", h.listUsers)
+	protected.HandleFunc("POST /api/v1/users/invite", h.inviteUser)
+	protected.HandleFunc("PATCH /api/v1/users/{id}", h.updateUser)
+	protected.HandleFunc("DELETE /api/v1/users/{id}", h.removeUser)
+	protected.HandleFunc("PUT /api/v1/users/bulk", h.syncUsers)
+	protected.HandleFunc("DELETE /api/v1/org", h.deleteOrg)
+	if deps.SSOService != nil {
+		protected.HandleFunc("GET /api/v1/sso", h.getSSOProvider)
+		protected.HandleFunc("PUT /api/v1/sso", h.setSSOProvider)
+		protected.HandleFunc("DELETE /api/v1/sso", h.deleteSSOProvider)
+		protected.HandleFunc("POST /api/v1/sso/domains/{domain}/verify", h.verifySSODomain)
+	}
+	if deps.AuthorizerService != nil {
+		protected.HandleFunc("GET /api/v1/authorizer", h.getAuthorizer)
+		protected.HandleFunc("PUT /api/v1/authorizer", h.setAuthorizer)
+		protected.HandleFunc("DELETE /api/v1/authorizer", h.deleteAuthorizer)
+	}
+	protected.HandleFunc("GET /api/v1/shares", h.listShares)
+	protected.HandleFunc("POST /api/v1/shares", h.createShare)
+	protected.HandleFunc("DELETE /api/v1/shares/{id}", h.revokeShare)
+	protected.HandleFunc("GET /api/v1/public-sites", h.listPublicSites)
+	protected.HandleFunc("POST /api/v1/public-sites", h.createPublicSite)
+	protected.HandleFunc("PATCH /api/v1/public-sites/{id}", h.updatePublicSite)
+	protected.HandleFunc("DELETE /api/v1/public-sites/{id}", h.deletePublicSite)
+	protected.HandleFunc("GET /api/v1/api-keys", h.listAPIKeys)
+	protected.HandleFunc("POST /api/v1/api-keys", h.createAPIKey)
+	protected.HandleFunc("DELETE /api/v1/api-keys/{id}", h.revokeAPIKey)
+	protected.HandleFunc("GET /api/v1/collections", h.listCollections)
+	protected.HandleFunc("POST /api/v1/collections", h.createCollection)
+	protected.HandleFunc("GET /api/v1/collections/{id}", h.getCollection)
+	protected.HandleFunc("PATCH /api/v1/collections/{id}", h.updateCollection)
+	protected.HandleFunc("DELETE /api/v1/collections/{id}", h.deleteCollection)
+	protected.HandleFunc("GET /api/v1/collections/{id}/documents", h.listCollectionDocuments)
+	protected.HandleFunc("POST /api/v1/collections/{id}/documents", h.addCollectionDocuments)
+	protected.HandleFunc("DELETE /api/v1/collections/{id}/documents/{document_id}", h.removeCollectionDocument)
+	protected.HandleFunc("GET /api/v1/assistants", h.listAssistants)
+	protected.HandleFunc("POST /api/v1/assistants", h.createAssistant)
+	protected.HandleFunc("GET /api/v1/assistants/{id}", h.getAssistant)
+	protected.HandleFunc("PUT /api/v1/assistants/{id}", h.updateAssistant)
+	protected.HandleFunc("DELETE /api/v1/assistants/{id}", h.deleteAssistant)
+	protected.HandleFunc("GET /api/v1/prompts", h.listPrompts)
+	protected.HandleFunc("POST /api/v1/prompts", h.createPrompt)
+	protected.HandleFunc("GET /api/v1/prompts/{id}", h.getPrompt)
+	protected.HandleFunc("PUT /api/v1/prompts/{id}", h.updatePrompt)
+	protected.HandleFunc("DELETE /api/v1/prompts/{id}", h.deletePrompt)
+	protected.HandleFunc("GET /api/v1/generation", h.getGeneration)
+	protected.HandleFunc("PUT /api/v1/generation", h.setGeneration)
+	protected.HandleFunc("DELETE /api/v1/generation", h.deleteGeneration)
+	protected.HandleFunc("POST /api/v1/assistants/{id}/query", h.drainable(h.withinQuota(h.assistantQuery, usage.LLMTokens)))
+	protected.HandleFunc("POST /api/v1/assistants/{id}/query/sync", h.drainable(h.withinQuota(h.assistantQuerySync, usage.LLMTokens)))
+	protected.HandleFunc("GET /api/v1/connectors", h.listConnectors)
+	protected.HandleFunc("POST /api/v1/connectors", h.createConnector)
+	protected.HandleFunc("DELETE /api/v1/connectors/{id}", h.deleteConnector)
+	protected.HandleFunc("POST /api/v1/connectors/{id}/sync", h.drainable(h.withinQuota(h.syncConnector, usage.IngestKinds...)))
+	protected.HandleFunc("GET /api/v1/connectors/{id}/items", h.listConnectorItems)
+	protected.HandleFunc("POST /api/v1/connectors/github/install", h.startGitHubInstall)
+	protected.HandleFunc("GET /api/v1/connectors/github/installations", h.listGitHubInstallations)
+	protected.HandleFunc("GET /api/v1/crm/integrations", h.listCRMIntegrations)
+	protected.HandleFunc("PUT /api/v1/crm/integrations/{provider}", h.setCRMIntegration)
+	protected.HandleFunc("DELETE /api/v1/crm/integrations/{provider}", h.deleteCRMIntegration)
+	protected.HandleFunc("GET /api/v1/query-log", h.listQueryLog)
+	protected.HandleFunc("GET /api/v1/query-log/intents", h.queryIntents)
+	protected.HandleFunc("GET /api/v1/queries/{id}", h.getQuery)
+	protected.HandleFunc("GET /api/v1/conversations", h.listConversations)
+	protected.HandleFunc("POST /api/v1/conversations", h.createConversation)
+	protected.HandleFunc("GET /api/v1/conversations/{id}/messages", h.listConversationMessages)
+	protected.HandleFunc("POST /api/v1/query", h.drainable(h.withinQuota(h.query, usage.LLMTokens)))          // SSE streaming
+	protected.HandleFunc("POST /api/v1/query/sync", h.drainable(h.withinQuota(h.querySync, usage.LLMTokens))) // one-shot for testing
+	protected.HandleFunc("GET /api/v1/query/ws", h.queryWS)                                                   // WebSocket streaming
 
-	mux.Handle("/api/v1/", h.authMiddleware(protected))
+	// Platform administration (super-admins)
+	protected.HandleFunc("GET /api/v1/admin/orgs", h.listAdminOrgs)
+	protected.HandleFunc("GET /api/v1/admin/orgs/{id}", h.getAdminOrg)
+	protected.HandleFunc("GET /api/v1/admin/orgs/{id}/usage", h.getAdminOrgUsage)
+	protected.HandleFunc("POST /api/v1/admin/orgs/{id}/suspend", h.suspendOrg)
+	protected.HandleFunc("POST /api/v1/admin/orgs/{id}/reactivate", h.reactivateOrg)
+	protected.HandleFunc("POST /api/v1/admin/orgs/{id}/impersonate", h.impersonateUser)
+	protected.HandleFunc("GET /api/v1/admin/audit", h.listAdminAudit)
+
+	mux.Handle("/api/v1/", h.authMiddleware(h.withAnalytics(protected, h.withPolicy(protected))))
 
 	return h.loggingMiddleware(mux)
 }
@@ -53,11 +273,17 @@ func NewRouter(deps RouterDeps) http.Handler {
 // Handlers
 
 type handlers struct {
-	deps RouterDeps
+	deps         RouterDeps
+	publicLimits *rateLimiter
+	widgetLimits *rateLimiter
 }
 
 func (h *handlers) health(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "time": time.Now().Format(time.RFC3339)})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":      "ok",
+		"time":        time.Now().Format(time.RFC3339),
+		"maintenance": h.deps.Maintenance.Enabled(),
+	})
 }
 
 func (h *handlers) register(w http.ResponseWriter, r *http.Request) {
@@ -72,6 +298,7 @@ func (h *handlers) register(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	h.deps.Analytics.Track(resp.Org.ID, "org_registered", nil)
 	writeJSON(w, http.StatusCreated, resp)
 }
 
@@ -83,169 +310,863 @@ func (h *handlers) login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp, err := h.deps.TenantService.Login(r.Context(), req)
-	if err != nil {
+	switch {
+	case errors.Is(err, tenant.ErrSSORequired), errors.Is(err, tenant.ErrOrgSuspended):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
 		writeError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// refresh exchanges a refresh token for a new access/refresh pair. The old
+// refresh token stops working.
+func (h *handlers) refresh(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "refresh_token is required")
 		return
 	}
-	writeJSON(w, http.StatusOK, resp)
+
+	resp, err := h.deps.TenantService.Refresh(r.Context(), body.RefreshToken)
+	switch {
+	case errors.Is(err, tenant.ErrInvalidRefreshToken):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, tenant.ErrOrgSuspended):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to refresh token")
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// logout revokes a refresh token (and its rotations). Access tokens already
+// issued stay valid until they expire, which is why they are short-lived.
+func (h *handlers) logout(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+
+	if err := h.deps.TenantService.Logout(r.Context(), body.RefreshToken); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to log out")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *handlers) listDocuments(w http.ResponseWriter, r *http.Request) {
 	claims := claimsFromCtx(r.Context())
 
-	docs, err := h.deps.DocumentService.List(r.Context(), claims.OrgID)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to list documents")
+	q := r.URL.Query()
+	opts := document.ListOptions{
+		Status:    document.Status(q.Get("status")),
+		Search:    q.Get("q"),
+		Tags:      q["tag"],
+		Folder:    q.Get("folder"),
+		Recursive: q.Get("recursive") == "true",
+		Sort:      q.Get("sort"),
+	}
+	var err error
+	if opts.Limit, err = queryInt(q, "limit"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"documents": docs, "count": len(docs)})
+	if opts.Offset, err = queryInt(q, "offset"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := h.deps.DocumentService.List(r.Context(), claims.OrgID, opts)
+	switch {
+	case errors.Is(err, document.ErrInvalidListing):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to list documents")
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"documents": list.Documents,
+			"count":     len(list.Documents),
+			"total":     list.Total,
+		})
+	}
+}
+
+// listFolders lists the folders directly in ?folder= (the root when
+// absent), with the number of documents under each.
+func (h *handlers) listFolders(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	listing, err := h.deps.DocumentService.Folders(r.Context(), claims.OrgID, r.URL.Query().Get("folder"))
+	switch {
+	case errors.Is(err, document.ErrInvalidListing):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to list folders")
+	default:
+		writeJSON(w, http.StatusOK, listing)
+	}
 }
 
+// queryInt parses the integer query parameter key, 0 when absent.
+func queryInt(q url.Values, key string) (int, error) {
+	raw := q.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+	return n, nil
+}
+
+// maxUploadBytes caps a multipart document upload.
+const maxUploadBytes = 32 << 20
+
+// uploadDocument accepts either JSON {"name", "content"} with raw text, or
+// multipart/form-data with a "file" part (PDF, DOCX, HTML, Markdown or
+// text) and an optional "name" field defaulting to the file name.
+// ?ingest=batch embeds through the Batch API, for large imports;
+// ?wait_for_ready=true answers once the document is ingested (see
+// respondQueued).
 func (h *handlers) uploadDocument(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUpload(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	wait, ok := wantsReady(w, r, req.Content, req.Batch)
+	if !ok {
+		return
+	}
+
+	doc, err := h.deps.DocumentService.Upload(r.Context(), req)
+	if errors.Is(err, document.ErrBatchDisabled) || errors.Is(err, document.ErrInvalidLabels) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, document.ErrReindexing) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to upload document")
+		return
+	}
+	h.respondQueued(w, r, doc, wait, http.StatusCreated)
+}
+
+// decodeUpload reads an upload of the caller's org: JSON {name, content,
+// tags, metadata} or a multipart file with optional "tags" (comma
+// separated) and "metadata" (a JSON object) fields, with ?ingest=batch.
+// Content is required, the name is up to the caller. It answers the
+// request itself when the upload is invalid.
+func decodeUpload(w http.ResponseWriter, r *http.Request) (document.UploadRequest, bool) {
+	req := document.UploadRequest{OrgID: claimsFromCtx(r.Context()).OrgID}
+	switch ingest := r.URL.Query().Get("ingest"); ingest {
+	case "":
+	case "batch":
+		req.Batch = true
+	default:
+		writeError(w, http.StatusBadRequest, `ingest must be "batch" or omitted`)
+		return req, false
+	}
+
+	var body struct {
+		Name     string         `json:"name"`
+		Content  string         `json:"content"`
+		Tags     []string       `json:"tags"`
+		Metadata map[string]any `json:"metadata"`
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		name, content, status, err := readUpload(w, r)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return req, false
+		}
+		body.Name, body.Content = name, content
+		if body.Tags, body.Metadata, err = formLabels(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return req, false
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	if body.Content == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return req, false
+	}
+	req.Name, req.Content = body.Name, body.Content
+	req.Tags, req.Metadata = body.Tags, body.Metadata
+	return req, true
+}
+
+// formLabels reads the "tags" (comma separated) and "metadata" (a JSON
+// object) fields of a multipart upload.
+func formLabels(r *http.Request) (tags []string, metadata map[string]any, err error) {
+	if t := r.FormValue("tags"); t != "" {
+		tags = strings.Split(t, ",")
+	}
+	if md := r.FormValue("metadata"); md != "" {
+		if err := json.Unmarshal([]byte(md), &metadata); err != nil {
+			return nil, nil, errors.New("metadata must be a JSON object")
+		}
+	}
+	return tags, metadata, nil
+}
+
+// readUpload reads the "file" part of a multipart upload and extracts its
+// text. On failure it returns the HTTP status to answer with.
+func readUpload(w http.ResponseWriter, r *http.Request) (name, content string, status int, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", "", http.StatusRequestEntityTooLarge, errors.New("file too large")
+		}
+		return "", "", http.StatusBadRequest, errors.New("multipart upload requires a file part")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", "", http.StatusBadRequest, errors.New("failed to read file")
+	}
+
+	text, err := extract.Text(header.Filename, header.Header.Get("Content-Type"), data)
+	if errors.Is(err, extract.ErrUnsupported) {
+		return "", "", http.StatusUnsupportedMediaType, err
+	}
+	if err != nil {
+		return "", "", http.StatusUnprocessableEntity, err
+	}
+
+	name = r.FormValue("name")
+	if name == "" {
+		name = header.Filename
+	}
+	return name, text, 0, nil
+}
+
+func (h *handlers) getDocument(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	doc, err := h.deps.DocumentService.Get(r.Context(), r.PathValue("id"), claims.OrgID)
+	if err != nil {
+		writeDocumentError(w, err, "failed to get document")
+		return
+	}
+	setETag(w, doc.Version)
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// renameDocument updates document metadata. Like every document write it
+// requires If-Match so two admins editing concurrently get a 409 instead of
+// silently overwriting each other.
+func (h *handlers) renameDocument(w http.ResponseWriter, r *http.Request) {
 	claims := claimsFromCtx(r.Context())
 
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var body struct {
-		Name    string `json:"name"`
-		Content string `json:"content"`
+		Name string `json:"name"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
-	if body.Name == "" || body.Content == "" {
-		writeError(w, http.StatusBadRequest, "name and content are required")
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
 		return
 	}
 
-	doc, err := h.deps.DocumentService.Upload(r.Context(), document.UploadRequest{
-		OrgID:   claims.OrgID,
-		Name:    body.Name,
-		Content: body.Content,
-	})
+	doc, err := h.deps.DocumentService.Rename(r.Context(), r.PathValue("id"), claims.OrgID, body.Name, version)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to upload document")
+		writeDocumentError(w, err, "failed to update document")
+		return
+	}
+	setETag(w, doc.Version)
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// replaceDocument replaces a document's content, and name if given, and
+// re-ingests it under the same ID. The body is that of an upload.
+func (h *handlers) replaceDocument(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	writeJSON(w, http.StatusAccepted, doc)
+	req, ok := decodeUpload(w, r)
+	if !ok {
+		return
+	}
+	if req.Batch {
+		writeError(w, http.StatusBadRequest, "batch ingestion is only available for new documents")
+		return
+	}
+	wait, ok := wantsReady(w, r, req.Content, false)
+	if !ok {
+		return
+	}
+
+	doc, err := h.deps.DocumentService.Replace(r.Context(), r.PathValue("id"), claims.OrgID, req.Name, req.Content, version)
+	if err != nil {
+		writeDocumentError(w, err, "failed to replace document")
+		return
+	}
+	h.respondQueued(w, r, doc, wait, http.StatusOK)
 }
 
 func (h *handlers) deleteDocument(w http.ResponseWriter, r *http.Request) {
 	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
 	docID := r.PathValue("id")
 
-	if err := h.deps.DocumentService.Delete(r.Context(), docID, claims.OrgID); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to delete document")
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+	if !h.authorize(w, r, claims, authorizer.ActionDocumentDelete, authorizer.Resource{Type: "document", ID: docID}, nil) {
+		return
+	}
+
+	if err := h.deps.DocumentService.Delete(r.Context(), docID, claims.OrgID, version); err != nil {
+		writeDocumentError(w, err, "failed to delete document")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// query handles SSE streaming of RAG responses.
-// The client receives a stream of "data: <token>\n\n" events.
-func (h *handlers) query(w http.ResponseWriter, r *http.Request) {
+// appendDocument ingests only newly appended content of an existing document.
+func (h *handlers) appendDocument(w http.ResponseWriter, r *http.Request) {
 	claims := claimsFromCtx(r.Context())
 
 	var body struct {
-		Question string `json:"question"`
-		TopK     int    `json:"top_k"`
+		Content string `json:"content"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if body.Content == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	wait, ok := wantsReady(w, r, body.Content, false)
+	if !ok {
+		return
+	}
+
+	doc, err := h.deps.DocumentService.Append(r.Context(), r.PathValue("id"), claims.OrgID, body.Content)
+	if err != nil {
+		writeDocumentError(w, err, "failed to append to document")
+		return
+	}
+	h.respondQueued(w, r, doc, wait, http.StatusOK)
+}
+
+// writeDocumentError maps document sentinel errors to HTTP statuses,
+// falling back to a 500 with fallbackMsg.
+func writeDocumentError(w http.ResponseWriter, err error, fallbackMsg string) {
+	switch {
+	case errors.Is(err, document.ErrNotFound):
+		writeError(w, http.StatusNotFound, "document not found")
+	case errors.Is(err, document.ErrVersionConflict), errors.Is(err, document.ErrNotReady),
+		errors.Is(err, document.ErrReindexing):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallbackMsg)
+	}
+}
+
+// query handles SSE streaming of RAG responses.
+// The client receives a stream of "data: <token>\n\n" events.
+func (h *handlers) query(w http.ResponseWriter, r *http.Request) {
+	req, conv, ok := h.decodeQuery(w, r)
+	if !ok {
+		return
+	}
+	h.streamQuery(w, r, req, conv)
+}
+
+// queryBody is the request body every query endpoint accepts.
+type queryBody struct {
+	Question        string         `json:"question"`
+	TopK            int            `json:"top_k"`
+	SearchMode      string         `json:"search_mode"`
+	MaxChunksPerDoc int            `json:"max_chunks_per_doc"`
+	ConversationID  string         `json:"conversation_id"`
+	CollectionIDs   []string       `json:"collection_ids"`
+	Filters         map[string]any `json:"filters"`
+	MinScore        float32        `json:"min_score"`
+	Model           string         `json:"model"`
+	NoCache         bool           `json:"no_cache"`
+	OutputFormat    string         `json:"output_format"`
+	llm.Generation                 // temperature, top_p, max_tokens, stop
+}
+
+// queryRequest validates body and returns the query it asks for in the
+// caller's org. If body doesn't hold up the error is written and ok is
+// false.
+func (h *handlers) queryRequest(w http.ResponseWriter, r *http.Request, body *queryBody) (req retrieval.QueryRequest, ok bool) {
 	if body.Question == "" {
 		writeError(w, http.StatusBadRequest, "question is required")
-		return
+		return retrieval.QueryRequest{}, false
+	}
+	mode, err := retrieval.ParseSearchMode(body.SearchMode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return retrieval.QueryRequest{}, false
+	}
+	format, err := retrieval.ParseOutputFormat(body.OutputFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return retrieval.QueryRequest{}, false
+	}
+	if body.MaxChunksPerDoc < 0 {
+		writeError(w, http.StatusBadRequest, "max_chunks_per_doc must not be negative")
+		return retrieval.QueryRequest{}, false
+	}
+	if err := retrieval.ValidateFilters(body.Filters); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return retrieval.QueryRequest{}, false
+	}
+	if body.MinScore < 0 || body.MinScore > 1 {
+		writeError(w, http.StatusBadRequest, "min_score must be between 0 and 1")
+		return retrieval.QueryRequest{}, false
+	}
+	if err := body.Generation.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return retrieval.QueryRequest{}, false
+	}
+	if !h.checkModel(w, body.Model) {
+		return retrieval.QueryRequest{}, false
+	}
+	if !h.checkCollections(w, r, body.CollectionIDs) {
+		return retrieval.QueryRequest{}, false
+	}
+
+	return retrieval.QueryRequest{
+		OrgID:                claimsFromCtx(r.Context()).OrgID,
+		Question:             body.Question,
+		TopK:                 body.TopK,
+		SearchMode:           mode,
+		MaxChunksPerDocument: body.MaxChunksPerDoc,
+		CollectionIDs:        body.CollectionIDs,
+		Filters:              body.Filters,
+		MinScore:             body.MinScore,
+		Generation:           body.Generation,
+		BypassCache:          body.NoCache,
+		OutputFormat:         format,
+	}, true
+}
+
+// decodeQuery parses the body shared by /query and /query/sync. With
+// "route": true the question is first routed to the best-fitting
+// assistant, whose choice is reported in the X-Routed-Assistant header;
+// an "assistant_id" names the assistant instead, so one client can query
+// each of the org's knowledge scopes with the same credential. With a
+// "conversation_id" the conversation's recent turns are loaded into
+// the request, and the conversation is returned so the answer can be
+// recorded.
+func (h *handlers) decodeQuery(w http.ResponseWriter, r *http.Request) (retrieval.QueryRequest, *conversation.Conversation, bool) {
+	claims := claimsFromCtx(r.Context())
+
+	var body struct {
+		queryBody
+		Route            bool   `json:"route"`
+		IncludeSummaries bool   `json:"include_summaries"`
+		AssistantID      string `json:"assistant_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return retrieval.QueryRequest{}, nil, false
+	}
+	if body.Route && body.AssistantID != "" {
+		writeError(w, http.StatusBadRequest, "route and assistant_id can't be combined")
+		return retrieval.QueryRequest{}, nil, false
+	}
+	req, ok := h.queryRequest(w, r, &body.queryBody)
+	if !ok {
+		return retrieval.QueryRequest{}, nil, false
+	}
+	req.IncludeSummaries = body.IncludeSummaries
+
+	if body.Route {
+		d, err := h.deps.QueryRouter.Route(r.Context(), claims.OrgID, body.Question)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to route query")
+			return retrieval.QueryRequest{}, nil, false
+		}
+		routed := "default"
+		if d.Assistant != nil {
+			d.Assistant.Apply(&req)
+			routed = d.Assistant.ID
+		}
+		w.Header().Set("X-Routed-Assistant", routed)
+	}
+	if body.AssistantID != "" {
+		a, err := h.deps.AssistantService.Get(r.Context(), body.AssistantID, claims.OrgID)
+		if err != nil {
+			writeAssistantError(w, err, "failed to load assistant")
+			return retrieval.QueryRequest{}, nil, false
+		}
+		a.Apply(&req)
+	}
+	// A model asked for outranks the assistant's.
+	if body.Model != "" {
+		req.Model = body.Model
 	}
 
+	conv, ok := h.loadHistory(w, r, body.ConversationID, &req)
+	if !ok {
+		return retrieval.QueryRequest{}, nil, false
+	}
+	return req, conv, true
+}
+
+// streamQuery runs a RAG query and relays the answer as typed SSE events
+// with JSON data (see writeSSE and relayQuery).
+func (h *handlers) streamQuery(w http.ResponseWriter, r *http.Request, req retrieval.QueryRequest, conv *conversation.Conversation) {
 	// Set SSE headers
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no") // Disable Nginx buffering
+	// The client can read back the answer with this should the stream
+	// break (see getQuery).
+	req.QueryID = uuid.NewString()
+	w.Header().Set("X-Query-ID", req.QueryID)
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
 		writeError(w, http.StatusInternalServerError, "streaming not supported")
 		return
 	}
 
+	_, err := h.relayQuery(r.Context(), req, conv, func(event string, payload map[string]any) {
+		writeSSE(w, event, payload)
+		_ = rc.Flush()
+	})
+	// If context was cancelled (client disconnected), that's fine
+	if err != nil && r.Context().Err() == nil {
+		h.deps.Logger.Error("RAG query error", "error", err)
+	}
+}
+
+// retrieved is what QueryWithSources hands its sources callback.
+type retrieved struct {
+	sources  []retrieval.Source
+	degraded retrieval.Degradation
+}
+
+// relayQuery runs a RAG query and passes the answer to emit as typed
+// events, the same over SSE and WebSocket: one "sources" event with the
+// retrieved passages (and "degraded" if the retrieval budget cut them
+// short), a "token" event per token, a "usage" event with the tokens the
+// request consumed and what they cost, then "done" with the usage again
+// and the latency (preceded by "error" if the query failed, and marked
+// "cancelled" if ctx ended first). A "provider_switch" event among the
+// tokens reports a fallback to another LLM; with "restart" set, the tokens
+// before it are superseded. When nothing relevant was retrieved, a
+// "no_context" event follows the empty sources and the tokens carry
+// retrieval.NoContextAnswer. With a conversation, the question and full
+// answer are appended to it once the answer completes.
+func (h *handlers) relayQuery(ctx context.Context, req retrieval.QueryRequest, conv *conversation.Conversation, emit func(event string, payload map[string]any)) (string, error) {
+	askedAt := time.Now()
+	qctx, tally := usage.WithTally(ctx)
+	if req.QueryID == "" {
+		req.QueryID = uuid.NewString()
+	}
+	saver := h.answerSaver(req)
 	out := make(chan string, 64)
+	sourcesc := make(chan retrieved, 1)
+	switches := make(chan providerSwitch)
+	errc := make(chan error, 1)
+	cached, smallTalk := false, false
 
+	req.OnProviderSwitch = switchesTo(switches)
+	req.OnCacheHit = func() { cached = true }
+	req.OnSmallTalk = func() { smallTalk = true }
 	go func() {
-		if err := h.deps.RAGService.Query(r.Context(), retrieval.QueryRequest{
-			OrgID:    claims.OrgID,
-			Question: body.Question,
-			TopK:     body.TopK,
-		}, out); err != nil {
-			// If context was cancelled (client disconnected), that's fine
-			if r.Context().Err() == nil {
-				h.deps.Logger.Error("RAG query error", "error", err)
+		errc <- h.deps.RAGService.QueryWithSources(qctx, req, func(sources []retrieval.Source, degraded retrieval.Degradation) {
+			sourcesc <- retrieved{sources, degraded}
+		}, out)
+	}()
+
+	// Sources are handed over before the first token, so checking for them
+	// ahead of each token keeps the sources event first on the wire.
+	sendSources := func(r retrieved) {
+		payload := map[string]any{"sources": r.sources, "query_id": req.QueryID}
+		if r.degraded != "" {
+			payload["degraded"] = r.degraded
+		}
+		emit("sources", payload)
+		if len(r.sources) == 0 && !smallTalk {
+			emit("no_context", map[string]any{"answer": retrieval.NoContextAnswer})
+		}
+	}
+
+	var answer strings.Builder
+	var firstToken time.Duration
+	sendToken := func(token string) {
+		select {
+		case sources := <-sourcesc:
+			sendSources(sources)
+		default:
+		}
+		if firstToken == 0 {
+			firstToken = time.Since(askedAt)
+		}
+		answer.WriteString(token)
+		saver.Update(ctx, answer.String())
+		emit("token", map[string]any{"content": token})
+	}
+stream:
+	for {
+		select {
+		case sources := <-sourcesc:
+			sendSources(sources)
+		case sw := <-switches:
+			drainTokens(out, sendToken)
+			if sw.Restart {
+				answer.Reset()
+			}
+			emit("provider_switch", sw.payload())
+			close(sw.handled)
+		case token, ok := <-out:
+			if !ok {
+				break stream
 			}
+			sendToken(token)
 		}
-	}()
+	}
+	// With no tokens to go ahead of, the sources may still be waiting when
+	// the answer ends; they go out all the same.
+	select {
+	case sources := <-sourcesc:
+		sendSources(sources)
+	default:
+	}
 
-	for token := range out {
-		// SSE format: "data: <content>\n\n"
-		payload := strings.ReplaceAll(token, "\n", "\\n") // escape newlines in token
-		fmt.Fprintf(w, "data: %s\n\n", payload)
-		flusher.Flush()
+	err := <-errc
+	saver.Finish(ctx, answer.String(), answerStatus(ctx, err))
+	if err == nil {
+		h.recordTurn(ctx, conv, req.Question, answer.String(), askedAt)
+	} else if errors.Is(err, retrieval.ErrPromptTooLarge) {
+		emit("error", map[string]any{"error": err.Error(), "status": http.StatusBadRequest})
+	} else if ctx.Err() == nil {
+		emit("error", map[string]any{"error": "query failed"})
 	}
 
+	spent := tally.Usage()
+	emit("usage", map[string]any{"usage": spent})
+
 	// Signal end of stream
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	done := map[string]any{
+		"query_id":       req.QueryID,
+		"usage":          spent,
+		"latency_ms":     time.Since(askedAt).Milliseconds(),
+		"first_token_ms": firstToken.Milliseconds(),
+	}
+	if cached {
+		done["cached"] = true
+	}
+	if smallTalk {
+		done["small_talk"] = true
+	}
+	if ctx.Err() != nil {
+		done["cancelled"] = true
+	}
+	emit("done", done)
+	return answer.String(), err
+}
+
+// writeSSE writes one server-sent event whose data is payload as JSON,
+// with "type" set to event so clients that only read data lines can tell
+// events apart. JSON keeps newlines in tokens escaped, so answers (code
+// blocks included) arrive exactly as generated.
+func writeSSE(w io.Writer, event string, payload map[string]any) {
+	payload["type"] = event
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
 }
 
 // querySync is a non-streaming endpoint for testing/simple clients.
 func (h *handlers) querySync(w http.ResponseWriter, r *http.Request) {
-	claims := claimsFromCtx(r.Context())
-
-	var body struct {
-		Question string `json:"question"`
-		TopK     int    `json:"top_k"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
+	req, conv, ok := h.decodeQuery(w, r)
+	if !ok {
 		return
 	}
+	h.answerQuery(w, r, req, conv)
+}
 
+// answerQuery runs a RAG query and writes the full answer as JSON, then
+// records the turn if the query belongs to a conversation. no_context
+// marks the fixed answer given when nothing relevant was retrieved,
+// degraded a retrieval cut short by its latency budget, provider_switches
+// the fallbacks to other LLMs, cached an answer from the answer cache,
+// small_talk a reply to small talk, and usage is what the query consumed.
+func (h *handlers) answerQuery(w http.ResponseWriter, r *http.Request, req retrieval.QueryRequest, conv *conversation.Conversation) {
+	askedAt := time.Now()
+	qctx, tally := usage.WithTally(r.Context())
+	req.QueryID = uuid.NewString()
+	w.Header().Set("X-Query-ID", req.QueryID)
+	saver := h.answerSaver(req)
 	out := make(chan string, 256)
+	switches := make(chan providerSwitch)
+	errc := make(chan error, 1)
 	var sb strings.Builder
+	var sources []retrieval.Source
+	var degraded retrieval.Degradation
+	var switched []retrieval.ProviderSwitch
+	cached, smallTalk := false, false
 
+	req.OnProviderSwitch = switchesTo(switches)
+	req.OnCacheHit = func() { cached = true }
+	req.OnSmallTalk = func() { smallTalk = true }
 	go func() {
-		_ = h.deps.RAGService.Query(r.Context(), retrieval.QueryRequest{
-			OrgID:    claims.OrgID,
-			Question: body.Question,
-			TopK:     body.TopK,
+		errc <- h.deps.RAGService.QueryWithSources(qctx, req, func(s []retrieval.Source, d retrieval.Degradation) {
+			sources, degraded = s, d
 		}, out)
 	}()
 
-	for token := range out {
+	writeToken := func(token string) {
 		sb.WriteString(token)
+		saver.Update(r.Context(), sb.String())
+	}
+read:
+	for {
+		select {
+		case sw := <-switches:
+			drainTokens(out, writeToken)
+			if sw.Restart {
+				sb.Reset()
+			}
+			switched = append(switched, sw.ProviderSwitch)
+			close(sw.handled)
+		case token, ok := <-out:
+			if !ok {
+				break read
+			}
+			writeToken(token)
+		}
+	}
+	err := <-errc
+	saver.Finish(r.Context(), sb.String(), answerStatus(r.Context(), err))
+	if errors.Is(err, retrieval.ErrPromptTooLarge) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == nil {
+		h.recordTurn(r.Context(), conv, req.Question, sb.String(), askedAt)
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"answer": sb.String()})
+	noContext := sources != nil && len(sources) == 0 && !smallTalk
+	if sources == nil {
+		sources = []retrieval.Source{}
+	}
+	resp := map[string]any{"query_id": req.QueryID, "answer": sb.String(), "sources": sources, "no_context": noContext, "usage": tally.Usage()}
+	if degraded != "" {
+		resp["degraded"] = degraded
+	}
+	if len(switched) > 0 {
+		resp["provider_switches"] = switched
+	}
+	if cached {
+		resp["cached"] = true
+	}
+	if smallTalk {
+		resp["small_talk"] = true
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 //  Middleware
 
+// authMiddleware accepts either a user JWT (Authorization: Bearer <jwt>) or
+// an org API key (X-API-Key: rk_..., or as the bearer token) for
+// machine-to-machine clients. WebSocket handshakes may pass either as
+// ?access_token= instead.
 func (h *handlers) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			writeError(w, http.StatusUnauthorized, "missing bearer token")
-			return
+		credential := r.Header.Get("X-API-Key")
+		if credential == "" {
+			authHeader := r.Header.Get("Authorization")
+			switch {
+			case strings.HasPrefix(authHeader, "Bearer "):
+				credential = strings.TrimPrefix(authHeader, "Bearer ")
+			case websocket.IsUpgrade(r) && r.URL.Query().Get("access_token") != "":
+				// Browsers can't set headers on a WebSocket handshake.
+				credential = r.URL.Query().Get("access_token")
+			default:
+				writeError(w, http.StatusUnauthorized, "missing bearer token or api key")
+				return
+			}
 		}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := h.deps.JWTManager.Verify(token)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "invalid or expired token")
-			return
+		var claims *auth.Claims
+		if apikey.IsKey(credential) {
+			key, err := h.deps.APIKeyService.Verify(r.Context(), credential)
+			if errors.Is(err, apikey.ErrInvalid) {
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to verify api key")
+				return
+			}
+			claims = &auth.Claims{OrgID: key.OrgID, Role: key.Role, APIKeyID: key.ID}
+		} else {
+			var err error
+			claims, err = h.deps.JWTManager.Verify(credential)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, "invalid or expired token")
+				return
+			}
+			// A password or role change since the token was issued revokes it.
+			err = h.deps.TenantService.CheckToken(r.Context(), claims)
+			if errors.Is(err, tenant.ErrTokenRevoked) {
+				writeError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if errors.Is(err, tenant.ErrOrgSuspended) {
+				writeError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to verify token")
+				return
+			}
+			if claims.Impersonator != "" && !h.auditImpersonated(w, r, claims) {
+				return
+			}
 		}
 
-		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		// Content queries route to the org's own storage when it has one;
+		// metered usage is attributed to the caller.
+		ctx := tenancy.WithOrg(context.WithValue(r.Context(), claimsKey, claims), claims.OrgID)
+		ctx = tenancy.WithActor(ctx, claims.Actor())
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -276,6 +1197,41 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, map[string]string{"error": msg})
 }
 
+// setETag exposes a resource version as a strong ETag, e.g. "3".
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// requireIfMatch parses the If-Match header into a version, writing a 428
+// when it is missing and a 400 when it is malformed. "*" matches any version.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	raw := strings.TrimSpace(r.Header.Get("If-Match"))
+	if raw == "" {
+		writeError(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return 0, false
+	}
+	if raw == "*" {
+		return document.AnyVersion, true
+	}
+
+	raw = strings.Trim(strings.TrimPrefix(raw, "W/"), `"`)
+	version, err := strconv.Atoi(raw)
+	if err != nil || version <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid If-Match header")
+		return 0, false
+	}
+	return version, true
+}
+
+// requireAdmin writes a 403 unless the caller holds the admin role.
+func requireAdmin(w http.ResponseWriter, claims *auth.Claims) bool {
+	if claims.Role != tenant.RoleAdmin && !claims.PolicyApproved {
+		writeError(w, http.StatusForbidden, "admin role required")
+		return false
+	}
+	return true
+}
+
 func claimsFromCtx(ctx context.Context) *auth.Claims {
 	c, _ := ctx.Value(claimsKey).(*auth.Claims)
 	return c
@@ -290,3 +1246,9 @@ func (rw *responseWriter) WriteHeader(status int) {
 	rw.status = status
 	rw.ResponseWriter.WriteHeader(status)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// streams and hijack WebSocket connections.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/authorizer"
+	"github.com/pixell07/multi-tenant-ai/internal/sharing"
+)
+
+// Cross-org sharing handlers
+
+func (h *handlers) listShares(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	outgoing, err := h.deps.SharingService.ListOutgoing(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list shares")
+		return
+	}
+	incoming, err := h.deps.SharingService.ListIncoming(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list shares")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"outgoing": outgoing, "incoming": incoming})
+}
+
+func (h *handlers) createShare(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req sharing.ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if (req.DocumentID == "") == (req.CollectionID == "") || req.GranteeOrgID == "" {
+		writeError(w, http.StatusBadRequest, "one of document_id and collection_id, and grantee_org_id are required")
+		return
+	}
+	// Grants and shared retrieval work on the shared tables only.
+	if h.deps.Tenancy.Isolated(claims.OrgID) || h.deps.Tenancy.Isolated(req.GranteeOrgID) {
+		writeError(w, http.StatusBadRequest, "organizations with isolated storage can't share documents")
+		return
+	}
+	action, resource := authorizer.ActionDocumentShare, authorizer.Resource{Type: "document", ID: req.DocumentID}
+	if req.CollectionID != "" {
+		action, resource = authorizer.ActionCollectionShare, authorizer.Resource{Type: "collection", ID: req.CollectionID}
+	}
+	if !h.authorize(w, r, claims, action, resource, map[string]string{"grantee_org_id": req.GranteeOrgID}) {
+		return
+	}
+
+	g, err := h.deps.SharingService.Share(r.Context(), claims.OrgID, claims.Actor(), req)
+	switch {
+	case errors.Is(err, sharing.ErrSelfGrant), errors.Is(err, sharing.ErrInvalidTarget):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, sharing.ErrDuplicate):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to share")
+	default:
+		writeJSON(w, http.StatusCreated, g)
+	}
+}
+
+func (h *handlers) revokeShare(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.SharingService.Revoke(r.Context(), r.PathValue("id"), claims.OrgID)
+	switch {
+	case errors.Is(err, sharing.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to revoke share")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/auth/oidc"
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+)
+
+// Single sign-on handlers. A login starts with the user's email and
+// returns the IdP URL to send the browser to; the page at SSO_REDIRECT_URL
+// posts the code and state the IdP hands back to the callback, which
+// answers like login.
+
+func (h *handlers) startSSO(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	authz, err := h.deps.SSOService.Start(r.Context(), body.Email)
+	switch {
+	case errors.Is(err, oidc.ErrNoProvider):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("sso start failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to start sso login")
+	default:
+		writeJSON(w, http.StatusOK, authz)
+	}
+}
+
+func (h *handlers) finishSSO(w http.ResponseWriter, r *http.Request) {
+	var req oidc.CallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	id, err := h.deps.SSOService.Finish(r.Context(), req)
+	switch {
+	case errors.Is(err, oidc.ErrInvalidState):
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	case errors.Is(err, oidc.ErrIdentity):
+		// The detail names what didn't check out; keep it in the logs.
+		h.deps.Logger.Warn("sso login refused", "error", err)
+		writeError(w, http.StatusUnauthorized, oidc.ErrIdentity.Error())
+		return
+	case err != nil:
+		h.deps.Logger.Error("sso login failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to complete sso login")
+		return
+	}
+
+	resp, err := h.deps.TenantService.LoginSSO(r.Context(), id.OrgID, id.Email, id.DefaultRole)
+	switch {
+	case errors.Is(err, tenant.ErrEmailTaken):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, tenant.ErrUserDeactivated), errors.Is(err, tenant.ErrOrgSuspended):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("sso login failed", "org_id", id.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to complete sso login")
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// getSSOProvider returns the org's identity provider. Admin only.
+func (h *handlers) getSSOProvider(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	p, err := h.deps.SSOService.Get(r.Context(), claims.OrgID)
+	if err != nil {
+		writeSSOError(w, err, "failed to load sso provider")
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// setSSOProvider creates or replaces the org's identity provider. Admin
+// only, and not with an API key: whoever controls the IdP controls every
+// login.
+func (h *handlers) setSSOProvider(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "sso cannot be configured with an api key")
+		return
+	}
+
+	var req oidc.ProviderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	p, err := h.deps.SSOService.Set(r.Context(), claims.OrgID, req)
+	if err != nil {
+		writeSSOError(w, err, "failed to save sso provider")
+		return
+	}
+	h.deps.Logger.Info("sso provider set", "org_id", claims.OrgID, "issuer", p.Issuer, "actor", claims.Actor())
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *handlers) deleteSSOProvider(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "sso cannot be configured with an api key")
+		return
+	}
+
+	if err := h.deps.SSOService.Delete(r.Context(), claims.OrgID); err != nil {
+		writeSSOError(w, err, "failed to delete sso provider")
+		return
+	}
+	h.deps.Logger.Info("sso provider deleted", "org_id", claims.OrgID, "actor", claims.Actor())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifySSODomain verifies a domain the org claims through its DNS TXT
+// record; until then the domain routes no logins. Admin only.
+func (h *handlers) verifySSODomain(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "sso cannot be configured with an api key")
+		return
+	}
+
+	d, err := h.deps.SSOService.VerifyDomain(r.Context(), claims.OrgID, r.PathValue("domain"))
+	if err != nil {
+		writeSSOError(w, err, "failed to verify domain")
+		return
+	}
+	h.deps.Logger.Info("sso domain verified", "org_id", claims.OrgID, "domain", d.Domain, "actor", claims.Actor())
+	writeJSON(w, http.StatusOK, d)
+}
+
+func writeSSOError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, oidc.ErrNotConfigured), errors.Is(err, oidc.ErrDomainNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, oidc.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, oidc.ErrDomainTaken), errors.Is(err, oidc.ErrDomainUnverified):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
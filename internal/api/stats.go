@@ -0,0 +1,33 @@
+package api
+
+import "net/http"
+
+// Usage statistics
+
+// orgStats reports the caller's org usage: documents by status and the
+// vector count and storage behind them.
+func (h *handlers) orgStats(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	st, err := h.deps.DocumentService.Stats(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// usage lists every org's vector storage for billing. Operator only.
+func (h *handlers) usage(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+
+	orgs, err := h.deps.DocumentService.Usage(r.Context())
+	if err != nil {
+		h.deps.Logger.Error("load vector usage failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orgs": orgs, "count": len(orgs)})
+}
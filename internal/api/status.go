@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pixell07/multi-tenant-ai/internal/slo"
+)
+
+// status serves the public status page data: query and ingestion success
+// rates and latencies against their objectives, and whether the database
+// is reachable. It is computed at most every few seconds however often it
+// is polled, and always answers 200; the body says how things are.
+func (h *handlers) status(w http.ResponseWriter, r *http.Request) {
+	st := *h.deps.Status.Status(r.Context())
+	// Maintenance is an operator's switch, not a measurement, so it shows
+	// at once rather than after the cache expires.
+	if h.deps.Maintenance.Enabled() && st.Status != slo.StatusOutage {
+		st.Status = slo.StatusMaintenance
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(slo.CacheTTL.Seconds())))
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Tenant storage placement. Moving an org to its own schema or database is
+// a deployment decision (it needs DDL rights, and for database mode a
+// DSN in the environment), so it is an operator route, not an org admin
+// one. Only orgs without content yet can be moved.
+
+func (h *handlers) getOrgStorage(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.deps.Tenancy.Placement(r.PathValue("id")))
+}
+
+// setOrgStorage provisions isolated storage:
+// {"mode": "schema"} or {"mode": "database", "database_env": "TENANT_DB_ACME"}.
+func (h *handlers) setOrgStorage(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+
+	var body struct {
+		Mode        tenancy.Mode `json:"mode"`
+		DatabaseEnv string       `json:"database_env"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	orgID := r.PathValue("id")
+	p, err := h.deps.Tenancy.Provision(r.Context(), orgID, tenancy.Placement{Mode: body.Mode, DatabaseEnv: body.DatabaseEnv})
+	switch {
+	case errors.Is(err, tenancy.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tenancy.ErrOrgNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, tenancy.ErrHasData), errors.Is(err, tenancy.ErrAlreadyIsolated):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("provision tenant storage failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to provision storage")
+	default:
+		h.deps.Logger.Info("tenant storage provisioned", "org_id", orgID, "mode", p.Mode)
+		writeJSON(w, http.StatusOK, p)
+	}
+}
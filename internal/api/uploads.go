@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+)
+
+// Direct uploads. Files too large to send through the API are PUT straight
+// to object storage: POST /api/v1/documents/uploads with {name, tags,
+// metadata} answers with the new document and a presigned upload URL, and
+// once the PUT has finished, POST /api/v1/documents/{id}/complete queues
+// the document for ingestion like a regular upload.
+
+func (h *handlers) startUpload(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name     string         `json:"name"`
+		Tags     []string       `json:"tags"`
+		Metadata map[string]any `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	doc, target, err := h.deps.DocumentService.StartUpload(r.Context(), document.UploadRequest{
+		OrgID:    claimsFromCtx(r.Context()).OrgID,
+		Name:     body.Name,
+		Tags:     body.Tags,
+		Metadata: body.Metadata,
+	})
+	switch {
+	case errors.Is(err, document.ErrUploadsDisabled):
+		writeError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, document.ErrInvalidLabels):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("start upload failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to start upload")
+	default:
+		setETag(w, doc.Version)
+		writeJSON(w, http.StatusCreated, map[string]any{"document": doc, "upload": target})
+	}
+}
+
+func (h *handlers) completeUpload(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.deps.DocumentService.CompleteUpload(r.Context(), r.PathValue("id"), claimsFromCtx(r.Context()).OrgID)
+	switch {
+	case errors.Is(err, document.ErrUploadsDisabled):
+		writeError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, document.ErrNotUploading), errors.Is(err, document.ErrUploadMissing):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, document.ErrUploadTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case err != nil:
+		writeDocumentError(w, err, "failed to complete upload")
+	default:
+		setETag(w, doc.Version)
+		writeJSON(w, http.StatusAccepted, doc)
+	}
+}
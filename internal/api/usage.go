@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+// Usage metering and quotas. Orgs read their own usage; quotas are set by
+// the operator, since they follow from what a customer pays for, and
+// budgets by the org's admins, since they limit what the org pays.
+
+// orgUsage reports the caller's org usage in the current month with its
+// quota.
+func (h *handlers) orgUsage(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	u, err := h.deps.UsageService.Usage(r.Context(), claims.OrgID)
+	if err != nil {
+		h.deps.Logger.Error("load usage failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// usageBreakdown splits the caller's org usage in a month (?period=YYYY-MM,
+// the current one by default) by user or API key (?by=user, the default)
+// or by workspace (?by=workspace). Admin only.
+func (h *handlers) usageBreakdown(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	q := r.URL.Query()
+	by := q.Get("by")
+	if by == "" {
+		by = usage.ByUser
+	}
+	var p time.Time
+	if s := q.Get("period"); s != "" {
+		var err error
+		if p, err = usage.ParsePeriod(s); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	list, err := h.deps.UsageService.Breakdown(r.Context(), claims.OrgID, p, by)
+	switch {
+	case errors.Is(err, usage.ErrInvalidBreakdown):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("usage breakdown failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to load usage breakdown")
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"breakdown": list, "count": len(list)})
+	}
+}
+
+// estimateDocument predicts the tokens and cost of uploading a document,
+// taking the same body as an upload, without storing anything.
+func (h *handlers) estimateDocument(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	req, ok := decodeUpload(w, r)
+	if !ok {
+		return
+	}
+
+	in, err := h.deps.DocumentService.Estimate(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	est, err := h.deps.UsageService.Estimate(r.Context(), claims.OrgID, in)
+	if err != nil {
+		h.deps.Logger.Error("estimate failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to estimate document")
+		return
+	}
+	writeJSON(w, http.StatusOK, est)
+}
+
+// getBudget returns the caller's org budget. Admin only.
+func (h *handlers) getBudget(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	b, err := h.deps.UsageService.Budget(r.Context(), claims.OrgID)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to load budget")
+	case b == nil:
+		writeError(w, http.StatusNotFound, usage.ErrNoBudget.Error())
+	default:
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+// setBudget replaces the caller's org monthly budget. Admin only.
+func (h *handlers) setBudget(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var b usage.Budget
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	err := h.deps.UsageService.SetBudget(r.Context(), claims.OrgID, &b)
+	switch {
+	case errors.Is(err, usage.ErrInvalidBudget):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("set budget failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to set budget")
+	default:
+		h.deps.Logger.Info("org budget set", "org_id", claims.OrgID, "cap_usd", b.MonthlyCapUSD, "actor", claims.Actor())
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+func (h *handlers) deleteBudget(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	err := h.deps.UsageService.DeleteBudget(r.Context(), claims.OrgID)
+	switch {
+	case errors.Is(err, usage.ErrNoBudget):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to delete budget")
+	default:
+		h.deps.Logger.Info("org budget deleted", "org_id", claims.OrgID, "actor", claims.Actor())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *handlers) getOrgQuota(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+	q, err := h.deps.UsageService.Quota(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load quota")
+		return
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+// setOrgQuota replaces an org's quota; omitted or null limits are
+// unlimited.
+func (h *handlers) setOrgQuota(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOperator(w, r) {
+		return
+	}
+
+	var q usage.Quota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	orgID := r.PathValue("id")
+	err := h.deps.UsageService.SetQuota(r.Context(), orgID, &q)
+	switch {
+	case errors.Is(err, usage.ErrInvalidQuota):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, usage.ErrOrgNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("set quota failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to set quota")
+	default:
+		h.deps.Logger.Info("org quota set", "org_id", orgID)
+		writeJSON(w, http.StatusOK, q)
+	}
+}
+
+// withinQuota guards a route that consumes kinds for the caller's org.
+func (h *handlers) withinQuota(next http.HandlerFunc, kinds ...usage.Kind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.checkQuota(w, r, claimsFromCtx(r.Context()).OrgID, kinds...) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkQuota answers and returns false if orgID has used up the quota of
+// any of kinds: 402 for documents and storage, which only more paid
+// capacity or deleting content frees, and 429 with Retry-After for the
+// monthly token quotas. A spent budget is a 402 with Retry-After: raising
+// it helps at once, otherwise the next month does. Exhausted prepaid
+// credits are a 402 too; only a new grant frees them.
+func (h *handlers) checkQuota(w http.ResponseWriter, r *http.Request, orgID string, kinds ...usage.Kind) bool {
+	return h.quotaAllows(w, orgID, h.deps.UsageService.Check(r.Context(), orgID, kinds...))
+}
+
+// quotaAllows answers err from a quota check as checkQuota describes and
+// returns whether it is nil.
+func (h *handlers) quotaAllows(w http.ResponseWriter, orgID string, err error) bool {
+	if err == nil {
+		return true
+	}
+	var be *usage.BudgetError
+	if errors.As(err, &be) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(be.ResetAt).Seconds())+1))
+		writeError(w, http.StatusPaymentRequired, be.Error())
+		return false
+	}
+	if errors.Is(err, usage.ErrCreditsExhausted) {
+		writeError(w, http.StatusPaymentRequired, err.Error())
+		return false
+	}
+	var qe *usage.QuotaError
+	if !errors.As(err, &qe) {
+		h.deps.Logger.Error("quota check failed", "org_id", orgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to check quota")
+		return false
+	}
+	if qe.ResetAt.IsZero() {
+		writeError(w, http.StatusPaymentRequired, qe.Error())
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(qe.ResetAt).Seconds())+1))
+	writeError(w, http.StatusTooManyRequests, qe.Error())
+	return false
+}
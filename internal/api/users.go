@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pixell07/multi-tenant-ai/internal/tenant"
+)
+
+// User / membership handlers
+
+func (h *handlers) listUsers(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+
+	users, err := h.deps.TenantService.ListUsers(r.Context(), claims.OrgID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": users, "count": len(users)})
+}
+
+// inviteUser adds an invited user and emails them a one-time token to
+// accept the invitation with, or returns the token when email isn't
+// configured. Admin only.
+func (h *handlers) inviteUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req tenant.InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	inv, err := h.deps.TenantService.Invite(r.Context(), claims.OrgID, claims.Actor(), req)
+	if err != nil {
+		writeMemberError(w, err, "failed to invite user")
+		return
+	}
+	h.deps.Logger.Info("user invited", "org_id", claims.OrgID, "user_id", inv.User.ID, "actor", claims.Actor())
+	writeJSON(w, http.StatusCreated, inv)
+}
+
+// acceptInvite sets an invited user's password and logs them in.
+func (h *handlers) acceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req tenant.AcceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	resp, err := h.deps.TenantService.AcceptInvite(r.Context(), req)
+	switch {
+	case errors.Is(err, tenant.ErrInvalidInvite):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, tenant.ErrSSORequired), errors.Is(err, tenant.ErrOrgSuspended):
+		writeError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// updateUser changes a user's role. Admin only.
+func (h *handlers) updateUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := h.deps.TenantService.SetRole(r.Context(), claims.OrgID, claims.UserID, r.PathValue("id"), body.Role)
+	if err != nil {
+		writeMemberError(w, err, "failed to update user")
+		return
+	}
+	h.deps.Logger.Info("user role changed", "org_id", claims.OrgID, "user_id", user.ID, "role", user.Role, "actor", claims.Actor())
+	writeJSON(w, http.StatusOK, user)
+}
+
+// removeUser deletes a user from the org. Admin only.
+func (h *handlers) removeUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	userID := r.PathValue("id")
+	if err := h.deps.TenantService.RemoveUser(r.Context(), claims.OrgID, claims.UserID, userID); err != nil {
+		writeMemberError(w, err, "failed to remove user")
+		return
+	}
+	h.deps.Logger.Info("user removed", "org_id", claims.OrgID, "user_id", userID, "actor", claims.Actor())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeMemberError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, tenant.ErrInvalidRole), errors.Is(err, tenant.ErrInvalidEmail),
+		errors.Is(err, tenant.ErrSelfChange):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tenant.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, tenant.ErrAlreadyMember), errors.Is(err, tenant.ErrEmailTaken),
+		errors.Is(err, tenant.ErrLastAdmin):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// syncUsers reconciles org membership to a desired-state list, for tenants
+// syncing from an HR system. Admin only.
+func (h *handlers) syncUsers(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+
+	var req tenant.SyncMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	res, err := h.deps.TenantService.SyncMembers(r.Context(), claims.OrgID, claims.Actor(), req)
+	if err != nil {
+		h.deps.Logger.Error("member sync failed", "org_id", claims.OrgID, "error", err)
+		writeMemberError(w, err, "failed to sync users")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// deleteOrg permanently deletes the caller's org and everything in it.
+// Admin only, and not with an API key: a leaked key must not be able to
+// wipe the tenant. The body repeats the org name: {"confirm": "Acme"}.
+func (h *handlers) deleteOrg(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromCtx(r.Context())
+	if !requireAdmin(w, claims) {
+		return
+	}
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "an org cannot be deleted with an api key")
+		return
+	}
+
+	var body struct {
+		Confirm string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	purged, err := h.deps.TenantService.DeleteOrg(r.Context(), claims.OrgID, body.Confirm)
+	switch {
+	case errors.Is(err, tenant.ErrConfirmMismatch):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tenant.ErrOrgNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		h.deps.Logger.Error("org deletion failed", "org_id", claims.OrgID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete organization")
+	default:
+		h.deps.Logger.Info("org deleted", "org_id", claims.OrgID, "actor", claims.Actor(), "vectors", purged)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The widget is a single dependency-free script tenants drop into their
+// site:
+//
+//	<script src="https://<api-host>/widget/widget.js" data-token="pk_..." async></script>
+//
+// It fetches /widget/config.json for its token and talks to the public
+// query endpoint, so everything it can do is bounded by the public site's
+// origin allow-list and rate limits.
+
+// Config lookups happen on every page view of the tenant's site, so they
+// get a looser limit than queries.
+const (
+	widgetConfigsPerMinute = 60
+	widgetConfigBurst      = 20
+)
+
+//go:embed widget/widget.js
+var widgetJS []byte
+
+func (h *handlers) widgetScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(widgetJS)
+}
+
+// widgetConfig returns the per-token settings the widget needs to render.
+func (h *handlers) widgetConfig(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if !h.widgetLimits.limit(w, clientIP(r)) {
+		return
+	}
+
+	site, ok := h.resolvePublicSite(w, r, token)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":      site.Name,
+		"query_url": "/api/v1/public/" + site.Token + "/query",
+	})
+}
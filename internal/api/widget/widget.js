@@ -0,0 +1,94 @@
+// Embeddable knowledge-base chat widget.
+// Usage: <script src="https://<api-host>/widget/widget.js" data-token="pk_..." async></script>
+(function () {
+  "use strict";
+
+  var script = document.currentScript;
+  if (!script) return;
+  var token = script.getAttribute("data-token");
+  if (!token) {
+    console.error("[rag-widget] missing data-token attribute");
+    return;
+  }
+  var apiBase = new URL(script.src).origin;
+
+  function el(tag, style, text) {
+    var e = document.createElement(tag);
+    if (style) e.style.cssText = style;
+    if (text) e.textContent = text;
+    return e;
+  }
+
+  fetch(apiBase + "/widget/config.json?token=" + encodeURIComponent(token))
+    .then(function (res) {
+      if (!res.ok) throw new Error("config request failed: " + res.status);
+      return res.json();
+    })
+    .then(render)
+    .catch(function (err) {
+      console.error("[rag-widget]", err);
+    });
+
+  function render(cfg) {
+    var button = el("button",
+      "position:fixed;bottom:20px;right:20px;z-index:2147483000;padding:12px 16px;" +
+      "border:none;border-radius:24px;background:#111;color:#fff;font:14px sans-serif;cursor:pointer",
+      cfg.name || "Ask");
+
+    var panel = el("div",
+      "position:fixed;bottom:72px;right:20px;z-index:2147483000;width:340px;max-height:460px;" +
+      "display:none;flex-direction:column;background:#fff;border:1px solid #ddd;border-radius:8px;" +
+      "box-shadow:0 4px 16px rgba(0,0,0,.15);font:14px sans-serif");
+    var log = el("div", "flex:1;overflow-y:auto;padding:12px");
+    var form = el("form", "display:flex;border-top:1px solid #eee");
+    var input = el("input", "flex:1;padding:10px;border:none;outline:none;font:inherit");
+    input.placeholder = "Ask a question...";
+    input.maxLength = 1000;
+    var send = el("button", "padding:0 14px;border:none;background:none;cursor:pointer;font:inherit", "Send");
+
+    form.appendChild(input);
+    form.appendChild(send);
+    panel.appendChild(log);
+    panel.appendChild(form);
+
+    function append(who, text) {
+      var row = el("div", "margin:0 0 10px;white-space:pre-wrap;" + (who === "you" ? "color:#555" : ""));
+      row.textContent = (who === "you" ? "You: " : "") + text;
+      log.appendChild(row);
+      log.scrollTop = log.scrollHeight;
+      return row;
+    }
+
+    button.addEventListener("click", function () {
+      panel.style.display = panel.style.display === "none" ? "flex" : "none";
+      if (panel.style.display === "flex") input.focus();
+    });
+
+    form.addEventListener("submit", function (ev) {
+      ev.preventDefault();
+      var question = input.value.trim();
+      if (!question) return;
+      input.value = "";
+      append("you", question);
+      var answer = append("bot", "…");
+      send.disabled = true;
+
+      fetch(apiBase + cfg.query_url, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ question: question })
+      })
+        .then(function (res) {
+          if (res.status === 429) throw new Error("Too many questions, please wait a moment.");
+          if (!res.ok) throw new Error("Something went wrong (" + res.status + ").");
+          return res.json();
+        })
+        .then(function (data) { answer.textContent = data.answer; })
+        .catch(function (err) { answer.textContent = err.message; })
+        .then(function () { send.disabled = false; });
+    });
+
+    document.body.appendChild(panel);
+    document.body.appendChild(button);
+  }
+})();
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+	"github.com/pixell07/multi-tenant-ai/internal/websocket"
+)
+
+// WebSocket queries. GET /api/v1/query/ws offers the streaming interface of
+// POST /api/v1/query over a WebSocket, for clients behind proxies that
+// buffer or mangle SSE. The client sends JSON messages:
+//
+//	{"type": "query", "id": "q1", "question": "...", ...}  (the /query body)
+//	{"type": "cancel", "id": "q1"}
+//	{"type": "reset"}
+//
+// and receives the events /query streams (sources, no_context, token,
+// error, done), each carrying the id of the query it belongs to. One query
+// runs at a time per connection. Follow-ups without a conversation_id see
+// the connection's earlier questions and answers; "reset" forgets them.
+
+const (
+	// wsIdleTimeout closes connections the client has stopped using.
+	wsIdleTimeout = 10 * time.Minute
+	// wsHistoryTurns bounds the connection-local history replayed into a
+	// follow-up, matching what a stored conversation replays.
+	wsHistoryTurns = 10
+)
+
+type wsMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// wsSession is the state of one connection.
+type wsSession struct {
+	h    *handlers
+	conn *websocket.Conn
+	r    *http.Request
+
+	mu      sync.Mutex
+	running string             // id of the running query
+	cancel  context.CancelFunc // cancels it; nil when idle
+	history []retrieval.Turn
+	wg      sync.WaitGroup
+}
+
+func (h *handlers) queryWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r, h.deps.WebSocketOrigins)
+	if err != nil {
+		return
+	}
+	s := &wsSession{h: h, conn: conn, r: r}
+	defer func() {
+		s.cancelQuery("")
+		s.wg.Wait()
+		_ = conn.Close(websocket.CloseGoingAway, "")
+	}()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) && !errors.Is(err, io.EOF) {
+				h.deps.Logger.Debug("websocket read failed", "error", err)
+			}
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.send("error", "", map[string]any{"error": "invalid message", "status": http.StatusBadRequest})
+			continue
+		}
+		switch msg.Type {
+		case "query":
+			s.startQuery(msg.ID, data)
+		case "cancel":
+			s.cancelQuery(msg.ID)
+		case "reset":
+			s.mu.Lock()
+			s.history = nil
+			s.mu.Unlock()
+		default:
+			s.send("error", msg.ID, map[string]any{"error": `type must be "query", "cancel" or "reset"`, "status": http.StatusBadRequest})
+		}
+	}
+}
+
+// send writes one event, tagged with the query id it belongs to.
+func (s *wsSession) send(event, id string, payload map[string]any) {
+	payload["type"] = event
+	if id != "" {
+		payload["id"] = id
+	}
+	_ = s.conn.WriteJSON(payload)
+}
+
+// cancelQuery stops the running query if it has the given id (any id when
+// empty). Its "done" event reports it as cancelled.
+func (s *wsSession) cancelQuery(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil && (id == "" || id == s.running) {
+		s.cancel()
+	}
+}
+
+// startQuery runs a query message in the background so the connection
+// keeps reading cancels. It goes through the same maintenance, quota and
+// validation checks as POST /api/v1/query, against a request whose body is
+// the message; whatever they would have answered is sent as an "error"
+// event with its HTTP status.
+func (s *wsSession) startQuery(id string, body []byte) {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		s.send("error", id, map[string]any{"error": "a query is already running on this connection", "status": http.StatusConflict})
+		return
+	}
+	ctx, cancel := context.WithCancel(s.r.Context())
+	s.running, s.cancel = id, cancel
+	history := s.history
+	s.mu.Unlock()
+
+	r := s.r.Clone(ctx)
+	r.Method = http.MethodPost
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	rec := &wsRecorder{header: http.Header{}}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			s.running, s.cancel = "", nil
+			s.mu.Unlock()
+		}()
+
+		s.h.drainable(s.h.withinQuota(func(w http.ResponseWriter, r *http.Request) {
+			req, conv, ok := s.h.decodeQuery(w, r)
+			if !ok {
+				return
+			}
+			if conv == nil {
+				req.History = history
+			}
+			if routed := w.Header().Get("X-Routed-Assistant"); routed != "" {
+				s.send("routed", id, map[string]any{"assistant": routed})
+			}
+
+			answer, err := s.h.relayQuery(r.Context(), req, conv, func(event string, payload map[string]any) {
+				s.send(event, id, payload)
+			})
+			if err != nil {
+				if r.Context().Err() == nil {
+					s.h.deps.Logger.Error("RAG query error", "error", err)
+				}
+				return
+			}
+			if conv == nil {
+				s.remember(req.Question, answer)
+			}
+		}, usage.LLMTokens))(rec, r)
+
+		if rec.status != 0 {
+			s.send("error", id, rec.payload())
+		}
+	}()
+}
+
+// remember adds a turn to the connection's history.
+func (s *wsSession) remember(question, answer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history,
+		retrieval.Turn{Role: "user", Content: question},
+		retrieval.Turn{Role: "assistant", Content: answer})
+	if n := len(s.history); n > wsHistoryTurns {
+		s.history = append([]retrieval.Turn(nil), s.history[n-wsHistoryTurns:]...)
+	}
+}
+
+// wsRecorder captures the HTTP error a query's checks would have written.
+type wsRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *wsRecorder) Header() http.Header { return rec.header }
+
+func (rec *wsRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *wsRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(p)
+}
+
+// payload turns the captured response into an "error" event's fields.
+func (rec *wsRecorder) payload() map[string]any {
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rec.body.Bytes(), &body)
+	p := map[string]any{"error": body.Error, "status": rec.status}
+	if secs, err := strconv.Atoi(rec.header.Get("Retry-After")); err == nil {
+		p["retry_after_seconds"] = secs
+	}
+	return p
+}
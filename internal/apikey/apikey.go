@@ -0,0 +1,194 @@
+// Package apikey issues and verifies long-lived, org-scoped API keys for
+// machine-to-machine access. Keys are random 32-byte secrets; only their
+// SHA-256 hash is persisted, so a database leak doesn't leak usable keys.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound    = errors.New("api key not found")
+	ErrInvalid     = errors.New("invalid, expired or revoked api key")
+	ErrInvalidRole = errors.New("role must be admin or member")
+)
+
+// keyPrefix marks our keys so secret scanners and humans can recognize them.
+const keyPrefix = "rk_"
+
+type Key struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `json:"role"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const keyColumns = `id, org_id, name, prefix, role, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func scanKey(row pgx.Row) (*Key, error) {
+	k := &Key{}
+	err := row.Scan(&k.ID, &k.OrgID, &k.Name, &k.Prefix, &k.Role, &k.CreatedBy, &k.CreatedAt,
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (r *Repository) Create(ctx context.Context, k *Key, hash string) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO api_keys (id, org_id, name, prefix, role, key_hash, created_by, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		k.ID, k.OrgID, k.Name, k.Prefix, k.Role, hash, k.CreatedBy, k.CreatedAt, k.ExpiresAt,
+	)
+	return err
+}
+
+// FindActiveByHash returns the non-revoked, unexpired key with the given
+// hash, unless its org is suspended, and records the use.
+func (r *Repository) FindActiveByHash(ctx context.Context, hash string) (*Key, error) {
+	return scanKey(r.db.QueryRow(ctx,
+		`UPDATE api_keys SET last_used_at = NOW()
+		 WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		   AND NOT EXISTS (SELECT 1 FROM organizations o WHERE o.id = api_keys.org_id AND o.suspended_at IS NOT NULL)
+		 RETURNING `+keyColumns, hash))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Key, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+keyColumns+` FROM api_keys WHERE org_id = $1 ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Key, error) {
+		return scanKey(row)
+	})
+}
+
+func (r *Repository) Revoke(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND org_id = $2 AND revoked_at IS NULL`,
+		id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// MintResponse carries the plaintext key. It is returned exactly once.
+type MintResponse struct {
+	Key    string `json:"key"`
+	APIKey *Key   `json:"api_key"`
+}
+
+// MintRequest describes a key to mint. Role defaults to member; a nil
+// ExpiresAt makes a key that lives until revoked.
+type MintRequest struct {
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Mint creates a new key for orgID.
+func (s *Service) Mint(ctx context.Context, orgID, userID string, req MintRequest) (*MintResponse, error) {
+	switch req.Role {
+	case "":
+		req.Role = "member"
+	case "admin", "member":
+	default:
+		return nil, ErrInvalidRole
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	plaintext := keyPrefix + hex.EncodeToString(b)
+
+	k := &Key{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		Name:      req.Name,
+		Prefix:    plaintext[:len(keyPrefix)+8],
+		Role:      req.Role,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.repo.Create(ctx, k, hashKey(plaintext)); err != nil {
+		return nil, err
+	}
+	return &MintResponse{Key: plaintext, APIKey: k}, nil
+}
+
+// IsKey reports whether a credential looks like one of our API keys, so
+// callers accepting several credential types can route it here.
+func IsKey(credential string) bool {
+	return strings.HasPrefix(credential, keyPrefix)
+}
+
+// Verify resolves a plaintext key to its record, rejecting unknown,
+// expired and revoked keys with ErrInvalid.
+func (s *Service) Verify(ctx context.Context, plaintext string) (*Key, error) {
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		return nil, ErrInvalid
+	}
+	k, err := s.repo.FindActiveByHash(ctx, hashKey(plaintext))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalid
+	}
+	return k, err
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Key, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+func (s *Service) Revoke(ctx context.Context, id, orgID string) error {
+	return s.repo.Revoke(ctx, id, orgID)
+}
+
+// Keys are 256-bit random values, so a plain SHA-256 is sufficient;
+// a slow KDF would only add latency to every authenticated request.
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+// Package assistant manages per-org assistant profiles: named
+// configurations of persona prompt, model and document scope that are
+// queried through /api/v1/assistants/{id}/query or embedded publicly via a
+// public site bound to the assistant.
+package assistant
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+var (
+	ErrNotFound      = errors.New("assistant not found")
+	ErrDuplicateName = errors.New("an assistant with that name already exists")
+	ErrInvalid       = errors.New("name is required")
+)
+
+type Assistant struct {
+	ID           string    `json:"id"`
+	OrgID        string    `json:"org_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	SystemPrompt string    `json:"system_prompt"`
+	Model        string    `json:"model"`
+	DocumentIDs  []string  `json:"document_ids"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Apply copies the assistant's overrides onto a query.
+func (a *Assistant) Apply(req *retrieval.QueryRequest) {
+	req.SystemPrompt = a.SystemPrompt
+	req.Model = a.Model
+	req.DocumentIDs = a.DocumentIDs
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const assistantColumns = `id, org_id, name, description, system_prompt, model, document_ids, created_at, updated_at`
+
+func scanAssistant(row pgx.Row) (*Assistant, error) {
+	a := &Assistant{}
+	err := row.Scan(&a.ID, &a.OrgID, &a.Name, &a.Description, &a.SystemPrompt, &a.Model, &a.DocumentIDs, &a.CreatedAt, &a.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (r *Repository) Create(ctx context.Context, a *Assistant) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO assistants (`+assistantColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		a.ID, a.OrgID, a.Name, a.Description, a.SystemPrompt, a.Model, a.DocumentIDs, a.CreatedAt, a.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Assistant, error) {
+	return scanAssistant(r.db.QueryRow(ctx,
+		`SELECT `+assistantColumns+` FROM assistants WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Assistant, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+assistantColumns+` FROM assistants WHERE org_id = $1 ORDER BY name`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Assistant, error) {
+		return scanAssistant(row)
+	})
+}
+
+func (r *Repository) Update(ctx context.Context, a *Assistant) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE assistants SET name = $1, description = $2, system_prompt = $3, model = $4, document_ids = $5, updated_at = $6
+		 WHERE id = $7 AND org_id = $8`,
+		a.Name, a.Description, a.SystemPrompt, a.Model, a.DocumentIDs, a.UpdatedAt, a.ID, a.OrgID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM assistants WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Input is the writable part of an assistant, used for create and update.
+type Input struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"` // used by the query router
+	SystemPrompt string   `json:"system_prompt"`
+	Model        string   `json:"model"`
+	DocumentIDs  []string `json:"document_ids"`
+}
+
+func (s *Service) Create(ctx context.Context, orgID string, in Input) (*Assistant, error) {
+	if in.Name == "" {
+		return nil, ErrInvalid
+	}
+	now := time.Now()
+	a := &Assistant{
+		ID:           uuid.NewString(),
+		OrgID:        orgID,
+		Name:         in.Name,
+		Description:  in.Description,
+		SystemPrompt: in.SystemPrompt,
+		Model:        in.Model,
+		DocumentIDs:  orEmpty(in.DocumentIDs),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.repo.Create(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *Service) Get(ctx context.Context, id, orgID string) (*Assistant, error) {
+	return s.repo.Get(ctx, id, orgID)
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Assistant, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+// Update replaces the assistant's configuration with in.
+func (s *Service) Update(ctx context.Context, id, orgID string, in Input) (*Assistant, error) {
+	if in.Name == "" {
+		return nil, ErrInvalid
+	}
+	a, err := s.repo.Get(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	a.Name = in.Name
+	a.Description = in.Description
+	a.SystemPrompt = in.SystemPrompt
+	a.Model = in.Model
+	a.DocumentIDs = orEmpty(in.DocumentIDs)
+	a.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id, orgID string) error {
+	return s.repo.Delete(ctx, id, orgID)
+}
+
+func orEmpty(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
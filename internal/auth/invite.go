@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// inviteTokenPrefix makes leaked invitation tokens recognizable to
+// scanners.
+const inviteTokenPrefix = "inv_"
+
+// NewInviteToken mints a one-time invitation token and returns it with its
+// lookup hash; only the hash is persisted.
+func NewInviteToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = inviteTokenPrefix + hex.EncodeToString(b)
+	return token, HashInviteToken(token), nil
+}
+
+// HashInviteToken returns the lookup hash of a plaintext invitation token.
+func HashInviteToken(token string) string {
+	return HashRefreshToken(token) // same entropy, same plain SHA-256
+}
@@ -7,47 +7,89 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Claims is the JWT payload embedded in every request.
+// Claims is the JWT payload embedded in every request. Requests
+// authenticated with an API key get synthesized claims with APIKeyID set
+// and no UserID.
 type Claims struct {
-	OrgID  string `json:"org_id"`
-	UserID string `json:"user_id"`
-	Role   string `json:"role"` // "admin" | "member"
+	OrgID    string `json:"org_id"`
+	UserID   string `json:"user_id"`
+	Role     string `json:"role"` // "admin" | "member"
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Version is the user's token version at issue; bumping it in the
+	// database (password change, role change) invalidates the token.
+	Version int `json:"ver,omitempty"`
+	// PolicyApproved is never issued. It is set on requests that the
+	// deployment's access policy allowed with POLICY_MODE=replace, and
+	// role checks defer to it.
+	PolicyApproved bool `json:"-"`
+	// SuperAdmin is set for users the operator made platform
+	// administrators, who may use /api/v1/admin.
+	SuperAdmin bool `json:"sa,omitempty"`
+	// Impersonator is the super-admin acting as UserID, on tokens issued
+	// for support impersonation.
+	Impersonator string `json:"imp,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Actor identifies who made the request for created_by/audit fields: the
+// user ID, or "apikey:<id>" for API key requests.
+func (c *Claims) Actor() string {
+	if c.APIKeyID != "" {
+		return "apikey:" + c.APIKeyID
+	}
+	return c.UserID
+}
+
 type JWTManager struct {
-	secret []byte
-	expiry time.Duration
+	secret        []byte
+	expiry        time.Duration // access token lifetime
+	refreshExpiry time.Duration // refresh token lifetime
+}
+
+func NewJWTManager(secret string, expiry, refreshExpiry time.Duration) *JWTManager {
+	return &JWTManager{secret: []byte(secret), expiry: expiry, refreshExpiry: refreshExpiry}
 }
 
-func NewJWTManager(secret string, expiry time.Duration) *JWTManager {
-	return &JWTManager{secret: []byte(secret), expiry: expiry}
+// Generate creates a signed JWT for the given org/user at token version
+// version.
+func (m *JWTManager) Generate(orgID, userID, role string, version int) (string, error) {
+	token, _, err := m.GenerateWithExpiry(orgID, userID, role, version)
+	return token, err
 }
 
-// Generate creates a signed JWT for the given org/user.
-func (m *JWTManager) Generate(orgID, userID, role string) (string, error) {
-	claims := Claims{
-		OrgID:  orgID,
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.expiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// GenerateWithExpiry is Generate that also reports when the token expires,
+// so clients know when to refresh.
+func (m *JWTManager) GenerateWithExpiry(orgID, userID, role string, version int) (string, time.Time, error) {
+	return m.Issue(Claims{OrgID: orgID, UserID: userID, Role: role, Version: version}, 0)
+}
+
+// Issue signs claims as a token valid for ttl, or the access token
+// lifetime when ttl is 0, and reports when it expires.
+func (m *JWTManager) Issue(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 {
+		ttl = m.expiry
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(m.secret)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
 }
 
 // Verify parses and validates a token string, returning the claims.
 func (m *JWTManager) Verify(tokenStr string) (*Claims, error) {
+	// Only the algorithm we sign with is accepted; pinning it also keeps
+	// FIPS deployments on an approved MAC.
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
 		return m.secret, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
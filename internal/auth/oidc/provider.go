@@ -0,0 +1,330 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// discoveryTTL is how long an IdP's OpenID configuration is cached.
+	discoveryTTL = time.Hour
+	// keysMinAge is how soon signing keys are refetched for an unknown key
+	// ID, so a token with a bogus kid can't make us hammer the IdP.
+	keysMinAge = time.Minute
+	// maxIdPResponse caps what is read from an IdP endpoint.
+	maxIdPResponse = 1 << 20
+	// googleIssuer is shared by every Google account, not just Workspace
+	// ones, so its tokens must name the Workspace domain in hd.
+	googleIssuer = "https://accounts.google.com"
+)
+
+// signingMethods are the ID token algorithms accepted; all are approved
+// in FIPS mode.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// discovery is the part of an IdP's OpenID configuration a login needs.
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// idp is a discovered identity provider with its signing keys.
+type idp struct {
+	discovery
+	fetched time.Time
+
+	mu     sync.Mutex
+	keys   map[string]crypto.PublicKey // by kid
+	keysAt time.Time
+}
+
+// idp returns the discovered provider for issuer, fetching its OpenID
+// configuration when it isn't cached or is stale.
+func (s *Service) idp(ctx context.Context, issuer string) (*idp, error) {
+	s.mu.Lock()
+	cached := s.idps[issuer]
+	s.mu.Unlock()
+	if cached != nil && time.Since(cached.fetched) < discoveryTTL {
+		return cached, nil
+	}
+
+	p := &idp{fetched: time.Now()}
+	if err := getJSON(ctx, s.client, issuer+"/.well-known/openid-configuration", &p.discovery); err != nil {
+		return nil, fmt.Errorf("openid discovery: %w", err)
+	}
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("openid discovery: issuer is %q, want %q", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("openid discovery: configuration lacks endpoints")
+	}
+	s.mu.Lock()
+	s.idps[issuer] = p
+	s.mu.Unlock()
+	return p, nil
+}
+
+// exchange redeems an authorization code and returns the raw ID token.
+func (p *idp) exchange(ctx context.Context, client *http.Client, cfg *Provider, redirectURL, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic is the spec's default; fall back to posting the
+	// secret for IdPs that only accept that.
+	basic := len(p.TokenAuthMethods) == 0 || slices.Contains(p.TokenAuthMethods, "client_secret_basic")
+	if !basic {
+		form.Set("client_id", cfg.ClientID)
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxIdPResponse)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: status %d", resp.StatusCode)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s: %s", body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint: status %d without an id_token", resp.StatusCode)
+	}
+	return body.IDToken, nil
+}
+
+// idClaims are the ID token claims a login looks at.
+type idClaims struct {
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"` // some IdPs send "true"
+	// PreferredUsername is Azure AD's UPN, used when the optional email
+	// claim isn't configured.
+	PreferredUsername string `json:"preferred_username"`
+	HostedDomain      string `json:"hd"`
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// verify checks an ID token's signature, issuer, audience, expiry and
+// nonce, and returns its email once that is in one of cfg's domains.
+func (p *idp) verify(ctx context.Context, client *http.Client, cfg *Provider, raw, nonce string) (string, error) {
+	claims := &idClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, client, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return "", fmt.Errorf("id token: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return "", errors.New("id token: nonce mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != cfg.ClientID {
+		return "", errors.New("id token: issued to another client")
+	}
+	if v, ok := claims.EmailVerified.(bool); (ok && !v) || claims.EmailVerified == "false" {
+		return "", errors.New("id token: email is not verified")
+	}
+
+	email := claims.Email
+	if email == "" {
+		email = claims.PreferredUsername
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", errors.New("id token: no email")
+	}
+	if !slices.Contains(cfg.VerifiedDomains, email[at+1:]) {
+		return "", fmt.Errorf("id token: %s is not in the organization's verified domains", email[at+1:])
+	}
+	if (p.Issuer == googleIssuer || claims.HostedDomain != "") && !slices.Contains(cfg.VerifiedDomains, strings.ToLower(claims.HostedDomain)) {
+		return "", errors.New("id token: account is not in the organization's workspace")
+	}
+	return email, nil
+}
+
+// key returns the signing key kid, refetching the IdP's keys when it is
+// unknown (keys rotate). A token without kid works if the IdP has one key.
+func (p *idp) key(ctx context.Context, client *http.Client, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < keysMinAge {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	keys, err := fetchKeys(ctx, client, p.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = keys, time.Now()
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds kid among the cached keys; callers hold p.mu.
+func (p *idp) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// jwk is one key of a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads a JWKS and returns its RSA and EC signing keys.
+// Keys of other types or uses are skipped.
+func fetchKeys(ctx context.Context, client *http.Client, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, client, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			pub crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			pub, err = k.rsa()
+		case "EC":
+			pub, err = k.ec()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) rsa() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("bad exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func (k jwk) ec() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("bad coordinates")
+	}
+	// Parsing the uncompressed point also checks it is on the curve.
+	return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+}
+
+func getJSON(ctx context.Context, client *http.Client, rawURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxIdPResponse)).Decode(out)
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// One-time tokens
+// Password resets and SSO logins hand the user a random token that works
+// once. Like refresh tokens and invitation tokens (invite.go) they are
+// stored hashed, so a database leak doesn't let anyone redeem them.
+
+// Prefixes make leaked one-time tokens recognizable to scanners.
+const (
+	ResetTokenPrefix = "pr_"
+	SSOStatePrefix   = "sso_"
+)
+
+// NewOneTimeToken mints a random token with prefix and returns it with its
+// lookup hash; only the hash is persisted.
+func NewOneTimeToken(prefix string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = prefix + hex.EncodeToString(b)
+	return token, HashOneTimeToken(token), nil
+}
+
+// HashOneTimeToken returns the lookup hash of a plaintext one-time token.
+func HashOneTimeToken(token string) string {
+	return HashRefreshToken(token) // same entropy, same plain SHA-256
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/fips140"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing
+//
+// Passwords are hashed with bcrypt by default. When the Go FIPS 140 module
+// is enabled (GODEBUG=fips140=on, or a GOFIPS140 build) bcrypt is not an
+// approved algorithm, so new hashes use PBKDF2-HMAC-SHA256 instead and
+// bcrypt hashes are refused. Both formats verify outside FIPS mode, so a
+// deployment can move between the two.
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	// ErrUnapprovedHash is returned in FIPS mode for a bcrypt hash; the
+	// user has to reset their password to get an approved hash.
+	ErrUnapprovedHash = errors.New("password hash uses an algorithm not approved in FIPS mode")
+)
+
+const (
+	pbkdf2Prefix = "$pbkdf2-sha256$"
+	// pbkdf2Iterations follows OWASP's current PBKDF2-HMAC-SHA256 guidance.
+	pbkdf2Iterations = 600_000
+	pbkdf2SaltLen    = 16
+	pbkdf2KeyLen     = 32
+)
+
+// HashPassword hashes a password with the algorithm the current crypto
+// mode allows.
+func HashPassword(password string) (string, error) {
+	if !fips140.Enabled() {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, pbkdf2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, pbkdf2KeyLen)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, pbkdf2Iterations,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPassword verifies a password against a stored hash of either
+// format.
+func CheckPassword(hash, password string) error {
+	if !strings.HasPrefix(hash, pbkdf2Prefix) {
+		if fips140.Enabled() {
+			return ErrUnapprovedHash
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	// $pbkdf2-sha256$i=<iterations>$<salt>$<key>
+	parts := strings.Split(strings.TrimPrefix(hash, pbkdf2Prefix), "$")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "i=") {
+		return errors.New("malformed pbkdf2 hash")
+	}
+	iter, err := strconv.Atoi(strings.TrimPrefix(parts[0], "i="))
+	if err != nil || iter <= 0 {
+		return errors.New("malformed pbkdf2 hash")
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed pbkdf2 hash")
+	}
+	want, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed pbkdf2 hash")
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
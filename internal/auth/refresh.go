@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Refresh tokens
+// Access JWTs are short-lived and stateless. Refresh tokens are opaque
+// random strings that are stored (hashed) server-side, so they can be
+// rotated on every use and revoked — something a JWT can't offer.
+
+// refreshTokenPrefix makes leaked refresh tokens recognizable to scanners.
+const refreshTokenPrefix = "rt_"
+
+// RefreshToken is a freshly minted refresh token. Token is the plaintext
+// for the client; only Hash is persisted.
+type RefreshToken struct {
+	Token     string
+	Hash      string
+	ExpiresAt time.Time
+}
+
+// NewRefreshToken mints a random refresh token valid for the manager's
+// refresh expiry.
+func (m *JWTManager) NewRefreshToken() (*RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := refreshTokenPrefix + hex.EncodeToString(b)
+	return &RefreshToken{
+		Token:     token,
+		Hash:      HashRefreshToken(token),
+		ExpiresAt: time.Now().Add(m.refreshExpiry),
+	}, nil
+}
+
+// HashRefreshToken returns the lookup hash of a plaintext refresh token.
+// Tokens carry 256 bits of entropy, so a plain SHA-256 suffices.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,450 @@
+// Package authorizer consults an org's own policy engine before sensitive
+// operations. Enterprises with OPA or an internal IAM register a webhook;
+// deleting a document, sharing one with another org or publishing a public
+// site then also needs the webhook's approval, on top of our roles.
+//
+// The webhook receives a POST with {"input": {...}} describing who wants
+// to do what, which is the shape OPA's data API takes, and answers
+// {"result": true}, {"result": {"allow": true}} or {"allow": true}; a
+// "reason" next to allow is shown to a denied caller. Requests are signed
+// with the org's secret: X-Authorizer-Signature is "t=<unix seconds>,v1=
+// <hex HMAC-SHA256 of "<t>.<body>">".
+//
+// Decisions are cached in each replica's memory for the webhook's cache
+// TTL, keyed by the whole input, so repeating an operation doesn't call out
+// again. Changing the webhook drops its cached decisions.
+//
+// The operator can also put every API request to a deployment-wide OPA
+// policy (see Policy).
+package authorizer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound = errors.New("authorization webhook not found")
+	ErrInvalid  = errors.New("invalid authorization webhook")
+	// ErrDenied means the org's webhook refused the operation.
+	ErrDenied = errors.New("denied by the organization's authorization webhook")
+	// ErrUnavailable means the webhook couldn't be reached or gave no
+	// usable answer, and it doesn't fail open.
+	ErrUnavailable = errors.New("authorization webhook unavailable")
+)
+
+// Action names a sensitive operation.
+type Action string
+
+const (
+	ActionDocumentDelete   Action = "document.delete"
+	ActionDocumentShare    Action = "document.share"
+	ActionCollectionShare  Action = "collection.share"
+	ActionPublicSiteCreate Action = "public_site.create"
+)
+
+const (
+	// DefaultCacheTTL is how long decisions are kept when the webhook
+	// doesn't say otherwise.
+	DefaultCacheTTL = time.Minute
+	// MaxCacheTTL bounds how long a revoked permission can linger.
+	MaxCacheTTL = time.Hour
+	// callTimeout bounds one webhook call.
+	callTimeout = 5 * time.Second
+	// maxDecisions bounds each replica's decision cache.
+	maxDecisions = 10000
+	// maxResponseBytes bounds a webhook's answer.
+	maxResponseBytes = 64 << 10
+)
+
+// Webhook is an org's registered authorizer. Secret is never serialized
+// back to clients; it is shown once when generated.
+type Webhook struct {
+	OrgID string `json:"org_id"`
+	URL   string `json:"url"`
+	// FailOpen allows operations while the webhook is unreachable or
+	// answers with an error; by default they are refused.
+	FailOpen        bool      `json:"fail_open"`
+	CacheTTLSeconds int       `json:"cache_ttl_seconds"`
+	Secret          string    `json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SetRequest registers or changes a webhook. A nil CacheTTLSeconds keeps
+// the current TTL (DefaultCacheTTL for a new webhook); 0 turns caching
+// off. A new webhook gets a fresh secret, as does RotateSecret.
+type SetRequest struct {
+	URL             string `json:"url"`
+	FailOpen        bool   `json:"fail_open"`
+	CacheTTLSeconds *int   `json:"cache_ttl_seconds"`
+	RotateSecret    bool   `json:"rotate_secret"`
+}
+
+// SetResponse carries a newly generated secret, empty when the secret was
+// kept.
+type SetResponse struct {
+	Webhook *Webhook `json:"webhook"`
+	Secret  string   `json:"secret,omitempty"`
+}
+
+// Check describes one operation to authorize.
+type Check struct {
+	OrgID    string   `json:"org_id"`
+	UserID   string   `json:"user_id,omitempty"`
+	APIKeyID string   `json:"api_key_id,omitempty"`
+	Role     string   `json:"role"`
+	Action   Action   `json:"action"`
+	Resource Resource `json:"resource"`
+	// Attributes adds details of the operation, such as the org a
+	// document is shared with.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Resource is what an operation acts on. ID is empty for one the
+// operation creates.
+type Resource struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const webhookColumns = `org_id, url, fail_open, cache_ttl_seconds, secret, created_at, updated_at`
+
+func scanWebhook(row pgx.Row) (*Webhook, error) {
+	wh := &Webhook{}
+	err := row.Scan(&wh.OrgID, &wh.URL, &wh.FailOpen, &wh.CacheTTLSeconds, &wh.Secret, &wh.CreatedAt, &wh.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wh, nil
+}
+
+func (r *Repository) Get(ctx context.Context, orgID string) (*Webhook, error) {
+	return scanWebhook(r.db.QueryRow(ctx,
+		`SELECT `+webhookColumns+` FROM authorization_webhooks WHERE org_id = $1`, orgID))
+}
+
+// Upsert stores the org's webhook, replacing any existing one.
+func (r *Repository) Upsert(ctx context.Context, wh *Webhook) (*Webhook, error) {
+	return scanWebhook(r.db.QueryRow(ctx,
+		`INSERT INTO authorization_webhooks (org_id, url, fail_open, cache_ttl_seconds, secret)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (org_id) DO UPDATE SET
+		     url = EXCLUDED.url, fail_open = EXCLUDED.fail_open,
+		     cache_ttl_seconds = EXCLUDED.cache_ttl_seconds, secret = EXCLUDED.secret,
+		     updated_at = NOW()
+		 RETURNING `+webhookColumns,
+		wh.OrgID, wh.URL, wh.FailOpen, wh.CacheTTLSeconds, wh.Secret,
+	))
+}
+
+func (r *Repository) Delete(ctx context.Context, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM authorization_webhooks WHERE org_id = $1`, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type decision struct {
+	allow   bool
+	reason  string
+	expires time.Time
+}
+
+type Service struct {
+	repo   *Repository
+	client *http.Client
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	decisions map[string]decision
+}
+
+// NewService creates the service. client calls the webhooks; pass nil for
+// one that refuses to connect to loopback, private and link-local
+// addresses, since webhook URLs are tenant-supplied.
+func NewService(repo *Repository, client *http.Client, logger *slog.Logger) *Service {
+	if client == nil {
+		client = publicOnlyClient
+	}
+	return &Service{repo: repo, client: client, logger: logger, decisions: make(map[string]decision)}
+}
+
+// publicOnlyClient refuses to connect to loopback, private, shared
+// (carrier-grade NAT) and link-local addresses, checked on the resolved
+// address at dial time so DNS names pointing inward are covered too.
+// Redirects aren't followed: a webhook answers itself. No proxy is used,
+// since the check would then see only the proxy's address while the proxy
+// reached anything.
+var publicOnlyClient = &http.Client{
+	Timeout: callTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: callTimeout,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+					ip.IsLinkLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+					return fmt.Errorf("authorizer: refusing to connect to non-public address %s", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: callTimeout,
+	},
+}
+
+// sharedAddressSpace is 100.64.0.0/10 (RFC 6598), used inside carrier and
+// cloud networks; net.IP.IsPrivate doesn't cover it.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func (s *Service) Get(ctx context.Context, orgID string) (*Webhook, error) {
+	return s.repo.Get(ctx, orgID)
+}
+
+// Set registers the org's webhook or changes it.
+func (s *Service) Set(ctx context.Context, orgID string, req SetRequest) (*SetResponse, error) {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an http(s) URL", ErrInvalid)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: url must not carry credentials; requests are signed instead", ErrInvalid)
+	}
+
+	current, err := s.repo.Get(ctx, orgID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	wh := &Webhook{OrgID: orgID, URL: u.String(), FailOpen: req.FailOpen, CacheTTLSeconds: int(DefaultCacheTTL / time.Second)}
+	if current != nil {
+		wh.CacheTTLSeconds, wh.Secret = current.CacheTTLSeconds, current.Secret
+	}
+	if req.CacheTTLSeconds != nil {
+		if *req.CacheTTLSeconds < 0 || *req.CacheTTLSeconds > int(MaxCacheTTL/time.Second) {
+			return nil, fmt.Errorf("%w: cache_ttl_seconds must be between 0 and %d", ErrInvalid, int(MaxCacheTTL/time.Second))
+		}
+		wh.CacheTTLSeconds = *req.CacheTTLSeconds
+	}
+	var secret string
+	if wh.Secret == "" || req.RotateSecret {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+		wh.Secret = secret
+	}
+
+	wh, err = s.repo.Upsert(ctx, wh)
+	if err != nil {
+		return nil, err
+	}
+	s.forget(orgID)
+	return &SetResponse{Webhook: wh, Secret: secret}, nil
+}
+
+func (s *Service) Delete(ctx context.Context, orgID string) error {
+	if err := s.repo.Delete(ctx, orgID); err != nil {
+		return err
+	}
+	s.forget(orgID)
+	return nil
+}
+
+// Authorize asks the org's webhook whether the operation may go ahead. It
+// returns nil when the org has no webhook, ErrDenied (with the webhook's
+// reason) when refused, and ErrUnavailable when the webhook failed and
+// doesn't fail open.
+func (s *Service) Authorize(ctx context.Context, c Check) error {
+	wh, err := s.repo.Get(ctx, c.OrgID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{"input": c})
+	if err != nil {
+		return err
+	}
+	// The webhook's update time is in the key, so a changed webhook
+	// never sees its predecessor's decisions.
+	key := c.OrgID + "\x00" + strconv.FormatInt(wh.UpdatedAt.UnixNano(), 10) + "\x00" + string(body)
+	d, ok := s.cached(key)
+	if !ok {
+		d, err = s.call(ctx, wh, body)
+		if err != nil {
+			if wh.FailOpen {
+				s.logger.Warn("authorization webhook failed; allowing (fail open)", "org_id", c.OrgID, "action", c.Action, "error", err)
+				return nil
+			}
+			s.logger.Warn("authorization webhook failed; refusing", "org_id", c.OrgID, "action", c.Action, "error", err)
+			return ErrUnavailable
+		}
+		if wh.CacheTTLSeconds > 0 {
+			d.expires = time.Now().Add(time.Duration(wh.CacheTTLSeconds) * time.Second)
+			s.store(key, d)
+		}
+	}
+	if !d.allow {
+		if d.reason != "" {
+			return fmt.Errorf("%w: %s", ErrDenied, d.reason)
+		}
+		return ErrDenied
+	}
+	return nil
+}
+
+// call posts the signed input to the webhook and reads its decision.
+func (s *Service) call(ctx context.Context, wh *Webhook, body []byte) (decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Authorizer-Signature", Sign(wh.Secret, time.Now(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return decision{}, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return decision{}, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return decision{}, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return parseDecision(raw)
+}
+
+// parseDecision reads {"allow": ...}, {"result": bool} or {"result":
+// {"allow": ...}}. An OPA rule left undefined has no result, and denies.
+func parseDecision(raw []byte) (decision, error) {
+	var verdict struct {
+		Allow  *bool           `json:"allow"`
+		Reason string          `json:"reason"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &verdict); err != nil {
+		return decision{}, fmt.Errorf("decoding webhook response: %w", err)
+	}
+	if verdict.Allow != nil {
+		return decision{allow: *verdict.Allow, reason: verdict.Reason}, nil
+	}
+	if len(verdict.Result) == 0 || string(verdict.Result) == "null" {
+		return decision{}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(verdict.Result, &allow); err == nil {
+		return decision{allow: allow}, nil
+	}
+	var result struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(verdict.Result, &result); err != nil {
+		return decision{}, errors.New("webhook result is neither a boolean nor an object with allow")
+	}
+	return decision{allow: result.Allow, reason: result.Reason}, nil
+}
+
+// Sign computes the X-Authorizer-Signature header for a request body sent
+// at t. Webhooks recompute v1 with their secret to verify a request, and
+// can reject old timestamps to stop replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts + "."))
+	m.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func (s *Service) cached(key string) (decision, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.decisions[key]
+	if !ok || time.Now().After(d.expires) {
+		return decision{}, false
+	}
+	return d, true
+}
+
+// store caches a decision, dropping expired ones when the cache is full,
+// and everything if that isn't enough.
+func (s *Service) store(key string, d decision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.decisions) >= maxDecisions {
+		now := time.Now()
+		for k, old := range s.decisions {
+			if now.After(old.expires) {
+				delete(s.decisions, k)
+			}
+		}
+		if len(s.decisions) >= maxDecisions {
+			clear(s.decisions)
+		}
+	}
+	s.decisions[key] = d
+}
+
+// forget drops an org's cached decisions on this replica; other replicas
+// stop using them when they next read the changed webhook.
+func (s *Service) forget(orgID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.decisions {
+		if strings.HasPrefix(k, orgID+"\x00") {
+			delete(s.decisions, k)
+		}
+	}
+}
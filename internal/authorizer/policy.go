@@ -0,0 +1,204 @@
+package authorizer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// Deployment access policy
+//
+// Besides orgs' own webhooks, the operator can put every authenticated API
+// request to the deployment's Rego policies, evaluated in-process by OPA's
+// engine from a bundle on disk. The bundle is read again periodically
+// (Watch), so policies change without a code change or restart, and can
+// express rules on any attribute of the caller or request. Each decision
+// is logged with an id that is also returned to the caller.
+
+var (
+	// ErrPolicyDenied means the deployment's policy refused the request.
+	ErrPolicyDenied = errors.New("denied by the access policy")
+	// ErrPolicyUnavailable means the policy couldn't be evaluated or gave
+	// no usable answer. Requests are refused meanwhile.
+	ErrPolicyUnavailable = errors.New("access policy unavailable")
+)
+
+// DefaultPolicyQuery is the decision queried when PolicyConfig.Query is
+// empty.
+const DefaultPolicyQuery = "data.rag.authz"
+
+// PolicyMode says how the policy combines with the built-in roles.
+type PolicyMode string
+
+const (
+	// PolicyEnforce requires the policy's approval on top of the roles.
+	PolicyEnforce PolicyMode = "enforce"
+	// PolicyReplace lets the policy decide alone: requests it allows
+	// pass the role checks too.
+	PolicyReplace PolicyMode = "replace"
+)
+
+func ParsePolicyMode(s string) (PolicyMode, error) {
+	switch m := PolicyMode(s); m {
+	case "":
+		return PolicyEnforce, nil
+	case PolicyEnforce, PolicyReplace:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: policy mode must be %q or %q", ErrInvalid, PolicyEnforce, PolicyReplace)
+}
+
+type PolicyConfig struct {
+	// Bundle is the policy bundle: a directory of .rego and data files,
+	// or a bundle archive (.tar.gz) as built by `opa build`.
+	Bundle string
+	// Query is the decision to evaluate, DefaultPolicyQuery when empty.
+	Query string
+	Mode  PolicyMode
+	// DecisionLog receives one record per decision.
+	DecisionLog *slog.Logger
+}
+
+// PolicyInput is what the policy decides on, OPA's input.
+type PolicyInput struct {
+	Subject PolicySubject `json:"subject"`
+	Request PolicyRequest `json:"request"`
+}
+
+type PolicySubject struct {
+	OrgID    string `json:"org_id"`
+	UserID   string `json:"user_id,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	Role     string `json:"role"`
+	// SuperAdmin and Impersonator are set for platform administrators
+	// and those acting as a user for support.
+	SuperAdmin   bool   `json:"super_admin,omitempty"`
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+type PolicyRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Route is the matched pattern, such as "DELETE
+	// /api/v1/documents/{id}", and Params its wildcards' values.
+	Route  string              `json:"route"`
+	Params map[string]string   `json:"params,omitempty"`
+	Query  map[string][]string `json:"query,omitempty"`
+}
+
+// Policy evaluates the deployment's policy bundle.
+type Policy struct {
+	cfg      PolicyConfig
+	prepared atomic.Pointer[rego.PreparedEvalQuery]
+}
+
+func NewPolicy(ctx context.Context, cfg PolicyConfig) (*Policy, error) {
+	if cfg.Bundle == "" {
+		return nil, fmt.Errorf("%w: a policy bundle is required", ErrInvalid)
+	}
+	if cfg.Query == "" {
+		cfg.Query = DefaultPolicyQuery
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = PolicyEnforce
+	}
+	if cfg.DecisionLog == nil {
+		cfg.DecisionLog = slog.Default()
+	}
+	p := &Policy{cfg: cfg}
+	if err := p.Reload(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return p, nil
+}
+
+// Reload reads and compiles the bundle again. The policy in use is kept
+// when the bundle doesn't compile.
+func (p *Policy) Reload(ctx context.Context) error {
+	prepared, err := rego.New(
+		rego.Query(p.cfg.Query),
+		rego.LoadBundle(p.cfg.Bundle),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("load policy bundle %s: %w", p.cfg.Bundle, err)
+	}
+	p.prepared.Store(&prepared)
+	return nil
+}
+
+// Watch reloads the bundle every interval until ctx is cancelled.
+func (p *Policy) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.Reload(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("policy bundle reload failed; keeping the previous policy", "error", err)
+		}
+	}
+}
+
+func (p *Policy) Mode() PolicyMode {
+	return p.cfg.Mode
+}
+
+// Decide asks the policy about a request. It returns the decision's id,
+// and ErrPolicyDenied (with the policy's reason) or ErrPolicyUnavailable
+// when the request may not go ahead.
+func (p *Policy) Decide(ctx context.Context, in PolicyInput) (string, error) {
+	id := uuid.NewString()
+	start := time.Now()
+	d, err := p.query(ctx, in)
+	attrs := []any{
+		"decision_id", id,
+		"input", in,
+		"allow", err == nil && d.allow,
+		"duration", time.Since(start),
+	}
+	if d.reason != "" {
+		attrs = append(attrs, "reason", d.reason)
+	}
+	if err != nil {
+		p.cfg.DecisionLog.Warn("policy decision", append(attrs, "error", err)...)
+		return id, ErrPolicyUnavailable
+	}
+	p.cfg.DecisionLog.Info("policy decision", attrs...)
+
+	if !d.allow {
+		if d.reason != "" {
+			return id, fmt.Errorf("%w: %s", ErrPolicyDenied, d.reason)
+		}
+		return id, ErrPolicyDenied
+	}
+	return id, nil
+}
+
+// query evaluates the decision. Its value takes the forms of a webhook's
+// result; an undefined decision denies.
+func (p *Policy) query(ctx context.Context, in PolicyInput) (decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	rs, err := p.prepared.Load().Eval(ctx, rego.EvalInput(in))
+	if err != nil {
+		return decision{}, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return decision{}, nil
+	}
+	result, err := json.Marshal(rs[0].Expressions[0].Value)
+	if err != nil {
+		return decision{}, err
+	}
+	return parseDecision([]byte(`{"result": ` + string(result) + `}`))
+}
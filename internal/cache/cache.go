@@ -0,0 +1,220 @@
+// Package cache keeps recent answers so a question asked again is answered
+// without calling the LLM. Support teams ask the same few questions
+// hundreds of times a day, and each answer costs tokens and seconds.
+//
+// An answer is keyed by its org, the normalized question and a fingerprint
+// of what it was generated from: the retrieved context, the prompt, the
+// model and the generation settings (see retrieval.AnswerCache). Editing a
+// document, a prompt template or a model choice changes the fingerprint, so
+// the old answer is simply never found again; entries also expire after a
+// TTL. With similarity matching on, a question worded differently but
+// embedding close to a cached one, with the same fingerprint, gets its
+// answer too: the same context was retrieved for both.
+//
+// The cache lives in each replica's memory and is bounded in entries; it
+// is a cost saving, not state, so losing it on restart is fine.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+)
+
+// DefaultMaxEntries bounds the cache when no size is configured.
+const DefaultMaxEntries = 10000
+
+type key struct {
+	orgID, question, fingerprint string
+}
+
+// scope groups the entries whose answers came from the same context, among
+// which similar questions are matched.
+type scope struct {
+	orgID, fingerprint string
+}
+
+type entry struct {
+	key
+	answer  string
+	vector  []float32 // nil without similarity matching
+	expires time.Time
+	elem    *list.Element
+}
+
+// Cache maps questions to the answers given to them. It is safe for
+// concurrent use.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	embedder   embedding.Embedder // nil matches exact questions only
+	similarity float32
+
+	mu      sync.Mutex
+	entries map[key]*entry
+	scopes  map[scope][]*entry
+	// order holds entries oldest first; with one TTL for all, that is
+	// also the order they expire in.
+	order *list.List
+}
+
+// New creates a cache keeping answers for ttl, at most maxEntries of them
+// (DefaultMaxEntries when zero); the oldest go first when it is full.
+func New(ttl time.Duration, maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[key]*entry),
+		scopes:     make(map[scope][]*entry),
+		order:      list.New(),
+	}
+}
+
+// MatchSimilar makes questions hit a cached answer to another question
+// whose embedding (by e) has at least the given cosine similarity to
+// theirs, when both were answered from the same context. Each lookup that
+// misses exactly, and each answer stored, embeds its question.
+func (c *Cache) MatchSimilar(e embedding.Embedder, similarity float32) {
+	c.embedder, c.similarity = e, similarity
+}
+
+// Normalize reduces a question to what matters for matching: lower case,
+// single spaces, no surrounding punctuation.
+func Normalize(question string) string {
+	q := strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	return strings.TrimFunc(q, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) })
+}
+
+// Get returns the answer cached for question in the org, answered from the
+// context fingerprint names. It implements retrieval.AnswerCache.
+func (c *Cache) Get(ctx context.Context, orgID, question, fingerprint string) (string, bool) {
+	k := key{orgID, Normalize(question), fingerprint}
+	now := time.Now()
+
+	c.mu.Lock()
+	c.expire(now)
+	if e, ok := c.entries[k]; ok {
+		c.mu.Unlock()
+		return e.answer, true
+	}
+	candidates := len(c.scopes[scope{orgID, fingerprint}])
+	c.mu.Unlock()
+	if c.embedder == nil || candidates == 0 {
+		return "", false
+	}
+
+	vec, err := c.embedder.EmbedQuery(ctx, k.question)
+	if err != nil {
+		slog.Warn("embed question for answer cache failed", "org_id", orgID, "error", err)
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var best *entry
+	bestSim := c.similarity
+	for _, e := range c.scopes[scope{orgID, fingerprint}] {
+		if e.expires.Before(now) {
+			continue
+		}
+		if sim := cosine(vec, e.vector); sim >= bestSim {
+			best, bestSim = e, sim
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.answer, true
+}
+
+// Put caches answer to question in the org, answered from the context
+// fingerprint names. It implements retrieval.AnswerCache.
+func (c *Cache) Put(ctx context.Context, orgID, question, fingerprint, answer string) {
+	e := &entry{key: key{orgID, Normalize(question), fingerprint}, answer: answer}
+	if c.embedder != nil {
+		vec, err := c.embedder.EmbedQuery(ctx, e.question)
+		if err != nil {
+			// Still good for exact matches.
+			slog.Warn("embed question for answer cache failed", "org_id", orgID, "error", err)
+		}
+		e.vector = vec
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[e.key]; ok {
+		c.remove(old)
+	}
+	e.expires = now.Add(c.ttl)
+	e.elem = c.order.PushBack(e)
+	c.entries[e.key] = e
+	if e.vector != nil {
+		s := scope{orgID, fingerprint}
+		c.scopes[s] = append(c.scopes[s], e)
+	}
+	c.expire(now)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Front().Value.(*entry))
+	}
+}
+
+// expire drops the entries expired at now; callers hold c.mu.
+func (c *Cache) expire(now time.Time) {
+	for f := c.order.Front(); f != nil; f = c.order.Front() {
+		e := f.Value.(*entry)
+		if e.expires.After(now) {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+// remove drops e; callers hold c.mu.
+func (c *Cache) remove(e *entry) {
+	c.order.Remove(e.elem)
+	delete(c.entries, e.key)
+	if e.vector == nil {
+		return
+	}
+	s := scope{e.orgID, e.fingerprint}
+	rest := c.scopes[s]
+	for i, other := range rest {
+		if other == e {
+			rest = append(rest[:i], rest[i+1:]...)
+			break
+		}
+	}
+	if len(rest) == 0 {
+		delete(c.scopes, s)
+	} else {
+		c.scopes[s] = rest
+	}
+}
+
+// cosine returns the cosine similarity of a and b, or 0 when they can't be
+// compared (the org's embedding model changed in between).
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(na*nb))
+}
@@ -0,0 +1,402 @@
+// Package collection groups an org's documents into named collections,
+// e.g. one per knowledge base, so queries can be scoped to some of them.
+// A document can be in any number of collections; deleting either side
+// drops the membership.
+package collection
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+var (
+	ErrNotFound      = errors.New("collection not found")
+	ErrDuplicateName = errors.New("a collection with that name already exists")
+	ErrInvalid       = errors.New("name is required")
+	// ErrUnknownDocument is returned when adding documents that don't
+	// exist or belong to another org.
+	ErrUnknownDocument = errors.New("document not found")
+	ErrNoDocuments     = errors.New("between 1 and 1000 document_ids are required")
+)
+
+// maxDocumentsPerRequest bounds the documents added in one call.
+const maxDocumentsPerRequest = 1000
+
+type Collection struct {
+	ID            string    `json:"id"`
+	OrgID         string    `json:"org_id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	DocumentCount int       `json:"document_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db database.DBTX) *Repository {
+	return &Repository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx}
+}
+
+const collectionColumns = `id, org_id, name, description,
+	(SELECT count(*) FROM collection_documents cd WHERE cd.collection_id = collections.id),
+	created_at, updated_at`
+
+func scanCollection(row pgx.Row) (*Collection, error) {
+	c := &Collection{}
+	err := row.Scan(&c.ID, &c.OrgID, &c.Name, &c.Description, &c.DocumentCount, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (r *Repository) Create(ctx context.Context, c *Collection) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO collections (id, org_id, name, description, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ID, c.OrgID, c.Name, c.Description, c.CreatedAt, c.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Collection, error) {
+	return scanCollection(r.db.QueryRow(ctx,
+		`SELECT `+collectionColumns+` FROM collections WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+// GetForUpdate is Get that locks the collection until the transaction
+// ends, serializing membership changes.
+func (r *Repository) GetForUpdate(ctx context.Context, id, orgID string) (*Collection, error) {
+	return scanCollection(r.db.QueryRow(ctx,
+		`SELECT `+collectionColumns+` FROM collections WHERE id = $1 AND org_id = $2 FOR UPDATE`, id, orgID))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Collection, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+collectionColumns+` FROM collections WHERE org_id = $1 ORDER BY name`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Collection, error) {
+		return scanCollection(row)
+	})
+}
+
+func (r *Repository) Update(ctx context.Context, c *Collection) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE collections SET name = $1, description = $2, updated_at = $3 WHERE id = $4 AND org_id = $5`,
+		c.Name, c.Description, c.UpdatedAt, c.ID, c.OrgID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM collections WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddDocuments puts orgID's documents documentIDs into a collection,
+// skipping those already in it, and returns how many of documentIDs exist
+// in the org.
+func (r *Repository) AddDocuments(ctx context.Context, collectionID, orgID string, documentIDs []string) (int, error) {
+	var found int
+	err := r.db.QueryRow(ctx,
+		`WITH docs AS (
+		     SELECT id FROM documents WHERE id = ANY($2) AND org_id = $3
+		 ), added AS (
+		     INSERT INTO collection_documents (collection_id, document_id, added_at)
+		     SELECT $1, id, NOW() FROM docs
+		     ON CONFLICT DO NOTHING
+		 )
+		 SELECT count(*) FROM docs`,
+		collectionID, documentIDs, orgID,
+	).Scan(&found)
+	return found, err
+}
+
+func (r *Repository) RemoveDocument(ctx context.Context, collectionID, documentID string) error {
+	tag, err := r.db.Exec(ctx,
+		`DELETE FROM collection_documents WHERE collection_id = $1 AND document_id = $2`, collectionID, documentID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUnknownDocument
+	}
+	return nil
+}
+
+// Owned returns ErrNotFound unless the distinct collections ids are all
+// orgID's.
+func (r *Repository) Owned(ctx context.Context, orgID string, ids []string) error {
+	var n int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM collections WHERE id = ANY($1) AND org_id = $2`, ids, orgID,
+	).Scan(&n); err != nil {
+		return err
+	}
+	if n != len(ids) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DocumentIDs returns the documents in any of the distinct collections
+// ids, which must all be orgID's; otherwise it returns ErrNotFound.
+func (r *Repository) DocumentIDs(ctx context.Context, orgID string, ids []string) ([]string, error) {
+	if err := r.Owned(ctx, orgID, ids); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT DISTINCT document_id FROM collection_documents WHERE collection_id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// SummaryInput is what a collection's summary node is built from.
+type SummaryInput struct {
+	ID    string
+	OrgID string
+	Name  string
+	// Summaries are those of the collection's documents with one, the
+	// most recently added first.
+	Summaries []string
+	// Digest identifies the summaries of all its documents; nil when
+	// there are none.
+	Digest *string
+}
+
+// StaleSummaries returns up to limit collections whose documents'
+// summaries changed since their summary node was built, each with the
+// latest maxSummaries of them.
+func (r *Repository) StaleSummaries(ctx context.Context, limit, maxSummaries int) ([]*SummaryInput, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT c.id, c.org_id, c.name, COALESCE(m.summaries, '{}'), m.digest
+		 FROM collections c
+		 LEFT JOIN LATERAL (
+		     SELECT (array_agg(s.summary ORDER BY cd.added_at DESC, cd.document_id))[1:$2] AS summaries,
+		            md5(string_agg(cd.document_id || ':' || md5(s.summary), ',' ORDER BY cd.document_id)) AS digest
+		     FROM collection_documents cd
+		     JOIN document_summaries s ON s.document_id = cd.document_id
+		     WHERE cd.collection_id = c.id
+		 ) m ON true
+		 WHERE c.summary_digest IS DISTINCT FROM m.digest
+		 ORDER BY c.id
+		 LIMIT $1`,
+		limit, maxSummaries)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*SummaryInput, error) {
+		in := &SummaryInput{}
+		err := row.Scan(&in.ID, &in.OrgID, &in.Name, &in.Summaries, &in.Digest)
+		return in, err
+	})
+}
+
+// SetSummaryDigest records which summaries a collection's summary node
+// was built from.
+func (r *Repository) SetSummaryDigest(ctx context.Context, id string, digest *string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE collections SET summary_digest = $2 WHERE id = $1`, id, digest)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SummaryStore holds the collections' summary nodes.
+type SummaryStore interface {
+	DeleteByDocument(ctx context.Context, documentID string) error
+}
+
+type Service struct {
+	repo *Repository
+	uow  *database.UnitOfWork
+	// summaries, when set, has a deleted collection's summary node
+	// removed.
+	summaries SummaryStore
+}
+
+func NewService(repo *Repository, uow *database.UnitOfWork) *Service {
+	return &Service{repo: repo, uow: uow}
+}
+
+// DropSummariesFrom has Delete remove the collection's summary node from
+// store.
+func (s *Service) DropSummariesFrom(store SummaryStore) {
+	s.summaries = store
+}
+
+// Input is the writable part of a collection, used for create and update.
+type Input struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (s *Service) Create(ctx context.Context, orgID string, in Input) (*Collection, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return nil, ErrInvalid
+	}
+	now := time.Now()
+	c := &Collection{
+		ID:          uuid.NewString(),
+		OrgID:       orgID,
+		Name:        in.Name,
+		Description: in.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Service) Get(ctx context.Context, id, orgID string) (*Collection, error) {
+	return s.repo.Get(ctx, id, orgID)
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Collection, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+// Update renames and redescribes a collection.
+func (s *Service) Update(ctx context.Context, id, orgID string, in Input) (*Collection, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return nil, ErrInvalid
+	}
+	c, err := s.repo.Get(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = in.Name
+	c.Description = in.Description
+	c.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Delete removes a collection; its documents stay.
+func (s *Service) Delete(ctx context.Context, id, orgID string) error {
+	if err := s.repo.Delete(ctx, id, orgID); err != nil {
+		return err
+	}
+	if s.summaries != nil {
+		if err := s.summaries.DeleteByDocument(ctx, retrieval.CollectionSummaryID(id)); err != nil {
+			slog.Warn("collection summary not deleted", "collection_id", id, "error", err)
+		}
+	}
+	return nil
+}
+
+// AddDocuments puts documents into a collection. Either all of them are
+// orgID's and get added, or none is and it returns ErrUnknownDocument.
+func (s *Service) AddDocuments(ctx context.Context, id, orgID string, documentIDs []string) (*Collection, error) {
+	documentIDs = distinct(documentIDs)
+	if len(documentIDs) == 0 || len(documentIDs) > maxDocumentsPerRequest {
+		return nil, ErrNoDocuments
+	}
+
+	var c *Collection
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if _, err := repo.GetForUpdate(ctx, id, orgID); err != nil {
+			return err
+		}
+		found, err := repo.AddDocuments(ctx, id, orgID, documentIDs)
+		if err != nil {
+			return err
+		}
+		if found != len(documentIDs) {
+			return ErrUnknownDocument
+		}
+		c, err = repo.Get(ctx, id, orgID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RemoveDocument takes a document out of a collection; the document stays.
+func (s *Service) RemoveDocument(ctx context.Context, id, orgID, documentID string) error {
+	if _, err := s.repo.Get(ctx, id, orgID); err != nil {
+		return err
+	}
+	return s.repo.RemoveDocument(ctx, id, documentID)
+}
+
+// DocumentIDs lists the documents in a collection.
+func (s *Service) DocumentIDs(ctx context.Context, id, orgID string) ([]string, error) {
+	return s.repo.DocumentIDs(ctx, orgID, []string{id})
+}
+
+// Check returns ErrNotFound unless the collections ids are all orgID's.
+func (s *Service) Check(ctx context.Context, orgID string, ids []string) error {
+	return s.repo.Owned(ctx, orgID, distinct(ids))
+}
+
+// CollectionDocumentIDs implements retrieval.CollectionResolver.
+func (s *Service) CollectionDocumentIDs(ctx context.Context, orgID string, ids []string) ([]string, error) {
+	return s.repo.DocumentIDs(ctx, orgID, distinct(ids))
+}
+
+func distinct(ids []string) []string {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
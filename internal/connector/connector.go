@@ -0,0 +1,806 @@
+// Package connector syncs items from external systems (Zendesk, Jira,
+// GitHub, RSS/Atom feeds) into an org's documents. Syncs are incremental: each connector
+// keeps an "updated since" cursor, and items already indexed are updated by
+// appending new comments and status changes rather than re-ingesting the
+// whole thread.
+package connector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+var (
+	ErrNotFound      = errors.New("connector not found")
+	ErrDuplicateName = errors.New("a connector with that name already exists")
+	ErrUnknownKind   = errors.New("kind must be one of: zendesk, jira, github, feed")
+	ErrInvalid       = errors.New("invalid connector")
+	ErrSyncRunning   = errors.New("a sync is already running for this connector")
+)
+
+type Kind string
+
+const (
+	KindZendesk Kind = "zendesk"
+	KindJira    Kind = "jira"
+	KindGitHub  Kind = "github"
+	KindFeed    Kind = "feed"
+)
+
+// syncLease is how long a claimed sync blocks other claims. A replica that
+// dies mid-sync releases its connector once the lease runs out.
+const syncLease = time.Hour
+
+// Connector is an org's link to one external system. Config holds
+// credentials and is never serialized back to clients.
+type Connector struct {
+	ID         string          `json:"id"`
+	OrgID      string          `json:"org_id"`
+	Kind       Kind            `json:"kind"`
+	Name       string          `json:"name"`
+	Config     json.RawMessage `json:"-"`
+	Enabled    bool            `json:"enabled"`
+	SyncCursor *time.Time      `json:"sync_cursor"`
+	LastSyncAt *time.Time      `json:"last_sync_at"`
+	LastError  string          `json:"last_error"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Item is one ticket, article, issue, file or feed entry as fetched from a
+// source.
+type Item struct {
+	ExternalID string
+	Type       string // "ticket", "article", "issue", "file", "entry"
+	Title      string
+	URL        string
+	Status     string
+	Body       string
+	Comments   []Comment // oldest first
+	UpdatedAt  time.Time
+	// Deleted marks an item removed at the source; its document is dropped.
+	Deleted bool
+	// Metadata is stored on the item's document and copied onto its chunks.
+	Metadata map[string]any
+}
+
+type Comment struct {
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// Source fetches items changed at or after since. A zero since means a
+// full sync.
+type Source interface {
+	Fetch(ctx context.Context, since time.Time) ([]Item, error)
+}
+
+// SyncedItem records where an external item lives in the index and what
+// was last indexed for it.
+type SyncedItem struct {
+	ExternalID      string     `json:"external_id"`
+	DocumentID      string     `json:"document_id"`
+	Title           string     `json:"title"`
+	URL             string     `json:"url"`
+	Status          string     `json:"status"`
+	ContentHash     string     `json:"-"`
+	LastCommentAt   *time.Time `json:"last_comment_at"`
+	SourceUpdatedAt time.Time  `json:"source_updated_at"`
+	SyncedAt        time.Time  `json:"synced_at"`
+}
+
+// newSource builds the Source for a connector kind from its stored config,
+// validating the config on the way.
+func (s *Service) newSource(kind Kind, config json.RawMessage) (Source, error) {
+	switch kind {
+	case KindZendesk:
+		var cfg ZendeskConfig
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid zendesk config: %w", err)
+		}
+		return newZendeskSource(cfg, s.client)
+	case KindJira:
+		var cfg JiraConfig
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid jira config: %w", err)
+		}
+		return newJiraSource(cfg, s.publicClient)
+	case KindGitHub:
+		var cfg GitHubConfig
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid github config: %w", err)
+		}
+		return newGitHubSource(cfg, s.github, s.client)
+	case KindFeed:
+		var cfg FeedConfig
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid feed config: %w", err)
+		}
+		return newFeedSource(cfg, s.publicClient)
+	default:
+		return nil, ErrUnknownKind
+	}
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db database.DBTX) *Repository {
+	return &Repository{db: db}
+}
+
+const connectorColumns = `id, org_id, kind, name, config, enabled, sync_cursor, last_sync_at, last_error, created_at`
+
+func scanConnector(row pgx.Row) (*Connector, error) {
+	c := &Connector{}
+	err := row.Scan(&c.ID, &c.OrgID, &c.Kind, &c.Name, &c.Config, &c.Enabled,
+		&c.SyncCursor, &c.LastSyncAt, &c.LastError, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (r *Repository) Create(ctx context.Context, c *Connector) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO connectors (id, org_id, kind, name, config, enabled, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		c.ID, c.OrgID, c.Kind, c.Name, c.Config, c.Enabled, c.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Connector, error) {
+	return scanConnector(r.db.QueryRow(ctx,
+		`SELECT `+connectorColumns+` FROM connectors WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Connector, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+connectorColumns+` FROM connectors WHERE org_id = $1 ORDER BY created_at`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Connector, error) {
+		return scanConnector(row)
+	})
+}
+
+// ListDue returns enabled connectors whose last sync is older than interval.
+func (r *Repository) ListDue(ctx context.Context, interval time.Duration) ([]*Connector, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+connectorColumns+` FROM connectors
+		 WHERE enabled AND (last_sync_at IS NULL OR last_sync_at < $1)
+		 ORDER BY last_sync_at NULLS FIRST`,
+		time.Now().Add(-interval))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Connector, error) {
+		return scanConnector(row)
+	})
+}
+
+func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM connectors WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListByGitHubRepo returns an org's enabled GitHub connectors of an app
+// installation that index repo.
+func (r *Repository) ListByGitHubRepo(ctx context.Context, orgID string, installationID int64, repo string) ([]*Connector, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+connectorColumns+` FROM connectors
+		 WHERE org_id = $1 AND kind = 'github' AND enabled
+		   AND (config->>'installation_id')::bigint = $2 AND config->'repos' ? $3`,
+		orgID, installationID, repo)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Connector, error) {
+		return scanConnector(row)
+	})
+}
+
+// Claim takes the sync lease on a connector. It reports false when another
+// sync holds an unexpired lease.
+func (r *Repository) Claim(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE connectors SET syncing_since = NOW()
+		 WHERE id = $1 AND (syncing_since IS NULL OR syncing_since < $2)`,
+		id, time.Now().Add(-syncLease))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Release ends a sync: it drops the lease and records the outcome. A nil
+// cursor leaves the stored cursor unchanged.
+func (r *Repository) Release(ctx context.Context, id string, cursor *time.Time, lastError string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE connectors
+		 SET syncing_since = NULL, last_sync_at = NOW(), last_error = $2,
+		     sync_cursor = COALESCE($3, sync_cursor)
+		 WHERE id = $1`,
+		id, lastError, cursor)
+	return err
+}
+
+const itemColumns = `external_id, document_id, title, url, status, content_hash, last_comment_at, source_updated_at, synced_at`
+
+func scanItem(row pgx.Row) (*SyncedItem, error) {
+	it := &SyncedItem{}
+	err := row.Scan(&it.ExternalID, &it.DocumentID, &it.Title, &it.URL, &it.Status,
+		&it.ContentHash, &it.LastCommentAt, &it.SourceUpdatedAt, &it.SyncedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func (r *Repository) GetItem(ctx context.Context, connectorID, externalID string) (*SyncedItem, error) {
+	return scanItem(r.db.QueryRow(ctx,
+		`SELECT `+itemColumns+` FROM connector_items WHERE connector_id = $1 AND external_id = $2`,
+		connectorID, externalID))
+}
+
+func (r *Repository) ListItems(ctx context.Context, connectorID string) ([]*SyncedItem, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+itemColumns+` FROM connector_items WHERE connector_id = $1 ORDER BY source_updated_at DESC`,
+		connectorID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*SyncedItem, error) {
+		return scanItem(row)
+	})
+}
+
+func (r *Repository) UpsertItem(ctx context.Context, connectorID string, it *SyncedItem) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO connector_items (connector_id, `+itemColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (connector_id, external_id) DO UPDATE SET
+		     document_id = EXCLUDED.document_id, title = EXCLUDED.title, url = EXCLUDED.url,
+		     status = EXCLUDED.status, content_hash = EXCLUDED.content_hash,
+		     last_comment_at = EXCLUDED.last_comment_at,
+		     source_updated_at = EXCLUDED.source_updated_at, synced_at = EXCLUDED.synced_at`,
+		connectorID, it.ExternalID, it.DocumentID, it.Title, it.URL, it.Status,
+		it.ContentHash, it.LastCommentAt, it.SourceUpdatedAt, it.SyncedAt,
+	)
+	return err
+}
+
+func (r *Repository) DeleteItem(ctx context.Context, connectorID, externalID string) error {
+	_, err := r.db.Exec(ctx,
+		`DELETE FROM connector_items WHERE connector_id = $1 AND external_id = $2`, connectorID, externalID)
+	return err
+}
+
+type Service struct {
+	repo         *Repository
+	docs         *document.Service
+	github       *GitHubApp // nil when no GitHub App is configured
+	client       *http.Client
+	publicClient *http.Client // for URLs tenants supply: feeds, Jira sites
+	logger       *slog.Logger
+	// scopes lists the shared tables plus each org with isolated storage.
+	scopes tenancy.Scoper
+	quotas Quotas // nil skips quota checks
+}
+
+// Quotas checks an org's quotas before a sync adds content to it.
+type Quotas interface {
+	Check(ctx context.Context, orgID string, kinds ...usage.Kind) error
+}
+
+// NewService creates the service. client makes every connector API call;
+// pass nil for the default client. A non-nil client (the internal-only one
+// of offline mode) also replaces the public-only client used for URLs
+// tenants supply: feeds and Jira sites.
+func NewService(repo *Repository, docs *document.Service, github *GitHubApp, client *http.Client, scopes tenancy.Scoper, logger *slog.Logger) *Service {
+	publicClient := publicOnlyClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	} else {
+		publicClient = client
+	}
+	return &Service{
+		repo:         repo,
+		docs:         docs,
+		github:       github,
+		client:       client,
+		publicClient: publicClient,
+		logger:       logger,
+		scopes:       scopes,
+	}
+}
+
+// CheckQuotasWith has every sync, scheduled and push-triggered ones
+// included, skipped while its org is over an ingest quota, as the API
+// refuses uploads; the next sync after the org has room again catches up.
+func (s *Service) CheckQuotasWith(quotas Quotas) {
+	s.quotas = quotas
+}
+
+type CreateRequest struct {
+	Kind   Kind            `json:"kind"`
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+// Create validates the source config and stores the connector. The first
+// sync picks it up on the next scheduler tick, or immediately via Sync.
+func (s *Service) Create(ctx context.Context, orgID string, req CreateRequest) (*Connector, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if _, err := s.newSource(req.Kind, req.Config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if err := s.checkGitHubOwner(ctx, orgID, req.Kind, req.Config); err != nil {
+		return nil, err
+	}
+
+	c := &Connector{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		Kind:      req.Kind,
+		Name:      req.Name,
+		Config:    req.Config,
+		Enabled:   true,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Connector, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+// Delete removes the connector and its item mappings. Documents it created
+// stay in the index; delete them explicitly if they should go too.
+func (s *Service) Delete(ctx context.Context, id, orgID string) error {
+	return s.repo.Delete(ctx, id, orgID)
+}
+
+// Items lists the synced items of a connector with their source status.
+func (s *Service) Items(ctx context.Context, id, orgID string) ([]*SyncedItem, error) {
+	if _, err := s.repo.Get(ctx, id, orgID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListItems(ctx, id)
+}
+
+// SyncResult counts what one sync did with the fetched items.
+type SyncResult struct {
+	Fetched   int    `json:"fetched"`
+	Created   int    `json:"created"`
+	Appended  int    `json:"appended"`
+	Replaced  int    `json:"replaced"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Sync runs one sync of the connector now.
+func (s *Service) Sync(ctx context.Context, id, orgID string) (*SyncResult, error) {
+	c, err := s.repo.Get(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return s.run(ctx, c)
+}
+
+// GitHubPush handles a GitHub webhook delivery: after verifying its
+// signature, a push to a repo's default branch triggers a background sync
+// of every connector of the installation's org indexing that repo, and an
+// uninstall unbinds the installation. Other events are acknowledged and
+// ignored. It returns ErrNotFound when no GitHub App is configured.
+func (s *Service) GitHubPush(ctx context.Context, event, signature string, body []byte) error {
+	if s.github == nil {
+		return ErrNotFound
+	}
+	if !s.github.VerifySignature(body, signature) {
+		return ErrBadSignature
+	}
+	if event == "installation" {
+		var inst struct {
+			Action       string `json:"action"`
+			Installation struct {
+				ID int64 `json:"id"`
+			} `json:"installation"`
+		}
+		if err := json.Unmarshal(body, &inst); err != nil {
+			return fmt.Errorf("%w: malformed installation payload", ErrInvalid)
+		}
+		if inst.Action == "deleted" {
+			return s.repo.unbindInstallation(shared(ctx), inst.Installation.ID)
+		}
+		return nil
+	}
+	if event != "push" {
+		return nil
+	}
+
+	var push struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			FullName      string `json:"full_name"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"repository"`
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		return fmt.Errorf("%w: malformed push payload", ErrInvalid)
+	}
+	if push.Ref != "refs/heads/"+push.Repository.DefaultBranch {
+		return nil
+	}
+
+	owner, err := s.repo.InstallationOwner(shared(ctx), push.Installation.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conns, err := s.repo.ListByGitHubRepo(tenancy.WithOrg(ctx, owner), owner, push.Installation.ID, push.Repository.FullName)
+	if err != nil {
+		return err
+	}
+	for _, c := range conns {
+		// GitHub expects a reply within seconds; the sync outlives the
+		// request. A sync already in flight skips this push and the next
+		// scheduled run picks the commits up.
+		go func(c *Connector) {
+			syncCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), syncLease)
+			defer cancel()
+			if _, err := s.run(syncCtx, c); err != nil {
+				s.logger.Warn("push-triggered sync skipped", "connector_id", c.ID, "error", err)
+			}
+		}(c)
+	}
+	return nil
+}
+
+// Run syncs every enabled connector that is due, every interval, until
+// ctx is cancelled. Ticks are skipped while paused (maintenance mode)
+// reports true; due connectors are picked up once it clears.
+func (s *Service) Run(ctx context.Context, interval time.Duration, paused func() bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !paused() {
+			s.syncDue(ctx, interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) syncDue(ctx context.Context, interval time.Duration) {
+	var due []*Connector
+	for _, scope := range s.scopes.Scopes(ctx) {
+		found, err := s.repo.ListDue(scope, interval)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("list due connectors failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+		}
+		due = append(due, found...)
+	}
+	for _, c := range due {
+		if _, err := s.run(ctx, c); err != nil && !errors.Is(err, ErrSyncRunning) {
+			s.logger.Error("connector sync failed", "connector_id", c.ID, "error", err)
+		}
+	}
+}
+
+// run fetches items changed since the connector's cursor and indexes them
+// oldest first. The cursor only advances past items that were indexed, so
+// a failed item (for example one whose document is still ingesting a
+// previous update) is fetched again next time.
+func (s *Service) run(ctx context.Context, c *Connector) (*SyncResult, error) {
+	ctx = tenancy.WithOrg(ctx, c.OrgID)
+	ok, err := s.repo.Claim(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSyncRunning
+	}
+
+	res := &SyncResult{}
+	var cursor *time.Time
+	defer func() {
+		// Record the outcome even if ctx was cancelled mid-sync.
+		if err := s.repo.Release(context.WithoutCancel(ctx), c.ID, cursor, res.Error); err != nil {
+			s.logger.Error("connector release failed", "connector_id", c.ID, "error", err)
+		}
+	}()
+
+	if s.quotas != nil {
+		// The cursor stays put, so nothing is missed.
+		if err := s.quotas.Check(ctx, c.OrgID, usage.IngestKinds...); err != nil {
+			res.Error = err.Error()
+			return res, nil
+		}
+	}
+
+	src, err := s.newSource(c.Kind, c.Config)
+	if err == nil {
+		err = s.checkGitHubOwner(ctx, c.OrgID, c.Kind, c.Config)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+
+	var since time.Time
+	if c.SyncCursor != nil {
+		since = *c.SyncCursor
+	}
+	items, err := src.Fetch(ctx, since)
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.Fetched = len(items)
+
+	sort.Slice(items, func(i, j int) bool { return items[i].UpdatedAt.Before(items[j].UpdatedAt) })
+
+	blocked := false
+	for i := range items {
+		it := &items[i]
+		outcome, err := s.syncItem(ctx, c, it)
+		if err != nil {
+			res.Failed++
+			if res.Error == "" {
+				res.Error = fmt.Sprintf("%s: %v", it.ExternalID, err)
+			}
+			s.logger.Warn("connector item sync failed",
+				"connector_id", c.ID, "external_id", it.ExternalID, "error", err)
+			blocked = true
+			continue
+		}
+		switch outcome {
+		case outcomeCreated:
+			res.Created++
+		case outcomeAppended:
+			res.Appended++
+		case outcomeReplaced:
+			res.Replaced++
+		case outcomeRemoved:
+			res.Removed++
+		default:
+			res.Unchanged++
+		}
+		if !blocked {
+			t := it.UpdatedAt
+			cursor = &t
+		}
+	}
+
+	s.logger.Info("connector synced", "connector_id", c.ID, "kind", c.Kind,
+		"fetched", res.Fetched, "created", res.Created, "appended", res.Appended,
+		"replaced", res.Replaced, "removed", res.Removed, "failed", res.Failed)
+	return res, nil
+}
+
+type outcome int
+
+const (
+	outcomeUnchanged outcome = iota
+	outcomeCreated
+	outcomeAppended
+	outcomeReplaced
+	outcomeRemoved
+)
+
+// syncItem indexes one fetched item:
+//   - new items become a document holding the rendered thread;
+//   - items whose title or body changed are re-indexed as a fresh document;
+//   - otherwise new comments and status changes are appended, so only the
+//     new text is embedded;
+//   - items deleted at the source lose their document.
+func (s *Service) syncItem(ctx context.Context, c *Connector, it *Item) (outcome, error) {
+	prev, err := s.repo.GetItem(ctx, c.ID, it.ExternalID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	if it.Deleted {
+		if prev == nil {
+			return outcomeUnchanged, nil
+		}
+		if err := s.docs.Delete(ctx, prev.DocumentID, c.OrgID, document.AnyVersion); err != nil &&
+			!errors.Is(err, document.ErrNotFound) {
+			return 0, err
+		}
+		return outcomeRemoved, s.repo.DeleteItem(ctx, c.ID, it.ExternalID)
+	}
+
+	rec := &SyncedItem{
+		ExternalID:      it.ExternalID,
+		Title:           it.Title,
+		URL:             it.URL,
+		Status:          it.Status,
+		ContentHash:     contentHash(it),
+		LastCommentAt:   lastCommentAt(it.Comments),
+		SourceUpdatedAt: it.UpdatedAt,
+		SyncedAt:        time.Now(),
+	}
+
+	switch {
+	case prev == nil:
+		doc, err := s.upload(ctx, c, it)
+		if err != nil {
+			return 0, err
+		}
+		rec.DocumentID = doc.ID
+		return outcomeCreated, s.repo.UpsertItem(ctx, c.ID, rec)
+
+	case !it.UpdatedAt.After(prev.SourceUpdatedAt):
+		// Incremental endpoints return items updated exactly at the cursor
+		// again; nothing new to index.
+		return outcomeUnchanged, nil
+
+	case prev.ContentHash != rec.ContentHash:
+		doc, err := s.upload(ctx, c, it)
+		if err != nil {
+			return 0, err
+		}
+		rec.DocumentID = doc.ID
+		if err := s.repo.UpsertItem(ctx, c.ID, rec); err != nil {
+			return 0, err
+		}
+		if err := s.docs.Delete(ctx, prev.DocumentID, c.OrgID, document.AnyVersion); err != nil &&
+			!errors.Is(err, document.ErrNotFound) {
+			s.logger.Warn("delete superseded connector document failed",
+				"document_id", prev.DocumentID, "error", err)
+		}
+		return outcomeReplaced, nil
+	}
+
+	text := renderUpdate(prev, it)
+	rec.DocumentID = prev.DocumentID
+	if text == "" {
+		return outcomeUnchanged, s.repo.UpsertItem(ctx, c.ID, rec)
+	}
+	if _, err := s.docs.Append(ctx, prev.DocumentID, c.OrgID, text); err != nil {
+		return 0, err
+	}
+	return outcomeAppended, s.repo.UpsertItem(ctx, c.ID, rec)
+}
+
+func (s *Service) upload(ctx context.Context, c *Connector, it *Item) (*document.Document, error) {
+	return s.docs.Upload(ctx, document.UploadRequest{
+		OrgID:    c.OrgID,
+		Name:     documentName(c.Kind, it),
+		Content:  render(it),
+		Metadata: it.Metadata,
+	})
+}
+
+// documentName is what shows up in listings and as doc_name in citations,
+// e.g. "Zendesk ticket 4521: Refund not received". Files keep their path
+// so the document splitter can recognize source code by extension; feed
+// entries keep their headline.
+func documentName(kind Kind, it *Item) string {
+	var source string
+	switch kind {
+	case KindGitHub, KindFeed:
+		return it.Title
+	case KindJira:
+		source = "Jira"
+	default:
+		source = "Zendesk"
+	}
+	return fmt.Sprintf("%s %s %s: %s", source, it.Type, strings.TrimPrefix(it.ExternalID, it.Type+":"), it.Title)
+}
+
+// render produces the full text of an item. The header carries the source
+// status so answers can tell resolved tickets from open ones, and the link
+// so they can point at the original.
+func render(it *Item) string {
+	var sb strings.Builder
+	sb.WriteString(it.Title + "\n")
+	if it.Status != "" {
+		fmt.Fprintf(&sb, "Status: %s\n", it.Status)
+	}
+	if it.URL != "" {
+		fmt.Fprintf(&sb, "Link: %s\n", it.URL)
+	}
+	if it.Body != "" {
+		sb.WriteString("\n" + it.Body + "\n")
+	}
+	for _, cm := range it.Comments {
+		writeComment(&sb, cm)
+	}
+	return sb.String()
+}
+
+// renderUpdate returns the text to append for an already indexed item:
+// its status change, if any, and comments newer than the last indexed one.
+func renderUpdate(prev *SyncedItem, it *Item) string {
+	var sb strings.Builder
+	if it.Status != prev.Status {
+		fmt.Fprintf(&sb, "\nStatus changed from %s to %s on %s\n",
+			prev.Status, it.Status, it.UpdatedAt.Format(time.RFC3339))
+	}
+	for _, cm := range it.Comments {
+		if prev.LastCommentAt == nil || cm.CreatedAt.After(*prev.LastCommentAt) {
+			writeComment(&sb, cm)
+		}
+	}
+	return sb.String()
+}
+
+func writeComment(sb *strings.Builder, cm Comment) {
+	fmt.Fprintf(sb, "\n--- %s on %s ---\n%s\n", cm.Author, cm.CreatedAt.Format(time.RFC3339), cm.Body)
+}
+
+// contentHash covers only the parts of an item that can't be appended:
+// edits to the title or body force a re-index.
+func contentHash(it *Item) string {
+	sum := sha256.Sum256([]byte(it.Title + "\x00" + it.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+func lastCommentAt(comments []Comment) *time.Time {
+	var last *time.Time
+	for i := range comments {
+		if last == nil || comments[i].CreatedAt.After(*last) {
+			last = &comments[i].CreatedAt
+		}
+	}
+	return last
+}
@@ -0,0 +1,510 @@
+package connector
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+)
+
+const githubAPI = "https://api.github.com"
+
+// maxGitHubFileBytes skips generated bundles, lockfiles and other blobs too
+// large to be useful context.
+const maxGitHubFileBytes = 256 << 10
+
+var (
+	ErrBadSignature = errors.New("invalid webhook signature")
+	// ErrInstallationUnverified is returned by the install callback when
+	// GitHub doesn't confirm the installing user has the installation.
+	ErrInstallationUnverified = errors.New("github installation could not be verified")
+	// ErrInstallationTaken is returned when binding an installation
+	// another org owns.
+	ErrInstallationTaken = errors.New("github installation belongs to another organization")
+)
+
+// GitHubApp holds the server-wide GitHub App credentials. Tenants install
+// the app through the install flow (Service.StartGitHubInstall), which
+// binds the installation to their org, and register connectors with the
+// installation ID; the app's private key mints short-lived installation
+// tokens.
+type GitHubApp struct {
+	appID         string
+	key           *rsa.PrivateKey
+	webhookSecret []byte
+	slug          string
+	clientID      string
+	clientSecret  string
+	client        *http.Client
+
+	mu     sync.Mutex
+	tokens map[int64]installationToken
+}
+
+// GitHubAppConfig configures the GitHub App.
+type GitHubAppConfig struct {
+	AppID         string
+	PrivateKeyPEM string
+	// WebhookSecret verifies push deliveries.
+	WebhookSecret string
+	// Slug names the app in its install URL,
+	// https://github.com/apps/<slug>/installations/new.
+	Slug string
+	// ClientID and ClientSecret are the app's OAuth credentials. The app
+	// must request user authorization during installation, so the install
+	// callback can confirm the installing user has access to the
+	// installation it reports.
+	ClientID     string
+	ClientSecret string
+}
+
+type installationToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewGitHubApp parses the app's PEM private key.
+func NewGitHubApp(cfg GitHubAppConfig) (*GitHubApp, error) {
+	if cfg.Slug == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("github app slug, client ID and client secret are required to bind installations to orgs")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cfg.PrivateKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("parse github app private key: %w", err)
+	}
+	return &GitHubApp{
+		appID:         cfg.AppID,
+		key:           key,
+		webhookSecret: []byte(cfg.WebhookSecret),
+		slug:          cfg.Slug,
+		clientID:      cfg.ClientID,
+		clientSecret:  cfg.ClientSecret,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		tokens:        make(map[int64]installationToken),
+	}, nil
+}
+
+// VerifySignature checks a webhook body against its X-Hub-Signature-256
+// header.
+func (a *GitHubApp) VerifySignature(body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, a.webhookSecret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// installationToken returns a cached installation access token, minting a
+// new one when the cached token is within five minutes of expiry.
+func (a *GitHubApp) installationToken(ctx context.Context, installationID int64) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.tokens[installationID]
+	a.mu.Unlock()
+	if ok && time.Until(cached.expires) > 5*time.Minute {
+		return cached.token, nil
+	}
+
+	now := time.Now()
+	appJWT, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    a.appID,
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)), // tolerate clock skew
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	}).SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPI, installationID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("github installation token: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.tokens[installationID] = installationToken{token: body.Token, expires: body.ExpiresAt}
+	a.mu.Unlock()
+	return body.Token, nil
+}
+
+// installURL is where an org admin installs the app; GitHub hands state
+// back to the install callback.
+func (a *GitHubApp) installURL(state string) string {
+	return "https://github.com/apps/" + url.PathEscape(a.slug) + "/installations/new?state=" + url.QueryEscape(state)
+}
+
+// verifyInstallation exchanges the OAuth code GitHub passes the install
+// callback for the installing user's token, and returns the account of
+// installationID if that user has access to it. An installation ID alone
+// proves nothing: anyone can put another org's in a callback URL.
+func (a *GitHubApp) verifyInstallation(ctx context.Context, code string, installationID int64) (string, error) {
+	form := url.Values{}
+	form.Set("client_id", a.clientID)
+	form.Set("client_secret", a.clientSecret)
+	form.Set("code", code)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return "", fmt.Errorf("github oauth: %w", err)
+	}
+	if grant.AccessToken == "" {
+		return "", fmt.Errorf("%w: github refused the authorization code (%s)", ErrInstallationUnverified, grant.Error)
+	}
+
+	for page := 1; ; page++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/user/installations?per_page=100&page=%d", githubAPI, page), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+grant.AccessToken)
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		var body struct {
+			Installations []struct {
+				ID      int64 `json:"id"`
+				Account struct {
+					Login string `json:"login"`
+				} `json:"account"`
+			} `json:"installations"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("github user installations: status %d", resp.StatusCode)
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, inst := range body.Installations {
+			if inst.ID == installationID {
+				return inst.Account.Login, nil
+			}
+		}
+		if len(body.Installations) < 100 {
+			return "", fmt.Errorf("%w: the installing user has no access to installation %d", ErrInstallationUnverified, installationID)
+		}
+	}
+}
+
+// GitHubConfig is the stored config of a GitHub connector. Repos are
+// "owner/name"; README and docs files are always indexed, source code only
+// with IncludeCode.
+type GitHubConfig struct {
+	InstallationID int64    `json:"installation_id"`
+	Repos          []string `json:"repos"`
+	IncludeCode    bool     `json:"include_code"`
+}
+
+type githubSource struct {
+	cfg    GitHubConfig
+	app    *GitHubApp
+	client *http.Client
+}
+
+func newGitHubSource(cfg GitHubConfig, app *GitHubApp, client *http.Client) (*githubSource, error) {
+	if app == nil {
+		return nil, errors.New("github: the GitHub App is not configured on this server")
+	}
+	if cfg.InstallationID == 0 {
+		return nil, errors.New("github: installation_id is required")
+	}
+	if len(cfg.Repos) == 0 {
+		return nil, errors.New("github: at least one repo is required")
+	}
+	for _, r := range cfg.Repos {
+		if owner, name, ok := strings.Cut(r, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("github: repo %q must be owner/name", r)
+		}
+	}
+	return &githubSource{cfg: cfg, app: app, client: client}, nil
+}
+
+// Fetch returns the wanted files of each repo's default branch. A full
+// sync walks the whole tree; an incremental one only the files touched by
+// commits since the cursor, including removals.
+func (g *githubSource) Fetch(ctx context.Context, since time.Time) ([]Item, error) {
+	token, err := g.app.installationToken(ctx, g.cfg.InstallationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	for _, repo := range g.cfg.Repos {
+		repoItems, err := g.fetchRepo(ctx, token, repo, since)
+		if err != nil {
+			return nil, fmt.Errorf("github %s: %w", repo, err)
+		}
+		items = append(items, repoItems...)
+	}
+	return items, nil
+}
+
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Committer struct {
+			Date time.Time `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+}
+
+func (g *githubSource) fetchRepo(ctx context.Context, token, repo string, since time.Time) ([]Item, error) {
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := g.get(ctx, token, "/repos/"+repo, &info); err != nil {
+		return nil, err
+	}
+	var head githubCommit
+	if err := g.get(ctx, token, "/repos/"+repo+"/commits/"+url.PathEscape(info.DefaultBranch), &head); err != nil {
+		return nil, err
+	}
+
+	// changed and removed map a path to the time of the last commit that
+	// modified or deleted it.
+	changed := map[string]time.Time{}
+	removed := map[string]time.Time{}
+
+	if since.IsZero() {
+		var tree struct {
+			Tree []struct {
+				Path string `json:"path"`
+				Type string `json:"type"`
+				Size int    `json:"size"`
+			} `json:"tree"`
+		}
+		if err := g.get(ctx, token, "/repos/"+repo+"/git/trees/"+head.SHA+"?recursive=1", &tree); err != nil {
+			return nil, err
+		}
+		for _, e := range tree.Tree {
+			if e.Type == "blob" && e.Size <= maxGitHubFileBytes && g.wanted(e.Path) {
+				changed[e.Path] = head.Commit.Committer.Date
+			}
+		}
+	} else {
+		commits, err := g.commitsSince(ctx, token, repo, info.DefaultBranch, since)
+		if err != nil {
+			return nil, err
+		}
+		// Oldest first, so a later commit's verdict on a path wins.
+		for i := len(commits) - 1; i >= 0; i-- {
+			var detail struct {
+				Files []struct {
+					Filename         string `json:"filename"`
+					Status           string `json:"status"`
+					PreviousFilename string `json:"previous_filename"`
+				} `json:"files"`
+			}
+			if err := g.get(ctx, token, "/repos/"+repo+"/commits/"+commits[i].SHA, &detail); err != nil {
+				return nil, err
+			}
+			at := commits[i].Commit.Committer.Date
+			for _, f := range detail.Files {
+				if f.PreviousFilename != "" {
+					removed[f.PreviousFilename] = at
+					delete(changed, f.PreviousFilename)
+				}
+				if f.Status == "removed" {
+					removed[f.Filename] = at
+					delete(changed, f.Filename)
+					continue
+				}
+				if g.wanted(f.Filename) {
+					changed[f.Filename] = at
+					delete(removed, f.Filename)
+				}
+			}
+		}
+	}
+
+	items := make([]Item, 0, len(changed)+len(removed))
+	for p, at := range removed {
+		items = append(items, Item{ExternalID: repo + ":" + p, Type: "file", Title: p, UpdatedAt: at, Deleted: true})
+	}
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		content, err := g.raw(ctx, token, repo, p, head.SHA)
+		if err != nil {
+			return nil, err
+		}
+		if content == nil {
+			continue // too large or binary
+		}
+		items = append(items, Item{
+			ExternalID: repo + ":" + p,
+			Type:       "file",
+			Title:      repo + "/" + p,
+			URL:        "https://github.com/" + repo + "/blob/" + info.DefaultBranch + "/" + p,
+			Body:       *content,
+			UpdatedAt:  changed[p],
+			Metadata:   map[string]any{document.MetaPath: repo + "/" + p},
+		})
+	}
+	return items, nil
+}
+
+func (g *githubSource) commitsSince(ctx context.Context, token, repo, branch string, since time.Time) ([]githubCommit, error) {
+	var all []githubCommit
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("sha", branch)
+		q.Set("since", since.UTC().Format(time.RFC3339))
+		q.Set("per_page", "100")
+		q.Set("page", strconv.Itoa(page))
+
+		var commits []githubCommit
+		if err := g.get(ctx, token, "/repos/"+repo+"/commits?"+q.Encode(), &commits); err != nil {
+			return nil, err
+		}
+		all = append(all, commits...)
+		if len(commits) < 100 {
+			return all, nil
+		}
+	}
+}
+
+var docExts = map[string]bool{".md": true, ".mdx": true, ".rst": true, ".txt": true, ".adoc": true}
+
+// wanted reports whether a repo path is indexed: READMEs and files under
+// docs/ always, recognized source files only with IncludeCode. Vendored and
+// generated trees are skipped.
+func (g *githubSource) wanted(p string) bool {
+	for _, dir := range []string{"vendor/", "node_modules/", "third_party/", "testdata/", "dist/"} {
+		if strings.HasPrefix(p, dir) || strings.Contains(p, "/"+dir) {
+			return false
+		}
+	}
+	base := strings.ToLower(path.Base(p))
+	ext := strings.ToLower(path.Ext(p))
+	if strings.HasPrefix(base, "readme") {
+		return true
+	}
+	if docExts[ext] && (strings.HasPrefix(p, "docs/") || strings.HasPrefix(p, "doc/") || !strings.Contains(p, "/")) {
+		return true
+	}
+	return g.cfg.IncludeCode && document.CodeLanguage(p) != ""
+}
+
+// raw downloads a file's content at ref. It returns nil for files over the
+// size limit, containing NUL bytes (binary) or missing at ref.
+func (g *githubSource) raw(ctx context.Context, token, repo, p, ref string) (*string, error) {
+	var escaped []string
+	for _, seg := range strings.Split(p, "/") {
+		escaped = append(escaped, url.PathEscape(seg))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		githubAPI+"/repos/"+repo+"/contents/"+strings.Join(escaped, "/")+"?ref="+ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github.raw+json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // removed by a commit newer than ref
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("contents %s: status %d", p, resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxGitHubFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxGitHubFileBytes || strings.ContainsRune(string(b), 0) {
+		return nil, nil
+	}
+	s := string(b)
+	return &s, nil
+}
+
+func (g *githubSource) get(ctx context.Context, token, apiPath string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubAPI+apiPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
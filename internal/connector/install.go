@@ -0,0 +1,209 @@
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// GitHub App installations
+// An installation ID is only a number, and GitHub connectors mint tokens
+// for whatever installation they name, so the server keeps which org owns
+// each one. An org admin starts an install (StartGitHubInstall) and is
+// sent to GitHub with a single-use state; GitHub redirects back to the
+// install callback with the state, the installation ID and an OAuth code
+// of the installing user. The installation is bound to the state's org
+// only when that user has access to it. Connectors naming an installation
+// their org doesn't own are refused, and stop syncing if it moves.
+//
+// The bindings live in the shared tables, whatever the org's placement.
+
+// githubInstallTTL is how long an install link stays usable.
+const githubInstallTTL = 30 * time.Minute
+
+// GitHubInstallation is an installation of the GitHub App bound to an org.
+type GitHubInstallation struct {
+	InstallationID int64     `json:"installation_id"`
+	OrgID          string    `json:"org_id"`
+	Account        string    `json:"account"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const installationColumns = `installation_id, org_id, account, created_by, created_at`
+
+func scanInstallation(row pgx.Row) (*GitHubInstallation, error) {
+	inst := &GitHubInstallation{}
+	err := row.Scan(&inst.InstallationID, &inst.OrgID, &inst.Account, &inst.CreatedBy, &inst.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (r *Repository) createInstallState(ctx context.Context, stateHash, orgID, userID string, expires time.Time) error {
+	// Abandoned installs pile up otherwise.
+	if _, err := r.db.Exec(ctx, `DELETE FROM github_install_states WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO github_install_states (state_hash, org_id, user_id, expires_at) VALUES ($1, $2, $3, $4)`,
+		stateHash, orgID, userID, expires)
+	return err
+}
+
+// consumeInstallState deletes a pending install and returns its org and
+// user. It returns ErrNotFound for unknown, used and expired states.
+func (r *Repository) consumeInstallState(ctx context.Context, stateHash string) (orgID, userID string, err error) {
+	err = r.db.QueryRow(ctx,
+		`DELETE FROM github_install_states WHERE state_hash = $1 AND expires_at > NOW()
+		 RETURNING org_id, user_id`,
+		stateHash).Scan(&orgID, &userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", ErrNotFound
+	}
+	return orgID, userID, err
+}
+
+// bindInstallation records that inst.OrgID owns the installation. Binding
+// it again to the same org refreshes the account; binding an installation
+// another org owns returns ErrInstallationTaken.
+func (r *Repository) bindInstallation(ctx context.Context, inst *GitHubInstallation) error {
+	tag, err := r.db.Exec(ctx,
+		`INSERT INTO github_installations (`+installationColumns+`) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (installation_id) DO UPDATE SET account = EXCLUDED.account
+		 WHERE github_installations.org_id = EXCLUDED.org_id`,
+		inst.InstallationID, inst.OrgID, inst.Account, inst.CreatedBy, inst.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInstallationTaken
+	}
+	return nil
+}
+
+func (r *Repository) unbindInstallation(ctx context.Context, installationID int64) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM github_installations WHERE installation_id = $1`, installationID)
+	return err
+}
+
+// InstallationOwner returns the org an installation is bound to, or
+// ErrNotFound.
+func (r *Repository) InstallationOwner(ctx context.Context, installationID int64) (string, error) {
+	var orgID string
+	err := r.db.QueryRow(ctx,
+		`SELECT org_id FROM github_installations WHERE installation_id = $1`, installationID).Scan(&orgID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return orgID, err
+}
+
+func (r *Repository) ListInstallations(ctx context.Context, orgID string) ([]*GitHubInstallation, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+installationColumns+` FROM github_installations WHERE org_id = $1 ORDER BY created_at`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*GitHubInstallation, error) {
+		return scanInstallation(row)
+	})
+}
+
+// shared routes a context to the shared tables, where installations live.
+func shared(ctx context.Context) context.Context {
+	return tenancy.WithOrg(ctx, "")
+}
+
+func hashInstallState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartGitHubInstall returns the URL an admin of orgID follows to install
+// the GitHub App. It returns ErrNotFound when no GitHub App is configured.
+func (s *Service) StartGitHubInstall(ctx context.Context, orgID, userID string) (string, error) {
+	if s.github == nil {
+		return "", ErrNotFound
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(b)
+	if err := s.repo.createInstallState(shared(ctx), hashInstallState(state), orgID, userID,
+		time.Now().Add(githubInstallTTL)); err != nil {
+		return "", err
+	}
+	return s.github.installURL(state), nil
+}
+
+// FinishGitHubInstall handles GitHub's redirect after an install: it binds
+// installationID to the org that started the install, once GitHub
+// confirms through code that the installing user has access to it.
+func (s *Service) FinishGitHubInstall(ctx context.Context, state, code string, installationID int64) (*GitHubInstallation, error) {
+	if s.github == nil {
+		return nil, ErrNotFound
+	}
+	if state == "" || code == "" || installationID <= 0 {
+		return nil, fmt.Errorf("%w: state, code and installation_id are required", ErrInvalid)
+	}
+	orgID, userID, err := s.repo.consumeInstallState(shared(ctx), hashInstallState(state))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: the install link expired or was already used; start again", ErrInvalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.github.verifyInstallation(ctx, code, installationID)
+	if err != nil {
+		return nil, err
+	}
+	inst := &GitHubInstallation{
+		InstallationID: installationID,
+		OrgID:          orgID,
+		Account:        account,
+		CreatedBy:      userID,
+		CreatedAt:      time.Now(),
+	}
+	if err := s.repo.bindInstallation(shared(ctx), inst); err != nil {
+		return nil, err
+	}
+	s.logger.Info("github installation bound", "org_id", orgID, "installation_id", installationID, "account", account)
+	return inst, nil
+}
+
+// GitHubInstallations lists the installations bound to orgID.
+func (s *Service) GitHubInstallations(ctx context.Context, orgID string) ([]*GitHubInstallation, error) {
+	return s.repo.ListInstallations(shared(ctx), orgID)
+}
+
+// checkGitHubOwner refuses a GitHub connector config naming an
+// installation orgID doesn't own. Other kinds pass.
+func (s *Service) checkGitHubOwner(ctx context.Context, orgID string, kind Kind, config json.RawMessage) error {
+	if kind != KindGitHub {
+		return nil
+	}
+	var cfg GitHubConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return fmt.Errorf("%w: invalid github config: %w", ErrInvalid, err)
+	}
+	owner, err := s.repo.InstallationOwner(shared(ctx), cfg.InstallationID)
+	if errors.Is(err, ErrNotFound) || (err == nil && owner != orgID) {
+		return fmt.Errorf("%w: github installation %d isn't bound to this organization; install the app through POST /api/v1/connectors/github/install",
+			ErrInvalid, cfg.InstallationID)
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+// Package conversation stores chat threads so queries can be multi-turn:
+// each conversation belongs to one user within an org, and its recent
+// messages are replayed into the prompt of the next question.
+package conversation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+var ErrNotFound = errors.New("conversation not found")
+
+// Message roles.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// historyMessages is how many of the latest messages (question/answer
+// pairs) are replayed into a follow-up's prompt.
+const historyMessages = 10
+
+// maxTitleLen bounds titles derived from a conversation's first question.
+const maxTitleLen = 80
+
+type Conversation struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	UserID    string    `json:"user_id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Message struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	Role           string    `json:"role"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db database.DBTX) *Repository {
+	return &Repository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx}
+}
+
+const conversationColumns = `id, org_id, user_id, title, created_at, updated_at`
+
+func scanConversation(row pgx.Row) (*Conversation, error) {
+	c := &Conversation{}
+	err := row.Scan(&c.ID, &c.OrgID, &c.UserID, &c.Title, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Repository) Create(ctx context.Context, c *Conversation) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO conversations (`+conversationColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ID, c.OrgID, c.UserID, c.Title, c.CreatedAt, c.UpdatedAt,
+	)
+	return err
+}
+
+// Get returns a conversation only to its owner; anyone else gets
+// ErrNotFound.
+func (r *Repository) Get(ctx context.Context, id, orgID, userID string) (*Conversation, error) {
+	return scanConversation(r.db.QueryRow(ctx,
+		`SELECT `+conversationColumns+` FROM conversations WHERE id = $1 AND org_id = $2 AND user_id = $3`,
+		id, orgID, userID))
+}
+
+func (r *Repository) ListByUser(ctx context.Context, orgID, userID string) ([]*Conversation, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+conversationColumns+` FROM conversations
+		 WHERE org_id = $1 AND user_id = $2 ORDER BY updated_at DESC`, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Conversation, error) {
+		return scanConversation(row)
+	})
+}
+
+// Touch bumps updated_at and fills in the title if it is still empty.
+func (r *Repository) Touch(ctx context.Context, id, title string, at time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE conversations SET updated_at = $2, title = CASE WHEN title = '' THEN $3 ELSE title END
+		 WHERE id = $1`, id, at, title)
+	return err
+}
+
+func (r *Repository) AddMessage(ctx context.Context, m *Message) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO conversation_messages (id, conversation_id, role, content, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		m.ID, m.ConversationID, m.Role, m.Content, m.CreatedAt,
+	)
+	return err
+}
+
+func scanMessage(row pgx.Row) (*Message, error) {
+	m := &Message{}
+	if err := row.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ListMessages returns a conversation's messages oldest first. A positive
+// limit keeps only the latest limit messages.
+func (r *Repository) ListMessages(ctx context.Context, conversationID string, limit int) ([]*Message, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT id, conversation_id, role, content, created_at FROM (
+		     SELECT * FROM conversation_messages WHERE conversation_id = $1
+		     ORDER BY created_at DESC LIMIT NULLIF($2, 0)
+		 ) m ORDER BY created_at`, conversationID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Message, error) {
+		return scanMessage(row)
+	})
+}
+
+type Service struct {
+	repo *Repository
+	uow  *database.UnitOfWork
+}
+
+func NewService(repo *Repository, uow *database.UnitOfWork) *Service {
+	return &Service{repo: repo, uow: uow}
+}
+
+// Create starts a conversation. An empty title is filled in from the
+// first question.
+func (s *Service) Create(ctx context.Context, orgID, userID, title string) (*Conversation, error) {
+	now := time.Now()
+	c := &Conversation{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		UserID:    userID,
+		Title:     truncateTitle(title),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Service) Get(ctx context.Context, id, orgID, userID string) (*Conversation, error) {
+	return s.repo.Get(ctx, id, orgID, userID)
+}
+
+func (s *Service) List(ctx context.Context, orgID, userID string) ([]*Conversation, error) {
+	return s.repo.ListByUser(ctx, orgID, userID)
+}
+
+// Messages returns the full message history of a user's conversation.
+func (s *Service) Messages(ctx context.Context, id, orgID, userID string) ([]*Message, error) {
+	if _, err := s.repo.Get(ctx, id, orgID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListMessages(ctx, id, 0)
+}
+
+// History returns the latest turns of a conversation in the form the RAG
+// prompt takes them.
+func (s *Service) History(ctx context.Context, c *Conversation) ([]retrieval.Turn, error) {
+	msgs, err := s.repo.ListMessages(ctx, c.ID, historyMessages)
+	if err != nil {
+		return nil, err
+	}
+	turns := make([]retrieval.Turn, len(msgs))
+	for i, m := range msgs {
+		turns[i] = retrieval.Turn{Role: m.Role, Content: m.Content}
+	}
+	return turns, nil
+}
+
+// Record appends a question and its answer to a conversation. askedAt is
+// when the question came in, so the pair sorts in order.
+func (s *Service) Record(ctx context.Context, c *Conversation, question, answer string, askedAt time.Time) error {
+	answeredAt := time.Now()
+	return s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		for _, m := range []*Message{
+			{ID: uuid.NewString(), ConversationID: c.ID, Role: RoleUser, Content: question, CreatedAt: askedAt},
+			{ID: uuid.NewString(), ConversationID: c.ID, Role: RoleAssistant, Content: answer, CreatedAt: answeredAt},
+		} {
+			if err := repo.AddMessage(ctx, m); err != nil {
+				return err
+			}
+		}
+		return repo.Touch(ctx, c.ID, truncateTitle(question), answeredAt)
+	})
+}
+
+func truncateTitle(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxTitleLen {
+		return string(r[:maxTitleLen-1]) + "…"
+	}
+	return s
+}
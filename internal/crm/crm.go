@@ -0,0 +1,333 @@
+// Package crm looks up customer context (contact, company, plan and custom
+// attributes) in an org's CRM so agent tools can ground support answers in
+// the asking customer's account. Each org brings its own Intercom and/or
+// HubSpot credentials.
+package crm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/secret"
+)
+
+var (
+	ErrNotFound        = errors.New("crm integration not found")
+	ErrUnknownProvider = errors.New("provider must be one of: intercom, hubspot")
+	ErrInvalid         = errors.New("invalid crm integration")
+	ErrNotConfigured   = errors.New("no crm integration is configured")
+)
+
+type Provider string
+
+const (
+	ProviderIntercom Provider = "intercom"
+	ProviderHubSpot  Provider = "hubspot"
+)
+
+// Config is the stored credential of an integration. Both providers use a
+// bearer token (Intercom access token, HubSpot private app token).
+type Config struct {
+	AccessToken string `json:"access_token"`
+}
+
+// Integration is an org's link to one CRM. Config is never serialized back
+// to clients.
+type Integration struct {
+	OrgID     string    `json:"org_id"`
+	Provider  Provider  `json:"provider"`
+	Config    Config    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// sealed is Config as stored, encrypted; nil for rows stored in
+	// plaintext before credentials were encrypted.
+	sealed []byte
+}
+
+// Customer is the account data a CRM holds about one person.
+type Customer struct {
+	Provider   Provider
+	ID         string
+	Name       string
+	Email      string
+	Company    string
+	URL        string
+	Attributes map[string]string
+}
+
+// Client finds a customer by email in one CRM; (nil, nil) means no match.
+type Client interface {
+	FindByEmail(ctx context.Context, email string) (*Customer, error)
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const integrationColumns = `org_id, provider, config, sealed_config, created_at, updated_at`
+
+func scanIntegration(row pgx.Row) (*Integration, error) {
+	in := &Integration{}
+	err := row.Scan(&in.OrgID, &in.Provider, &in.Config, &in.sealed, &in.CreatedAt, &in.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// Upsert creates the org's integration for a provider or replaces its
+// credentials, given sealed.
+func (r *Repository) Upsert(ctx context.Context, orgID string, provider Provider, sealed []byte) (*Integration, error) {
+	return scanIntegration(r.db.QueryRow(ctx,
+		`INSERT INTO crm_integrations (org_id, provider, config, sealed_config)
+		 VALUES ($1, $2, '{}', $3)
+		 ON CONFLICT (org_id, provider) DO UPDATE SET config = '{}', sealed_config = EXCLUDED.sealed_config, updated_at = NOW()
+		 RETURNING `+integrationColumns,
+		orgID, provider, sealed,
+	))
+}
+
+// ListPlaintext returns the integrations whose credentials were stored
+// before they were encrypted.
+func (r *Repository) ListPlaintext(ctx context.Context) ([]*Integration, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+integrationColumns+` FROM crm_integrations WHERE sealed_config IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Integration, error) {
+		return scanIntegration(row)
+	})
+}
+
+// seal replaces an integration's plaintext credentials with sealed, unless
+// they were replaced since.
+func (r *Repository) seal(ctx context.Context, orgID string, provider Provider, sealed []byte) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE crm_integrations SET config = '{}', sealed_config = $3
+		 WHERE org_id = $1 AND provider = $2 AND sealed_config IS NULL`,
+		orgID, provider, sealed)
+	return err
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Integration, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+integrationColumns+` FROM crm_integrations WHERE org_id = $1 ORDER BY provider`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Integration, error) {
+		return scanIntegration(row)
+	})
+}
+
+func (r *Repository) Delete(ctx context.Context, orgID string, provider Provider) error {
+	tag, err := r.db.Exec(ctx,
+		`DELETE FROM crm_integrations WHERE org_id = $1 AND provider = $2`, orgID, provider)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type Service struct {
+	repo   *Repository
+	client *http.Client
+	box    *secret.Box
+}
+
+// NewService creates the service. client makes the CRM API calls; pass nil
+// for the default client, or an internal-only one in offline mode. box
+// encrypts the stored credentials.
+func NewService(repo *Repository, client *http.Client, box *secret.Box) *Service {
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	return &Service{repo: repo, client: client, box: box}
+}
+
+// sealLabel binds sealed credentials to their org and provider.
+func sealLabel(orgID string, provider Provider) string {
+	return "crm:" + orgID + ":" + string(provider)
+}
+
+func (s *Service) seal(orgID string, provider Provider, cfg Config) ([]byte, error) {
+	plaintext, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.box.Seal(plaintext, sealLabel(orgID, provider)), nil
+}
+
+// open fills in an integration's Config from its sealed credentials.
+func (s *Service) open(in *Integration) error {
+	if in.sealed == nil {
+		return nil
+	}
+	plaintext, err := s.box.Open(in.sealed, sealLabel(in.OrgID, in.Provider))
+	if err != nil {
+		return fmt.Errorf("%s credentials: %w", in.Provider, err)
+	}
+	return json.Unmarshal(plaintext, &in.Config)
+}
+
+// SealPlaintext encrypts credentials stored in plaintext before they were
+// encrypted, and returns how many it sealed.
+func (s *Service) SealPlaintext(ctx context.Context) (int, error) {
+	integrations, err := s.repo.ListPlaintext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, in := range integrations {
+		sealed, err := s.seal(in.OrgID, in.Provider, in.Config)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.repo.seal(ctx, in.OrgID, in.Provider, sealed); err != nil {
+			return 0, err
+		}
+	}
+	return len(integrations), nil
+}
+
+func (s *Service) newClient(provider Provider, cfg Config) (Client, error) {
+	switch provider {
+	case ProviderIntercom:
+		return &intercomClient{token: cfg.AccessToken, http: s.client}, nil
+	case ProviderHubSpot:
+		return &hubspotClient{token: cfg.AccessToken, http: s.client}, nil
+	default:
+		return nil, ErrUnknownProvider
+	}
+}
+
+// Set stores the org's credentials for a provider, replacing any existing
+// ones.
+func (s *Service) Set(ctx context.Context, orgID string, provider Provider, cfg Config) (*Integration, error) {
+	if _, err := s.newClient(provider, cfg); err != nil {
+		return nil, err
+	}
+	cfg.AccessToken = strings.TrimSpace(cfg.AccessToken)
+	if cfg.AccessToken == "" {
+		return nil, fmt.Errorf("%w: access_token is required", ErrInvalid)
+	}
+	sealed, err := s.seal(orgID, provider, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Upsert(ctx, orgID, provider, sealed)
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Integration, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+func (s *Service) Delete(ctx context.Context, orgID string, provider Provider) error {
+	return s.repo.Delete(ctx, orgID, provider)
+}
+
+// LookupCustomer searches each of the org's CRMs for the email and returns
+// every match. A provider that fails doesn't hide matches from the others;
+// its error is returned only if nothing was found anywhere.
+func (s *Service) LookupCustomer(ctx context.Context, orgID, email string) ([]*Customer, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("%w: a customer email is required", ErrInvalid)
+	}
+
+	integrations, err := s.repo.ListByOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if len(integrations) == 0 {
+		return nil, ErrNotConfigured
+	}
+
+	var (
+		found []*Customer
+		errs  []error
+	)
+	for _, in := range integrations {
+		if err := s.open(in); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		client, err := s.newClient(in.Provider, in.Config)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c, err := client.FindByEmail(ctx, email)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.Provider, err))
+			continue
+		}
+		if c != nil {
+			found = append(found, c)
+		}
+	}
+	if len(found) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return found, nil
+}
+
+// Render formats a customer as a plain-text block for a model's context.
+func (c *Customer) Render() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Customer (%s)\n", c.Provider)
+	writeField(&sb, "Name", c.Name)
+	writeField(&sb, "Email", c.Email)
+	writeField(&sb, "Company", c.Company)
+	writeField(&sb, "Record", c.URL)
+
+	keys := make([]string, 0, len(c.Attributes))
+	for k := range c.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(&sb, k, c.Attributes[k])
+	}
+	return sb.String()
+}
+
+func writeField(sb *strings.Builder, label, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		fmt.Fprintf(sb, "%s: %s\n", label, value)
+	}
+}
+
+// attrString flattens a CRM attribute value to text, dropping empties.
+func attrString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
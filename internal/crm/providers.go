@@ -0,0 +1,161 @@
+package crm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	intercomAPI = "https://api.intercom.io"
+	hubspotAPI  = "https://api.hubapi.com"
+)
+
+// hubspotProperties are the contact fields fetched from HubSpot; only the
+// ones with a value end up in the customer's attributes.
+var hubspotProperties = []string{
+	"firstname", "lastname", "email", "company", "jobtitle", "phone",
+	"lifecyclestage", "hs_lead_status", "createdate", "lastmodifieddate",
+}
+
+type intercomClient struct {
+	token string
+	http  *http.Client
+}
+
+func (c *intercomClient) FindByEmail(ctx context.Context, email string) (*Customer, error) {
+	body := map[string]any{
+		"query": map[string]any{"field": "email", "operator": "=", "value": email},
+	}
+	var resp struct {
+		Data []struct {
+			ID               string         `json:"id"`
+			WorkspaceID      string         `json:"workspace_id"`
+			Name             string         `json:"name"`
+			Email            string         `json:"email"`
+			Role             string         `json:"role"`
+			CreatedAt        int64          `json:"created_at"`
+			LastSeenAt       int64          `json:"last_seen_at"`
+			CustomAttributes map[string]any `json:"custom_attributes"`
+			Location         struct {
+				Country string `json:"country"`
+			} `json:"location"`
+		} `json:"data"`
+	}
+	err := postJSON(ctx, c.http, intercomAPI+"/contacts/search", c.token,
+		map[string]string{"Intercom-Version": "2.11"}, body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, nil
+	}
+
+	d := resp.Data[0]
+	attrs := map[string]string{
+		"role":         d.Role,
+		"country":      d.Location.Country,
+		"signed_up_at": unixDate(d.CreatedAt),
+		"last_seen_at": unixDate(d.LastSeenAt),
+	}
+	for k, v := range d.CustomAttributes {
+		attrs[k] = attrString(v)
+	}
+	return &Customer{
+		Provider:   ProviderIntercom,
+		ID:         d.ID,
+		Name:       d.Name,
+		Email:      d.Email,
+		Company:    attrs["company"],
+		URL:        fmt.Sprintf("https://app.intercom.com/a/apps/%s/users/%s/all-conversations", d.WorkspaceID, d.ID),
+		Attributes: attrs,
+	}, nil
+}
+
+type hubspotClient struct {
+	token string
+	http  *http.Client
+}
+
+func (c *hubspotClient) FindByEmail(ctx context.Context, email string) (*Customer, error) {
+	body := map[string]any{
+		"filterGroups": []any{map[string]any{
+			"filters": []any{map[string]any{"propertyName": "email", "operator": "EQ", "value": email}},
+		}},
+		"properties": hubspotProperties,
+		"limit":      1,
+	}
+	var resp struct {
+		Results []struct {
+			ID         string            `json:"id"`
+			Properties map[string]string `json:"properties"`
+		} `json:"results"`
+	}
+	if err := postJSON(ctx, c.http, hubspotAPI+"/crm/v3/objects/contacts/search", c.token, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) == 0 {
+		return nil, nil
+	}
+
+	r := resp.Results[0]
+	p := r.Properties
+	attrs := make(map[string]string, len(p))
+	for k, v := range p {
+		switch k {
+		case "firstname", "lastname", "email", "company", "hs_object_id":
+			// surfaced as dedicated fields
+		default:
+			attrs[k] = v
+		}
+	}
+	return &Customer{
+		Provider:   ProviderHubSpot,
+		ID:         r.ID,
+		Name:       strings.TrimSpace(p["firstname"] + " " + p["lastname"]),
+		Email:      p["email"],
+		Company:    p["company"],
+		Attributes: attrs,
+	}, nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url, token string, headers map[string]string, in, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func unixDate(sec int64) string {
+	if sec <= 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format("2006-01-02")
+}
@@ -0,0 +1,77 @@
+// Package database holds the Postgres plumbing shared by the repositories:
+// a DBTX interface satisfied by both the pool and a transaction, and a
+// UnitOfWork that runs multi-step operations atomically.
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DBTX is the subset of pgx shared by *pgxpool.Pool and pgx.Tx, so a
+// repository can run the same queries inside or outside a transaction.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ErrRollback can be returned from a Do callback to discard the transaction's
+// writes without reporting failure; Do returns nil. Dry runs use it to
+// execute the real statements and report what would have changed.
+var ErrRollback = errors.New("rollback requested")
+
+// Beginner starts transactions: *pgxpool.Pool, or a router that picks the
+// pool per request such as the tenancy resolver.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// UnitOfWork groups repository calls into a single Postgres transaction.
+type UnitOfWork struct {
+	pool Beginner
+}
+
+func NewUnitOfWork(pool Beginner) *UnitOfWork {
+	return &UnitOfWork{pool: pool}
+}
+
+// Do runs fn inside a transaction. The transaction is committed when fn
+// returns nil and rolled back when it returns an error or panics, so a
+// partial failure never leaves half-written rows behind.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(tx pgx.Tx) error) (err error) {
+	tx, err := u.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+		if err != nil {
+			// Rollback on an already-aborted tx is harmless; only log real failures.
+			if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+				slog.Error("tx rollback failed", "error", rbErr)
+			}
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if errors.Is(err, ErrRollback) {
+			_ = tx.Rollback(ctx)
+			err = nil
+		}
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
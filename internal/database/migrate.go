@@ -0,0 +1,292 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Schema migrations
+//
+// The SQL files in migrations/ are applied in version order, each in its
+// own transaction, and recorded in schema_migrations with a checksum of the
+// file. A session advisory lock serializes migrators, so replicas started
+// together with MIGRATE_ON_START apply each migration once. Databases
+// created before the history existed (by hand, or by Postgres' init
+// scripts) are baselined first: the migrations they already have are
+// recorded without being run.
+
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+	// ErrNotBaselined means the database has tables but no migration
+	// history, so which migrations it already has is unknown.
+	ErrNotBaselined = errors.New("database has tables but no migration history; run `server migrate baseline <version>` with the last migration it has")
+)
+
+// migrationLock is the advisory lock key held while migrating.
+const migrationLock = 7_482_191_006
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_[a-z0-9_]+\.sql$`)
+
+// Migration is one SQL file of migrations/.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func (m Migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus is a migration and when it was applied; AppliedAt is nil
+// for a pending one. Modified marks an applied migration whose file has
+// changed since, and Unknown one this build doesn't have.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Modified  bool       `json:"modified,omitempty"`
+	Unknown   bool       `json:"unknown,omitempty"`
+}
+
+// LoadMigrations reads the <version>_<name>.sql files at the root of fsys,
+// in version order.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, 0, len(files))
+	for _, name := range files {
+		m := migrationFileRe.FindStringSubmatch(name)
+		if m == nil {
+			return nil, fmt.Errorf("%w: %s is not named <version>_<name>.sql", ErrInvalidMigration, name)
+		}
+		version, _ := strconv.Atoi(m[1])
+		sql, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: path.Base(name), SQL: string(sql)})
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("%w: %s and %s share version %d", ErrInvalidMigration,
+				migrations[i-1].Name, migrations[i].Name, migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies migrations to one database. It needs a connection of
+// its own, since the advisory lock belongs to the session.
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+func NewMigrator(conn *pgx.Conn, migrations []Migration, logger *slog.Logger) *Migrator {
+	return &Migrator{conn: conn, migrations: migrations, logger: logger}
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// history reads schema_migrations. ok is false when the table doesn't
+// exist yet; err is ErrNotBaselined when other tables do. Only the first
+// schema on the search_path counts, so a tenant schema isn't taken for
+// migrated because public is.
+func (m *Migrator) history(ctx context.Context) (applied map[int]appliedMigration, ok bool, err error) {
+	var hasHistory, hasTables bool
+	if err := m.conn.QueryRow(ctx,
+		`SELECT to_regclass(format('%I.schema_migrations', current_schema())) IS NOT NULL,
+		        to_regclass(format('%I.organizations', current_schema())) IS NOT NULL`,
+	).Scan(&hasHistory, &hasTables); err != nil {
+		return nil, false, err
+	}
+	if !hasHistory {
+		if hasTables {
+			return nil, false, ErrNotBaselined
+		}
+		return map[int]appliedMigration{}, false, nil
+	}
+
+	rows, err := m.conn.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, false, err
+	}
+	applied = map[int]appliedMigration{}
+	var version int
+	var a appliedMigration
+	_, err = pgx.ForEachRow(rows, []any{&version, &a.name, &a.checksum, &a.appliedAt}, func() error {
+		applied[version] = a
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return applied, true, nil
+}
+
+func (m *Migrator) createHistory(ctx context.Context) error {
+	_, err := m.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		checksum   TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	return err
+}
+
+// locked runs fn holding the migration lock.
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	if _, err := m.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("take migration lock: %w", err)
+	}
+	defer func() {
+		// The lock goes with the session if this fails.
+		_, _ = m.conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLock)
+	}()
+	return fn()
+}
+
+// Status lists every migration, applied or pending, and any applied ones
+// this build doesn't know.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, _, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			s.AppliedAt = &a.appliedAt
+			s.Modified = a.checksum != mig.checksum()
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for version, a := range applied {
+		statuses = append(statuses, MigrationStatus{Version: version, Name: a.name, AppliedAt: &a.appliedAt, Unknown: true})
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int { return cmp.Compare(a.Version, b.Version) })
+	return statuses, nil
+}
+
+// Pending returns the migrations not applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, _, err := m.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations up to version target, or all of them
+// when target is 0, and returns those it applied. Each runs in its own
+// transaction, so a failure leaves the ones before it applied.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func() error {
+		applied, ok, err := m.history(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := m.createHistory(ctx); err != nil {
+				return fmt.Errorf("create schema_migrations: %w", err)
+			}
+		}
+		for _, mig := range m.migrations {
+			if target > 0 && mig.Version > target {
+				break
+			}
+			if a, ok := applied[mig.Version]; ok {
+				if a.checksum != mig.checksum() {
+					m.logger.Warn("applied migration has changed since", "migration", mig.Name)
+				}
+				continue
+			}
+			start := time.Now()
+			if err := m.apply(ctx, mig); err != nil {
+				return fmt.Errorf("migration %s: %w", mig.Name, err)
+			}
+			m.logger.Info("migration applied", "migration", mig.Name, "duration", time.Since(start))
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // no-op after commit
+
+	// No arguments, so pgx sends the file with the simple protocol, which
+	// takes several statements at once.
+	if _, err := tx.Exec(ctx, mig.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+		mig.Version, mig.Name, mig.checksum()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Baseline records the migrations up to version as applied without
+// running them, for a database that already has them.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if !slices.ContainsFunc(m.migrations, func(mig Migration) bool { return mig.Version == version }) {
+		return fmt.Errorf("%w: no migration has version %d", ErrInvalidMigration, version)
+	}
+	return m.locked(ctx, func() error {
+		if err := m.createHistory(ctx); err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			if _, err := m.conn.Exec(ctx,
+				`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+				 ON CONFLICT (version) DO NOTHING`,
+				mig.Version, mig.Name, mig.checksum()); err != nil {
+				return err
+			}
+		}
+		m.logger.Info("migrations baselined", "version", version)
+		return nil
+	})
+}
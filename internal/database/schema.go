@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Expand/contract column transitions
+//
+// A column is renamed (or moved to a new column of a compatible type) on a
+// live database in steps, each recorded as a row in schema_transitions:
+//
+//	expand    the new column exists and is being backfilled. Writes go to
+//	          both columns; reads prefer the new one and fall back to the old.
+//	migrated  the backfill is done. Reads use the new column only; writes
+//	          still go to both so the app can be rolled back.
+//	contract  the old column is no longer touched and can be dropped once
+//	          every replica has reloaded (see Schema.Watch).
+//
+// After the old column is dropped, the code is changed to use the new name
+// and the row deleted. Repositories route their non-key columns through a
+// Schema, so no code change is needed while a transition is in progress.
+// Key columns (id, org_id and other join/filter keys) are not routed.
+
+// Phase is a column transition's step.
+type Phase string
+
+const (
+	PhaseExpand   Phase = "expand"
+	PhaseMigrated Phase = "migrated"
+	PhaseContract Phase = "contract"
+)
+
+type columnKey struct{ table, column string }
+
+type transition struct {
+	newColumn string
+	phase     Phase
+}
+
+// Schema is the set of column transitions in progress. A nil *Schema has
+// none, so every column maps to itself.
+type Schema struct {
+	pool        *pgxpool.Pool
+	transitions atomic.Pointer[map[columnKey]transition]
+}
+
+func NewSchema(pool *pgxpool.Pool) *Schema {
+	s := &Schema{pool: pool}
+	s.transitions.Store(&map[columnKey]transition{})
+	return s
+}
+
+// Load reads the current transitions from schema_transitions.
+func (s *Schema) Load(ctx context.Context) error {
+	rows, err := s.pool.Query(ctx,
+		`SELECT table_name, column_name, new_column, phase FROM schema_transitions`)
+	if err != nil {
+		return fmt.Errorf("load schema transitions: %w", err)
+	}
+	loaded := map[columnKey]transition{}
+	var table, column string
+	var t transition
+	_, err = pgx.ForEachRow(rows, []any{&table, &column, &t.newColumn, &t.phase}, func() error {
+		loaded[columnKey{table, column}] = t
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load schema transitions: %w", err)
+	}
+	s.transitions.Store(&loaded)
+	return nil
+}
+
+// Watch reloads the transitions every interval until ctx is cancelled, so
+// a phase change reaches every replica without a restart. A failed reload
+// keeps the previous set.
+func (s *Schema) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Load(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("schema transitions reload failed", "error", err)
+		}
+	}
+}
+
+func (s *Schema) lookup(table, column string) (transition, bool) {
+	if s == nil {
+		return transition{}, false
+	}
+	t, ok := (*s.transitions.Load())[columnKey{table, column}]
+	return t, ok
+}
+
+// Read returns the SQL expression that reads a column.
+func (s *Schema) Read(table, column string) string {
+	t, ok := s.lookup(table, column)
+	switch {
+	case !ok:
+		return column
+	case t.phase == PhaseExpand:
+		return fmt.Sprintf("COALESCE(%s, %s)", t.newColumn, column)
+	default:
+		return t.newColumn
+	}
+}
+
+// Columns returns a SELECT (or RETURNING) list reading the given columns.
+func (s *Schema) Columns(table string, columns ...string) string {
+	exprs := make([]string, len(columns))
+	for i, c := range columns {
+		exprs[i] = s.Read(table, c)
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// writes returns the physical columns a write to column goes to.
+func (s *Schema) writes(table, column string) []string {
+	t, ok := s.lookup(table, column)
+	switch {
+	case !ok:
+		return []string{column}
+	case t.phase == PhaseContract:
+		return []string{t.newColumn}
+	default:
+		return []string{column, t.newColumn}
+	}
+}
+
+// Insert returns the column list and matching VALUES placeholders ($1...)
+// for inserting the given columns in order; a column written to both its
+// old and new name repeats its placeholder.
+func (s *Schema) Insert(table string, columns ...string) (cols, values string) {
+	var names, params []string
+	for i, c := range columns {
+		for _, w := range s.writes(table, c) {
+			names = append(names, w)
+			params = append(params, fmt.Sprintf("$%d", i+1))
+		}
+	}
+	return strings.Join(names, ", "), strings.Join(params, ", ")
+}
+
+// Assign returns the SET clause assigning value (an SQL expression, such as
+// a placeholder) to a column. Use Read inside value to refer to the
+// column's current contents.
+func (s *Schema) Assign(table, column, value string) string {
+	ws := s.writes(table, column)
+	sets := make([]string, len(ws))
+	for i, w := range ws {
+		sets[i] = w + " = " + value
+	}
+	return strings.Join(sets, ", ")
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidTLS is returned for Postgres TLS settings that can't be used.
+var ErrInvalidTLS = errors.New("invalid database TLS setting")
+
+// sslModes are the libpq sslmode values pgx understands.
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// TLS configures how connections to Postgres are secured, in libpq's
+// terms. Each non-empty field overrides the same parameter in the
+// connection string, so one setting covers the shared database, the
+// databases of isolated orgs and the CLI tools. With Cert and Key set the
+// server can authenticate the app by its client certificate (clientcert
+// or cert in pg_hba.conf) rather than, or on top of, a password.
+type TLS struct {
+	// Mode is the sslmode; verify-full checks the server's certificate
+	// against RootCert and its name against the host.
+	Mode string
+	// RootCert is a PEM bundle of the CAs the server's certificate must
+	// chain to.
+	RootCert string
+	// Cert and Key are the client certificate and its private key.
+	Cert string
+	Key  string
+}
+
+// TLSFromEnv reads DATABASE_SSLMODE, DATABASE_SSLROOTCERT,
+// DATABASE_SSLCERT and DATABASE_SSLKEY.
+func TLSFromEnv() TLS {
+	return TLS{
+		Mode:     os.Getenv("DATABASE_SSLMODE"),
+		RootCert: os.Getenv("DATABASE_SSLROOTCERT"),
+		Cert:     os.Getenv("DATABASE_SSLCERT"),
+		Key:      os.Getenv("DATABASE_SSLKEY"),
+	}
+}
+
+// IsZero reports whether t leaves connection strings unchanged.
+func (t TLS) IsZero() bool {
+	return t == TLS{}
+}
+
+// Validate checks the mode and that the files can be read.
+func (t TLS) Validate() error {
+	if t.Mode != "" && !slices.Contains(sslModes, t.Mode) {
+		return fmt.Errorf("%w: sslmode %q (want one of %s)", ErrInvalidTLS, t.Mode, strings.Join(sslModes, ", "))
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return fmt.Errorf("%w: a client certificate needs both its cert and key files", ErrInvalidTLS)
+	}
+	if (t.Mode == "verify-ca" || t.Mode == "verify-full") && t.RootCert == "" {
+		return fmt.Errorf("%w: sslmode %s needs a root certificate", ErrInvalidTLS, t.Mode)
+	}
+	for _, file := range []string{t.RootCert, t.Cert, t.Key} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.ReadFile(file); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidTLS, err)
+		}
+	}
+	return nil
+}
+
+// params returns the libpq parameters t sets, in a stable order.
+func (t TLS) params() [][2]string {
+	var params [][2]string
+	for _, p := range [][2]string{
+		{"sslmode", t.Mode},
+		{"sslrootcert", t.RootCert},
+		{"sslcert", t.Cert},
+		{"sslkey", t.Key},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// Apply returns connString with t's parameters set. Both URL
+// (postgres://...) and keyword/value (host=... dbname=...) connection
+// strings are accepted.
+func (t TLS) Apply(connString string) (string, error) {
+	params := t.params()
+	if len(params) == 0 {
+		return connString, nil
+	}
+	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		u, err := url.Parse(connString)
+		if err != nil {
+			// Not wrapped: the parse error quotes the URL, password included.
+			return "", fmt.Errorf("%w: the connection string isn't a valid URL", ErrInvalidTLS)
+		}
+		q := u.Query()
+		for _, p := range params {
+			q.Set(p[0], p[1])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	// Keyword/value: a later keyword overrides an earlier one.
+	var b strings.Builder
+	b.WriteString(connString)
+	for _, p := range params {
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p[1])
+		fmt.Fprintf(&b, " %s='%s'", p[0], value)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,413 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Batch ingestion
+// A batch upload enqueues an ingest job marked batch, which the workers
+// leave alone. RunBatches collects an org's queued batch jobs, splits their
+// documents and submits every chunk as one Batch API batch; the documents
+// then follow the batch's status (batch_validating, batch_in_progress,
+// batch_finalizing). Once the batch completes the documents are split
+// again, which is deterministic, and stored with the returned vectors.
+// Anything that goes wrong on the way (submission refused, batch failed or
+// expired, results missing for a document) hands the document back to the
+// regular workers, so a batch upload is never worse than a normal one.
+
+const (
+	// batchMaxDocuments caps how many documents one batch covers.
+	batchMaxDocuments = 1000
+	// batchRequestInputs is how many chunks one embeddings request in a
+	// batch carries.
+	batchRequestInputs = 100
+	// batchLease is how long one replica owns a batch while polling it or
+	// storing its results.
+	batchLease = 30 * time.Minute
+)
+
+// errBatchIncomplete is returned when a completed batch lacks some of a
+// document's vectors.
+var errBatchIncomplete = errors.New("batch results incomplete")
+
+// batchStatus maps a Batch API status to the document status showing it.
+func batchStatus(status string) Status {
+	switch status {
+	case embedding.BatchInProgress:
+		return StatusBatchInProgress
+	case embedding.BatchFinalizing:
+		return StatusBatchFinalizing
+	default:
+		return StatusBatchValidating
+	}
+}
+
+// batchRequestID names the request carrying a document's chunks from
+// offset on.
+func batchRequestID(docID string, offset int) string {
+	return fmt.Sprintf("%s/%d", docID, offset)
+}
+
+func (r *Repository) enqueueBatchJob(ctx context.Context, docID, orgID string) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO ingest_jobs (document_id, org_id, batch) VALUES ($1, $2, TRUE)`, docID, orgID)
+	return err
+}
+
+// claimBatchJobs leases up to batchMaxDocuments runnable batch jobs of one
+// org, the one with the oldest, so each batch belongs to a single org.
+func (r *Repository) claimBatchJobs(ctx context.Context) ([]*ingestJob, error) {
+	rows, err := r.db.Query(ctx,
+		`WITH runnable AS (
+			 SELECT id, org_id FROM ingest_jobs
+			 WHERE batch AND run_after <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+		 )
+		 UPDATE ingest_jobs SET attempts = attempts + 1, locked_until = NOW() + $1::interval
+		 WHERE id IN (
+			 SELECT id FROM ingest_jobs
+			 WHERE id IN (SELECT id FROM runnable)
+			   AND org_id = (SELECT org_id FROM runnable ORDER BY id LIMIT 1)
+			 ORDER BY id
+			 LIMIT $2
+			 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, document_id, org_id, attempts`,
+		batchLease.String(), batchMaxDocuments)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*ingestJob, error) {
+		job := &ingestJob{}
+		err := row.Scan(&job.id, &job.docID, &job.orgID, &job.attempts)
+		return job, err
+	})
+}
+
+// unbatchJobs hands jobs back to the regular workers.
+func (r *Repository) unbatchJobs(ctx context.Context, ids []int64) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE ingest_jobs SET batch = FALSE, locked_until = NULL WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// createBatch records a submitted batch, moves its documents to the
+// batch's stage and removes their jobs.
+func (r *Repository) createBatch(ctx context.Context, b *embedding.Batch, orgID string, docIDs []string, jobIDs []int64) error {
+	if _, err := r.db.Exec(ctx,
+		`INSERT INTO embedding_batches (id, org_id, status) VALUES ($1, $2, $3)`, b.ID, orgID, b.Status); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("status", "$2")+`, `+r.set("updated_at", "NOW()")+`, `+r.set("batch_id", "$3")+`
+		 WHERE id = ANY($1)`, docIDs, batchStatus(b.Status), b.ID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, `DELETE FROM ingest_jobs WHERE id = ANY($1)`, jobIDs)
+	return err
+}
+
+// embeddingBatch is a submitted batch awaiting its results.
+type embeddingBatch struct {
+	id     string
+	orgID  string
+	status string
+}
+
+// claimBatches leases every batch no other replica is polling.
+func (r *Repository) claimBatches(ctx context.Context) ([]embeddingBatch, error) {
+	rows, err := r.db.Query(ctx,
+		`UPDATE embedding_batches SET locked_until = NOW() + $1::interval
+		 WHERE id IN (
+			 SELECT id FROM embedding_batches
+			 WHERE locked_until IS NULL OR locked_until < NOW()
+			 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, org_id, status`,
+		batchLease.String())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (embeddingBatch, error) {
+		var b embeddingBatch
+		err := row.Scan(&b.id, &b.orgID, &b.status)
+		return b, err
+	})
+}
+
+// updateBatch records a batch's status on it and its documents and
+// releases the lease.
+func (r *Repository) updateBatch(ctx context.Context, id, status string) error {
+	if _, err := r.db.Exec(ctx,
+		`UPDATE embedding_batches SET status = $2, updated_at = NOW(), locked_until = NULL WHERE id = $1`,
+		id, status); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("status", "$2")+`, `+r.set("updated_at", "NOW()")+`
+		 WHERE `+r.read("batch_id")+` = $1 AND `+r.read("status")+` <> $2`, id, batchStatus(status))
+	return err
+}
+
+// batchDocuments returns the documents still waiting on a batch.
+func (r *Repository) batchDocuments(ctx context.Context, batchID string) ([]*Document, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+r.columns()+` FROM documents WHERE `+r.read("batch_id")+` = $1`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Document, error) {
+		return scanDocument(row)
+	})
+}
+
+// requeueDocument takes a document out of its batch and queues a regular
+// ingest for it.
+func (r *Repository) requeueDocument(ctx context.Context, doc *Document) error {
+	if _, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("status", "$2")+`, `+r.set("updated_at", "NOW()")+`, `+r.set("batch_id", "NULL")+`
+		 WHERE id = $1`, doc.ID, StatusPending); err != nil {
+		return err
+	}
+	return r.enqueueJob(ctx, doc.ID, doc.OrgID, "", 0)
+}
+
+// deleteBatch forgets a finished batch, detaching any documents left on it.
+func (r *Repository) deleteBatch(ctx context.Context, id string) error {
+	if _, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("batch_id", "NULL")+` WHERE `+r.read("batch_id")+` = $1`, id); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(ctx, `DELETE FROM embedding_batches WHERE id = $1`, id)
+	return err
+}
+
+// RunBatches submits queued batch uploads and collects finished batches
+// every interval until ctx is cancelled, in the shared storage and every
+// isolated org's. It returns at once when batch ingestion is disabled.
+func (s *Service) RunBatches(ctx context.Context, interval time.Duration) {
+	if s.batches == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, scope := range s.scopes.Scopes(ctx) {
+			s.submitBatches(scope)
+			s.pollBatches(scope)
+		}
+	}
+}
+
+// submitBatches submits batch jobs until the scope's queue has none left.
+func (s *Service) submitBatches(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := s.repo.claimBatchJobs(ctx)
+		if err != nil {
+			slog.Error("claim batch jobs failed", "org_id", tenancy.OrgFrom(ctx), "error", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+		orgCtx := tenancy.WithOrg(ctx, jobs[0].orgID)
+		if err := s.submitBatch(orgCtx, jobs); err != nil {
+			slog.Warn("batch submission failed, ingesting normally", "org_id", jobs[0].orgID, "documents", len(jobs), "error", err)
+			ids := make([]int64, len(jobs))
+			for i, job := range jobs {
+				ids[i] = job.id
+			}
+			if err := s.repo.unbatchJobs(orgCtx, ids); err != nil {
+				slog.Error("release batch jobs failed", "org_id", jobs[0].orgID, "error", err)
+				return
+			}
+			s.notify()
+		}
+	}
+}
+
+// submitBatch splits the jobs' documents and submits their chunks as one
+// batch. Documents that are gone or can't be split settle like they would
+// with a worker.
+func (s *Service) submitBatch(ctx context.Context, jobs []*ingestJob) error {
+	var (
+		reqs   []embedding.BatchRequest
+		docIDs []string
+		jobIDs []int64
+	)
+	for _, job := range jobs {
+		doc, err := s.repo.Get(ctx, job.docID, job.orgID)
+		if err == nil {
+			doc.Content, err = s.repo.Content(ctx, job.docID, job.orgID)
+		}
+		if errors.Is(err, ErrNotFound) {
+			_ = s.repo.completeJob(ctx, job.id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		chunks, err := s.splitDocument(doc)
+		if err != nil || len(chunks) == 0 {
+			slog.Error("text splitting failed", "doc_id", doc.ID, "error", err)
+			_ = s.repo.UpdateStatus(ctx, doc.ID, StatusFailed, 0)
+			_ = s.repo.completeJob(ctx, job.id)
+			continue
+		}
+		for off := 0; off < len(chunks); off += batchRequestInputs {
+			req := embedding.BatchRequest{ID: batchRequestID(doc.ID, off)}
+			for _, c := range chunks[off:min(off+batchRequestInputs, len(chunks))] {
+				req.Inputs = append(req.Inputs, c.PageContent)
+			}
+			reqs = append(reqs, req)
+		}
+		docIDs = append(docIDs, doc.ID)
+		jobIDs = append(jobIDs, job.id)
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	b, err := s.batches.Submit(ctx, reqs)
+	if err != nil {
+		return err
+	}
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		return s.repo.WithTx(tx).createBatch(ctx, b, jobs[0].orgID, docIDs, jobIDs)
+	})
+	if err != nil {
+		// The batch runs regardless; its results are simply never read.
+		return fmt.Errorf("record batch %s: %w", b.ID, err)
+	}
+	slog.Info("embedding batch submitted", "batch_id", b.ID, "org_id", jobs[0].orgID, "documents", len(docIDs), "requests", len(reqs))
+	return nil
+}
+
+// pollBatches checks every unclaimed batch of the scope.
+func (s *Service) pollBatches(ctx context.Context) {
+	batches, err := s.repo.claimBatches(ctx)
+	if err != nil {
+		slog.Error("claim embedding batches failed", "org_id", tenancy.OrgFrom(ctx), "error", err)
+		return
+	}
+	for _, eb := range batches {
+		if err := s.pollBatch(tenancy.WithOrg(ctx, eb.orgID), eb); err != nil {
+			slog.Error("poll embedding batch failed", "batch_id", eb.id, "error", err)
+		}
+	}
+}
+
+// pollBatch records a batch's progress and, once it is done, stores its
+// documents' vectors or hands them back to the workers.
+func (s *Service) pollBatch(ctx context.Context, eb embeddingBatch) error {
+	b, err := s.batches.Get(ctx, eb.id)
+	if err != nil {
+		_ = s.repo.updateBatch(ctx, eb.id, eb.status)
+		return err
+	}
+	if !b.Done() {
+		if b.Status == eb.status {
+			return s.repo.updateBatch(ctx, eb.id, eb.status) // just release it
+		}
+		return s.repo.updateBatch(ctx, eb.id, b.Status)
+	}
+
+	results := map[string][][]float32{}
+	if b.Status == embedding.BatchCompleted {
+		if results, err = s.batches.Results(ctx, b); err != nil {
+			_ = s.repo.updateBatch(ctx, eb.id, eb.status)
+			return err
+		}
+	} else {
+		slog.Warn("embedding batch did not complete, ingesting normally", "batch_id", eb.id, "status", b.Status)
+	}
+
+	docs, err := s.repo.batchDocuments(ctx, eb.id)
+	if err != nil {
+		_ = s.repo.updateBatch(ctx, eb.id, eb.status)
+		return err
+	}
+	for _, doc := range docs {
+		err := s.storeBatched(ctx, doc, results)
+		if err == nil {
+			continue
+		}
+		if len(results) > 0 {
+			slog.Warn("batch results unusable, ingesting normally", "doc_id", doc.ID, "error", err)
+		}
+		err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+			return s.repo.WithTx(tx).requeueDocument(ctx, doc)
+		})
+		if err != nil {
+			return err
+		}
+		s.notify()
+	}
+	return s.repo.deleteBatch(ctx, eb.id)
+}
+
+// storeBatched stores a document's chunks with their batch vectors and
+// marks it ready.
+func (s *Service) storeBatched(ctx context.Context, doc *Document, results map[string][][]float32) error {
+	content, err := s.repo.Content(ctx, doc.ID, doc.OrgID)
+	if err != nil {
+		return err
+	}
+	doc.Content = content
+	chunks, err := s.splitDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	// Renaming a document can change its splitter, and with it the chunks.
+	vecs := make([][]float32, 0, len(chunks))
+	for off := 0; off < len(chunks); off += batchRequestInputs {
+		part, ok := results[batchRequestID(doc.ID, off)]
+		if !ok || len(part) != min(batchRequestInputs, len(chunks)-off) {
+			return errBatchIncomplete
+		}
+		vecs = append(vecs, part...)
+	}
+
+	if err := s.repo.UpdateStatus(ctx, doc.ID, StatusProcessing, 0); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil // deleted meanwhile
+		}
+		return err
+	}
+	// The chunks and the ready status commit together, so a document
+	// deleted meanwhile rolls its chunks back with it (stores outside
+	// Postgres are cleared by hand).
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		store := s.vectorStore.WithTx(tx)
+		if err := store.DeleteChunksFrom(ctx, doc.ID, 0); err != nil {
+			return fmt.Errorf("clear partial vectors: %w", err)
+		}
+		if err := store.AddEmbedded(ctx, chunks, vecs); err != nil {
+			return fmt.Errorf("vector store add: %w", err)
+		}
+		return s.repo.WithTx(tx).UpdateStatus(ctx, doc.ID, StatusReady, len(chunks))
+	})
+	if errors.Is(err, ErrNotFound) {
+		_ = s.vectorStore.DeleteByDocument(ctx, doc.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.buildSummaryTree(ctx, doc, chunks)
+	slog.Info("document ingested from batch", "doc_id", doc.ID, "chunks", len(chunks))
+	return nil
+}
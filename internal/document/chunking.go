@@ -0,0 +1,294 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// Semantic chunking
+//
+// Fixed-size splitting cuts wherever the character budget runs out, often
+// in the middle of a table or a definition. The semantic splitter instead
+// breaks text into sentences (and table rows and list items, which are
+// kept as units), embeds them, and ends a chunk where the meaning shifts:
+// where the embedding distance between neighbouring sentences is among the
+// largest in the document. Each sentence is compared with its neighbours
+// averaged in, so one odd sentence doesn't cause a break, and a table is
+// never broken between rows unless it outgrows a chunk. Chunks stay between
+// a quarter of and twice the configured size.
+//
+// It costs an embedding call over the whole text on top of embedding the
+// chunks. Source files keep their declaration-aware splitter, and batch
+// ingestion, which splits again once the batch completes, keeps fixed-size
+// splitting.
+
+// Chunking strategies.
+const (
+	ChunkFixed    = "fixed"
+	ChunkSemantic = "semantic"
+)
+
+// ChunkingConfig selects how documents are split into chunks.
+type ChunkingConfig struct {
+	// Strategy is ChunkFixed or ChunkSemantic; empty means ChunkFixed.
+	Strategy string
+	// Size is the target chunk size and Overlap how much fixed-size chunks
+	// share with their neighbours, both measured by Length.
+	Size    int
+	Overlap int
+	// Length measures text for Size and Overlap; nil counts characters.
+	// See TokenLength for counting model tokens.
+	Length func(string) int
+	// Breakpoint is the percentile (0-1) of sentence-to-sentence distances
+	// above which a semantic chunk ends; higher makes fewer, longer chunks.
+	Breakpoint float64
+}
+
+// DefaultChunking applies to settings left zero.
+var DefaultChunking = ChunkingConfig{Strategy: ChunkFixed, Size: 512, Overlap: 64, Breakpoint: 0.9}
+
+// ParseChunkStrategy validates a CHUNKING_STRATEGY value; empty means
+// ChunkFixed.
+func ParseChunkStrategy(s string) (string, error) {
+	switch s {
+	case "":
+		return ChunkFixed, nil
+	case ChunkFixed, ChunkSemantic:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown chunking strategy %q (want %q or %q)", s, ChunkFixed, ChunkSemantic)
+	}
+}
+
+// ChunkWith sets how documents are split.
+func (s *Service) ChunkWith(cfg ChunkingConfig) {
+	if cfg.Strategy == "" {
+		cfg.Strategy = DefaultChunking.Strategy
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultChunking.Size
+	}
+	if cfg.Overlap <= 0 || cfg.Overlap >= cfg.Size {
+		cfg.Overlap = min(DefaultChunking.Overlap, cfg.Size/4)
+	}
+	if cfg.Breakpoint <= 0 || cfg.Breakpoint >= 1 {
+		cfg.Breakpoint = DefaultChunking.Breakpoint
+	}
+	s.chunking = cfg
+}
+
+// measure is text's length in the unit Size counts.
+func (c ChunkingConfig) measure(text string) int {
+	if c.Length != nil {
+		return c.Length(text)
+	}
+	return utf8.RuneCountInString(text)
+}
+
+// errSentenceEmbedding marks a semantic split that failed to embed its
+// sentences; unlike unsplittable content, it is worth retrying.
+var errSentenceEmbedding = errors.New("embed sentences")
+
+// sentenceBatch is how many sentences one embedding call covers.
+const sentenceBatch = 256
+
+// semanticSplitter implements textsplitter.TextSplitter for one ingestion,
+// carrying the context its embedding calls run under.
+type semanticSplitter struct {
+	ctx   context.Context
+	embed func(context.Context, []string) ([][]float32, error)
+	cfg   ChunkingConfig
+}
+
+func (sp *semanticSplitter) SplitText(text string) ([]string, error) {
+	segs := sp.segments(text)
+	if len(segs) == 0 {
+		return nil, nil
+	}
+	if len(segs) == 1 {
+		return []string{strings.TrimSpace(segs[0])}, nil
+	}
+
+	vecs := make([][]float32, 0, len(segs))
+	for off := 0; off < len(segs); off += sentenceBatch {
+		batch := make([]string, 0, sentenceBatch)
+		for _, seg := range segs[off:min(off+sentenceBatch, len(segs))] {
+			batch = append(batch, strings.TrimSpace(seg))
+		}
+		v, err := sp.embed(sp.ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errSentenceEmbedding, err)
+		}
+		vecs = append(vecs, v...)
+	}
+
+	// dist[i] is how far the meaning moves between segment i and i+1, each
+	// smoothed with its neighbours.
+	windows := make([][]float64, len(vecs))
+	for i := range vecs {
+		windows[i] = window(vecs, i)
+	}
+	dist := make([]float64, len(segs)-1)
+	for i := range dist {
+		dist[i] = 1 - dot(windows[i], windows[i+1])
+	}
+	sorted := slices.Clone(dist)
+	slices.Sort(sorted)
+	threshold := sorted[min(int(sp.cfg.Breakpoint*float64(len(sorted))), len(sorted)-1)]
+
+	minLen, maxLen := sp.cfg.Size/4, sp.cfg.Size*2
+	var chunks []string
+	var cur strings.Builder
+	n := 0 // length of cur, summed over its segments
+	for i, seg := range segs {
+		segLen := sp.cfg.measure(seg)
+		if cur.Len() > 0 && n+segLen > maxLen {
+			chunks = append(chunks, strings.TrimSpace(cur.String()))
+			cur.Reset()
+			n = 0
+		}
+		cur.WriteString(seg)
+		n += segLen
+		if i < len(dist) && dist[i] > threshold && n >= minLen && !(tableRow(seg) && tableRow(segs[i+1])) {
+			chunks = append(chunks, strings.TrimSpace(cur.String()))
+			cur.Reset()
+			n = 0
+		}
+	}
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		chunks = append(chunks, rest)
+	}
+	return chunks, nil
+}
+
+// segments cuts text into the units chunk boundaries may fall between:
+// sentences of prose, and whole lines of tables and lists. Each keeps its
+// trailing whitespace so the chunks read as the original. Units longer than
+// a chunk may be are split to size.
+func (sp *semanticSplitter) segments(text string) []string {
+	var segs []string
+	add := func(seg string) {
+		if strings.TrimSpace(seg) == "" {
+			if len(segs) > 0 {
+				segs[len(segs)-1] += seg
+			}
+			return
+		}
+		if sp.cfg.measure(seg) <= sp.cfg.Size*2 {
+			segs = append(segs, seg)
+			return
+		}
+		pieces, _ := textsplitter.NewRecursiveCharacter(
+			textsplitter.WithChunkSize(sp.cfg.Size),
+			textsplitter.WithChunkOverlap(0),
+			textsplitter.WithLenFunc(sp.cfg.measure),
+		).SplitText(seg)
+		for _, p := range pieces {
+			segs = append(segs, p+" ")
+		}
+	}
+
+	var prose strings.Builder
+	flush := func() {
+		for _, s := range sentences(prose.String()) {
+			add(s)
+		}
+		prose.Reset()
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if structuredLine(line) {
+			flush()
+			add(line)
+			continue
+		}
+		prose.WriteString(line)
+	}
+	flush()
+	return segs
+}
+
+// tableRow reports whether a segment is a row of a table, which only the
+// maximum chunk size may separate from the next row.
+func tableRow(seg string) bool {
+	t := strings.TrimSpace(seg)
+	return strings.HasPrefix(t, "|") || strings.Contains(t, "\t")
+}
+
+// structuredLine reports whether a line is a table row or list item,
+// which must not be merged with its neighbours into sentences.
+func structuredLine(line string) bool {
+	t := strings.TrimSpace(line)
+	if t == "" {
+		return false
+	}
+	switch {
+	case tableRow(t):
+		return true
+	case strings.HasPrefix(t, "- "), strings.HasPrefix(t, "* "), strings.HasPrefix(t, "• "):
+		return true
+	}
+	// Numbered items: "1. ", "12) ".
+	digits := strings.IndexFunc(t, func(r rune) bool { return !unicode.IsDigit(r) })
+	return digits > 0 && digits+1 < len(t) && (t[digits] == '.' || t[digits] == ')') && t[digits+1] == ' '
+}
+
+// sentences splits prose after sentence-ending punctuation followed by
+// whitespace, and at blank lines.
+func sentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		end := (c == '.' || c == '!' || c == '?') && i+1 < len(text) && (text[i+1] == ' ' || text[i+1] == '\n')
+		para := c == '\n' && i+1 < len(text) && text[i+1] == '\n'
+		if !end && !para {
+			continue
+		}
+		j := i + 1
+		for j < len(text) && (text[j] == ' ' || text[j] == '\n' || text[j] == '\r' || text[j] == '\t') {
+			j++
+		}
+		out = append(out, text[start:j])
+		start, i = j, j-1
+	}
+	if start < len(text) {
+		out = append(out, text[start:])
+	}
+	return out
+}
+
+// window is the unit-length mean of vecs[i] and its neighbours.
+func window(vecs [][]float32, i int) []float64 {
+	sum := make([]float64, len(vecs[i]))
+	for j := max(i-1, 0); j <= min(i+1, len(vecs)-1); j++ {
+		for k, x := range vecs[j] {
+			sum[k] += float64(x)
+		}
+	}
+	var norm float64
+	for _, x := range sum {
+		norm += x * x
+	}
+	if norm = math.Sqrt(norm); norm > 0 {
+		for k := range sum {
+			sum[k] /= norm
+		}
+	}
+	return sum
+}
+
+func dot(a, b []float64) float64 {
+	var d float64
+	for i := range a {
+		d += a[i] * b[i]
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+package document
+
+import (
+	"path"
+	"strings"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// Code-aware splitting
+//
+// Source files split on paragraph breaks cut functions in half. For known
+// languages the splitter instead tries top-level declaration boundaries
+// first (the same separator lists LangChain uses for its language
+// splitters), then blank lines, then lines. Separators are kept so each
+// chunk starts at its "func"/"class"/"def" keyword.
+
+var languageByExt = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "js",
+	".jsx":   "js",
+	".mjs":   "js",
+	".ts":    "ts",
+	".tsx":   "ts",
+	".java":  "java",
+	".kt":    "kotlin",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".php":   "php",
+	".cs":    "csharp",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".swift": "swift",
+	".scala": "scala",
+}
+
+var languageSeparators = map[string][]string{
+	"go":     {"\nfunc ", "\nvar ", "\nconst ", "\ntype ", "\nif ", "\nfor ", "\nswitch ", "\ncase "},
+	"python": {"\nclass ", "\ndef ", "\n\tdef ", "\n    def "},
+	"js":     {"\nfunction ", "\nconst ", "\nlet ", "\nvar ", "\nclass ", "\nexport ", "\nif ", "\nfor ", "\nswitch "},
+	"ts":     {"\nenum ", "\ninterface ", "\nnamespace ", "\ntype ", "\nclass ", "\nfunction ", "\nconst ", "\nlet ", "\nexport "},
+	"java":   {"\nclass ", "\npublic ", "\nprotected ", "\nprivate ", "\nstatic ", "\nif ", "\nfor ", "\nswitch "},
+	"kotlin": {"\nclass ", "\nobject ", "\nfun ", "\nval ", "\nvar ", "\nif ", "\nfor ", "\nwhen "},
+	"ruby":   {"\nclass ", "\nmodule ", "\ndef ", "\nif ", "\nunless ", "\nwhile "},
+	"rust":   {"\nfn ", "\npub fn ", "\nimpl ", "\nstruct ", "\nenum ", "\ntrait ", "\nmod ", "\nconst ", "\nlet "},
+	"php":    {"\nclass ", "\nfunction ", "\npublic function ", "\nprivate function ", "\nif ", "\nforeach "},
+	"csharp": {"\nnamespace ", "\nclass ", "\ninterface ", "\npublic ", "\nprivate ", "\nprotected ", "\nif ", "\nforeach "},
+	"c":      {"\nstruct ", "\ntypedef ", "\nstatic ", "\nvoid ", "\nint ", "\nif ", "\nfor ", "\nwhile "},
+	"cpp":    {"\nclass ", "\nnamespace ", "\ntemplate ", "\nstruct ", "\nvoid ", "\nint ", "\nif ", "\nfor "},
+	"swift":  {"\nfunc ", "\nclass ", "\nstruct ", "\nenum ", "\nextension ", "\nprotocol ", "\nif ", "\nfor "},
+	"scala":  {"\nclass ", "\nobject ", "\ntrait ", "\ndef ", "\nval ", "\nvar ", "\nif ", "\nfor "},
+}
+
+// CodeLanguage returns the language of a source file name ("main.go" →
+// "go"), or "" if it isn't a recognized source file.
+func CodeLanguage(name string) string {
+	return languageByExt[strings.ToLower(path.Ext(name))]
+}
+
+// splitterFor picks the fixed-size splitter for a document by its name:
+// declaration-aware for source files, the default recursive splitter for
+// everything else.
+func splitterFor(name string, cfg ChunkingConfig) textsplitter.TextSplitter {
+	opts := []textsplitter.Option{
+		textsplitter.WithChunkSize(cfg.Size),
+		textsplitter.WithChunkOverlap(cfg.Overlap),
+		textsplitter.WithLenFunc(cfg.measure),
+	}
+	if seps, ok := languageSeparators[CodeLanguage(name)]; ok {
+		seps = append(append([]string{}, seps...), "\n\n", "\n", " ", "")
+		opts = append(opts, textsplitter.WithSeparators(seps), textsplitter.WithKeepSeparator(true))
+	}
+	return textsplitter.NewRecursiveCharacter(opts...)
+}
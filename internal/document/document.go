@@ -2,13 +2,20 @@ package document
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
 	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/objectstore"
 	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/summary"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/textsplitter"
 )
@@ -20,71 +27,291 @@ const (
 	StatusProcessing Status = "processing"
 	StatusReady      Status = "ready"
 	StatusFailed     Status = "failed"
+
+	// Batch ingestion stages, while the embedding Batch API works on the
+	// document's chunks; the document moves on to processing once the
+	// vectors are back.
+	StatusBatchValidating Status = "batch_validating"
+	StatusBatchInProgress Status = "batch_in_progress"
+	StatusBatchFinalizing Status = "batch_finalizing"
+)
+
+var (
+	// ErrNotFound is returned when a document doesn't exist or belongs to another org.
+	ErrNotFound = errors.New("document not found")
+	// ErrVersionConflict is returned when a conditional write targets a stale version.
+	ErrVersionConflict = errors.New("document was modified by another request")
+	// ErrNotReady is returned when an operation needs a fully ingested document.
+	ErrNotReady = errors.New("document is still being ingested")
+	// ErrBatchDisabled is returned for a batch upload when no embedding
+	// Batch API is configured.
+	ErrBatchDisabled = errors.New("batch ingestion is not enabled")
 )
 
+// AnyVersion skips the version check on conditional writes (If-Match: *).
+const AnyVersion = 0
+
 type Document struct {
-	ID         string    `json:"id"`
-	OrgID      string    `json:"org_id"`
-	Name       string    `json:"name"`
-	Content    string    `json:"-"` // raw text, not exposed in listings
-	Status     Status    `json:"status"`
-	ChunkCount int       `json:"chunk_count"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         string `json:"id"`
+	OrgID      string `json:"org_id"`
+	Name       string `json:"name"`
+	Content    string `json:"-"` // raw text, not exposed in listings
+	Status     Status `json:"status"`
+	ChunkCount int    `json:"chunk_count"`
+	Version    int    `json:"version"`
+	// Retries counts ingestion attempts beyond the first: queue retries
+	// after a failure plus re-enqueues by the stuck-document sweep.
+	Retries   int            `json:"retries"`
+	Tags      []string       `json:"tags"`               // copied onto every chunk
+	Metadata  map[string]any `json:"metadata,omitempty"` // copied onto every chunk
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
 }
 
 type Repository struct {
-	db *pgxpool.Pool
+	db database.DBTX
+	// schema routes non-key columns through any expand/contract
+	// transition in progress.
+	schema *database.Schema
+}
+
+func NewRepository(db database.DBTX, schema *database.Schema) *Repository {
+	return &Repository{db: db, schema: schema}
 }
 
-func NewRepository(db *pgxpool.Pool) *Repository {
-	return &Repository{db: db}
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx, schema: r.schema}
+}
+
+const documentsTable = "documents"
+
+// columns is the SELECT/RETURNING list scanDocument expects.
+func (r *Repository) columns() string {
+	return r.schema.Columns(documentsTable,
+		"id", "org_id", "name", "status", "chunk_count", "version", "retries", "tags", "metadata", "created_at", "updated_at")
+}
+
+func scanDocument(row pgx.Row) (*Document, error) {
+	d := &Document{}
+	if err := row.Scan(&d.ID, &d.OrgID, &d.Name, &d.Status, &d.ChunkCount, &d.Version, &d.Retries, &d.Tags, &d.Metadata,
+		&d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// read and set route one column through the schema transitions.
+func (r *Repository) read(column string) string {
+	return r.schema.Read(documentsTable, column)
+}
+
+func (r *Repository) set(column, value string) string {
+	return r.schema.Assign(documentsTable, column, value)
+}
+
+// versionMatches is the conditional-write predicate on version; $n = 0
+// (AnyVersion) skips the check.
+func (r *Repository) versionMatches(n int) string {
+	return fmt.Sprintf("($%[1]d = 0 OR %[2]s = $%[1]d)", n, r.read("version"))
 }
 
 func (r *Repository) Create(ctx context.Context, doc *Document) error {
+	cols, values := r.schema.Insert(documentsTable,
+		"id", "org_id", "name", "content", "status", "chunk_count", "version", "tags", "metadata", "created_at", "updated_at")
 	_, err := r.db.Exec(ctx,
-		`INSERT INTO documents (id, org_id, name, content, status, chunk_count, created_at, updated_at)
-		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+		`INSERT INTO documents (`+cols+`) VALUES (`+values+`)`,
 		doc.ID, doc.OrgID, doc.Name, doc.Content, doc.Status,
-		doc.ChunkCount, doc.CreatedAt, doc.UpdatedAt,
+		doc.ChunkCount, doc.Version, tagsOrEmpty(doc.Tags), metadataOrEmpty(doc.Metadata), doc.CreatedAt, doc.UpdatedAt,
 	)
 	return err
 }
 
 func (r *Repository) UpdateStatus(ctx context.Context, id string, status Status, chunkCount int) error {
-	_, err := r.db.Exec(ctx,
-		`UPDATE documents SET status=$1, chunk_count=$2, updated_at=$3 WHERE id=$4`,
+	tag, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("status", "$1")+`, `+
+			r.set("chunk_count", "$2")+`, `+
+			r.set("updated_at", "$3")+` WHERE id=$4`,
 		status, chunkCount, time.Now(), id,
 	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Document, error) {
+	d, err := scanDocument(r.db.QueryRow(ctx,
+		`SELECT `+r.columns()+` FROM documents WHERE id=$1 AND org_id=$2`,
+		id, orgID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Content returns a document's raw text, which listings and Get leave out.
+func (r *Repository) Content(ctx context.Context, id, orgID string) (string, error) {
+	var content string
+	err := r.db.QueryRow(ctx,
+		`SELECT `+r.read("content")+` FROM documents WHERE id=$1 AND org_id=$2`, id, orgID,
+	).Scan(&content)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return content, err
+}
+
+// Rename changes a document's name if it is still at the expected version,
+// bumping the version on success.
+func (r *Repository) Rename(ctx context.Context, id, orgID, name string, version int) (*Document, error) {
+	d, err := scanDocument(r.db.QueryRow(ctx,
+		`UPDATE documents SET `+r.set("name", "$1")+`, `+
+			r.set("version", r.read("version")+" + 1")+`, `+
+			r.set("updated_at", "$2")+`
+		 WHERE id=$3 AND org_id=$4 AND `+r.versionMatches(5)+`
+		 RETURNING `+r.columns(),
+		name, time.Now(), id, orgID, version,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, r.missReason(ctx, id, orgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetForUpdate loads a document and locks its row until the surrounding
+// transaction ends. Only meaningful on a tx-bound repository.
+func (r *Repository) GetForUpdate(ctx context.Context, id, orgID string) (*Document, error) {
+	d, err := scanDocument(r.db.QueryRow(ctx,
+		`SELECT `+r.columns()+` FROM documents WHERE id=$1 AND org_id=$2 FOR UPDATE`,
+		id, orgID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// AppendContent appends text to the stored content, on a new line unless
+// the content is empty or already ends in one, and puts the document back
+// into pending so the appended part gets ingested.
+func (r *Repository) AppendContent(ctx context.Context, id, text string) error {
+	content := r.read("content")
+	sep := "CASE WHEN " + content + " = '' OR right(" + content + ", 1) = E'\\n' THEN '' ELSE E'\\n' END"
+	_, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("content", content+" || "+sep+" || $1")+`, `+
+			r.set("status", "$2")+`, `+
+			r.set("version", r.read("version")+" + 1")+`, `+
+			r.set("updated_at", "$3")+`
+		 WHERE id = $4`,
+		text, StatusPending, time.Now(), id,
+	)
 	return err
 }
 
-func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Document, error) {
-	rows, err := r.db.Query(ctx,
-		`SELECT id, org_id, name, status, chunk_count, created_at, updated_at
-		 FROM documents WHERE org_id=$1 ORDER BY created_at DESC`,
-		orgID,
+// ReplaceContent replaces a document's name and content, bumps its version
+// and puts it back into pending for re-ingestion.
+func (r *Repository) ReplaceContent(ctx context.Context, id, name, content string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("name", "$1")+`, `+
+			r.set("content", "$2")+`, `+
+			r.set("status", "$3")+`, `+
+			r.set("version", r.read("version")+" + 1")+`, `+
+			r.set("updated_at", "$4")+`
+		 WHERE id = $5`,
+		name, content, StatusPending, time.Now(), id,
 	)
+	return err
+}
+
+// missReason tells a conditional write that matched no rows apart:
+// either the document is gone (ErrNotFound) or its version moved on.
+func (r *Repository) missReason(ctx context.Context, id, orgID string) error {
+	var exists bool
+	if err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM documents WHERE id=$1 AND org_id=$2)`, id, orgID,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionConflict
+	}
+	return ErrNotFound
+}
+
+// CountByStatus counts an org's documents per status.
+func (r *Repository) CountByStatus(ctx context.Context, orgID string) (map[Status]int, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+r.read("status")+`, count(*) FROM documents WHERE org_id=$1 GROUP BY 1`, orgID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	counts := map[Status]int{}
+	var (
+		status Status
+		n      int
+	)
+	_, err = pgx.ForEachRow(rows, []any{&status, &n}, func() error {
+		counts[status] = n
+		return nil
+	})
+	return counts, err
+}
 
-	var docs []*Document
-	for rows.Next() {
-		d := &Document{}
-		if err := rows.Scan(&d.ID, &d.OrgID, &d.Name, &d.Status,
-			&d.ChunkCount, &d.CreatedAt, &d.UpdatedAt); err != nil {
-			return nil, err
-		}
-		docs = append(docs, d)
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
 	}
-	return docs, rows.Err()
+	return tags
 }
 
-func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+func metadataOrEmpty(md map[string]any) map[string]any {
+	if md == nil {
+		return map[string]any{}
+	}
+	return md
+}
+
+// Delete removes a document if it is still at the expected version
+// (AnyVersion skips the check).
+func (r *Repository) Delete(ctx context.Context, id, orgID string, version int) error {
+	tag, err := r.db.Exec(ctx,
+		`DELETE FROM documents WHERE id=$1 AND org_id=$2 AND `+r.versionMatches(3),
+		id, orgID, version,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return r.missReason(ctx, id, orgID)
+	}
+	return nil
+}
+
+// SetSummary records the summary at the top of a document's summary tree,
+// which its collections' summaries are built from; "" removes it.
+func (r *Repository) SetSummary(ctx context.Context, id, summary string) error {
+	if summary == "" {
+		_, err := r.db.Exec(ctx, `DELETE FROM document_summaries WHERE document_id = $1`, id)
+		return err
+	}
 	_, err := r.db.Exec(ctx,
-		`DELETE FROM documents WHERE id=$1 AND org_id=$2`, id, orgID,
+		`INSERT INTO document_summaries (document_id, summary, updated_at) VALUES ($1, $2, NOW())
+		 ON CONFLICT (document_id) DO UPDATE SET summary = EXCLUDED.summary, updated_at = EXCLUDED.updated_at`,
+		id, summary,
 	)
 	return err
 }
@@ -92,144 +319,420 @@ func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
 // LangChain Text Splitting
 // langchaingo's textsplitter.RecursiveCharacter splits text by trying a list of
 // separators in order (\n\n → \n → space → character), which produces much more
-// natural chunk boundaries than a naive word-count window.
+// natural chunk boundaries than a naive word-count window. Source files get
+// a declaration-aware separator list instead (see splitterFor), and prose
+// can be split semantically (see chunking.go).
 //
 // textsplitter.CreateDocuments attaches metadata to each chunk so we can carry
 // org_id and document_id through the pipeline as langchaingo schema.Documents.
 
-func splitDocument(doc *Document) ([]schema.Document, error) {
-	splitter := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(512),
-		textsplitter.WithChunkOverlap(64),
-	)
+// splitDocument splits a whole document with the fixed-size splitter, for
+// batch ingestion, which must get the same chunks each time it splits.
+func (s *Service) splitDocument(doc *Document) ([]schema.Document, error) {
+	return chunkText(doc, doc.Content, 0, splitterFor(doc.Name, s.chunking))
+}
+
+// splitText splits text belonging to doc with the configured strategy; see
+// chunkText.
+func (s *Service) splitText(ctx context.Context, doc *Document, text string, firstIndex int) ([]schema.Document, error) {
+	var splitter textsplitter.TextSplitter = &semanticSplitter{ctx: ctx, embed: s.embedDocuments, cfg: s.chunking}
+	if s.chunking.Strategy != ChunkSemantic || CodeLanguage(doc.Name) != "" {
+		splitter = splitterFor(doc.Name, s.chunking)
+	}
+	return chunkText(doc, text, firstIndex, splitter)
+}
+
+// chunkText splits text belonging to doc into chunks whose "chunk_index"
+// metadata starts at firstIndex, so appended content continues the
+// document's existing chunk ordinals.
+func chunkText(doc *Document, text string, firstIndex int, splitter textsplitter.TextSplitter) ([]schema.Document, error) {
+	// Document metadata goes in first so it can't override the keys
+	// tenant isolation and retrieval depend on.
+	base := make(map[string]any, len(doc.Metadata)+5)
+	for k, v := range doc.Metadata {
+		base[k] = v
+	}
+	if len(doc.Tags) > 0 {
+		base["tags"] = doc.Tags
+	}
+	base["org_id"] = doc.OrgID
+	base["document_id"] = doc.ID
+	base["doc_name"] = doc.Name
+	base["level"] = summary.LevelChunk
 
 	// CreateDocuments handles splitting + metadata attachment in one call
-	return textsplitter.CreateDocuments(
-		splitter,
-		[]string{doc.Content},
-		[]map[string]any{
-			{
-				"org_id":      doc.OrgID,
-				"document_id": doc.ID,
-				"doc_name":    doc.Name,
-			},
-		},
-	)
+	chunks, err := textsplitter.CreateDocuments(splitter, []string{text}, []map[string]any{base})
+	if err != nil {
+		return nil, err
+	}
+
+	// CreateDocuments shares one metadata map across chunks; give each
+	// chunk its own copy before setting its ordinal.
+	for i := range chunks {
+		md := make(map[string]any, len(chunks[i].Metadata)+1)
+		for k, v := range chunks[i].Metadata {
+			md[k] = v
+		}
+		md["chunk_index"] = firstIndex + i
+		chunks[i].Metadata = md
+	}
+	return chunks, nil
 }
 
 type Service struct {
 	repo        *Repository
-	vectorStore *retrieval.LangChainVectorStore
+	uow         *database.UnitOfWork
+	vectorStore retrieval.VectorStore
 	embedder    embedding.Embedder
-	// Buffered channel acts as an in-process job queue.
-	// In production replace with Redis Streams / SQS / NATS.
-	jobs chan ingestJob
+	batches     *embedding.BatchClient // nil disables batch ingestion
+	summarizer  *summary.Summarizer    // nil disables the summary tree
+	scopes      tenancy.Scoper
+	observer    retrieval.Observer // nil leaves ingestion untracked
+	chunking    ChunkingConfig
+	// embedCalls limits the embedding calls in flight; see
+	// LimitEmbeddingCalls.
+	embedCalls chan struct{}
+	// wake nudges an idle worker when a job is enqueued locally.
+	wake chan struct{}
+	// running counts jobs being ingested on this instance.
+	running atomic.Int64
+	// perOrgJobs caps the jobs one org may run at once; 0 is no cap.
+	perOrgJobs int
+	// turn rotates the scope workers try first.
+	turn atomic.Uint64
+	// uploads holds direct uploads; nil disables them. See upload.go.
+	uploads        *objectstore.Store
+	uploadMaxBytes int64
+	uploadExpiry   time.Duration
 }
 
-type ingestJob struct {
-	doc *Document
-}
-
-func NewService(repo *Repository, vs *retrieval.LangChainVectorStore, embedder embedding.Embedder) *Service {
-	s := &Service{
+// NewService creates the service; call Run to start ingesting.
+func NewService(
+	repo *Repository,
+	uow *database.UnitOfWork,
+	vs retrieval.VectorStore,
+	embedder embedding.Embedder,
+	batches *embedding.BatchClient,
+	summarizer *summary.Summarizer,
+	scopes tenancy.Scoper,
+) *Service {
+	return &Service{
 		repo:        repo,
+		uow:         uow,
 		vectorStore: vs,
 		embedder:    embedder,
-		jobs:        make(chan ingestJob, 256),
-	}
-	// Fixed pool of goroutine workers — each owns its own context and runs forever
-	// for i := range 4
-	// s.jobs { ... } This will NOT compile in Go
-	for i := 0; i < 4; i++ {
-		go s.worker(i)
+		embedCalls:  make(chan struct{}, DefaultEmbeddingCalls),
+		batches:     batches,
+		summarizer:  summarizer,
+		scopes:      scopes,
+		chunking:    DefaultChunking,
+		wake:        make(chan struct{}, 1),
 	}
-	return s
+}
+
+// ObserveWith reports the outcome of every ingestion run to o.
+func (s *Service) ObserveWith(o retrieval.Observer) {
+	s.observer = o
 }
 
 type UploadRequest struct {
-	OrgID   string
-	Name    string
-	Content string
+	OrgID    string
+	Name     string
+	Content  string
+	Tags     []string
+	Metadata map[string]any
+	// Batch embeds through the Batch API instead of the ingestion workers:
+	// half the cost, finished within a day. Meant for large imports.
+	Batch bool
 }
 
 // Upload persists the document metadata and enqueues async embedding.
 // Returns immediately with status="pending" so the HTTP caller isn't blocked.
 func (s *Service) Upload(ctx context.Context, req UploadRequest) (*Document, error) {
-	doc := &Document{
+	if req.Batch && s.batches == nil {
+		return nil, ErrBatchDisabled
+	}
+	if err := req.validateLabels(); err != nil {
+		return nil, err
+	}
+	doc := newDocument(req, StatusPending)
+
+	// The row and its ingest job commit together, so an accepted upload is
+	// always ingested eventually.
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.reindexing(ctx, req.OrgID); err != nil {
+			return err
+		}
+		return repo.createQueued(ctx, doc, req.Batch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !req.Batch {
+		s.notify()
+	}
+	return doc, nil
+}
+
+// newDocument is the first version of the document req uploads.
+func newDocument(req UploadRequest, status Status) *Document {
+	return &Document{
 		ID:        uuid.NewString(),
 		OrgID:     req.OrgID,
 		Name:      req.Name,
 		Content:   req.Content,
-		Status:    StatusPending,
+		Status:    status,
+		Version:   1,
+		Tags:      req.Tags,
+		Metadata:  req.Metadata,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+}
 
-	if err := s.repo.Create(ctx, doc); err != nil {
-		return nil, err
+// createQueued creates doc with its ingest job, for the workers or the
+// batch submitter.
+func (r *Repository) createQueued(ctx context.Context, doc *Document, batch bool) error {
+	if err := r.Create(ctx, doc); err != nil {
+		return err
+	}
+	if batch {
+		return r.enqueueBatchJob(ctx, doc.ID, doc.OrgID)
 	}
+	return r.enqueueJob(ctx, doc.ID, doc.OrgID, "", 0)
+}
 
-	// Non-blocking enqueue: if the queue is full the doc stays "pending"
-	// and can be retried by a background sweep (not implemented here).
-	select {
-	case s.jobs <- ingestJob{doc: doc}:
-	default:
-		slog.Warn("ingestion queue full, document queued as pending", "doc_id", doc.ID)
+func (s *Service) Get(ctx context.Context, id, orgID string) (*Document, error) {
+	return s.repo.Get(ctx, id, orgID)
+}
+
+// Stats is an org's document and vector usage.
+type Stats struct {
+	Documents  int            `json:"documents"`
+	ByStatus   map[Status]int `json:"by_status"`
+	Vectors    int64          `json:"vectors"`
+	VectorSize int64          `json:"vector_storage_bytes"`
+}
+
+// Stats reports an org's usage. With pgvector, vector figures come from
+// the incrementally maintained vector_stats table, so this is cheap at any
+// size; other backends count the org's points.
+func (s *Service) Stats(ctx context.Context, orgID string) (*Stats, error) {
+	byStatus, err := s.repo.CountByStatus(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := s.vectorStore.Stats(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	st := &Stats{ByStatus: byStatus, Vectors: vs.Vectors, VectorSize: vs.Bytes}
+	for _, n := range byStatus {
+		st.Documents += n
 	}
+	return st, nil
+}
 
-	return doc, nil
+// Usage lists every org's vector usage, largest first, for storage-based
+// billing.
+func (s *Service) Usage(ctx context.Context) ([]*retrieval.VectorStats, error) {
+	return s.vectorStore.AllStats(ctx)
 }
 
-func (s *Service) List(ctx context.Context, orgID string) ([]*Document, error) {
-	return s.repo.ListByOrg(ctx, orgID)
+// Rename updates the document name, failing with ErrVersionConflict if
+// someone else changed the document since the caller read version.
+func (s *Service) Rename(ctx context.Context, id, orgID, name string, version int) (*Document, error) {
+	return s.repo.Rename(ctx, id, orgID, name, version)
 }
 
-func (s *Service) Delete(ctx context.Context, id, orgID string) error {
-	if err := s.vectorStore.DeleteByDocument(ctx, id); err != nil {
-		return err
+// Append adds text to the end of a ready document and ingests only the new
+// part, numbering its chunks after the existing ones. Meant for
+// append-only sources (chat logs, ticket threads) that would otherwise be
+// re-ingested in full on every update.
+func (s *Service) Append(ctx context.Context, id, orgID, text string) (*Document, error) {
+	var doc *Document
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.reindexing(ctx, orgID); err != nil {
+			return err
+		}
+
+		var err error
+		doc, err = repo.GetForUpdate(ctx, id, orgID)
+		if err != nil {
+			return err
+		}
+		// Appending while a previous ingest runs would race on chunk ordinals.
+		if doc.Status != StatusReady {
+			return ErrNotReady
+		}
+		if err := repo.AppendContent(ctx, id, text); err != nil {
+			return err
+		}
+		doc.Status = StatusPending
+		doc.Version++
+		return repo.enqueueJob(ctx, id, orgID, text, doc.ChunkCount)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return s.repo.Delete(ctx, id, orgID)
+	s.notify()
+	return doc, nil
 }
 
-// worker is the goroutine that consumes ingest jobs.
-func (s *Service) worker(id int) {
-	slog.Info("ingestion worker started", "worker_id", id)
-	for job := range s.jobs {
-		s.ingest(job.doc)
+// Replace swaps a document's content (and name, unless empty) for new
+// content and re-ingests it under the same ID, if it is still at the
+// expected version. The document must not be mid-ingestion. Chunks of the
+// old content that no longer match are deleted when the job starts, and
+// unchanged ones are kept rather than re-embedded; a rename drops them
+// all, since every chunk carries the name.
+func (s *Service) Replace(ctx context.Context, id, orgID, name, content string, version int) (*Document, error) {
+	var doc *Document
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.reindexing(ctx, orgID); err != nil {
+			return err
+		}
+
+		var err error
+		doc, err = repo.GetForUpdate(ctx, id, orgID)
+		if err != nil {
+			return err
+		}
+		if version != AnyVersion && doc.Version != version {
+			return ErrVersionConflict
+		}
+		// A running ingest would store chunks of the old content.
+		if doc.Status != StatusReady && doc.Status != StatusFailed {
+			return ErrNotReady
+		}
+		if name != "" && name != doc.Name {
+			if err := s.vectorStore.WithTx(tx).DeleteByDocument(ctx, id); err != nil {
+				return err
+			}
+			doc.Name = name
+		}
+		if err := repo.ReplaceContent(ctx, id, doc.Name, content); err != nil {
+			return err
+		}
+		doc.Status = StatusPending
+		doc.Version++
+		return repo.enqueueJob(ctx, id, orgID, "", 0)
+	})
+	if err != nil {
+		return nil, err
 	}
+	s.notify()
+	return doc, nil
+}
+
+// Delete removes the document row and its vectors as one unit. The row is
+// deleted first so the org_id and version checks run before any vectors are
+// touched; if removing the vectors fails the row delete is rolled back and
+// the caller can retry.
+func (s *Service) Delete(ctx context.Context, id, orgID string, version int) error {
+	return s.uow.Do(ctx, func(tx pgx.Tx) error {
+		if err := s.repo.WithTx(tx).Delete(ctx, id, orgID, version); err != nil {
+			return err
+		}
+		return s.vectorStore.WithTx(tx).DeleteByDocument(ctx, id)
+	})
 }
 
 // ingest is the full pipeline for one document:
 //  1. langchaingo textsplitter → []schema.Document (chunks with metadata)
-//  2. langchaingo pgvector store → AddDocuments (embed + store in one call)
-func (s *Service) ingest(doc *Document) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+//  2. embed + store the chunks not stored by an earlier attempt, in
+//     parallel batches (see embedChunks)
+//  3. optionally, summary tree nodes, embedded in batches like chunks
+//
+// It returns an error when the attempt should be retried; content that
+// can't be split marks the document failed for good.
+func (s *Service) ingest(ctx context.Context, job *ingestJob) error {
+	doc := job.doc
+	ctx, cancel := context.WithTimeout(ctx, ingestTimeout)
 	defer cancel()
 
-	if err := s.repo.UpdateStatus(ctx, doc.ID, StatusProcessing, 0); err != nil {
-		slog.Error("status update failed", "doc_id", doc.ID, "error", err)
-		return
+	if err := s.repo.UpdateStatus(ctx, doc.ID, StatusProcessing, job.firstChunk); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("status update: %w", err)
 	}
 
-	// S1: Split with langchaingo RecursiveCharacter splitter
-	chunks, err := splitDocument(doc)
+	// S1: Split into chunks (see splitText)
+	chunks, err := s.splitText(ctx, doc, job.text, job.firstChunk)
+	if errors.Is(err, errSentenceEmbedding) {
+		return fmt.Errorf("split: %w", err)
+	}
 	if err != nil || len(chunks) == 0 {
 		slog.Error("text splitting failed", "doc_id", doc.ID, "error", err)
-		_ = s.repo.UpdateStatus(ctx, doc.ID, StatusFailed, 0)
-		return
+		_ = s.repo.UpdateStatus(ctx, doc.ID, StatusFailed, job.firstChunk)
+		return nil
 	}
 
-	// S2: AddDocuments via langchaingo pgvector store
-	// langchaingo handles batching and embedding internally.
-	if err := s.vectorStore.AddDocuments(ctx, chunks); err != nil {
-		slog.Error("vector store add failed", "doc_id", doc.ID, "error", err)
-		_ = s.repo.UpdateStatus(ctx, doc.ID, StatusFailed, 0)
-		return
+	// S2: A previous attempt, or an ingest the sweep restarted, may have
+	// stored some of the chunks already; embed the rest.
+	todo, err := s.resumeChunks(ctx, job, chunks)
+	if err != nil {
+		return fmt.Errorf("resume ingest: %w", err)
+	}
+	if len(todo) < len(chunks) {
+		slog.Info("resuming ingestion", "doc_id", doc.ID, "checkpoint", job.checkpoint,
+			"stored", len(chunks)-len(todo), "remaining", len(todo))
+	}
+	total := job.firstChunk + len(chunks)
+	if err := s.embedChunks(ctx, job, todo, total); err != nil {
+		return fmt.Errorf("vector store add: %w", err)
 	}
 
-	if err := s.repo.UpdateStatus(ctx, doc.ID, StatusReady, len(chunks)); err != nil {
-		slog.Error("status update to ready failed", "doc_id", doc.ID, "error", err)
+	// S3: Summary tree. Appends don't rebuild it; the tree reflects the
+	// content at first ingestion.
+	if job.firstChunk == 0 {
+		s.buildSummaryTree(ctx, doc, chunks)
+	}
+
+	if err := s.repo.UpdateStatus(ctx, doc.ID, StatusReady, total); err != nil {
+		// The document was deleted while we were embedding it; drop the
+		// vectors we just wrote so they don't outlive their row.
+		if errors.Is(err, ErrNotFound) {
+			slog.Warn("document deleted during ingestion", "doc_id", doc.ID)
+			_ = s.vectorStore.DeleteByDocument(ctx, doc.ID)
+			return nil
+		}
+		return fmt.Errorf("status update to ready: %w", err)
 	}
 
-	slog.Info("document ingested", "doc_id", doc.ID, "chunks", len(chunks))
+	slog.Info("document ingested", "doc_id", doc.ID, "chunks", len(chunks), "total_chunks", total)
+	return nil
+}
+
+// buildSummaryTree stores the summary nodes of a freshly ingested
+// document, if enabled, and records the top one (a lone chunk standing in
+// for it) for the document's collections. The tree only improves broad
+// questions, so a failure is logged and the document is still marked
+// ready.
+func (s *Service) buildSummaryTree(ctx context.Context, doc *Document, chunks []schema.Document) {
+	if s.summarizer == nil {
+		return
+	}
+	nodes, err := s.summarizer.Build(ctx, chunks)
+	if err == nil {
+		for _, batch := range embedBatches(nodes) {
+			if err = s.embedBatch(ctx, batch); err != nil {
+				break
+			}
+		}
+	}
+	top := chunks[0].PageContent
+	if len(nodes) > 0 {
+		top = nodes[len(nodes)-1].PageContent
+	}
+	if err != nil {
+		slog.Warn("summary tree build failed", "doc_id", doc.ID, "error", err)
+		top = ""
+	}
+	if err := s.repo.SetSummary(ctx, doc.ID, top); err != nil {
+		slog.Warn("document summary not recorded", "doc_id", doc.ID, "error", err)
+	}
 }
@@ -0,0 +1,266 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Parallel chunk embedding
+// A document's chunks are embedded and inserted in batches, several at a
+// time, bounded in chunks and in tokens. Embedding calls are limited
+// across all of the instance's workers (LimitEmbeddingCalls), so a large
+// upload can't flood the provider; rate limits it still hits are waited
+// out by every worker together (see internal/retry). Each batch is one
+// embedding call and one INSERT, so it is stored
+// entirely or not at all; a failed batch doesn't stop the others. Once
+// every batch up to some point is stored, the job's checkpoint moves past
+// it. The next attempt skips the chunks below the checkpoint, looks at
+// what is stored beyond it (same index, same text) and only embeds the
+// rest, so a large document that fails late doesn't pay for its first
+// chunks twice.
+
+const (
+	// embedBatchSize is how many chunks one embedding call covers at most.
+	embedBatchSize = 64
+	// embedBatchTokens bounds the (estimated) tokens of one embedding
+	// call, well under the providers' per-request limits, so that batches
+	// of large chunks don't eat a whole minute's token quota at once.
+	embedBatchTokens = 50_000
+	// embedParallelism bounds the batches one document embeds at once, so
+	// one large document doesn't take all of the instance's calls.
+	embedParallelism = 4
+	// DefaultEmbeddingCalls is how many embedding calls an instance makes
+	// at once unless LimitEmbeddingCalls says otherwise.
+	DefaultEmbeddingCalls = 8
+)
+
+// LimitEmbeddingCalls caps the embedding calls the instance's ingestion
+// makes at once, across all workers and documents. Call it before Run.
+func (s *Service) LimitEmbeddingCalls(n int) {
+	s.embedCalls = make(chan struct{}, n)
+}
+
+// embedDocuments embeds texts once a call slot is free.
+func (s *Service) embedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	select {
+	case s.embedCalls <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.embedCalls }()
+	return s.embedder.EmbedDocuments(ctx, texts)
+}
+
+// embedBatches splits chunks into batches of at most embedBatchSize chunks
+// and embedBatchTokens tokens; a chunk larger than that goes alone.
+func embedBatches(chunks []schema.Document) [][]schema.Document {
+	var batches [][]schema.Document
+	start, tokens := 0, int64(0)
+	for i, c := range chunks {
+		n := embedding.EstimateTokens(c.PageContent)
+		if i > start && (i-start == embedBatchSize || tokens+n > embedBatchTokens) {
+			batches = append(batches, chunks[start:i])
+			start, tokens = i, 0
+		}
+		tokens += n
+	}
+	if start < len(chunks) {
+		batches = append(batches, chunks[start:])
+	}
+	return batches
+}
+
+// ChunkRange is an inclusive range of chunk indexes.
+type ChunkRange struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+func (r ChunkRange) String() string {
+	if r.First == r.Last {
+		return fmt.Sprint(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// ChunkRangeError reports the chunks an ingest attempt failed to store;
+// the others were stored and are kept for the next attempt.
+type ChunkRangeError struct {
+	Failed []ChunkRange
+	// Err is the first batch's failure.
+	Err error
+}
+
+func (e *ChunkRangeError) Error() string {
+	ranges := make([]string, len(e.Failed))
+	for i, r := range e.Failed {
+		ranges[i] = r.String()
+	}
+	return fmt.Sprintf("chunks %s not stored: %v", strings.Join(ranges, ", "), e.Err)
+}
+
+func (e *ChunkRangeError) Unwrap() error { return e.Err }
+
+// resumeChunks returns the chunks of job still to be stored. Chunks below
+// the checkpoint are taken as stored; beyond it, stored chunks that match
+// are kept and the rest pruned. Summary nodes are removed before a full
+// ingest rebuilds them.
+func (s *Service) resumeChunks(ctx context.Context, job *ingestJob, chunks []schema.Document) ([]schema.Document, error) {
+	if job.firstChunk == 0 {
+		if err := s.vectorStore.DeleteSummaries(ctx, job.docID); err != nil {
+			return nil, err
+		}
+	}
+	stored, err := s.vectorStore.StoredChunks(ctx, job.docID, job.checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		keep []int
+		todo []schema.Document
+	)
+	for i, c := range chunks {
+		index := job.firstChunk + i
+		if index < job.checkpoint {
+			continue
+		}
+		if text, ok := stored[index]; ok && text == c.PageContent {
+			keep = append(keep, index)
+			continue
+		}
+		todo = append(todo, c)
+	}
+	if len(keep) < len(stored) {
+		if err := s.vectorStore.PruneChunks(ctx, job.docID, job.checkpoint, keep); err != nil {
+			return nil, err
+		}
+	}
+	return todo, nil
+}
+
+// embedChunks embeds and stores chunks of job (all of them from the
+// checkpoint on, in order) in batches (see embedBatches), embedParallelism
+// at a time, advancing the checkpoint as batches complete. end is the
+// index after the job's last chunk. It returns a *ChunkRangeError naming
+// every chunk that wasn't stored if any batch failed, wrapping
+// errInterrupted if the instance is shutting down.
+func (s *Service) embedChunks(ctx context.Context, job *ingestJob, chunks []schema.Document, end int) error {
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, embedParallelism)
+		mu      sync.Mutex
+		failed  []ChunkRange
+		first   error
+		batches = embedBatches(chunks)
+		done    = make([]bool, len(batches))
+		next    int // first batch not yet stored
+	)
+
+	// resumeAt is the index of the first chunk not yet stored; callers
+	// hold mu.
+	resumeAt := func() int {
+		if next == len(batches) {
+			return end
+		}
+		c, _ := batches[next][0].Metadata["chunk_index"].(int)
+		return c
+	}
+	// checkpoint returns where the job resumes once batch i is stored, or
+	// -1 if that doesn't move it.
+	checkpoint := func(i int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		done[i] = true
+		if i != next {
+			return -1
+		}
+		for next < len(batches) && done[next] {
+			next++
+		}
+		return resumeAt()
+	}
+
+	for i, batch := range batches {
+		var err error
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err() // out of time: the remaining batches fail
+		case <-job.stop:
+			err = errInterrupted
+		}
+		wg.Go(func() {
+			if err == nil {
+				err = s.embedBatch(ctx, batch)
+				<-sem
+			}
+			if err == nil {
+				if c := checkpoint(i); c >= 0 {
+					if err := s.repo.saveCheckpoint(ctx, job.id, c); err != nil {
+						slog.Warn("save ingest checkpoint failed", "doc_id", job.docID, "checkpoint", c, "error", err)
+					}
+				}
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, chunkRanges(batch)...)
+			if first == nil || errors.Is(err, errInterrupted) {
+				first = err
+			}
+		})
+	}
+	wg.Wait()
+	if next > 0 {
+		job.checkpoint = resumeAt()
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	slices.SortFunc(failed, func(a, b ChunkRange) int { return a.First - b.First })
+	merged := failed[:1]
+	for _, r := range failed[1:] {
+		if last := &merged[len(merged)-1]; r.First == last.Last+1 {
+			last.Last = r.Last
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return &ChunkRangeError{Failed: merged, Err: first}
+}
+
+func (s *Service) embedBatch(ctx context.Context, batch []schema.Document) error {
+	texts := make([]string, len(batch))
+	for i, c := range batch {
+		texts[i] = c.PageContent
+	}
+	vecs, err := s.embedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("embed: %w", err)
+	}
+	return s.vectorStore.AddEmbedded(ctx, batch, vecs)
+}
+
+// chunkRanges collapses the chunk indexes of batch into ranges. A resumed
+// batch can skip over chunks stored earlier.
+func chunkRanges(batch []schema.Document) []ChunkRange {
+	var ranges []ChunkRange
+	for _, c := range batch {
+		i, _ := c.Metadata["chunk_index"].(int)
+		if n := len(ranges); n > 0 && ranges[n-1].Last == i-1 {
+			ranges[n-1].Last = i
+			continue
+		}
+		ranges = append(ranges, ChunkRange{First: i, Last: i})
+	}
+	return ranges
+}
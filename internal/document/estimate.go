@@ -0,0 +1,62 @@
+package document
+
+import (
+	"errors"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/summary"
+)
+
+// IngestEstimate predicts what ingesting a document consumes. Token counts
+// use the same estimate metering does.
+type IngestEstimate struct {
+	Chunks int `json:"chunks"`
+	// ChunkTokens is what embedding the chunks costs; with Batch it goes
+	// through the Batch API. EmbeddingTokens adds the summary nodes, which
+	// are always embedded directly.
+	ChunkTokens     int64 `json:"chunk_tokens"`
+	EmbeddingTokens int64 `json:"embedding_tokens"`
+	// SummaryCalls, PromptTokens and CompletionTokens are the LLM calls
+	// building the summary tree; zero when it is disabled.
+	SummaryCalls     int   `json:"summary_calls"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	Batch            bool  `json:"batch"`
+}
+
+// Estimate splits req's content the way ingestion would and counts the
+// tokens it would embed and summarize. Nothing is stored or embedded, so
+// with semantic chunking the fixed-size split stands in for the chunks and
+// the sentence embeddings are added to EmbeddingTokens.
+func (s *Service) Estimate(req UploadRequest) (*IngestEstimate, error) {
+	if req.Batch && s.batches == nil {
+		return nil, ErrBatchDisabled
+	}
+	if req.Content == "" {
+		return nil, errors.New("content is required")
+	}
+	if err := req.validateLabels(); err != nil {
+		return nil, err
+	}
+	doc := &Document{OrgID: req.OrgID, Name: req.Name, Tags: req.Tags, Metadata: req.Metadata}
+	chunks, err := chunkText(doc, req.Content, 0, splitterFor(doc.Name, s.chunking))
+	if err != nil {
+		return nil, err
+	}
+
+	est := &IngestEstimate{Chunks: len(chunks), Batch: req.Batch}
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.PageContent
+		est.ChunkTokens += embedding.EstimateTokens(c.PageContent)
+	}
+	est.EmbeddingTokens = est.ChunkTokens
+	if s.chunking.Strategy == ChunkSemantic && !req.Batch && CodeLanguage(req.Name) == "" {
+		est.EmbeddingTokens += embedding.EstimateTokens(req.Content)
+	}
+	if s.summarizer != nil {
+		est.SummaryCalls, est.PromptTokens, est.CompletionTokens = summary.Estimate(texts)
+		est.EmbeddingTokens += est.CompletionTokens
+	}
+	return est, nil
+}
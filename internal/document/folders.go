@@ -0,0 +1,120 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Folders
+//
+// A document with a "path" metadata value, like those expanded from an
+// archive or synced from a repository, lives in the folder that path
+// names: "policies/hr/leave.md" is in "policies/hr". Folders aren't stored
+// anywhere; they exist while a document is in them. Documents without a
+// path sit at the root.
+
+// MetaPath is the metadata key holding a document's path.
+const MetaPath = "path"
+
+// Folder is a folder directly under the one listed.
+type Folder struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// DocumentCount counts the documents anywhere under the folder.
+	DocumentCount int `json:"document_count"`
+}
+
+// FolderListing is the contents of one folder.
+type FolderListing struct {
+	Path    string   `json:"path"`
+	Folders []Folder `json:"folders"`
+	// DocumentCount counts the documents directly in the folder.
+	DocumentCount int `json:"document_count"`
+}
+
+// CleanFolder normalizes a folder path: no leading, trailing or repeated
+// slashes, and "" for the root. ".." isn't allowed.
+func CleanFolder(folder string) (string, error) {
+	for _, part := range strings.Split(folder, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: folder must not contain ..", ErrInvalidListing)
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+folder), "/"), nil
+}
+
+// folderPrefix is what the paths of documents under folder start with.
+func folderPrefix(folder string) string {
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
+
+// Folders lists the folders directly in folder ("" for the root) and
+// counts the documents there.
+func (s *Service) Folders(ctx context.Context, orgID, folder string) (*FolderListing, error) {
+	folder, err := CleanFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.folders(ctx, orgID, folder)
+}
+
+// pathExpr is a document's path, "" when it has none.
+func (r *Repository) pathExpr() string {
+	return `COALESCE(` + r.read("metadata") + `->>'` + MetaPath + `', '')`
+}
+
+// folderScope is the condition keeping documents in folder, bound at $n:
+// anywhere under it when recursive, or directly in it.
+func (r *Repository) folderScope(folder string, recursive bool, n int) (string, any) {
+	prefix := folderPrefix(folder)
+	scope := fmt.Sprintf(`%s LIKE $%d`, r.pathExpr(), n)
+	if !recursive {
+		scope += ` AND strpos(` + r.pathBelow(prefix) + `, '/') = 0`
+	}
+	return scope, escapeLike(prefix) + "%"
+}
+
+// pathBelow is the part of a document's path after prefix: "hr/leave.md"
+// in "policies/".
+func (r *Repository) pathBelow(prefix string) string {
+	return fmt.Sprintf(`substr(%s, %d)`, r.pathExpr(), utf8.RuneCountInString(prefix)+1)
+}
+
+func (r *Repository) folders(ctx context.Context, orgID, folder string) (*FolderListing, error) {
+	prefix := folderPrefix(folder)
+	under, arg := r.folderScope(folder, true, 2)
+	rows, err := r.db.Query(ctx,
+		`SELECT split_part(rest, '/', 1), count(*)
+		 FROM (SELECT `+r.pathBelow(prefix)+` AS rest FROM documents WHERE org_id = $1 AND `+under+`) d
+		 WHERE strpos(rest, '/') > 0
+		 GROUP BY 1
+		 ORDER BY 1`,
+		orgID, arg)
+	if err != nil {
+		return nil, err
+	}
+	subfolders, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Folder, error) {
+		var f Folder
+		err := row.Scan(&f.Name, &f.DocumentCount)
+		f.Path = prefix + f.Name
+		return f, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &FolderListing{Path: folder, Folders: subfolders}
+	scope, arg := r.folderScope(folder, false, 2)
+	err = r.db.QueryRow(ctx,
+		`SELECT count(*) FROM documents WHERE org_id = $1 AND `+scope, orgID, arg,
+	).Scan(&listing.DocumentCount)
+	return listing, err
+}
@@ -0,0 +1,179 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Archive imports
+//
+// An uploaded archive is expanded into one document per file, named by its
+// path in the archive, which is also kept in the "path" metadata key so
+// retrieval can filter on folders. An import record ties the documents
+// together under the archive's name, along with the files that were left
+// out and why. The import's per-file status is read from its documents, so
+// it stays current as they are ingested, replaced or deleted.
+
+// ErrEmptyImport is returned for an archive with no file that could be
+// imported.
+var ErrEmptyImport = errors.New("archive has no importable files")
+
+// ImportFile is one file of an archive, its text already extracted.
+type ImportFile struct {
+	Path    string
+	Content string
+}
+
+// SkippedFile is a file of an archive that wasn't imported.
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Import records one expanded archive.
+type Import struct {
+	ID        string        `json:"id"`
+	OrgID     string        `json:"org_id"`
+	Name      string        `json:"name"`
+	Files     int           `json:"files"`
+	Skipped   []SkippedFile `json:"skipped"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// ImportedFile is the current state of one imported file's document.
+type ImportedFile struct {
+	Path       string `json:"path"`
+	DocumentID string `json:"document_id"`
+	Status     Status `json:"status"`
+	ChunkCount int    `json:"chunk_count"`
+}
+
+// ImportStatus is an import with the state of its documents.
+type ImportStatus struct {
+	Import
+	ByStatus  map[Status]int `json:"by_status"`
+	Documents []ImportedFile `json:"documents"`
+}
+
+// Import creates a document for each file, labelled with req's tags and
+// metadata, and queues them together: all or none are stored. req.Name is
+// the archive's.
+func (s *Service) Import(ctx context.Context, req UploadRequest, files []ImportFile, skipped []SkippedFile) (*Import, []*Document, error) {
+	if req.Batch && s.batches == nil {
+		return nil, nil, ErrBatchDisabled
+	}
+	if err := req.validateLabels(); err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, ErrEmptyImport
+	}
+	imp := &Import{
+		ID:        uuid.NewString(),
+		OrgID:     req.OrgID,
+		Name:      req.Name,
+		Files:     len(files),
+		Skipped:   skipped,
+		CreatedAt: time.Now(),
+	}
+	if imp.Skipped == nil {
+		imp.Skipped = []SkippedFile{}
+	}
+
+	docs := make([]*Document, len(files))
+	for i, f := range files {
+		fileReq := req
+		fileReq.Name, fileReq.Content = f.Path, f.Content
+		fileReq.Metadata = maps.Clone(req.Metadata)
+		if fileReq.Metadata == nil {
+			fileReq.Metadata = map[string]any{}
+		}
+		fileReq.Metadata[MetaPath] = f.Path
+		fileReq.Metadata["import_id"] = imp.ID
+		docs[i] = newDocument(fileReq, StatusPending)
+	}
+
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.reindexing(ctx, req.OrgID); err != nil {
+			return err
+		}
+		if err := repo.createImport(ctx, imp); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if err := repo.createQueued(ctx, doc, req.Batch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !req.Batch {
+		s.notify()
+	}
+	return imp, docs, nil
+}
+
+// GetImport returns an import of orgID with the current state of its
+// documents.
+func (s *Service) GetImport(ctx context.Context, id, orgID string) (*ImportStatus, error) {
+	imp, err := s.repo.getImport(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.repo.importedFiles(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	st := &ImportStatus{Import: *imp, ByStatus: map[Status]int{}, Documents: files}
+	for _, f := range files {
+		st.ByStatus[f.Status]++
+	}
+	return st, nil
+}
+
+func (r *Repository) createImport(ctx context.Context, imp *Import) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO document_imports (id, org_id, name, files, skipped, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		imp.ID, imp.OrgID, imp.Name, imp.Files, imp.Skipped, imp.CreatedAt)
+	return err
+}
+
+func (r *Repository) getImport(ctx context.Context, id, orgID string) (*Import, error) {
+	imp := &Import{}
+	err := r.db.QueryRow(ctx,
+		`SELECT id, org_id, name, files, skipped, created_at FROM document_imports WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	).Scan(&imp.ID, &imp.OrgID, &imp.Name, &imp.Files, &imp.Skipped, &imp.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return imp, err
+}
+
+// importedFiles lists the documents of an import still present, by path.
+func (r *Repository) importedFiles(ctx context.Context, importID, orgID string) ([]ImportedFile, error) {
+	metadata := r.read("metadata")
+	rows, err := r.db.Query(ctx,
+		`SELECT `+metadata+`->>'path', id, `+r.read("status")+`, `+r.read("chunk_count")+`
+		 FROM documents
+		 WHERE org_id = $1 AND `+metadata+`->>'import_id' = $2
+		 ORDER BY 1`,
+		orgID, importID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ImportedFile, error) {
+		var f ImportedFile
+		err := row.Scan(&f.Path, &f.DocumentID, &f.Status, &f.ChunkCount)
+		return f, err
+	})
+}
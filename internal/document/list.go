@@ -0,0 +1,155 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// DefaultListLimit is the page size when a listing doesn't ask for one.
+	DefaultListLimit = 100
+	// MaxListLimit caps the page size.
+	MaxListLimit = 500
+)
+
+// ErrInvalidListing is returned for a listing with an unknown status or
+// sort, or an out-of-range limit or offset.
+var ErrInvalidListing = errors.New("invalid document listing")
+
+// listSorts are the sort keys a listing accepts; "-" in front of one sorts
+// descending.
+var listSorts = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"name":       "name",
+	"status":     "status",
+}
+
+// ListOptions filters, sorts and pages an org's documents. The zero value
+// lists the first DefaultListLimit documents, newest first.
+type ListOptions struct {
+	// Status keeps documents in one status.
+	Status Status
+	// Search keeps documents whose name contains it, ignoring case.
+	Search string
+	// Tags keeps documents with any of these tags.
+	Tags []string
+	// Folder keeps documents directly in this folder, or anywhere under
+	// it when Recursive; see Folders. Empty lists every document.
+	Folder    string
+	Recursive bool
+	// Sort is a sort key, "-created_at" by default.
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// DocumentList is one page of a listing; Total counts every document that
+// matches the filters.
+type DocumentList struct {
+	Documents []*Document
+	Total     int
+}
+
+func (o *ListOptions) validate() error {
+	switch o.Status {
+	case "", StatusPending, StatusProcessing, StatusReady, StatusFailed,
+		StatusBatchValidating, StatusBatchInProgress, StatusBatchFinalizing:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidListing, o.Status)
+	}
+	if o.Sort == "" {
+		o.Sort = "-created_at"
+	}
+	if _, ok := listSorts[strings.TrimPrefix(o.Sort, "-")]; !ok {
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidListing, o.Sort)
+	}
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit < 0 || o.Limit > MaxListLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListing, MaxListLimit)
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidListing)
+	}
+	tags, err := normalizeTags(o.Tags)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidListing, err)
+	}
+	o.Tags = tags
+	if o.Folder, err = CleanFolder(o.Folder); err != nil {
+		return err
+	}
+	return nil
+}
+
+// List returns a page of orgID's documents matching opts, which must be
+// valid.
+func (r *Repository) List(ctx context.Context, orgID string, opts ListOptions) (*DocumentList, error) {
+	where := `org_id=$1`
+	args := []any{orgID}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		where += fmt.Sprintf(` AND %s = $%d`, r.read("status"), len(args))
+	}
+	if opts.Search != "" {
+		args = append(args, "%"+escapeLike(opts.Search)+"%")
+		where += fmt.Sprintf(` AND %s ILIKE $%d`, r.read("name"), len(args))
+	}
+	if len(opts.Tags) > 0 {
+		args = append(args, opts.Tags)
+		where += fmt.Sprintf(` AND %s && $%d`, r.read("tags"), len(args))
+	}
+	if opts.Folder != "" {
+		scope, arg := r.folderScope(opts.Folder, opts.Recursive, len(args)+1)
+		args = append(args, arg)
+		where += ` AND ` + scope
+	}
+
+	list := &DocumentList{}
+	if err := r.db.QueryRow(ctx, `SELECT count(*) FROM documents WHERE `+where, args...).Scan(&list.Total); err != nil {
+		return nil, err
+	}
+
+	dir := "ASC"
+	key := opts.Sort
+	if strings.HasPrefix(key, "-") {
+		dir, key = "DESC", key[1:]
+	}
+	// id breaks ties so pages don't overlap.
+	order := fmt.Sprintf(`%s %s, id %s`, r.read(listSorts[key]), dir, dir)
+	args = append(args, opts.Limit, opts.Offset)
+	rows, err := r.db.Query(ctx,
+		fmt.Sprintf(`SELECT %s FROM documents WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+			r.columns(), where, order, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	list.Documents, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Document, error) {
+		return scanDocument(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// escapeLike makes s match itself in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// List returns a page of orgID's documents.
+func (s *Service) List(ctx context.Context, orgID string, opts ListOptions) (*DocumentList, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return s.repo.List(ctx, orgID, opts)
+}
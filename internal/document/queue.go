@@ -0,0 +1,397 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/slo"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Durable ingestion queue
+// Ingest jobs are rows in ingest_jobs, written in the same transaction as
+// the document change that needs them, so a crash or restart can't lose
+// one. Workers claim jobs with FOR UPDATE SKIP LOCKED under a lease: a job
+// whose worker died becomes claimable again once its lease runs out, and
+// any number of replicas can poll the same table. ingest_jobs is a content
+// table, so isolated orgs keep their queue (and appended text) in their own
+// storage and workers visit every tenancy scope.
+//
+// A job checkpoints its progress as chunk batches are stored (see
+// embedChunks): whoever runs it next, after a failure, a crashed worker or
+// a redeploy, resumes at the checkpoint. An attempt that moved the
+// checkpoint forward doesn't count towards maxIngestAttempts, so a large
+// document spread over several attempts still finishes, and shutdown stops
+// a job at the next batch and hands it back instead of waiting it out.
+//
+// Workers are shared by every org, so claims are fair rather than first
+// come, first served: the next job goes to the org with the fewest jobs
+// running (counted by live leases, across replicas), the oldest job
+// breaking ties, and workers start each pass at the next tenancy scope. An
+// org bulk-importing thousands of documents then takes turns with one
+// uploading a single file instead of queueing it behind the import. A
+// per-org limit (LimitIngestionPerOrg) additionally caps the jobs one org
+// may have running at once; simultaneous claims on several replicas may
+// overshoot it by a job or two until their leases are counted.
+
+const (
+	// ingestWorkers is the number of jobs one instance runs concurrently.
+	ingestWorkers = 4
+	// ingestPollInterval is how often idle workers look for jobs enqueued
+	// by other replicas or due for a retry; local enqueues wake them at once.
+	ingestPollInterval = 2 * time.Second
+	// ingestTimeout bounds one attempt; ingestLease must outlast it so a
+	// live worker never loses its job to another.
+	ingestTimeout = 5 * time.Minute
+	ingestLease   = 10 * time.Minute
+	// maxIngestAttempts is how often a job is tried without progress before
+	// the document is marked failed.
+	maxIngestAttempts = 5
+	// sweepBatch caps how many stuck documents one sweep re-enqueues per
+	// scope, so a large backlog is spread over several intervals.
+	sweepBatch = 500
+)
+
+// ingestJob covers both full ingestion and appends: text is the content to
+// split and firstChunk the ordinal its first chunk gets.
+type ingestJob struct {
+	id         int64
+	docID      string
+	orgID      string
+	doc        *Document
+	text       string
+	firstChunk int
+	// checkpoint is the chunk index to resume at; chunks of the job below
+	// it are stored.
+	checkpoint int
+	attempts   int
+	// objectKey is the uploaded file the content still has to be read
+	// from; see loadUpload.
+	objectKey string
+	// reindexID is the reindex the job rebuilds a document for, if any.
+	reindexID string
+	// stop is closed when this instance shuts down.
+	stop <-chan struct{}
+}
+
+// errInterrupted is returned by an ingest stopped for shutdown; the job is
+// released for another worker to resume at once.
+var errInterrupted = errors.New("ingestion interrupted by shutdown")
+
+// enqueueJob records a job for docID. Empty text stands for the whole
+// document content, read when the job runs.
+func (r *Repository) enqueueJob(ctx context.Context, docID, orgID, text string, firstChunk int) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO ingest_jobs (document_id, org_id, text, first_chunk) VALUES ($1, $2, NULLIF($3, ''), $4)`,
+		docID, orgID, text, firstChunk)
+	return err
+}
+
+// claimJob leases the oldest runnable job of the org with the fewest jobs
+// running, skipping orgs that already run perOrg jobs (0 for no limit). It
+// returns nil when there is none.
+func (r *Repository) claimJob(ctx context.Context, perOrg int) (*ingestJob, error) {
+	var (
+		job  ingestJob
+		text *string
+	)
+	err := r.db.QueryRow(ctx,
+		`WITH running AS (
+			 SELECT org_id, COUNT(*) AS n FROM ingest_jobs
+			 WHERE locked_until >= NOW()
+			 GROUP BY org_id
+		 )
+		 UPDATE ingest_jobs SET attempts = attempts + 1, locked_until = NOW() + $1::interval
+		 WHERE id = (
+			 SELECT j.id FROM ingest_jobs j
+			 LEFT JOIN running ON running.org_id = j.org_id
+			 WHERE NOT j.batch AND j.run_after <= NOW() AND (j.locked_until IS NULL OR j.locked_until < NOW())
+			   AND ($2::int = 0 OR COALESCE(running.n, 0) < $2)
+			 ORDER BY COALESCE(running.n, 0), j.id
+			 LIMIT 1
+			 FOR UPDATE OF j SKIP LOCKED
+		 )
+		 RETURNING id, document_id, org_id, text, first_chunk, COALESCE(checkpoint, first_chunk), attempts, COALESCE(object_key, ''),
+			 COALESCE(reindex_id, '')`,
+		ingestLease.String(), perOrg,
+	).Scan(&job.id, &job.docID, &job.orgID, &text, &job.firstChunk, &job.checkpoint, &job.attempts, &job.objectKey,
+		&job.reindexID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if text != nil {
+		job.text = *text
+	}
+	return &job, nil
+}
+
+func (r *Repository) completeJob(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM ingest_jobs WHERE id = $1`, id)
+	return err
+}
+
+// saveCheckpoint advances a job's checkpoint, never backwards, and renews
+// its lease. Progress resets the attempt count to this one attempt.
+func (r *Repository) saveCheckpoint(ctx context.Context, id int64, checkpoint int) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE ingest_jobs SET checkpoint = GREATEST(COALESCE(checkpoint, 0), $2), attempts = 1,
+			 locked_until = NOW() + $3::interval
+		 WHERE id = $1`,
+		id, checkpoint, ingestLease.String())
+	return err
+}
+
+// releaseJob hands an interrupted job back without counting the attempt.
+func (r *Repository) releaseJob(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE ingest_jobs SET locked_until = NULL, attempts = GREATEST(attempts - 1, 0) WHERE id = $1`, id)
+	return err
+}
+
+// retryJob releases a failed job to run again after delay.
+func (r *Repository) retryJob(ctx context.Context, id int64, delay time.Duration, cause error) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE ingest_jobs SET locked_until = NULL, run_after = NOW() + $2::interval, last_error = $3 WHERE id = $1`,
+		id, delay.String(), cause.Error())
+	return err
+}
+
+// countRetry records another ingestion attempt on the document.
+func (r *Repository) countRetry(ctx context.Context, docID string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("retries", r.read("retries")+" + 1")+` WHERE id = $1`, docID)
+	return err
+}
+
+// requeueStuck re-enqueues a full ingest for documents left pending or
+// processing for longer than stuckAfter with no job to finish them (a job
+// lost before the durable queue existed, or removed by hand). Documents
+// with a job are the queue's business, however old. Returns the number
+// re-enqueued; at most sweepBatch per call.
+func (r *Repository) requeueStuck(ctx context.Context, stuckAfter time.Duration) (int64, error) {
+	tag, err := r.db.Exec(ctx,
+		`WITH stuck AS (
+			 SELECT id FROM documents d
+			 WHERE `+r.read("status")+` IN ($2, $3)
+			   AND `+r.read("updated_at")+` < NOW() - $1::interval
+			   AND NOT EXISTS (SELECT 1 FROM ingest_jobs j WHERE j.document_id = d.id)
+			 LIMIT `+strconv.Itoa(sweepBatch)+`
+			 FOR UPDATE SKIP LOCKED
+		 ), requeued AS (
+			 UPDATE documents d SET `+r.set("status", "$2")+`, `+
+			r.set("retries", r.read("retries")+" + 1")+`, `+
+			r.set("updated_at", "NOW()")+`
+			 FROM stuck WHERE d.id = stuck.id
+			 RETURNING d.id, d.org_id
+		 )
+		 INSERT INTO ingest_jobs (document_id, org_id) SELECT id, org_id FROM requeued`,
+		stuckAfter.String(), StatusPending, StatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunSweeper re-enqueues stuck documents (see requeueStuck) every interval
+// until ctx is cancelled, in the shared storage and every isolated org's.
+// It also deletes direct uploads whose file never arrived, stuckAfter past
+// their URL's expiry.
+func (s *Service) RunSweeper(ctx context.Context, interval, stuckAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, scope := range s.scopes.Scopes(ctx) {
+			n, err := s.repo.requeueStuck(scope, stuckAfter)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("stuck document sweep failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+				}
+				continue
+			}
+			if n > 0 {
+				slog.Warn("re-enqueued stuck documents", "count", n, "org_id", tenancy.OrgFrom(scope))
+				s.notify()
+			}
+			if n, err := s.deleteAbandonedUploads(scope, stuckAfter); err != nil {
+				if ctx.Err() == nil {
+					slog.Error("abandoned upload sweep failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+				}
+			} else if n > 0 {
+				slog.Info("deleted abandoned uploads", "count", n, "org_id", tenancy.OrgFrom(scope))
+			}
+		}
+	}
+}
+
+// Run starts the ingestion workers and blocks until ctx is cancelled. A job
+// running at cancellation stops after the chunk batches in flight and is
+// released to resume elsewhere.
+func (s *Service) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for i := range ingestWorkers {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			s.worker(ctx, i)
+		}()
+	}
+	for range ingestWorkers {
+		<-done
+	}
+}
+
+// worker claims and runs jobs until ctx is cancelled, sleeping between
+// polls when every scope's queue is empty.
+func (s *Service) worker(ctx context.Context, id int) {
+	slog.Info("ingestion worker started", "worker_id", id)
+	ticker := time.NewTicker(ingestPollInterval)
+	defer ticker.Stop()
+	for {
+		for s.runNext(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// runNext runs one job from the first scope that has one, starting one
+// scope further along each call so no storage is always visited last. It
+// reports whether it found any.
+func (s *Service) runNext(ctx context.Context) bool {
+	scopes := s.scopes.Scopes(ctx)
+	start := int(s.turn.Add(1) % uint64(max(len(scopes), 1)))
+	for i := range scopes {
+		scope := scopes[(start+i)%len(scopes)]
+		job, err := s.repo.claimJob(scope, s.perOrgJobs)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("claim ingest job failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		job.stop = ctx.Done()
+		s.running.Add(1)
+		s.runJob(context.WithoutCancel(tenancy.WithOrg(scope, job.orgID)), job)
+		s.running.Add(-1)
+		return true
+	}
+	return false
+}
+
+// runJob loads the job's document, ingests it and settles the job: done,
+// retried with backoff, or given up with the document marked failed.
+func (s *Service) runJob(ctx context.Context, job *ingestJob) {
+	doc, err := s.repo.Get(ctx, job.docID, job.orgID)
+	if errors.Is(err, ErrNotFound) {
+		// Deleted before its turn; the cascade normally takes the job too.
+		_ = s.repo.completeJob(ctx, job.id)
+		return
+	}
+	if err != nil {
+		s.settle(ctx, job, err)
+		return
+	}
+	job.doc = doc
+	if job.objectKey != "" {
+		err = s.loadUpload(ctx, job)
+		if errors.Is(err, errUnextractable) {
+			slog.Error("uploaded file unreadable, giving up", "doc_id", job.docID, "error", err)
+			_ = s.repo.UpdateStatus(ctx, job.docID, StatusFailed, 0)
+			_ = s.repo.completeJob(ctx, job.id)
+			s.deleteUpload(ctx, job.orgID, job.docID)
+			return
+		}
+		if err != nil {
+			s.settle(ctx, job, err)
+			return
+		}
+	}
+	if job.text == "" {
+		if job.text, err = s.repo.Content(ctx, job.docID, job.orgID); err != nil {
+			s.settle(ctx, job, err)
+			return
+		}
+	}
+	start := time.Now()
+	err = s.ingest(ctx, job)
+	if s.observer != nil && !errors.Is(err, errInterrupted) {
+		s.observer.Observe(slo.OpIngestion, time.Since(start), err)
+	}
+	s.settle(ctx, job, err)
+}
+
+func (s *Service) settle(ctx context.Context, job *ingestJob, err error) {
+	switch {
+	case err == nil:
+		err = s.repo.completeJob(ctx, job.id)
+	case errors.Is(err, errInterrupted):
+		slog.Info("ingestion interrupted, released", "doc_id", job.docID, "checkpoint", job.checkpoint)
+		_ = s.repo.UpdateStatus(ctx, job.docID, StatusPending, job.firstChunk)
+		err = s.repo.releaseJob(ctx, job.id)
+	case job.attempts < maxIngestAttempts:
+		delay := time.Duration(job.attempts*job.attempts) * 30 * time.Second
+		slog.Warn("ingestion failed, will retry", "doc_id", job.docID, "attempt", job.attempts, "retry_in", delay, "error", err)
+		_ = s.repo.UpdateStatus(ctx, job.docID, StatusPending, job.firstChunk)
+		_ = s.repo.countRetry(ctx, job.docID)
+		err = s.repo.retryJob(ctx, job.id, delay, err)
+	default:
+		slog.Error("ingestion failed, giving up", "doc_id", job.docID, "attempts", job.attempts, "error", err)
+		_ = s.repo.UpdateStatus(ctx, job.docID, StatusFailed, job.firstChunk)
+		err = s.repo.completeJob(ctx, job.id)
+		if job.objectKey != "" {
+			s.deleteUpload(ctx, job.orgID, job.docID)
+		}
+	}
+	if err != nil {
+		slog.Error("settle ingest job failed", "job_id", job.id, "doc_id", job.docID, "error", err)
+	}
+	if job.reindexID != "" {
+		if err := s.repo.finishReindex(ctx, job.orgID); err != nil {
+			slog.Error("finish reindex failed", "reindex_id", job.reindexID, "error", err)
+		}
+	}
+}
+
+// LimitIngestionPerOrg caps how many ingestion jobs one org may have
+// running at once across all replicas; 0 removes the cap. Jobs beyond it
+// wait for the org's running ones to finish, leaving the workers to other
+// orgs.
+func (s *Service) LimitIngestionPerOrg(n int) {
+	s.perOrgJobs = max(n, 0)
+}
+
+// notify wakes an idle worker after a local enqueue.
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// PendingJobs is the number of ingestion jobs running on this instance.
+// Queued jobs are durable and resume after a restart, but an operator can
+// wait for this to reach zero before maintenance to avoid repeating work.
+func (s *Service) PendingJobs() int64 {
+	return s.running.Load()
+}
@@ -0,0 +1,248 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Reindexing
+//
+// A reindex rebuilds every vector of an org from the documents' stored
+// content, after a chunking or embedding model change or when the index
+// is suspected to be damaged. Starting one drops the org's vectors, puts
+// its ready and failed documents back to pending and queues a full ingest
+// for each, tagged with the reindex, all in one transaction; the regular
+// workers then work through them. Progress is read from the jobs left.
+//
+// It stops the world for the org's documents: nothing else may be queued
+// while the reindex runs (uploads, replacements, appends and imports fail
+// with ErrReindexing), and a reindex can't start while the org has
+// ingestion under way. Queries keep working but only find documents
+// already rebuilt.
+
+var (
+	// ErrReindexing is returned for a document change, or a second
+	// reindex, while the org's reindex runs.
+	ErrReindexing = errors.New("a reindex is in progress; try again when it finishes")
+	// ErrIngestionBusy is returned when a reindex is started while the
+	// org's documents are still being ingested.
+	ErrIngestionBusy = errors.New("documents are still being ingested; wait for them to finish")
+	// ErrNothingToReindex is returned when the org has no document to
+	// rebuild.
+	ErrNothingToReindex = errors.New("there are no documents to reindex")
+	// ErrNoReindex is returned when the org has never been reindexed.
+	ErrNoReindex = errors.New("no reindex has been run")
+)
+
+// Reindex records one reindex of an org.
+type Reindex struct {
+	ID             string `json:"id"`
+	OrgID          string `json:"org_id"`
+	StartedBy      string `json:"started_by"`
+	Documents      int    `json:"documents"`
+	VectorsDropped int64  `json:"vectors_dropped"`
+	// Failed counts the documents whose rebuild failed.
+	Failed     int        `json:"failed"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ReindexProgress is a reindex with how far it got.
+type ReindexProgress struct {
+	Reindex
+	Running        bool    `json:"running"`
+	Done           int     `json:"done"`
+	Remaining      int     `json:"remaining"`
+	Percent        float64 `json:"percent"`
+	ElapsedSeconds int64   `json:"elapsed_seconds"`
+	// ETASeconds extrapolates the rate so far; it is absent until a
+	// document is done and once the reindex has finished.
+	ETASeconds *int64 `json:"eta_seconds,omitempty"`
+}
+
+// StartReindex drops the org's vectors and queues every document with
+// content for a rebuild. startedBy is recorded with the reindex.
+func (s *Service) StartReindex(ctx context.Context, orgID, startedBy string) (*ReindexProgress, error) {
+	ri := &Reindex{ID: uuid.NewString(), OrgID: orgID, StartedBy: startedBy, StartedAt: time.Now()}
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		// A reindex whose last documents were deleted is over.
+		if err := repo.finishReindex(ctx, orgID); err != nil {
+			return err
+		}
+		if err := repo.reindexing(ctx, orgID); err != nil {
+			return err
+		}
+		busy, err := repo.ingestionBusy(ctx, orgID)
+		if err != nil {
+			return err
+		}
+		if busy {
+			return ErrIngestionBusy
+		}
+		if ri.VectorsDropped, err = s.vectorStore.WithTx(tx).PurgeOrg(ctx, orgID); err != nil {
+			return err
+		}
+		if ri.Documents, err = repo.requeueForReindex(ctx, orgID, ri.ID); err != nil {
+			return err
+		}
+		if ri.Documents == 0 {
+			return ErrNothingToReindex
+		}
+		return repo.createReindex(ctx, ri)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notify()
+	return ri.progress(ri.Documents, time.Now()), nil
+}
+
+// LatestReindex returns the org's most recent reindex with its progress.
+func (s *Service) LatestReindex(ctx context.Context, orgID string) (*ReindexProgress, error) {
+	if err := s.repo.finishReindex(ctx, orgID); err != nil {
+		return nil, err
+	}
+	ri, remaining, err := s.repo.latestReindex(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return ri.progress(remaining, time.Now()), nil
+}
+
+func (ri *Reindex) progress(remaining int, now time.Time) *ReindexProgress {
+	p := &ReindexProgress{Reindex: *ri, Running: ri.FinishedAt == nil, Remaining: remaining}
+	end := now
+	if ri.FinishedAt != nil {
+		end, p.Remaining = *ri.FinishedAt, 0
+	}
+	p.Done = ri.Documents - p.Remaining
+	if ri.Documents > 0 {
+		p.Percent = float64(p.Done) * 100 / float64(ri.Documents)
+	}
+	elapsed := end.Sub(ri.StartedAt)
+	p.ElapsedSeconds = int64(elapsed.Seconds())
+	if p.Running && p.Done > 0 {
+		eta := int64(elapsed.Seconds() * float64(p.Remaining) / float64(p.Done))
+		p.ETASeconds = &eta
+	}
+	return p
+}
+
+// reindexing returns ErrReindexing while the org has a reindex with jobs
+// left.
+func (r *Repository) reindexing(ctx context.Context, orgID string) error {
+	var running bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (
+			 SELECT 1 FROM reindexes ri
+			 WHERE ri.org_id = $1 AND ri.finished_at IS NULL
+			   AND EXISTS (SELECT 1 FROM ingest_jobs j WHERE j.reindex_id = ri.id)
+		 )`, orgID,
+	).Scan(&running)
+	if err != nil {
+		return err
+	}
+	if running {
+		return ErrReindexing
+	}
+	return nil
+}
+
+// ingestionBusy reports whether any of the org's documents is queued or
+// being ingested, by the workers or a batch.
+func (r *Repository) ingestionBusy(ctx context.Context, orgID string) (bool, error) {
+	var busy bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM ingest_jobs WHERE org_id = $1)
+		     OR EXISTS (SELECT 1 FROM documents WHERE org_id = $1 AND `+r.read("status")+` <> ALL($2))`,
+		orgID, []string{string(StatusReady), string(StatusFailed), string(StatusUploading)},
+	).Scan(&busy)
+	return busy, err
+}
+
+// requeueForReindex puts the org's ready and failed documents back to
+// pending with a job tagged reindexID each, and returns how many.
+func (r *Repository) requeueForReindex(ctx context.Context, orgID, reindexID string) (int, error) {
+	tag, err := r.db.Exec(ctx,
+		`WITH requeued AS (
+			 UPDATE documents SET `+r.set("status", "$2")+`, `+
+			r.set("chunk_count", "0")+`, `+
+			r.set("updated_at", "NOW()")+`
+			 WHERE org_id = $1 AND `+r.read("status")+` IN ($3, $4)
+			 RETURNING id, org_id
+		 )
+		 INSERT INTO ingest_jobs (document_id, org_id, reindex_id) SELECT id, org_id, $5 FROM requeued`,
+		orgID, StatusPending, StatusReady, StatusFailed, reindexID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *Repository) createReindex(ctx context.Context, ri *Reindex) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO reindexes (id, org_id, started_by, documents, vectors_dropped, started_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		ri.ID, ri.OrgID, ri.StartedBy, ri.Documents, ri.VectorsDropped, ri.StartedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		// Another reindex started at the same time.
+		return ErrReindexing
+	}
+	return err
+}
+
+// latestReindex returns the org's most recent reindex and the number of
+// its jobs left.
+func (r *Repository) latestReindex(ctx context.Context, orgID string) (*Reindex, int, error) {
+	ri := &Reindex{}
+	var remaining int
+	err := r.db.QueryRow(ctx,
+		`SELECT id, org_id, started_by, documents, vectors_dropped, failed, started_at, finished_at,
+			 (SELECT count(*) FROM ingest_jobs j WHERE j.reindex_id = ri.id)
+		 FROM reindexes ri
+		 WHERE org_id = $1
+		 ORDER BY started_at DESC
+		 LIMIT 1`, orgID,
+	).Scan(&ri.ID, &ri.OrgID, &ri.StartedBy, &ri.Documents, &ri.VectorsDropped, &ri.Failed, &ri.StartedAt, &ri.FinishedAt,
+		&remaining)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, ErrNoReindex
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if ri.FinishedAt == nil {
+		// Documents can't change while it runs, so a failed one updated
+		// since the start failed its rebuild.
+		err := r.db.QueryRow(ctx,
+			`SELECT count(*) FROM documents
+			 WHERE org_id = $1 AND `+r.read("status")+` = $2 AND `+r.read("updated_at")+` >= $3`,
+			orgID, StatusFailed, ri.StartedAt,
+		).Scan(&ri.Failed)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return ri, remaining, nil
+}
+
+// finishReindex marks the org's running reindex finished once none of its
+// jobs are left, recording how many documents failed.
+func (r *Repository) finishReindex(ctx context.Context, orgID string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE reindexes ri SET finished_at = NOW(), failed = (
+			 SELECT count(*) FROM documents
+			 WHERE org_id = ri.org_id AND `+r.read("status")+` = $2 AND `+r.read("updated_at")+` >= ri.started_at
+		 )
+		 WHERE ri.org_id = $1 AND ri.finished_at IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM ingest_jobs j WHERE j.reindex_id = ri.id)`,
+		orgID, StatusFailed)
+	return err
+}
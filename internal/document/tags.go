@@ -0,0 +1,66 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tags and metadata
+// Uploads can label a document with tags and free-form key/values. Both
+// are copied onto every chunk, so queries can filter on them (see
+// retrieval.ValidateFilters); listings filter on tags.
+
+const (
+	maxTags         = 50
+	maxTagLength    = 64
+	maxMetadataKeys = 50
+)
+
+// ErrInvalidLabels is returned for tags or metadata an upload can't set.
+var ErrInvalidLabels = errors.New("invalid tags or metadata")
+
+// reservedMetadataKeys are set by ingestion on every chunk (collection_id
+// on collection summary nodes); documents can't set them. Keys starting
+// with "_" are reserved for search results.
+var reservedMetadataKeys = []string{"org_id", "document_id", "doc_name", "level", "chunk_index", "chunk_start", "chunk_end", "tags", "collection_id"}
+
+// normalizeTags trims, lowercases, sorts and de-duplicates tags.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidLabels, maxTags)
+	}
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || len(t) > maxTagLength {
+			return nil, fmt.Errorf("%w: tags must be 1 to %d characters", ErrInvalidLabels, maxTagLength)
+		}
+		out = append(out, t)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+func validateMetadata(md map[string]any) error {
+	if len(md) > maxMetadataKeys {
+		return fmt.Errorf("%w: at most %d metadata keys are allowed", ErrInvalidLabels, maxMetadataKeys)
+	}
+	for k := range md {
+		if k == "" || strings.HasPrefix(k, "_") || slices.Contains(reservedMetadataKeys, k) {
+			return fmt.Errorf("%w: metadata key %q is reserved", ErrInvalidLabels, k)
+		}
+	}
+	return nil
+}
+
+// validateLabels normalizes req's tags and checks its metadata.
+func (req *UploadRequest) validateLabels() error {
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return err
+	}
+	req.Tags = tags
+	return validateMetadata(req.Metadata)
+}
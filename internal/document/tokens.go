@@ -0,0 +1,44 @@
+package document
+
+import (
+	"fmt"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Token-sized chunks
+//
+// Chunk sizes count characters by default, which is only a rough proxy for
+// what embedding and chat models limit: tokens. The same 512 characters are
+// around 130 tokens of English but several times that of Chinese or Thai,
+// or of code dense with symbols. With a tokenizer configured, Size and
+// Overlap count tokens of a tiktoken encoding instead, so a chunk fits the
+// same share of the model's limits whatever its language.
+
+// TokenLength returns a function counting the tokens text encodes to under
+// the named tiktoken encoding (cl100k_base, which OpenAI's embedding and
+// GPT-4 models use, p50k_base or r50k_base), for ChunkingConfig.Length.
+//
+// The encoding's ranks are read from file when it is set, for deployments
+// that can't download them; otherwise they are downloaded once and cached
+// under TIKTOKEN_CACHE_DIR (default: the temp directory).
+func TokenLength(encoding, file string) (func(string) int, error) {
+	if file != "" {
+		tiktoken.SetBpeLoader(fileLoader(file))
+	}
+	tk, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("load tokenizer %q: %w", encoding, err)
+	}
+	return func(text string) int {
+		return len(tk.Encode(text, nil, nil))
+	}, nil
+}
+
+// fileLoader loads an encoding's ranks from a local .tiktoken file instead
+// of its download URL.
+type fileLoader string
+
+func (f fileLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	return tiktoken.NewDefaultBpeLoader().LoadTiktokenBpe(string(f))
+}
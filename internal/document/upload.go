@@ -0,0 +1,230 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/extract"
+	"github.com/pixell07/multi-tenant-ai/internal/objectstore"
+)
+
+// Direct uploads
+//
+// Very large files shouldn't stream through an API replica. Instead the
+// client asks for an upload (StartUpload), which creates the document in
+// StatusUploading and returns a presigned URL to PUT the file straight to
+// object storage. Once the PUT is done it calls CompleteUpload, which
+// checks the object is there and within the size limit and queues the
+// document like any upload, its job pointing at the object. The worker
+// running the job reads the object, extracts its text into the document,
+// deletes the object and carries on ingesting. Uploads never completed are
+// deleted by the stuck-document sweep.
+
+// StatusUploading marks a document waiting for its file to be uploaded to
+// object storage.
+const StatusUploading Status = "uploading"
+
+var (
+	ErrUploadsDisabled = errors.New("direct uploads are not configured")
+	ErrNotUploading    = errors.New("document is not awaiting an upload")
+	ErrUploadMissing   = errors.New("the file has not been uploaded yet")
+	ErrUploadTooLarge  = errors.New("uploaded file is too large")
+)
+
+// UploadTarget tells the client where to send the file.
+type UploadTarget struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxBytes  int64     `json:"max_bytes"`
+}
+
+// UploadDirectTo enables direct uploads to store: presigned URLs last
+// expiry, and files may be up to maxBytes. Workers hold a file in memory
+// while extracting it, so maxBytes bounds their memory use too.
+func (s *Service) UploadDirectTo(store *objectstore.Store, maxBytes int64, expiry time.Duration) {
+	s.uploads, s.uploadMaxBytes, s.uploadExpiry = store, maxBytes, expiry
+}
+
+// uploadKey is where a document's file is uploaded.
+func uploadKey(orgID, docID string) string {
+	return "uploads/" + orgID + "/" + docID
+}
+
+// StartUpload creates a document awaiting its file (req.Content is
+// ignored) and returns where to upload it.
+func (s *Service) StartUpload(ctx context.Context, req UploadRequest) (*Document, *UploadTarget, error) {
+	if s.uploads == nil {
+		return nil, nil, ErrUploadsDisabled
+	}
+	if err := req.validateLabels(); err != nil {
+		return nil, nil, err
+	}
+	req.Content = ""
+	doc := newDocument(req, StatusUploading)
+	if err := s.repo.Create(ctx, doc); err != nil {
+		return nil, nil, err
+	}
+	return doc, &UploadTarget{
+		URL:       s.uploads.PresignPut(uploadKey(doc.OrgID, doc.ID), s.uploadExpiry),
+		Method:    "PUT",
+		ExpiresAt: time.Now().Add(s.uploadExpiry),
+		MaxBytes:  s.uploadMaxBytes,
+	}, nil
+}
+
+// CompleteUpload queues an uploaded document for ingestion once its file
+// is in object storage.
+func (s *Service) CompleteUpload(ctx context.Context, id, orgID string) (*Document, error) {
+	if s.uploads == nil {
+		return nil, ErrUploadsDisabled
+	}
+	doc, err := s.repo.Get(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Status != StatusUploading {
+		return nil, ErrNotUploading
+	}
+	info, err := s.uploads.Stat(ctx, uploadKey(orgID, id))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, ErrUploadMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size > s.uploadMaxBytes {
+		s.deleteUpload(ctx, orgID, id)
+		return nil, ErrUploadTooLarge
+	}
+
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.reindexing(ctx, orgID); err != nil {
+			return err
+		}
+		// Completing twice at once must queue one job.
+		d, err := repo.GetForUpdate(ctx, id, orgID)
+		if err != nil {
+			return err
+		}
+		if d.Status != StatusUploading {
+			return ErrNotUploading
+		}
+		if err := repo.UpdateStatus(ctx, id, StatusPending, 0); err != nil {
+			return err
+		}
+		return repo.enqueueUploadJob(ctx, id, orgID, uploadKey(orgID, id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notify()
+	doc.Status = StatusPending
+	return doc, nil
+}
+
+// loadUpload reads the job's uploaded file into the document's content and
+// the job's text, then deletes the file. A file whose text can't be
+// extracted fails the document for good (errUnextractable).
+func (s *Service) loadUpload(ctx context.Context, job *ingestJob) error {
+	body, info, err := s.uploads.Open(ctx, job.objectKey)
+	if err != nil {
+		return fmt.Errorf("read upload: %w", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, s.uploadMaxBytes+1))
+	if err != nil {
+		return fmt.Errorf("read upload: %w", err)
+	}
+	if int64(len(data)) > s.uploadMaxBytes {
+		return fmt.Errorf("%w: %w", errUnextractable, ErrUploadTooLarge)
+	}
+	text, err := extract.Text(job.doc.Name, info.ContentType, data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUnextractable, err)
+	}
+
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.setContent(ctx, job.docID, text); err != nil {
+			return err
+		}
+		return repo.clearJobUpload(ctx, job.id)
+	})
+	if err != nil {
+		return err
+	}
+	s.deleteUpload(ctx, job.orgID, job.docID)
+	job.text, job.objectKey = text, ""
+	return nil
+}
+
+// errUnextractable marks an uploaded file no retry will make readable.
+var errUnextractable = errors.New("unreadable upload")
+
+func (s *Service) deleteUpload(ctx context.Context, orgID, docID string) {
+	if err := s.uploads.Delete(ctx, uploadKey(orgID, docID)); err != nil {
+		slog.Warn("delete uploaded file failed", "doc_id", docID, "error", err)
+	}
+}
+
+// deleteAbandonedUploads removes documents still awaiting their file long
+// after the upload URL expired, with any partial file.
+func (s *Service) deleteAbandonedUploads(ctx context.Context, grace time.Duration) (int, error) {
+	if s.uploads == nil {
+		return 0, nil
+	}
+	abandoned, err := s.repo.deleteUploading(ctx, s.uploadExpiry+grace)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range abandoned {
+		s.deleteUpload(ctx, d.OrgID, d.ID)
+	}
+	return len(abandoned), nil
+}
+
+// enqueueUploadJob queues the ingest of a document whose content is still
+// the uploaded file at key.
+func (r *Repository) enqueueUploadJob(ctx context.Context, docID, orgID, key string) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO ingest_jobs (document_id, org_id, object_key) VALUES ($1, $2, $3)`,
+		docID, orgID, key)
+	return err
+}
+
+func (r *Repository) clearJobUpload(ctx context.Context, jobID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE ingest_jobs SET object_key = NULL WHERE id = $1`, jobID)
+	return err
+}
+
+// setContent stores the text extracted from an uploaded file.
+func (r *Repository) setContent(ctx context.Context, id, content string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE documents SET `+r.set("content", "$1")+`, `+r.set("updated_at", "$2")+` WHERE id = $3`,
+		content, time.Now(), id)
+	return err
+}
+
+// deleteUploading deletes documents left in StatusUploading for longer
+// than olderThan, returning them.
+func (r *Repository) deleteUploading(ctx context.Context, olderThan time.Duration) ([]Document, error) {
+	rows, err := r.db.Query(ctx,
+		`DELETE FROM documents WHERE `+r.read("status")+` = $1 AND `+r.read("updated_at")+` < NOW() - $2::interval
+		 RETURNING id, org_id`,
+		StatusUploading, olderThan.String())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Document, error) {
+		var d Document
+		err := row.Scan(&d.ID, &d.OrgID)
+		return d, err
+	})
+}
@@ -0,0 +1,48 @@
+package document
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Waiting for ingestion
+//
+// Writes return as soon as the document is queued. A script that uploads
+// and then queries can instead wait for the document to settle. The job may
+// run on any replica, so waiting polls the document's row, quickly at first
+// since small documents are ingested in well under a second.
+
+const (
+	waitFirstPoll = 100 * time.Millisecond
+	waitMaxPoll   = time.Second
+)
+
+// ErrIngestionFailed is returned when the awaited document failed
+// ingestion.
+var ErrIngestionFailed = errors.New("document ingestion failed")
+
+// WaitReady blocks until the document is ready or failed, or ctx ends. It
+// returns the document as last read, with ErrIngestionFailed when it
+// failed and ctx's error when ctx ended first.
+func (s *Service) WaitReady(ctx context.Context, id, orgID string) (*Document, error) {
+	poll := waitFirstPoll
+	for {
+		doc, err := s.repo.Get(ctx, id, orgID)
+		if err != nil {
+			return nil, err
+		}
+		switch doc.Status {
+		case StatusReady:
+			return doc, nil
+		case StatusFailed:
+			return doc, ErrIngestionFailed
+		}
+		select {
+		case <-ctx.Done():
+			return doc, ctx.Err()
+		case <-time.After(poll):
+		}
+		poll = min(poll*2, waitMaxPoll)
+	}
+}
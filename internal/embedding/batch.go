@@ -0,0 +1,284 @@
+package embedding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Batch embeddings
+// OpenAI's Batch API runs embedding requests asynchronously at half the
+// price of the embeddings endpoint, finishing within a 24h window. Requests
+// go up as a JSONL file, the batch is polled until it reaches a terminal
+// status, and the results come back as another JSONL file keyed by each
+// request's custom_id.
+
+// Batch statuses as reported by the API.
+const (
+	BatchValidating = "validating"
+	BatchInProgress = "in_progress"
+	BatchFinalizing = "finalizing"
+	BatchCompleted  = "completed"
+	BatchFailed     = "failed"
+	BatchExpired    = "expired"
+	BatchCancelling = "cancelling"
+	BatchCancelled  = "cancelled"
+)
+
+// batchTimeout bounds one Batch API call; uploads and result downloads
+// can be large.
+const batchTimeout = 5 * time.Minute
+
+// UsageFunc receives the tokens a batch's results were billed for, with
+// the ctx passed to Results.
+type UsageFunc func(ctx context.Context, tokens int64)
+
+// BatchClient submits embedding batches to an OpenAI-compatible Batch API.
+type BatchClient struct {
+	apiKey     string
+	baseURL    string
+	model      string
+	dimensions int
+	onUsage    UsageFunc
+	http       *http.Client
+	// models picks the model of the org a batch is for; nil uses model.
+	models *Router
+}
+
+// NewBatchClient creates a client for the Batch API at baseURL, embedding
+// with model at dimensions (0 keeps the model's own). onUsage, if not nil,
+// is told the billed tokens of every batch whose results are read.
+func NewBatchClient(apiKey, baseURL, model string, dimensions int, onUsage UsageFunc) *BatchClient {
+	return &BatchClient{
+		apiKey:     apiKey,
+		baseURL:    baseURL,
+		model:      model,
+		dimensions: dimensions,
+		onUsage:    onUsage,
+		http:       &http.Client{Timeout: batchTimeout},
+	}
+}
+
+// ModelsFrom embeds each org's batches with the model r picks for it.
+func (c *BatchClient) ModelsFrom(r *Router) {
+	c.models = r
+}
+
+// SendThrough carries the client's calls over rt, such as one going
+// through a proxy.
+func (c *BatchClient) SendThrough(rt http.RoundTripper) {
+	c.http.Transport = rt
+}
+
+// BatchRequest is one embeddings request in a batch; ID must be unique
+// within the batch.
+type BatchRequest struct {
+	ID     string
+	Inputs []string
+}
+
+// Batch is the state of a submitted batch.
+type Batch struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	OutputFileID string `json:"output_file_id"`
+	ErrorFileID  string `json:"error_file_id"`
+}
+
+// Done reports whether the batch reached a terminal status.
+func (b *Batch) Done() bool {
+	switch b.Status {
+	case BatchCompleted, BatchFailed, BatchExpired, BatchCancelled:
+		return true
+	}
+	return false
+}
+
+// Submit uploads reqs as a batch input file and starts the batch, for the
+// org in ctx.
+func (c *BatchClient) Submit(ctx context.Context, reqs []BatchRequest) (*Batch, error) {
+	model := c.model
+	if c.models != nil {
+		var err error
+		if model, err = c.models.ModelFor(ctx, tenancy.OrgFrom(ctx)); err != nil {
+			return nil, err
+		}
+	}
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for _, r := range reqs {
+		body := map[string]any{"model": model, "input": r.Inputs}
+		if c.dimensions > 0 && SupportsDimensions(model) {
+			body["dimensions"] = c.dimensions
+		}
+		if err := enc.Encode(map[string]any{
+			"custom_id": r.ID,
+			"method":    http.MethodPost,
+			"url":       "/v1/embeddings",
+			"body":      body,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("purpose", "batch")
+	part, err := mw.CreateFormFile("file", "embeddings.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(input.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/files", mw.FormDataContentType(), &form, &file); err != nil {
+		return nil, fmt.Errorf("upload batch input: %w", err)
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"input_file_id":     file.ID,
+		"endpoint":          "/v1/embeddings",
+		"completion_window": "24h",
+	})
+	var b Batch
+	if err := c.do(ctx, http.MethodPost, "/batches", "application/json", bytes.NewReader(body), &b); err != nil {
+		return nil, fmt.Errorf("create batch: %w", err)
+	}
+	return &b, nil
+}
+
+// Get returns the current state of a batch.
+func (c *BatchClient) Get(ctx context.Context, id string) (*Batch, error) {
+	var b Batch
+	if err := c.do(ctx, http.MethodGet, "/batches/"+id, "", nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Results downloads a completed batch's output and returns each successful
+// request's vectors by request ID, fitted to the configured dimensions.
+// Failed requests are missing from the map.
+func (c *BatchClient) Results(ctx context.Context, b *Batch) (map[string][][]float32, error) {
+	results := map[string][][]float32{}
+	if b.OutputFileID == "" {
+		return results, nil // every request failed
+	}
+
+	req, err := c.request(ctx, http.MethodGet, "/files/"+b.OutputFileID+"/content", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var tokens int64
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 64<<20) // one line holds every vector of a request
+	for sc.Scan() {
+		var line struct {
+			CustomID string `json:"custom_id"`
+			Response struct {
+				StatusCode int `json:"status_code"`
+				Body       struct {
+					Data []struct {
+						Index     int       `json:"index"`
+						Embedding []float32 `json:"embedding"`
+					} `json:"data"`
+					Usage struct {
+						PromptTokens int64 `json:"prompt_tokens"`
+					} `json:"usage"`
+				} `json:"body"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("parse batch output: %w", err)
+		}
+		if line.Response.StatusCode != http.StatusOK {
+			continue
+		}
+		tokens += line.Response.Body.Usage.PromptTokens
+		vecs := make([][]float32, len(line.Response.Body.Data))
+		for _, d := range line.Response.Body.Data {
+			if d.Index < 0 || d.Index >= len(vecs) {
+				return nil, fmt.Errorf("parse batch output: embedding index %d out of range", d.Index)
+			}
+			v, err := fit(d.Embedding, c.dimensions)
+			if err != nil {
+				return nil, err
+			}
+			vecs[d.Index] = v
+		}
+		results[line.CustomID] = vecs
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read batch output: %w", err)
+	}
+	if c.onUsage != nil && tokens > 0 {
+		c.onUsage(ctx, tokens)
+	}
+	return results, nil
+}
+
+func (c *BatchClient) request(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+// do sends a request and decodes its JSON response into out.
+func (c *BatchClient) do(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	req, err := c.request(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError describes a non-200 response, including the start of the
+// body where the API puts the reason.
+func statusError(resp *http.Response) error {
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(snippet)); msg != "" {
+		return fmt.Errorf("batch api returned status %d: %s", resp.StatusCode, msg)
+	}
+	return fmt.Errorf("batch api returned status %d", resp.StatusCode)
+}
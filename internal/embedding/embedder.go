@@ -4,6 +4,12 @@ package embedding
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/tmc/langchaingo/embeddings"
 	lcopenai "github.com/tmc/langchaingo/llms/openai"
@@ -15,20 +21,60 @@ type Embedder interface {
 	EmbedQuery(ctx context.Context, text string) ([]float32, error)
 }
 
+// Embedding providers (EMBEDDING_PROVIDER). ProviderOpenAI covers any
+// OpenAI-compatible server.
+const (
+	ProviderOpenAI = "openai"
+	ProviderFake   = "fake"
+)
+
 // LangChainEmbedder wraps langchaingo's embeddings.EmbedderImpl.
 type LangChainEmbedder struct {
-	inner *embeddings.EmbedderImpl
+	inner      *embeddings.EmbedderImpl
+	dimensions int
 }
 
-// NewOpenAIEmbedder creates a new embedder backed by OpenAI's
-// text-embedding-3-small model via langchaingo.
-func NewOpenAIEmbedder(apiKey string) (*LangChainEmbedder, error) {
+// DefaultModel is OpenAI's text-embedding-3-small (1536 dimensions).
+const DefaultModel = "text-embedding-3-small"
+
+// ErrDimensionMismatch is returned when the model produces vectors shorter
+// than the configured dimensions; they can't be padded meaningfully.
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// SupportsDimensions reports whether model accepts the embeddings API's
+// dimensions parameter and shortens its vectors server-side.
+func SupportsDimensions(model string) bool {
+	return strings.HasPrefix(model, "text-embedding-3")
+}
+
+// NewOpenAIEmbedder creates a new embedder for an OpenAI-compatible
+// embeddings API via langchaingo. baseURL points it at a local server
+// (Ollama, vLLM, TEI) instead of OpenAI; the API key may then be empty.
+//
+// dimensions is the vector size to produce (0 keeps the model's own).
+// text-embedding-3 models are asked for it directly; longer vectors from
+// other models are truncated and re-normalized client-side (Matryoshka
+// truncation, meaningful for models trained for it such as nomic-embed).
+// A nil client uses langchaingo's default.
+func NewOpenAIEmbedder(apiKey, baseURL, model string, dimensions int, client *http.Client) (*LangChainEmbedder, error) {
+	// langchaingo refuses an empty token even for servers that ignore it.
+	if apiKey == "" {
+		apiKey = "unused"
+	}
 	// langchaingo's openai.New() reads OPENAI_API_KEY automatically;
 	// WithToken lets us pass it explicitly so callers don't have to set env vars.
-	llm, err := lcopenai.New(
+	opts := []lcopenai.Option{
 		lcopenai.WithToken(apiKey),
-		lcopenai.WithEmbeddingModel("text-embedding-3-small"),
-	)
+		lcopenai.WithBaseURL(baseURL),
+		lcopenai.WithEmbeddingModel(model),
+	}
+	if dimensions > 0 && SupportsDimensions(model) {
+		opts = append(opts, lcopenai.WithEmbeddingDimensions(dimensions))
+	}
+	if client != nil {
+		opts = append(opts, lcopenai.WithHTTPClient(client))
+	}
+	llm, err := lcopenai.New(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -38,15 +84,59 @@ func NewOpenAIEmbedder(apiKey string) (*LangChainEmbedder, error) {
 		return nil, err
 	}
 
-	return &LangChainEmbedder{inner: embedder}, nil
+	return &LangChainEmbedder{inner: embedder, dimensions: dimensions}, nil
 }
 
 // EmbedDocuments embeds a batch of texts.
 func (e *LangChainEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
-	return e.inner.EmbedDocuments(ctx, texts)
+	vecs, err := e.inner.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vecs {
+		if vecs[i], err = fit(vecs[i], e.dimensions); err != nil {
+			return nil, err
+		}
+	}
+	return vecs, nil
 }
 
 // EmbedQuery embeds a single query string.
 func (e *LangChainEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
-	return e.inner.EmbedQuery(ctx, text)
+	vec, err := e.inner.EmbedQuery(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return fit(vec, e.dimensions)
+}
+
+// EstimateTokens approximates how many tokens text counts for with
+// OpenAI's tokenizers (about four characters per token in English). Used
+// for metering where the API's own count isn't available.
+func EstimateTokens(text string) int64 {
+	return int64((utf8.RuneCountInString(text) + 3) / 4)
+}
+
+// fit brings v to the configured dimensions: longer vectors keep their
+// leading components and are scaled back to unit length, so cosine
+// distances stay comparable.
+func fit(v []float32, dimensions int) ([]float32, error) {
+	switch {
+	case dimensions <= 0 || len(v) == dimensions:
+		return v, nil
+	case len(v) < dimensions:
+		return nil, fmt.Errorf("%w: model returned %d dimensions, %d configured", ErrDimensionMismatch, len(v), dimensions)
+	}
+
+	v = v[:dimensions]
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if norm := math.Sqrt(sum); norm > 0 {
+		for i := range v {
+			v[i] = float32(float64(v[i]) / norm)
+		}
+	}
+	return v, nil
 }
@@ -0,0 +1,75 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// FakeEmbedder embeds text without a model, for integration tests and
+// demos that must run without network access or API keys. Each lowercased
+// word is hashed to a dimension and a sign and the sum is scaled to unit
+// length (feature hashing), so the same text always gets the same vector
+// and texts sharing words come out similar, which is enough to exercise
+// retrieval end to end.
+type FakeEmbedder struct {
+	dimensions int
+}
+
+// fakeDimensions is the vector size when none is configured.
+const fakeDimensions = 1536
+
+// NewFakeEmbedder creates a fake embedder producing vectors of dimensions
+// (0 keeps the default of 1536).
+func NewFakeEmbedder(dimensions int) *FakeEmbedder {
+	if dimensions <= 0 {
+		dimensions = fakeDimensions
+	}
+	return &FakeEmbedder{dimensions: dimensions}
+}
+
+func (e *FakeEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i, t := range texts {
+		vecs[i] = e.embed(t)
+	}
+	return vecs, ctx.Err()
+}
+
+func (e *FakeEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return e.embed(text), ctx.Err()
+}
+
+func (e *FakeEmbedder) embed(text string) []float32 {
+	v := make([]float32, e.dimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		sum := h.Sum64()
+		sign := float32(1)
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		v[sum%uint64(e.dimensions)] += sign
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		// Text without words still needs a valid direction.
+		v[0] = 1
+		return v
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v
+}
@@ -0,0 +1,357 @@
+package embedding
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Providers besides OpenAI
+//
+// Cohere and Voyage AI are hosted embeddings APIs with their own protocols;
+// text-embeddings-inference (TEI) and Ollama serve open models locally.
+// Hosted models are told whether they embed documents or queries, which
+// their retrieval quality depends on. Each provider defaults to a model
+// and its vector size; EMBEDDING_DIMENSIONS shortens the vectors (server
+// side where the model supports it), and for local servers, whose model
+// the operator picks, it is learned from the first vector when unset (see
+// Probe).
+
+// Provider names, with ProviderOpenAI and ProviderFake.
+const (
+	ProviderCohere = "cohere"
+	ProviderVoyage = "voyage"
+	// ProviderTEI is a model served by text-embeddings-inference (or
+	// anything speaking its /embed API); it has no default URL, and the
+	// model is whatever the server was started with.
+	ProviderTEI    = "tei"
+	ProviderOllama = "ollama"
+)
+
+// Config configures an APIEmbedder. Empty BaseURL and Model take the
+// provider's defaults.
+type Config struct {
+	APIKey  string
+	Model   string
+	BaseURL string
+	// Dimensions is the vector size to produce (0 keeps the model's own).
+	Dimensions int
+	// HTTPClient defaults to one with a 60s timeout.
+	HTTPClient *http.Client
+}
+
+type inputType int
+
+const (
+	inputDocument inputType = iota
+	inputQuery
+)
+
+type provider struct {
+	defaultBaseURL    string
+	defaultModel      string
+	defaultDimensions int // 0 when the model isn't known in advance
+	keyOptional       bool
+	path              string
+	// maxTexts caps the texts sent per request.
+	maxTexts int
+	// request builds the body embedding texts as input; dimensions is 0
+	// unless the model shortens its vectors itself. decode reads the
+	// vectors from the response, in text order.
+	request func(model string, texts []string, input inputType, dimensions int) any
+	decode  func(body []byte) ([][]float32, error)
+	// shortens reports whether model returns vectors of the given size
+	// when asked.
+	shortens func(model string, dimensions int) bool
+}
+
+var providers = map[string]provider{
+	ProviderCohere: {
+		defaultBaseURL:    "https://api.cohere.com",
+		defaultModel:      "embed-v4.0",
+		defaultDimensions: 1536,
+		path:              "/v2/embed",
+		maxTexts:          96,
+		request: func(model string, texts []string, input inputType, dimensions int) any {
+			body := map[string]any{
+				"model":           model,
+				"texts":           texts,
+				"input_type":      map[inputType]string{inputDocument: "search_document", inputQuery: "search_query"}[input],
+				"embedding_types": []string{"float"},
+			}
+			if dimensions > 0 {
+				body["output_dimension"] = dimensions
+			}
+			return body
+		},
+		decode: func(body []byte) ([][]float32, error) {
+			var resp struct {
+				Embeddings struct {
+					Float [][]float32 `json:"float"`
+				} `json:"embeddings"`
+			}
+			err := json.Unmarshal(body, &resp)
+			return resp.Embeddings.Float, err
+		},
+		shortens: func(model string, dimensions int) bool {
+			return strings.HasPrefix(model, "embed-v4") && slices.Contains([]int{256, 512, 1024, 1536}, dimensions)
+		},
+	},
+	ProviderVoyage: {
+		defaultBaseURL:    "https://api.voyageai.com",
+		defaultModel:      "voyage-3.5",
+		defaultDimensions: 1024,
+		path:              "/v1/embeddings",
+		maxTexts:          128,
+		request: func(model string, texts []string, input inputType, dimensions int) any {
+			body := map[string]any{
+				"model":      model,
+				"input":      texts,
+				"input_type": map[inputType]string{inputDocument: "document", inputQuery: "query"}[input],
+			}
+			if dimensions > 0 {
+				body["output_dimension"] = dimensions
+			}
+			return body
+		},
+		decode: func(body []byte) ([][]float32, error) {
+			var resp struct {
+				Data []struct {
+					Index     int       `json:"index"`
+					Embedding []float32 `json:"embedding"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				return nil, err
+			}
+			vecs := make([][]float32, len(resp.Data))
+			for _, d := range resp.Data {
+				if d.Index < 0 || d.Index >= len(vecs) {
+					return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+				}
+				vecs[d.Index] = d.Embedding
+			}
+			return vecs, nil
+		},
+		shortens: func(model string, dimensions int) bool {
+			flexible := strings.HasPrefix(model, "voyage-3.5") || strings.HasPrefix(model, "voyage-3-large") || strings.HasPrefix(model, "voyage-code-3")
+			return flexible && slices.Contains([]int{256, 512, 1024, 2048}, dimensions)
+		},
+	},
+	ProviderTEI: {
+		keyOptional: true,
+		path:        "/embed",
+		maxTexts:    32, // TEI's default --max-client-batch-size
+		request: func(_ string, texts []string, _ inputType, _ int) any {
+			return map[string]any{"inputs": texts, "truncate": true}
+		},
+		decode: func(body []byte) ([][]float32, error) {
+			var vecs [][]float32
+			err := json.Unmarshal(body, &vecs)
+			return vecs, err
+		},
+	},
+	ProviderOllama: {
+		defaultBaseURL: "http://localhost:11434",
+		defaultModel:   "nomic-embed-text",
+		keyOptional:    true,
+		path:           "/api/embed",
+		maxTexts:       64,
+		request: func(model string, texts []string, _ inputType, _ int) any {
+			return map[string]any{"model": model, "input": texts, "truncate": true}
+		},
+		decode: func(body []byte) ([][]float32, error) {
+			var resp struct {
+				Embeddings [][]float32 `json:"embeddings"`
+			}
+			err := json.Unmarshal(body, &resp)
+			return resp.Embeddings, err
+		},
+	},
+}
+
+// Providers lists every embedding provider name, sorted.
+func Providers() []string {
+	names := []string{ProviderOpenAI, ProviderFake}
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// DefaultBaseURL returns a provider's endpoint, empty for OpenAI (which
+// follows the chat provider), the fake one, unknown providers and ones
+// deployed locally without a conventional address.
+func DefaultBaseURL(name string) string {
+	return providers[name].defaultBaseURL
+}
+
+// DefaultModelOf returns a provider's default model, empty for TEI, whose
+// model is chosen when the server starts.
+func DefaultModelOf(name string) string {
+	switch name {
+	case ProviderOpenAI, ProviderFake:
+		return DefaultModel
+	}
+	return providers[name].defaultModel
+}
+
+// DefaultDimensions returns the vector size of a provider's default
+// model, 0 when it depends on the model a local server runs.
+func DefaultDimensions(name string) int {
+	switch name {
+	case ProviderOpenAI, ProviderFake:
+		return 1536
+	}
+	return providers[name].defaultDimensions
+}
+
+// APIEmbedder embeds through one of the providers with their own
+// protocols.
+type APIEmbedder struct {
+	p   provider
+	cfg Config
+	// shortened is whether the model is asked for cfg.Dimensions; other
+	// vectors are fitted client-side.
+	shortened bool
+}
+
+// New creates an embedder for the named provider (not ProviderOpenAI or
+// ProviderFake), filling in its defaults.
+func New(name string, cfg Config) (*APIEmbedder, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = p.defaultBaseURL
+	}
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("embedding provider %q needs a base URL", name)
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Model == "" {
+		cfg.Model = p.defaultModel
+	}
+	if cfg.APIKey == "" && !p.keyOptional {
+		return nil, fmt.Errorf("embedding provider %q needs an API key", name)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	shortened := cfg.Dimensions > 0 && p.shortens != nil && p.shortens(cfg.Model, cfg.Dimensions)
+	return &APIEmbedder{p: p, cfg: cfg, shortened: shortened}, nil
+}
+
+// NewProvider creates an embedder for any provider in Providers: the
+// fake one, OpenAI (or an OpenAI-compatible server at cfg.BaseURL) or one
+// with its own API.
+func NewProvider(name string, cfg Config) (Embedder, error) {
+	switch name {
+	case ProviderFake:
+		return NewFakeEmbedder(cfg.Dimensions), nil
+	case ProviderOpenAI:
+		e, err := NewOpenAIEmbedder(cfg.APIKey, cfg.BaseURL, cmp.Or(cfg.Model, DefaultModel), cfg.Dimensions, cfg.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	e, err := New(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// EmbedDocuments embeds texts as documents to search, in as many requests
+// as the provider needs.
+func (e *APIEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, 0, len(texts))
+	for batch := range slices.Chunk(texts, e.p.maxTexts) {
+		got, err := e.embed(ctx, batch, inputDocument)
+		if err != nil {
+			return nil, err
+		}
+		vecs = append(vecs, got...)
+	}
+	return vecs, nil
+}
+
+// EmbedQuery embeds a search query.
+func (e *APIEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := e.embed(ctx, []string{text}, inputQuery)
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+func (e *APIEmbedder) embed(ctx context.Context, texts []string, input inputType) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	var dimensions int
+	if e.shortened {
+		dimensions = e.cfg.Dimensions
+	}
+	body, _ := json.Marshal(e.p.request(e.cfg.Model, texts, input, dimensions))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.BaseURL+e.p.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if e.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data[:min(len(data), 512)]))
+		return nil, fmt.Errorf("embeddings provider returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	vecs, err := e.p.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode embeddings response: %w", err)
+	}
+	if len(vecs) != len(texts) {
+		return nil, fmt.Errorf("embeddings provider returned %d vectors for %d texts", len(vecs), len(texts))
+	}
+	for i := range vecs {
+		if len(vecs[i]) == 0 {
+			return nil, errors.New("embeddings provider returned an empty vector")
+		}
+		if vecs[i], err = fit(vecs[i], e.cfg.Dimensions); err != nil {
+			return nil, err
+		}
+	}
+	return vecs, nil
+}
+
+// Probe embeds a short text with e and returns the size of its vectors,
+// for models whose size isn't known until they run.
+func Probe(ctx context.Context, e Embedder) (int, error) {
+	vec, err := e.EmbedQuery(ctx, "dimension probe")
+	if err != nil {
+		return 0, fmt.Errorf("probe embedding dimensions: %w", err)
+	}
+	return len(vec), nil
+}
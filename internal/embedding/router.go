@@ -0,0 +1,121 @@
+package embedding
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Per-org models
+//
+// The operator can offer embedding models besides the default
+// (EMBEDDING_MODELS). An org that chose one has everything it embeds,
+// documents and questions alike, go through it. The models share the
+// provider and the configured dimensions, so their vectors fit the same
+// tables, but an org's vectors are only comparable while they all come
+// from one model: switching means reindexing.
+
+// ModelSource returns the embedding model an org chose, "" for the
+// default.
+type ModelSource interface {
+	EmbeddingModel(ctx context.Context, orgID string) (string, error)
+}
+
+// Model is one embedding model an org can choose.
+type Model struct {
+	ID      string `json:"id"`
+	Default bool   `json:"default"`
+}
+
+// Router embeds the text of the org in ctx with the model it chose.
+type Router struct {
+	defaultModel string
+	embedders    map[string]Embedder
+	models       []Model
+	source       ModelSource // nil embeds everything with the default
+}
+
+// NewRouter creates a router embedding with def, which embeds with
+// defaultModel, until models are added and chosen.
+func NewRouter(defaultModel string, def Embedder) *Router {
+	return &Router{
+		defaultModel: defaultModel,
+		embedders:    map[string]Embedder{defaultModel: def},
+		models:       []Model{{ID: defaultModel, Default: true}},
+	}
+}
+
+// Add offers model, embedded with e.
+func (r *Router) Add(model string, e Embedder) {
+	if _, dup := r.embedders[model]; dup {
+		return
+	}
+	r.embedders[model] = e
+	r.models = append(r.models, Model{ID: model})
+}
+
+// ChooseWith embeds each org's text with the model src names for it.
+func (r *Router) ChooseWith(src ModelSource) {
+	r.source = src
+}
+
+// Models lists the models offered, the default first.
+func (r *Router) Models() []Model {
+	return r.models
+}
+
+// Offers reports whether model can be chosen.
+func (r *Router) Offers(model string) bool {
+	_, ok := r.embedders[model]
+	return ok
+}
+
+// Resolve returns the model a choice of model embeds with: itself when it
+// is offered, else the default.
+func (r *Router) Resolve(model string) string {
+	if r.Offers(model) {
+		return model
+	}
+	return r.defaultModel
+}
+
+// ModelFor returns the model orgID's text is embedded with. A choice the
+// operator has stopped offering falls back to the default.
+func (r *Router) ModelFor(ctx context.Context, orgID string) (string, error) {
+	if r.source == nil || orgID == "" {
+		return r.defaultModel, nil
+	}
+	model, err := r.source.EmbeddingModel(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	if model != "" && !r.Offers(model) {
+		slog.Warn("embedding model no longer offered, using the default", "org_id", orgID, "model", model)
+	}
+	return r.Resolve(model), nil
+}
+
+func (r *Router) embedder(ctx context.Context) (Embedder, error) {
+	model, err := r.ModelFor(ctx, tenancy.OrgFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return r.embedders[model], nil
+}
+
+func (r *Router) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	e, err := r.embedder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.EmbedDocuments(ctx, texts)
+}
+
+func (r *Router) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	e, err := r.embedder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.EmbedQuery(ctx, text)
+}
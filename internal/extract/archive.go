@@ -0,0 +1,143 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Archive expansion
+//
+// ZIP and TAR (plain or gzipped) uploads are expanded into their files,
+// each becoming its own document. An archive is a small upload that can
+// unpack into something huge, so expansion stops at MaxArchiveFiles files
+// or MaxArchiveBytes of uncompressed content, whichever comes first.
+
+const (
+	MaxArchiveFiles = 1000
+	MaxArchiveBytes = 256 << 20
+)
+
+var ErrArchiveTooLarge = fmt.Errorf("archive expands to more than %d files or %d MB", MaxArchiveFiles, MaxArchiveBytes>>20)
+
+// ArchiveFile is one file of an archive, Path relative to its root.
+type ArchiveFile struct {
+	Path string
+	Data []byte
+}
+
+// IsArchive reports whether filename names a ZIP or TAR archive.
+func IsArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Archive returns the regular files of a ZIP or TAR archive, in archive
+// order. Directories, links and operating system clutter (__MACOSX/,
+// .DS_Store, other dotfiles) are left out.
+func Archive(filename string, data []byte) ([]ArchiveFile, error) {
+	x := &expansion{}
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return x.zip(data)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		return x.tar(gz)
+	case strings.HasSuffix(name, ".tar"):
+		return x.tar(bytes.NewReader(data))
+	}
+	return nil, ErrUnsupported
+}
+
+// expansion tracks what an archive has unpacked to so far.
+type expansion struct {
+	files []ArchiveFile
+	bytes int64
+}
+
+func (x *expansion) zip(data []byte) ([]ArchiveFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		err = x.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return x.files, nil
+}
+
+func (x *expansion) tar(r io.Reader) ([]ArchiveFile, error) {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return x.files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := x.add(h.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// add reads one file unless it is clutter, enforcing the limits on the
+// uncompressed size rather than trusting the archive's headers.
+func (x *expansion) add(name string, r io.Reader) error {
+	p := path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))[1:]
+	if p == "" || hidden(p) {
+		return nil
+	}
+	if len(x.files) == MaxArchiveFiles {
+		return ErrArchiveTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxArchiveBytes-x.bytes+1))
+	if err != nil {
+		return fmt.Errorf("read %s: %w", p, err)
+	}
+	if x.bytes += int64(len(data)); x.bytes > MaxArchiveBytes {
+		return ErrArchiveTooLarge
+	}
+	x.files = append(x.files, ArchiveFile{Path: p, Data: data})
+	return nil
+}
+
+// hidden reports whether any element of p is a dotfile or macOS metadata.
+func hidden(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDOCXPartBytes caps the decompressed size of word/document.xml so a zip
+// bomb can't exhaust memory.
+const maxDOCXPartBytes = 64 << 20
+
+// DOCX extracts the body text of a Word document, one paragraph per line.
+// Tables come out row by row with cells separated by tabs.
+func DOCX(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("docx: %w", err)
+	}
+
+	var part *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			part = f
+			break
+		}
+	}
+	if part == nil {
+		return "", errors.New("docx: missing word/document.xml")
+	}
+
+	rc, err := part.Open()
+	if err != nil {
+		return "", fmt.Errorf("docx: %w", err)
+	}
+	defer rc.Close()
+
+	// Walk the WordprocessingML tokens rather than unmarshalling: only runs
+	// of text (w:t), tabs, breaks and paragraph/cell ends matter.
+	dec := xml.NewDecoder(io.LimitReader(rc, maxDOCXPartBytes))
+	var (
+		sb     strings.Builder
+		inText bool
+		inCell int // table cell nesting; paragraphs inside cells stay on the row
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("docx: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tc":
+				inCell++
+			case "tab":
+				sb.WriteByte('\t')
+			case "br", "cr":
+				sb.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if inCell > 0 {
+					sb.WriteByte(' ')
+				} else {
+					sb.WriteByte('\n')
+				}
+			case "tc":
+				inCell--
+				sb.WriteByte('\t')
+			case "tr":
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
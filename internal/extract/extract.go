@@ -0,0 +1,138 @@
+// Package extract converts uploaded files (PDF, DOCX, HTML, Markdown, plain
+// text) into plain text for chunking. Extraction keeps paragraph breaks so
+// the text splitter still finds natural boundaries.
+package extract
+
+import (
+	"errors"
+	"html"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file type: expected PDF, DOCX, HTML, Markdown or plain text")
+	ErrNoText      = errors.New("no extractable text in file")
+)
+
+type Format string
+
+const (
+	FormatPDF      Format = "pdf"
+	FormatDOCX     Format = "docx"
+	FormatHTML     Format = "html"
+	FormatMarkdown Format = "markdown"
+	FormatText     Format = "text"
+)
+
+var formatByExt = map[string]Format{
+	".pdf":      FormatPDF,
+	".docx":     FormatDOCX,
+	".html":     FormatHTML,
+	".htm":      FormatHTML,
+	".md":       FormatMarkdown,
+	".markdown": FormatMarkdown,
+	".txt":      FormatText,
+}
+
+var formatByMIME = map[string]Format{
+	"application/pdf": FormatPDF,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": FormatDOCX,
+	"text/html":     FormatHTML,
+	"text/markdown": FormatMarkdown,
+	"text/plain":    FormatText,
+}
+
+// Detect picks a file's format from its name, falling back to its content
+// type. Other text/* types (source code, CSV...) are treated as plain text.
+func Detect(filename, contentType string) (Format, error) {
+	if f, ok := formatByExt[strings.ToLower(path.Ext(filename))]; ok {
+		return f, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if f, ok := formatByMIME[mediaType]; ok {
+		return f, nil
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return FormatText, nil
+	}
+	return "", ErrUnsupported
+}
+
+// Text extracts the plain text of a file. Plain-text and source files must
+// be valid UTF-8.
+func Text(filename, contentType string, data []byte) (string, error) {
+	format, err := Detect(filename, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	switch format {
+	case FormatPDF:
+		text, err = PDF(data)
+	case FormatDOCX:
+		text, err = DOCX(data)
+	case FormatHTML:
+		text = HTML(string(data))
+	case FormatMarkdown:
+		text = Markdown(string(data))
+	case FormatText:
+		if !utf8.Valid(data) {
+			return "", ErrUnsupported
+		}
+		text = string(data)
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
+
+var (
+	invisibleRe = regexp.MustCompile(`(?is)<(script|style|head|noscript)[^>]*>.*?</(script|style|head|noscript)>`)
+	blockTagRe  = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/h[1-6]|/tr|/section|/article|/blockquote|/pre)[^>]*>`)
+	tagRe       = regexp.MustCompile(`<[^>]*>`)
+	blankRe     = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTML flattens markup into plain text, dropping scripts and styles and
+// keeping line breaks at block boundaries.
+func HTML(s string) string {
+	s = invisibleRe.ReplaceAllString(s, "")
+	s = blockTagRe.ReplaceAllString(s, "\n")
+	s = tagRe.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(blankRe.ReplaceAllString(s, "\n\n"))
+}
+
+var (
+	mdImageRe    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdHeadingRe  = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)
+	mdEmphasisRe = regexp.MustCompile(`(\*\*|~~)(\S(?:.*?\S)?)(\*\*|~~)`)
+	mdFenceRe    = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	mdRuleRe     = regexp.MustCompile(`(?m)^\s{0,3}([-*_]\s*){3,}$`)
+	mdQuoteRe    = regexp.MustCompile(`(?m)^\s{0,3}>\s?`)
+)
+
+// Markdown strips formatting syntax while keeping the words, link targets
+// and code. Headings stay on their own lines so sections remain visible to
+// the splitter. Inline HTML is left alone: tag stripping would also eat
+// generics like List<String> in code samples.
+func Markdown(s string) string {
+	s = mdFenceRe.ReplaceAllString(s, "")
+	s = mdImageRe.ReplaceAllString(s, "$1")
+	s = mdLinkRe.ReplaceAllString(s, "$1 ($2)")
+	s = mdHeadingRe.ReplaceAllString(s, "")
+	s = mdEmphasisRe.ReplaceAllString(s, "$2")
+	s = mdRuleRe.ReplaceAllString(s, "")
+	s = mdQuoteRe.ReplaceAllString(s, "")
+	return strings.TrimSpace(blankRe.ReplaceAllString(s, "\n\n"))
+}
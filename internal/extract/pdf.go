@@ -0,0 +1,353 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// PDF text extraction
+//
+// This is a deliberately small extractor: it decodes the page content
+// streams (uncompressed or FlateDecode) and collects the strings drawn by
+// the text operators (Tj, TJ, ', "), starting a new line on text moves.
+// It reads PDFs with standard (Latin) font encodings, which covers most
+// exported office documents and reports. Scanned PDFs have no text at all,
+// and fonts with custom CID encodings produce glyph IDs rather than
+// characters; both are reported as ErrNoText instead of indexing garbage.
+
+// maxPDFStreamBytes caps each decompressed stream and maxPDFContentBytes
+// all of them together, so a crafted file can't exhaust memory.
+const (
+	maxPDFStreamBytes  = 32 << 20
+	maxPDFContentBytes = 64 << 20
+)
+
+// minPrintableRatio is the share of extracted runes that must be ordinary
+// text for the result to be trusted.
+const minPrintableRatio = 0.85
+
+func PDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return "", errors.New("pdf: not a PDF file")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errors.New("pdf: encrypted PDFs are not supported")
+	}
+
+	var sb strings.Builder
+	for _, stream := range contentStreams(data) {
+		extractTextOps(stream, &sb)
+		sb.WriteByte('\n')
+	}
+
+	text := strings.TrimSpace(blankRe.ReplaceAllString(sb.String(), "\n\n"))
+	if text == "" || printableRatio(text) < minPrintableRatio {
+		return "", fmt.Errorf("%w (scanned or custom-encoded PDF)", ErrNoText)
+	}
+	return text, nil
+}
+
+// contentStreams returns the decoded streams that look like page content:
+// their dictionary has no /Type or /Subtype (images, fonts, object and xref
+// streams all carry one) and they use no filter other than FlateDecode.
+// Streams past maxPDFContentBytes are left out.
+func contentStreams(data []byte) [][]byte {
+	var (
+		streams [][]byte
+		total   int
+		// objFrom is where the previous stream ended; the next one's
+		// object starts after it, so looking back for it stays linear.
+		objFrom int
+	)
+	for pos := 0; total < maxPDFContentBytes; {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			return streams
+		}
+		kw := pos + i
+		pos = kw + len("stream")
+
+		// Skip "endstream" and require the EOL that must follow "stream".
+		if kw >= 3 && string(data[kw-3:kw]) == "end" {
+			continue
+		}
+		start := pos
+		switch {
+		case bytes.HasPrefix(data[start:], []byte("\r\n")):
+			start += 2
+		case bytes.HasPrefix(data[start:], []byte("\n")):
+			start++
+		default:
+			continue
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			return streams
+		}
+		end += start
+		pos = end + len("endstream")
+
+		objStart := bytes.LastIndex(data[objFrom:kw], []byte("obj"))
+		dictFrom := objFrom + objStart
+		objFrom = pos
+		if objStart < 0 {
+			continue
+		}
+		dict := data[dictFrom:kw]
+		if bytes.Contains(dict, []byte("/Type")) || bytes.Contains(dict, []byte("/Subtype")) ||
+			bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+
+		raw := bytes.TrimRight(data[start:end], "\r\n")
+		switch filters := bytes.Count(dict, []byte("Decode")); {
+		case filters == 0:
+			raw = raw[:min(len(raw), maxPDFContentBytes-total)]
+			streams = append(streams, raw)
+			total += len(raw)
+		case filters == 1 && bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			decoded, err := io.ReadAll(io.LimitReader(zr, int64(min(maxPDFStreamBytes, maxPDFContentBytes-total))))
+			zr.Close()
+			if err != nil && len(decoded) == 0 {
+				continue
+			}
+			streams = append(streams, decoded)
+			total += len(decoded)
+		}
+	}
+	return streams
+}
+
+// extractTextOps scans a content stream and writes the text shown by its
+// text operators.
+func extractTextOps(content []byte, sb *strings.Builder) {
+	var (
+		operands []string // strings seen since the last operator
+		numbers  []float64
+		inArray  bool
+		arrayStr strings.Builder
+	)
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := readLiteralString(content[i:])
+			i += n
+			if inArray {
+				arrayStr.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			s, n := readHexString(content[i:])
+			i += n
+			if inArray {
+				arrayStr.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '[':
+			inArray = true
+			arrayStr.Reset()
+			i++
+		case c == ']':
+			inArray = false
+			operands = append(operands, arrayStr.String())
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || (content[j] >= '0' && content[j] <= '9')) {
+				j++
+			}
+			n, _ := strconv.ParseFloat(string(content[i:j]), 64)
+			// Large negative kerning inside TJ arrays is how PDFs space words.
+			if inArray && n < -200 {
+				arrayStr.WriteByte(' ')
+			}
+			numbers = append(numbers, n)
+			i = j
+		case c == '/': // name operand (font, resource); not text
+			j := i + 1
+			for j < len(content) && !isDelimiter(content[j]) {
+				j++
+			}
+			i = j
+		case isOperatorChar(c) || c == '\'' || c == '"':
+			j := i + 1
+			if c != '\'' && c != '"' {
+				for j < len(content) && isOperatorChar(content[j]) {
+					j++
+				}
+			}
+			switch op := string(content[i:j]); op {
+			case "Tj", "TJ":
+				writeOperands(sb, operands)
+			case "'", "\"":
+				sb.WriteByte('\n')
+				writeOperands(sb, operands)
+			case "T*", "ET":
+				sb.WriteByte('\n')
+			case "Td", "TD":
+				if len(numbers) >= 2 && numbers[len(numbers)-1] != 0 {
+					sb.WriteByte('\n')
+				} else {
+					sb.WriteByte(' ')
+				}
+			case "Tm":
+				sb.WriteByte('\n')
+			}
+			if !inArray {
+				operands = operands[:0]
+				numbers = numbers[:0]
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+func writeOperands(sb *strings.Builder, operands []string) {
+	for _, s := range operands {
+		sb.WriteString(s)
+	}
+}
+
+func isOperatorChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*'
+}
+
+// isDelimiter reports whether c ends a PDF token (whitespace or a
+// delimiter character).
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// readLiteralString decodes a (...) string starting at b[0], handling
+// escapes and balanced nested parentheses. It returns the text and the
+// number of bytes consumed.
+func readLiteralString(b []byte) (string, int) {
+	var out []byte
+	depth := 0
+	i := 0
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return decodePDFString(out), i + 1
+			}
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7' {
+						j++
+					}
+					v, _ := strconv.ParseUint(string(b[i:j]), 8, 8)
+					out = append(out, byte(v))
+					i = j - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return decodePDFString(out), i
+}
+
+// readHexString decodes a <...> string starting at b[0].
+func readHexString(b []byte) (string, int) {
+	end := bytes.IndexByte(b, '>')
+	if end < 0 {
+		return "", len(b)
+	}
+	var hex []byte
+	for _, c := range b[1:end] {
+		if unicode.Is(unicode.ASCII_Hex_Digit, rune(c)) {
+			hex = append(hex, c)
+		}
+	}
+	if len(hex)%2 == 1 {
+		hex = append(hex, '0')
+	}
+	out := make([]byte, len(hex)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(hex[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return decodePDFString(out), end + 1
+}
+
+// decodePDFString maps string bytes to text: UTF-16BE when the string has
+// a byte-order mark, otherwise one character per byte (standard Latin
+// encodings agree with Latin-1 on the printable range).
+func decodePDFString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		u := make([]uint16, 0, (len(b)-2)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func printableRatio(s string) float64 {
+	var total, ok int
+	for _, r := range s {
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			ok++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(ok) / float64(total)
+}
@@ -0,0 +1,160 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfWith wraps content streams in the objects of a minimal PDF. A
+// stream starting with "/FlateDecode" is compressed and marked so.
+func pdfWith(streams ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		dict := ""
+		if rest, ok := strings.CutPrefix(s, "/FlateDecode"); ok {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write([]byte(rest))
+			zw.Close()
+			s, dict = z.String(), " /Filter /FlateDecode"
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n", i+4, len(s), dict, s)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestPDF(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams []string
+		want    string
+	}{
+		{"show string", []string{"BT /F1 12 Tf 72 712 Td (Quarterly report) Tj ET"}, "Quarterly report"},
+		{"kerned array", []string{"BT [(Annual) -250 (revenue) 120 (s)] TJ ET"}, "Annual revenues"},
+		{"escapes and nesting", []string{`BT (Costs \(net\) of \050tax\051 a\\b) Tj ET`}, `Costs (net) of (tax) a\b`},
+		{"hex UTF-16", []string{"BT <FEFF0043006100660065> Tj ET"}, "Cafe"},
+		{"lines", []string{"BT (First line) Tj 0 -14 Td (Second line) Tj ET"}, "First line\nSecond line"},
+		{"compressed", []string{"/FlateDecode BT (Compressed text) Tj ET"}, "Compressed text"},
+		{"several pages", []string{"BT (Page one) Tj ET", "BT (Page two) Tj ET"}, "Page one\n\nPage two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PDF(pdfWith(tt.streams...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not a PDF", []byte("PK\x03\x04 a zip"), nil},
+		{"empty", nil, nil},
+		{"encrypted", append(pdfWith("BT (secret) Tj ET"), "<< /Encrypt 9 0 R >>"...), nil},
+		{"no text", pdfWith("q 100 0 0 100 0 0 cm /Im1 Do Q"), ErrNoText},
+		{"glyph IDs", pdfWith("BT <0001000200030004000500060007> Tj ET"), ErrNoText},
+		{"images skipped", []byte("%PDF-1.4\n1 0 obj\n<< /Subtype /Image >>\nstream\nBT (pixels) Tj ET\nendstream\nendobj\n"), ErrNoText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PDF(tt.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Malformed files must fail or yield what text they have, never panic or
+// hang.
+func TestPDFMalformed(t *testing.T) {
+	valid := pdfWith("BT (Some text here) Tj ET")
+	inputs := map[string][]byte{
+		"header only":          []byte("%PDF-1.7"),
+		"stream without end":   []byte("%PDF-1.4\n1 0 obj\n<<>>\nstream\nBT (cut off"),
+		"stream without obj":   []byte("%PDF-1.4\nstream\nBT (orphan) Tj ET\nendstream"),
+		"stream without EOL":   []byte("%PDF-1.4\n1 0 obj\n<<>>\nstreamBT (x) Tj ET endstream"),
+		"bad zlib":             []byte("%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode >>\nstream\nnot zlib\nendstream\nendobj"),
+		"truncated zlib":       pdfWith("/FlateDecode BT (Compressed text) Tj ET")[:60],
+		"other filter":         []byte("%PDF-1.4\n1 0 obj\n<< /Filter /DCTDecode >>\nstream\nBT (x) Tj ET\nendstream\nendobj"),
+		"unbalanced paren":     pdfWith("BT (never closed Tj ET"),
+		"unbalanced close":     pdfWith("BT ) ) ) Tj ET"),
+		"trailing backslash":   pdfWith(`BT (ends in \`),
+		"bad octal":            pdfWith(`BT (\9\777\0) Tj ET`),
+		"unterminated hex":     pdfWith("BT <48656C6C6F"),
+		"odd hex":              pdfWith("BT <48656C6C6> Tj ET"),
+		"lone BOM":             pdfWith("BT <FEFF> Tj <FEFF00> Tj ET"),
+		"unterminated array":   pdfWith("BT [(a) (b) TJ ET"),
+		"stray close bracket":  pdfWith("BT ] ] (x) Tj ET"),
+		"operators only":       pdfWith("TJ Tj ' \" T* Td TD Tm ET"),
+		"numbers only":         pdfWith("-.--+ 1e9 99999999999999999999999 .5 -"),
+		"comment to EOF":       pdfWith("BT (x) Tj % no newline"),
+		"deep nesting":         pdfWith("BT " + strings.Repeat("(", 10000) + "x" + strings.Repeat(")", 10000) + " Tj ET"),
+		"many streams":         bytes.Repeat([]byte("obj\nstream\n(x) Tj\nendstream\n"), 2000),
+		"many orphan streams":  append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("stream\nendstream\n"), 200000)...),
+		"nested endstream":     pdfWith("BT (endstream) Tj ET"),
+		"null bytes":           append([]byte("%PDF-1.4\x00"), bytes.Repeat([]byte{0}, 1000)...),
+		"truncated everywhere": valid[:len(valid)/2],
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			PDF(data)
+		})
+	}
+}
+
+// Streams that inflate far beyond their size are cut off, each and
+// together, not read whole.
+func TestPDFDecompressionBomb(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(bytes.Repeat([]byte(" "), 2*maxPDFStreamBytes))
+	zw.Close()
+	stream := fmt.Appendf(nil, "1 0 obj\n<< /Filter /FlateDecode >>\nstream\n%s\nendstream\nendobj\n", z.Bytes())
+	data := append([]byte("%PDF-1.4\n"), bytes.Repeat(stream, 4)...)
+
+	var total int
+	for _, s := range contentStreams(data) {
+		if len(s) > maxPDFStreamBytes {
+			t.Errorf("stream of %d bytes decoded", len(s))
+		}
+		total += len(s)
+	}
+	if total > maxPDFContentBytes {
+		t.Errorf("%d bytes decoded in all", total)
+	}
+	if _, err := PDF(data); !errors.Is(err, ErrNoText) {
+		t.Errorf("got %v, want ErrNoText", err)
+	}
+}
+
+func FuzzPDF(f *testing.F) {
+	f.Add(pdfWith("BT /F1 12 Tf 72 712 Td (Quarterly report) Tj ET"))
+	f.Add(pdfWith("BT [(Annual) -250 (revenue)] TJ T* <FEFF0043> Tj ET"))
+	f.Add(pdfWith("/FlateDecode BT (Compressed text) Tj 0 -14 Td (more) ' ET"))
+	f.Add(pdfWith(`BT (a\(b\)c\101\n) Tj ET`, "q 1 0 0 1 0 0 cm Q"))
+	f.Add([]byte("%PDF-1.4\n1 0 obj\n<<>>\nstream\r\nBT (x) Tj ET\r\nendstream"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		text, err := PDF(data)
+		if err == nil && strings.TrimSpace(text) == "" {
+			t.Error("no error and no text")
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is required by the Messages API; it caps the
+	// answer length, not the prompt.
+	anthropicMaxTokens = 4096
+)
+
+func init() {
+	Register(ProviderAnthropic, Provider{
+		DefaultBaseURL: "https://api.anthropic.com/v1",
+		DefaultModel:   "claude-3-5-haiku-latest",
+		New: func(cfg Config) (Client, error) {
+			return &AnthropicClient{cfg: cfg}, nil
+		},
+	})
+}
+
+// AnthropicClient streams from Anthropic's Messages API.
+type AnthropicClient struct {
+	cfg Config
+}
+
+type anthropicRequest struct {
+	Model         string        `json:"model"`
+	System        string        `json:"system,omitempty"`
+	Messages      []chatMessage `json:"messages"`
+	MaxTokens     int           `json:"max_tokens"`
+	Stream        bool          `json:"stream"`
+	Temperature   *float64      `json:"temperature,omitempty"`
+	TopP          *float64      `json:"top_p,omitempty"`
+	StopSequences []string      `json:"stop_sequences,omitempty"`
+}
+
+func (c *AnthropicClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
+	defer close(out)
+
+	model := c.cfg.Model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	body, _ := json.Marshal(anthropicRequest{
+		Model:     model,
+		System:    systemPrompt,
+		Messages:  []chatMessage{{Role: "user", Content: userMessage}},
+		MaxTokens: cmp.Or(opts.MaxTokens, anthropicMaxTokens),
+		Stream:    true,
+		// Anthropic takes temperatures up to 1.
+		Temperature:   clampTemperature(opts.Temperature, 1),
+		TopP:          opts.TopP,
+		StopSequences: opts.Stop,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-key", c.cfg.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	// Text arrives in content_block_delta events; message_stop ends the
+	// stream and an error event can interrupt it.
+	return readSSE(resp.Body, func(data string) (bool, error) {
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, nil
+		}
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				return false, send(ctx, out, event.Delta.Text)
+			}
+		case "message_stop":
+			return true, nil
+		case "error":
+			return true, errors.New("anthropic stream error: " + event.Error.Message)
+		}
+		return false, nil
+	})
+}
+
+// clampTemperature lowers t to limit for providers with a narrower range.
+func clampTemperature(t *float64, limit float64) *float64 {
+	if t == nil || *t <= limit {
+		return t
+	}
+	return &limit
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Pricing tiers, from cheapest to dearest.
+const (
+	TierEconomy  = "economy"
+	TierStandard = "standard"
+	TierPremium  = "premium"
+)
+
+// modelInfo is what is known about a provider model.
+type modelInfo struct {
+	contextWindow int
+	tier          string
+}
+
+// knownModels describes the providers' default and common models. Models
+// missing here can still be offered; they are listed without details.
+var knownModels = map[string]modelInfo{
+	"gpt-4o-mini":             {128_000, TierEconomy},
+	"gpt-4o":                  {128_000, TierPremium},
+	"gpt-4.1":                 {1_047_576, TierPremium},
+	"gpt-4.1-mini":            {1_047_576, TierStandard},
+	"gpt-4.1-nano":            {1_047_576, TierEconomy},
+	"claude-3-5-haiku-latest": {200_000, TierStandard},
+	"claude-sonnet-4-0":       {200_000, TierPremium},
+	"gemini-2.0-flash":        {1_048_576, TierEconomy},
+	"gemini-2.5-pro":          {1_048_576, TierPremium},
+	"llama3.1:8b":             {128_000, TierEconomy},
+}
+
+// Model is one model clients can ask for by ID.
+type Model struct {
+	// ID is what clients pass as the model: an alias, or the provider's
+	// model name.
+	ID       string `json:"id"`
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	// ContextWindow is in tokens; zero when the model isn't known.
+	ContextWindow int    `json:"context_window,omitempty"`
+	PricingTier   string `json:"pricing_tier,omitempty"`
+	// Streaming is true for every model today: all providers stream.
+	Streaming bool `json:"streaming"`
+	Default   bool `json:"default"`
+}
+
+// Catalog is the set of models the deployment offers, with the aliases
+// operators gave them.
+type Catalog struct {
+	models []Model
+	byID   map[string]string
+}
+
+// NewCatalog builds the catalog of provider's models. Each entry is a
+// model name or "alias=model"; defaultModel is always offered, first.
+func NewCatalog(provider, defaultModel string, entries []string) (*Catalog, error) {
+	c := &Catalog{byID: map[string]string{}}
+	add := func(id, model string) error {
+		if id == "" || model == "" {
+			return fmt.Errorf("invalid model entry %q", id+"="+model)
+		}
+		if prev, dup := c.byID[id]; dup {
+			if prev == model {
+				return nil
+			}
+			return fmt.Errorf("model %q is listed twice", id)
+		}
+		info := knownModels[model]
+		c.byID[id] = model
+		c.models = append(c.models, Model{
+			ID:            id,
+			Model:         model,
+			Provider:      provider,
+			ContextWindow: info.contextWindow,
+			PricingTier:   info.tier,
+			Streaming:     true,
+			Default:       id == defaultModel,
+		})
+		return nil
+	}
+
+	if err := add(defaultModel, defaultModel); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		id, model, ok := strings.Cut(e, "=")
+		if !ok {
+			model = id
+		}
+		if err := add(strings.TrimSpace(id), strings.TrimSpace(model)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Models lists the catalog, the default model first.
+func (c *Catalog) Models() []Model {
+	return c.models
+}
+
+// Resolve returns the provider model for id, an alias or model name.
+func (c *Catalog) Resolve(id string) (string, bool) {
+	model, ok := c.byID[id]
+	return model, ok
+}
+
+// ContextWindow returns the context window in tokens of the model id
+// names, an alias or model name; empty names the default model. It is zero
+// for models the catalog has no details on.
+func (c *Catalog) ContextWindow(id string) int {
+	if id == "" {
+		return c.models[0].ContextWindow
+	}
+	model, ok := c.byID[id]
+	if !ok {
+		model = id
+	}
+	return knownModels[model].contextWindow
+}
+
+// Client wraps inner so requests can name a model by its alias. Names the
+// catalog doesn't know pass through unchanged.
+func (c *Catalog) Client(inner Client) Client {
+	return &aliasedClient{inner: inner, catalog: c}
+}
+
+type aliasedClient struct {
+	inner   Client
+	catalog *Catalog
+}
+
+func (a *aliasedClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
+	if model, ok := a.catalog.Resolve(opts.Model); ok {
+		opts.Model = model
+	}
+	return a.inner.StreamCompletion(ctx, systemPrompt, userMessage, opts, out)
+}
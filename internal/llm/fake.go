@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register(ProviderFake, Provider{
+		DefaultModel: "fake",
+		InProcess:    true,
+		New: func(cfg Config) (Client, error) {
+			if cfg.Script == "" {
+				return NewFakeClient(nil), nil
+			}
+			data, err := os.ReadFile(cfg.Script)
+			if err != nil {
+				return nil, fmt.Errorf("read fake LLM script: %w", err)
+			}
+			var rules []FakeRule
+			if err := json.Unmarshal(data, &rules); err != nil {
+				return nil, fmt.Errorf("parse fake LLM script %s: %w", cfg.Script, err)
+			}
+			return NewFakeClient(rules), nil
+		},
+	})
+}
+
+// FakeRule scripts one response of the fake provider.
+type FakeRule struct {
+	// Match is looked for in the question (or the whole user message when
+	// the prompt has no question), ignoring case; empty matches anything.
+	Match    string `json:"match"`
+	Response string `json:"response"`
+}
+
+// FakeClient answers without a model, for integration tests and demos
+// that must run without network access or API keys. The first rule
+// matching the question gives the answer; without one the answer is
+// derived from the prompt, so the same request always streams the same
+// tokens. Answers are streamed word by word like a real provider's, each
+// word counting as a token for MaxTokens.
+type FakeClient struct {
+	rules []FakeRule
+}
+
+// NewFakeClient creates a fake client answering by rules.
+func NewFakeClient(rules []FakeRule) *FakeClient {
+	return &FakeClient{rules: rules}
+}
+
+func (c *FakeClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
+	defer close(out)
+
+	// Like a model, stop before a stop sequence and after MaxTokens words.
+	answer := c.answer(systemPrompt, userMessage)
+	for _, stop := range opts.Stop {
+		answer, _, _ = strings.Cut(answer, stop)
+	}
+	for i, word := range strings.SplitAfter(answer, " ") {
+		if opts.MaxTokens > 0 && i == opts.MaxTokens {
+			break
+		}
+		if err := send(ctx, out, word); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// answer picks the scripted response for a prompt, or makes one up.
+func (c *FakeClient) answer(systemPrompt, userMessage string) string {
+	question := userMessage
+	if i := strings.LastIndex(userMessage, "Question: "); i >= 0 {
+		question = strings.TrimSpace(userMessage[i+len("Question: "):])
+	}
+	for _, r := range c.rules {
+		if strings.Contains(strings.ToLower(question), strings.ToLower(r.Match)) {
+			return r.Response
+		}
+	}
+
+	// Retrieved passages are headed "--- Chunk n ..." in RAG prompts.
+	if chunks := strings.Count(userMessage, "\n--- "); chunks > 0 {
+		return fmt.Sprintf("Fake answer to %q based on %d context chunks [1].", question, chunks)
+	}
+	sum := sha256.Sum256([]byte(systemPrompt + "\x00" + userMessage))
+	return "Fake completion " + hex.EncodeToString(sum[:4]) + "."
+}
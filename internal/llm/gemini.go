@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+func init() {
+	Register(ProviderGemini, Provider{
+		DefaultBaseURL: "https://generativelanguage.googleapis.com/v1beta",
+		DefaultModel:   "gemini-2.0-flash",
+		New: func(cfg Config) (Client, error) {
+			return &GeminiClient{cfg: cfg}, nil
+		},
+	})
+}
+
+// GeminiClient streams from the Google Gemini API (generativelanguage).
+type GeminiClient struct {
+	cfg Config
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent         `json:"contents"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+func (c *GeminiClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
+	defer close(out)
+
+	model := c.cfg.Model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	greq := geminiRequest{
+		Contents: []geminiContent{{Role: "user", Parts: []geminiPart{{Text: userMessage}}}},
+	}
+	if systemPrompt != "" {
+		greq.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: systemPrompt}}}
+	}
+	if g := opts.Generation; g.Temperature != nil || g.TopP != nil || g.MaxTokens != 0 || len(g.Stop) > 0 {
+		greq.GenerationConfig = &geminiGenerationConfig{
+			Temperature:     g.Temperature,
+			TopP:            g.TopP,
+			MaxOutputTokens: g.MaxTokens,
+			StopSequences:   g.Stop,
+		}
+	}
+	body, _ := json.Marshal(greq)
+
+	endpoint := c.cfg.BaseURL + "/models/" + url.PathEscape(model) + ":streamGenerateContent?alt=sse"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-goog-api-key", c.cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	// Each event is a partial GenerateContentResponse; the stream ends when
+	// the connection closes.
+	return readSSE(resp.Body, func(data string) (bool, error) {
+		var chunk struct {
+			Candidates []struct {
+				Content geminiContent `json:"content"`
+			} `json:"candidates"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, nil
+		}
+		if chunk.Error != nil {
+			return true, errors.New("gemini stream error: " + chunk.Error.Message)
+		}
+		if len(chunk.Candidates) == 0 {
+			return false, nil
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if err := send(ctx, out, part.Text); err != nil {
+				return true, err
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,233 @@
+// Package llm streams chat completions from a configurable provider. Each
+// provider registers itself under a name (LLM_PROVIDER) and implements
+// Client on top of its own streaming API.
+package llm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Client streams a completion token by token over out, closing out when
+// done or on error.
+type Client interface {
+	StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error
+}
+
+// CompletionOptions are per-request overrides for a completion call.
+// Zero values fall back to the client's defaults.
+type CompletionOptions struct {
+	Model string
+	Generation
+}
+
+// Generation tunes how an answer is sampled. Unset fields (nil, zero,
+// empty) leave the provider's defaults.
+type Generation struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// MaxStopSequences is the most stop sequences every provider accepts.
+const MaxStopSequences = 4
+
+// ErrInvalidGeneration is returned for generation parameters out of range.
+var ErrInvalidGeneration = errors.New("invalid generation parameters")
+
+// Validate checks the parameters are in the ranges providers accept:
+// temperature 0-2, top_p above 0 up to 1, max_tokens not negative, and at
+// most MaxStopSequences non-empty stop sequences.
+func (g Generation) Validate() error {
+	switch {
+	case g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2):
+		return fmt.Errorf("%w: temperature must be between 0 and 2", ErrInvalidGeneration)
+	case g.TopP != nil && (*g.TopP <= 0 || *g.TopP > 1):
+		return fmt.Errorf("%w: top_p must be above 0 and at most 1", ErrInvalidGeneration)
+	case g.MaxTokens < 0:
+		return fmt.Errorf("%w: max_tokens must not be negative", ErrInvalidGeneration)
+	case len(g.Stop) > MaxStopSequences:
+		return fmt.Errorf("%w: at most %d stop sequences are allowed", ErrInvalidGeneration, MaxStopSequences)
+	case slices.Contains(g.Stop, ""):
+		return fmt.Errorf("%w: stop sequences must not be empty", ErrInvalidGeneration)
+	}
+	return nil
+}
+
+// Or returns g with its unset parameters taken from def.
+func (g Generation) Or(def Generation) Generation {
+	if g.Temperature == nil {
+		g.Temperature = def.Temperature
+	}
+	if g.TopP == nil {
+		g.TopP = def.TopP
+	}
+	if g.MaxTokens == 0 {
+		g.MaxTokens = def.MaxTokens
+	}
+	if len(g.Stop) == 0 {
+		g.Stop = def.Stop
+	}
+	return g
+}
+
+// Config configures a provider's client. Empty BaseURL and Model take the
+// provider's defaults.
+type Config struct {
+	APIKey  string
+	Model   string
+	BaseURL string
+	// APIVersion is the Azure OpenAI api-version; other providers ignore it.
+	APIVersion string
+	// Script is the fake provider's file of scripted responses (see
+	// FakeRule); other providers ignore it.
+	Script string
+	// HTTPClient defaults to one with a 120s timeout.
+	HTTPClient *http.Client
+}
+
+// Provider describes one LLM backend.
+type Provider struct {
+	// DefaultBaseURL is empty when the endpoint is per deployment (Azure).
+	DefaultBaseURL string
+	DefaultModel   string
+	// KeyOptional reports whether baseURL can be used without an API key.
+	// Nil means a key is always required.
+	KeyOptional func(baseURL string) bool
+	// InProcess providers answer without calling any endpoint (the fake
+	// provider), so they need neither a base URL nor a key.
+	InProcess bool
+	New       func(cfg Config) (Client, error)
+}
+
+// NeedsKey reports whether calling baseURL requires an API key.
+func (p Provider) NeedsKey(baseURL string) bool {
+	return !p.InProcess && (p.KeyOptional == nil || !p.KeyOptional(baseURL))
+}
+
+// Provider names.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAzure     = "azure"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+	ProviderOllama    = "ollama"
+	ProviderFake      = "fake"
+)
+
+var providers = map[string]Provider{}
+
+// Register makes a provider available to New. It panics on a duplicate
+// name, like database/sql drivers.
+func Register(name string, p Provider) {
+	if _, dup := providers[name]; dup {
+		panic("llm: provider registered twice: " + name)
+	}
+	providers[name] = p
+}
+
+// Lookup returns a registered provider.
+func Lookup(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Providers lists the registered provider names, sorted.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// New creates a client for the named provider, filling in its defaults.
+func New(name string, cfg Config) (Client, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = p.DefaultBaseURL
+	}
+	if cfg.BaseURL == "" && !p.InProcess {
+		return nil, fmt.Errorf("LLM provider %q needs a base URL", name)
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Model == "" {
+		cfg.Model = p.DefaultModel
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("LLM provider %q needs a model (for Azure, the deployment name)", name)
+	}
+	if cfg.APIKey == "" && p.NeedsKey(cfg.BaseURL) {
+		return nil, fmt.Errorf("LLM provider %q needs an API key", name)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 120 * time.Second}
+	}
+	return p.New(cfg)
+}
+
+// Streaming helpers shared by the providers.
+
+// maxLineBytes bounds one line of a streamed response.
+const maxLineBytes = 1 << 20
+
+// readLines calls fn with each line of a streamed response body until fn
+// asks to stop or the body ends.
+func readLines(body io.Reader, fn func(line string) (stop bool, err error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		stop, err := fn(scanner.Text())
+		if err != nil || stop {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readSSE is readLines for server-sent events: fn gets the payload of each
+// "data:" line.
+func readSSE(body io.Reader, fn func(data string) (stop bool, err error)) error {
+	return readLines(body, func(line string) (bool, error) {
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			return false, nil
+		}
+		return fn(strings.TrimPrefix(data, " "))
+	})
+}
+
+// send forwards a token unless the caller has gone away.
+func send(ctx context.Context, out chan<- string, token string) error {
+	if token == "" {
+		return nil
+	}
+	select {
+	case out <- token:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// statusError describes a non-200 response, including the start of the
+// body where providers put the reason.
+func statusError(resp *http.Response) error {
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(snippet)); msg != "" {
+		return fmt.Errorf("llm returned status %d: %s", resp.StatusCode, msg)
+	}
+	return fmt.Errorf("llm returned status %d", resp.StatusCode)
+}
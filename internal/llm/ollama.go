@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func init() {
+	Register(ProviderOllama, Provider{
+		DefaultBaseURL: "http://localhost:11434",
+		DefaultModel:   "llama3.1:8b",
+		KeyOptional:    func(string) bool { return true },
+		New: func(cfg Config) (Client, error) {
+			return &OllamaClient{cfg: cfg}, nil
+		},
+	})
+}
+
+// OllamaClient streams from a local Ollama server's native chat API. The
+// API key, if set, is sent as a bearer token for servers behind an
+// authenticating proxy.
+type OllamaClient struct {
+	cfg Config
+}
+
+// ollamaRequest is the native chat request; sampling goes in options.
+type ollamaRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  ollamaOptions `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+func (c *OllamaClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
+	defer close(out)
+
+	model := c.cfg.Model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	body, _ := json.Marshal(ollamaRequest{
+		Model: model,
+		Messages: []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: userMessage},
+		},
+		Stream: true,
+		Options: ollamaOptions{
+			Temperature: opts.Temperature,
+			TopP:        opts.TopP,
+			NumPredict:  opts.MaxTokens,
+			Stop:        opts.Stop,
+		},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	// The response is newline-delimited JSON, one message fragment per
+	// line, ending with "done": true.
+	return readLines(resp.Body, func(line string) (bool, error) {
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Done  bool   `json:"done"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return false, nil
+		}
+		if chunk.Error != "" {
+			return true, errors.New("ollama stream error: " + chunk.Error)
+		}
+		if err := send(ctx, out, chunk.Message.Content); err != nil {
+			return true, err
+		}
+		return chunk.Done, nil
+	})
+}
@@ -1,36 +1,106 @@
 package llm
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
-const openAIChatURL = "https://api.openai.com/v1/chat/completions"
+// DefaultBaseURL is OpenAI's API. Any OpenAI-compatible server (Ollama,
+// vLLM, LocalAI) can be used instead by passing its /v1 base URL.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// azureAPIVersion is the Azure OpenAI api-version used when none is set.
+// It was 2024-06-01 until usage reporting needed a later one (see the
+// README's LLM providers section).
+const azureAPIVersion = "2024-10-21"
+
+// azureUsageVersion is the first Azure OpenAI api-version that accepts
+// stream_options; versions sort as their dates.
+const azureUsageVersion = "2024-07-01"
+
+func init() {
+	Register(ProviderOpenAI, Provider{
+		DefaultBaseURL: DefaultBaseURL,
+		DefaultModel:   "gpt-4o-mini",
+		// Local OpenAI-compatible servers don't check the key.
+		KeyOptional: func(baseURL string) bool { return baseURL != DefaultBaseURL },
+		New: func(cfg Config) (Client, error) {
+			c := NewOpenAIClient(cfg.APIKey, cfg.Model, cfg.BaseURL)
+			c.client = cfg.HTTPClient
+			return c, nil
+		},
+	})
 
+	// Azure OpenAI speaks the same protocol, but the model is a deployment
+	// in the URL and the key goes in an api-key header. BaseURL is the
+	// resource endpoint, e.g. https://<resource>.openai.azure.com.
+	Register(ProviderAzure, Provider{
+		New: func(cfg Config) (Client, error) {
+			version := cfg.APIVersion
+			if version == "" {
+				version = azureAPIVersion
+			}
+			return &OpenAIClient{
+				model: cfg.Model,
+				chatURL: func(deployment string) string {
+					return cfg.BaseURL + "/openai/deployments/" + url.PathEscape(deployment) +
+						"/chat/completions?api-version=" + url.QueryEscape(version)
+				},
+				authorize:    func(req *http.Request) { req.Header.Set("api-key", cfg.APIKey) },
+				client:       cfg.HTTPClient,
+				includeUsage: version >= azureUsageVersion,
+			}, nil
+		},
+	})
+}
+
+// OpenAIClient streams from the OpenAI chat completions API or a server
+// compatible with it (including Azure OpenAI).
 type OpenAIClient struct {
-	apiKey string
-	model  string
-	client *http.Client
+	model     string
+	chatURL   func(model string) string
+	authorize func(req *http.Request)
+	client    *http.Client
+	// includeUsage asks for the usage chunk at the end of the stream.
+	includeUsage bool
 }
 
-func NewOpenAIClient(apiKey, model string) *OpenAIClient {
+// NewOpenAIClient creates a chat client for an OpenAI-compatible API. The
+// API key may be empty for local servers that don't check it.
+func NewOpenAIClient(apiKey, model, baseURL string) *OpenAIClient {
+	chatURL := strings.TrimRight(baseURL, "/") + "/chat/completions"
 	return &OpenAIClient{
-		apiKey: apiKey,
-		model:  model,
-		client: &http.Client{Timeout: 120 * time.Second},
+		model:   model,
+		chatURL: func(string) string { return chatURL },
+		authorize: func(req *http.Request) {
+			if apiKey != "" {
+				req.Header.Set("Authorization", "Bearer "+apiKey)
+			}
+		},
+		client:       &http.Client{Timeout: 120 * time.Second},
+		includeUsage: true,
 	}
 }
 
 type chatRequest struct {
-	Model    string        `json:"model"`
-	Messages []chatMessage `json:"messages"`
-	Stream   bool          `json:"stream"`
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	// StreamOptions asks for a final chunk with the completion's usage.
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type chatMessage struct {
@@ -39,25 +109,40 @@ type chatMessage struct {
 }
 
 // StreamCompletion calls the OpenAI chat API with stream=true and forwards
-// each token to the out channel. Closes out when done or on error.
-func (c *OpenAIClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, out chan<- string) error {
+// each token to the out channel. Closes out when done or on error. The
+// usage the API reports in the stream's last chunk goes to the report in
+// ctx (see WithUsageReport).
+func (c *OpenAIClient) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts CompletionOptions, out chan<- string) error {
 	defer close(out)
 
-	body, _ := json.Marshal(chatRequest{
-		Model: c.model,
+	model := c.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	chat := chatRequest{
+		Model: model,
 		Messages: []chatMessage{
 			{Role: "system", Content: systemPrompt},
 			{Role: "user", Content: userMessage},
 		},
-		Stream: true,
-	})
+		Stream:      true,
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+		Stop:        opts.Stop,
+	}
+	if c.includeUsage {
+		chat.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	body, _ := json.Marshal(chat)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIChatURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.chatURL(model), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	c.authorize(req)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "text/event-stream")
 
@@ -69,19 +154,13 @@ func (c *OpenAIClient) StreamCompletion(ctx context.Context, systemPrompt, userM
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("openai returned status %d", resp.StatusCode)
+		return statusError(resp)
 	}
 
 	// Parse SSE stream: each line is "data: <json>" or "data: [DONE]"
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := strings.TrimPrefix(line, "data: ")
+	return readSSE(resp.Body, func(data string) (bool, error) {
 		if data == "[DONE]" {
-			break
+			return true, nil
 		}
 
 		var chunk struct {
@@ -90,18 +169,21 @@ func (c *OpenAIClient) StreamCompletion(ctx context.Context, systemPrompt, userM
 					Content string `json:"content"`
 				} `json:"delta"`
 			} `json:"choices"`
+			// Usage is only set on the final chunk, which has no choices.
+			Usage *struct {
+				PromptTokens     int64 `json:"prompt_tokens"`
+				CompletionTokens int64 `json:"completion_tokens"`
+			} `json:"usage"`
 		}
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			continue
+			return false, nil
 		}
-		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
-			select {
-			case out <- chunk.Choices[0].Delta.Content:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+		if chunk.Usage != nil {
+			reportUsage(ctx, Usage{PromptTokens: chunk.Usage.PromptTokens, CompletionTokens: chunk.Usage.CompletionTokens})
 		}
-	}
-
-	return scanner.Err()
+		if len(chunk.Choices) == 0 {
+			return false, nil
+		}
+		return false, send(ctx, out, chunk.Choices[0].Delta.Content)
+	})
 }
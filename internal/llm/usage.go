@@ -0,0 +1,33 @@
+package llm
+
+import "context"
+
+// Reported usage
+//
+// Client streams text only, so token counts go back out of band: a caller
+// that wants them passes a context from WithUsageReport, and a provider
+// whose API reports what a completion billed fills it in. Providers that
+// don't report leave it empty and the caller estimates.
+
+// Usage is the tokens a provider billed for one completion.
+type Usage struct {
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+type usageKey struct{}
+
+// WithUsageReport returns a context under which a completion's reported
+// usage is stored in the returned Usage. It stays zero when the provider
+// doesn't report usage.
+func WithUsageReport(ctx context.Context) (context.Context, *Usage) {
+	u := &Usage{}
+	return context.WithValue(ctx, usageKey{}, u), u
+}
+
+// reportUsage stores u in the report in ctx, if any.
+func reportUsage(ctx context.Context, u Usage) {
+	if r, _ := ctx.Value(usageKey{}).(*Usage); r != nil {
+		*r = u
+	}
+}
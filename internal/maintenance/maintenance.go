@@ -0,0 +1,191 @@
+// Package maintenance holds the maintenance switch. While it is on, new
+// queries and uploads are turned away with 503 so work already running
+// can finish before an operator migrates the schema; the in-flight count
+// tells them when the instance has drained. The switch is stored in the
+// database, so turning it on through one replica turns it on for all of
+// them; each counts its own requests in flight.
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultRetryAfter is sent as Retry-After when the operator doesn't set
+// one.
+const DefaultRetryAfter = 2 * time.Minute
+
+// State is the current maintenance setting.
+type State struct {
+	Enabled    bool
+	Message    string
+	RetryAfter time.Duration
+	Since      time.Time
+}
+
+// Repository stores the switch in maintenance_mode, whose one row exists
+// while maintenance is on.
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+func (r *Repository) load(ctx context.Context) (State, error) {
+	st := State{Enabled: true}
+	var seconds int
+	err := r.db.QueryRow(ctx,
+		`SELECT message, retry_after_seconds, since FROM maintenance_mode`,
+	).Scan(&st.Message, &seconds, &st.Since)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return State{}, nil
+	}
+	st.RetryAfter = time.Duration(seconds) * time.Second
+	return st, err
+}
+
+// enable turns the switch on, keeping the start time if it already was.
+func (r *Repository) enable(ctx context.Context, message string, retryAfter time.Duration) (State, error) {
+	st := State{Enabled: true, Message: message, RetryAfter: retryAfter}
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO maintenance_mode (message, retry_after_seconds) VALUES ($1, $2)
+		 ON CONFLICT (id) DO UPDATE SET message = EXCLUDED.message, retry_after_seconds = EXCLUDED.retry_after_seconds
+		 RETURNING since`,
+		message, int(retryAfter.Seconds()),
+	).Scan(&st.Since)
+	return st, err
+}
+
+func (r *Repository) disable(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM maintenance_mode`)
+	return err
+}
+
+// Mode is the switch plus a count of the guarded requests in progress.
+// Without a repository (and in the zero value) the switch is the
+// process's own.
+type Mode struct {
+	repo     *Repository
+	mu       sync.RWMutex
+	state    State
+	inFlight atomic.Int64
+}
+
+func New(repo *Repository) *Mode {
+	return &Mode{repo: repo}
+}
+
+// Load reads the stored switch.
+func (m *Mode) Load(ctx context.Context) error {
+	if m.repo == nil {
+		return nil
+	}
+	st, err := m.repo.load(ctx)
+	if err != nil {
+		return fmt.Errorf("load maintenance mode: %w", err)
+	}
+	m.set(st)
+	return nil
+}
+
+// Watch reloads the switch every interval until ctx is cancelled, so a
+// change made through another replica reaches this one. A failed reload
+// keeps the previous state.
+func (m *Mode) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Load(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("maintenance mode reload failed", "error", err)
+		}
+	}
+}
+
+// Enable turns maintenance on. A non-positive retryAfter uses
+// DefaultRetryAfter. Calling it again updates the message and retry hint
+// but keeps the original start time.
+func (m *Mode) Enable(ctx context.Context, message string, retryAfter time.Duration) (State, error) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+	if m.repo == nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		since := m.state.Since
+		if !m.state.Enabled {
+			since = time.Now()
+		}
+		m.state = State{Enabled: true, Message: message, RetryAfter: retryAfter, Since: since}
+		return m.state, nil
+	}
+	st, err := m.repo.enable(ctx, message, retryAfter)
+	if err != nil {
+		return State{}, fmt.Errorf("enable maintenance mode: %w", err)
+	}
+	m.set(st)
+	return st, nil
+}
+
+// Disable turns maintenance off.
+func (m *Mode) Disable(ctx context.Context) (State, error) {
+	if m.repo != nil {
+		if err := m.repo.disable(ctx); err != nil {
+			return State{}, fmt.Errorf("disable maintenance mode: %w", err)
+		}
+	}
+	m.set(State{})
+	return State{}, nil
+}
+
+func (m *Mode) set(st State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = st
+}
+
+func (m *Mode) State() State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *Mode) Enabled() bool {
+	return m.State().Enabled
+}
+
+// Begin admits a new guarded request. It returns ok=false with the current
+// state while maintenance is on; otherwise the caller must call done when
+// the request finishes.
+//
+// The request is counted before the switch is checked, so once Enable
+// returns every request this replica admitted is already in InFlight;
+// other replicas stop admitting within their reload interval.
+func (m *Mode) Begin() (done func(), state State, ok bool) {
+	m.inFlight.Add(1)
+	if state = m.State(); state.Enabled {
+		m.inFlight.Add(-1)
+		return nil, state, false
+	}
+	return func() { m.inFlight.Add(-1) }, state, true
+}
+
+// InFlight is the number of admitted requests that haven't finished on
+// this replica.
+func (m *Mode) InFlight() int64 {
+	return m.inFlight.Load()
+}
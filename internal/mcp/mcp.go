@@ -0,0 +1,365 @@
+// Package mcp exposes a tenant's knowledge base to desktop agents and IDE
+// assistants over the Model Context Protocol (Streamable HTTP transport).
+//
+// Clients POST JSON-RPC 2.0 messages to a single endpoint and authenticate
+// with an org API key (Authorization: Bearer rk_... or X-API-Key). The
+// server is stateless: every response is a plain JSON body, so no session
+// or server-initiated SSE stream is needed.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/pixell07/multi-tenant-ai/internal/apikey"
+	"github.com/pixell07/multi-tenant-ai/internal/crm"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/pixell07/multi-tenant-ai/internal/usage"
+)
+
+const (
+	protocolVersion = "2025-06-18"
+	serverName      = "multi-tenant-ai"
+	serverVersion   = "1.0.0"
+	maxTopK         = 20
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// KeyVerifier resolves an API key to its org.
+type KeyVerifier interface {
+	Verify(ctx context.Context, plaintext string) (*apikey.Key, error)
+}
+
+// CustomerLookup finds a customer's account data in the org's CRMs.
+type CustomerLookup interface {
+	LookupCustomer(ctx context.Context, orgID, email string) ([]*crm.Customer, error)
+}
+
+// QuotaChecker refuses work an org has no quota left for.
+type QuotaChecker interface {
+	Check(ctx context.Context, orgID string, kinds ...usage.Kind) error
+}
+
+type Server struct {
+	rag    *retrieval.RAGService
+	keys   KeyVerifier
+	crm    CustomerLookup
+	quota  QuotaChecker
+	logger *slog.Logger
+}
+
+func NewServer(rag *retrieval.RAGService, keys KeyVerifier, crm CustomerLookup, quota QuotaChecker, logger *slog.Logger) *Server {
+	return &Server{rag: rag, keys: keys, crm: crm, quota: quota, logger: logger}
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ServeHTTP handles the MCP endpoint. Only POST is supported; GET (the
+// optional server->client stream) answers 405 as the spec allows.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := s.keys.Verify(r.Context(), keyFromRequest(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mcp"`)
+		http.Error(w, "invalid or missing api key", http.StatusUnauthorized)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: codeParseError, Message: "parse error"}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: idOrNull(req.ID),
+			Error: &rpcError{Code: codeInvalidRequest, Message: "invalid request"}})
+		return
+	}
+
+	// Notifications (no id) get no JSON-RPC response.
+	if len(req.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	ctx := tenancy.WithActor(tenancy.WithOrg(r.Context(), key.OrgID), "apikey:"+key.ID)
+	result, rpcErr := s.dispatch(ctx, key, req)
+	writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr})
+}
+
+func (s *Server) dispatch(ctx context.Context, key *apikey.Key, req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": protocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": serverName, "version": serverVersion},
+			"instructions":    "Search and ask questions about this organization's knowledge base.",
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": toolsFor(key)}, nil
+	case "tools/call":
+		return s.callTool(ctx, key, req.Params)
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+}
+
+var toolDefinitions = []map[string]any{
+	{
+		"name":        "search_knowledge_base",
+		"description": "Semantic search over the organization's documents. Returns the most relevant passages with their source document.",
+		"inputSchema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "What to search for"},
+				"top_k": map[string]any{"type": "integer", "description": "Number of passages (default 5, max 20)"},
+				"search_mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{"vector", "hybrid"},
+					"description": "vector (default) for semantic matches; hybrid also matches exact terms such as error codes and SKUs",
+				},
+				"max_chunks_per_doc": map[string]any{"type": "integer", "description": "Cap on passages from any one document (default no cap)"},
+			},
+			"required": []string{"query"},
+		},
+	},
+	{
+		"name":        "ask_knowledge_base",
+		"description": "Answer a question using only the organization's documents (retrieval-augmented generation).",
+		"inputSchema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"question": map[string]any{"type": "string"},
+				"top_k":    map[string]any{"type": "integer", "description": "Passages to ground the answer on (default 5, max 20)"},
+			},
+			"required": []string{"question"},
+		},
+	},
+	{
+		"name":        "get_customer_context",
+		"description": "Look up a customer's account data (name, company, plan, lifecycle stage, custom attributes) in the organization's CRM (Intercom or HubSpot) by email. Use it to tailor support answers to the asking customer.",
+		"inputSchema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"email": map[string]any{"type": "string", "description": "The customer's email address"},
+			},
+			"required": []string{"email"},
+		},
+	},
+}
+
+// adminTools reveal data beyond the knowledge base (customers' account
+// data), so only admin keys see and call them.
+var adminTools = map[string]bool{"get_customer_context": true}
+
+// toolsFor lists the tools key may call.
+func toolsFor(key *apikey.Key) []map[string]any {
+	if key.Role == "admin" {
+		return toolDefinitions
+	}
+	var tools []map[string]any
+	for _, t := range toolDefinitions {
+		if !adminTools[t["name"].(string)] {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+type toolArgs struct {
+	Query      string `json:"query"`
+	Question   string `json:"question"`
+	TopK       int    `json:"top_k"`
+	SearchMode string `json:"search_mode"`
+	MaxPerDoc  int    `json:"max_chunks_per_doc"`
+	Email      string `json:"email"`
+}
+
+// callTool runs a tool. Tool failures are reported in the result with
+// isError set, per MCP, so the calling model can see and react to them.
+func (s *Server) callTool(ctx context.Context, key *apikey.Key, raw json.RawMessage) (any, *rpcError) {
+	var params struct {
+		Name      string   `json:"name"`
+		Arguments toolArgs `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "invalid params"}
+	}
+	if adminTools[params.Name] && key.Role != "admin" {
+		return toolResult("This tool requires an admin API key.", true), nil
+	}
+	orgID := key.OrgID
+	args := params.Arguments
+	args.TopK = min(args.TopK, maxTopK)
+
+	var (
+		text string
+		err  error
+	)
+	switch params.Name {
+	case "search_knowledge_base":
+		if args.Query == "" {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "query is required"}
+		}
+		text, err = s.search(ctx, orgID, args)
+	case "ask_knowledge_base":
+		if args.Question == "" {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "question is required"}
+		}
+		if err = s.quota.Check(ctx, orgID, usage.LLMTokens); errors.Is(err, usage.ErrQuotaExceeded) {
+			return toolResult("This organization has used up its quota: "+err.Error(), true), nil
+		}
+		if err == nil {
+			text, err = s.ask(ctx, orgID, args)
+		}
+	case "get_customer_context":
+		if args.Email == "" {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "email is required"}
+		}
+		text, err = s.customerContext(ctx, orgID, args)
+		switch {
+		case errors.Is(err, crm.ErrNotConfigured):
+			return toolResult("No CRM integration is configured for this organization.", true), nil
+		case errors.Is(err, crm.ErrInvalid):
+			return toolResult(err.Error(), true), nil
+		}
+	default:
+		return nil, &rpcError{Code: codeInvalidParams, Message: "unknown tool: " + params.Name}
+	}
+
+	if err != nil {
+		s.logger.Error("mcp tool failed", "tool", params.Name, "org_id", orgID, "error", err)
+		return toolResult("The knowledge base request failed. Please try again.", true), nil
+	}
+	return toolResult(text, false), nil
+}
+
+func (s *Server) search(ctx context.Context, orgID string, args toolArgs) (string, error) {
+	mode, err := retrieval.ParseSearchMode(args.SearchMode)
+	if err != nil {
+		return "", err
+	}
+	docs, degraded, err := s.rag.Retrieve(ctx, retrieval.QueryRequest{
+		OrgID: orgID, Question: args.Query, TopK: args.TopK, SearchMode: mode,
+		MaxChunksPerDocument: max(args.MaxPerDoc, 0),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "No matching passages found.", nil
+	}
+
+	var sb strings.Builder
+	if degraded != "" {
+		fmt.Fprintf(&sb, "Note: search ran out of time (%s); these passages may be incomplete.\n\n", degraded)
+	}
+	for i, doc := range docs {
+		docName, _ := doc.Metadata["doc_name"].(string)
+		docID, _ := doc.Metadata["document_id"].(string)
+		fmt.Fprintf(&sb, "[%d] %s (document_id: %s, score: %.3f)\n", i+1, docName, docID, doc.Score)
+		if terms, _ := doc.Metadata[retrieval.MetaMatchedTerms].([]string); len(terms) > 0 {
+			fmt.Fprintf(&sb, "matched terms: %s\n", strings.Join(terms, ", "))
+		}
+		fmt.Fprintf(&sb, "%s\n\n", doc.PageContent)
+	}
+	return sb.String(), nil
+}
+
+func (s *Server) ask(ctx context.Context, orgID string, args toolArgs) (string, error) {
+	out := make(chan string, 256)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.rag.Query(ctx, retrieval.QueryRequest{OrgID: orgID, Question: args.Question, TopK: args.TopK}, out)
+	}()
+
+	var sb strings.Builder
+	for token := range out {
+		sb.WriteString(token)
+	}
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (s *Server) customerContext(ctx context.Context, orgID string, args toolArgs) (string, error) {
+	customers, err := s.crm.LookupCustomer(ctx, orgID, args.Email)
+	if err != nil {
+		return "", err
+	}
+	if len(customers) == 0 {
+		return "No customer found with that email.", nil
+	}
+
+	blocks := make([]string, len(customers))
+	for i, c := range customers {
+		blocks[i] = c.Render()
+	}
+	return strings.Join(blocks, "\n"), nil
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func keyFromRequest(r *http.Request) string {
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func writeRPC(w http.ResponseWriter, resp rpcResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
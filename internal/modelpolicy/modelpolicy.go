@@ -0,0 +1,152 @@
+// Package modelpolicy keeps each org's choice of LLM and embedding model.
+// The operator decides what can be chosen: the LLM catalog (LLM_MODELS)
+// and the embedding models offered (EMBEDDING_MODELS). An org's LLM model
+// is the default for its queries, which an assistant or the query itself
+// can still override; its embedding model embeds everything the org
+// stores and asks. A choice the operator has since withdrawn falls back to
+// the deployment's default.
+package modelpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+)
+
+// ErrUnknownModel is returned when an org chooses a model the operator
+// doesn't offer.
+var ErrUnknownModel = errors.New("model is not offered")
+
+// Settings are an org's model choices; empty keeps the default.
+type Settings struct {
+	LLMModel       string    `json:"llm_model"`
+	EmbeddingModel string    `json:"embedding_model"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// WithTx returns a repository that runs its statements in tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx}
+}
+
+// Get returns an org's settings, or nil if it has none.
+func (r *Repository) Get(ctx context.Context, orgID string) (*Settings, error) {
+	s := &Settings{}
+	err := r.db.QueryRow(ctx,
+		`SELECT llm_model, embedding_model, updated_at FROM model_settings WHERE org_id = $1`, orgID,
+	).Scan(&s.LLMModel, &s.EmbeddingModel, &s.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces an org's settings.
+func (r *Repository) Set(ctx context.Context, orgID string, s *Settings) error {
+	return r.db.QueryRow(ctx,
+		`INSERT INTO model_settings (org_id, llm_model, embedding_model) VALUES ($1, $2, $3)
+		 ON CONFLICT (org_id) DO UPDATE SET
+			 llm_model = EXCLUDED.llm_model,
+			 embedding_model = EXCLUDED.embedding_model,
+			 updated_at = NOW()
+		 RETURNING updated_at`,
+		orgID, s.LLMModel, s.EmbeddingModel,
+	).Scan(&s.UpdatedAt)
+}
+
+// SetEmbeddingModel changes an org's embedding model, keeping its other
+// choices.
+func (r *Repository) SetEmbeddingModel(ctx context.Context, orgID, model string) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO model_settings (org_id, embedding_model) VALUES ($1, $2)
+		 ON CONFLICT (org_id) DO UPDATE SET embedding_model = EXCLUDED.embedding_model, updated_at = NOW()`,
+		orgID, model)
+	return err
+}
+
+type Service struct {
+	repo       *Repository
+	llmModels  *llm.Catalog
+	embeddings *embedding.Router
+}
+
+func NewService(repo *Repository, llmModels *llm.Catalog, embeddings *embedding.Router) *Service {
+	return &Service{repo: repo, llmModels: llmModels, embeddings: embeddings}
+}
+
+// Settings returns an org's choices, empty when it has made none.
+func (s *Service) Settings(ctx context.Context, orgID string) (*Settings, error) {
+	st, err := s.repo.Get(ctx, orgID)
+	if err != nil || st != nil {
+		return st, err
+	}
+	return &Settings{}, nil
+}
+
+// Set replaces an org's choices. A changed embedding model applies to
+// everything embedded from now on; the org's existing vectors need a
+// reindex (see EmbeddingChanged).
+func (s *Service) Set(ctx context.Context, orgID string, st *Settings) error {
+	if st.LLMModel != "" {
+		if _, ok := s.llmModels.Resolve(st.LLMModel); !ok {
+			return fmt.Errorf("%w: LLM model %q", ErrUnknownModel, st.LLMModel)
+		}
+	}
+	if st.EmbeddingModel != "" && !s.embeddings.Offers(st.EmbeddingModel) {
+		return fmt.Errorf("%w: embedding model %q", ErrUnknownModel, st.EmbeddingModel)
+	}
+	return s.repo.Set(ctx, orgID, st)
+}
+
+// EmbeddingChanged reports whether moving from prev to next changes the
+// model the org's text is embedded with.
+func (s *Service) EmbeddingChanged(prev, next *Settings) bool {
+	return s.embeddings.Resolve(prev.EmbeddingModel) != s.embeddings.Resolve(next.EmbeddingModel)
+}
+
+// LLMModel returns the model a query of orgID runs with when it asks for
+// requested: requested itself, else the org's choice, else "" for the
+// default. It implements retrieval.ModelPolicy.
+func (s *Service) LLMModel(ctx context.Context, orgID, requested string) (string, error) {
+	if requested != "" {
+		return requested, nil
+	}
+	st, err := s.repo.Get(ctx, orgID)
+	if err != nil || st == nil || st.LLMModel == "" {
+		return "", err
+	}
+	if _, ok := s.llmModels.Resolve(st.LLMModel); !ok {
+		slog.Warn("LLM model no longer offered, using the default", "org_id", orgID, "model", st.LLMModel)
+		return "", nil
+	}
+	return st.LLMModel, nil
+}
+
+// EmbeddingModel returns the embedding model orgID chose, "" for the
+// default. It implements embedding.ModelSource.
+func (s *Service) EmbeddingModel(ctx context.Context, orgID string) (string, error) {
+	st, err := s.repo.Get(ctx, orgID)
+	if err != nil || st == nil {
+		return "", err
+	}
+	return st.EmbeddingModel, nil
+}
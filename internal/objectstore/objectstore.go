@@ -0,0 +1,228 @@
+// Package objectstore talks to S3-compatible object storage (AWS S3, MinIO,
+// Cloudflare R2, GCS in interoperability mode) with no SDK: every request,
+// ours or a client's, is made on a URL presigned with AWS Signature Version
+// 4, so secrets never leave the server and a client can upload straight to
+// the bucket.
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for a key with no object.
+var ErrNotFound = errors.New("object not found")
+
+// maxPresignExpiry is the longest validity S3 accepts for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// Config locates a bucket and the credentials to sign for it.
+type Config struct {
+	// Endpoint is the storage's base URL, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://minio:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as a path (endpoint/bucket/key), as
+	// MinIO and most self-hosted stores expect, instead of as a subdomain
+	// (bucket.endpoint/key).
+	PathStyle bool
+}
+
+// Store is one bucket.
+type Store struct {
+	cfg      Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// New validates cfg. A nil client gets one with a 30-minute timeout, enough
+// to read a large object.
+func New(cfg Config, client *http.Client) (*Store, error) {
+	u, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid object storage endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("object storage needs a bucket, an access key and a secret key")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Minute}
+	}
+	return &Store{cfg: cfg, endpoint: u, client: client, now: time.Now}, nil
+}
+
+// Info describes a stored object.
+type Info struct {
+	Size        int64
+	ContentType string
+}
+
+// PresignPut returns a URL a client can PUT the object for key to until it
+// expires, without credentials of its own.
+func (s *Store) PresignPut(key string, expires time.Duration) string {
+	return s.presign(http.MethodPut, key, expires)
+}
+
+// Stat returns the object's size and content type.
+func (s *Store) Stat(ctx context.Context, key string) (Info, error) {
+	resp, err := s.do(ctx, http.MethodHead, key)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	return info(resp), nil
+}
+
+// Open streams the object; the caller closes it.
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	resp, err := s.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return resp.Body, info(resp), nil
+}
+
+// Delete removes the object; a missing object is not an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a presigned request and turns error statuses into errors.
+func (s *Store) do(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.presign(method, key, 15*time.Minute), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("object storage %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func info(resp *http.Response) Info {
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return Info{Size: size, ContentType: resp.Header.Get("Content-Type")}
+}
+
+// presign signs method on key with query-string authentication (SigV4),
+// leaving the payload unsigned so the URL works for any body.
+func (s *Store) presign(method, key string, expires time.Duration) string {
+	expires = min(max(expires, time.Second), maxPresignExpiry)
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	host, path := s.endpoint.Host, "/"+key
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket + path
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+	path = s.endpoint.Path + path
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.cfg.AccessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       strconv.Itoa(int(expires.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQuery(query)
+	canonical := strings.Join([]string{
+		method,
+		encodePath(path),
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + query["X-Amz-Date"] + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	k = hmacSHA256(k, s.cfg.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	return s.endpoint.Scheme + "://" + host + encodePath(path) + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes parameters as SigV4 requires.
+func canonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = encode(k, true) + "=" + encode(params[k], true)
+	}
+	return strings.Join(parts, "&")
+}
+
+// encodePath percent-encodes a path, keeping its slashes.
+func encodePath(path string) string {
+	return encode(path, false)
+}
+
+// encode percent-encodes everything but RFC 3986 unreserved characters,
+// and slashes unless encodeSlash.
+func encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
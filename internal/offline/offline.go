@@ -0,0 +1,84 @@
+// Package offline enforces the air-gapped deployment profile (OFFLINE_MODE).
+// In that profile every outbound call must stay on the local network: the
+// server refuses to boot if a configured endpoint resolves to a public
+// address, and tenant-configured integrations (connectors, CRMs) go through
+// an HTTP client that can only dial internal addresses.
+package offline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Endpoint is an outbound URL a component is configured to call.
+type Endpoint struct {
+	Component string
+	URL       string
+}
+
+// IsInternal reports whether ip is on the local machine or a private
+// network.
+func IsInternal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// Check resolves every endpoint's host and fails if any of them resolves to
+// a public address (or can't be resolved). All violations are reported at
+// once so operators can fix the config in one pass.
+func Check(ctx context.Context, endpoints []Endpoint) error {
+	var errs []error
+	for _, ep := range endpoints {
+		if err := checkEndpoint(ctx, ep.URL); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", ep.Component, ep.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkEndpoint(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return errors.New("not a valid URL")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve host: %w", err)
+	}
+	for _, a := range addrs {
+		if !IsInternal(a.IP) {
+			return fmt.Errorf("resolves to external address %s", a.IP)
+		}
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that refuses to connect to anything but
+// internal addresses. The check runs on the resolved address at dial time,
+// so redirects and DNS names pointing outward are caught too.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 10 * time.Second,
+				Control: func(_, address string, _ syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					if ip := net.ParseIP(host); ip == nil || !IsInternal(ip) {
+						return fmt.Errorf("offline mode: refusing to connect to external address %s", host)
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,276 @@
+// Package orgmerge consolidates two tenants into one. Customers who
+// accidentally registered twice end up with documents and users split
+// across orgs; a merge moves everything from the source org into the
+// target and removes the empty source.
+package orgmerge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+var (
+	ErrSameOrg     = errors.New("source and target org must differ")
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrIsolated is returned when either org keeps its content in its own
+	// schema or database (tenant_placements); merging only moves rows
+	// within the shared tables.
+	ErrIsolated = errors.New("organizations with isolated storage can't be merged")
+)
+
+// Report describes what a merge moved (or, for a dry run, would move).
+type Report struct {
+	SourceOrgID string `json:"source_org_id"`
+	TargetOrgID string `json:"target_org_id"`
+	Users       int64  `json:"users"`
+	Documents   int64  `json:"documents"`
+	Vectors     int64  `json:"vectors"`
+	DryRun      bool   `json:"dry_run"`
+}
+
+type Merger struct {
+	uow    *database.UnitOfWork
+	schema *database.Schema
+}
+
+func NewMerger(uow *database.UnitOfWork, schema *database.Schema) *Merger {
+	return &Merger{uow: uow, schema: schema}
+}
+
+// Merge moves users, documents and their vectors from sourceID into
+// targetID in a single transaction, then deletes the source org. Vector
+// chunks carry org_id in their metadata, so it is rewritten in place
+// rather than re-embedding anything. With dryRun every statement runs and
+// is then rolled back, so the report reflects exactly what would change.
+func (m *Merger) Merge(ctx context.Context, sourceID, targetID string, dryRun bool) (*Report, error) {
+	if sourceID == targetID {
+		return nil, ErrSameOrg
+	}
+
+	rep := &Report{SourceOrgID: sourceID, TargetOrgID: targetID, DryRun: dryRun}
+	err := m.uow.Do(ctx, func(tx pgx.Tx) error {
+		// Lock both orgs so nothing registers into the source mid-merge.
+		var found int
+		if err := tx.QueryRow(ctx,
+			`SELECT count(*) FROM (SELECT id FROM organizations WHERE id IN ($1, $2) FOR UPDATE) o`,
+			sourceID, targetID,
+		).Scan(&found); err != nil {
+			return err
+		}
+		if found != 2 {
+			return ErrOrgNotFound
+		}
+		var isolated bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM tenant_placements WHERE org_id IN ($1, $2))`,
+			sourceID, targetID,
+		).Scan(&isolated); err != nil {
+			return err
+		}
+		if isolated {
+			return ErrIsolated
+		}
+
+		tag, err := tx.Exec(ctx, `UPDATE users SET org_id = $1 WHERE org_id = $2`, targetID, sourceID)
+		if err != nil {
+			return fmt.Errorf("move users: %w", err)
+		}
+		rep.Users = tag.RowsAffected()
+
+		tag, err = tx.Exec(ctx,
+			`UPDATE documents SET org_id = $1, `+m.schema.Assign("documents", "updated_at", "NOW()")+` WHERE org_id = $2`,
+			targetID, sourceID)
+		if err != nil {
+			return fmt.Errorf("move documents: %w", err)
+		}
+		rep.Documents = tag.RowsAffected()
+
+		// Queued ingestion and pending embedding batches follow their
+		// documents.
+		if _, err := tx.Exec(ctx,
+			`UPDATE ingest_jobs SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move ingest jobs: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE embedding_batches SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move embedding batches: %w", err)
+		}
+
+		// This month's metered usage counts against the target's quota; the
+		// source's own quota goes away with it.
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO usage_counters (org_id, period, embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd)
+			 SELECT $1, period, embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd
+			 FROM usage_counters WHERE org_id = $2
+			 ON CONFLICT (org_id, period) DO UPDATE SET
+				 embedding_tokens = usage_counters.embedding_tokens + EXCLUDED.embedding_tokens,
+				 prompt_tokens = usage_counters.prompt_tokens + EXCLUDED.prompt_tokens,
+				 completion_tokens = usage_counters.completion_tokens + EXCLUDED.completion_tokens,
+				 queries = usage_counters.queries + EXCLUDED.queries,
+				 spend_usd = usage_counters.spend_usd + EXCLUDED.spend_usd,
+				 updated_at = NOW()`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("merge usage: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM usage_counters WHERE org_id = $1`, sourceID); err != nil {
+			return fmt.Errorf("drop source usage: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO usage_attribution
+				 (org_id, period, actor, workspace_id, embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd)
+			 SELECT $1, period, actor, workspace_id, embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd
+			 FROM usage_attribution WHERE org_id = $2
+			 ON CONFLICT (org_id, period, actor, workspace_id) DO UPDATE SET
+				 embedding_tokens = usage_attribution.embedding_tokens + EXCLUDED.embedding_tokens,
+				 prompt_tokens = usage_attribution.prompt_tokens + EXCLUDED.prompt_tokens,
+				 completion_tokens = usage_attribution.completion_tokens + EXCLUDED.completion_tokens,
+				 queries = usage_attribution.queries + EXCLUDED.queries,
+				 spend_usd = usage_attribution.spend_usd + EXCLUDED.spend_usd,
+				 updated_at = NOW()`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("merge usage attribution: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM usage_attribution WHERE org_id = $1`, sourceID); err != nil {
+			return fmt.Errorf("drop source usage attribution: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM org_quotas WHERE org_id = $1`, sourceID); err != nil {
+			return fmt.Errorf("drop source quota: %w", err)
+		}
+		// Prepaid credits and their history carry over to the target.
+		if _, err := tx.Exec(ctx,
+			`UPDATE credit_grants SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move credit grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE credit_transactions SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move credit transactions: %w", err)
+		}
+		// Statements stay as issued, kept apart from the target's own by
+		// the org they were issued to.
+		if _, err := tx.Exec(ctx,
+			`UPDATE usage_statements
+			 SET org_id = $1, merged_from = CASE WHEN merged_from = '' THEN $2 ELSE merged_from END
+			 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move usage statements: %w", err)
+		}
+
+		// The vectors join the target's collection, which its searches
+		// are confined to.
+		collection, err := retrieval.ActiveCollection(ctx, tx, targetID)
+		if err != nil {
+			return fmt.Errorf("read target collection: %w", err)
+		}
+		tag, err = tx.Exec(ctx, fmt.Sprintf(
+			`UPDATE %s e
+			 SET cmetadata = jsonb_set(e.cmetadata::jsonb, '{org_id}', to_jsonb($1::text))::json, collection_id = c.uuid
+			 FROM %s c
+			 WHERE c.name = $3 AND e.cmetadata->>'org_id' = $2`, retrieval.EmbeddingTable, retrieval.CollectionTable),
+			targetID, sourceID, collection,
+		)
+		if err != nil {
+			return fmt.Errorf("rewrite vector metadata: %w", err)
+		}
+		rep.Vectors = tag.RowsAffected()
+		if err := retrieval.ForgetCollections(ctx, tx, sourceID); err != nil {
+			return err
+		}
+
+		// Shares between the two orgs become meaningless once they are one;
+		// shares with third parties follow the source org's side.
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM document_grants
+			 WHERE (owner_org_id = $1 AND grantee_org_id = $2) OR (owner_org_id = $2 AND grantee_org_id = $1)`,
+			sourceID, targetID,
+		); err != nil {
+			return fmt.Errorf("drop internal grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE document_grants SET owner_org_id = $1 WHERE owner_org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move outgoing grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE document_grants SET grantee_org_id = $1 WHERE grantee_org_id = $2
+			 AND document_id NOT IN (SELECT document_id FROM document_grants WHERE grantee_org_id = $1)`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("move incoming grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM collection_grants
+			 WHERE (owner_org_id = $1 AND grantee_org_id = $2) OR (owner_org_id = $2 AND grantee_org_id = $1)`,
+			sourceID, targetID,
+		); err != nil {
+			return fmt.Errorf("drop internal collection grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE collection_grants SET owner_org_id = $1 WHERE owner_org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move outgoing collection grants: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE collection_grants SET grantee_org_id = $1 WHERE grantee_org_id = $2
+			 AND collection_id NOT IN (SELECT collection_id FROM collection_grants WHERE grantee_org_id = $1)`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("move incoming collection grants: %w", err)
+		}
+
+		// Connectors keep syncing into the merged org. Their item mappings
+		// point at documents that just moved, so they stay valid.
+		if _, err := tx.Exec(ctx,
+			`UPDATE connectors SET org_id = $1, name = name || ' (merged)'
+			 WHERE org_id = $2 AND name IN (SELECT name FROM connectors WHERE org_id = $1)`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("rename clashing connectors: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE connectors SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move connectors: %w", err)
+		}
+		// So do the GitHub App installations they sync through.
+		if _, err := tx.Exec(ctx,
+			`UPDATE github_installations SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move github installations: %w", err)
+		}
+
+		// Conversations stay with their users, who moved above.
+		if _, err := tx.Exec(ctx,
+			`UPDATE conversations SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move conversations: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE query_log SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move query log: %w", err)
+		}
+
+		// Collections keep their documents, which moved along.
+		if _, err := tx.Exec(ctx,
+			`UPDATE collections SET org_id = $1, name = name || ' (merged)'
+			 WHERE org_id = $2 AND name IN (SELECT name FROM collections WHERE org_id = $1)`,
+			targetID, sourceID); err != nil {
+			return fmt.Errorf("rename clashing collections: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE collections SET org_id = $1 WHERE org_id = $2`, targetID, sourceID); err != nil {
+			return fmt.Errorf("move collections: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, sourceID); err != nil {
+			return fmt.Errorf("delete source org: %w", err)
+		}
+
+		if dryRun {
+			return database.ErrRollback
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
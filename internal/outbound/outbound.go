@@ -0,0 +1,188 @@
+// Package outbound shapes how calls to model providers leave the server:
+// through a corporate HTTP(S) proxy, trusting a private CA bundle, and
+// with extra headers that some API gateways require. On-prem deployments
+// that reach the internet only through an authenticated proxy configure it
+// once here, under every provider client, rather than per provider.
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrInvalid is returned for a proxy, CA bundle or header that can't be
+// used.
+var ErrInvalid = errors.New("invalid outbound setting")
+
+// Config describes the route out. The zero value behaves like
+// http.DefaultTransport.
+type Config struct {
+	// ProxyURL is the http:// or https:// proxy calls go through;
+	// credentials in it are sent as Proxy-Authorization. Empty follows
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+	ProxyURL string
+	// NoProxy lists the hosts reached directly rather than through
+	// ProxyURL: names (their subdomains too), IPs, CIDR ranges, or "*".
+	// Loopback addresses are always reached directly.
+	NoProxy []string
+	// CAFile is a PEM bundle trusted on top of the system roots, such as
+	// the CA of a proxy that inspects TLS.
+	CAFile string
+	// Headers are added to every call, unless the client sets the header
+	// itself.
+	Headers http.Header
+}
+
+// Redacted returns the proxy URL without its password, for logs and
+// errors.
+func (c Config) Redacted() string {
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return "(unparseable)"
+	}
+	return u.Redacted()
+}
+
+// NewTransport returns a transport carrying calls out as cfg describes.
+func NewTransport(cfg Config) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.ProxyURL != "" {
+		proxy, err := url.Parse(cfg.ProxyURL)
+		if err != nil || (proxy.Scheme != "http" && proxy.Scheme != "https") || proxy.Host == "" {
+			return nil, fmt.Errorf("%w: proxy %s must be an http:// or https:// URL", ErrInvalid, cfg.Redacted())
+		}
+		direct, err := directMatcher(cfg.NoProxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			if direct(req.URL.Hostname()) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: read CA bundle: %w", ErrInvalid, err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in CA bundle %s", ErrInvalid, cfg.CAFile)
+		}
+		t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots}
+	}
+
+	if len(cfg.Headers) == 0 {
+		return t, nil
+	}
+	return &headerTransport{next: t, headers: cfg.Headers}, nil
+}
+
+// directMatcher returns whether a host is reached without the proxy.
+func directMatcher(noProxy []string) (func(host string) bool, error) {
+	var (
+		all   bool
+		ips   []net.IP
+		nets  []*net.IPNet
+		names []string
+	)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			all = true
+		case strings.Contains(entry, "/"):
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: no-proxy range %q", ErrInvalid, entry)
+			}
+			nets = append(nets, n)
+		case net.ParseIP(entry) != nil:
+			ips = append(ips, net.ParseIP(entry))
+		default:
+			names = append(names, strings.TrimPrefix(entry, "."))
+		}
+	}
+	return func(host string) bool {
+		host = strings.ToLower(host)
+		if all || host == "localhost" {
+			return true
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.IsLoopback() {
+				return true
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			for _, i := range ips {
+				if i.Equal(ip) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, name := range names {
+			if host == name || strings.HasSuffix(host, "."+name) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// ParseHeaders reads headers written as comma-separated Name=value
+// pairs, as in PROVIDER_HEADERS.
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t:\r\n") {
+			return nil, fmt.Errorf("%w: header %q (want Name=value)", ErrInvalid, name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%w: header %s has a line break", ErrInvalid, name)
+		}
+		headers.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// headerTransport adds headers to each request.
+type headerTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = slices.Clone(values)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
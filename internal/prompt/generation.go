@@ -0,0 +1,143 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+)
+
+// Generation settings
+//
+// A query can tune how its answer is sampled (temperature, top_p,
+// max_tokens, stop sequences). An org's settings give defaults for what a
+// query leaves unset and caps on what it may ask for: a larger max_tokens
+// or temperature is lowered to the cap, and with a max_tokens cap every
+// answer is bounded by it even when neither sets max_tokens.
+
+// ErrNoGenerationSettings is returned when an org without settings
+// deletes them.
+var ErrNoGenerationSettings = errors.New("no generation settings are set")
+
+// GenerationSettings are an org's generation defaults and caps.
+type GenerationSettings struct {
+	Defaults llm.Generation `json:"defaults"`
+	// MaxTokensCap bounds max_tokens; 0 means no cap.
+	MaxTokensCap int `json:"max_tokens_cap"`
+	// MaxTemperature bounds temperature; nil means no cap.
+	MaxTemperature *float64  `json:"max_temperature"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (g *GenerationSettings) validate() error {
+	if err := g.Defaults.Validate(); err != nil {
+		return fmt.Errorf("%w: defaults: %w", ErrInvalid, err)
+	}
+	if g.MaxTokensCap < 0 {
+		return fmt.Errorf("%w: max_tokens_cap must not be negative", ErrInvalid)
+	}
+	if t := g.MaxTemperature; t != nil && (*t < 0 || *t > 2) {
+		return fmt.Errorf("%w: max_temperature must be between 0 and 2", ErrInvalid)
+	}
+	if g.MaxTokensCap > 0 && g.Defaults.MaxTokens > g.MaxTokensCap {
+		return fmt.Errorf("%w: the default max_tokens is above max_tokens_cap", ErrInvalid)
+	}
+	if g.MaxTemperature != nil && g.Defaults.Temperature != nil && *g.Defaults.Temperature > *g.MaxTemperature {
+		return fmt.Errorf("%w: the default temperature is above max_temperature", ErrInvalid)
+	}
+	return nil
+}
+
+// apply fills in what requested leaves unset from the defaults and lowers
+// it to the caps.
+func (g *GenerationSettings) apply(requested llm.Generation) llm.Generation {
+	out := requested.Or(g.Defaults)
+	if g.MaxTokensCap > 0 && (out.MaxTokens == 0 || out.MaxTokens > g.MaxTokensCap) {
+		out.MaxTokens = g.MaxTokensCap
+	}
+	if g.MaxTemperature != nil && out.Temperature != nil && *out.Temperature > *g.MaxTemperature {
+		out.Temperature = g.MaxTemperature
+	}
+	return out
+}
+
+// GenerationSettings returns an org's settings, or nil if it has none.
+func (r *Repository) GenerationSettings(ctx context.Context, orgID string) (*GenerationSettings, error) {
+	g := &GenerationSettings{}
+	err := r.db.QueryRow(ctx,
+		`SELECT temperature, top_p, max_tokens, stop, max_tokens_cap, max_temperature, updated_at
+		 FROM generation_settings WHERE org_id = $1`, orgID,
+	).Scan(&g.Defaults.Temperature, &g.Defaults.TopP, &g.Defaults.MaxTokens, &g.Defaults.Stop,
+		&g.MaxTokensCap, &g.MaxTemperature, &g.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetGenerationSettings replaces an org's settings.
+func (r *Repository) SetGenerationSettings(ctx context.Context, orgID string, g *GenerationSettings) error {
+	stop := g.Defaults.Stop
+	if stop == nil {
+		stop = []string{}
+	}
+	return r.db.QueryRow(ctx,
+		`INSERT INTO generation_settings (org_id, temperature, top_p, max_tokens, stop, max_tokens_cap, max_temperature)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (org_id) DO UPDATE SET
+			 temperature = EXCLUDED.temperature,
+			 top_p = EXCLUDED.top_p,
+			 max_tokens = EXCLUDED.max_tokens,
+			 stop = EXCLUDED.stop,
+			 max_tokens_cap = EXCLUDED.max_tokens_cap,
+			 max_temperature = EXCLUDED.max_temperature,
+			 updated_at = NOW()
+		 RETURNING updated_at`,
+		orgID, g.Defaults.Temperature, g.Defaults.TopP, g.Defaults.MaxTokens, stop, g.MaxTokensCap, g.MaxTemperature,
+	).Scan(&g.UpdatedAt)
+}
+
+func (r *Repository) DeleteGenerationSettings(ctx context.Context, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM generation_settings WHERE org_id = $1`, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoGenerationSettings
+	}
+	return nil
+}
+
+// GenerationSettings returns an org's settings, or nil if it has none.
+func (s *Service) GenerationSettings(ctx context.Context, orgID string) (*GenerationSettings, error) {
+	return s.repo.GenerationSettings(ctx, orgID)
+}
+
+// SetGenerationSettings replaces an org's settings.
+func (s *Service) SetGenerationSettings(ctx context.Context, orgID string, g *GenerationSettings) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	return s.repo.SetGenerationSettings(ctx, orgID, g)
+}
+
+// DeleteGenerationSettings drops an org's defaults and caps.
+func (s *Service) DeleteGenerationSettings(ctx context.Context, orgID string) error {
+	return s.repo.DeleteGenerationSettings(ctx, orgID)
+}
+
+// Generation returns the parameters a query of orgID runs with when it
+// asks for requested. It implements retrieval.GenerationPolicy.
+func (s *Service) Generation(ctx context.Context, orgID string, requested llm.Generation) (llm.Generation, error) {
+	g, err := s.repo.GenerationSettings(ctx, orgID)
+	if err != nil || g == nil {
+		return requested, err
+	}
+	return g.apply(requested), nil
+}
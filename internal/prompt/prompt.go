@@ -0,0 +1,326 @@
+// Package prompt manages per-org prompt templates: an org's own wording of
+// the system and user messages sent to the LLM, replacing the built-in
+// persona and grounding rules. An org can keep several templates; the one
+// marked active is used for all its queries.
+//
+// Templates are plain text with {{variable}} placeholders:
+//
+//	{{tenant_name}}   the org's name (system and user)
+//	{{persona}}       the querying assistant's system prompt, or the default
+//	                  persona (system only)
+//	{{context}}       the retrieved chunks (user only, required there)
+//	{{question}}      the user's question (user only, required there)
+//	{{conversation}}  the replayed conversation, empty without one (user only)
+//
+// An empty system or user template keeps the built-in one.
+//
+// A template can be made specific to an intent (see internal/querylog):
+// questions classified as that intent use the intent's active template,
+// and other questions the active template without intent.
+//
+// The package also keeps each org's generation settings: defaults and caps
+// for the sampling parameters of its queries (see GenerationSettings).
+package prompt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/querylog"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+var (
+	ErrNotFound      = errors.New("prompt template not found")
+	ErrDuplicateName = errors.New("a prompt template with that name already exists")
+	ErrInvalid       = errors.New("invalid prompt template")
+)
+
+// maxTemplateChars bounds each template; it is sent with every query.
+const maxTemplateChars = 8000
+
+var (
+	systemVariables = []string{"tenant_name", "persona"}
+	userVariables   = []string{"tenant_name", "context", "question", "conversation"}
+)
+
+type Template struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	Name      string    `json:"name"`
+	System    string    `json:"system"`
+	User      string    `json:"user"`
+	Intent    string    `json:"intent"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx}
+}
+
+const templateColumns = `id, org_id, name, system_template, user_template, intent, active, created_at, updated_at`
+
+func scanTemplate(row pgx.Row) (*Template, error) {
+	t := &Template{}
+	err := row.Scan(&t.ID, &t.OrgID, &t.Name, &t.System, &t.User, &t.Intent, &t.Active, &t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (r *Repository) Create(ctx context.Context, t *Template) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO prompt_templates (`+templateColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		t.ID, t.OrgID, t.Name, t.System, t.User, t.Intent, t.Active, t.CreatedAt, t.UpdatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Template, error) {
+	return scanTemplate(r.db.QueryRow(ctx,
+		`SELECT `+templateColumns+` FROM prompt_templates WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Template, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+templateColumns+` FROM prompt_templates WHERE org_id = $1 ORDER BY name`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Template, error) {
+		return scanTemplate(row)
+	})
+}
+
+func (r *Repository) Update(ctx context.Context, t *Template) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE prompt_templates SET name = $1, system_template = $2, user_template = $3, intent = $4, active = $5, updated_at = $6
+		 WHERE id = $7 AND org_id = $8`,
+		t.Name, t.System, t.User, t.Intent, t.Active, t.UpdatedAt, t.ID, t.OrgID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM prompt_templates WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deactivateOthers clears the active flag of the org's templates for
+// intent but id.
+func (r *Repository) deactivateOthers(ctx context.Context, id, orgID, intent string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE prompt_templates SET active = FALSE WHERE org_id = $1 AND intent = $2 AND id <> $3 AND active`,
+		orgID, intent, id)
+	return err
+}
+
+// active returns the org's active template for intent, else its active
+// template without intent, and the org's name, or ErrNotFound when it has
+// neither.
+func (r *Repository) active(ctx context.Context, orgID, intent string) (system, user, orgName string, err error) {
+	err = r.db.QueryRow(ctx,
+		`SELECT t.system_template, t.user_template, o.name
+		 FROM prompt_templates t JOIN organizations o ON o.id = t.org_id
+		 WHERE t.org_id = $1 AND t.active AND t.intent IN ($2, '')
+		 ORDER BY t.intent = '' LIMIT 1`, orgID, intent,
+	).Scan(&system, &user, &orgName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", "", ErrNotFound
+	}
+	return system, user, orgName, err
+}
+
+type Service struct {
+	repo *Repository
+	uow  *database.UnitOfWork
+}
+
+func NewService(repo *Repository, uow *database.UnitOfWork) *Service {
+	return &Service{repo: repo, uow: uow}
+}
+
+// Input is the writable part of a template, used for create and update.
+// Making a template active deactivates the org's other templates for the
+// same intent.
+type Input struct {
+	Name   string `json:"name"`
+	System string `json:"system"`
+	User   string `json:"user"`
+	// Intent limits the template to questions of one intent; empty
+	// applies to all.
+	Intent string `json:"intent"`
+	Active bool   `json:"active"`
+}
+
+func (in Input) validate() error {
+	if in.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if in.Intent != "" {
+		if err := querylog.CheckIntent(in.Intent); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	if in.System == "" && in.User == "" {
+		return fmt.Errorf("%w: set a system or a user template", ErrInvalid)
+	}
+	if err := checkVariables("system", in.System, systemVariables); err != nil {
+		return err
+	}
+	if err := checkVariables("user", in.User, userVariables); err != nil {
+		return err
+	}
+	if in.User != "" {
+		for _, name := range []string{"context", "question"} {
+			if !slices.Contains(retrieval.TemplateVariables(in.User), name) {
+				return fmt.Errorf("%w: the user template must include {{%s}}", ErrInvalid, name)
+			}
+		}
+	}
+	return nil
+}
+
+func checkVariables(which, text string, allowed []string) error {
+	if len(text) > maxTemplateChars {
+		return fmt.Errorf("%w: the %s template is longer than %d characters", ErrInvalid, which, maxTemplateChars)
+	}
+	for _, name := range retrieval.TemplateVariables(text) {
+		if !slices.Contains(allowed, name) {
+			return fmt.Errorf("%w: {{%s}} can't be used in the %s template (allowed: %s)",
+				ErrInvalid, name, which, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+func (s *Service) Create(ctx context.Context, orgID string, in Input) (*Template, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t := &Template{
+		ID:        uuid.NewString(),
+		OrgID:     orgID,
+		Name:      in.Name,
+		System:    in.System,
+		User:      in.User,
+		Intent:    in.Intent,
+		Active:    in.Active,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if t.Active {
+			if err := repo.deactivateOthers(ctx, t.ID, orgID, t.Intent); err != nil {
+				return err
+			}
+		}
+		return repo.Create(ctx, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Service) Get(ctx context.Context, id, orgID string) (*Template, error) {
+	return s.repo.Get(ctx, id, orgID)
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Template, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+// Update replaces the template with in.
+func (s *Service) Update(ctx context.Context, id, orgID string, in Input) (*Template, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	var t *Template
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		var err error
+		if t, err = repo.Get(ctx, id, orgID); err != nil {
+			return err
+		}
+		t.Name, t.System, t.User, t.Intent, t.Active = in.Name, in.System, in.User, in.Intent, in.Active
+		t.UpdatedAt = time.Now()
+		if t.Active {
+			if err := repo.deactivateOthers(ctx, id, orgID, t.Intent); err != nil {
+				return err
+			}
+		}
+		return repo.Update(ctx, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id, orgID string) error {
+	return s.repo.Delete(ctx, id, orgID)
+}
+
+// PromptTemplate returns the org's active template for intent, falling
+// back to the one for all intents, or nil when the org uses the built-in
+// prompt. It implements retrieval.PromptSource.
+func (s *Service) PromptTemplate(ctx context.Context, orgID, intent string) (*retrieval.PromptTemplate, error) {
+	system, user, orgName, err := s.repo.active(ctx, orgID, intent)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &retrieval.PromptTemplate{System: system, User: user, TenantName: orgName}, nil
+}
@@ -0,0 +1,276 @@
+// Package publickb manages public knowledge bases: tokens that let
+// anonymous visitors query an org's documents read-only, e.g. to power
+// search or chat on a public docs site from the same index. A site only
+// answers from the collections and documents it is scoped to, never the
+// whole knowledge base.
+package publickb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound      = errors.New("public site not found")
+	ErrInvalidOrigin = errors.New("allowed origins must be scheme://host[:port] URLs")
+	// ErrUnscoped is returned for a site that names no collection or
+	// document to answer from.
+	ErrUnscoped = errors.New("a public site needs collection_ids or document_ids to answer from")
+)
+
+// tokenPrefix makes public tokens recognizable in logs and page source.
+const tokenPrefix = "pk_"
+
+type Site struct {
+	ID             string    `json:"id"`
+	OrgID          string    `json:"org_id"`
+	Name           string    `json:"name"`
+	Token          string    `json:"token"`
+	Enabled        bool      `json:"enabled"`
+	AllowedOrigins []string  `json:"allowed_origins"`
+	AssistantID    *string   `json:"assistant_id"` // nil: the org's default assistant
+	CreatedAt      time.Time `json:"created_at"`
+
+	// CollectionIDs and DocumentIDs are what the site answers from: the
+	// documents in those collections, or those documents, or the documents
+	// in both when the site names both.
+	CollectionIDs []string `json:"collection_ids"`
+	DocumentIDs   []string `json:"document_ids"`
+}
+
+// Scoped reports whether the site names anything to answer from.
+func (s *Site) Scoped() bool {
+	return len(s.CollectionIDs) > 0 || len(s.DocumentIDs) > 0
+}
+
+// AllowsOrigin reports whether a browser request from origin may use the
+// site. Requests without an Origin header (server-side callers) are always
+// allowed; browsers only from the allow-list, so an empty one admits none.
+func (s *Site) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	return slices.Contains(s.AllowedOrigins, strings.ToLower(origin))
+}
+
+// normalizeOrigins validates and canonicalizes allow-list entries so they
+// compare equal to browser Origin headers.
+func normalizeOrigins(origins []string) ([]string, error) {
+	out := make([]string, 0, len(origins))
+	for _, o := range origins {
+		u, err := url.Parse(strings.TrimSpace(o))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, ErrInvalidOrigin
+		}
+		out = append(out, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return out, nil
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const siteColumns = `id, org_id, name, token, enabled, allowed_origins, assistant_id, collection_ids, document_ids, created_at`
+
+func scanSite(row pgx.Row) (*Site, error) {
+	s := &Site{}
+	if err := row.Scan(&s.ID, &s.OrgID, &s.Name, &s.Token, &s.Enabled, &s.AllowedOrigins, &s.AssistantID,
+		&s.CollectionIDs, &s.DocumentIDs, &s.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *Repository) Create(ctx context.Context, s *Site) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO public_sites (`+siteColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		s.ID, s.OrgID, s.Name, s.Token, s.Enabled, s.AllowedOrigins, s.AssistantID,
+		s.CollectionIDs, s.DocumentIDs, s.CreatedAt,
+	)
+	return err
+}
+
+// FindByToken returns the site with a public token, unless its org is
+// suspended.
+func (r *Repository) FindByToken(ctx context.Context, token string) (*Site, error) {
+	return scanSite(r.db.QueryRow(ctx,
+		`SELECT `+siteColumns+` FROM public_sites
+		 WHERE token = $1
+		   AND NOT EXISTS (SELECT 1 FROM organizations o WHERE o.id = public_sites.org_id AND o.suspended_at IS NOT NULL)`, token))
+}
+
+func (r *Repository) ListByOrg(ctx context.Context, orgID string) ([]*Site, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+siteColumns+` FROM public_sites WHERE org_id = $1 ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Site, error) {
+		return scanSite(row)
+	})
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Site, error) {
+	return scanSite(r.db.QueryRow(ctx,
+		`SELECT `+siteColumns+` FROM public_sites WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+// Update applies the non-nil fields of upd.
+func (r *Repository) Update(ctx context.Context, id, orgID string, upd SiteUpdate) (*Site, error) {
+	return scanSite(r.db.QueryRow(ctx,
+		`UPDATE public_sites
+		 SET enabled = COALESCE($1, enabled), allowed_origins = COALESCE($2, allowed_origins),
+		     collection_ids = COALESCE($3, collection_ids), document_ids = COALESCE($4, document_ids)
+		 WHERE id = $5 AND org_id = $6 RETURNING `+siteColumns,
+		upd.Enabled, upd.AllowedOrigins, upd.CollectionIDs, upd.DocumentIDs, id, orgID))
+}
+
+func (r *Repository) Delete(ctx context.Context, id, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM public_sites WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+type CreateSiteRequest struct {
+	Name           string   `json:"name"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AssistantID binds the site to an assistant profile, making its token
+	// that assistant's embed token. The caller must check org ownership.
+	AssistantID *string `json:"assistant_id"`
+	// CollectionIDs and DocumentIDs scope the site; at least one is
+	// required. The caller must check org ownership.
+	CollectionIDs []string `json:"collection_ids"`
+	DocumentIDs   []string `json:"document_ids"`
+}
+
+// SiteUpdate is a partial update; nil fields are left unchanged.
+type SiteUpdate struct {
+	Enabled        *bool    `json:"enabled"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	CollectionIDs  []string `json:"collection_ids"`
+	DocumentIDs    []string `json:"document_ids"`
+}
+
+func (s *Service) Create(ctx context.Context, orgID string, req CreateSiteRequest) (*Site, error) {
+	if len(req.CollectionIDs) == 0 && len(req.DocumentIDs) == 0 {
+		return nil, ErrUnscoped
+	}
+	origins, err := normalizeOrigins(req.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	site := &Site{
+		ID:             uuid.NewString(),
+		OrgID:          orgID,
+		Name:           req.Name,
+		Token:          token,
+		Enabled:        true,
+		AllowedOrigins: origins,
+		AssistantID:    req.AssistantID,
+		CollectionIDs:  nonNil(req.CollectionIDs),
+		DocumentIDs:    nonNil(req.DocumentIDs),
+		CreatedAt:      time.Now(),
+	}
+	if err := s.repo.Create(ctx, site); err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// Resolve returns the enabled site for a public token. Disabled, unknown
+// and suspended orgs' tokens are indistinguishable to the caller.
+func (s *Service) Resolve(ctx context.Context, token string) (*Site, error) {
+	site, err := s.repo.FindByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !site.Enabled {
+		return nil, ErrNotFound
+	}
+	return site, nil
+}
+
+func (s *Service) List(ctx context.Context, orgID string) ([]*Site, error) {
+	return s.repo.ListByOrg(ctx, orgID)
+}
+
+func (s *Service) Update(ctx context.Context, id, orgID string, upd SiteUpdate) (*Site, error) {
+	if upd.AllowedOrigins != nil {
+		origins, err := normalizeOrigins(upd.AllowedOrigins)
+		if err != nil {
+			return nil, err
+		}
+		upd.AllowedOrigins = origins
+	}
+	if upd.CollectionIDs != nil || upd.DocumentIDs != nil {
+		site, err := s.repo.Get(ctx, id, orgID)
+		if err != nil {
+			return nil, err
+		}
+		if upd.CollectionIDs != nil {
+			site.CollectionIDs = upd.CollectionIDs
+		}
+		if upd.DocumentIDs != nil {
+			site.DocumentIDs = upd.DocumentIDs
+		}
+		if !site.Scoped() {
+			return nil, ErrUnscoped
+		}
+	}
+	return s.repo.Update(ctx, id, orgID, upd)
+}
+
+// nonNil keeps an omitted list from becoming a NULL column.
+func nonNil(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
+
+func (s *Service) Delete(ctx context.Context, id, orgID string) error {
+	return s.repo.Delete(ctx, id, orgID)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,382 @@
+// Package querylog records the questions each org asks, tagged with their
+// intent: how-to, factual, troubleshooting or chit-chat. A cheap model
+// classifies each question while retrieval runs; the tag picks the org's
+// prompt template for that intent, if it has one, and powers analytics of
+// what the org's users ask.
+//
+// Answers are saved into the log as they stream (see AnswerSaver), so one
+// cut short by a server crash or a dropped client can be read back by
+// whoever asked. Entries are kept for a retention period (see RunPurge).
+package querylog
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Intents.
+const (
+	IntentHowTo           = "how_to"
+	IntentFactual         = "factual"
+	IntentTroubleshooting = "troubleshooting"
+	IntentChitChat        = retrieval.IntentChitChat
+)
+
+// Intents lists every intent a question can be tagged with.
+var Intents = []string{IntentHowTo, IntentFactual, IntentTroubleshooting, IntentChitChat}
+
+// Answer statuses. An entry whose answer isn't recorded has none.
+const (
+	StatusAnswering = "answering"
+	StatusAnswered  = "answered"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	// StatusInterrupted is reported for an answer left answering, without
+	// progress for staleAfter: the server answering it went down.
+	StatusInterrupted = "interrupted"
+)
+
+var (
+	// ErrUnknownIntent is returned for an intent not in Intents.
+	ErrUnknownIntent = errors.New("unknown intent")
+	ErrNotFound      = errors.New("query not found")
+)
+
+// CheckIntent returns ErrUnknownIntent unless intent is one of Intents.
+func CheckIntent(intent string) error {
+	if !slices.Contains(Intents, intent) {
+		return fmt.Errorf("%w %q (want one of %s)", ErrUnknownIntent, intent, strings.Join(Intents, ", "))
+	}
+	return nil
+}
+
+const classifierPrompt = `You classify the intent of questions asked to a knowledge base.
+how_to: asks how to do something, for steps or instructions
+factual: asks for a fact, a definition or a specific piece of information
+troubleshooting: reports a problem, an error or something not working
+chit_chat: a greeting, thanks or small talk rather than a question
+Reply with ONLY the intent name.`
+
+const (
+	// classifyTimeout bounds classification, which the prompt waits on.
+	classifyTimeout = 5 * time.Second
+	// maxClassifiedChars is how much of a question the model sees; the
+	// start says enough about its intent.
+	maxClassifiedChars = 1000
+)
+
+// Classifier tags questions with their intent using a cheap model.
+type Classifier struct {
+	llm   retrieval.LLMClient
+	model string
+}
+
+// NewClassifier creates a classifier asking model ("" for the client's
+// default) through llmClient.
+func NewClassifier(llmClient retrieval.LLMClient, model string) *Classifier {
+	return &Classifier{llm: llmClient, model: model}
+}
+
+// Classify returns question's intent, or "" when the model fails or its
+// answer isn't an intent; a query is never failed for it. It implements
+// retrieval.IntentClassifier.
+func (c *Classifier) Classify(ctx context.Context, question string) string {
+	ctx, cancel := context.WithTimeout(ctx, classifyTimeout)
+	defer cancel()
+	if r := []rune(question); len(r) > maxClassifiedChars {
+		question = string(r[:maxClassifiedChars])
+	}
+	zero := 0.0
+	answer, err := retrieval.Complete(ctx, c.llm, classifierPrompt, "Question: "+question, llm.CompletionOptions{
+		Model:      c.model,
+		Generation: llm.Generation{Temperature: &zero, MaxTokens: 8},
+	})
+	if err != nil {
+		slog.Warn("intent classification failed", "error", err)
+		return ""
+	}
+	intent := strings.ToLower(strings.Trim(strings.TrimSpace(answer), ".\"'`"))
+	intent = strings.NewReplacer("-", "_", " ", "_").Replace(intent)
+	if !slices.Contains(Intents, intent) {
+		slog.Warn("unparseable intent classification", "output", answer)
+		return ""
+	}
+	return intent
+}
+
+// Entry is one logged question.
+type Entry struct {
+	ID       string `json:"id"`
+	OrgID    string `json:"org_id"`
+	Actor    string `json:"actor"`
+	Question string `json:"question"`
+	// Intent is empty when the question wasn't classified.
+	Intent string `json:"intent"`
+	// Answer is what was generated so far, as of UpdatedAt.
+	Answer    string    `json:"answer"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IntentCount is how many questions had one intent.
+type IntentCount struct {
+	Intent string `json:"intent"`
+	Count  int    `json:"count"`
+}
+
+type Repository struct {
+	db database.DBTX
+}
+
+func NewRepository(db database.DBTX) *Repository {
+	return &Repository{db: db}
+}
+
+const entryColumns = `id, org_id, actor, question, intent, answer, status, created_at, updated_at`
+
+func scanEntry(row pgx.Row) (*Entry, error) {
+	e := &Entry{}
+	err := row.Scan(&e.ID, &e.OrgID, &e.Actor, &e.Question, &e.Intent, &e.Answer, &e.Status, &e.CreatedAt, &e.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+func (r *Repository) Create(ctx context.Context, e *Entry) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO query_log (`+entryColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.ID, e.OrgID, e.Actor, e.Question, e.Intent, e.Answer, e.Status, e.CreatedAt, e.UpdatedAt)
+	return err
+}
+
+func (r *Repository) Get(ctx context.Context, id, orgID string) (*Entry, error) {
+	return scanEntry(r.db.QueryRow(ctx,
+		`SELECT `+entryColumns+` FROM query_log WHERE id = $1 AND org_id = $2`, id, orgID))
+}
+
+// saveAnswer stores the answer of an entry so far, and its status.
+func (r *Repository) saveAnswer(ctx context.Context, id, orgID, answer, status string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE query_log SET answer = $1, status = $2, updated_at = NOW() WHERE id = $3 AND org_id = $4`,
+		answer, status, id, orgID)
+	return err
+}
+
+// List returns the org's latest entries, those with intent only unless
+// it is empty.
+func (r *Repository) List(ctx context.Context, orgID, intent string, limit int) ([]*Entry, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+entryColumns+` FROM query_log
+		 WHERE org_id = $1 AND ($2 = '' OR intent = $2)
+		 ORDER BY created_at DESC
+		 LIMIT $3`, orgID, intent, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Entry, error) {
+		return scanEntry(row)
+	})
+}
+
+// IntentCounts counts the org's questions per intent asked in [from, to),
+// most frequent first.
+func (r *Repository) IntentCounts(ctx context.Context, orgID string, from, to time.Time) ([]IntentCount, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT intent, count(*) FROM query_log
+		 WHERE org_id = $1 AND created_at >= $2 AND created_at < $3
+		 GROUP BY intent
+		 ORDER BY count(*) DESC, intent`, orgID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (IntentCount, error) {
+		var c IntentCount
+		err := row.Scan(&c.Intent, &c.Count)
+		return c, err
+	})
+}
+
+// purge deletes up to limit entries created before before, returning how
+// many it deleted.
+func (r *Repository) purge(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tag, err := r.db.Exec(ctx,
+		`DELETE FROM query_log WHERE id IN (
+			 SELECT id FROM query_log WHERE created_at < $1 LIMIT $2
+		 )`, before, limit)
+	return tag.RowsAffected(), err
+}
+
+const (
+	// maxListed caps the entries listed at once.
+	maxListed = 200
+	// saveEvery is how often a streaming answer is saved; a crash loses
+	// at most this much of it.
+	saveEvery = time.Second
+	// saveTimeout bounds one save, so a slow database can't hold up the
+	// stream for long.
+	saveTimeout = 2 * time.Second
+	// staleAfter is how long an answer may go unsaved while answering
+	// before it is reported interrupted.
+	staleAfter = 2 * time.Minute
+	// purgeBatch is how many entries one purge statement deletes, so a
+	// large backlog doesn't hold one long transaction.
+	purgeBatch = 10000
+)
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Record logs a question of orgID asked by the actor in ctx, under id if
+// given. Like metering, logging never fails the query, so errors are only
+// logged. It implements retrieval.QueryLog.
+func (s *Service) Record(ctx context.Context, id, orgID, question, intent string) {
+	now := time.Now()
+	e := &Entry{
+		ID:        cmp.Or(id, uuid.NewString()),
+		OrgID:     orgID,
+		Actor:     tenancy.ActorFrom(ctx),
+		Question:  question,
+		Intent:    intent,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.Create(context.WithoutCancel(ctx), e); err != nil {
+		slog.Error("record query failed", "org_id", orgID, "error", err)
+	}
+}
+
+// Get returns a logged query of the org, with its answer so far.
+func (s *Service) Get(ctx context.Context, id, orgID string) (*Entry, error) {
+	e, err := s.repo.Get(ctx, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	e.reportStale()
+	return e, nil
+}
+
+// reportStale marks an answer abandoned by its server as interrupted.
+func (e *Entry) reportStale() {
+	if e.Status == StatusAnswering && time.Since(e.UpdatedAt) > staleAfter {
+		e.Status = StatusInterrupted
+	}
+}
+
+// List returns the org's latest questions, newest first, with intent
+// only unless it is empty. A limit out of range lists the most allowed.
+func (s *Service) List(ctx context.Context, orgID, intent string, limit int) ([]*Entry, error) {
+	if intent != "" {
+		if err := CheckIntent(intent); err != nil {
+			return nil, err
+		}
+	}
+	if limit <= 0 || limit > maxListed {
+		limit = maxListed
+	}
+	entries, err := s.repo.List(ctx, orgID, intent, limit)
+	for _, e := range entries {
+		e.reportStale()
+	}
+	return entries, err
+}
+
+// IntentCounts counts the org's questions per intent in the calendar month
+// (UTC) starting at period; "" counts those left unclassified.
+func (s *Service) IntentCounts(ctx context.Context, orgID string, period time.Time) ([]IntentCount, error) {
+	return s.repo.IntentCounts(ctx, orgID, period, period.AddDate(0, 1, 0))
+}
+
+// RunPurge deletes entries older than retention, in every scope, at start
+// and then every interval until ctx is cancelled.
+func (s *Service) RunPurge(ctx context.Context, scopes tenancy.Scoper, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, scope := range scopes.Scopes(ctx) {
+			n, err := s.purge(scope, time.Now().Add(-retention))
+			if err != nil && ctx.Err() == nil {
+				slog.Error("query log purge failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+			}
+			if n > 0 {
+				slog.Info("purged query log", "count", n, "org_id", tenancy.OrgFrom(scope))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge deletes the entries created before before, a batch at a time.
+func (s *Service) purge(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		n, err := s.repo.purge(ctx, before, purgeBatch)
+		total += n
+		if err != nil || n < purgeBatch {
+			return total, err
+		}
+	}
+}
+
+// AnswerSaver saves the answer to one logged query as it streams. A nil
+// saver saves nothing.
+type AnswerSaver struct {
+	repo      *Repository
+	id, orgID string
+	saved     time.Time
+	savedLen  int
+}
+
+// SaveAnswer returns a saver for the answer to the logged query id.
+func (s *Service) SaveAnswer(id, orgID string) *AnswerSaver {
+	return &AnswerSaver{repo: s.repo, id: id, orgID: orgID, savedLen: -1}
+}
+
+// Update saves answer, the whole answer so far, unless the last save was
+// under saveEvery ago. Saving never fails the query; errors are logged.
+func (a *AnswerSaver) Update(ctx context.Context, answer string) {
+	if a == nil || len(answer) == a.savedLen || time.Since(a.saved) < saveEvery {
+		return
+	}
+	a.save(ctx, answer, StatusAnswering)
+}
+
+// Finish saves the final answer with its status.
+func (a *AnswerSaver) Finish(ctx context.Context, answer, status string) {
+	if a == nil {
+		return
+	}
+	a.save(ctx, answer, status)
+}
+
+func (a *AnswerSaver) save(ctx context.Context, answer, status string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), saveTimeout)
+	defer cancel()
+	if err := a.repo.saveAnswer(ctx, a.id, a.orgID, answer, status); err != nil {
+		slog.Warn("save answer failed", "query_id", a.id, "error", err)
+	}
+	a.saved, a.savedLen = time.Now(), len(answer)
+}
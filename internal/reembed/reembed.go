@@ -0,0 +1,427 @@
+// Package reembed moves an org's vectors to another embedding model.
+// Changing the model through the API re-ingests every document, and until
+// that finishes the org's questions are embedded with the new model but
+// searched against vectors of the old one. A Reembedder instead embeds
+// the org's stored chunks again, into a collection of the target model
+// while searches keep using the old one, and then switches the org's
+// collection and embedding model in one transaction.
+//
+// Chunks stored while the copy runs are caught up before the switch, and
+// any stored during the switch itself are moved over right after it. An
+// org moved to a collection of its own meanwhile fails the switch with
+// retrieval.ErrCollectionChanged; running again reuses the copies.
+package reembed
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pgvector/pgvector-go"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/modelpolicy"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+var (
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrDimensionMismatch is returned when the org's embedding table was
+	// created for another vector size than the target model is fitted to.
+	ErrDimensionMismatch = embedding.ErrDimensionMismatch
+)
+
+const (
+	// DefaultBatch is how many chunks are embedded per call.
+	DefaultBatch = 100
+	// maxCatchUps bounds the passes copying chunks stored during the
+	// previous one; what a busy org stores meanwhile is moved after the
+	// switch instead.
+	maxCatchUps = 3
+	// settle is how long after the switch a write that began before it
+	// may still land in the old collection.
+	settle = 2 * time.Second
+)
+
+// Config describes the target model.
+type Config struct {
+	// Embedder embeds with the target Model, fitted to Dimensions.
+	Embedder embedding.Embedder
+	Model    string
+	// DefaultModel is the deployment's model, which orgs without a choice
+	// of their own use.
+	DefaultModel string
+	// Dimensions is the vector size of the embedding tables.
+	Dimensions int
+	// Batch is the chunks embedded per call; 0 uses DefaultBatch.
+	Batch int
+	// Collections is the server's VECTOR_COLLECTIONS mode.
+	Collections retrieval.CollectionMode
+}
+
+// Report describes one org's re-embedding (or, for a dry run, what would
+// be re-embedded).
+type Report struct {
+	OrgID     string `json:"org_id"`
+	FromModel string `json:"from_model"`
+	Model     string `json:"model"`
+	// FromCollection and Collection are the org's collections before and
+	// after the switch.
+	FromCollection string `json:"from_collection,omitempty"`
+	Collection     string `json:"collection"`
+	Chunks         int64  `json:"chunks"`
+	// Skipped says why nothing was done, if so.
+	Skipped string `json:"skipped,omitempty"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+type Reembedder struct {
+	tenants  *tenancy.Resolver
+	shared   *pgxpool.Pool
+	settings *modelpolicy.Repository
+	cfg      Config
+}
+
+// NewReembedder creates a Reembedder reaching each org's vectors through
+// tenants, and orgs and their model settings in shared.
+func NewReembedder(tenants *tenancy.Resolver, shared *pgxpool.Pool, cfg Config) *Reembedder {
+	cfg.Batch = cmp.Or(cfg.Batch, DefaultBatch)
+	return &Reembedder{tenants: tenants, shared: shared, settings: modelpolicy.NewRepository(shared), cfg: cfg}
+}
+
+// Collection is the name of the collection orgID's vectors of the target
+// model go to: shared by every org re-embedded with it, or the org's own
+// with retrieval.CollectionsPerOrg unless the org has isolated storage.
+func (r *Reembedder) Collection(orgID string) string {
+	name := retrieval.DefaultCollection + "@" + r.cfg.Model
+	if r.cfg.Collections == retrieval.CollectionsPerOrg && !r.tenants.Isolated(orgID) {
+		return retrieval.OwnCollection(name, orgID)
+	}
+	return name
+}
+
+// RunAll re-embeds every org, stopping at the first failure.
+func (r *Reembedder) RunAll(ctx context.Context, dryRun bool) ([]*Report, error) {
+	rows, err := r.shared.Query(ctx, `SELECT id FROM organizations ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, org := range orgs {
+		rep, err := r.Run(ctx, org, dryRun)
+		if err != nil {
+			return reports, fmt.Errorf("org %s: %w", org, err)
+		}
+		reports = append(reports, rep)
+	}
+	return reports, nil
+}
+
+// Run re-embeds orgID's chunks with the target model and switches the org
+// over to them. Orgs already on the model, or without vectors, are
+// skipped. With dryRun only the chunks to re-embed are counted.
+func (r *Reembedder) Run(ctx context.Context, orgID string, dryRun bool) (*Report, error) {
+	ctx = tenancy.WithOrg(ctx, orgID)
+	rep := &Report{OrgID: orgID, Model: r.cfg.Model, Collection: r.Collection(orgID), DryRun: dryRun}
+
+	var exists bool
+	if err := r.shared.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1)`, orgID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrOrgNotFound, orgID)
+	}
+	st, err := r.settings.Get(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("read model settings: %w", err)
+	}
+	rep.FromModel = r.cfg.DefaultModel
+	if st != nil && st.EmbeddingModel != "" {
+		rep.FromModel = st.EmbeddingModel
+	}
+
+	dimensions, err := retrieval.StoredDimensions(ctx, r.tenants)
+	if err != nil {
+		return nil, err
+	}
+	if dimensions == 0 {
+		rep.Skipped = "no vectors"
+		return rep, nil
+	}
+	if dimensions != r.cfg.Dimensions {
+		return nil, fmt.Errorf("%w: the org's vectors have %d dimensions, the target model is fitted to %d",
+			ErrDimensionMismatch, dimensions, r.cfg.Dimensions)
+	}
+
+	// The target collection is kept only if there is something to copy
+	// into it.
+	err = database.NewUnitOfWork(r.tenants).Do(ctx, func(tx pgx.Tx) error {
+		if err := retrieval.PrepareCollection(ctx, tx, r.Collection(orgID), map[string]any{
+			"model":      r.cfg.Model,
+			"dimensions": r.cfg.Dimensions,
+		}); err != nil {
+			return err
+		}
+		if rep.FromCollection, err = retrieval.ActiveCollection(ctx, tx, orgID); err != nil {
+			return fmt.Errorf("read collection: %w", err)
+		}
+		if rep.FromCollection == rep.Collection {
+			return database.ErrRollback
+		}
+		if err := tx.QueryRow(ctx, fmt.Sprintf(
+			`SELECT count(*) FROM %s e JOIN %s c ON c.uuid = e.collection_id
+			 WHERE c.name = $1 AND e.cmetadata->>'org_id' = $2`,
+			retrieval.EmbeddingTable, retrieval.CollectionTable),
+			rep.FromCollection, orgID).Scan(&rep.Chunks); err != nil {
+			return fmt.Errorf("count chunks: %w", err)
+		}
+		if dryRun || rep.Chunks == 0 || rep.FromModel == r.cfg.Model {
+			return database.ErrRollback
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rep.FromCollection == rep.Collection:
+		// Switched by an earlier run that didn't get to the settings.
+		if rep.FromModel == r.cfg.Model {
+			rep.Skipped = "already on the model"
+		} else if !dryRun {
+			if err := r.settings.SetEmbeddingModel(ctx, orgID, r.cfg.Model); err != nil {
+				return nil, fmt.Errorf("set embedding model: %w", err)
+			}
+		}
+		return rep, nil
+	case rep.FromModel == r.cfg.Model:
+		rep.Chunks = 0
+		rep.Skipped = "already on the model"
+		return rep, nil
+	case rep.Chunks == 0:
+		rep.Skipped = "no vectors"
+		return rep, nil
+	case dryRun:
+		return rep, nil
+	}
+
+	// Copy, then copy what was stored meanwhile.
+	rep.Chunks = 0
+	for pass := 0; pass <= maxCatchUps; pass++ {
+		n, err := r.copyChunks(ctx, orgID, rep.FromCollection)
+		rep.Chunks += n
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if err := r.switchOver(ctx, orgID, rep.FromCollection); err != nil {
+		return nil, err
+	}
+	// Writes under way at the switch may still land in the old
+	// collection; move them once they are in.
+	for range 2 {
+		n, err := r.moveChunks(ctx, orgID, rep.FromCollection)
+		rep.Chunks += n
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(settle):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return rep, nil
+}
+
+// copyID is the SQL for the ID of the copy in collection coll of the
+// chunk whose ID is src; the switch renames copies back to their chunk's.
+func copyID(src, coll string) string {
+	return fmt.Sprintf(`md5(%s::text || %s)::uuid`, src, coll)
+}
+
+// chunk is a stored chunk to embed again.
+type chunk struct {
+	id, text, metadata string
+}
+
+// nextChunks returns up to cfg.Batch of orgID's chunks in collection from
+// after the ID after, in ID order; with uncopied, only those not yet
+// copied to the target collection.
+func (r *Reembedder) nextChunks(ctx context.Context, orgID, from, after string, uncopied bool) ([]chunk, error) {
+	rows, err := r.tenants.Query(ctx, fmt.Sprintf(
+		`SELECT e.uuid::text, e.document, e.cmetadata::text FROM %[1]s e JOIN %[2]s c ON c.uuid = e.collection_id
+		 WHERE c.name = $1 AND e.cmetadata->>'org_id' = $2 AND e.uuid > $3::uuid
+		   AND (NOT $4 OR NOT EXISTS (SELECT 1 FROM %[1]s n WHERE n.uuid = %[3]s))
+		 ORDER BY e.uuid
+		 LIMIT $6`, retrieval.EmbeddingTable, retrieval.CollectionTable, copyID("e.uuid", "$5")),
+		from, orgID, after, uncopied, r.Collection(orgID), r.cfg.Batch)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (chunk, error) {
+		var c chunk
+		err := row.Scan(&c.id, &c.text, &c.metadata)
+		return c, err
+	})
+}
+
+// embed returns the chunks' texts embedded with the target model, as
+// pgvector literals.
+func (r *Reembedder) embed(ctx context.Context, chunks []chunk) (ids, texts, vectors, metadata []string, err error) {
+	for _, c := range chunks {
+		ids = append(ids, c.id)
+		texts = append(texts, c.text)
+		metadata = append(metadata, c.metadata)
+	}
+	vecs, err := r.cfg.Embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("embed chunks: %w", err)
+	}
+	if len(vecs) != len(chunks) {
+		return nil, nil, nil, nil, fmt.Errorf("embedder returned %d vectors for %d chunks", len(vecs), len(chunks))
+	}
+	for _, vec := range vecs {
+		if len(vec) != r.cfg.Dimensions {
+			return nil, nil, nil, nil, fmt.Errorf("%w: got %d dimensions, want %d", ErrDimensionMismatch, len(vec), r.cfg.Dimensions)
+		}
+		vectors = append(vectors, pgvector.NewVector(vec).String())
+	}
+	return ids, texts, vectors, metadata, nil
+}
+
+// copyChunks copies orgID's chunks in collection from that have no copy
+// yet to the target collection, embedded with the target model, and
+// returns how many it copied.
+func (r *Reembedder) copyChunks(ctx context.Context, orgID, from string) (int64, error) {
+	var copied int64
+	after := "00000000-0000-0000-0000-000000000000"
+	for {
+		chunks, err := r.nextChunks(ctx, orgID, from, after, true)
+		if err != nil || len(chunks) == 0 {
+			return copied, err
+		}
+		ids, texts, vectors, metadata, err := r.embed(ctx, chunks)
+		if err != nil {
+			return copied, err
+		}
+		tag, err := r.tenants.Exec(ctx, fmt.Sprintf(
+			`INSERT INTO %s (uuid, document, embedding, cmetadata, collection_id)
+			 SELECT %s, r.text, r.vec::vector, r.md::json, c.uuid
+			 FROM unnest($1::text[], $2::text[], $3::text[], $4::text[]) AS r(id, text, vec, md)
+			 JOIN %s c ON c.name = $5
+			 ON CONFLICT (uuid) DO NOTHING`,
+			retrieval.EmbeddingTable, copyID("r.id", "$5"), retrieval.CollectionTable),
+			ids, texts, vectors, metadata, r.Collection(orgID))
+		if err != nil {
+			return copied, fmt.Errorf("store copies: %w", err)
+		}
+		copied += tag.RowsAffected()
+		after = chunks[len(chunks)-1].id
+	}
+}
+
+// switchOver replaces orgID's chunks in collection from by their copies,
+// which take over their IDs and latest metadata, drops copies of chunks
+// deleted meanwhile, and points the org at the target collection and
+// model, all in one transaction. Chunks stored since their pass are left
+// for moveChunks.
+func (r *Reembedder) switchOver(ctx context.Context, orgID, from string) error {
+	isolatedDB := r.tenants.Placement(orgID).Mode == tenancy.ModeDatabase
+	err := database.NewUnitOfWork(r.tenants).Do(ctx, func(tx pgx.Tx) error {
+		// First, so the pointer is locked against a concurrent move.
+		if err := retrieval.SwitchCollection(ctx, tx, orgID, from, r.Collection(orgID)); err != nil {
+			return fmt.Errorf("switch collection: %w", err)
+		}
+		stmts := []struct {
+			what, sql string
+			args      []any
+		}{
+			{"list copied chunks", `CREATE TEMP TABLE reembed_copied (uuid uuid PRIMARY KEY, cmetadata json) ON COMMIT DROP`, nil},
+			{"list copied chunks", fmt.Sprintf(
+				`INSERT INTO reembed_copied
+				 SELECT e.uuid, e.cmetadata FROM %[1]s e JOIN %[2]s c ON c.uuid = e.collection_id
+				 WHERE c.name = $1 AND e.cmetadata->>'org_id' = $2
+				   AND EXISTS (SELECT 1 FROM %[1]s n WHERE n.uuid = %[3]s)`,
+				retrieval.EmbeddingTable, retrieval.CollectionTable, copyID("e.uuid", "$3")),
+				[]any{from, orgID, r.Collection(orgID)}},
+			{"drop stale copies", fmt.Sprintf(
+				`DELETE FROM %[1]s n USING %[2]s c
+				 WHERE c.uuid = n.collection_id AND c.name = $1 AND n.cmetadata->>'org_id' = $2
+				   AND NOT EXISTS (SELECT 1 FROM reembed_copied m WHERE n.uuid = %[3]s)`,
+				retrieval.EmbeddingTable, retrieval.CollectionTable, copyID("m.uuid", "$1")),
+				[]any{r.Collection(orgID), orgID}},
+			{"delete copied chunks", fmt.Sprintf(
+				`DELETE FROM %s e USING reembed_copied m WHERE e.uuid = m.uuid`, retrieval.EmbeddingTable), nil},
+			{"rename copies", fmt.Sprintf(
+				`UPDATE %s n SET uuid = m.uuid, cmetadata = m.cmetadata
+				 FROM reembed_copied m WHERE n.uuid = %s`, retrieval.EmbeddingTable, copyID("m.uuid", "$1")),
+				[]any{r.Collection(orgID)}},
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(ctx, stmt.sql, stmt.args...); err != nil {
+				return fmt.Errorf("%s: %w", stmt.what, err)
+			}
+		}
+		// A tenant schema's transaction reaches the shared settings too.
+		if !isolatedDB {
+			if err := r.settings.WithTx(tx).SetEmbeddingModel(ctx, orgID, r.cfg.Model); err != nil {
+				return fmt.Errorf("set embedding model: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if isolatedDB {
+		// Rerunning finishes this if it fails: the org is already in the
+		// target collection.
+		if err := r.settings.SetEmbeddingModel(ctx, orgID, r.cfg.Model); err != nil {
+			return fmt.Errorf("set embedding model: %w", err)
+		}
+	}
+	return nil
+}
+
+// moveChunks re-embeds orgID's chunks still in collection from and moves
+// them to the target collection in place, returning how many it moved.
+func (r *Reembedder) moveChunks(ctx context.Context, orgID, from string) (int64, error) {
+	var moved int64
+	after := "00000000-0000-0000-0000-000000000000"
+	for {
+		chunks, err := r.nextChunks(ctx, orgID, from, after, false)
+		if err != nil || len(chunks) == 0 {
+			return moved, err
+		}
+		ids, _, vectors, _, err := r.embed(ctx, chunks)
+		if err != nil {
+			return moved, err
+		}
+		tag, err := r.tenants.Exec(ctx, fmt.Sprintf(
+			`UPDATE %s e SET embedding = r.vec::vector, collection_id = c.uuid
+			 FROM unnest($1::uuid[], $2::text[]) AS r(id, vec), %s c
+			 WHERE e.uuid = r.id AND c.name = $3`,
+			retrieval.EmbeddingTable, retrieval.CollectionTable),
+			ids, vectors, r.Collection(orgID))
+		if err != nil {
+			return moved, fmt.Errorf("move chunks: %w", err)
+		}
+		moved += tag.RowsAffected()
+		after = chunks[len(chunks)-1].id
+	}
+}
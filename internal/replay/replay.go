@@ -0,0 +1,214 @@
+// Package replay records the service's calls to model providers (chat
+// completions, embeddings, reranking) as fixture files and plays them back,
+// so end-to-end tests of the RAG pipeline run reproducibly in CI without
+// network access or API keys. It works at the HTTP level: a Transport sits
+// under each provider client, so what is captured is exactly what the
+// provider sent, streamed responses included.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mode is what a Transport does with provider calls.
+type Mode string
+
+const (
+	// ModeOff passes calls through untouched.
+	ModeOff Mode = ""
+	// ModeRecord passes calls through and saves each response as a
+	// fixture, replacing any earlier one for the same request.
+	ModeRecord Mode = "record"
+	// ModeReplay answers from fixtures only; a call without one fails.
+	ModeReplay Mode = "replay"
+)
+
+// ParseMode validates a PROVIDER_REPLAY value.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeOff, ModeRecord, ModeReplay:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown replay mode %q (want %q or %q)", s, ModeRecord, ModeReplay)
+	}
+}
+
+// ErrNoFixture is returned in ModeReplay for a request never recorded.
+var ErrNoFixture = errors.New("no recorded fixture for request")
+
+// secretParams are query parameters that carry credentials (Gemini's
+// ?key=, Azure's api-key); they are left out of fixtures and keys.
+var secretParams = []string{"key", "api-key", "api_key"}
+
+// Fixture is one recorded exchange. Only what identifies the request and
+// what the client reads of the response is kept; headers carrying
+// credentials never reach disk.
+type Fixture struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Response    string    `json:"response"`
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// Transport records or replays the requests it carries. Fixtures are
+// files in Dir named by a hash of the request's method, URL and body, so
+// the same request always maps to the same fixture.
+type Transport struct {
+	Mode Mode
+	Dir  string
+	// Next carries requests in ModeOff and ModeRecord; nil uses
+	// http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+// Client returns an HTTP client using t, with the given timeout.
+func (t *Transport) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: t, Timeout: timeout}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if t.Mode == ModeOff {
+		return next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	target := redact(req.URL)
+	path := filepath.Join(t.Dir, key(req.Method, target, body)+".json")
+
+	if t.Mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s %s (%s)", ErrNoFixture, req.Method, target, filepath.Base(path))
+		}
+		if err != nil {
+			return nil, err
+		}
+		var f Fixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("read fixture %s: %w", path, err)
+		}
+		return f.response(req), nil
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	f := Fixture{
+		Method:      req.Method,
+		URL:         target,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Response:    string(respBody),
+		RecordedAt:  time.Now().UTC(),
+	}
+	if json.Valid(body) {
+		f.Body = body
+	}
+	if err := f.save(path); err != nil {
+		return nil, fmt.Errorf("record fixture: %w", err)
+	}
+	return f.response(req), nil
+}
+
+// response rebuilds the recorded response for req.
+func (f *Fixture) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	if f.ContentType != "" {
+		header.Set("Content-Type", f.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(f.Response)),
+		ContentLength: int64(len(f.Response)),
+		Request:       req,
+	}
+}
+
+// save writes the fixture atomically, so a concurrent replay never reads
+// half of one.
+func (f *Fixture) save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// redact returns u without credential query parameters.
+func redact(u *url.URL) string {
+	c := *u
+	q := c.Query()
+	for _, p := range secretParams {
+		q.Del(p)
+	}
+	c.RawQuery = q.Encode()
+	c.User = nil
+	return c.String()
+}
+
+// key identifies a request. JSON bodies are compacted first so formatting
+// differences don't break replay.
+func key(method, target string, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, target)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
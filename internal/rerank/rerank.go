@@ -0,0 +1,204 @@
+// Package rerank scores retrieved passages against a query with a
+// cross-encoder, which judges relevance far better than the embedding
+// distance that found them. Hosted rerank APIs (Cohere, Jina) and local
+// cross-encoders served over HTTP (Hugging Face text-embeddings-inference)
+// are supported, selected by name (RERANK_PROVIDER).
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Provider names.
+const (
+	ProviderCohere = "cohere"
+	ProviderJina   = "jina"
+	// ProviderTEI is a cross-encoder served by text-embeddings-inference
+	// (or anything speaking its /rerank API); it has no default URL.
+	ProviderTEI = "tei"
+)
+
+// Config configures a reranker. Empty BaseURL and Model take the
+// provider's defaults.
+type Config struct {
+	APIKey  string
+	Model   string
+	BaseURL string
+	// HTTPClient defaults to one with a 30s timeout.
+	HTTPClient *http.Client
+}
+
+type provider struct {
+	defaultBaseURL string
+	defaultModel   string
+	keyOptional    bool
+	path           string
+	// request builds the body for a query over passages; decode reads
+	// the score of each passage index from the response.
+	request func(model, query string, passages []string) any
+	decode  func(body []byte) (map[int]float64, error)
+}
+
+var providers = map[string]provider{
+	ProviderCohere: {
+		defaultBaseURL: "https://api.cohere.com",
+		defaultModel:   "rerank-v3.5",
+		path:           "/v2/rerank",
+		request:        topNRequest,
+		decode:         decodeResults,
+	},
+	ProviderJina: {
+		defaultBaseURL: "https://api.jina.ai",
+		defaultModel:   "jina-reranker-v2-base-multilingual",
+		path:           "/v1/rerank",
+		request:        topNRequest,
+		decode:         decodeResults,
+	},
+	ProviderTEI: {
+		keyOptional: true,
+		path:        "/rerank",
+		request: func(_, query string, passages []string) any {
+			return map[string]any{"query": query, "texts": passages, "truncate": true}
+		},
+		decode: func(body []byte) (map[int]float64, error) {
+			var results []struct {
+				Index int     `json:"index"`
+				Score float64 `json:"score"`
+			}
+			if err := json.Unmarshal(body, &results); err != nil {
+				return nil, err
+			}
+			scores := make(map[int]float64, len(results))
+			for _, r := range results {
+				scores[r.Index] = r.Score
+			}
+			return scores, nil
+		},
+	},
+}
+
+// Providers lists the provider names, sorted.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// DefaultBaseURL returns a provider's endpoint, empty for unknown
+// providers and ones deployed locally.
+func DefaultBaseURL(name string) string {
+	return providers[name].defaultBaseURL
+}
+
+// Client reranks through one provider.
+type Client struct {
+	p   provider
+	cfg Config
+}
+
+// New creates a client for the named provider, filling in its defaults.
+func New(name string, cfg Config) (*Client, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rerank provider %q (available: %s)", name, strings.Join(Providers(), ", "))
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = p.defaultBaseURL
+	}
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("rerank provider %q needs a base URL", name)
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Model == "" {
+		cfg.Model = p.defaultModel
+	}
+	if cfg.APIKey == "" && !p.keyOptional {
+		return nil, fmt.Errorf("rerank provider %q needs an API key", name)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{p: p, cfg: cfg}, nil
+}
+
+// Rerank returns the relevance of each passage to query, in passage
+// order; higher is more relevant. Passages the provider didn't score get
+// -Inf. It implements retrieval.Reranker.
+func (c *Client) Rerank(ctx context.Context, query string, passages []string) ([]float64, error) {
+	if len(passages) == 0 {
+		return nil, nil
+	}
+	body, _ := json.Marshal(c.p.request(c.cfg.Model, query, passages))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.BaseURL+c.p.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(data[:min(len(data), 512)]))
+		return nil, fmt.Errorf("reranker returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	byIndex, err := c.p.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode rerank response: %w", err)
+	}
+	scores := make([]float64, len(passages))
+	for i := range scores {
+		score, ok := byIndex[i]
+		if !ok {
+			score = math.Inf(-1)
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+// topNRequest is the Cohere/Jina request body, asking for every passage
+// back so each gets a score.
+func topNRequest(model, query string, passages []string) any {
+	return map[string]any{"model": model, "query": query, "documents": passages, "top_n": len(passages)}
+}
+
+// decodeResults reads a Cohere/Jina response.
+func decodeResults(body []byte) (map[int]float64, error) {
+	var resp struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	scores := make(map[int]float64, len(resp.Results))
+	for _, r := range resp.Results {
+		scores[r.Index] = r.RelevanceScore
+	}
+	return scores, nil
+}
@@ -0,0 +1,116 @@
+package retrieval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// Answer caching
+//
+// With an AnswerCache set (CacheAnswersIn), a question whose answer was
+// generated before from the same prompt is answered from the cache, after
+// retrieval but without calling the LLM. The cache key's fingerprint covers
+// everything besides the question that the answer depends on, so changed
+// documents, templates or settings miss by construction. Queries can skip
+// the cache with BypassCache.
+
+// AnswerCache stores answers by org, question and the fingerprint of the
+// prompt they were generated from. Get may match a question close to a
+// cached one; see internal/cache.
+type AnswerCache interface {
+	Get(ctx context.Context, orgID, question, fingerprint string) (string, bool)
+	Put(ctx context.Context, orgID, question, fingerprint, answer string)
+}
+
+// CacheAnswersIn answers repeated questions from c, and stores new answers
+// in it.
+func (s *RAGService) CacheAnswersIn(c AnswerCache) {
+	s.cache = c
+}
+
+// fingerprint identifies what an answer was generated from besides the
+// question: the model, the generation settings and the prompt rendered
+// without the question, which holds the template, the retrieved context
+// and the conversation.
+func fingerprint(req QueryRequest, system, user string) string {
+	gen, _ := json.Marshal(req.Generation)
+	h := sha256.New()
+	for _, part := range []string{req.Model, string(gen), system, user} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// answerCached sends the cached answer to req's question, if there is one,
+// and closes out. It reports whether it did.
+func (s *RAGService) answerCached(ctx context.Context, req QueryRequest, fp string, out chan<- string) (bool, error) {
+	answer, ok := s.cache.Get(ctx, req.OrgID, req.Question, fp)
+	if !ok {
+		return false, nil
+	}
+	if req.OnCacheHit != nil {
+		req.OnCacheHit()
+	}
+	defer close(out)
+	select {
+	case out <- answer:
+		return true, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// generateAndCache is generate, storing the answer in the cache once it is
+// complete and sent. An answer a provider switch restarted isn't stored: out already
+// carried the discarded start.
+func (s *RAGService) generateAndCache(ctx context.Context, req QueryRequest, system, user, fp string, out chan<- string) error {
+	tokens := make(chan string)
+	flush := make(chan chan struct{})
+	done := make(chan string)
+	go func() {
+		defer close(out)
+		var answer strings.Builder
+		for {
+			select {
+			case t, ok := <-tokens:
+				if !ok {
+					done <- answer.String()
+					return
+				}
+				answer.WriteString(t)
+				select {
+				case out <- t:
+				case <-ctx.Done():
+					// The reader is gone; drain so generation can finish.
+				}
+			case flushed := <-flush:
+				close(flushed)
+			}
+		}
+	}()
+
+	restarted := false
+	onSwitch := req.OnProviderSwitch
+	req.OnProviderSwitch = func(sw ProviderSwitch) {
+		restarted = restarted || sw.Restart
+		// Tokens sent before the switch reach out before it's reported.
+		flushed := make(chan struct{})
+		flush <- flushed
+		<-flushed
+		if onSwitch != nil {
+			onSwitch(sw)
+		}
+	}
+
+	err := s.generate(ctx, req, system, user, tokens)
+	answer := <-done
+	if err == nil && !restarted && ctx.Err() == nil && answer != "" {
+		// Storing may embed the question; the reader needn't wait for it.
+		go s.cache.Put(context.WithoutCancel(ctx), req.OrgID, req.Question, fp, answer)
+	}
+	return err
+}
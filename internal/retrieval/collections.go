@@ -0,0 +1,377 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Collections
+//
+// An org's vectors live in DefaultCollection until they are re-embedded
+// with another model (cmd/reembed). The new vectors are written to a
+// collection of their own next to the old ones, and the org is then
+// pointed at it in CollectionPointerTable: searches and inserts follow the
+// pointer, so the switch is a single row. Deletes match documents in any
+// collection, so a copy in progress never outlives its document.
+//
+// With CollectionsPerOrg, each pooled org's vectors also get a collection
+// of their own (OwnCollection) with a partial HNSW index over just its
+// rows, so one org's searches walk a graph no bigger than its corpus and
+// can never be handed another org's neighbours. New orgs are pointed at
+// theirs when they first store vectors; RunSplit moves the orgs already
+// in DefaultCollection over one at a time and drops the shared index once
+// none are left. Orgs with a schema or database of their own have their
+// own tables already and stay in DefaultCollection there.
+//
+// A collection's name up to any "#" is its model family: an org's own
+// collection is in the family of the collection it came from. Granted
+// documents are searched when their owner's collection is in the same
+// family as the org's, since vectors of different models can't be
+// compared.
+
+const (
+	// DefaultCollection holds the vectors of every org not pointed
+	// elsewhere.
+	DefaultCollection = collectionName
+	// CollectionPointerTable names the collection of each org whose
+	// vectors aren't in DefaultCollection.
+	CollectionPointerTable = "embedding_collections"
+)
+
+// CollectionMode selects whether pooled orgs share DefaultCollection.
+type CollectionMode string
+
+const (
+	// CollectionsShared keeps pooled orgs in DefaultCollection under one
+	// HNSW index (the default).
+	CollectionsShared CollectionMode = "shared"
+	// CollectionsPerOrg gives each pooled org a collection and index of
+	// its own.
+	CollectionsPerOrg CollectionMode = "org"
+)
+
+// ParseCollectionMode validates a VECTOR_COLLECTIONS value; empty means
+// CollectionsShared.
+func ParseCollectionMode(s string) (CollectionMode, error) {
+	switch m := CollectionMode(s); m {
+	case "":
+		return CollectionsShared, nil
+	case CollectionsShared, CollectionsPerOrg:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown vector collection mode %q (want %q or %q)", s, CollectionsShared, CollectionsPerOrg)
+	}
+}
+
+// ErrCollectionChanged is returned when an org is switched from a
+// collection it was moved out of meanwhile.
+var ErrCollectionChanged = errors.New("collection changed meanwhile")
+
+// ErrNoCollection is returned when vectors are stored for an org whose
+// collection doesn't exist.
+var ErrNoCollection = errors.New("vector collection not found")
+
+// OwnCollection returns the name of orgID's own collection in the model
+// family of the collection family.
+func OwnCollection(family, orgID string) string {
+	family, _, _ = strings.Cut(family, "#")
+	return family + "#" + orgID
+}
+
+// activeCollection is the SQL for the collection of the org whose ID is
+// the expression org, falling back to the collection named by fallback.
+func activeCollection(org, fallback string) string {
+	return fmt.Sprintf(`COALESCE((SELECT p.collection FROM %s p WHERE p.org_id = %s), %s)`,
+		CollectionPointerTable, org, fallback)
+}
+
+// createCollectionPointers creates the pointer table in db's storage, next
+// to the embedding table.
+func createCollectionPointers(ctx context.Context, db database.DBTX) error {
+	if _, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+CollectionPointerTable+` (
+		org_id      TEXT PRIMARY KEY,
+		collection  TEXT NOT NULL,
+		switched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`); err != nil {
+		return fmt.Errorf("create collection pointers: %w", err)
+	}
+	return nil
+}
+
+// PrepareCollection makes sure db's storage has the vector tables' pointer
+// table and a collection named name, recording metadata on it when it is
+// created. The embedding tables themselves must already exist.
+func PrepareCollection(ctx context.Context, db database.DBTX, name string, metadata map[string]any) error {
+	if err := createCollectionPointers(ctx, db); err != nil {
+		return err
+	}
+	return createCollection(ctx, db, name, metadata)
+}
+
+// createCollection adds a collection named name to db's storage unless it
+// has one.
+func createCollection(ctx context.Context, db database.DBTX, name string, metadata map[string]any) error {
+	_, err := db.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s (uuid, name, cmetadata) VALUES (gen_random_uuid(), $1, $2)
+		 ON CONFLICT (name) DO NOTHING`, CollectionTable), name, metadata)
+	if err != nil {
+		return fmt.Errorf("create collection %s: %w", name, err)
+	}
+	return nil
+}
+
+// recordedDimensions is the SQL for the vector size recorded on the
+// collection c when it was created, 0 if none was.
+const recordedDimensions = `COALESCE((c.cmetadata->>'dimensions')::int, 0)`
+
+// collectionSize is a collection's name and recorded vector size.
+type collectionSize struct {
+	name       string
+	dimensions int
+}
+
+// collectionSizes returns the collection the vectors of each org owning
+// docs go into, with its recorded size, so they are checked against the
+// collection rather than the configuration alone.
+func (vs *LangChainVectorStore) collectionSizes(ctx context.Context, docs []schema.Document) (map[string]collectionSize, error) {
+	var orgs []string
+	for _, doc := range docs {
+		if org := fmt.Sprint(doc.Metadata["org_id"]); !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
+		}
+	}
+	rows, err := vs.db.Query(ctx, fmt.Sprintf(
+		`SELECT o.org, c.name, %s FROM unnest($1::text[]) AS o(org)
+		 JOIN %s c ON c.name = %s`, recordedDimensions, CollectionTable, activeCollection("o.org", "$2")),
+		orgs, collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("resolve collections: %w", err)
+	}
+	defer rows.Close()
+	sizes := make(map[string]collectionSize, len(orgs))
+	for rows.Next() {
+		var (
+			org  string
+			size collectionSize
+		)
+		if err := rows.Scan(&org, &size.name, &size.dimensions); err != nil {
+			return nil, err
+		}
+		sizes[org] = size
+	}
+	return sizes, rows.Err()
+}
+
+// ActiveCollection returns the collection orgID's vectors are stored and
+// searched in, reading the pointer table in db's storage.
+func ActiveCollection(ctx context.Context, db database.DBTX, orgID string) (string, error) {
+	var name string
+	err := db.QueryRow(ctx,
+		`SELECT collection FROM `+CollectionPointerTable+` WHERE org_id = $1`, orgID).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultCollection, nil
+	}
+	return name, err
+}
+
+// SwitchCollection points orgID from the collection from to the
+// collection to, in db's storage. It returns ErrCollectionChanged if the
+// org isn't in from (any more).
+func SwitchCollection(ctx context.Context, db database.DBTX, orgID, from, to string) error {
+	sql := `UPDATE ` + CollectionPointerTable + ` SET collection = $3, switched_at = NOW()
+		 WHERE org_id = $1 AND collection = $2`
+	if from == DefaultCollection {
+		// Orgs in DefaultCollection may have no pointer yet.
+		sql = `INSERT INTO ` + CollectionPointerTable + ` AS p (org_id, collection) VALUES ($1, $3)
+		 ON CONFLICT (org_id) DO UPDATE SET collection = EXCLUDED.collection, switched_at = NOW()
+		 WHERE p.collection = $2`
+	}
+	tag, err := db.Exec(ctx, sql, orgID, from, to)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: org %s is no longer in collection %s", ErrCollectionChanged, orgID, from)
+	}
+	return nil
+}
+
+// ForgetCollections removes orgID's pointer and its own collections from
+// db's storage, for an org deleted or merged away. Vectors left in them
+// go too; RunSplit drops their indexes.
+func ForgetCollections(ctx context.Context, db database.DBTX, orgID string) error {
+	if _, err := db.Exec(ctx,
+		`DELETE FROM `+CollectionPointerTable+` WHERE org_id = $1`, orgID); err != nil {
+		return fmt.Errorf("drop collection pointer: %w", err)
+	}
+	if _, err := db.Exec(ctx,
+		`DELETE FROM `+CollectionTable+` WHERE split_part(name, '#', 2) = $1`, orgID); err != nil {
+		return fmt.Errorf("drop own collections: %w", err)
+	}
+	return nil
+}
+
+// claimCollections points the pooled orgs owning docs at collections of
+// their own before their first vectors are stored, with
+// CollectionsPerOrg. Orgs with vectors in DefaultCollection already are
+// left for RunSplit to move.
+func (vs *LangChainVectorStore) claimCollections(ctx context.Context, docs []schema.Document) error {
+	if vs.cfg.Collections != CollectionsPerOrg {
+		return nil
+	}
+	for _, doc := range docs {
+		org, _ := doc.Metadata["org_id"].(string)
+		if org == "" || vs.tenants.Isolated(org) {
+			continue
+		}
+		if _, ok := vs.owned.Load(org); ok {
+			continue
+		}
+		own := OwnCollection(DefaultCollection, org)
+		// The pointer table exists already, and requests for an org may
+		// not create tables.
+		if err := createCollection(ctx, vs.db, own, vs.ownMetadata(org)); err != nil {
+			return err
+		}
+		if _, err := vs.db.Exec(ctx, fmt.Sprintf(
+			`INSERT INTO %[1]s (org_id, collection)
+			 SELECT $1, $2 WHERE NOT EXISTS (
+				 SELECT 1 FROM %[2]s e JOIN %[3]s c ON c.uuid = e.collection_id
+				 WHERE c.name = $3 AND e.cmetadata->>'org_id' = $1)
+			 ON CONFLICT (org_id) DO NOTHING`, CollectionPointerTable, EmbeddingTable, CollectionTable),
+			org, own, DefaultCollection); err != nil {
+			return fmt.Errorf("claim collection of org %s: %w", org, err)
+		}
+		vs.owned.Store(org, struct{}{})
+	}
+	return nil
+}
+
+func (vs *LangChainVectorStore) ownMetadata(orgID string) map[string]any {
+	return map[string]any{"org_id": orgID, "dimensions": vs.cfg.Dimensions}
+}
+
+// RunSplit moves the pooled orgs still in the shared storage's
+// DefaultCollection to collections of their own every interval until ctx
+// ends, with CollectionsPerOrg.
+func (vs *LangChainVectorStore) RunSplit(ctx context.Context, interval time.Duration) {
+	if vs.cfg.Collections != CollectionsPerOrg {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := vs.split(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("split vector collections failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// split is one pass of RunSplit. The orgs' collections are indexed before
+// their vectors move in, so their searches never go without an index.
+func (vs *LangChainVectorStore) split(ctx context.Context) error {
+	var defaultID string
+	err := vs.tenants.QueryRow(ctx,
+		`SELECT uuid::text FROM `+CollectionTable+` WHERE name = $1`, DefaultCollection).Scan(&defaultID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := vs.tenants.Query(ctx, fmt.Sprintf(
+		`SELECT DISTINCT cmetadata->>'org_id' FROM %s
+		 WHERE collection_id = $1::uuid AND cmetadata->>'org_id' IS NOT NULL`, EmbeddingTable), defaultID)
+	if err != nil {
+		return fmt.Errorf("list orgs to move: %w", err)
+	}
+	orgs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("list orgs to move: %w", err)
+	}
+	orgs = slices.DeleteFunc(orgs, vs.tenants.Isolated)
+	for _, org := range orgs {
+		if err := PrepareCollection(ctx, vs.tenants, OwnCollection(DefaultCollection, org), vs.ownMetadata(org)); err != nil {
+			return err
+		}
+	}
+
+	rows, err = vs.tenants.Query(ctx,
+		`SELECT uuid::text FROM `+CollectionTable+` WHERE name <> $1`, DefaultCollection)
+	if err != nil {
+		return fmt.Errorf("list collections: %w", err)
+	}
+	collections, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("list collections: %w", err)
+	}
+	if err := vs.cfg.indexCollections(ctx, vs.tenants, collections); err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if err := vs.moveOrg(ctx, org); err != nil {
+			return fmt.Errorf("move org %s: %w", org, err)
+		}
+	}
+
+	var left bool
+	if err := vs.tenants.QueryRow(ctx, fmt.Sprintf(
+		`SELECT EXISTS (SELECT 1 FROM %s WHERE collection_id = $1::uuid)`, EmbeddingTable),
+		defaultID).Scan(&left); err != nil {
+		return err
+	}
+	if left {
+		return nil
+	}
+	return dropSharedIndexes(ctx, vs.tenants)
+}
+
+// moveOrg points orgID at its own collection and moves its vectors there
+// from DefaultCollection, in one transaction. Orgs re-embedded into
+// another collection meanwhile are left alone: their stragglers are the
+// re-embedding's to move.
+func (vs *LangChainVectorStore) moveOrg(ctx context.Context, orgID string) error {
+	ctx = tenancy.WithOrg(ctx, orgID)
+	own := OwnCollection(DefaultCollection, orgID)
+	return database.NewUnitOfWork(vs.tenants).Do(ctx, func(tx pgx.Tx) error {
+		active, err := ActiveCollection(ctx, tx, orgID)
+		if err != nil {
+			return err
+		}
+		if active != DefaultCollection && active != own {
+			return nil
+		}
+		// Switching to own from own just locks the pointer against a
+		// concurrent switch.
+		if err := SwitchCollection(ctx, tx, orgID, active, own); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, fmt.Sprintf(
+			`UPDATE %[1]s e SET collection_id = o.uuid
+			 FROM %[2]s d, %[2]s o
+			 WHERE d.name = $1 AND o.name = $2 AND e.collection_id = d.uuid AND e.cmetadata->>'org_id' = $3`,
+			EmbeddingTable, CollectionTable), DefaultCollection, own, orgID)
+		if err != nil {
+			return err
+		}
+		slog.Info("moved org to its own vector collection", "org_id", orgID, "collection", own, "vectors", tag.RowsAffected())
+		return nil
+	})
+}
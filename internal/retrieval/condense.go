@@ -0,0 +1,78 @@
+package retrieval
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+)
+
+// Question condensation
+//
+// A follow-up like "and for managers?" means something only after the
+// turns before it, so searching for it as asked retrieves nothing useful.
+// With CondenseFollowUps on, a question asked with history is first
+// rewritten into a standalone one ("What is the parental leave policy for
+// managers?"), which retrieval, reranking and intent classification use.
+// The answer is still generated for the question as asked, with the
+// conversation in the prompt.
+
+const condensePrompt = `You rewrite follow-up questions for searching a knowledge base.
+Given a conversation and a follow-up question, rewrite the follow-up as a standalone question that can be understood without the conversation, resolving pronouns and references to earlier turns.
+Keep the question's language and intent, and add no facts that aren't in the conversation.
+If the follow-up is already standalone, repeat it unchanged.
+Reply with ONLY the question.`
+
+const (
+	// condenseTimeout bounds condensation, which retrieval waits on.
+	condenseTimeout = 5 * time.Second
+	// condenseTurns is how many of the latest turns the rewrite sees;
+	// references rarely reach further back.
+	condenseTurns = 6
+	// maxCondensedChars rejects rewrites that ramble instead of asking.
+	maxCondensedChars = 1000
+)
+
+// CondenseFollowUps rewrites questions asked with history into standalone
+// ones for retrieval, through model ("" for the client's default).
+func (s *RAGService) CondenseFollowUps(model string) {
+	s.condense, s.condenseModel = true, model
+}
+
+// standalone returns req's question rewritten to stand on its own, or the
+// question as asked when there is no history or the rewrite fails; a query
+// is never failed for it.
+func (s *RAGService) standalone(ctx context.Context, req QueryRequest) string {
+	if !s.condense || len(req.History) == 0 {
+		return req.Question
+	}
+	ctx, cancel := context.WithTimeout(ctx, condenseTimeout)
+	defer cancel()
+	history := req.History
+	if len(history) > condenseTurns {
+		history = history[len(history)-condenseTurns:]
+	}
+	zero := 0.0
+	answer, err := Complete(ctx, s.llm, condensePrompt,
+		"Conversation:\n"+renderHistory(history)+"\nFollow-up question: "+req.Question,
+		llm.CompletionOptions{
+			Model:      s.condenseModel,
+			Generation: llm.Generation{Temperature: &zero, MaxTokens: 200},
+		})
+	if err != nil {
+		slog.Warn("question condensation failed", "org_id", req.OrgID, "error", err)
+		return req.Question
+	}
+	standalone := strings.TrimSpace(answer)
+	if label, rest, ok := strings.Cut(standalone, ":"); ok && strings.Contains(strings.ToLower(label), "question") {
+		standalone = strings.TrimSpace(rest)
+	}
+	standalone = strings.Trim(standalone, "\"'`")
+	if standalone == "" || len([]rune(standalone)) > maxCondensedChars {
+		slog.Warn("unusable question condensation", "org_id", req.OrgID, "output", answer)
+		return req.Question
+	}
+	return standalone
+}
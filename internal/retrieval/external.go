@@ -0,0 +1,549 @@
+package retrieval
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// External vector stores
+// Every backend stores a chunk as a point keyed by ChunkID with its
+// vector and, as payload, the fields the store filters on (org_id,
+// document_id, level, chunk_index), the text and the whole metadata as
+// JSON. Scoping works as with pgvector: an org's own chunks plus granted
+// documents, narrowed by document and level in the backend's own filter
+// language. Metadata filters are applied to the results instead, over a
+// wider candidate set, since the backends can't all match list values
+// and prefixes. Pooled orgs share Collection; isolated orgs get a
+// collection named after it and the org.
+
+const (
+	levelChunk = "chunk"
+	// levelSummary matches every level but levelChunk.
+	levelSummary = "summary"
+	// filterOverfetch is how many candidates per requested result a
+	// search with metadata filters ranks before applying them.
+	filterOverfetch = 4
+	// pruneBatch is how many points one delete by ID names.
+	pruneBatch = 500
+)
+
+// ExternalConfig says how to reach a vector database other than Postgres.
+type ExternalConfig struct {
+	Backend Backend
+	// URL is the database's endpoint; empty means Backend.DefaultURL.
+	// Redis takes a redis:// or rediss:// URL, credentials included.
+	URL string
+	// APIKey is sent to Qdrant (api-key), Weaviate and Milvus (bearer
+	// token) when set.
+	APIKey string
+	// Collection names the collection (Weaviate class, Redis index)
+	// holding pooled orgs' vectors; empty means DefaultCollection.
+	Collection string
+	Dimensions int
+	// HTTPClient defaults to one with a 30s timeout.
+	HTTPClient *http.Client
+}
+
+// point is a stored chunk. Listings fill in only id, chunkIndex and
+// content.
+type point struct {
+	id         string
+	vector     []float32
+	orgID      string
+	documentID string
+	level      string
+	chunkIndex int
+	content    string
+	metadata   string
+}
+
+// hit is a search result, score being the cosine similarity.
+type hit struct {
+	content  string
+	metadata string
+	score    float32
+}
+
+// match selects points. ids, when set, selects those points alone;
+// otherwise every non-zero field must hold.
+type match struct {
+	ids         []string
+	orgID       string
+	documentIDs []string
+	// level is levelChunk, levelSummary or "" for both.
+	level string
+	// fromChunk keeps points with chunk_index >= fromChunk when > 0.
+	fromChunk int
+}
+
+// scope selects what a search may return: orgID's points or those of
+// the shared documents, that also satisfy match.
+type scope struct {
+	orgID  string
+	shared []string
+	match
+}
+
+// engine is one backend's client. Collections are named as the store
+// names them; each engine maps the name to what its database allows.
+type engine interface {
+	// ensure creates the collection and its filter indexes if missing,
+	// and returns the vector size it holds: dimensions for a new one, for
+	// an existing one its own where the backend reports it, else 0.
+	ensure(ctx context.Context, collection string, dimensions int) (int, error)
+	upsert(ctx context.Context, collection string, points []point) error
+	// search returns the limit points nearest vec in s, nearest first.
+	search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error)
+	list(ctx context.Context, collection string, m match) ([]point, error)
+	count(ctx context.Context, collection string, m match) (int64, error)
+	delete(ctx context.Context, collection string, m match) error
+	close()
+}
+
+// ExternalVectorStore keeps vectors in Qdrant, Weaviate, Milvus or
+// Redis. It implements VectorStore.
+type ExternalVectorStore struct {
+	engine   engine
+	cfg      ExternalConfig
+	embedder embedding.Embedder
+	tenants  *tenancy.Resolver
+	// ready holds the collections ensured so far, each with its vector
+	// size (0 if unknown).
+	ready *sync.Map
+}
+
+// NewExternalVectorStore connects to the vector database cfg names and
+// creates the shared collection if missing.
+func NewExternalVectorStore(ctx context.Context, tenants *tenancy.Resolver, embedder embedding.Embedder, cfg ExternalConfig) (*ExternalVectorStore, error) {
+	if cfg.URL == "" {
+		cfg.URL = cfg.Backend.DefaultURL()
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	if cfg.Collection == "" {
+		cfg.Collection = DefaultCollection
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	var (
+		e   engine
+		err error
+	)
+	rest := &restClient{base: cfg.URL, header: http.Header{}, client: cfg.HTTPClient}
+	switch cfg.Backend {
+	case BackendQdrant:
+		if cfg.APIKey != "" {
+			rest.header.Set("api-key", cfg.APIKey)
+		}
+		e = &qdrant{rest}
+	case BackendWeaviate:
+		if cfg.APIKey != "" {
+			rest.header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+		e = &weaviate{rest}
+	case BackendMilvus:
+		if cfg.APIKey != "" {
+			rest.header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+		e = &milvus{rest}
+	case BackendRedis:
+		e, err = newRedis(cfg.URL)
+	default:
+		err = fmt.Errorf("%w: %q is not an external vector store", ErrUnsupported, cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vs := &ExternalVectorStore{engine: e, cfg: cfg, embedder: embedder, tenants: tenants, ready: &sync.Map{}}
+	if _, err := vs.collection(ctx); err != nil {
+		e.close()
+		return nil, err
+	}
+	return vs, nil
+}
+
+// collection returns the collection of the org in ctx, creating it on
+// first use. An existing collection holding vectors of another size than
+// configured is refused, as pgvector's table is.
+func (vs *ExternalVectorStore) collection(ctx context.Context) (string, error) {
+	name := vs.cfg.Collection
+	if org := tenancy.OrgFrom(ctx); org != "" && vs.tenants.Isolated(org) {
+		name += "_" + org
+	}
+	if _, ok := vs.ready.Load(name); ok {
+		return name, nil
+	}
+	dims, err := vs.engine.ensure(ctx, name, vs.cfg.Dimensions)
+	if err != nil {
+		return "", fmt.Errorf("%s collection %s: %w", vs.cfg.Backend, name, err)
+	}
+	if dims > 0 && vs.cfg.Dimensions > 0 && dims != vs.cfg.Dimensions {
+		return "", fmt.Errorf("%w: %s collection %s stores %d-dimensional vectors but EMBEDDING_DIMENSIONS is %d",
+			ErrDimensionMismatch, vs.cfg.Backend, name, dims, vs.cfg.Dimensions)
+	}
+	vs.ready.Store(name, dims)
+	return name, nil
+}
+
+// checkVector validates a vector against the size of collection, which
+// must have been ensured.
+func (vs *ExternalVectorStore) checkVector(collection string, vec []float32) error {
+	dims, _ := vs.ready.Load(collection)
+	n, _ := dims.(int)
+	return checkVector(vec, collection, cmp.Or(n, vs.cfg.Dimensions))
+}
+
+// AddEmbedded stores docs with vectors computed elsewhere, vecs[i]
+// belonging to docs[i]. Points are keyed by ChunkID, so storing a chunk
+// twice leaves one.
+func (vs *ExternalVectorStore) AddEmbedded(ctx context.Context, docs []schema.Document, vecs [][]float32) error {
+	if len(docs) != len(vecs) {
+		return fmt.Errorf("%d documents but %d vectors", len(docs), len(vecs))
+	}
+	coll, err := vs.collection(ctx)
+	if err != nil {
+		return err
+	}
+	points := make([]point, len(docs))
+	for i, doc := range docs {
+		if err := vs.checkVector(coll, vecs[i]); err != nil {
+			return err
+		}
+		md, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return err
+		}
+		level, _ := doc.Metadata["level"].(string)
+		index, ok := metaInt(doc.Metadata["chunk_index"])
+		if !ok {
+			index = -1
+		}
+		points[i] = point{
+			id:         ChunkID(doc.Metadata, doc.PageContent),
+			vector:     vecs[i],
+			orgID:      fmt.Sprint(doc.Metadata["org_id"]),
+			documentID: fmt.Sprint(doc.Metadata["document_id"]),
+			level:      cmp.Or(level, levelChunk),
+			chunkIndex: index,
+			content:    doc.PageContent,
+			metadata:   string(md),
+		}
+	}
+	if err := vs.engine.upsert(ctx, coll, points); err != nil {
+		return fmt.Errorf("%s upsert: %w", vs.cfg.Backend, err)
+	}
+	return nil
+}
+
+// SimilaritySearch returns the top-k chunks nearest the query in p's
+// scope. SearchHybrid ranks by the vectors alone here; the cosine
+// similarity is also in MetaVectorScore then.
+func (vs *ExternalVectorStore) SimilaritySearch(ctx context.Context, p SearchParams) ([]schema.Document, error) {
+	coll, err := vs.collection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vec, err := vs.embedder.EmbedQuery(ctx, p.Query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	if err := vs.checkVector(coll, vec); err != nil {
+		return nil, err
+	}
+
+	limit := p.TopK
+	if p.MaxPerDocument > 0 {
+		limit *= perDocumentOverfetch
+	}
+	if len(p.Filters) > 0 {
+		limit *= filterOverfetch
+	}
+	s := scope{orgID: p.OrgID, shared: p.SharedDocumentIDs, match: match{documentIDs: p.DocumentIDs}}
+	if !p.IncludeSummaries {
+		s.level = levelChunk
+	}
+	hits, err := vs.engine.search(ctx, coll, vec, s, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s search: %w", vs.cfg.Backend, err)
+	}
+
+	docs := make([]schema.Document, 0, len(hits))
+	for _, h := range hits {
+		if h.score < p.MinScore {
+			continue
+		}
+		doc := schema.Document{PageContent: h.content, Score: h.score, Metadata: map[string]any{}}
+		if err := json.Unmarshal([]byte(h.metadata), &doc.Metadata); err != nil {
+			return nil, fmt.Errorf("%s search: chunk metadata: %w", vs.cfg.Backend, err)
+		}
+		if !matchesFilters(doc.Metadata, p.Filters) {
+			continue
+		}
+		if p.Mode == SearchHybrid {
+			doc.Metadata[MetaVectorScore] = h.score
+		}
+		docs = append(docs, doc)
+	}
+	if p.MaxPerDocument > 0 {
+		return capPerDocument(docs, p.MaxPerDocument, p.TopK), nil
+	}
+	return docs[:min(len(docs), p.TopK)], nil
+}
+
+// KeywordSearch needs Postgres full-text search.
+func (vs *ExternalVectorStore) KeywordSearch(ctx context.Context, p SearchParams) ([]schema.Document, error) {
+	return nil, fmt.Errorf("%w: keyword search needs %s", ErrUnsupported, BackendPgvector)
+}
+
+// remove deletes the points m selects, counting them first when asked.
+func (vs *ExternalVectorStore) remove(ctx context.Context, m match, counted bool) (int64, error) {
+	coll, err := vs.collection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if counted {
+		if n, err = vs.engine.count(ctx, coll, m); err != nil {
+			return 0, fmt.Errorf("%s count: %w", vs.cfg.Backend, err)
+		}
+	}
+	if err := vs.engine.delete(ctx, coll, m); err != nil {
+		return 0, fmt.Errorf("%s delete: %w", vs.cfg.Backend, err)
+	}
+	return n, nil
+}
+
+// DeleteByDocument removes all chunks (and summary nodes) of a document.
+func (vs *ExternalVectorStore) DeleteByDocument(ctx context.Context, documentID string) error {
+	_, err := vs.remove(ctx, match{documentIDs: []string{documentID}}, false)
+	return err
+}
+
+// DeleteByDocuments removes the chunks of many documents and returns the
+// number of vectors deleted.
+func (vs *ExternalVectorStore) DeleteByDocuments(ctx context.Context, documentIDs []string) (int64, error) {
+	if len(documentIDs) == 0 {
+		return 0, nil
+	}
+	return vs.remove(ctx, match{documentIDs: documentIDs}, true)
+}
+
+// DeleteChunksFrom removes a document's chunks numbered from firstChunk
+// on. From 0 it removes everything, summary nodes included.
+func (vs *ExternalVectorStore) DeleteChunksFrom(ctx context.Context, documentID string, firstChunk int) error {
+	if firstChunk == 0 {
+		return vs.DeleteByDocument(ctx, documentID)
+	}
+	_, err := vs.remove(ctx, match{documentIDs: []string{documentID}, level: levelChunk, fromChunk: firstChunk}, false)
+	return err
+}
+
+// DeleteSummaries removes a document's summary nodes.
+func (vs *ExternalVectorStore) DeleteSummaries(ctx context.Context, documentID string) error {
+	_, err := vs.remove(ctx, match{documentIDs: []string{documentID}, level: levelSummary}, false)
+	return err
+}
+
+// chunks lists a document's chunks numbered from firstChunk on.
+func (vs *ExternalVectorStore) chunks(ctx context.Context, documentID string, firstChunk int) ([]point, error) {
+	coll, err := vs.collection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	points, err := vs.engine.list(ctx, coll, match{documentIDs: []string{documentID}, level: levelChunk, fromChunk: firstChunk})
+	if err != nil {
+		return nil, fmt.Errorf("%s list: %w", vs.cfg.Backend, err)
+	}
+	return points, nil
+}
+
+// StoredChunks returns the text of a document's chunks numbered from
+// firstChunk on, by chunk index. Summary nodes are not included.
+func (vs *ExternalVectorStore) StoredChunks(ctx context.Context, documentID string, firstChunk int) (map[int]string, error) {
+	points, err := vs.chunks(ctx, documentID, firstChunk)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[int]string, len(points))
+	for _, p := range points {
+		stored[p.chunkIndex] = p.content
+	}
+	return stored, nil
+}
+
+// PruneChunks removes a document's chunks numbered from firstChunk on
+// except those in keep. Summary nodes are left alone.
+func (vs *ExternalVectorStore) PruneChunks(ctx context.Context, documentID string, firstChunk int, keep []int) error {
+	points, err := vs.chunks(ctx, documentID, firstChunk)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, p := range points {
+		if !slices.Contains(keep, p.chunkIndex) {
+			ids = append(ids, p.id)
+		}
+	}
+	for batch := range slices.Chunk(ids, pruneBatch) {
+		if _, err := vs.remove(ctx, match{ids: batch}, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeOrg removes every vector owned by an org, for org deletion.
+func (vs *ExternalVectorStore) PurgeOrg(ctx context.Context, orgID string) (int64, error) {
+	return vs.remove(ctx, match{orgID: orgID}, true)
+}
+
+// Stats returns an org's vector count. The backends don't report sizes
+// per org, so Bytes counts the vectors' own floats.
+func (vs *ExternalVectorStore) Stats(ctx context.Context, orgID string) (*VectorStats, error) {
+	ctx = tenancy.WithOrg(ctx, orgID)
+	coll, err := vs.collection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n, err := vs.engine.count(ctx, coll, match{orgID: orgID})
+	if err != nil {
+		return nil, fmt.Errorf("%s count: %w", vs.cfg.Backend, err)
+	}
+	return &VectorStats{OrgID: orgID, Vectors: n, Bytes: n * int64(vs.cfg.Dimensions) * 4}, nil
+}
+
+// AllStats returns the vector usage of every org with vectors, largest
+// first, counting each org in turn.
+func (vs *ExternalVectorStore) AllStats(ctx context.Context) ([]*VectorStats, error) {
+	rows, err := vs.tenants.Query(ctx, `SELECT id FROM organizations`)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	var all []*VectorStats
+	for _, org := range orgs {
+		st, err := vs.Stats(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		if st.Vectors > 0 {
+			all = append(all, st)
+		}
+	}
+	slices.SortFunc(all, func(a, b *VectorStats) int { return cmp.Compare(b.Bytes, a.Bytes) })
+	return all, nil
+}
+
+// WithTx returns the store itself: its writes can't join a Postgres
+// transaction, so they take effect at once.
+func (vs *ExternalVectorStore) WithTx(pgx.Tx) VectorStore {
+	return vs
+}
+
+// ForgetOrg forgets that an org's collection exists, so it is checked
+// again on next use.
+func (vs *ExternalVectorStore) ForgetOrg(orgID string) {
+	vs.ready.Delete(vs.cfg.Collection + "_" + orgID)
+}
+
+// Close releases the connections to the backend.
+func (vs *ExternalVectorStore) Close() {
+	vs.engine.close()
+}
+
+// metaInt reads a metadata number, set in process (int) or decoded from
+// JSON (float64).
+func metaInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
+
+// restClient calls a backend's JSON-over-HTTP API.
+type restClient struct {
+	base   string
+	header http.Header
+	client *http.Client
+}
+
+// statusError is a reply outside 2xx.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
+
+// notFound reports whether err is a 404 reply.
+func notFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.status == http.StatusNotFound
+}
+
+// do sends in (if not nil) as JSON and decodes the reply into out (if
+// not nil).
+func (c *restClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header.Clone()
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &statusError{status: resp.StatusCode, body: strings.TrimSpace(string(data[:min(len(data), 512)]))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
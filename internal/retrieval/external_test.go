@@ -0,0 +1,390 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// The VectorStore contract, checked against each external backend. A
+// backend runs when its URL is in the environment, for example
+//
+//	docker run -p 6333:6333 qdrant/qdrant
+//	TEST_QDRANT_URL=http://localhost:6333 go test ./internal/retrieval -run Conformance
+//
+// and is skipped otherwise. Each run uses a fresh collection and purges
+// its points when done. The in-memory engine below runs the same checks
+// everywhere, covering what ExternalVectorStore adds on top of the
+// backends (scoring, filters, per-document caps).
+
+const conformanceDimensions = 64
+
+func TestConformanceMemory(t *testing.T) {
+	testVectorStore(t, memoryStore(embedding.NewFakeEmbedder(conformanceDimensions), conformanceDimensions))
+}
+
+// memoryStore is an ExternalVectorStore on a memoryEngine.
+func memoryStore(embedder embedding.Embedder, dimensions int) *ExternalVectorStore {
+	return &ExternalVectorStore{
+		engine:   &memoryEngine{},
+		cfg:      ExternalConfig{Backend: "memory", Collection: DefaultCollection, Dimensions: dimensions},
+		embedder: embedder,
+		tenants:  tenancy.NewResolver(nil),
+		ready:    &sync.Map{},
+	}
+}
+
+// A collection holding vectors of another size is refused, and so are
+// vectors not of its size.
+func TestCollectionDimensions(t *testing.T) {
+	ctx := t.Context()
+	embedder := embedding.NewFakeEmbedder(conformanceDimensions)
+	docs := []schema.Document{chunk("acme", "doc", 0, "text", nil)}
+	vecs, err := embedder.EmbedDocuments(ctx, []string{"text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := memoryStore(embedder, conformanceDimensions)
+	vs.engine.ensure(ctx, DefaultCollection, 2*conformanceDimensions)
+	if err := vs.AddEmbedded(ctx, docs, vecs); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("stored into a collection of another size: %v", err)
+	}
+
+	vs = memoryStore(embedder, conformanceDimensions)
+	if err := vs.AddEmbedded(ctx, docs, [][]float32{vecs[0][:8]}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("stored a vector of another size: %v", err)
+	}
+	if err := vs.AddEmbedded(ctx, docs, vecs); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConformanceQdrant(t *testing.T)   { testBackend(t, BackendQdrant, "TEST_QDRANT_URL") }
+func TestConformanceWeaviate(t *testing.T) { testBackend(t, BackendWeaviate, "TEST_WEAVIATE_URL") }
+func TestConformanceMilvus(t *testing.T)   { testBackend(t, BackendMilvus, "TEST_MILVUS_URL") }
+func TestConformanceRedis(t *testing.T)    { testBackend(t, BackendRedis, "TEST_REDIS_URL") }
+
+func testBackend(t *testing.T, backend Backend, env string) {
+	url := os.Getenv(env)
+	if url == "" {
+		t.Skipf("%s not set", env)
+	}
+	vs, err := NewExternalVectorStore(t.Context(), tenancy.NewResolver(nil), embedding.NewFakeEmbedder(conformanceDimensions), ExternalConfig{
+		Backend:    backend,
+		URL:        url,
+		APIKey:     os.Getenv(env + "_API_KEY"),
+		Collection: fmt.Sprintf("conformance_%d", time.Now().UnixNano()),
+		Dimensions: conformanceDimensions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(vs.Close)
+	testVectorStore(t, vs)
+}
+
+// chunk builds a stored chunk of org's document at index; summaries get a
+// negative index, as the summary tree stores them.
+func chunk(org, doc string, index int, text string, extra map[string]any) schema.Document {
+	md := map[string]any{"org_id": org, "document_id": doc, "chunk_index": index, "doc_name": doc + ".txt"}
+	if index < 0 {
+		md["level"] = "document"
+		md["chunk_start"], md["chunk_end"] = 0, 2
+	}
+	maps.Copy(md, extra)
+	return schema.Document{PageContent: text, Metadata: md}
+}
+
+func testVectorStore(t *testing.T, vs VectorStore) {
+	ctx := t.Context()
+	embedder := embedding.NewFakeEmbedder(conformanceDimensions)
+	run := fmt.Sprint(time.Now().UnixNano())
+	orgA, orgB := "org-a-"+run, "org-b-"+run
+	docA, docB := "doc-a-"+run, "doc-b-"+run
+	t.Cleanup(func() {
+		ctx := context.WithoutCancel(ctx)
+		vs.PurgeOrg(ctx, orgA)
+		vs.PurgeOrg(ctx, orgB)
+	})
+
+	docs := []schema.Document{
+		chunk(orgA, docA, 0, "vacation policy allows twenty days", map[string]any{"tags": []any{"hr"}}),
+		chunk(orgA, docA, 1, "expense reports are due monthly", map[string]any{"tags": []any{"finance"}}),
+		chunk(orgA, docA, 2, "laptops are replaced every three years", nil),
+		chunk(orgA, docA, -1, "summary of the employee handbook", nil),
+		chunk(orgB, docB, 0, "vacation requests go through the portal", nil),
+		chunk(orgB, docB, 1, "the office closes at six", nil),
+	}
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.PageContent
+	}
+	vecs, err := embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.AddEmbedded(ctx, docs, vecs); err != nil {
+		t.Fatal(err)
+	}
+	// Storing a chunk again keeps one copy.
+	if err := vs.AddEmbedded(ctx, docs[:1], vecs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := vs.AddEmbedded(ctx, docs[:2], vecs[:1]); err == nil {
+		t.Error("AddEmbedded accepted 2 documents with 1 vector")
+	}
+
+	search := func(t *testing.T, p SearchParams) []schema.Document {
+		t.Helper()
+		p.TopK = cmp.Or(p.TopK, 10)
+		res, err := vs.SimilaritySearch(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	t.Run("scoped to the org", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "vacation policy", OrgID: orgA})
+		if len(res) != 3 {
+			t.Fatalf("got %d chunks, want org A's 3 leaf chunks", len(res))
+		}
+		for _, d := range res {
+			if d.Metadata["org_id"] != orgA {
+				t.Errorf("chunk of %v returned to %s", d.Metadata["org_id"], orgA)
+			}
+		}
+		if res[0].PageContent != docs[0].PageContent {
+			t.Errorf("nearest chunk %q, want %q", res[0].PageContent, docs[0].PageContent)
+		}
+		if !slices.IsSortedFunc(res, func(a, b schema.Document) int { return cmp.Compare(b.Score, a.Score) }) {
+			t.Error("results not ordered by score")
+		}
+		if _, ok := metaInt(res[0].Metadata["chunk_index"]); !ok {
+			t.Errorf("metadata not kept: %v", res[0].Metadata)
+		}
+	})
+
+	t.Run("shared documents", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "vacation", OrgID: orgA, SharedDocumentIDs: []string{docB}})
+		if len(res) != 5 {
+			t.Fatalf("got %d chunks, want 3 own and 2 shared", len(res))
+		}
+	})
+
+	t.Run("document restriction", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "vacation", OrgID: orgA, SharedDocumentIDs: []string{docB}, DocumentIDs: []string{docB}})
+		if len(res) != 2 || res[0].Metadata["document_id"] != docB {
+			t.Fatalf("got %d chunks, want docB's 2", len(res))
+		}
+		// Naming a document doesn't widen the scope.
+		if res := search(t, SearchParams{Query: "vacation", OrgID: orgA, DocumentIDs: []string{docB}}); len(res) != 0 {
+			t.Errorf("got %d chunks of an unshared document", len(res))
+		}
+	})
+
+	t.Run("summaries", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "handbook", OrgID: orgA, IncludeSummaries: true})
+		if len(res) != 4 || res[0].PageContent != docs[3].PageContent {
+			t.Fatalf("got %d chunks, want 4 with the summary first", len(res))
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		if res := search(t, SearchParams{Query: "vacation", OrgID: orgA, TopK: 2}); len(res) != 2 {
+			t.Errorf("TopK 2 returned %d chunks", len(res))
+		}
+		res := search(t, SearchParams{Query: "vacation", OrgID: orgA, SharedDocumentIDs: []string{docB}, MaxPerDocument: 1})
+		if len(res) != 2 {
+			t.Errorf("MaxPerDocument 1 over 2 documents returned %d chunks", len(res))
+		}
+		res = search(t, SearchParams{Query: "vacation policy allows twenty days", OrgID: orgA, MinScore: 0.99})
+		if len(res) != 1 {
+			t.Errorf("MinScore 0.99 returned %d chunks, want the exact match", len(res))
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "policy", OrgID: orgA, Filters: map[string]any{"tags": "finance"}})
+		if len(res) != 1 || res[0].PageContent != docs[1].PageContent {
+			t.Errorf("tag filter returned %d chunks", len(res))
+		}
+		res = search(t, SearchParams{Query: "policy", OrgID: orgA, Filters: map[string]any{"doc_name": map[string]any{"prefix": "doc-b"}}})
+		if len(res) != 0 {
+			t.Errorf("prefix filter widened the scope: %d chunks", len(res))
+		}
+	})
+
+	t.Run("hybrid falls back to vectors", func(t *testing.T) {
+		res := search(t, SearchParams{Query: "vacation", OrgID: orgA, Mode: SearchHybrid})
+		if len(res) == 0 {
+			t.Fatal("no results")
+		}
+		if _, ok := res[0].Metadata[MetaVectorScore].(float32); !ok {
+			t.Error("hybrid result without a vector score")
+		}
+		if _, err := vs.KeywordSearch(ctx, SearchParams{Query: "vacation", OrgID: orgA, TopK: 1}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("KeywordSearch: %v, want ErrUnsupported", err)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		st, err := vs.Stats(ctx, orgA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Vectors != 4 {
+			t.Errorf("org A has %d vectors, want 4", st.Vectors)
+		}
+	})
+
+	t.Run("chunk maintenance", func(t *testing.T) {
+		stored, err := vs.StoredChunks(ctx, docA, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[int]string{1: docs[1].PageContent, 2: docs[2].PageContent}; !maps.Equal(stored, want) {
+			t.Fatalf("StoredChunks from 1 = %v, want %v", stored, want)
+		}
+		if err := vs.PruneChunks(ctx, docA, 0, []int{0, 2}); err != nil {
+			t.Fatal(err)
+		}
+		if stored, _ = vs.StoredChunks(ctx, docA, 0); !slices.Equal(slices.Sorted(maps.Keys(stored)), []int{0, 2}) {
+			t.Fatalf("after PruneChunks keeping 0 and 2: %v", stored)
+		}
+		if err := vs.DeleteChunksFrom(ctx, docA, 1); err != nil {
+			t.Fatal(err)
+		}
+		if stored, _ = vs.StoredChunks(ctx, docA, 0); !slices.Equal(slices.Sorted(maps.Keys(stored)), []int{0}) {
+			t.Fatalf("after DeleteChunksFrom 1: %v", stored)
+		}
+		if err := vs.DeleteSummaries(ctx, docA); err != nil {
+			t.Fatal(err)
+		}
+		if res := search(t, SearchParams{Query: "handbook", OrgID: orgA, IncludeSummaries: true}); len(res) != 1 {
+			t.Errorf("after DeleteSummaries: %d chunks, want chunk 0 alone", len(res))
+		}
+	})
+
+	t.Run("deletes", func(t *testing.T) {
+		n, err := vs.DeleteByDocuments(ctx, []string{docB})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("DeleteByDocuments removed %d vectors, want 2", n)
+		}
+		if n, err = vs.PurgeOrg(ctx, orgA); err != nil || n != 1 {
+			t.Errorf("PurgeOrg = %d, %v; want 1 vector", n, err)
+		}
+		if res := search(t, SearchParams{Query: "vacation", OrgID: orgA, SharedDocumentIDs: []string{docB}, IncludeSummaries: true}); len(res) != 0 {
+			t.Errorf("%d chunks left after deleting everything", len(res))
+		}
+	})
+}
+
+// memoryEngine is an engine keeping points in memory, searched by brute
+// force.
+type memoryEngine struct {
+	mu         sync.Mutex
+	points     map[string]map[string]point
+	dimensions map[string]int
+}
+
+func (m *memoryEngine) ensure(ctx context.Context, collection string, dimensions int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.points == nil {
+		m.points = map[string]map[string]point{}
+		m.dimensions = map[string]int{}
+	}
+	if m.points[collection] == nil {
+		m.points[collection] = map[string]point{}
+		m.dimensions[collection] = dimensions
+	}
+	return m.dimensions[collection], nil
+}
+
+func (m *memoryEngine) upsert(ctx context.Context, collection string, points []point) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range points {
+		m.points[collection][p.id] = p
+	}
+	return nil
+}
+
+func (m *memoryEngine) search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hits []hit
+	for _, p := range m.points[collection] {
+		if !memoryMatch(p, s.match) || (p.orgID != s.orgID && !slices.Contains(s.shared, p.documentID)) {
+			continue
+		}
+		var dot float32
+		for i := range vec {
+			dot += vec[i] * p.vector[i]
+		}
+		hits = append(hits, hit{content: p.content, metadata: p.metadata, score: dot})
+	}
+	slices.SortFunc(hits, func(a, b hit) int { return cmp.Compare(b.score, a.score) })
+	return hits[:min(len(hits), limit)], nil
+}
+
+func (m *memoryEngine) list(ctx context.Context, collection string, mt match) ([]point, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var points []point
+	for _, p := range m.points[collection] {
+		if memoryMatch(p, mt) {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+func (m *memoryEngine) count(ctx context.Context, collection string, mt match) (int64, error) {
+	points, err := m.list(ctx, collection, mt)
+	return int64(len(points)), err
+}
+
+func (m *memoryEngine) delete(ctx context.Context, collection string, mt match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, p := range m.points[collection] {
+		if memoryMatch(p, mt) {
+			delete(m.points[collection], id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryEngine) close() {}
+
+func memoryMatch(p point, m match) bool {
+	if len(m.ids) > 0 {
+		return slices.Contains(m.ids, p.id)
+	}
+	switch {
+	case m.orgID != "" && p.orgID != m.orgID,
+		len(m.documentIDs) > 0 && !slices.Contains(m.documentIDs, p.documentID),
+		m.level == levelChunk && p.level != levelChunk,
+		m.level == levelSummary && p.level == levelChunk,
+		m.fromChunk > 0 && p.chunkIndex < m.fromChunk:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+)
+
+// Provider fallback
+//
+// With a fallback chain set (FallBackTo), an answer the LLM fails to give,
+// by returning an error or by sending no token for the stall timeout, is
+// asked of the next provider in the chain, then the one after. Each switch
+// is reported to the query's OnProviderSwitch. Tokens already streamed
+// can't be taken back, so the next provider answers from the start and the
+// switch says so (Restart).
+
+// Fallback is one LLM of a fallback chain, answering with its own model.
+type Fallback struct {
+	Provider string
+	Model    string
+	Client   LLMClient
+}
+
+func (f Fallback) name() string {
+	return f.Provider + ":" + f.Model
+}
+
+// ProviderSwitch reports that an answer moved to the next provider.
+type ProviderSwitch struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Reason is "failed" or "stalled".
+	Reason string `json:"reason"`
+	// Restart is set when part of the answer was already sent: the new
+	// provider starts over, so it should be discarded.
+	Restart bool `json:"restart"`
+}
+
+// errStalled ends an attempt that sent no token for the stall timeout.
+var errStalled = errors.New("LLM stopped sending tokens")
+
+// FallBackTo makes answers the service's LLM fails to give fall back to
+// chain in order. primary names that LLM's provider and default model for
+// switch events; its Client is unused. A provider that sends nothing for
+// stall is given up on too; zero waits as long as the provider's own
+// timeout.
+func (s *RAGService) FallBackTo(primary Fallback, chain []Fallback, stall time.Duration) {
+	s.primary, s.fallbacks, s.stall = primary, chain, stall
+}
+
+// generate streams the answer to out, going down the fallback chain as
+// providers fail. It closes out.
+func (s *RAGService) generate(ctx context.Context, req QueryRequest, system, user string, out chan<- string) error {
+	opts := llm.CompletionOptions{Model: req.Model, Generation: req.Generation}
+	if len(s.fallbacks) == 0 {
+		return s.llm.StreamCompletion(ctx, system, user, opts, out)
+	}
+	defer close(out)
+
+	client, name := s.llm, s.primary.name()
+	if req.Model != "" {
+		name = s.primary.Provider + ":" + req.Model
+	}
+	for i := 0; ; i++ {
+		sent, err := s.attempt(ctx, client, system, user, opts, out)
+		if err == nil || ctx.Err() != nil || i == len(s.fallbacks) {
+			return err
+		}
+		next := s.fallbacks[i]
+		slog.Warn("LLM failed, falling back", "from", name, "to", next.name(), "tokens_sent", sent, "error", err)
+		if req.OnProviderSwitch != nil {
+			reason := "failed"
+			if errors.Is(err, errStalled) {
+				reason = "stalled"
+			}
+			req.OnProviderSwitch(ProviderSwitch{From: name, To: next.name(), Reason: reason, Restart: sent})
+		}
+		client, name = next.Client, next.name()
+		opts.Model = next.Model
+	}
+}
+
+// attempt streams one provider's answer to out, giving up with errStalled
+// when no token arrives for the stall timeout. It reports whether any token
+// was sent.
+func (s *RAGService) attempt(ctx context.Context, c LLMClient, system, user string, opts llm.CompletionOptions, out chan<- string) (sent bool, err error) {
+	actx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	tokens := make(chan string)
+	errc := make(chan error, 1)
+	go func() { errc <- c.StreamCompletion(actx, system, user, opts, tokens) }()
+
+	var stalled <-chan time.Time
+	var timer *time.Timer
+	if s.stall > 0 {
+		timer = time.NewTimer(s.stall)
+		defer timer.Stop()
+		stalled = timer.C
+	}
+	for {
+		select {
+		case t, ok := <-tokens:
+			if !ok {
+				err := <-errc
+				if cause := context.Cause(actx); errors.Is(cause, errStalled) {
+					return sent, cause
+				}
+				return sent, err
+			}
+			if actx.Err() != nil {
+				continue // given up on; only draining now
+			}
+			if timer != nil {
+				timer.Reset(s.stall)
+			}
+			select {
+			case out <- t:
+				sent = true
+			case <-ctx.Done():
+				// The reader is gone; drain so the provider can finish.
+			}
+		case <-stalled:
+			// The provider closes tokens once it notices.
+			cancel(errStalled)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Metadata filters
+// A query can narrow retrieval to chunks whose metadata matches. Chunks
+// carry their document's metadata plus doc_name, document_id and the
+// like, so {"doc_name": "handbook.pdf"} asks one document and
+// {"tags": ["hr", "legal"]} the documents tagged with either. A value of
+// {"prefix": "..."} matches strings starting with it, so
+// {"path": {"prefix": "policies/"}} scopes a query to a folder subtree.
+// Filters are AND-ed with each other and with the org scope, which they
+// can't widen.
+
+// maxFilters bounds the filters of one query.
+const maxFilters = 20
+
+// ErrInvalidFilter is returned for a filter that can't be applied.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ValidateFilters checks query filters: each key must be non-empty and
+// each value a string, number or boolean, which the metadata value must
+// equal or (for a list) contain, a {"prefix": "..."} object, or a
+// non-empty list of those, any of which must match.
+func ValidateFilters(filters map[string]any) error {
+	if len(filters) > maxFilters {
+		return fmt.Errorf("%w: at most %d filters are allowed", ErrInvalidFilter, maxFilters)
+	}
+	for k, v := range filters {
+		if k == "" {
+			return fmt.Errorf("%w: filter keys must not be empty", ErrInvalidFilter)
+		}
+		if list, ok := v.([]any); ok {
+			if len(list) == 0 {
+				return fmt.Errorf("%w: filter %q has no values", ErrInvalidFilter, k)
+			}
+			for _, item := range list {
+				if !filterScalar(item) {
+					return fmt.Errorf("%w: filter %q must list strings, numbers, booleans or prefixes", ErrInvalidFilter, k)
+				}
+			}
+			continue
+		}
+		if !filterScalar(v) {
+			return fmt.Errorf("%w: filter %q must be a string, number, boolean, prefix or a list of them", ErrInvalidFilter, k)
+		}
+	}
+	return nil
+}
+
+func filterScalar(v any) bool {
+	switch v := v.(type) {
+	case string, float64, bool:
+		return true
+	case map[string]any:
+		prefix, ok := v["prefix"].(string)
+		return ok && prefix != "" && len(v) == 1
+	}
+	return false
+}
+
+// filtersArg binds filters as $8; nil skips filtering.
+func filtersArg(filters map[string]any) any {
+	if len(filters) == 0 {
+		return nil
+	}
+	return filters
+}
+
+// filterScope is the searchScope condition for the filters in $8 (jsonb):
+// no filter may fail. A value matches a metadata value equal to it or a
+// metadata list containing it, and a prefix a metadata string starting
+// with it; a list of values matches if any does.
+const filterScope = `($8::jsonb IS NULL OR NOT EXISTS (
+		       SELECT 1 FROM jsonb_each($8::jsonb) f(key, want)
+		       WHERE NOT EXISTS (
+		           SELECT 1
+		           FROM jsonb_array_elements(CASE jsonb_typeof(f.want) WHEN 'array' THEN f.want ELSE jsonb_build_array(f.want) END) w(value)
+		           WHERE e.cmetadata::jsonb->f.key = w.value
+		              OR (jsonb_typeof(e.cmetadata::jsonb->f.key) = 'array' AND e.cmetadata::jsonb->f.key @> jsonb_build_array(w.value))
+		              OR (jsonb_typeof(w.value) = 'object' AND starts_with(e.cmetadata::jsonb->>f.key, w.value->>'prefix'))
+		       )
+		   ))`
+
+// matchesFilters applies filters to a chunk's metadata the way
+// filterScope does, for stores that can't evaluate them in the query.
+// metadata holds decoded JSON, so its numbers are float64 like the
+// filters'.
+func matchesFilters(metadata, filters map[string]any) bool {
+	for key, want := range filters {
+		values, ok := want.([]any)
+		if !ok {
+			values = []any{want}
+		}
+		if !slices.ContainsFunc(values, func(w any) bool { return filterMatches(metadata[key], w) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterMatches reports whether a metadata value satisfies one filter
+// value.
+func filterMatches(have, want any) bool {
+	if p, ok := want.(map[string]any); ok {
+		s, ok := have.(string)
+		prefix, _ := p["prefix"].(string)
+		return ok && strings.HasPrefix(s, prefix)
+	}
+	if list, ok := have.([]any); ok {
+		return slices.ContainsFunc(list, func(item any) bool { return scalarEqual(item, want) })
+	}
+	return scalarEqual(have, want)
+}
+
+func scalarEqual(a, b any) bool {
+	switch a.(type) {
+	case string, float64, bool:
+		return a == b
+	}
+	return false
+}
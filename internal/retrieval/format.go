@@ -0,0 +1,244 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// Output formats
+//
+// Models answer in markdown, which chat UIs render but emails and
+// plain-text widgets show as stray asterisks and pound signs. A query's
+// OutputFormat adjusts the prompt's formatting instructions and converts
+// the answer as it streams: plain text has leftover markdown stripped,
+// and HTML is rendered from the markdown (raw HTML the model writes is
+// escaped, never passed through). Converted answers stream a line (plain)
+// or a block (HTML) at a time.
+
+// OutputFormat is the markup an answer is written in.
+type OutputFormat string
+
+const (
+	// FormatMarkdown leaves the model's markdown as is (the default).
+	FormatMarkdown OutputFormat = "markdown"
+	FormatPlain    OutputFormat = "plain"
+	FormatHTML     OutputFormat = "html"
+)
+
+// ParseOutputFormat validates an output_format value; empty means
+// FormatMarkdown.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(s); f {
+	case "":
+		return FormatMarkdown, nil
+	case FormatMarkdown, FormatPlain, FormatHTML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (want %q, %q or %q)", s, FormatMarkdown, FormatPlain, FormatHTML)
+	}
+}
+
+// formatRules are added to the system prompt per format.
+var formatRules = map[OutputFormat]string{
+	FormatPlain: `Write plain text without any markdown: no asterisks, underscores, backticks, pound signs, tables or bracketed links.
+Separate paragraphs with blank lines and start list items with "- ".`,
+	FormatHTML: `Format with markdown only (paragraphs, lists, bold, italics, links and tables), never raw HTML.`,
+}
+
+// formatter converts an answer as it streams.
+type formatter interface {
+	// write takes the next piece of the answer and returns what can be
+	// sent converted so far.
+	write(text string) string
+	// flush converts and returns everything held back, and starts over.
+	flush() string
+}
+
+// formatAnswers converts what is sent on the returned channel to format
+// and forwards it to out, closing out after the returned channel. flush
+// sends everything held back on to out before it returns. A nil channel
+// means there is nothing to convert: send to out directly.
+func formatAnswers(ctx context.Context, format OutputFormat, out chan<- string) (in chan<- string, flush func()) {
+	var f formatter
+	switch format {
+	case FormatPlain:
+		f = &plainFormatter{}
+	case FormatHTML:
+		f = &htmlFormatter{md: markdown.New(markdown.HTML(false), markdown.Linkify(false), markdown.Typographer(false))}
+	default:
+		return nil, nil
+	}
+
+	tokens := make(chan string)
+	flushes := make(chan chan struct{})
+	send := func(text string) {
+		if text == "" {
+			return
+		}
+		select {
+		case out <- text:
+		case <-ctx.Done():
+			// The reader is gone; drain so generation can finish.
+		}
+	}
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case t, ok := <-tokens:
+				if !ok {
+					send(f.flush())
+					return
+				}
+				send(f.write(t))
+			case flushed := <-flushes:
+				send(f.flush())
+				close(flushed)
+			}
+		}
+	}()
+	return tokens, func() {
+		flushed := make(chan struct{})
+		flushes <- flushed
+		<-flushed
+	}
+}
+
+// lines splits off the complete lines of what a formatter holds.
+type lines struct {
+	buf strings.Builder
+}
+
+// add appends text and returns the lines it completed, without their
+// newlines.
+func (l *lines) add(text string) []string {
+	l.buf.WriteString(text)
+	held := l.buf.String()
+	i := strings.LastIndexByte(held, '\n')
+	if i < 0 {
+		return nil
+	}
+	l.buf.Reset()
+	l.buf.WriteString(held[i+1:])
+	return strings.Split(held[:i], "\n")
+}
+
+// rest returns the incomplete last line, and forgets it.
+func (l *lines) rest() string {
+	s := l.buf.String()
+	l.buf.Reset()
+	return s
+}
+
+var (
+	mdHeading   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	mdBullet    = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	mdQuote     = regexp.MustCompile(`^\s{0,3}>\s?`)
+	mdRule      = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+	mdTableRule = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+	mdImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdStrong    = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdEmphasis  = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	mdCode      = regexp.MustCompile("`([^`]+)`")
+)
+
+// plainFormatter strips markdown a line at a time. Code blocks keep
+// their contents and lose their fences.
+type plainFormatter struct {
+	lines   lines
+	inFence bool
+}
+
+func (f *plainFormatter) write(text string) string {
+	var sb strings.Builder
+	for _, line := range f.lines.add(text) {
+		if line, ok := f.line(line); ok {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func (f *plainFormatter) flush() string {
+	line, ok := f.line(f.lines.rest())
+	f.inFence = false
+	if !ok {
+		return ""
+	}
+	return line
+}
+
+// line converts one line, reporting false for lines that are dropped.
+func (f *plainFormatter) line(line string) (string, bool) {
+	if strings.HasPrefix(strings.TrimSpace(line), "```") {
+		f.inFence = !f.inFence
+		return "", false
+	}
+	if f.inFence {
+		return line, true
+	}
+	if mdRule.MatchString(line) || (strings.Contains(line, "|") && mdTableRule.MatchString(line)) {
+		return "", false
+	}
+	line = mdHeading.ReplaceAllString(line, "")
+	line = mdQuote.ReplaceAllString(line, "")
+	line = mdBullet.ReplaceAllString(line, "$1- ")
+	if t := strings.TrimSpace(line); strings.HasPrefix(t, "|") && strings.HasSuffix(t, "|") && len(t) > 1 {
+		cells := strings.Split(t[1:len(t)-1], "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		line = strings.Join(cells, " | ")
+	}
+	line = mdImage.ReplaceAllString(line, "$1")
+	line = mdLink.ReplaceAllString(line, "$1 ($2)")
+	line = mdCode.ReplaceAllString(line, "$1")
+	line = mdStrong.ReplaceAllString(line, "$2")
+	line = mdEmphasis.ReplaceAllString(line, "$1$2")
+	return line, true
+}
+
+// htmlFormatter renders markdown a block at a time: a block ends at a
+// blank line outside a code block.
+type htmlFormatter struct {
+	md      *markdown.Markdown
+	lines   lines
+	block   strings.Builder
+	inFence bool
+}
+
+func (f *htmlFormatter) write(text string) string {
+	var sb strings.Builder
+	for _, line := range f.lines.add(text) {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			f.inFence = !f.inFence
+		}
+		if strings.TrimSpace(line) == "" && !f.inFence {
+			sb.WriteString(f.render())
+			continue
+		}
+		f.block.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+func (f *htmlFormatter) flush() string {
+	f.block.WriteString(f.lines.rest())
+	f.inFence = false
+	return f.render()
+}
+
+// render converts the block held and forgets it.
+func (f *htmlFormatter) render() string {
+	block := f.block.String()
+	f.block.Reset()
+	if strings.TrimSpace(block) == "" {
+		return ""
+	}
+	return f.md.RenderToString([]byte(block))
+}
@@ -0,0 +1,261 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// milvus speaks Milvus' RESTful API (v2). Each collection has a fixed
+// schema with the chunk fields as scalar columns and an AUTOINDEX over
+// the vectors by cosine similarity.
+type milvus struct {
+	*restClient
+}
+
+const (
+	// milvusPageSize is how many entities one listing query returns;
+	// offset plus limit may not pass 16384.
+	milvusPageSize = 1000
+	// milvusMaxText is the longest VarChar Milvus stores, in bytes.
+	milvusMaxText = 65535
+)
+
+// name maps a collection name to one Milvus allows: letters, digits
+// and underscores.
+func (mv *milvus) name(collection string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, collection)
+}
+
+// call posts to a v2 endpoint and decodes the data of a successful
+// reply into out.
+func (mv *milvus) call(ctx context.Context, path string, in map[string]any, out any) error {
+	var resp struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := mv.do(ctx, http.MethodPost, "/v2/vectordb"+path, in, &resp); err != nil {
+		return err
+	}
+	if resp.Code != 0 {
+		return fmt.Errorf("code %d: %s", resp.Code, resp.Message)
+	}
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+func (mv *milvus) ensure(ctx context.Context, collection string, dimensions int) (int, error) {
+	name := mv.name(collection)
+	var has struct {
+		Has bool `json:"has"`
+	}
+	if err := mv.call(ctx, "/collections/has", map[string]any{"collectionName": name}, &has); err != nil {
+		return 0, err
+	}
+	if has.Has {
+		return mv.dimensions(ctx, name)
+	}
+	varchar := func(field string, length int) map[string]any {
+		return map[string]any{"fieldName": field, "dataType": "VarChar", "elementTypeParams": map[string]any{"max_length": strconv.Itoa(length)}}
+	}
+	id := varchar("id", 64)
+	id["isPrimary"] = true
+	// Collections created with index parameters are loaded straight away.
+	return dimensions, mv.call(ctx, "/collections/create", map[string]any{
+		"collectionName": name,
+		"schema": map[string]any{
+			"autoId":             false,
+			"enableDynamicField": false,
+			"fields": []any{
+				id,
+				map[string]any{"fieldName": "vector", "dataType": "FloatVector", "elementTypeParams": map[string]any{"dim": strconv.Itoa(dimensions)}},
+				varchar("org_id", 256), varchar("document_id", 256), varchar("level", 64),
+				map[string]any{"fieldName": "chunk_index", "dataType": "Int64"},
+				varchar("content", milvusMaxText), varchar("metadata", milvusMaxText),
+			},
+		},
+		"indexParams": []any{
+			map[string]any{"fieldName": "vector", "indexName": "vector", "metricType": "COSINE", "indexType": "AUTOINDEX"},
+		},
+	}, nil)
+}
+
+// dimensions returns the size of the vector field of the collection
+// named name.
+func (mv *milvus) dimensions(ctx context.Context, name string) (int, error) {
+	var desc struct {
+		Fields []struct {
+			Name   string `json:"name"`
+			Params []struct {
+				Key   string `json:"key"`
+				Value any    `json:"value"`
+			} `json:"params"`
+		} `json:"fields"`
+	}
+	if err := mv.call(ctx, "/collections/describe", map[string]any{"collectionName": name}, &desc); err != nil {
+		return 0, err
+	}
+	for _, f := range desc.Fields {
+		if f.Name != "vector" {
+			continue
+		}
+		for _, p := range f.Params {
+			if p.Key == "dim" {
+				n, _ := strconv.Atoi(fmt.Sprint(p.Value))
+				return n, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (mv *milvus) upsert(ctx context.Context, collection string, points []point) error {
+	rows := make([]map[string]any, len(points))
+	for i, p := range points {
+		if len(p.content) > milvusMaxText || len(p.metadata) > milvusMaxText {
+			return fmt.Errorf("chunk %s is longer than the %d bytes Milvus stores", p.id, milvusMaxText)
+		}
+		rows[i] = map[string]any{
+			"id":          p.id,
+			"vector":      p.vector,
+			"org_id":      p.orgID,
+			"document_id": p.documentID,
+			"level":       p.level,
+			"chunk_index": p.chunkIndex,
+			"content":     p.content,
+			"metadata":    p.metadata,
+		}
+	}
+	return mv.call(ctx, "/entities/upsert", map[string]any{"collectionName": mv.name(collection), "data": rows}, nil)
+}
+
+// milvusString quotes s for a filter expression.
+func milvusString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func milvusList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = milvusString(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// expr translates m into a filter expression; "" matches everything.
+func (mv *milvus) expr(m match) string {
+	var terms []string
+	if len(m.ids) > 0 {
+		terms = append(terms, "id in "+milvusList(m.ids))
+	}
+	if m.orgID != "" {
+		terms = append(terms, "org_id == "+milvusString(m.orgID))
+	}
+	if len(m.documentIDs) > 0 {
+		terms = append(terms, "document_id in "+milvusList(m.documentIDs))
+	}
+	switch m.level {
+	case levelChunk:
+		terms = append(terms, "level == "+milvusString(levelChunk))
+	case levelSummary:
+		terms = append(terms, "level != "+milvusString(levelChunk))
+	}
+	if m.fromChunk > 0 {
+		terms = append(terms, "chunk_index >= "+strconv.Itoa(m.fromChunk))
+	}
+	return strings.Join(terms, " and ")
+}
+
+func (mv *milvus) search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error) {
+	filter := "org_id == " + milvusString(s.orgID)
+	if len(s.shared) > 0 {
+		filter = "(" + filter + " or document_id in " + milvusList(s.shared) + ")"
+	}
+	if rest := mv.expr(s.match); rest != "" {
+		filter += " and " + rest
+	}
+	var results []struct {
+		Distance float32 `json:"distance"`
+		Content  string  `json:"content"`
+		Metadata string  `json:"metadata"`
+	}
+	if err := mv.call(ctx, "/entities/search", map[string]any{
+		"collectionName": mv.name(collection),
+		"data":           [][]float32{vec},
+		"annsField":      "vector",
+		"filter":         filter,
+		"limit":          limit,
+		"outputFields":   []string{"content", "metadata"},
+	}, &results); err != nil {
+		return nil, err
+	}
+	// With the COSINE metric, distance is the similarity.
+	hits := make([]hit, len(results))
+	for i, r := range results {
+		hits[i] = hit{content: r.Content, metadata: r.Metadata, score: r.Distance}
+	}
+	return hits, nil
+}
+
+func (mv *milvus) list(ctx context.Context, collection string, m match) ([]point, error) {
+	var points []point
+	for offset := 0; ; offset += milvusPageSize {
+		var rows []struct {
+			ID         string `json:"id"`
+			ChunkIndex int    `json:"chunk_index"`
+			Content    string `json:"content"`
+		}
+		if err := mv.call(ctx, "/entities/query", map[string]any{
+			"collectionName": mv.name(collection),
+			"filter":         mv.expr(m),
+			"outputFields":   []string{"id", "chunk_index", "content"},
+			"limit":          milvusPageSize,
+			"offset":         offset,
+		}, &rows); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			points = append(points, point{id: r.ID, chunkIndex: r.ChunkIndex, content: r.Content})
+		}
+		if len(rows) < milvusPageSize {
+			return points, nil
+		}
+	}
+}
+
+func (mv *milvus) count(ctx context.Context, collection string, m match) (int64, error) {
+	var rows []map[string]json.Number
+	if err := mv.call(ctx, "/entities/query", map[string]any{
+		"collectionName": mv.name(collection),
+		"filter":         mv.expr(m),
+		"outputFields":   []string{"count(*)"},
+	}, &rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, errors.New("no count returned")
+	}
+	return rows[0]["count(*)"].Int64()
+}
+
+func (mv *milvus) delete(ctx context.Context, collection string, m match) error {
+	return mv.call(ctx, "/entities/delete", map[string]any{
+		"collectionName": mv.name(collection),
+		"filter":         mv.expr(m),
+	}, nil)
+}
+
+func (mv *milvus) close() {}
@@ -0,0 +1,191 @@
+package retrieval
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// qdrant speaks Qdrant's REST API. Points carry the chunk fields as
+// payload, with keyword and integer indexes on the filtered ones.
+type qdrant struct {
+	*restClient
+}
+
+// qdrantPageSize is how many points one scroll request returns.
+const qdrantPageSize = 256
+
+func (q *qdrant) path(collection, rest string) string {
+	return "/collections/" + url.PathEscape(collection) + rest
+}
+
+func (q *qdrant) ensure(ctx context.Context, collection string, dimensions int) (int, error) {
+	var info struct {
+		Result struct {
+			Config struct {
+				Params struct {
+					Vectors struct {
+						Size int `json:"size"`
+					} `json:"vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	err := q.do(ctx, http.MethodGet, q.path(collection, ""), nil, &info)
+	if err == nil || !notFound(err) {
+		return info.Result.Config.Params.Vectors.Size, err
+	}
+	if err := q.do(ctx, http.MethodPut, q.path(collection, ""), map[string]any{
+		"vectors": map[string]any{"size": dimensions, "distance": "Cosine"},
+	}, nil); err != nil {
+		return 0, err
+	}
+	indexes := map[string]string{"org_id": "keyword", "document_id": "keyword", "level": "keyword", "chunk_index": "integer"}
+	for field, schema := range indexes {
+		if err := q.do(ctx, http.MethodPut, q.path(collection, "/index?wait=true"), map[string]any{
+			"field_name": field, "field_schema": schema,
+		}, nil); err != nil {
+			return 0, err
+		}
+	}
+	return dimensions, nil
+}
+
+func (q *qdrant) upsert(ctx context.Context, collection string, points []point) error {
+	body := make([]map[string]any, len(points))
+	for i, p := range points {
+		body[i] = map[string]any{
+			"id":     p.id,
+			"vector": p.vector,
+			"payload": map[string]any{
+				"org_id":      p.orgID,
+				"document_id": p.documentID,
+				"level":       p.level,
+				"chunk_index": p.chunkIndex,
+				"content":     p.content,
+				"metadata":    p.metadata,
+			},
+		}
+	}
+	return q.do(ctx, http.MethodPut, q.path(collection, "/points?wait=true"), map[string]any{"points": body}, nil)
+}
+
+// filter translates m into a Qdrant filter.
+func (q *qdrant) filter(m match) map[string]any {
+	var must, mustNot []any
+	if len(m.ids) > 0 {
+		must = append(must, map[string]any{"has_id": m.ids})
+	}
+	if m.orgID != "" {
+		must = append(must, map[string]any{"key": "org_id", "match": map[string]any{"value": m.orgID}})
+	}
+	if len(m.documentIDs) > 0 {
+		must = append(must, map[string]any{"key": "document_id", "match": map[string]any{"any": m.documentIDs}})
+	}
+	level := map[string]any{"key": "level", "match": map[string]any{"value": levelChunk}}
+	switch m.level {
+	case levelChunk:
+		must = append(must, level)
+	case levelSummary:
+		mustNot = append(mustNot, level)
+	}
+	if m.fromChunk > 0 {
+		must = append(must, map[string]any{"key": "chunk_index", "range": map[string]any{"gte": m.fromChunk}})
+	}
+	f := map[string]any{}
+	if must != nil {
+		f["must"] = must
+	}
+	if mustNot != nil {
+		f["must_not"] = mustNot
+	}
+	return f
+}
+
+func (q *qdrant) search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error) {
+	f := q.filter(s.match)
+	owner := []any{map[string]any{"key": "org_id", "match": map[string]any{"value": s.orgID}}}
+	if len(s.shared) > 0 {
+		owner = append(owner, map[string]any{"key": "document_id", "match": map[string]any{"any": s.shared}})
+	}
+	must, _ := f["must"].([]any)
+	f["must"] = append(must, map[string]any{"should": owner})
+
+	var resp struct {
+		Result []struct {
+			Score   float32 `json:"score"`
+			Payload struct {
+				Content  string `json:"content"`
+				Metadata string `json:"metadata"`
+			} `json:"payload"`
+		} `json:"result"`
+	}
+	if err := q.do(ctx, http.MethodPost, q.path(collection, "/points/search"), map[string]any{
+		"vector": vec, "filter": f, "limit": limit, "with_payload": []string{"content", "metadata"},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	hits := make([]hit, len(resp.Result))
+	for i, r := range resp.Result {
+		hits[i] = hit{content: r.Payload.Content, metadata: r.Payload.Metadata, score: r.Score}
+	}
+	return hits, nil
+}
+
+func (q *qdrant) list(ctx context.Context, collection string, m match) ([]point, error) {
+	var (
+		points []point
+		offset any
+	)
+	for {
+		var resp struct {
+			Result struct {
+				Points []struct {
+					ID      any `json:"id"`
+					Payload struct {
+						ChunkIndex int    `json:"chunk_index"`
+						Content    string `json:"content"`
+					} `json:"payload"`
+				} `json:"points"`
+				NextPageOffset any `json:"next_page_offset"`
+			} `json:"result"`
+		}
+		req := map[string]any{
+			"filter": q.filter(m), "limit": qdrantPageSize,
+			"with_payload": []string{"chunk_index", "content"}, "with_vector": false,
+		}
+		if offset != nil {
+			req["offset"] = offset
+		}
+		if err := q.do(ctx, http.MethodPost, q.path(collection, "/points/scroll"), req, &resp); err != nil {
+			return nil, err
+		}
+		for _, p := range resp.Result.Points {
+			id, _ := p.ID.(string)
+			points = append(points, point{id: id, chunkIndex: p.Payload.ChunkIndex, content: p.Payload.Content})
+		}
+		if offset = resp.Result.NextPageOffset; offset == nil {
+			return points, nil
+		}
+	}
+}
+
+func (q *qdrant) count(ctx context.Context, collection string, m match) (int64, error) {
+	var resp struct {
+		Result struct {
+			Count int64 `json:"count"`
+		} `json:"result"`
+	}
+	err := q.do(ctx, http.MethodPost, q.path(collection, "/points/count"), map[string]any{
+		"filter": q.filter(m), "exact": true,
+	}, &resp)
+	return resp.Result.Count, err
+}
+
+func (q *qdrant) delete(ctx context.Context, collection string, m match) error {
+	return q.do(ctx, http.MethodPost, q.path(collection, "/points/delete?wait=true"), map[string]any{
+		"filter": q.filter(m),
+	}, nil)
+}
+
+func (q *qdrant) close() {}
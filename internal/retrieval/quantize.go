@@ -0,0 +1,248 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+)
+
+// Quantized vector search
+// The embedding column always keeps full-precision vectors; quantization
+// only changes the ANN index. A halfvec index stores 16-bit floats and takes
+// half the memory of the full index; a bit index keeps one bit per
+// dimension (binary_quantize) and is compared by Hamming distance. Searches
+// order by the same expression so the planner uses the index, then
+// optionally re-score the top candidates by exact cosine distance against
+// the stored vectors to recover the precision the index gave up.
+
+// Quantization selects the precision of the ANN index.
+type Quantization string
+
+const (
+	// QuantizeNone indexes full-precision vectors (the default).
+	QuantizeNone Quantization = "none"
+	// QuantizeHalfvec indexes 16-bit floats; also lifts pgvector's
+	// 2000-dimension HNSW limit to 4000.
+	QuantizeHalfvec Quantization = "halfvec"
+	// QuantizeBit indexes one bit per dimension. Much smaller and faster,
+	// but coarse: meant to be used with rescoring.
+	QuantizeBit Quantization = "bit"
+)
+
+// ParseQuantization validates a VECTOR_QUANTIZATION value; empty means
+// QuantizeNone.
+func ParseQuantization(s string) (Quantization, error) {
+	switch q := Quantization(s); q {
+	case "":
+		return QuantizeNone, nil
+	case QuantizeNone, QuantizeHalfvec, QuantizeBit:
+		return q, nil
+	default:
+		return "", fmt.Errorf("unknown vector quantization %q (want %q, %q or %q)", s, QuantizeNone, QuantizeHalfvec, QuantizeBit)
+	}
+}
+
+const (
+	// HNSW build parameters, shared by every index variant.
+	hnswM              = 16
+	hnswEfConstruction = 64
+	// fullIndex is the full-precision index langchaingo creates.
+	fullIndex = EmbeddingTable + "_embedding_hnsw"
+	// rescoreOverfetch is how many quantized candidates per requested
+	// result are re-scored exactly.
+	rescoreOverfetch = 4
+	// maxHalfvecDimensions is pgvector's limit for indexing halfvec.
+	maxHalfvecDimensions = 4000
+)
+
+// VectorConfig describes the stored vectors and how they are indexed.
+type VectorConfig struct {
+	// Dimensions must match the embedding model's output.
+	Dimensions   int
+	Quantization Quantization
+	// Rescore re-ranks quantized candidates by exact cosine distance.
+	// Ignored with QuantizeNone.
+	Rescore bool
+	// Collections is whether pooled orgs share an index; empty means
+	// CollectionsShared.
+	Collections CollectionMode
+}
+
+func (c VectorConfig) validate() error {
+	if c.Quantization == QuantizeHalfvec && c.Dimensions > maxHalfvecDimensions {
+		return fmt.Errorf("halfvec quantization supports at most %d dimensions, got %d", maxHalfvecDimensions, c.Dimensions)
+	}
+	return nil
+}
+
+func (c VectorConfig) quantized() bool {
+	return c.Quantization == QuantizeHalfvec || c.Quantization == QuantizeBit
+}
+
+// distance is the ranking expression for query vector $1; it repeats the
+// index expression exactly, or the index isn't used.
+func (c VectorConfig) distance() string {
+	switch c.Quantization {
+	case QuantizeHalfvec:
+		return fmt.Sprintf("e.embedding::halfvec(%[1]d) <=> $1::vector::halfvec(%[1]d)", c.Dimensions)
+	case QuantizeBit:
+		return fmt.Sprintf("binary_quantize(e.embedding)::bit(%d) <~> binary_quantize($1::vector)", c.Dimensions)
+	default:
+		return "e.embedding <=> $1"
+	}
+}
+
+// indexMethod is the access method, expression and build parameters of
+// the index variant q.
+func (c VectorConfig) indexMethod(q Quantization) string {
+	with := fmt.Sprintf("WITH (m = %d, ef_construction = %d)", hnswM, hnswEfConstruction)
+	switch q {
+	case QuantizeHalfvec:
+		return fmt.Sprintf("hnsw ((embedding::halfvec(%d)) halfvec_cosine_ops) %s", c.Dimensions, with)
+	case QuantizeBit:
+		return fmt.Sprintf("hnsw ((binary_quantize(embedding)::bit(%d)) bit_hamming_ops) %s", c.Dimensions, with)
+	default:
+		return "hnsw (embedding vector_cosine_ops) " + with
+	}
+}
+
+// indexes maps each quantized index name to its definition.
+func (c VectorConfig) indexes() map[Quantization]string {
+	indexes := map[Quantization]string{}
+	for _, q := range []Quantization{QuantizeHalfvec, QuantizeBit} {
+		indexes[q] = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_embedding_%s ON %s USING %s`,
+			q, EmbeddingTable, c.indexMethod(q))
+	}
+	return indexes
+}
+
+// createANNIndex creates the configured quantized index on db's embedding
+// table and drops the ones no longer searched, the full-precision index
+// included, so their memory is actually given back. Switching back to
+// QuantizeNone lets langchaingo rebuild the full index. Unless global, the
+// storage's collections are indexed one by one (see indexCollections) and
+// the configured index is neither created nor dropped: RunSplit drops it
+// once nothing is left to search with it.
+func (c VectorConfig) createANNIndex(ctx context.Context, db database.DBTX, global bool) error {
+	for q, create := range c.indexes() {
+		stmt := "DROP INDEX IF EXISTS idx_embedding_" + string(q)
+		if q == c.Quantization {
+			if !global {
+				continue
+			}
+			stmt = create
+		}
+		if _, err := db.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("create %s index: %w", q, err)
+		}
+	}
+	if c.quantized() && global {
+		if _, err := db.Exec(ctx, "DROP INDEX IF EXISTS "+fullIndex); err != nil {
+			return fmt.Errorf("drop full-precision index: %w", err)
+		}
+	}
+	return nil
+}
+
+// collectionIndexPrefix starts the names of the partial indexes of single
+// collections, followed by the quantization and the collection's UUID.
+const collectionIndexPrefix = "idx_collection_"
+
+// indexCollections gives each collection in ids (UUIDs) a partial index
+// of the configured variant over its rows on db's embedding table, and
+// drops every other collection index there: other variants, indexes of
+// collections not in ids, and builds that failed halfway. Indexes are
+// built and dropped CONCURRENTLY, so writes go on meanwhile; db must not
+// be a transaction.
+func (c VectorConfig) indexCollections(ctx context.Context, db database.DBTX, ids []string) error {
+	want := map[string]string{}
+	for _, id := range ids {
+		want[collectionIndexPrefix+string(c.Quantization)+"_"+strings.ReplaceAll(id, "-", "")] = id
+	}
+	rows, err := db.Query(ctx,
+		`SELECT ic.relname::text, i.indisvalid FROM pg_index i JOIN pg_class ic ON ic.oid = i.indexrelid
+		 WHERE i.indrelid = to_regclass($1) AND starts_with(ic.relname::text, $2)`,
+		EmbeddingTable, collectionIndexPrefix)
+	if err != nil {
+		return fmt.Errorf("list collection indexes: %w", err)
+	}
+	valid := map[string]bool{}
+	var (
+		name string
+		ok   bool
+	)
+	if _, err := pgx.ForEachRow(rows, []any{&name, &ok}, func() error {
+		valid[name] = ok
+		return nil
+	}); err != nil {
+		return fmt.Errorf("list collection indexes: %w", err)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(valid)) {
+		if _, wanted := want[name]; wanted && valid[name] {
+			delete(want, name)
+			continue
+		}
+		if _, err := db.Exec(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+name); err != nil {
+			return fmt.Errorf("drop index %s: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(want)) {
+		if _, err := db.Exec(ctx, fmt.Sprintf(
+			`CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s USING %s WHERE collection_id = '%s'`,
+			name, EmbeddingTable, c.indexMethod(c.Quantization), want[name])); err != nil {
+			return fmt.Errorf("index collection %s: %w", want[name], err)
+		}
+	}
+	return nil
+}
+
+// dropSharedIndexes drops the ANN indexes over every collection in db's
+// embedding table.
+func dropSharedIndexes(ctx context.Context, db database.DBTX) error {
+	for _, name := range []string{fullIndex, "idx_embedding_" + string(QuantizeHalfvec), "idx_embedding_" + string(QuantizeBit)} {
+		if _, err := db.Exec(ctx, "DROP INDEX CONCURRENTLY IF EXISTS "+name); err != nil {
+			return fmt.Errorf("drop index %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// candidatesSQL selects the uuid and ranking distance of the nearest chunks
+// in the search scope, at most limit (a placeholder) of them. The org's own
+// chunks are ranked apart, in its collection (the UUID placeholder
+// collection) alone, so the index over just that collection can serve
+// them when there is one; granted documents are few and ranked on their
+// own. With rescoring, rescoreOverfetch times as many are taken from the
+// quantized index and the exact distance picks the final ones.
+func (c VectorConfig) candidatesSQL(limit, collection string) string {
+	fetch, distance := limit, "r.distance"
+	if c.quantized() && c.Rescore {
+		fetch, distance = fmt.Sprintf("%s * %d", limit, rescoreOverfetch), "r.embedding <=> $1"
+	}
+	return fmt.Sprintf(`SELECT r.uuid, %[8]s AS distance
+			 FROM (
+				 (SELECT e.uuid, e.embedding, %[5]s AS distance
+				  FROM %[1]s e
+				  WHERE e.collection_id = %[7]s::uuid AND e.cmetadata->>'org_id' = $3
+				    AND %[4]s
+				  ORDER BY %[5]s
+				  LIMIT %[6]s)
+				 UNION ALL
+				 (SELECT e.uuid, e.embedding, %[5]s AS distance
+				  FROM %[1]s e
+				  JOIN %[2]s c ON c.uuid = e.collection_id
+				  WHERE cardinality($4::text[]) > 0 AND e.cmetadata->>'org_id' <> $3
+				    AND %[3]s
+				  ORDER BY %[5]s
+				  LIMIT %[6]s)
+			 ) r
+			 ORDER BY %[8]s
+			 LIMIT %[9]s`, EmbeddingTable, CollectionTable, searchScope, scopeFilters, c.distance(),
+		fetch, collection, distance, limit)
+}
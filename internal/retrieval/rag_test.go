@@ -0,0 +1,191 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Queries end to end with the fake providers, as LLM_PROVIDER=fake runs
+// them: documents embedded by the fake embedder, retrieved within the
+// org's scope and answered by the fake LLM, with neither network access
+// nor keys.
+
+type grantMap map[string][]string
+
+func (g grantMap) SharedDocumentIDs(ctx context.Context, orgID string) ([]string, error) {
+	return g[orgID], nil
+}
+
+type collectionMap map[string][]string
+
+func (c collectionMap) CollectionDocumentIDs(ctx context.Context, orgID string, ids []string) ([]string, error) {
+	var docs []string
+	for _, id := range ids {
+		docs = append(docs, c[id]...)
+	}
+	return docs, nil
+}
+
+type ragFixture struct {
+	svc    *RAGService
+	grants grantMap
+}
+
+func newRAGFixture(t *testing.T) *ragFixture {
+	t.Helper()
+	ctx := t.Context()
+	embedder, err := embedding.NewProvider(embedding.ProviderFake, embedding.Config{Dimensions: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(script, []byte(`[{"match": "refund", "response": "Refunds are issued within 14 days [1]."}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := llm.New(llm.ProviderFake, llm.Config{Script: script})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := memoryStore(embedder, 256)
+	docs := []schema.Document{
+		chunk("acme", "policies", 0, "Refunds are issued within 14 days of the return.", nil),
+		chunk("acme", "policies", 1, "Shipping takes three business days within the country.", nil),
+		chunk("acme", "handbook", 0, "The office is closed on public holidays.", nil),
+		chunk("globex", "pricing", 0, "Globex enterprise pricing starts at 900 dollars.", nil),
+		chunk("acme", CollectionSummaryID("shipping"), -1, "The shipping collection covers delivery times and refunds.",
+			map[string]any{"level": "collection", "collection_id": "shipping", "doc_name": "Shipping"}),
+	}
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.PageContent
+	}
+	vecs, err := embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddEmbedded(ctx, docs, vecs); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &ragFixture{grants: grantMap{}}
+	f.svc = NewRAGService(store, client, f.grants, collectionMap{"shipping": {"policies"}})
+	return f
+}
+
+// ask runs a query and returns its answer and sources.
+func (f *ragFixture) ask(t *testing.T, req QueryRequest) (string, []Source) {
+	t.Helper()
+	out := make(chan string)
+	var sources []Source
+	errc := make(chan error, 1)
+	go func() {
+		errc <- f.svc.QueryWithSources(t.Context(), req, func(s []Source, _ Degradation) { sources = s }, out)
+	}()
+	var answer strings.Builder
+	for token := range out {
+		answer.WriteString(token)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return answer.String(), sources
+}
+
+func documentsOf(sources []Source) []string {
+	var ids []string
+	for _, s := range sources {
+		if !slices.Contains(ids, s.DocumentID) {
+			ids = append(ids, s.DocumentID)
+		}
+	}
+	return ids
+}
+
+func TestQueryScripted(t *testing.T) {
+	f := newRAGFixture(t)
+	answer, sources := f.ask(t, QueryRequest{OrgID: "acme", Question: "When are REFUNDS issued?", TopK: 3})
+	if answer != "Refunds are issued within 14 days [1]." {
+		t.Errorf("answer %q", answer)
+	}
+	if len(sources) == 0 || !strings.HasPrefix(sources[0].Excerpt, "Refunds are issued") {
+		t.Errorf("top source %+v, want the refund chunk", sources)
+	}
+}
+
+func TestQueryDeterministic(t *testing.T) {
+	f := newRAGFixture(t)
+	req := QueryRequest{OrgID: "acme", Question: "How long does shipping take?", TopK: 2}
+	answer, sources := f.ask(t, req)
+	want := fmt.Sprintf("Fake answer to %q based on %d context chunks [1].", req.Question, len(sources))
+	if answer != want {
+		t.Errorf("answer %q, want %q", answer, want)
+	}
+	if len(sources) != 2 || sources[0].DocumentID != "policies" {
+		t.Errorf("sources %+v, want 2 led by the shipping chunk", sources)
+	}
+	if again, _ := f.ask(t, req); again != answer {
+		t.Errorf("asked again: %q, want %q", again, answer)
+	}
+}
+
+func TestQueryScope(t *testing.T) {
+	f := newRAGFixture(t)
+	question := "What does enterprise pricing start at?"
+	if _, sources := f.ask(t, QueryRequest{OrgID: "acme", Question: question, TopK: 10}); slices.Contains(documentsOf(sources), "pricing") {
+		t.Fatal("another org's document retrieved")
+	}
+
+	f.grants["acme"] = []string{"pricing"}
+	_, sources := f.ask(t, QueryRequest{OrgID: "acme", Question: question, TopK: 10})
+	if len(sources) == 0 || sources[0].DocumentID != "pricing" {
+		t.Errorf("sources %v, want the shared pricing document first", documentsOf(sources))
+	}
+	_, sources = f.ask(t, QueryRequest{OrgID: "acme", Question: question, TopK: 10, SkipGrants: true})
+	if slices.Contains(documentsOf(sources), "pricing") {
+		t.Error("shared document retrieved with SkipGrants")
+	}
+
+	_, sources = f.ask(t, QueryRequest{OrgID: "acme", Question: "office holidays", TopK: 10, CollectionIDs: []string{"shipping"}})
+	if docs := documentsOf(sources); !slices.Equal(docs, []string{"policies"}) {
+		t.Errorf("collection query retrieved %v, want policies alone", docs)
+	}
+}
+
+func TestQueryCollectionSummary(t *testing.T) {
+	f := newRAGFixture(t)
+	isSummary := func(s Source) bool { return s.CollectionID != "" }
+	req := QueryRequest{OrgID: "acme", Question: "What does the shipping collection cover?", TopK: 3, CollectionIDs: []string{"shipping"}}
+	if _, sources := f.ask(t, req); slices.ContainsFunc(sources, isSummary) {
+		t.Error("collection summary retrieved without IncludeSummaries")
+	}
+
+	req.IncludeSummaries = true
+	_, sources := f.ask(t, req)
+	if len(sources) == 0 || sources[0].CollectionID != "shipping" || sources[0].DocumentID != "" || sources[0].DocName != "Shipping" {
+		t.Errorf("top source %+v, want the collection summary", sources)
+	}
+
+	// The summary covers more than the documents asked for.
+	req.DocumentIDs = []string{"policies"}
+	if _, sources := f.ask(t, req); slices.ContainsFunc(sources, isSummary) {
+		t.Error("collection summary retrieved within DocumentIDs")
+	}
+}
+
+func TestQueryNoContext(t *testing.T) {
+	f := newRAGFixture(t)
+	answer, sources := f.ask(t, QueryRequest{OrgID: "initech", Question: "What is the refund policy?"})
+	if answer != NoContextAnswer || len(sources) != 0 {
+		t.Errorf("answer %q with %d sources, want NoContextAnswer", answer, len(sources))
+	}
+}
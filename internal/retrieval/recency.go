@@ -0,0 +1,76 @@
+package retrieval
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Recency boost
+//
+// News and blog content (feed connectors) goes stale: of two equally
+// relevant chunks, the newer should win. With a half-life set
+// (BoostRecent), a chunk whose metadata carries MetaPublishedAt has its
+// ranking score (the rerank score when reranking ran, else the search
+// score) multiplied by 1 + weight·2^(−age/half-life): a chunk published
+// now gains weight, one a half-life old half of it, and old ones almost
+// nothing. Chunks without a publish date keep their score. The boost only
+// reorders; the scores reported in sources are left as they were.
+
+// MetaPublishedAt is the chunk metadata key holding when its document was
+// published, in RFC 3339. Feed connectors set it on every entry.
+const MetaPublishedAt = "published_at"
+
+// recencyOverfetch is how many times TopK chunks are fetched with the
+// boost on, so recent chunks just below the cut can move into it.
+const recencyOverfetch = 2
+
+// BoostRecent ranks recently published chunks higher, as described above.
+// A zero halfLife turns the boost off.
+func (s *RAGService) BoostRecent(halfLife time.Duration, weight float64) {
+	s.recencyHalfLife, s.recencyWeight = halfLife, weight
+}
+
+// boostRecent reorders docs by their recency-boosted ranking score.
+func (s *RAGService) boostRecent(docs []schema.Document, now time.Time) []schema.Document {
+	boosted := make([]float64, len(docs))
+	for i, doc := range docs {
+		boosted[i] = rankingScore(doc) * s.recencyFactor(doc, now)
+	}
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(boosted[b], boosted[a]) })
+	ranked := make([]schema.Document, len(docs))
+	for i, j := range order {
+		ranked[i] = docs[j]
+	}
+	return ranked
+}
+
+// recencyFactor is the multiplier for doc's age; 1 without a usable
+// publish date. Dates in the future count as now.
+func (s *RAGService) recencyFactor(doc schema.Document, now time.Time) float64 {
+	v, _ := doc.Metadata[MetaPublishedAt].(string)
+	published, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 1
+	}
+	age := max(now.Sub(published), 0)
+	return 1 + s.recencyWeight*math.Exp2(-float64(age)/float64(s.recencyHalfLife))
+}
+
+// rankingScore is the score retrieval ranked doc by. Negative scores (some
+// cross-encoders return logits) count as zero, keeping their order after
+// the rest, as boosting them would lower them.
+func rankingScore(doc schema.Document) float64 {
+	score := float64(doc.Score)
+	if v, ok := doc.Metadata[MetaRerankScore].(float32); ok {
+		score = float64(v)
+	}
+	return max(score, 0)
+}
@@ -0,0 +1,498 @@
+package retrieval
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// redis keeps each point in a hash under the collection's key prefix,
+// indexed by RediSearch (FT.CREATE) with tag fields for filtering and an
+// HNSW vector field, and searches with KNN queries. It speaks RESP2 over
+// a small pool of connections.
+type redis struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	conns    chan *redisConn
+}
+
+const (
+	// redisPoolSize is how many idle connections are kept.
+	redisPoolSize = 8
+	// redisPageSize is how many keys one listing query returns.
+	redisPageSize = 1000
+	// redisTimeout bounds a command whose context has no deadline.
+	redisTimeout = 30 * time.Second
+)
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedis parses a redis:// or rediss:// URL: credentials, host and
+// port (default 6379) and the database number as its path.
+func newRedis(rawURL string) (*redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("%w: the redis vector store needs a redis:// or rediss:// URL", ErrUnsupported)
+	}
+	r := &redis{addr: u.Host, conns: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("%w: redis database %q is not a number", ErrUnsupported, db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		r.tls = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+	}
+	return r, nil
+}
+
+// conn takes an idle connection or dials a new one, with ctx's deadline.
+func (r *redis) conn(ctx context.Context) (*redisConn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	select {
+	case c := <-r.conns:
+		return c, c.SetDeadline(deadline)
+	default:
+	}
+
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.tls != nil {
+		raw = tls.Client(raw, r.tls)
+	}
+	c := &redisConn{Conn: raw, r: bufio.NewReader(raw)}
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return nil, err
+	}
+	var setup [][]any
+	if r.password != "" {
+		if r.username != "" {
+			setup = append(setup, []any{"AUTH", r.username, r.password})
+		} else {
+			setup = append(setup, []any{"AUTH", r.password})
+		}
+	}
+	if r.db != 0 {
+		setup = append(setup, []any{"SELECT", r.db})
+	}
+	for _, cmd := range setup {
+		if _, err := c.roundTrip(cmd); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// release returns c to the pool, or closes it if it may be out of step.
+func (r *redis) release(c *redisConn, err error) {
+	var reply redisError
+	if err != nil && !errors.As(err, &reply) {
+		c.Close()
+		return
+	}
+	select {
+	case r.conns <- c:
+	default:
+		c.Close()
+	}
+}
+
+// pipeline sends cmds at once and returns their replies in order; error
+// replies are returned as redisError values.
+func (r *redis) pipeline(ctx context.Context, cmds [][]any) (replies []any, err error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { r.release(c, err) }()
+	w := bufio.NewWriter(c)
+	for _, cmd := range cmds {
+		writeCommand(w, cmd)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	replies = make([]any, len(cmds))
+	for i := range cmds {
+		if replies[i], err = c.read(); err != nil {
+			var reply redisError
+			if !errors.As(err, &reply) {
+				return nil, err
+			}
+			replies[i], err = reply, nil
+		}
+	}
+	return replies, nil
+}
+
+// call runs one command.
+func (r *redis) call(ctx context.Context, args ...any) (any, error) {
+	replies, err := r.pipeline(ctx, [][]any{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(redisError); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+func (c *redisConn) roundTrip(cmd []any) (any, error) {
+	w := bufio.NewWriter(c)
+	writeCommand(w, cmd)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func writeCommand(w *bufio.Writer, args []any) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch a := arg.(type) {
+		case []byte:
+			b = a
+		case string:
+			b = []byte(a)
+		case int:
+			b = strconv.AppendInt(nil, int64(a), 10)
+		default:
+			b = fmt.Append(nil, a)
+		}
+		fmt.Fprintf(w, "$%d\r\n", len(b))
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+}
+
+// read decodes one reply: a string, int64, []any, nil, or a redisError
+// as the error.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			// An error inside an array is a value, not a failed read.
+			item, err := c.read()
+			var reply redisError
+			if err != nil && !errors.As(err, &reply) {
+				return nil, err
+			}
+			items[i] = item
+			if err != nil {
+				items[i] = reply
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (r *redis) prefix(collection string) string {
+	return collection + ":"
+}
+
+// ensure reports the vector size of an index it creates only; FT.INFO's
+// reply differs between RediSearch versions.
+func (r *redis) ensure(ctx context.Context, collection string, dimensions int) (int, error) {
+	_, err := r.call(ctx, "FT.INFO", collection)
+	var reply redisError
+	if err == nil || !errors.As(err, &reply) {
+		return 0, err
+	}
+	if msg := strings.ToLower(string(reply)); !strings.Contains(msg, "unknown index") && !strings.Contains(msg, "no such index") {
+		return 0, err
+	}
+	_, err = r.call(ctx, "FT.CREATE", collection, "ON", "HASH", "PREFIX", 1, r.prefix(collection), "SCHEMA",
+		"org_id", "TAG", "document_id", "TAG", "level", "TAG", "chunk_index", "NUMERIC",
+		"vector", "VECTOR", "HNSW", 6, "TYPE", "FLOAT32", "DIM", dimensions, "DISTANCE_METRIC", "COSINE")
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "index already exists") {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return dimensions, nil
+}
+
+func (r *redis) upsert(ctx context.Context, collection string, points []point) error {
+	cmds := make([][]any, len(points))
+	for i, p := range points {
+		blob := make([]byte, 4*len(p.vector))
+		for j, f := range p.vector {
+			binary.LittleEndian.PutUint32(blob[4*j:], math.Float32bits(f))
+		}
+		cmds[i] = []any{"HSET", r.prefix(collection) + p.id,
+			"org_id", p.orgID, "document_id", p.documentID, "level", p.level,
+			"chunk_index", p.chunkIndex, "content", p.content, "metadata", p.metadata, "vector", blob}
+	}
+	replies, err := r.pipeline(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if e, ok := reply.(redisError); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// redisTag escapes a value for a tag query: everything but letters,
+// digits and underscores.
+func redisTag(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func redisTags(field string, values []string) string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = redisTag(v)
+	}
+	return "@" + field + ":{" + strings.Join(escaped, " | ") + "}"
+}
+
+// query translates m (without ids) into a query; "*" matches
+// everything.
+func (r *redis) query(m match) string {
+	var terms []string
+	if m.orgID != "" {
+		terms = append(terms, redisTags("org_id", []string{m.orgID}))
+	}
+	if len(m.documentIDs) > 0 {
+		terms = append(terms, redisTags("document_id", m.documentIDs))
+	}
+	switch m.level {
+	case levelChunk:
+		terms = append(terms, redisTags("level", []string{levelChunk}))
+	case levelSummary:
+		terms = append(terms, "-"+redisTags("level", []string{levelChunk}))
+	}
+	if m.fromChunk > 0 {
+		terms = append(terms, fmt.Sprintf("@chunk_index:[%d +inf]", m.fromChunk))
+	}
+	if len(terms) == 0 {
+		return "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// results reads an FT.SEARCH reply: the keys with their fields.
+func (r *redis) results(reply any) ([]string, []map[string]string, error) {
+	items, ok := reply.([]any)
+	if !ok || len(items) == 0 {
+		return nil, nil, fmt.Errorf("redis: unexpected search reply %T", reply)
+	}
+	var (
+		keys   []string
+		fields []map[string]string
+	)
+	for i := 1; i < len(items); i++ {
+		key, _ := items[i].(string)
+		keys = append(keys, key)
+		f := map[string]string{}
+		if i+1 < len(items) {
+			if list, ok := items[i+1].([]any); ok {
+				for j := 0; j+1 < len(list); j += 2 {
+					name, _ := list[j].(string)
+					value, _ := list[j+1].(string)
+					f[name] = value
+				}
+				i++
+			}
+		}
+		fields = append(fields, f)
+	}
+	return keys, fields, nil
+}
+
+func (r *redis) search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error) {
+	filter := redisTags("org_id", []string{s.orgID})
+	if len(s.shared) > 0 {
+		filter = "(" + filter + " | " + redisTags("document_id", s.shared) + ")"
+	}
+	if rest := r.query(s.match); rest != "*" {
+		filter += " " + rest
+	}
+	blob := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(blob[4*i:], math.Float32bits(f))
+	}
+	reply, err := r.call(ctx, "FT.SEARCH", collection,
+		fmt.Sprintf("(%s)=>[KNN %d @vector $vec AS dist]", filter, limit),
+		"PARAMS", 2, "vec", blob, "SORTBY", "dist", "ASC",
+		"RETURN", 3, "content", "metadata", "dist", "LIMIT", 0, limit, "DIALECT", 2)
+	if err != nil {
+		return nil, err
+	}
+	_, fields, err := r.results(reply)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]hit, len(fields))
+	for i, f := range fields {
+		dist, _ := strconv.ParseFloat(f["dist"], 32)
+		hits[i] = hit{content: f["content"], metadata: f["metadata"], score: 1 - float32(dist)}
+	}
+	return hits, nil
+}
+
+func (r *redis) list(ctx context.Context, collection string, m match) ([]point, error) {
+	var points []point
+	for offset := 0; ; offset += redisPageSize {
+		reply, err := r.call(ctx, "FT.SEARCH", collection, r.query(m),
+			"RETURN", 2, "chunk_index", "content", "LIMIT", offset, redisPageSize, "DIALECT", 2)
+		if err != nil {
+			return nil, err
+		}
+		keys, fields, err := r.results(reply)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			index, _ := strconv.Atoi(fields[i]["chunk_index"])
+			points = append(points, point{
+				id:         strings.TrimPrefix(key, r.prefix(collection)),
+				chunkIndex: index,
+				content:    fields[i]["content"],
+			})
+		}
+		if len(keys) < redisPageSize {
+			return points, nil
+		}
+	}
+}
+
+func (r *redis) count(ctx context.Context, collection string, m match) (int64, error) {
+	reply, err := r.call(ctx, "FT.SEARCH", collection, r.query(m), "LIMIT", 0, 0, "DIALECT", 2)
+	if err != nil {
+		return 0, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) == 0 {
+		return 0, fmt.Errorf("redis: unexpected search reply %T", reply)
+	}
+	n, _ := items[0].(int64)
+	return n, nil
+}
+
+// delete removes the points by key, finding them first unless m names
+// them.
+func (r *redis) delete(ctx context.Context, collection string, m match) error {
+	del := func(ids []string) error {
+		args := []any{"DEL"}
+		for _, id := range ids {
+			args = append(args, r.prefix(collection)+id)
+		}
+		_, err := r.call(ctx, args...)
+		return err
+	}
+	if len(m.ids) > 0 {
+		return del(m.ids)
+	}
+	for {
+		reply, err := r.call(ctx, "FT.SEARCH", collection, r.query(m), "NOCONTENT", "LIMIT", 0, redisPageSize, "DIALECT", 2)
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) == 0 {
+			return fmt.Errorf("redis: unexpected search reply %T", reply)
+		}
+		if len(items) == 1 {
+			return nil
+		}
+		ids := make([]string, 0, len(items)-1)
+		for _, item := range items[1:] {
+			key, _ := item.(string)
+			ids = append(ids, strings.TrimPrefix(key, r.prefix(collection)))
+		}
+		if err := del(ids); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *redis) close() {
+	for {
+		select {
+		case c := <-r.conns:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -3,14 +3,28 @@
 package retrieval
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pgvector/pgvector-go"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
 	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/slo"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
 	"github.com/tmc/langchaingo/schema"
-	"github.com/tmc/langchaingo/vectorstores"
 	lcpgvector "github.com/tmc/langchaingo/vectorstores/pgvector"
 )
 
@@ -22,78 +36,840 @@ import (
 //   - Provides AddDocuments (embed + upsert) and SimilaritySearch in one call
 //   - Supports HNSW index creation via WithHNSWIndex option
 
+const (
+	// EmbeddingTable is the table langchaingo's pgvector store writes chunks to.
+	// Chunk metadata (org_id, document_id, doc_name) lives in its cmetadata column.
+	EmbeddingTable = "langchain_pg_embedding"
+	// CollectionTable maps collection names to the collection_id on each chunk.
+	CollectionTable = "langchain_pg_collection"
+
+	collectionName = "rag_documents"
+)
+
 type LangChainVectorStore struct {
 	store    lcpgvector.Store
+	db       database.DBTX // the tenancy resolver, or a tx via WithTx
 	embedder embedding.Embedder
+	cfg      VectorConfig
+	tenants  *tenancy.Resolver
+	isolated *isolatedStores
+	// owned holds the orgs claimCollections has seen to.
+	owned *sync.Map
+	// vectorSQL and hybridSQL are the search queries for cfg's index.
+	vectorSQL string
+	hybridSQL string
+}
+
+// isolatedStores holds one langchaingo store per org with isolated
+// storage, created on the org's first vector operation.
+type isolatedStores struct {
+	opts   []lcpgvector.Option
+	mu     sync.Mutex
+	stores map[string]lcpgvector.Store
 }
 
 // NewLangChainVectorStore initialises a langchaingo pgvector Store.
-// It will auto-create the embedding/collection tables on first use.
+// It will auto-create the embedding/collection tables on first use, in the
+// shared database and in each isolated org's schema or database.
 func NewLangChainVectorStore(
 	ctx context.Context,
-	db *pgxpool.Pool,
+	tenants *tenancy.Resolver,
 	embedder embedding.Embedder,
 	connURL string,
+	cfg VectorConfig,
 ) (*LangChainVectorStore, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	// langchaingo's pgvector store needs the embedder as its own interface.
 	// We adapt our internal Embedder to langchaingo's embeddings.Embedder.
-	lcEmbedder := &langchainEmbedderAdapter{inner: embedder}
+	lcEmbedder := &langchainEmbedderAdapter{inner: embedder, dimensions: cfg.Dimensions}
 
-	store, err := lcpgvector.New(
-		ctx,
-		lcpgvector.WithConnectionURL(connURL),
+	opts := []lcpgvector.Option{
 		lcpgvector.WithEmbedder(lcEmbedder),
-		lcpgvector.WithCollectionName("rag_documents"),
-		lcpgvector.WithVectorDimensions(1536), // text-embedding-3-small
+		lcpgvector.WithCollectionName(collectionName),
+		lcpgvector.WithCollectionMetadata(map[string]any{
+			"dimensions":   cfg.Dimensions,
+			"quantization": cfg.Quantization,
+		}),
+		lcpgvector.WithVectorDimensions(cfg.Dimensions), // must match the embedding model
+	}
+	// Isolated orgs' tables always have the index over every collection;
+	// the shared one only while pooled orgs share it.
+	sharedOpts := opts
+	if !cfg.quantized() {
 		// Create HNSW index for sub-linear ANN search
-		lcpgvector.WithHNSWIndex(16, 64, "cosine"),
-	)
+		opts = append(opts, lcpgvector.WithHNSWIndex(hnswM, hnswEfConstruction, "vector_cosine_ops"))
+		if cfg.Collections != CollectionsPerOrg {
+			sharedOpts = opts
+		}
+	}
+	if err := checkDimensions(ctx, tenants, cfg.Dimensions); err != nil {
+		return nil, err
+	}
+	store, err := lcpgvector.New(ctx, append([]lcpgvector.Option{lcpgvector.WithConnectionURL(connURL)}, sharedOpts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("init langchaingo pgvector store: %w", err)
 	}
+	if err := createIndexes(ctx, tenants, cfg, cfg.Collections != CollectionsPerOrg); err != nil {
+		return nil, err
+	}
+	if cfg.Collections != CollectionsPerOrg {
+		// Collection indexes left from CollectionsPerOrg.
+		if err := cfg.indexCollections(ctx, tenants, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := createCollectionPointers(ctx, tenants); err != nil {
+		return nil, err
+	}
+	if err := secureVectors(ctx, tenants); err != nil {
+		return nil, err
+	}
+	if err := installStats(ctx, tenants); err != nil {
+		return nil, err
+	}
 
-	return &LangChainVectorStore{store: store, embedder: embedder}, nil
+	return &LangChainVectorStore{
+		store:     store,
+		db:        tenants,
+		embedder:  embedder,
+		cfg:       cfg,
+		tenants:   tenants,
+		isolated:  &isolatedStores{opts: opts, stores: map[string]lcpgvector.Store{}},
+		owned:     &sync.Map{},
+		vectorSQL: vectorSearchSQL(cfg.candidatesSQL("$5", "$9")),
+		hybridSQL: hybridSearchSQL(cfg.candidatesSQL("$10", "$11")),
+	}, nil
 }
 
-// AddDocuments embeds and stores a batch of langchaingo schema.Documents.
-// This is called by the ingestion worker after text splitting.
-func (vs *LangChainVectorStore) AddDocuments(ctx context.Context, docs []schema.Document) error {
-	_, err := vs.store.AddDocuments(ctx, docs)
-	return err
+// ErrDimensionMismatch is returned when a vector doesn't have the
+// dimensions the embedding table was created with.
+var ErrDimensionMismatch = embedding.ErrDimensionMismatch
+
+// checkDimensions refuses an embedding table created for a different vector
+// size than configured: langchaingo only creates the table once, and
+// pgvector would reject every insert and query against it. Changing
+// EMBEDDING_DIMENSIONS means re-embedding into a fresh table. Collections
+// recording another size are refused likewise.
+func checkDimensions(ctx context.Context, db database.DBTX, dimensions int) error {
+	stored, err := StoredDimensions(ctx, db)
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return nil
+	}
+	if stored != dimensions {
+		return fmt.Errorf("%w: %s stores %d-dimensional vectors but EMBEDDING_DIMENSIONS is %d; "+
+			"re-embed the corpus into a new table or restore the previous setting",
+			ErrDimensionMismatch, EmbeddingTable, stored, dimensions)
+	}
+	var (
+		name     string
+		recorded int
+	)
+	err = db.QueryRow(ctx,
+		`SELECT c.name, `+recordedDimensions+` FROM `+CollectionTable+` c
+		 WHERE `+recordedDimensions+` NOT IN (0, $1) LIMIT 1`, dimensions).Scan(&name, &recorded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check collection dimensions: %w", err)
+	}
+	return fmt.Errorf("%w: collection %s records %d-dimensional vectors but EMBEDDING_DIMENSIONS is %d",
+		ErrDimensionMismatch, name, recorded, dimensions)
+}
+
+// StoredDimensions returns the vector size of db's embedding table, 0 if
+// it hasn't been created yet.
+func StoredDimensions(ctx context.Context, db database.DBTX) (int, error) {
+	var stored *int32
+	err := db.QueryRow(ctx,
+		`SELECT a.atttypmod FROM pg_attribute a
+		 WHERE a.attrelid = to_regclass($1) AND a.attname = 'embedding' AND NOT a.attisdropped`,
+		EmbeddingTable).Scan(&stored)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil // first start: the table is created with our dimensions
+	}
+	if err != nil {
+		return 0, fmt.Errorf("check embedding dimensions: %w", err)
+	}
+	if stored == nil || *stored < 0 {
+		return 0, nil
+	}
+	return int(*stored), nil
 }
 
-// SimilaritySearch returns the top-k most similar documents for the query,
-// filtered to a specific org via langchaingo's vectorstores.WithFilters option.
+// checkVector validates a query or chunk embedding against the dimensions
+// of the collection it is stored in or searched, before it reaches the
+// vector store.
+func checkVector(vec []float32, collection string, dimensions int) error {
+	if dimensions > 0 && len(vec) != dimensions {
+		return fmt.Errorf("%w: got %d dimensions, collection %s uses %d",
+			ErrDimensionMismatch, len(vec), collection, dimensions)
+	}
+	return nil
+}
 
-// The filter maps directly to a WHERE clause in pgvector's metadata JSON column.
-func (vs *LangChainVectorStore) SimilaritySearch(
-	ctx context.Context,
-	query string,
-	orgID string,
-	topK int,
-) ([]schema.Document, error) {
-	return vs.store.SimilaritySearch(
-		ctx,
-		query,
-		topK,
-		vectorstores.WithFilters(map[string]any{
-			"org_id": orgID,
-		}),
+// createIndexes adds the indexes our own queries rely on to db's
+// embedding table; global is passed on to createANNIndex.
+func createIndexes(ctx context.Context, db database.DBTX, cfg VectorConfig, global bool) error {
+	if err := cfg.createANNIndex(ctx, db, global); err != nil {
+		return err
+	}
+	// Deletes and tenant filters match on metadata keys; index them so
+	// removing a document doesn't scan every tenant's vectors.
+	for _, key := range []string{"document_id", "org_id"} {
+		if _, err := db.Exec(ctx, fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS idx_embedding_%[2]s ON %[1]s ((cmetadata->>'%[2]s'))`,
+			EmbeddingTable, key)); err != nil {
+			return fmt.Errorf("create %s index: %w", key, err)
+		}
+	}
+	// Searches rank an org's own chunks by collection, which is all an
+	// unindexed one has to go on.
+	if _, err := db.Exec(ctx, fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS idx_embedding_collection ON %s (collection_id)`, EmbeddingTable)); err != nil {
+		return fmt.Errorf("create collection index: %w", err)
+	}
+	// Full-text index for the keyword half of hybrid search.
+	if _, err := db.Exec(ctx, fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS idx_embedding_document_fts ON %s USING gin (to_tsvector('%s', document))`,
+		EmbeddingTable, textSearchConfig)); err != nil {
+		return fmt.Errorf("create full-text index: %w", err)
+	}
+	return nil
+}
+
+// storeFor returns the langchaingo store for the org in ctx, creating the
+// vector tables in an isolated org's storage on first use.
+func (vs *LangChainVectorStore) storeFor(ctx context.Context) (lcpgvector.Store, error) {
+	org := tenancy.OrgFrom(ctx)
+	if org == "" || !vs.tenants.Isolated(org) {
+		return vs.store, nil
+	}
+
+	vs.isolated.mu.Lock()
+	defer vs.isolated.mu.Unlock()
+	if store, ok := vs.isolated.stores[org]; ok {
+		return store, nil
+	}
+	pool, err := vs.tenants.Pool(ctx)
+	if err != nil {
+		return lcpgvector.Store{}, err
+	}
+	if err := checkDimensions(ctx, pool, vs.cfg.Dimensions); err != nil {
+		return lcpgvector.Store{}, fmt.Errorf("vector store of org %s: %w", org, err)
+	}
+	store, err := lcpgvector.New(ctx, append([]lcpgvector.Option{lcpgvector.WithConn(pool)}, vs.isolated.opts...)...)
+	if err != nil {
+		return lcpgvector.Store{}, fmt.Errorf("init vector store of org %s: %w", org, err)
+	}
+	if err := createIndexes(ctx, pool, vs.cfg, true); err != nil {
+		return lcpgvector.Store{}, err
+	}
+	if err := createCollectionPointers(ctx, pool); err != nil {
+		return lcpgvector.Store{}, err
+	}
+	if err := installStats(ctx, pool); err != nil {
+		return lcpgvector.Store{}, err
+	}
+	vs.isolated.stores[org] = store
+	return store, nil
+}
+
+// chunkNamespace seeds ChunkID.
+var chunkNamespace = uuid.MustParse("3f1c6a8e-5b7d-4e2a-9c0f-8d4b2e6a1f37")
+
+// ChunkID derives a vector's ID from its document, its position in it and
+// its content, so storing the same chunk again (a retried batch, a resumed
+// ingest) overwrites the earlier row instead of duplicating it. Summary
+// nodes are positioned by level and the chunks they cover.
+func ChunkID(metadata map[string]any, content string) string {
+	position := fmt.Sprint(metadata["chunk_index"])
+	if level, _ := metadata["level"].(string); level != "" && level != "chunk" {
+		position = fmt.Sprintf("%s:%v-%v", level, metadata["chunk_start"], metadata["chunk_end"])
+	}
+	sum := sha256.Sum256([]byte(content))
+	key := fmt.Sprintf("%v\x00%s\x00%x", metadata["document_id"], position, sum)
+	return uuid.NewSHA1(chunkNamespace, []byte(key)).String()
+}
+
+// CollectionSummaryID is the document_id of a collection's summary node,
+// which has no document of its own; its "collection_id" metadata is the
+// collection.
+func CollectionSummaryID(collectionID string) string {
+	return "collection:" + collectionID
+}
+
+// AddEmbedded stores docs with vectors computed elsewhere, vecs[i]
+// belonging to docs[i], in their org's collection. Rows are keyed by
+// ChunkID and upserted, so inserting a chunk twice leaves one vector.
+// langchaingo's own insert generates random IDs, so the rows are written
+// directly. If any chunk's collection doesn't exist, none is stored and
+// ErrNoCollection is returned.
+func (vs *LangChainVectorStore) AddEmbedded(ctx context.Context, docs []schema.Document, vecs [][]float32) error {
+	if len(docs) != len(vecs) {
+		return fmt.Errorf("%d documents but %d vectors", len(docs), len(vecs))
+	}
+	if _, err := vs.storeFor(ctx); err != nil {
+		return err
+	}
+	if err := vs.claimCollections(ctx, docs); err != nil {
+		return err
+	}
+	sizes, err := vs.collectionSizes(ctx, docs)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(docs))
+	texts := make([]string, len(docs))
+	vectors := make([]string, len(docs))
+	metadata := make([]string, len(docs))
+	for i, doc := range docs {
+		size := sizes[fmt.Sprint(doc.Metadata["org_id"])]
+		if err := checkVector(vecs[i], cmp.Or(size.name, collectionName), cmp.Or(size.dimensions, vs.cfg.Dimensions)); err != nil {
+			return err
+		}
+		md, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return err
+		}
+		ids[i] = ChunkID(doc.Metadata, doc.PageContent)
+		texts[i] = doc.PageContent
+		vectors[i] = pgvector.NewVector(vecs[i]).String()
+		metadata[i] = string(md)
+	}
+	// Nothing is written unless every chunk has a collection to go to.
+	tag, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`WITH incoming AS (
+			 SELECT r.id, r.text, r.vec, r.md, c.uuid AS collection_id
+			 FROM unnest($1::uuid[], $2::text[], $3::text[], $4::text[]) AS r(id, text, vec, md)
+			 LEFT JOIN %s c ON c.name = %s
+		 )
+		 INSERT INTO %s (uuid, document, embedding, cmetadata, collection_id)
+		 SELECT id, text, vec::vector, md::json, collection_id FROM incoming
+		 WHERE NOT EXISTS (SELECT 1 FROM incoming WHERE collection_id IS NULL)
+		 ON CONFLICT (uuid) DO UPDATE SET
+			 embedding = EXCLUDED.embedding, cmetadata = EXCLUDED.cmetadata, collection_id = EXCLUDED.collection_id`,
+		CollectionTable, activeCollection("r.md::json->>'org_id'", "$5"), EmbeddingTable),
+		ids, texts, vectors, metadata, collectionName)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != int64(len(docs)) {
+		return fmt.Errorf("%w: %d chunks not stored", ErrNoCollection, len(docs))
+	}
+	return nil
+}
+
+// SearchMode selects how chunks are ranked.
+type SearchMode string
+
+const (
+	// SearchVector ranks by embedding cosine similarity only (the default).
+	SearchVector SearchMode = "vector"
+	// SearchHybrid fuses the vector ranking with a Postgres full-text
+	// ranking, so exact terms such as error codes and SKUs that embeddings
+	// blur still surface.
+	SearchHybrid SearchMode = "hybrid"
+)
+
+// ParseSearchMode validates a search_mode value; empty means SearchVector.
+func ParseSearchMode(s string) (SearchMode, error) {
+	switch m := SearchMode(s); m {
+	case "":
+		return SearchVector, nil
+	case SearchVector, SearchHybrid:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown search mode %q (want %q or %q)", s, SearchVector, SearchHybrid)
+	}
+}
+
+// Hybrid search tuning.
+const (
+	// textSearchConfig is the Postgres text search configuration. "simple"
+	// lowercases without stemming or stop words, so codes and identifiers
+	// match as written and no language is assumed.
+	textSearchConfig = "simple"
+	// rrfK is the reciprocal rank fusion constant: a chunk scores
+	// 1/(rrfK+rank) in each ranking it appears in. 60 is the usual choice.
+	rrfK = 60
+	// hybridCandidates is how many chunks each ranking contributes per
+	// requested result before fusion.
+	hybridCandidates = 4
+)
+
+// SearchParams scopes a similarity search. Results always come from OrgID's
+// own chunks; SharedDocumentIDs widens the scope to specific documents other
+// orgs have granted read access to.
+type SearchParams struct {
+	Query             string
+	OrgID             string
+	TopK              int
+	SharedDocumentIDs []string
+	// DocumentIDs, when non-empty, further restricts results to these documents.
+	DocumentIDs []string
+	// IncludeSummaries also searches summary-tree nodes (section/document
+	// summaries). By default only leaf chunks are returned.
+	IncludeSummaries bool
+	// Mode picks the ranking; empty means SearchVector.
+	Mode SearchMode
+	// MaxPerDocument caps how many results one document contributes, so a
+	// long document can't fill the whole TopK window. Zero means no cap.
+	MaxPerDocument int
+	// Filters restricts results to chunks whose metadata matches; see
+	// ValidateFilters. They narrow the org scope, never widen it.
+	Filters map[string]any
+	// MinScore drops chunks whose cosine similarity to the query is below
+	// it (in hybrid mode too, where Score is the fused rank). Zero keeps
+	// everything.
+	MinScore float32
+}
+
+// perDocumentOverfetch is how many candidates per requested result a
+// capped search ranks, so TopK can still be filled after the cap drops
+// chunks of over-represented documents.
+const perDocumentOverfetch = 4
+
+// searchScope is the WHERE clause shared by every ranking: chunks in
+// their owner's collection (DefaultCollection, $2, unless it points
+// elsewhere) that are the org's own ($3) or in granted documents ($4) of
+// the same model family, and pass scopeFilters. It binds $2-$4 and $6-$8.
+var searchScope = `c.name = ` + activeCollection("e.cmetadata->>'org_id'", "$2") + `
+		   AND (e.cmetadata->>'org_id' = $3
+		        OR (e.cmetadata->>'document_id' = ANY($4)
+		            AND split_part(c.name, '#', 1) = split_part(` + activeCollection("$3", "$2") + `, '#', 1)))
+		   AND ` + scopeFilters
+
+// scopeFilters narrows the scope by the optional document filter, the
+// summary-level filter and the metadata filters.
+var scopeFilters = `($6::text[] IS NULL OR e.cmetadata->>'document_id' = ANY($6))
+		   AND ($7 OR COALESCE(e.cmetadata->>'level', 'chunk') = 'chunk')
+		   AND ` + filterScope
+
+// SimilaritySearch returns the top-k most relevant chunks for the query
+// with their scores, leaving out those below p.MinScore. In SearchHybrid
+// mode the score is the fused RRF score rather than a cosine similarity,
+// which is in the MetaVectorScore metadata instead. If ctx ends while rows
+// are being read, the chunks read so far are returned with the error.
+//
+// langchaingo's WithFilters only supports AND-ed equality on metadata, which
+// can't express "own org OR granted documents", so the query is issued
+// directly against the embedding table with bound parameters.
+func (vs *LangChainVectorStore) SimilaritySearch(ctx context.Context, p SearchParams) ([]schema.Document, error) {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return nil, err
+	}
+	vec, err := vs.embedder.EmbedQuery(ctx, p.Query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	shared := p.SharedDocumentIDs
+	if shared == nil {
+		shared = []string{}
+	}
+	limit := p.TopK
+	if p.MaxPerDocument > 0 {
+		limit *= perDocumentOverfetch
+	}
+	// The org's collection is bound as a value rather than looked up in
+	// the query, so the planner can match it to its collection's index.
+	// The query vector must have the size recorded on it.
+	var (
+		collection *string
+		size       collectionSize
 	)
+	err = vs.db.QueryRow(ctx,
+		`SELECT c.uuid::text, c.name, `+recordedDimensions+` FROM `+CollectionTable+` c
+		 WHERE c.name = `+activeCollection("$1", "$2"),
+		p.OrgID, collectionName).Scan(&collection, &size.name, &size.dimensions)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("resolve collection: %w", err)
+	}
+	if err := checkVector(vec, cmp.Or(size.name, collectionName), cmp.Or(size.dimensions, vs.cfg.Dimensions)); err != nil {
+		return nil, err
+	}
+	args := []any{
+		pgvector.NewVector(vec), collectionName, p.OrgID, shared, limit, nilIfEmpty(p.DocumentIDs),
+		p.IncludeSummaries, filtersArg(p.Filters),
+	}
+
+	var query string
+	switch p.Mode {
+	case SearchHybrid:
+		query = vs.hybridSQL
+		args = append(args, p.Query, max(p.TopK*hybridCandidates, limit), collection)
+	default:
+		query = vs.vectorSQL
+		args = append(args, collection)
+	}
+
+	rows, err := vs.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []schema.Document
+	for rows.Next() {
+		var doc schema.Document
+		if p.Mode != SearchHybrid {
+			if err := rows.Scan(&doc.PageContent, &doc.Metadata, &doc.Score); err != nil {
+				return nil, err
+			}
+			if doc.Score >= p.MinScore {
+				docs = append(docs, doc)
+			}
+			continue
+		}
+
+		var (
+			vectorScore float32
+			highlight   *string
+			matched     []string
+		)
+		if err := rows.Scan(&doc.PageContent, &doc.Metadata, &doc.Score, &vectorScore, &highlight, &matched); err != nil {
+			return nil, err
+		}
+		if vectorScore < p.MinScore {
+			continue
+		}
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]any{}
+		}
+		doc.Metadata[MetaVectorScore] = vectorScore
+		doc.Metadata[MetaMatchedTerms] = matched
+		if highlight != nil {
+			doc.Metadata[MetaHighlight] = *highlight
+		}
+		docs = append(docs, doc)
+	}
+	if p.MaxPerDocument > 0 {
+		docs = capPerDocument(docs, p.MaxPerDocument, p.TopK)
+	}
+	return docs, rows.Err()
+}
+
+// KeywordSearch ranks chunks by full-text match alone, in the scope of p
+// (MinScore aside, which is a cosine similarity). It needs no embedding, so
+// it stands in when the vector search can't finish in time. Results carry
+// MetaMatchedTerms and MetaHighlight like hybrid ones.
+func (vs *LangChainVectorStore) KeywordSearch(ctx context.Context, p SearchParams) ([]schema.Document, error) {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return nil, err
+	}
+	shared := p.SharedDocumentIDs
+	if shared == nil {
+		shared = []string{}
+	}
+	limit := p.TopK
+	if p.MaxPerDocument > 0 {
+		limit *= perDocumentOverfetch
+	}
+	rows, err := vs.db.Query(ctx, keywordSearchSQL,
+		p.Query, collectionName, p.OrgID, shared, limit, nilIfEmpty(p.DocumentIDs),
+		p.IncludeSummaries, filtersArg(p.Filters))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []schema.Document
+	for rows.Next() {
+		var (
+			doc       schema.Document
+			highlight string
+			matched   []string
+		)
+		if err := rows.Scan(&doc.PageContent, &doc.Metadata, &doc.Score, &highlight, &matched); err != nil {
+			return nil, err
+		}
+		if doc.Metadata == nil {
+			doc.Metadata = map[string]any{}
+		}
+		doc.Metadata[MetaMatchedTerms] = matched
+		doc.Metadata[MetaHighlight] = highlight
+		docs = append(docs, doc)
+	}
+	if p.MaxPerDocument > 0 {
+		docs = capPerDocument(docs, p.MaxPerDocument, p.TopK)
+	}
+	return docs, rows.Err()
+}
+
+// capPerDocument keeps, in rank order, at most perDoc results of each
+// document and at most topK in total.
+func capPerDocument(docs []schema.Document, perDoc, topK int) []schema.Document {
+	seen := map[any]int{}
+	kept := docs[:0]
+	for _, doc := range docs {
+		id := doc.Metadata["document_id"]
+		if seen[id] >= perDoc {
+			continue
+		}
+		seen[id]++
+		kept = append(kept, doc)
+		if len(kept) == topK {
+			break
+		}
+	}
+	return kept
+}
+
+// vectorSearchSQL ranks chunks by the candidates query (see
+// VectorConfig.candidatesSQL). The score is always the exact cosine
+// similarity, whatever index found the chunk.
+func vectorSearchSQL(candidates string) string {
+	return fmt.Sprintf(
+		`SELECT e.document, e.cmetadata, 1 - (e.embedding <=> $1) AS score
+		 FROM (%s) d
+		 JOIN %s e ON e.uuid = d.uuid
+		 ORDER BY d.distance`, candidates, EmbeddingTable)
+}
+
+// hybridSearchSQL fuses a vector ranking and a full-text ranking of the
+// same scope with reciprocal rank fusion. Each side ranks its top $10
+// candidates: the vector side through the candidates query (see
+// VectorConfig.candidatesSQL), the text side by ts_rank_cd. A chunk found
+// by both sums its two contributions. The words of the question ($9) are
+// OR-ed rather than AND-ed, since a natural language question rarely has
+// every word in one chunk; ts_rank_cd then favours chunks matching more of
+// them, close together.
+//
+// Besides the fused score each result explains itself: its own cosine
+// similarity, the question terms it contains and a ts_headline snippet
+// with those terms marked (NULL when the text ranking didn't pick it).
+func hybridSearchSQL(candidates string) string {
+	return fmt.Sprintf(
+		`WITH q AS (
+		 SELECT replace(plainto_tsquery('%[4]s', $9)::text, '&', '|')::tsquery AS query
+	 ),
+	 dense AS (
+		 -- rank after the LIMIT so the HNSW index still drives the scan
+		 SELECT uuid, row_number() OVER (ORDER BY distance) AS rank
+		 FROM (%[7]s) v
+	 ),
+	 sparse AS (
+		 SELECT e.uuid, row_number() OVER (ORDER BY ts_rank_cd(to_tsvector('%[4]s', e.document), q.query) DESC) AS rank
+		 FROM %[1]s e
+		 JOIN %[2]s c ON c.uuid = e.collection_id
+		 CROSS JOIN q
+		 WHERE %[3]s
+		   AND to_tsvector('%[4]s', e.document) @@ q.query
+		 ORDER BY rank
+		 LIMIT $10
+	 ),
+	 fused AS (
+		 SELECT COALESCE(d.uuid, s.uuid) AS uuid, s.uuid IS NOT NULL AS text_match,
+		        (COALESCE(1.0 / (%[5]d + d.rank), 0) + COALESCE(1.0 / (%[5]d + s.rank), 0))::float8 AS score
+		 FROM dense d
+		 FULL JOIN sparse s ON s.uuid = d.uuid
+		 ORDER BY score DESC
+		 LIMIT $5
+	 )
+	 SELECT e.document, e.cmetadata, f.score,
+	        (1 - (e.embedding <=> $1))::float8 AS vector_score,
+	        CASE WHEN f.text_match THEN ts_headline('%[4]s', e.document, q.query, '%[6]s') END AS highlight,
+	        ARRAY(
+			 SELECT unnest(tsvector_to_array(to_tsvector('%[4]s', e.document)))
+			 INTERSECT
+			 SELECT unnest(tsvector_to_array(to_tsvector('%[4]s', $9)))
+	        ) AS matched_terms
+	 FROM fused f
+	 JOIN %[1]s e ON e.uuid = f.uuid
+	 CROSS JOIN q
+	 ORDER BY f.score DESC`, EmbeddingTable, CollectionTable, searchScope, textSearchConfig, rrfK, headlineOptions, candidates)
+}
+
+// keywordSearchSQL is the full-text side of hybridSearchSQL on its own,
+// scored by ts_rank_cd. The question is $1, where the other searches bind
+// the query vector, so the scope keeps its parameter positions.
+var keywordSearchSQL = fmt.Sprintf(
+	`WITH q AS (
+		 SELECT replace(plainto_tsquery('%[4]s', $1)::text, '&', '|')::tsquery AS query
+	 )
+	 SELECT e.document, e.cmetadata,
+	        ts_rank_cd(to_tsvector('%[4]s', e.document), q.query)::float8 AS score,
+	        ts_headline('%[4]s', e.document, q.query, '%[5]s') AS highlight,
+	        ARRAY(
+			 SELECT unnest(tsvector_to_array(to_tsvector('%[4]s', e.document)))
+			 INTERSECT
+			 SELECT unnest(tsvector_to_array(to_tsvector('%[4]s', $1)))
+	        ) AS matched_terms
+	 FROM %[1]s e
+	 JOIN %[2]s c ON c.uuid = e.collection_id
+	 CROSS JOIN q
+	 WHERE %[3]s
+	   AND to_tsvector('%[4]s', e.document) @@ q.query
+	 ORDER BY score DESC
+	 LIMIT $5`, EmbeddingTable, CollectionTable, searchScope, textSearchConfig, headlineOptions)
+
+// headlineOptions configures the hybrid-search snippets: up to two short
+// fragments with matched terms wrapped in ** (markdown bold).
+const headlineOptions = "MaxFragments=2, MaxWords=20, MinWords=8, StartSel=**, StopSel=**"
+
+// Metadata keys SimilaritySearch adds to hybrid results to explain the
+// match. The leading underscore keeps them apart from document metadata.
+const (
+	MetaVectorScore  = "_vector_score"
+	MetaHighlight    = "_highlight"
+	MetaMatchedTerms = "_matched_terms"
+	// MetaRerankScore is the reranker's relevance score, when one is
+	// configured.
+	MetaRerankScore = "_rerank_score"
+)
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
 }
 
-// DeleteByDocument removes all chunks for a given document_id from the store.
+// WithTx returns a copy of the store whose direct SQL (searches, inserts
+// and deletes) runs in tx, so vector writes commit or roll back together
+// with the caller's row changes.
+func (vs *LangChainVectorStore) WithTx(tx pgx.Tx) VectorStore {
+	cp := *vs
+	cp.db = tx
+	return &cp
+}
 
+// DeleteByDocument removes all chunks (and summary nodes) of a document.
 func (vs *LangChainVectorStore) DeleteByDocument(ctx context.Context, documentID string) error {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return err
+	}
+	_, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s WHERE cmetadata->>'document_id' = $1`, EmbeddingTable), documentID)
+	return err
+}
+
+// DeleteChunksFrom removes a document's chunks numbered from firstChunk
+// on, left behind by an interrupted ingest. From 0 it removes everything,
+// summary nodes included.
+func (vs *LangChainVectorStore) DeleteChunksFrom(ctx context.Context, documentID string, firstChunk int) error {
+	if firstChunk == 0 {
+		return vs.DeleteByDocument(ctx, documentID)
+	}
+	if _, err := vs.storeFor(ctx); err != nil {
+		return err
+	}
+	_, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s WHERE cmetadata->>'document_id' = $1 AND (cmetadata->>'chunk_index')::int >= $2`,
+		EmbeddingTable), documentID, firstChunk)
+	return err
+}
 
-	// langchaingo's pgvector store doesn't expose a direct delete-by-filter yet,
-	// so we use the underlying connection URL via a raw pgx query.
-	// The store manages its own pool internally; we delete from the embedding table directly.
+// StoredChunks returns the text of a document's chunks numbered from
+// firstChunk on, by chunk index, so an interrupted ingest can pick up
+// where it stopped. Summary nodes are not included.
+func (vs *LangChainVectorStore) StoredChunks(ctx context.Context, documentID string, firstChunk int) (map[int]string, error) {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := vs.db.Query(ctx, fmt.Sprintf(
+		`SELECT (cmetadata->>'chunk_index')::int, document FROM %s
+		 WHERE cmetadata->>'document_id' = $1
+		   AND COALESCE(cmetadata->>'level', 'chunk') = 'chunk'
+		   AND (cmetadata->>'chunk_index')::int >= $2`,
+		EmbeddingTable), documentID, firstChunk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stored := map[int]string{}
+	for rows.Next() {
+		var (
+			index int
+			text  string
+		)
+		if err := rows.Scan(&index, &text); err != nil {
+			return nil, err
+		}
+		stored[index] = text
+	}
+	return stored, rows.Err()
+}
+
+// PruneChunks removes a document's chunks numbered from firstChunk on
+// except those in keep. Summary nodes are left alone.
+func (vs *LangChainVectorStore) PruneChunks(ctx context.Context, documentID string, firstChunk int, keep []int) error {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return err
+	}
+	if keep == nil {
+		keep = []int{} // NULL would never match below
+	}
+	_, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s
+		 WHERE cmetadata->>'document_id' = $1
+		   AND COALESCE(cmetadata->>'level', 'chunk') = 'chunk'
+		   AND COALESCE((cmetadata->>'chunk_index')::int, 0) >= $2
+		   AND NOT COALESCE((cmetadata->>'chunk_index')::int, -1) = ANY($3)`,
+		EmbeddingTable), documentID, firstChunk, keep)
+	return err
+}
+
+// DeleteSummaries removes a document's summary nodes, before its tree is
+// rebuilt.
+func (vs *LangChainVectorStore) DeleteSummaries(ctx context.Context, documentID string) error {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return err
+	}
+	_, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s WHERE cmetadata->>'document_id' = $1 AND COALESCE(cmetadata->>'level', 'chunk') <> 'chunk'`,
+		EmbeddingTable), documentID)
+	return err
+}
+
+// DeleteByDocuments removes the chunks of many documents in one statement
+// and returns the number of vectors deleted.
+func (vs *LangChainVectorStore) DeleteByDocuments(ctx context.Context, documentIDs []string) (int64, error) {
+	if len(documentIDs) == 0 {
+		return 0, nil
+	}
+	if _, err := vs.storeFor(ctx); err != nil {
+		return 0, err
+	}
+	tag, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s WHERE cmetadata->>'document_id' = ANY($1)`, EmbeddingTable), documentIDs)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
 
-	return vs.store.RemoveCollection(ctx, nil) // no-op placeholder — see note below
+// PurgeOrg removes every vector owned by an org, for org deletion. Vectors
+// are matched on their org_id metadata, so chunks of documents whose rows
+// are already gone are caught too.
+func (vs *LangChainVectorStore) PurgeOrg(ctx context.Context, orgID string) (int64, error) {
+	if _, err := vs.storeFor(ctx); err != nil {
+		return 0, err
+	}
+	tag, err := vs.db.Exec(ctx, fmt.Sprintf(
+		`DELETE FROM %s WHERE cmetadata->>'org_id' = $1`, EmbeddingTable), orgID)
+	if err != nil {
+		return 0, err
+	}
+	if err := ForgetCollections(ctx, vs.db, orgID); err != nil {
+		return 0, err
+	}
+	vs.owned.Delete(orgID)
+	return tag.RowsAffected(), nil
+}
 
-	// NOTE: In production implement this by holding a *pgxpool.Pool reference and running:
-	//   DELETE FROM langchain_pg_embedding WHERE cmetadata->>'document_id' = $1
+// ForgetOrg drops the cached store of an org whose isolated storage is
+// being released.
+func (vs *LangChainVectorStore) ForgetOrg(orgID string) {
+	vs.isolated.mu.Lock()
+	defer vs.isolated.mu.Unlock()
+	delete(vs.isolated.stores, orgID)
 }
 
 // Close releases the pgvector store connection.
@@ -106,15 +882,29 @@ func (vs *LangChainVectorStore) Close() {
 //  bridge internal embedding.Embedder
 
 type langchainEmbedderAdapter struct {
-	inner embedding.Embedder
+	inner      embedding.Embedder
+	dimensions int
 }
 
 func (a *langchainEmbedderAdapter) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
-	return a.inner.EmbedDocuments(ctx, texts)
+	vecs, err := a.inner.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	for _, vec := range vecs {
+		if err := checkVector(vec, collectionName, a.dimensions); err != nil {
+			return nil, err
+		}
+	}
+	return vecs, nil
 }
 
 func (a *langchainEmbedderAdapter) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
-	return a.inner.EmbedQuery(ctx, text)
+	vec, err := a.inner.EmbedQuery(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return vec, checkVector(vec, collectionName, a.dimensions)
 }
 
 // RAG Service
@@ -124,57 +914,686 @@ func (a *langchainEmbedderAdapter) EmbedQuery(ctx context.Context, text string)
 //  2. Build a context-augmented prompt from the retrieved chunks
 //  3. Stream the LLM response token-by-token over a Go channel
 
-// LLMClient is the interface the RAG service uses to stream completions.
-type LLMClient interface {
-	StreamCompletion(ctx context.Context, systemPrompt, userMessage string, out chan<- string) error
+// LLMClient is the interface the RAG service uses to stream completions;
+// any provider from the llm registry satisfies it.
+type LLMClient = llm.Client
+
+// Complete runs a non-streaming completion by draining StreamCompletion.
+// Used for short auxiliary calls (classification, rewriting) where the
+// caller needs the whole answer before continuing.
+func Complete(ctx context.Context, c LLMClient, systemPrompt, userMessage string, opts llm.CompletionOptions) (string, error) {
+	out := make(chan string, 64)
+	errc := make(chan error, 1)
+	go func() { errc <- c.StreamCompletion(ctx, systemPrompt, userMessage, opts, out) }()
+
+	var sb strings.Builder
+	for token := range out {
+		sb.WriteString(token)
+	}
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// GrantResolver lists documents other orgs have shared with an org, so
+// retrieval can include them alongside the org's own chunks.
+type GrantResolver interface {
+	SharedDocumentIDs(ctx context.Context, orgID string) ([]string, error)
+}
+
+// CollectionResolver lists the documents in some of an org's collections,
+// so a query can be scoped to them.
+type CollectionResolver interface {
+	CollectionDocumentIDs(ctx context.Context, orgID string, ids []string) ([]string, error)
+}
+
+// Reranker scores passages by their relevance to a query, in passage
+// order; higher is more relevant.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, passages []string) ([]float64, error)
+}
+
+// QueryCounter counts the queries answered for an org, for billing.
+type QueryCounter interface {
+	CountQuery(ctx context.Context, orgID string)
+}
+
+// IntentClassifier tags a question with its intent, "" when it can't tell.
+type IntentClassifier interface {
+	Classify(ctx context.Context, question string) string
+}
+
+// QueryLog records the questions asked of an org with their intent, under
+// the query's ID when it has one.
+type QueryLog interface {
+	Record(ctx context.Context, id, orgID, question, intent string)
+}
+
+// GenerationPolicy settles the generation parameters of an org's query
+// from what it asked for, applying the org's defaults and caps.
+type GenerationPolicy interface {
+	Generation(ctx context.Context, orgID string, requested llm.Generation) (llm.Generation, error)
+}
+
+// ModelPolicy settles the LLM model of an org's query from the one it
+// asked for, "" when it asked for none; "" again means the default.
+type ModelPolicy interface {
+	LLMModel(ctx context.Context, orgID, requested string) (string, error)
+}
+
+// Observer records how long each query took and whether it failed, for
+// SLO tracking.
+type Observer interface {
+	Observe(op string, took time.Duration, err error)
 }
 
 type RAGService struct {
-	vectorStore *LangChainVectorStore
+	vectorStore VectorStore
 	llm         LLMClient
+	grants      GrantResolver
+	collections CollectionResolver
+	queries     QueryCounter
+	intents     IntentClassifier // nil leaves questions unclassified
+	queryLog    QueryLog
+	observer    Observer
+	reranker    Reranker
+	// minScore is the similarity below which chunks are dropped unless a
+	// query sets its own.
+	minScore float32
+	// rerankCandidates is how many chunks are fetched for the reranker to
+	// pick TopK from.
+	rerankCandidates int
+	// recencyHalfLife and recencyWeight boost recently published chunks;
+	// zero recencyHalfLife doesn't. See BoostRecent.
+	recencyHalfLife time.Duration
+	recencyWeight   float64
+	// budget bounds retrieval; zero means no limit. See LimitRetrieval.
+	budget time.Duration
+	// window and countTokens budget prompts; nil window sends them
+	// unchecked. See FitContextWith.
+	window      func(model string) int
+	countTokens func(string) int
+	// prompts holds orgs' prompt templates; nil uses the built-in prompt
+	// for everyone. See PromptsFrom.
+	prompts PromptSource
+	// generation applies orgs' generation defaults and caps; nil sends
+	// what queries ask for. See GenerateWith.
+	generation GenerationPolicy
+	// models picks orgs' default LLM models; nil uses the deployment's
+	// default. See ChooseModelsWith.
+	models ModelPolicy
+	// primary names llm, and fallbacks are tried in order when it fails,
+	// each given up on after stall without a token. See FallBackTo.
+	primary   Fallback
+	fallbacks []Fallback
+	stall     time.Duration
+	// cache answers repeated questions; nil generates every answer. See
+	// CacheAnswersIn.
+	cache AnswerCache
+	// smallTalk answers small talk directly, with smallTalkReply when set.
+	// See AnswerSmallTalk.
+	smallTalk      bool
+	smallTalkReply string
+	// condense rewrites follow-ups into standalone questions for
+	// retrieval, through condenseModel. See CondenseFollowUps.
+	condense      bool
+	condenseModel string
+}
+
+func NewRAGService(vs VectorStore, llm LLMClient, grants GrantResolver, collections CollectionResolver) *RAGService {
+	return &RAGService{vectorStore: vs, llm: llm, grants: grants, collections: collections}
+}
+
+// RerankWith makes retrieval fetch candidates chunks (at least TopK) and
+// keep the TopK that r ranks highest.
+func (s *RAGService) RerankWith(r Reranker, candidates int) {
+	s.reranker, s.rerankCandidates = r, candidates
+}
+
+// CountQueriesWith makes the service count every query it answers.
+func (s *RAGService) CountQueriesWith(c QueryCounter) {
+	s.queries = c
+}
+
+// ClassifyWith tags each question with its intent from c, which picks the
+// org's prompt template for that intent.
+func (s *RAGService) ClassifyWith(c IntentClassifier) {
+	s.intents = c
+}
+
+// LogQueriesTo records every question answered, with its intent, in l.
+func (s *RAGService) LogQueriesTo(l QueryLog) {
+	s.queryLog = l
+}
+
+// DropBelow sets the cosine similarity under which retrieved chunks are
+// treated as irrelevant, for queries that don't set their own MinScore.
+func (s *RAGService) DropBelow(score float32) {
+	s.minScore = score
+}
+
+// ObserveWith reports the outcome of every query to o.
+func (s *RAGService) ObserveWith(o Observer) {
+	s.observer = o
+}
+
+// GenerateWith settles each query's generation parameters with p.
+func (s *RAGService) GenerateWith(p GenerationPolicy) {
+	s.generation = p
+}
+
+// ChooseModelsWith settles the LLM model of each query with p.
+func (s *RAGService) ChooseModelsWith(p ModelPolicy) {
+	s.models = p
 }
 
-func NewRAGService(vs *LangChainVectorStore, llm LLMClient) *RAGService {
-	return &RAGService{vectorStore: vs, llm: llm}
+// LimitRetrieval gives retrieval a latency budget. Embedding the question,
+// the search and reranking must fit in it; when they don't, retrieval
+// settles for less rather than failing the query (see Degradation).
+func (s *RAGService) LimitRetrieval(budget time.Duration) {
+	s.budget = budget
 }
 
+// Degradation says how retrieval fell short of its latency budget; empty
+// when it didn't.
+type Degradation string
+
+const (
+	// DegradedPartial: the search ran out of time while returning chunks,
+	// and those returned so far were used.
+	DegradedPartial Degradation = "partial_results"
+	// DegradedKeywordOnly: the vector search ran out of time before
+	// returning anything, and a full-text search (allowed its own budget)
+	// was used instead.
+	DegradedKeywordOnly Degradation = "keyword_only"
+	// DegradedNotReranked: no time was left to rerank, so the search
+	// order was kept.
+	DegradedNotReranked Degradation = "not_reranked"
+)
+
 type QueryRequest struct {
+	// QueryID identifies the query in the query log (see LogQueriesTo);
+	// empty lets the log pick one.
+	QueryID  string
 	OrgID    string
 	Question string
 	TopK     int
+	// SkipGrants restricts retrieval to the org's own documents, ignoring
+	// anything shared with it. Set for anonymous (public) queries.
+	SkipGrants bool
+
+	// Assistant overrides. SystemPrompt replaces the default persona (the
+	// grounding rules are always appended), Model the default LLM model,
+	// and DocumentIDs narrows retrieval to a fixed document scope.
+	SystemPrompt string
+	Model        string
+	DocumentIDs  []string
+
+	// CollectionIDs narrows retrieval to the documents in these of the
+	// org's collections (within DocumentIDs, if both are set).
+	CollectionIDs []string
+
+	// IncludeSummaries lets retrieval traverse the summary tree, matching
+	// section/document summaries as well as raw chunks. Best for broad
+	// "summarize ..." questions.
+	IncludeSummaries bool
+
+	// SearchMode picks vector-only or hybrid (vector + full-text) ranking;
+	// empty means vector.
+	SearchMode SearchMode
+
+	// MaxChunksPerDocument caps the passages taken from any one document;
+	// zero means no cap.
+	MaxChunksPerDocument int
+
+	// Filters narrows retrieval to chunks whose metadata matches, e.g.
+	// {"doc_name": "handbook.pdf"} or {"tags": ["hr"]}.
+	Filters map[string]any
+
+	// MinScore is the cosine similarity a chunk needs to be used; zero
+	// takes the service's threshold (see DropBelow).
+	MinScore float32
+
+	// History holds the prior turns of a conversation, oldest first. They
+	// go into the prompt so follow-ups can refer back ("and for Linux?").
+	History []Turn
+
+	// SearchQuery is what retrieval searches for instead of Question, set
+	// while the query runs to the standalone form of a follow-up (see
+	// CondenseFollowUps).
+	SearchQuery string
+
+	// Generation tunes how the answer is sampled, within the org's caps
+	// (see GenerateWith).
+	Generation llm.Generation
+
+	// Intent is the question's intent, set while the query runs when the
+	// service classifies questions (see ClassifyWith).
+	Intent string
+
+	// OnProviderSwitch, if set, is told when the answer falls back to
+	// another provider (see FallBackTo).
+	OnProviderSwitch func(ProviderSwitch)
+
+	// BypassCache generates a fresh answer rather than a cached one, and
+	// leaves the cache as it is (see CacheAnswersIn).
+	BypassCache bool
+
+	// OnCacheHit, if set, is told before the answer is sent when it comes
+	// from the cache.
+	OnCacheHit func()
+
+	// OnSmallTalk, if set, is told before the sources when the question
+	// is answered as small talk (see AnswerSmallTalk).
+	OnSmallTalk func()
+
+	// OutputFormat is the markup the answer is written in; empty means
+	// markdown.
+	OutputFormat OutputFormat
 }
 
-// Query retrieves relevant context via langchaingo SimilaritySearch and
-// streams an LLM response over the out channel (closed when done).
-func (s *RAGService) Query(ctx context.Context, req QueryRequest, out chan<- string) error {
+// Turn is one message of a conversation.
+type Turn struct {
+	Role    string // "user" or "assistant"
+	Content string
+}
+
+// DefaultPersona opens the system prompt when no assistant overrides it.
+const DefaultPersona = "You are a helpful knowledge-base assistant."
+
+// groundingRules keep answers tied to the retrieved context whatever
+// persona the tenant configured.
+const groundingRules = `Answer the user's question using ONLY the provided context chunks.
+If the answer is not in the context, say "I don't have enough information to answer that."
+Be concise and cite chunk numbers when referencing specific information.`
+
+// historyRules explain the replayed turns: they disambiguate the question,
+// but facts must still come from the context.
+const historyRules = `The conversation so far is included to resolve what the question refers to.
+Do not treat earlier answers as a source of facts; rely on the context chunks.`
+
+// NoContextAnswer is the whole answer to a question nothing relevant was
+// retrieved for. The model isn't asked at all then: with an empty context
+// it tends to answer from its own knowledge anyway.
+const NoContextAnswer = "I couldn't find anything relevant to that in the knowledge base."
+
+// maxTurnChars caps each replayed turn so a long earlier answer can't crowd
+// the retrieved context out of the prompt.
+const maxTurnChars = 2000
+
+// Retrieve runs only the retrieval half of a query: the org's chunks plus
+// any shared with it, ranked by similarity. Used directly by tool-style
+// callers (MCP) that want raw passages rather than a generated answer. A
+// non-empty Degradation means the latency budget cut retrieval short.
+func (s *RAGService) Retrieve(ctx context.Context, req QueryRequest) ([]schema.Document, Degradation, error) {
 	if req.TopK <= 0 {
 		req.TopK = 5
 	}
 
-	// S1: Retrieve via langchaingo pgvector SimilaritySearch
-	results, err := s.vectorStore.SimilaritySearch(ctx, req.Question, req.OrgID, req.TopK)
+	// Grants are checked on every query so a revoked share stops
+	// contributing context immediately.
+	var shared []string
+	if !req.SkipGrants {
+		var err error
+		shared, err = s.grants.SharedDocumentIDs(ctx, req.OrgID)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolve shared documents: %w", err)
+		}
+	}
+
+	docIDs := req.DocumentIDs
+	if len(req.CollectionIDs) > 0 {
+		inCollections, err := s.collections.CollectionDocumentIDs(ctx, req.OrgID, req.CollectionIDs)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolve collections: %w", err)
+		}
+		if len(docIDs) > 0 {
+			inCollections = slices.DeleteFunc(inCollections, func(id string) bool {
+				return !slices.Contains(docIDs, id)
+			})
+		}
+		// An empty DocumentIDs wouldn't restrict the search at all.
+		if len(inCollections) == 0 {
+			return nil, "", nil
+		}
+		docIDs = inCollections
+		// The collections' own summary nodes cover documents beyond
+		// DocumentIDs, so they only join an unrestricted scope.
+		if req.IncludeSummaries && len(req.DocumentIDs) == 0 {
+			for _, id := range req.CollectionIDs {
+				docIDs = append(docIDs, CollectionSummaryID(id))
+			}
+		}
+	}
+
+	// rctx bounds retrieval by the budget; ctx stays for falling back.
+	rctx := ctx
+	if s.budget > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(ctx, s.budget)
+		defer cancel()
+	}
+
+	fetch := req.TopK
+	if s.reranker != nil {
+		fetch = max(fetch, s.rerankCandidates)
+	}
+	if s.recencyHalfLife > 0 {
+		fetch = max(fetch, recencyOverfetch*req.TopK)
+	}
+	query := cmp.Or(req.SearchQuery, req.Question)
+	results, degraded, err := s.search(ctx, rctx, SearchParams{
+		Query:             query,
+		OrgID:             req.OrgID,
+		TopK:              fetch,
+		SharedDocumentIDs: shared,
+		DocumentIDs:       docIDs,
+		IncludeSummaries:  req.IncludeSummaries,
+		Mode:              req.SearchMode,
+		MaxPerDocument:    req.MaxChunksPerDocument,
+		Filters:           req.Filters,
+		MinScore:          cmp.Or(req.MinScore, s.minScore),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("similarity search: %w", err)
+	}
+	if s.reranker != nil {
+		var reranked bool
+		results, reranked = s.rerank(rctx, query, results)
+		if !reranked && degraded == "" && outOfTime(ctx, rctx) {
+			degraded = DegradedNotReranked
+		}
+	}
+	if s.recencyHalfLife > 0 {
+		results = s.boostRecent(results, time.Now())
+	}
+	if len(results) > req.TopK {
+		results = results[:req.TopK]
+	}
+	return results, degraded, nil
+}
+
+// search runs the similarity search within rctx, retrieval's budget. When
+// the budget runs out it makes do with the chunks read so far or, failing
+// that, with a keyword search given a budget of its own.
+func (s *RAGService) search(ctx, rctx context.Context, p SearchParams) ([]schema.Document, Degradation, error) {
+	docs, err := s.vectorStore.SimilaritySearch(rctx, p)
+	if err == nil || !outOfTime(ctx, rctx) {
+		return docs, "", err
+	}
+	if len(docs) > 0 {
+		slog.Warn("retrieval over budget, using partial results", "org_id", p.OrgID, "chunks", len(docs))
+		return docs, DegradedPartial, nil
+	}
+
+	slog.Warn("retrieval over budget, falling back to keyword search", "org_id", p.OrgID, "budget", s.budget)
+	kctx, cancel := context.WithTimeout(ctx, s.budget)
+	defer cancel()
+	searchErr := err
+	docs, err = s.vectorStore.KeywordSearch(kctx, p)
+	if errors.Is(err, ErrUnsupported) {
+		return nil, "", searchErr
+	}
+	if err != nil && outOfTime(ctx, kctx) {
+		return docs, DegradedKeywordOnly, nil
+	}
+	return docs, DegradedKeywordOnly, err
+}
+
+// outOfTime reports whether bounded, derived from ctx, ended because its
+// budget ran out rather than because ctx did.
+func outOfTime(ctx, bounded context.Context) bool {
+	return bounded.Err() != nil && ctx.Err() == nil
+}
+
+// rerank orders docs by the reranker's scores, recording each in the
+// metadata. Reranking only refines the order, so if the reranker fails the
+// search ranking stands; reranked is false then.
+func (s *RAGService) rerank(ctx context.Context, question string, docs []schema.Document) (ranked []schema.Document, reranked bool) {
+	if len(docs) < 2 {
+		return docs, true
+	}
+	passages := make([]string, len(docs))
+	for i, doc := range docs {
+		passages[i] = doc.PageContent
+	}
+	scores, err := s.reranker.Rerank(ctx, question, passages)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("rerank failed, keeping search order", "error", err)
+		}
+		return docs, false
+	}
+	for i := range docs {
+		if docs[i].Metadata == nil {
+			docs[i].Metadata = map[string]any{}
+		}
+		if !math.IsInf(scores[i], 0) {
+			docs[i].Metadata[MetaRerankScore] = float32(scores[i])
+		}
+	}
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+	ranked = make([]schema.Document, len(docs))
+	for i, j := range order {
+		ranked[i] = docs[j]
+	}
+	return ranked, true
+}
+
+// Source describes a retrieved passage an answer was grounded on, so
+// clients can show where the answer came from.
+type Source struct {
+	DocumentID string  `json:"document_id"`
+	DocName    string  `json:"doc_name"`
+	Score      float32 `json:"score"`
+	Excerpt    string  `json:"excerpt"`
+	// CollectionID is set instead of DocumentID for a collection's summary
+	// node, DocName then being the collection's name.
+	CollectionID string `json:"collection_id,omitempty"`
+
+	// Hybrid search only: the passage's cosine similarity (Score is then
+	// the fused rank score), the question terms it contains, and a snippet
+	// with them marked in **bold**.
+	VectorScore  *float32 `json:"vector_score,omitempty"`
+	MatchedTerms []string `json:"matched_terms,omitempty"`
+	Highlight    string   `json:"highlight,omitempty"`
+
+	// RerankScore is the reranker's relevance score, with reranking on.
+	RerankScore *float32 `json:"rerank_score,omitempty"`
+}
+
+// maxExcerptChars bounds the passage text returned with each source.
+const maxExcerptChars = 300
+
+// Sources converts retrieval results into citation metadata.
+func Sources(docs []schema.Document) []Source {
+	sources := make([]Source, len(docs))
+	for i, doc := range docs {
+		docID, _ := doc.Metadata["document_id"].(string)
+		docName, _ := doc.Metadata["doc_name"].(string)
+		excerpt := strings.Join(strings.Fields(doc.PageContent), " ")
+		if r := []rune(excerpt); len(r) > maxExcerptChars {
+			excerpt = string(r[:maxExcerptChars]) + "…"
+		}
+		sources[i] = Source{DocumentID: docID, DocName: docName, Score: doc.Score, Excerpt: excerpt}
+		if id, ok := doc.Metadata["collection_id"].(string); ok && docID == CollectionSummaryID(id) {
+			sources[i].DocumentID, sources[i].CollectionID = "", id
+		}
+		if v, ok := doc.Metadata[MetaVectorScore].(float32); ok {
+			sources[i].VectorScore = &v
+		}
+		sources[i].MatchedTerms, _ = doc.Metadata[MetaMatchedTerms].([]string)
+		sources[i].Highlight, _ = doc.Metadata[MetaHighlight].(string)
+		if v, ok := doc.Metadata[MetaRerankScore].(float32); ok {
+			sources[i].RerankScore = &v
+		}
+	}
+	return sources
+}
+
+// Query retrieves relevant context via pgvector similarity search and
+// streams an LLM response over the out channel (closed when done).
+func (s *RAGService) Query(ctx context.Context, req QueryRequest, out chan<- string) error {
+	return s.QueryWithSources(ctx, req, nil, out)
+}
+
+// QueryWithSources is Query with a callback that receives the retrieved
+// sources once retrieval finishes, before the first token is sent, and
+// whether the latency budget degraded them. A nil onSources behaves like
+// Query. The sources are empty when the question is answered as small talk
+// (OnSmallTalk is told first) and otherwise exactly when nothing relevant
+// was found, the answer then being NoContextAnswer.
+func (s *RAGService) QueryWithSources(ctx context.Context, req QueryRequest, onSources func([]Source, Degradation), out chan<- string) (err error) {
+	if s.observer != nil {
+		defer func(start time.Time) { s.observer.Observe(slo.OpQuery, time.Since(start), err) }(time.Now())
+	}
+	// Everything sent from here on is converted to the output format;
+	// text held back for conversion goes out before a provider switch is
+	// reported.
+	if in, flush := formatAnswers(ctx, req.OutputFormat, out); in != nil {
+		out = in
+		onSwitch := req.OnProviderSwitch
+		req.OnProviderSwitch = func(sw ProviderSwitch) {
+			flush()
+			if onSwitch != nil {
+				onSwitch(sw)
+			}
+		}
+	}
+
+	if s.generation != nil {
+		if req.Generation, err = s.generation.Generation(ctx, req.OrgID, req.Generation); err != nil {
+			close(out) // StreamCompletion never runs; don't leave the reader hanging
+			return fmt.Errorf("load generation settings: %w", err)
+		}
+	}
+	if s.models != nil {
+		if req.Model, err = s.models.LLMModel(ctx, req.OrgID, req.Model); err != nil {
+			close(out)
+			return fmt.Errorf("load model settings: %w", err)
+		}
+	}
+
+	// A follow-up is made to stand on its own, then classified while
+	// retrieval runs; its intent picks the prompt template. Obvious small
+	// talk needs none of it.
+	intent := make(chan string, 1)
+	smallTalk := s.smallTalk && IsSmallTalk(req.Question)
+	if !smallTalk {
+		req.SearchQuery = s.standalone(ctx, req)
+	}
+	switch {
+	case smallTalk:
+		intent <- IntentChitChat
+	case s.intents != nil:
+		go func() { intent <- s.intents.Classify(ctx, req.SearchQuery) }()
+	default:
+		intent <- ""
+	}
+
+	// S1: Retrieve via pgvector similarity search, keeping what fits the
+	// model's context window
+	var results []schema.Document
+	var degraded Degradation
+	if !smallTalk {
+		if results, degraded, err = s.Retrieve(ctx, req); err != nil {
+			close(out)
+			return err
+		}
+	}
+	req.Intent = <-intent
+	if s.smallTalk && req.Intent == IntentChitChat && len(results) == 0 {
+		return s.answerSmallTalk(ctx, req, onSources, out)
+	}
+	prompt, err := s.promptFor(ctx, req)
+	if err != nil {
+		close(out)
+		return fmt.Errorf("load prompt template: %w", err)
+	}
+	history, results, err := s.fitPrompt(req, prompt.system(false)+prompt.user("", "", req.Question), results)
 	if err != nil {
-		return fmt.Errorf("similarity search: %w", err)
+		close(out)
+		return err
+	}
+	if onSources != nil {
+		onSources(Sources(results), degraded)
+	}
+	s.recordQuery(ctx, req)
+	if len(results) == 0 {
+		defer close(out)
+		select {
+		case out <- NoContextAnswer:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	// S2: Build context block from retrieved schema.Documents
 	var ctxBuilder strings.Builder
 	for i, doc := range results {
-		docID, _ := doc.Metadata["document_id"].(string)
-		docName, _ := doc.Metadata["doc_name"].(string)
-		fmt.Fprintf(&ctxBuilder,
-			"--- Chunk %d (doc: %s / %s) ---\n%s\n\n",
-			i+1, docID, docName, doc.PageContent,
-		)
+		ctxBuilder.WriteString(chunkHeader(i, doc))
+		ctxBuilder.WriteString(doc.PageContent)
+		ctxBuilder.WriteString("\n\n")
 	}
 
-	system := `You are a helpful knowledge-base assistant.
-Answer the user's question using ONLY the provided context chunks.
-If the answer is not in the context, say "I don't have enough information to answer that."
-Be concise and cite chunk numbers when referencing specific information.`
+	var conversation string
+	if len(history) > 0 {
+		conversation = "Conversation so far:\n" + renderHistory(history) + "\n"
+	}
+	system := prompt.system(len(history) > 0)
+	user := prompt.user(conversation, ctxBuilder.String(), req.Question)
+
+	// S3: Stream LLM response, falling back to other providers on failure,
+	// unless the answer is cached
+	if s.cache == nil || req.BypassCache {
+		return s.generate(ctx, req, system, user, out)
+	}
+	fp := fingerprint(req, system, prompt.user(conversation, ctxBuilder.String(), ""))
+	if cached, err := s.answerCached(ctx, req, fp, out); cached {
+		return err
+	}
+	return s.generateAndCache(ctx, req, system, user, fp, out)
+}
+
+// recordQuery counts an answered query and logs its question.
+func (s *RAGService) recordQuery(ctx context.Context, req QueryRequest) {
+	if s.queries != nil {
+		s.queries.CountQuery(ctx, req.OrgID)
+	}
+	if s.queryLog != nil {
+		s.queryLog.Record(ctx, req.QueryID, req.OrgID, req.Question, req.Intent)
+	}
+}
 
-	user := fmt.Sprintf("Context:\n%s\n\nQuestion: %s", ctxBuilder.String(), req.Question)
+// chunkHeader introduces the i-th (0-based) context chunk in the prompt.
+func chunkHeader(i int, doc schema.Document) string {
+	docID, _ := doc.Metadata["document_id"].(string)
+	docName, _ := doc.Metadata["doc_name"].(string)
+	label := "Chunk"
+	if level, _ := doc.Metadata["level"].(string); level != "" && level != "chunk" {
+		label = "Summary (" + level + ")" // summary-tree node
+	}
+	return fmt.Sprintf("--- %s %d (doc: %s / %s) ---\n", label, i+1, docID, docName)
+}
 
-	// S3: Stream LLM response
-	return s.llm.StreamCompletion(ctx, system, user, out)
+func renderHistory(turns []Turn) string {
+	var sb strings.Builder
+	for _, t := range turns {
+		label := "User"
+		if t.Role == "assistant" {
+			label = "Assistant"
+		}
+		content := t.Content
+		if r := []rune(content); len(r) > maxTurnChars {
+			content = string(r[:maxTurnChars]) + "…"
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", label, content)
+	}
+	return sb.String()
 }
@@ -0,0 +1,81 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Row-level security on vectors
+//
+// The embedding table gets the policies migrations 046 and 053 give
+// documents: tenancy.TenantRole reads and writes its org's chunks and
+// reads the chunks of documents granted to it, alone or in a collection.
+// langchaingo creates the table at runtime, so the policies are added at
+// startup once the role exists, along with grants on the vector tables
+// the app creates itself.
+
+// vectorPolicies maps each policy on the embedding table to its
+// definition.
+var vectorPolicies = map[string]string{
+	"tenant_rows": fmt.Sprintf(`CREATE POLICY tenant_rows ON %s TO %s
+		USING ((cmetadata->>'org_id') = app_current_org())
+		WITH CHECK ((cmetadata->>'org_id') = app_current_org())`, EmbeddingTable, tenancy.TenantRole),
+	"tenant_granted": fmt.Sprintf(`CREATE POLICY tenant_granted ON %s FOR SELECT TO %s
+		USING ((cmetadata->>'document_id') = ANY (ARRAY(
+			SELECT g.document_id FROM document_grants g WHERE g.grantee_org_id = app_current_org())))`,
+		EmbeddingTable, tenancy.TenantRole),
+	"tenant_collection_granted": fmt.Sprintf(`CREATE POLICY tenant_collection_granted ON %s FOR SELECT TO %s
+		USING ((cmetadata->>'document_id') = ANY (ARRAY(
+			SELECT cd.document_id FROM collection_grants g
+			JOIN collection_documents cd ON cd.collection_id = g.collection_id
+			WHERE g.grantee_org_id = app_current_org())))`,
+		EmbeddingTable, tenancy.TenantRole),
+}
+
+// secureVectors applies vectorPolicies to db's embedding table and grants
+// the tenant role the vector tables, if the role exists.
+func secureVectors(ctx context.Context, db database.DBTX) error {
+	var exists, enabled bool
+	if err := db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1),
+		        COALESCE((SELECT relrowsecurity FROM pg_class WHERE oid = to_regclass($2)), false)`,
+		tenancy.TenantRole, EmbeddingTable).Scan(&exists, &enabled); err != nil {
+		return fmt.Errorf("check row-level security: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if _, err := db.Exec(ctx, fmt.Sprintf(`GRANT SELECT, INSERT, UPDATE, DELETE ON %s, %s, %s TO %s`,
+		EmbeddingTable, CollectionTable, CollectionPointerTable, tenancy.TenantRole)); err != nil {
+		return fmt.Errorf("grant vector tables: %w", err)
+	}
+	rows, err := db.Query(ctx,
+		`SELECT policyname::text FROM pg_policies WHERE schemaname = current_schema() AND tablename = $1`, EmbeddingTable)
+	if err != nil {
+		return fmt.Errorf("list vector policies: %w", err)
+	}
+	existing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("list vector policies: %w", err)
+	}
+	for name, create := range vectorPolicies {
+		if slices.Contains(existing, name) {
+			continue
+		}
+		if _, err := db.Exec(ctx, create); err != nil {
+			return fmt.Errorf("create vector policy %s: %w", name, err)
+		}
+	}
+	if !enabled {
+		if _, err := db.Exec(ctx, `ALTER TABLE `+EmbeddingTable+` ENABLE ROW LEVEL SECURITY`); err != nil {
+			return fmt.Errorf("enable row-level security: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package retrieval
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// Small talk
+//
+// Greetings, thanks and other small talk have nothing to look up: searching
+// for "hello" only finds noise, and the grounding rules then make the model
+// say it doesn't have enough information. With AnswerSmallTalk on, such a
+// message is answered directly instead. Obvious small talk (IsSmallTalk)
+// skips retrieval altogether; a message the intent classifier tags
+// IntentChitChat is answered as small talk when retrieval finds nothing
+// for it, in place of NoContextAnswer.
+
+// IntentChitChat is the intent the classifier (see ClassifyWith) gives
+// small talk.
+const IntentChitChat = "chit_chat"
+
+// smallTalkRules replace the grounding rules for small talk.
+const smallTalkRules = `The user is making small talk rather than asking a question.
+Reply in one or two friendly sentences, without making up any facts, and offer to help with questions about the knowledge base.`
+
+// AnswerSmallTalk answers small talk directly, without retrieval. A
+// non-empty reply is sent as is; otherwise the LLM replies in the query's
+// persona.
+func (s *RAGService) AnswerSmallTalk(reply string) {
+	s.smallTalk, s.smallTalkReply = true, reply
+}
+
+// smallTalkPhrases are whole messages that are small talk, once
+// normalized and stripped of trailing fillers.
+var smallTalkPhrases = map[string]bool{
+	"hi": true, "hello": true, "hey": true, "hiya": true, "howdy": true, "yo": true,
+	"greetings": true, "morning": true, "good morning": true, "good afternoon": true,
+	"good evening": true, "good night": true,
+	"thanks": true, "thank you": true, "thx": true, "ty": true, "cheers": true,
+	"many thanks": true, "much appreciated": true,
+	"bye": true, "goodbye": true, "bye bye": true, "see you": true, "see ya": true,
+	"ok": true, "okay": true, "cool": true, "great": true, "nice": true,
+	"awesome": true, "perfect": true, "got it": true,
+	"how are you": true, "how are you doing": true, "how's it going": true,
+	"what's up": true, "sup": true,
+}
+
+// smallTalkFillers may trail small talk ("thanks a lot", "hi there").
+var smallTalkFillers = map[string]bool{
+	"there": true, "all": true, "everyone": true, "team": true, "bot": true,
+	"again": true, "so": true, "much": true, "very": true, "a": true, "lot": true,
+	"today": true, "then": true,
+}
+
+// IsSmallTalk reports whether message is obviously small talk, like
+// "Hi there!" or "thanks a lot". It errs towards false: anything that
+// might be a question goes through retrieval.
+func IsSmallTalk(message string) bool {
+	words := strings.Fields(strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'' || r == '’':
+			return '\''
+		case unicode.IsLetter(r) || unicode.IsSpace(r):
+			return unicode.ToLower(r)
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			return ' '
+		}
+		return r
+	}, message))
+	if len(words) == 0 || len(words) > 6 {
+		return false
+	}
+	for len(words) > 1 && smallTalkFillers[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return smallTalkPhrases[strings.Join(words, " ")]
+}
+
+// answerSmallTalk replies to small talk with no sources, and closes out.
+func (s *RAGService) answerSmallTalk(ctx context.Context, req QueryRequest, onSources func([]Source, Degradation), out chan<- string) error {
+	if req.OnSmallTalk != nil {
+		req.OnSmallTalk()
+	}
+	if onSources != nil {
+		onSources(Sources(nil), "")
+	}
+	s.recordQuery(ctx, req)
+
+	if s.smallTalkReply != "" {
+		defer close(out)
+		select {
+		case out <- s.smallTalkReply:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	persona := DefaultPersona
+	if req.SystemPrompt != "" {
+		persona = req.SystemPrompt
+	}
+	var conversation string
+	if len(req.History) > 0 {
+		conversation = "Conversation so far:\n" + renderHistory(req.History) + "\n"
+	}
+	system := persona + "\n\n" + smallTalkRules
+	if rules := formatRules[req.OutputFormat]; rules != "" {
+		system += "\n" + rules
+	}
+	return s.generate(ctx, req, system, conversation+req.Question, out)
+}
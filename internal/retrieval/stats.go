@@ -0,0 +1,146 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Vector usage
+// vector_stats keeps a running count and size of each org's vectors for
+// the stats endpoint and storage-based billing. Statement-level triggers on
+// the embedding table fold every insert, update (org moves) and delete into
+// it, so reading usage never scans the vectors. Isolated orgs' triggers run
+// in their own storage: a tenant schema resolves vector_stats to the shared
+// table, a tenant database keeps its own.
+
+// VectorStats is one org's vector usage.
+type VectorStats struct {
+	OrgID   string `json:"org_id"`
+	Vectors int64  `json:"vectors"`
+	// Bytes is the stored size of the rows (embedding, text and
+	// metadata), excluding index overhead.
+	Bytes     int64     `json:"storage_bytes"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+const statsTriggerFunc = `
+CREATE OR REPLACE FUNCTION track_vector_stats() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	IF TG_OP IN ('DELETE', 'UPDATE') THEN
+		INSERT INTO vector_stats (org_id, vectors, bytes)
+		SELECT cmetadata->>'org_id', -count(*), -sum(pg_column_size(o.*))::bigint
+		FROM old_rows o WHERE cmetadata->>'org_id' IS NOT NULL GROUP BY 1
+		ON CONFLICT (org_id) DO UPDATE SET
+			vectors = vector_stats.vectors + EXCLUDED.vectors,
+			bytes = vector_stats.bytes + EXCLUDED.bytes,
+			updated_at = NOW();
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		INSERT INTO vector_stats (org_id, vectors, bytes)
+		SELECT cmetadata->>'org_id', count(*), sum(pg_column_size(n.*))::bigint
+		FROM new_rows n WHERE cmetadata->>'org_id' IS NOT NULL GROUP BY 1
+		ON CONFLICT (org_id) DO UPDATE SET
+			vectors = vector_stats.vectors + EXCLUDED.vectors,
+			bytes = vector_stats.bytes + EXCLUDED.bytes,
+			updated_at = NOW();
+	END IF;
+	-- Purged orgs (and orgs merged away) leave nothing behind.
+	DELETE FROM vector_stats WHERE vectors <= 0;
+	RETURN NULL;
+END $$`
+
+// installStats attaches the usage triggers to db's embedding table. The
+// first install counts the vectors already there, under a lock so no write
+// slips between the count and the triggers.
+func installStats(ctx context.Context, db database.Beginner) error {
+	return database.NewUnitOfWork(db).Do(ctx, func(tx pgx.Tx) error {
+		var installed bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'vector_stats_delete' AND tgrelid = $1::regclass)`,
+			EmbeddingTable).Scan(&installed); err != nil {
+			return fmt.Errorf("check vector stats triggers: %w", err)
+		}
+		if installed {
+			return nil
+		}
+
+		stmts := []string{
+			`LOCK TABLE ` + EmbeddingTable + ` IN SHARE ROW EXCLUSIVE MODE`,
+			statsTriggerFunc,
+			`CREATE OR REPLACE TRIGGER vector_stats_insert AFTER INSERT ON ` + EmbeddingTable +
+				` REFERENCING NEW TABLE AS new_rows FOR EACH STATEMENT EXECUTE FUNCTION track_vector_stats()`,
+			`CREATE OR REPLACE TRIGGER vector_stats_update AFTER UPDATE ON ` + EmbeddingTable +
+				` REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows FOR EACH STATEMENT EXECUTE FUNCTION track_vector_stats()`,
+			// Created last: its presence marks the install as complete.
+			`CREATE OR REPLACE TRIGGER vector_stats_delete AFTER DELETE ON ` + EmbeddingTable +
+				` REFERENCING OLD TABLE AS old_rows FOR EACH STATEMENT EXECUTE FUNCTION track_vector_stats()`,
+			`INSERT INTO vector_stats (org_id, vectors, bytes)
+			 SELECT cmetadata->>'org_id', count(*), sum(pg_column_size(e.*))::bigint
+			 FROM ` + EmbeddingTable + ` e WHERE cmetadata->>'org_id' IS NOT NULL GROUP BY 1
+			 ON CONFLICT (org_id) DO UPDATE SET
+				 vectors = EXCLUDED.vectors, bytes = EXCLUDED.bytes, updated_at = NOW()`,
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("install vector stats: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Stats returns an org's vector usage; an org without vectors has zeros.
+func (vs *LangChainVectorStore) Stats(ctx context.Context, orgID string) (*VectorStats, error) {
+	ctx = tenancy.WithOrg(ctx, orgID)
+	if _, err := vs.storeFor(ctx); err != nil {
+		return nil, err
+	}
+	st := &VectorStats{OrgID: orgID}
+	err := vs.db.QueryRow(ctx,
+		`SELECT vectors, bytes, updated_at FROM vector_stats WHERE org_id = $1`, orgID,
+	).Scan(&st.Vectors, &st.Bytes, &st.UpdatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return st, nil
+}
+
+// AllStats returns the vector usage of every org with vectors, across the
+// shared storage and each isolated org's, largest first.
+func (vs *LangChainVectorStore) AllStats(ctx context.Context) ([]*VectorStats, error) {
+	byOrg := map[string]*VectorStats{}
+	for _, scope := range vs.tenants.Scopes(ctx) {
+		rows, err := vs.db.Query(scope, `SELECT org_id, vectors, bytes, updated_at FROM vector_stats`)
+		if err != nil {
+			return nil, err
+		}
+		list, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*VectorStats, error) {
+			st := &VectorStats{}
+			err := row.Scan(&st.OrgID, &st.Vectors, &st.Bytes, &st.UpdatedAt)
+			return st, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Tenant schemas read the shared table again; a tenant database
+		// only holds its own org.
+		for _, st := range list {
+			byOrg[st.OrgID] = st
+		}
+	}
+
+	all := make([]*VectorStats, 0, len(byOrg))
+	for _, st := range byOrg {
+		all = append(all, st)
+	}
+	slices.SortFunc(all, func(a, b *VectorStats) int { return cmp.Compare(b.Bytes, a.Bytes) })
+	return all, nil
+}
@@ -0,0 +1,99 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Vector store backends
+// Chunks and their vectors live in pgvector by default, next to the rest
+// of the data. Deployments whose managed Postgres can't load the extension
+// keep them in a dedicated vector database instead (ExternalVectorStore):
+// Qdrant, Weaviate, Milvus or Redis Stack. The backends store the same
+// points (ChunkID, vector, text and metadata) and answer the same scoped
+// searches; features built on Postgres itself (hybrid and keyword search,
+// quantized indexes, per-org collections, re-embedding, row-level
+// security) are pgvector's alone.
+
+// VectorStore stores chunk vectors and searches them within an org's
+// scope. LangChainVectorStore and ExternalVectorStore implement it.
+type VectorStore interface {
+	AddEmbedded(ctx context.Context, docs []schema.Document, vecs [][]float32) error
+	SimilaritySearch(ctx context.Context, p SearchParams) ([]schema.Document, error)
+	KeywordSearch(ctx context.Context, p SearchParams) ([]schema.Document, error)
+
+	DeleteByDocument(ctx context.Context, documentID string) error
+	DeleteByDocuments(ctx context.Context, documentIDs []string) (int64, error)
+	DeleteChunksFrom(ctx context.Context, documentID string, firstChunk int) error
+	DeleteSummaries(ctx context.Context, documentID string) error
+	StoredChunks(ctx context.Context, documentID string, firstChunk int) (map[int]string, error)
+	PruneChunks(ctx context.Context, documentID string, firstChunk int, keep []int) error
+	PurgeOrg(ctx context.Context, orgID string) (int64, error)
+
+	Stats(ctx context.Context, orgID string) (*VectorStats, error)
+	AllStats(ctx context.Context) ([]*VectorStats, error)
+
+	// WithTx returns a store whose writes join tx where the backend can:
+	// pgvector's commit or roll back with it, other backends' happen
+	// at once.
+	WithTx(tx pgx.Tx) VectorStore
+	// ForgetOrg drops what the store caches about an org whose isolated
+	// storage is being released.
+	ForgetOrg(orgID string)
+	Close()
+}
+
+var (
+	_ VectorStore = (*LangChainVectorStore)(nil)
+	_ VectorStore = (*ExternalVectorStore)(nil)
+)
+
+// ErrUnsupported is returned for an operation the configured vector
+// store backend can't perform.
+var ErrUnsupported = errors.New("not supported by the vector store backend")
+
+// Backend names where vectors are stored.
+type Backend string
+
+const (
+	// BackendPgvector stores vectors in Postgres (the default).
+	BackendPgvector Backend = "pgvector"
+	BackendQdrant   Backend = "qdrant"
+	BackendWeaviate Backend = "weaviate"
+	BackendMilvus   Backend = "milvus"
+	// BackendRedis needs the RediSearch module (Redis Stack, or Redis 8).
+	BackendRedis Backend = "redis"
+)
+
+// ParseBackend validates a VECTOR_STORE value; empty means
+// BackendPgvector.
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(s); b {
+	case "":
+		return BackendPgvector, nil
+	case BackendPgvector, BackendQdrant, BackendWeaviate, BackendMilvus, BackendRedis:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown vector store %q (want %q, %q, %q, %q or %q)",
+			s, BackendPgvector, BackendQdrant, BackendWeaviate, BackendMilvus, BackendRedis)
+	}
+}
+
+// DefaultURL returns where b listens by default.
+func (b Backend) DefaultURL() string {
+	switch b {
+	case BackendQdrant:
+		return "http://localhost:6333"
+	case BackendWeaviate:
+		return "http://localhost:8080"
+	case BackendMilvus:
+		return "http://localhost:19530"
+	case BackendRedis:
+		return "redis://localhost:6379"
+	}
+	return ""
+}
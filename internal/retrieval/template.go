@@ -0,0 +1,106 @@
+package retrieval
+
+import (
+	"context"
+	"regexp"
+)
+
+// Prompt templates
+//
+// By default the system message is the persona (an assistant's, or
+// DefaultPersona) followed by the grounding rules, and the user message is
+// the conversation, the context chunks and the question. An org can word
+// either itself with a template (see PromptsFrom); {{name}} placeholders
+// in it are filled in per query.
+
+// PromptTemplate is an org's wording of the prompt. An empty System or
+// User keeps the built-in message.
+type PromptTemplate struct {
+	System     string
+	User       string
+	TenantName string
+}
+
+// PromptSource returns the template an org's queries of intent ("" when
+// unclassified) use, nil for the built-in prompt.
+type PromptSource interface {
+	PromptTemplate(ctx context.Context, orgID, intent string) (*PromptTemplate, error)
+}
+
+// PromptsFrom words each org's prompts with its template from p.
+func (s *RAGService) PromptsFrom(p PromptSource) {
+	s.prompts = p
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+// TemplateVariables lists the placeholders in a template, in order.
+func TemplateVariables(text string) []string {
+	var names []string
+	for _, m := range placeholder.FindAllStringSubmatch(text, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// expand fills in a template's placeholders in one pass, so a value that
+// itself looks like a placeholder (a question quoting "{{context}}") is
+// left alone. Unknown placeholders are kept as written.
+func expand(text string, vars map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		if v, ok := vars[placeholder.FindStringSubmatch(m)[1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// prompt writes the system and user messages for a query. Before
+// retrieval it is called with empty context to measure the fixed part.
+type prompt struct {
+	tmpl    *PromptTemplate
+	persona string
+	format  OutputFormat
+}
+
+func (s *RAGService) promptFor(ctx context.Context, req QueryRequest) (prompt, error) {
+	p := prompt{persona: DefaultPersona, format: req.OutputFormat}
+	if req.SystemPrompt != "" {
+		p.persona = req.SystemPrompt
+	}
+	if s.prompts == nil {
+		return p, nil
+	}
+	var err error
+	p.tmpl, err = s.prompts.PromptTemplate(ctx, req.OrgID, req.Intent)
+	return p, err
+}
+
+func (p prompt) system(withHistory bool) string {
+	system := p.persona + "\n\n" + groundingRules
+	if p.tmpl != nil && p.tmpl.System != "" {
+		system = expand(p.tmpl.System, map[string]string{
+			"persona":     p.persona,
+			"tenant_name": p.tmpl.TenantName,
+		})
+	}
+	if withHistory {
+		system += "\n" + historyRules
+	}
+	if rules := formatRules[p.format]; rules != "" {
+		system += "\n" + rules
+	}
+	return system
+}
+
+func (p prompt) user(conversation, context, question string) string {
+	if p.tmpl == nil || p.tmpl.User == "" {
+		return conversation + "Context:\n" + context + "\n\nQuestion: " + question
+	}
+	return expand(p.tmpl.User, map[string]string{
+		"conversation": conversation,
+		"context":      context,
+		"question":     question,
+		"tenant_name":  p.tmpl.TenantName,
+	})
+}
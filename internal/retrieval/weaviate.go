@@ -0,0 +1,305 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// weaviate speaks Weaviate's REST and GraphQL APIs. Each collection is a
+// class with vectorizer none, its filtered properties tokenized as whole
+// values.
+type weaviate struct {
+	*restClient
+}
+
+// weaviatePageSize is how many objects one listing query returns.
+const weaviatePageSize = 1000
+
+// class maps a collection name to a valid class name: a capital letter
+// then letters, digits and underscores.
+func (w *weaviate) class(collection string) string {
+	name := []rune(collection)
+	for i, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || !unicode.IsLetter(name[0]) {
+		name = append([]rune("C"), name...)
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// ensure reports no vector size: Weaviate takes it from the first vector
+// stored.
+func (w *weaviate) ensure(ctx context.Context, collection string, dimensions int) (int, error) {
+	class := w.class(collection)
+	err := w.do(ctx, http.MethodGet, "/v1/schema/"+url.PathEscape(class), nil, nil)
+	if err == nil || !notFound(err) {
+		return 0, err
+	}
+	keyword := func(name string) map[string]any {
+		return map[string]any{"name": name, "dataType": []string{"text"}, "tokenization": "field", "indexSearchable": false}
+	}
+	stored := func(name string) map[string]any {
+		return map[string]any{"name": name, "dataType": []string{"text"}, "indexFilterable": false, "indexSearchable": false}
+	}
+	return 0, w.do(ctx, http.MethodPost, "/v1/schema", map[string]any{
+		"class":             class,
+		"vectorizer":        "none",
+		"vectorIndexConfig": map[string]any{"distance": "cosine"},
+		"properties": []any{
+			keyword("org_id"), keyword("document_id"), keyword("level"),
+			map[string]any{"name": "chunk_index", "dataType": []string{"int"}},
+			stored("content"), stored("metadata"),
+		},
+	}, nil)
+}
+
+func (w *weaviate) upsert(ctx context.Context, collection string, points []point) error {
+	class := w.class(collection)
+	objects := make([]map[string]any, len(points))
+	for i, p := range points {
+		objects[i] = map[string]any{
+			"class":  class,
+			"id":     p.id,
+			"vector": p.vector,
+			"properties": map[string]any{
+				"org_id":      p.orgID,
+				"document_id": p.documentID,
+				"level":       p.level,
+				"chunk_index": p.chunkIndex,
+				"content":     p.content,
+				"metadata":    p.metadata,
+			},
+		}
+	}
+	var results []struct {
+		Result struct {
+			Errors *struct {
+				Error []struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			} `json:"errors"`
+		} `json:"result"`
+	}
+	if err := w.do(ctx, http.MethodPost, "/v1/batch/objects", map[string]any{"objects": objects}, &results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Result.Errors != nil && len(r.Result.Errors.Error) > 0 {
+			return errors.New(r.Result.Errors.Error[0].Message)
+		}
+	}
+	return nil
+}
+
+// where translates m into a Weaviate where filter; nil matches
+// everything.
+func (w *weaviate) where(m match) map[string]any {
+	var operands []map[string]any
+	if len(m.ids) > 0 {
+		operands = append(operands, map[string]any{"path": []string{"id"}, "operator": "ContainsAny", "valueText": m.ids})
+	}
+	if m.orgID != "" {
+		operands = append(operands, map[string]any{"path": []string{"org_id"}, "operator": "Equal", "valueText": m.orgID})
+	}
+	if len(m.documentIDs) > 0 {
+		operands = append(operands, map[string]any{"path": []string{"document_id"}, "operator": "ContainsAny", "valueText": m.documentIDs})
+	}
+	switch m.level {
+	case levelChunk:
+		operands = append(operands, map[string]any{"path": []string{"level"}, "operator": "Equal", "valueText": levelChunk})
+	case levelSummary:
+		operands = append(operands, map[string]any{"path": []string{"level"}, "operator": "NotEqual", "valueText": levelChunk})
+	}
+	if m.fromChunk > 0 {
+		operands = append(operands, map[string]any{"path": []string{"chunk_index"}, "operator": "GreaterThanEqual", "valueInt": m.fromChunk})
+	}
+	return weaviateAll(operands)
+}
+
+func weaviateAll(operands []map[string]any) map[string]any {
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	}
+	return map[string]any{"operator": "And", "operands": operands}
+}
+
+// graphQL renders v as a GraphQL input value: JSON, except that keys
+// are bare and operators are enum values.
+func graphQL(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			value := graphQL(v[k])
+			if k == "operator" {
+				value = v[k].(string)
+			}
+			fields[i] = k + ": " + value
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case []map[string]any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = graphQL(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// query runs a GraphQL query, decoding its data into out.
+func (w *weaviate) query(ctx context.Context, q string, out any) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := w.do(ctx, http.MethodPost, "/v1/graphql", map[string]any{"query": q}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return errors.New(resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// args renders GraphQL arguments, leaving out a nil where.
+func (w *weaviate) args(where map[string]any, more ...string) string {
+	if where != nil {
+		more = append(more, "where: "+graphQL(where))
+	}
+	return "(" + strings.Join(more, ", ") + ")"
+}
+
+func (w *weaviate) search(ctx context.Context, collection string, vec []float32, s scope, limit int) ([]hit, error) {
+	owner := []map[string]any{{"path": []string{"org_id"}, "operator": "Equal", "valueText": s.orgID}}
+	if len(s.shared) > 0 {
+		owner = append(owner, map[string]any{"path": []string{"document_id"}, "operator": "ContainsAny", "valueText": s.shared})
+	}
+	operands := []map[string]any{{"operator": "Or", "operands": owner}}
+	if where := w.where(s.match); where != nil {
+		operands = append(operands, where)
+	}
+	vector, _ := json.Marshal(vec)
+	class := w.class(collection)
+	q := fmt.Sprintf(`{ Get { %s%s { content metadata _additional { distance } } } }`, class,
+		w.args(weaviateAll(operands), "nearVector: {vector: "+string(vector)+"}", fmt.Sprintf("limit: %d", limit)))
+
+	var data struct {
+		Get map[string][]struct {
+			Content    string `json:"content"`
+			Metadata   string `json:"metadata"`
+			Additional struct {
+				Distance float32 `json:"distance"`
+			} `json:"_additional"`
+		} `json:"Get"`
+	}
+	if err := w.query(ctx, q, &data); err != nil {
+		return nil, err
+	}
+	results := data.Get[class]
+	hits := make([]hit, len(results))
+	for i, r := range results {
+		hits[i] = hit{content: r.Content, metadata: r.Metadata, score: 1 - r.Additional.Distance}
+	}
+	return hits, nil
+}
+
+func (w *weaviate) list(ctx context.Context, collection string, m match) ([]point, error) {
+	class := w.class(collection)
+	var points []point
+	for offset := 0; ; offset += weaviatePageSize {
+		q := fmt.Sprintf(`{ Get { %s%s { chunk_index content _additional { id } } } }`, class,
+			w.args(w.where(m), fmt.Sprintf("limit: %d", weaviatePageSize), fmt.Sprintf("offset: %d", offset)))
+		var data struct {
+			Get map[string][]struct {
+				ChunkIndex int    `json:"chunk_index"`
+				Content    string `json:"content"`
+				Additional struct {
+					ID string `json:"id"`
+				} `json:"_additional"`
+			} `json:"Get"`
+		}
+		if err := w.query(ctx, q, &data); err != nil {
+			return nil, err
+		}
+		for _, r := range data.Get[class] {
+			points = append(points, point{id: r.Additional.ID, chunkIndex: r.ChunkIndex, content: r.Content})
+		}
+		if len(data.Get[class]) < weaviatePageSize {
+			return points, nil
+		}
+	}
+}
+
+func (w *weaviate) count(ctx context.Context, collection string, m match) (int64, error) {
+	class := w.class(collection)
+	args := ""
+	if where := w.where(m); where != nil {
+		args = w.args(where)
+	}
+	var data struct {
+		Aggregate map[string][]struct {
+			Meta struct {
+				Count int64 `json:"count"`
+			} `json:"meta"`
+		} `json:"Aggregate"`
+	}
+	if err := w.query(ctx, fmt.Sprintf(`{ Aggregate { %s%s { meta { count } } } }`, class, args), &data); err != nil {
+		return 0, err
+	}
+	if results := data.Aggregate[class]; len(results) > 0 {
+		return results[0].Meta.Count, nil
+	}
+	return 0, nil
+}
+
+// delete removes the matching objects in rounds, since one batch delete
+// stops at the server's query limit.
+func (w *weaviate) delete(ctx context.Context, collection string, m match) error {
+	for {
+		var resp struct {
+			Results struct {
+				Matches int64 `json:"matches"`
+				Limit   int64 `json:"limit"`
+				Failed  int64 `json:"failed"`
+			} `json:"results"`
+		}
+		if err := w.do(ctx, http.MethodDelete, "/v1/batch/objects", map[string]any{
+			"match":  map[string]any{"class": w.class(collection), "where": w.where(m)},
+			"output": "minimal",
+		}, &resp); err != nil {
+			return err
+		}
+		if resp.Results.Failed > 0 {
+			return fmt.Errorf("%d objects not deleted", resp.Results.Failed)
+		}
+		if resp.Results.Limit == 0 || resp.Results.Matches < resp.Results.Limit {
+			return nil
+		}
+	}
+}
+
+func (w *weaviate) close() {}
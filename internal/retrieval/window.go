@@ -0,0 +1,123 @@
+package retrieval
+
+import (
+	"cmp"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Context window budget
+//
+// Concatenating TopK chunks, the conversation and the question into one
+// prompt is fine until a large TopK, long chunks or a small local model
+// push it past the model's context window, which providers answer with a
+// 400. With a budget set (FitContextWith), the prompt is counted before it
+// is sent, leaving room for the answer (the query's max_tokens, or
+// answerReserve tokens): history beyond half of what's left drops its
+// oldest turns, then chunks are taken in rank order while they fit and the
+// first that doesn't is trimmed to the space remaining. Sources list only
+// the chunks that made it into the prompt, so citations stay numbered as
+// the model saw them.
+
+// answerReserve is how many tokens of the window are kept for the answer
+// when the query doesn't set max_tokens.
+const answerReserve = 1024
+
+// minTrimTokens is the smallest piece of a chunk worth trimming it to;
+// below it the chunk is left out.
+const minTrimTokens = 64
+
+// ErrPromptTooLarge is returned when the question, instructions and the
+// most recent turn leave no room for any context in the model's window.
+var ErrPromptTooLarge = errors.New("question too long for the model's context window")
+
+// FitContextWith budgets prompts against the model's context window:
+// window returns the size in tokens of the model a query asks for ("" for
+// the default; 0 when unknown, which skips the budget), and count measures
+// text in tokens (nil estimates).
+func (s *RAGService) FitContextWith(window func(model string) int, count func(string) int) {
+	s.window = window
+	s.countTokens = count
+	if s.countTokens == nil {
+		s.countTokens = estimateTokens
+	}
+}
+
+// fitPrompt trims history and chunks so the prompt fits the window of the
+// model req asks for. fixed is the text always sent: instructions and
+// question.
+func (s *RAGService) fitPrompt(req QueryRequest, fixed string, docs []schema.Document) ([]Turn, []schema.Document, error) {
+	if s.window == nil {
+		return req.History, docs, nil
+	}
+	window := s.window(req.Model)
+	if window <= 0 || len(docs) == 0 {
+		return req.History, docs, nil
+	}
+	avail := window - cmp.Or(req.Generation.MaxTokens, answerReserve) - s.countTokens(fixed)
+
+	history := req.History
+	if len(history) > 0 {
+		avail -= s.countTokens(historyRules)
+		for len(history) > 1 && s.countTokens(renderHistory(history)) > avail/2 {
+			history = history[1:]
+		}
+		avail -= s.countTokens(renderHistory(history))
+	}
+	if avail < minTrimTokens {
+		return nil, nil, ErrPromptTooLarge
+	}
+
+	var kept []schema.Document
+	for i, doc := range docs {
+		// The chunk header ("--- Chunk 3 (doc: ... / ...) ---") counts too.
+		need := s.countTokens(doc.PageContent) + s.countTokens(chunkHeader(i, doc))
+		if need <= avail {
+			kept = append(kept, doc)
+			avail -= need
+			continue
+		}
+		if room := avail - s.countTokens(chunkHeader(i, doc)); room >= minTrimTokens {
+			doc.PageContent = s.trimTokens(doc.PageContent, room)
+			kept = append(kept, doc)
+		}
+		break
+	}
+	if len(kept) == 0 {
+		return nil, nil, ErrPromptTooLarge
+	}
+	return history, kept, nil
+}
+
+// trimTokens cuts text to at most limit tokens, at a word boundary where
+// there is one.
+func (s *RAGService) trimTokens(text string, limit int) string {
+	runes := []rune(text)
+	n := len(runes) * limit / max(s.countTokens(text), 1)
+	for n > 0 && s.countTokens(string(runes[:n])+"…") > limit {
+		n = n * 9 / 10
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexAny(cut, " \n"); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// estimateTokens errs on the high side of what OpenAI's tokenizers count:
+// four ASCII characters per token, but a token for every other character,
+// which is close for CJK scripts and generous for accented Latin.
+func estimateTokens(text string) int {
+	n, ascii := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			n++
+		}
+	}
+	return n + (ascii+3)/4
+}
@@ -0,0 +1,203 @@
+// Package retry retries the service's calls to model providers (chat
+// completions, embeddings, reranking) that fail with a rate limit (429) or
+// a transient server error (5xx). Without it, one hiccup fails a whole
+// ingestion or query. It works at the HTTP level, under each provider
+// client, and only looks at the response status: a streamed completion is
+// retried before its first token, never midway.
+//
+// A rate limit holds back every call to the endpoint, not just the one
+// that hit it: the ingestion workers embedding in parallel all wait out
+// the provider's Retry-After together, rather than each hammering it
+// into a storm of 429s.
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAttempts is how many times a failed call is worth retrying.
+	DefaultAttempts = 3
+	// DefaultBudget is how long a call may wait between its retries when
+	// Transport.Budget is unset.
+	DefaultBudget = 30 * time.Second
+
+	firstDelay = 500 * time.Millisecond
+	maxDelay   = 8 * time.Second
+)
+
+// Transport retries requests answered with a retryable status. Waits
+// double from half a second with jitter, or follow the provider's
+// Retry-After, and a call gives up once it has retried Attempts times or
+// the next wait would take it past Budget; the caller then gets the last
+// response as is.
+type Transport struct {
+	// Attempts is the number of retries; 0 passes requests through.
+	Attempts int
+	// Budget bounds the total wait of one call; 0 uses DefaultBudget.
+	Budget time.Duration
+	// Next carries the requests; nil uses http.DefaultTransport.
+	Next http.RoundTripper
+
+	mu sync.Mutex
+	// cooldowns holds, by endpoint (host and path), when calls may go out
+	// again after a rate limit.
+	cooldowns map[string]time.Time
+}
+
+// Client returns an HTTP client using t, with the given timeout.
+func (t *Transport) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: t, Timeout: timeout}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if t.Attempts <= 0 {
+		return next.RoundTrip(req)
+	}
+	budget := t.Budget
+	if budget == 0 {
+		budget = DefaultBudget
+	}
+
+	// Each attempt sends the body again.
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		getBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		req.Body, _ = getBody()
+	}
+
+	endpoint := req.URL.Host + req.URL.Path
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := t.coolDown(req.Context(), endpoint); err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(req)
+		if err != nil || !retryable(resp.StatusCode) {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header)
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.throttle(endpoint, wait)
+		}
+		if attempt > t.Attempts || waited+wait > budget {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		slog.Warn("provider call failed, retrying", "host", req.URL.Host, "status", resp.StatusCode, "attempt", attempt, "retry_in", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		waited += wait
+
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// throttle holds calls to endpoint back for wait, or longer if another
+// rate limit asked for more.
+func (t *Transport) throttle(endpoint string, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cooldowns == nil {
+		t.cooldowns = make(map[string]time.Time)
+	}
+	if until := time.Now().Add(wait); until.After(t.cooldowns[endpoint]) {
+		t.cooldowns[endpoint] = until
+	}
+}
+
+// coolDown waits until calls to endpoint may go out again.
+func (t *Transport) coolDown(ctx context.Context, endpoint string) error {
+	t.mu.Lock()
+	wait := time.Until(t.cooldowns[endpoint])
+	if wait <= 0 {
+		delete(t.cooldowns, endpoint)
+	}
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether a response with status is worth retrying:
+// rate limits and server errors other than those saying the request
+// itself is unsupported.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= 500
+}
+
+// backoff is the wait before retry n (from 1): firstDelay doubled n-1
+// times, capped at maxDelay, with its upper half jittered so clients
+// throttled together don't retry together.
+func backoff(n int) time.Duration {
+	d := min(firstDelay<<min(n-1, 8), maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter reads how long the provider asked to wait: Retry-After in
+// seconds or as a date, or OpenAI's retry-after-ms. It returns 0 when
+// none is usable.
+func retryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+// Package routing picks which assistant should answer a question, so a
+// tenant with segmented knowledge (HR, Eng, Sales...) can expose a single
+// entry point. Routing is a short LLM classification over the org's
+// assistant names and descriptions.
+package routing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/pixell07/multi-tenant-ai/internal/assistant"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+)
+
+// Decision records the routing outcome. Assistant is nil when the
+// question should go to the org's default (unscoped) assistant.
+type Decision struct {
+	Assistant *assistant.Assistant
+	Reason    string
+}
+
+type Router struct {
+	assistants *assistant.Service
+	llm        retrieval.LLMClient
+	logger     *slog.Logger
+}
+
+func NewRouter(assistants *assistant.Service, llmClient retrieval.LLMClient, logger *slog.Logger) *Router {
+	return &Router{assistants: assistants, llm: llmClient, logger: logger}
+}
+
+const classifierPrompt = `You route questions to the assistant best suited to answer them.
+Reply with ONLY the number of the best assistant, or 0 if none clearly fits.`
+
+// Route classifies question against orgID's assistants. Classification
+// failures fall back to the default assistant rather than failing the
+// query; every decision is logged.
+func (r *Router) Route(ctx context.Context, orgID, question string) (*Decision, error) {
+	list, err := r.assistants.List(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	d := r.classify(ctx, list, question)
+	chosen := "default"
+	if d.Assistant != nil {
+		chosen = d.Assistant.ID
+	}
+	r.logger.Info("query routed",
+		"org_id", orgID,
+		"assistant_id", chosen,
+		"reason", d.Reason,
+		"candidates", len(list),
+	)
+	return d, nil
+}
+
+func (r *Router) classify(ctx context.Context, list []*assistant.Assistant, question string) *Decision {
+	if len(list) == 0 {
+		return &Decision{Reason: "no assistants configured"}
+	}
+
+	var sb strings.Builder
+	for i, a := range list {
+		fmt.Fprintf(&sb, "%d. %s: %s\n", i+1, a.Name, a.Description)
+	}
+	user := fmt.Sprintf("Assistants:\n%s\nQuestion: %s", sb.String(), question)
+
+	answer, err := retrieval.Complete(ctx, r.llm, classifierPrompt, user, llm.CompletionOptions{})
+	if err != nil {
+		return &Decision{Reason: "classifier error: " + err.Error()}
+	}
+
+	n, err := strconv.Atoi(strings.Trim(strings.TrimSpace(answer), "."))
+	switch {
+	case err != nil || n < 0 || n > len(list):
+		return &Decision{Reason: fmt.Sprintf("unparseable classifier output %q", answer)}
+	case n == 0:
+		return &Decision{Reason: "no assistant matched"}
+	default:
+		return &Decision{Assistant: list[n-1], Reason: "classified"}
+	}
+}
@@ -0,0 +1,68 @@
+// Package secret encrypts credentials the server has to present again
+// later, such as CRM access tokens, before they are stored. Secrets it
+// only has to recognize (API keys, refresh tokens) are hashed instead.
+//
+// Sealed values are AES-256-GCM with a random nonce, bound to a label
+// naming the row they belong to, so a sealed value copied to another row
+// doesn't open.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of a Box key.
+const KeySize = 32
+
+var (
+	ErrInvalidKey = errors.New("secret key must be 32 bytes")
+	// ErrOpen is returned for values sealed under another key or label,
+	// or altered since.
+	ErrOpen = errors.New("sealed value doesn't open")
+)
+
+// Box seals and opens values under one key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+func New(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithRandomNonce(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// DeriveKey derives a KeySize key for the use named by label from another
+// secret of the server, for deployments without a key of their own. Keys
+// of different labels are unrelated; each changes with the secret.
+func DeriveKey(secret []byte, label string) ([]byte, error) {
+	return hkdf.Key(sha256.New, secret, nil, label, KeySize)
+}
+
+// Seal encrypts plaintext for the row named by label.
+func (b *Box) Seal(plaintext []byte, label string) []byte {
+	return b.aead.Seal(nil, nil, plaintext, []byte(label))
+}
+
+// Open decrypts a value Seal returned for the same label.
+func (b *Box) Open(sealed []byte, label string) ([]byte, error) {
+	plaintext, err := b.aead.Open(nil, nil, sealed, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOpen, err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,188 @@
+// Package sharing manages explicit read-only grants that let one org
+// retrieve from specific documents owned by another (agency/client setups).
+// A grant names one document or one collection; a collection grant covers
+// whatever documents are in the collection when a query runs. Grants are
+// checked at query time and merged into the retrieval scope.
+package sharing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound      = errors.New("grant not found")
+	ErrInvalidTarget = errors.New("document, collection or grantee organization not found")
+	ErrSelfGrant     = errors.New("cannot share with its own organization")
+	ErrDuplicate     = errors.New("already shared with that organization")
+)
+
+// Grant shares either DocumentID or CollectionID; the other is empty.
+type Grant struct {
+	ID           string    `json:"id"`
+	OwnerOrgID   string    `json:"owner_org_id"`
+	GranteeOrgID string    `json:"grantee_org_id"`
+	DocumentID   string    `json:"document_id,omitempty"`
+	CollectionID string    `json:"collection_id,omitempty"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Create inserts a grant. The INSERT ... SELECT only matches when the
+// document or collection belongs to the owner and the grantee org exists,
+// so a caller can't share someone else's.
+func (r *Repository) Create(ctx context.Context, g *Grant) error {
+	query := `INSERT INTO document_grants (id, owner_org_id, grantee_org_id, document_id, created_by, created_at)
+		 SELECT $1, $2, $3, $4, $5, $6
+		 WHERE EXISTS (SELECT 1 FROM documents WHERE id = $4 AND org_id = $2)
+		   AND EXISTS (SELECT 1 FROM organizations WHERE id = $3)`
+	target := g.DocumentID
+	if g.CollectionID != "" {
+		query = `INSERT INTO collection_grants (id, owner_org_id, grantee_org_id, collection_id, created_by, created_at)
+		 SELECT $1, $2, $3, $4, $5, $6
+		 WHERE EXISTS (SELECT 1 FROM collections WHERE id = $4 AND org_id = $2)
+		   AND EXISTS (SELECT 1 FROM organizations WHERE id = $3)`
+		target = g.CollectionID
+	}
+	tag, err := r.db.Exec(ctx, query, g.ID, g.OwnerOrgID, g.GranteeOrgID, target, g.CreatedBy, g.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidTarget
+	}
+	return nil
+}
+
+// Delete removes a document or collection grant; IDs are UUIDs, unique
+// across both tables.
+func (r *Repository) Delete(ctx context.Context, id, ownerOrgID string) error {
+	tag, err := r.db.Exec(ctx,
+		`WITH d AS (DELETE FROM document_grants WHERE id = $1 AND owner_org_id = $2 RETURNING 1),
+		      c AS (DELETE FROM collection_grants WHERE id = $1 AND owner_org_id = $2 RETURNING 1)
+		 SELECT 1 FROM d UNION ALL SELECT 1 FROM c`, id, ownerOrgID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListOutgoing returns grants the org has given; ListIncoming those it received.
+func (r *Repository) ListOutgoing(ctx context.Context, orgID string) ([]*Grant, error) {
+	return r.list(ctx, `owner_org_id = $1`, orgID)
+}
+
+func (r *Repository) ListIncoming(ctx context.Context, orgID string) ([]*Grant, error) {
+	return r.list(ctx, `grantee_org_id = $1`, orgID)
+}
+
+func (r *Repository) list(ctx context.Context, where, orgID string) ([]*Grant, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT id, owner_org_id, grantee_org_id, document_id, '', created_by, created_at
+		 FROM document_grants WHERE `+where+`
+		 UNION ALL
+		 SELECT id, owner_org_id, grantee_org_id, '', collection_id, created_by, created_at
+		 FROM collection_grants WHERE `+where+`
+		 ORDER BY created_at DESC`,
+		orgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Grant, error) {
+		g := &Grant{}
+		err := row.Scan(&g.ID, &g.OwnerOrgID, &g.GranteeOrgID, &g.DocumentID, &g.CollectionID, &g.CreatedBy, &g.CreatedAt)
+		return g, err
+	})
+}
+
+// SharedDocumentIDs returns the documents granted to granteeOrgID, alone
+// or through the collections they are in now.
+func (r *Repository) SharedDocumentIDs(ctx context.Context, granteeOrgID string) ([]string, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT document_id FROM document_grants WHERE grantee_org_id = $1
+		 UNION
+		 SELECT cd.document_id FROM collection_grants g
+		 JOIN collection_documents cd ON cd.collection_id = g.collection_id
+		 WHERE g.grantee_org_id = $1`, granteeOrgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+type Service struct {
+	repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// ShareRequest names either a document or a collection.
+type ShareRequest struct {
+	DocumentID   string `json:"document_id"`
+	CollectionID string `json:"collection_id"`
+	GranteeOrgID string `json:"grantee_org_id"`
+}
+
+// Share grants granteeOrgID read-only retrieval access to one of
+// ownerOrgID's documents or to the documents of one of its collections.
+func (s *Service) Share(ctx context.Context, ownerOrgID, userID string, req ShareRequest) (*Grant, error) {
+	if req.GranteeOrgID == ownerOrgID {
+		return nil, ErrSelfGrant
+	}
+	g := &Grant{
+		ID:           uuid.NewString(),
+		OwnerOrgID:   ownerOrgID,
+		GranteeOrgID: req.GranteeOrgID,
+		DocumentID:   req.DocumentID,
+		CollectionID: req.CollectionID,
+		CreatedBy:    userID,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.repo.Create(ctx, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (s *Service) Revoke(ctx context.Context, id, ownerOrgID string) error {
+	return s.repo.Delete(ctx, id, ownerOrgID)
+}
+
+func (s *Service) ListOutgoing(ctx context.Context, orgID string) ([]*Grant, error) {
+	return s.repo.ListOutgoing(ctx, orgID)
+}
+
+func (s *Service) ListIncoming(ctx context.Context, orgID string) ([]*Grant, error) {
+	return s.repo.ListIncoming(ctx, orgID)
+}
+
+// SharedDocumentIDs implements retrieval.GrantResolver.
+func (s *Service) SharedDocumentIDs(ctx context.Context, orgID string) ([]string, error) {
+	return s.repo.SharedDocumentIDs(ctx, orgID)
+}
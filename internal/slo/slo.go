@@ -0,0 +1,320 @@
+// Package slo tracks how the service is doing against its objectives: the
+// success rate and latency percentiles of queries and ingestion over a
+// rolling hour, and the reachability of what they depend on. It backs the
+// public status page (GET /status). Outcomes are kept in memory per
+// instance, so behind several replicas each reports on its own traffic.
+package slo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Tracked operations.
+const (
+	OpQuery     = "query"
+	OpIngestion = "ingestion"
+)
+
+// Component states, from best to worst.
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusMaintenance = "maintenance"
+	StatusOutage      = "outage"
+)
+
+const (
+	// Window is how far back success rates and latencies look.
+	Window = time.Hour
+	// slotWidth is the resolution at which outcomes age out of Window.
+	slotWidth = time.Minute
+	// outageBelow is the success rate under which an operation is down
+	// rather than degraded.
+	outageBelow = 0.5
+	// CacheTTL is how long a computed status is served; the status page is
+	// public, so polling it must stay cheap.
+	CacheTTL = 15 * time.Second
+	// probeTimeout bounds each dependency check.
+	probeTimeout = 3 * time.Second
+)
+
+// latencyBounds are the upper edges of the latency histogram buckets;
+// percentiles are reported as the edge of the bucket they fall in.
+var latencyBounds = []time.Duration{
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute,
+}
+
+// Objective is what an operation is expected to achieve over Window.
+type Objective struct {
+	// SuccessRate is the minimum share of operations that succeed, 0-1.
+	SuccessRate float64
+	// LatencyP95 is the maximum 95th percentile latency.
+	LatencyP95 time.Duration
+}
+
+// DefaultObjectives apply to operations without their own. Query latency
+// covers the whole streamed answer; ingestion covers parsing, embedding
+// and storing one document.
+var DefaultObjectives = map[string]Objective{
+	OpQuery:     {SuccessRate: 0.99, LatencyP95: 30 * time.Second},
+	OpIngestion: {SuccessRate: 0.98, LatencyP95: 5 * time.Minute},
+}
+
+// Component is the health of one operation or dependency.
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Requests counts the operations in Window; omitted for dependencies.
+	Requests    int64    `json:"requests,omitempty"`
+	SuccessRate *float64 `json:"success_rate,omitempty"`
+	P50Ms       int64    `json:"p50_ms,omitempty"`
+	P95Ms       int64    `json:"p95_ms,omitempty"`
+	P99Ms       int64    `json:"p99_ms,omitempty"`
+	// Objective is what the operation is held to; omitted for
+	// dependencies.
+	Objective *ObjectiveReport `json:"objective,omitempty"`
+}
+
+// ObjectiveReport is an Objective as reported.
+type ObjectiveReport struct {
+	SuccessRate float64 `json:"success_rate"`
+	P95Ms       int64   `json:"p95_ms"`
+}
+
+// Status summarizes the service's health.
+type Status struct {
+	// Status is the worst of the components'.
+	Status        string      `json:"status"`
+	WindowSeconds int64       `json:"window_seconds"`
+	Components    []Component `json:"components"`
+	GeneratedAt   time.Time   `json:"generated_at"`
+}
+
+// slot holds the outcomes that ended in one slotWidth interval.
+type slot struct {
+	start     time.Time
+	total     int64
+	failed    int64
+	latencies []int64 // one per latencyBounds edge, plus overflow
+}
+
+type series struct {
+	slots []slot // ring indexed by slot start
+}
+
+type probe struct {
+	name  string
+	check func(context.Context) error
+}
+
+// Tracker records operation outcomes and dependency probes. It is safe
+// for concurrent use.
+type Tracker struct {
+	objectives map[string]Objective
+	// refresh lets one caller at a time recompute an expired status.
+	refresh sync.Mutex
+
+	mu     sync.Mutex
+	ops    map[string]*series
+	probes []probe
+	cached *Status
+}
+
+// New creates a tracker holding operations to objectives, falling back to
+// DefaultObjectives.
+func New(objectives map[string]Objective) *Tracker {
+	merged := make(map[string]Objective, len(DefaultObjectives))
+	for op, o := range DefaultObjectives {
+		merged[op] = o
+	}
+	for op, o := range objectives {
+		merged[op] = o
+	}
+	return &Tracker{objectives: merged, ops: map[string]*series{}}
+}
+
+// Probe adds a dependency checked whenever the status is computed; an
+// error marks it down.
+func (t *Tracker) Probe(name string, check func(context.Context) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probes = append(t.probes, probe{name: name, check: check})
+}
+
+// Observe records one finished operation. Operations abandoned by the
+// caller (a cancelled context) say nothing about the service and are
+// ignored. It fits retrieval.Observer and document.Observer.
+func (t *Tracker) Observe(op string, took time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	now := time.Now()
+	start := now.Truncate(slotWidth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.ops[op]
+	if !ok {
+		s = &series{slots: make([]slot, Window/slotWidth)}
+		t.ops[op] = s
+	}
+	sl := &s.slots[start.Unix()/int64(slotWidth/time.Second)%int64(len(s.slots))]
+	if !sl.start.Equal(start) {
+		*sl = slot{start: start, latencies: make([]int64, len(latencyBounds)+1)}
+	}
+	sl.total++
+	if err != nil {
+		sl.failed++
+	}
+	b, _ := slices.BinarySearch(latencyBounds, took)
+	sl.latencies[b]++
+}
+
+// Status reports the health of every tracked operation and dependency,
+// recomputed at most every CacheTTL. The result is shared and must not be
+// modified.
+func (t *Tracker) Status(ctx context.Context) *Status {
+	if st := t.fresh(); st != nil {
+		return st
+	}
+	t.refresh.Lock()
+	defer t.refresh.Unlock()
+	if st := t.fresh(); st != nil {
+		return st
+	}
+	t.mu.Lock()
+	probes := slices.Clone(t.probes)
+	t.mu.Unlock()
+
+	// Probes run unlocked: they may be slow, and Observe must not wait.
+	deps := make([]Component, len(probes))
+	for i, p := range probes {
+		deps[i] = check(ctx, p)
+	}
+
+	now := time.Now()
+	st := &Status{
+		Status:        StatusOperational,
+		WindowSeconds: int64(Window / time.Second),
+		GeneratedAt:   now.UTC(),
+	}
+	t.mu.Lock()
+	ops := make([]string, 0, len(t.objectives))
+	for op := range t.objectives {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+	for _, op := range ops {
+		st.Components = append(st.Components, t.component(op, now))
+	}
+	st.Components = append(st.Components, deps...)
+	for _, c := range st.Components {
+		st.Status = worse(st.Status, c.Status)
+	}
+	t.cached = st
+	t.mu.Unlock()
+	return st
+}
+
+// fresh returns the cached status unless it has expired.
+func (t *Tracker) fresh() *Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cached != nil && time.Since(t.cached.GeneratedAt) < CacheTTL {
+		return t.cached
+	}
+	return nil
+}
+
+// check runs one probe. The status is cached for everyone, so a caller
+// hanging up must not fail it; why it failed is logged rather than
+// published.
+func check(ctx context.Context, p probe) Component {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), probeTimeout)
+	defer cancel()
+	if err := p.check(ctx); err != nil {
+		slog.Warn("status probe failed", "component", p.name, "error", err)
+		return Component{Name: p.name, Status: StatusOutage}
+	}
+	return Component{Name: p.name, Status: StatusOperational}
+}
+
+// component sums op's outcomes over Window and grades them against its
+// objective. Operations without traffic are presumed operational. t.mu
+// must be held.
+func (t *Tracker) component(op string, now time.Time) Component {
+	obj := t.objectives[op]
+	c := Component{
+		Name:      op,
+		Status:    StatusOperational,
+		Objective: &ObjectiveReport{SuccessRate: obj.SuccessRate, P95Ms: obj.LatencyP95.Milliseconds()},
+	}
+	s, ok := t.ops[op]
+	if !ok {
+		return c
+	}
+
+	var failed int64
+	hist := make([]int64, len(latencyBounds)+1)
+	oldest := now.Truncate(slotWidth).Add(-Window + slotWidth)
+	for _, sl := range s.slots {
+		if sl.start.Before(oldest) {
+			continue
+		}
+		c.Requests += sl.total
+		failed += sl.failed
+		for i, n := range sl.latencies {
+			hist[i] += n
+		}
+	}
+	if c.Requests == 0 {
+		return c
+	}
+
+	rate := float64(c.Requests-failed) / float64(c.Requests)
+	c.SuccessRate = &rate
+	c.P50Ms = percentile(hist, c.Requests, 0.50).Milliseconds()
+	p95 := percentile(hist, c.Requests, 0.95)
+	c.P95Ms = p95.Milliseconds()
+	c.P99Ms = percentile(hist, c.Requests, 0.99).Milliseconds()
+
+	switch {
+	case rate < outageBelow:
+		c.Status = StatusOutage
+	case rate < obj.SuccessRate || (obj.LatencyP95 > 0 && p95 > obj.LatencyP95):
+		c.Status = StatusDegraded
+	}
+	return c
+}
+
+// percentile returns the upper edge of the bucket holding the q-th
+// quantile of total samples; samples past the last edge report it.
+func percentile(hist []int64, total int64, q float64) time.Duration {
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for i, n := range hist {
+		seen += n
+		if seen >= rank {
+			return latencyBounds[min(i, len(latencyBounds)-1)]
+		}
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
+
+var severity = map[string]int{StatusOperational: 0, StatusDegraded: 1, StatusMaintenance: 2, StatusOutage: 3}
+
+// worse returns the more severe of two states.
+func worse(a, b string) string {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,108 @@
+package summary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/collection"
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// collectionBatch is how many collections one pass rebuilds per scope.
+const collectionBatch = 20
+
+// maxCollectionSummaries bounds the document summaries a collection node
+// is built from, the most recently added documents' (25 LLM calls at most).
+const maxCollectionSummaries = 100
+
+// Collections keeps one summary node per collection, the top of the tree
+// above its documents' summaries. Documents record their top summary as
+// they are ingested; a collection whose documents' summaries changed since
+// its node was built (added, removed, re-ingested) is rebuilt by the next
+// pass, so a burst of changes costs one rebuild.
+type Collections struct {
+	summarizer *Summarizer
+	repo       *collection.Repository
+	store      retrieval.VectorStore
+	embedder   embedding.Embedder
+	scopes     tenancy.Scoper
+}
+
+func NewCollections(
+	summarizer *Summarizer,
+	repo *collection.Repository,
+	store retrieval.VectorStore,
+	embedder embedding.Embedder,
+	scopes tenancy.Scoper,
+) *Collections {
+	return &Collections{summarizer: summarizer, repo: repo, store: store, embedder: embedder, scopes: scopes}
+}
+
+// Run rebuilds the collections' stale summaries every interval until ctx
+// is cancelled.
+func (c *Collections) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, scope := range c.scopes.Scopes(ctx) {
+			stale, err := c.repo.StaleSummaries(scope, collectionBatch, maxCollectionSummaries)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("collection summary scan failed", "org_id", tenancy.OrgFrom(scope), "error", err)
+				}
+				continue
+			}
+			for _, in := range stale {
+				if err := c.rebuild(tenancy.WithOrg(scope, in.OrgID), in); err != nil && ctx.Err() == nil {
+					slog.Warn("collection summary build failed", "collection_id", in.ID, "org_id", in.OrgID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// rebuild replaces a collection's summary node. A collection with fewer
+// than two summarized documents gets none, the document's own summary
+// covering it. On failure the old node stays until the next pass.
+func (c *Collections) rebuild(ctx context.Context, in *collection.SummaryInput) error {
+	id := retrieval.CollectionSummaryID(in.ID)
+	var nodes []schema.Document
+	var vecs [][]float32
+	if len(in.Summaries) > 1 {
+		base := map[string]any{"org_id": in.OrgID, "document_id": id, "doc_name": in.Name, "collection_id": in.ID}
+		node, err := c.summarizer.BuildCollection(ctx, base, in.Summaries)
+		if err != nil {
+			return err
+		}
+		if vecs, err = c.embedder.EmbedDocuments(ctx, []string{node.PageContent}); err != nil {
+			return fmt.Errorf("embed: %w", err)
+		}
+		nodes = []schema.Document{node}
+	}
+
+	if err := c.store.DeleteByDocument(ctx, id); err != nil {
+		return fmt.Errorf("delete old summary: %w", err)
+	}
+	if len(nodes) > 0 {
+		if err := c.store.AddEmbedded(ctx, nodes, vecs); err != nil {
+			return fmt.Errorf("vector store add: %w", err)
+		}
+	}
+	err := c.repo.SetSummaryDigest(ctx, in.ID, in.Digest)
+	if errors.Is(err, collection.ErrNotFound) {
+		// Deleted while it was being summarized.
+		return c.store.DeleteByDocument(ctx, id)
+	}
+	return err
+}
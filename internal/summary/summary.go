@@ -0,0 +1,152 @@
+// Package summary builds a hierarchical summary index over a document's
+// chunks, RAPTOR-style: consecutive chunks are summarized into section
+// nodes, and sections into a single document node. The summaries are
+// stored as extra vectors next to the chunks so broad questions ("summarize
+// our Q3 strategy") can match a high-level node instead of scattered
+// fragments. A collection's documents are summarized once more into a
+// collection node (see Collections).
+package summary
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Tree levels, stored in each vector's "level" metadata.
+const (
+	LevelChunk      = "chunk"
+	LevelSection    = "section"
+	LevelDocument   = "document"
+	LevelCollection = "collection"
+)
+
+// sectionSize is how many consecutive chunks one section summary covers.
+const sectionSize = 5
+
+// summaryTokens is about how long a summary comes back (150 words).
+const summaryTokens = 200
+
+const summarizePrompt = `You write dense, factual summaries for a search index.
+Summarize the passages below in at most 150 words. Keep names, numbers,
+dates and decisions; drop filler. Do not add information that is not present.`
+
+type Summarizer struct {
+	llm retrieval.LLMClient
+}
+
+func NewSummarizer(llmClient retrieval.LLMClient) *Summarizer {
+	return &Summarizer{llm: llmClient}
+}
+
+// Build returns the summary nodes for a document's chunks (in order). The
+// chunk metadata (org_id, document_id, ...) is copied onto every node.
+// Documents too small to benefit (a single section) get no document node.
+func (s *Summarizer) Build(ctx context.Context, chunks []schema.Document) ([]schema.Document, error) {
+	if len(chunks) < 2 {
+		return nil, nil
+	}
+	base := chunks[0].Metadata
+
+	var sections []schema.Document
+	for start := 0; start < len(chunks); start += sectionSize {
+		end := min(start+sectionSize, len(chunks))
+		texts := make([]string, 0, end-start)
+		for _, c := range chunks[start:end] {
+			texts = append(texts, c.PageContent)
+		}
+
+		text, err := s.summarize(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("summarize section %d: %w", start/sectionSize, err)
+		}
+		sections = append(sections, node(base, text, LevelSection, start, end-1))
+	}
+
+	nodes := sections
+	if len(sections) > 1 {
+		texts := make([]string, 0, len(sections))
+		for _, sec := range sections {
+			texts = append(texts, sec.PageContent)
+		}
+		text, err := s.summarize(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("summarize document: %w", err)
+		}
+		nodes = append(nodes, node(base, text, LevelDocument, 0, len(chunks)-1))
+	}
+	return nodes, nil
+}
+
+// BuildCollection returns the summary node of a collection whose
+// documents have these summaries: they are summarized sectionSize at a
+// time, then the results again, until one summary is left. base is the
+// node's metadata.
+func (s *Summarizer) BuildCollection(ctx context.Context, base map[string]any, summaries []string) (schema.Document, error) {
+	texts := summaries
+	for len(texts) > sectionSize {
+		var next []string
+		for start := 0; start < len(texts); start += sectionSize {
+			text, err := s.summarize(ctx, texts[start:min(start+sectionSize, len(texts))])
+			if err != nil {
+				return schema.Document{}, fmt.Errorf("summarize documents from %d: %w", start, err)
+			}
+			next = append(next, text)
+		}
+		texts = next
+	}
+	text, err := s.summarize(ctx, texts)
+	if err != nil {
+		return schema.Document{}, fmt.Errorf("summarize collection: %w", err)
+	}
+	return node(base, text, LevelCollection, 0, len(summaries)-1), nil
+}
+
+// Estimate predicts the LLM calls Build makes for chunks with these texts
+// and the prompt and completion tokens they count for. The completions are
+// also what gets embedded as summary nodes.
+func Estimate(texts []string) (calls int, prompt, completion int64) {
+	if len(texts) < 2 {
+		return 0, 0, 0
+	}
+	instructions := embedding.EstimateTokens(summarizePrompt)
+	sections := 0
+	for start := 0; start < len(texts); start += sectionSize {
+		prompt += instructions
+		for _, t := range texts[start:min(start+sectionSize, len(texts))] {
+			prompt += embedding.EstimateTokens(t) + 2 // separator
+		}
+		sections++
+	}
+	calls = sections
+	if sections > 1 {
+		prompt += instructions + int64(sections)*(summaryTokens+2)
+		calls++
+	}
+	return calls, prompt, int64(calls) * summaryTokens
+}
+
+func (s *Summarizer) summarize(ctx context.Context, passages []string) (string, error) {
+	out, err := retrieval.Complete(ctx, s.llm, summarizePrompt,
+		strings.Join(passages, "\n\n---\n\n"), llm.CompletionOptions{})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func node(base map[string]any, text, level string, firstChunk, lastChunk int) schema.Document {
+	md := make(map[string]any, len(base)+3)
+	for k, v := range base {
+		md[k] = v
+	}
+	md["level"] = level
+	md["chunk_start"] = firstChunk
+	md["chunk_end"] = lastChunk
+	return schema.Document{PageContent: text, Metadata: md}
+}
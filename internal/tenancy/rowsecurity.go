@@ -0,0 +1,90 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Row-level security
+//
+// Pooled orgs' rows are told apart by org_id alone, so a repository query
+// missing its org filter would read another tenant's rows. As a second
+// line of defence, documents, users and the embedding table carry
+// row-level security policies (migration 046) admitting only the rows of
+// the org in the app.current_org setting, plus documents granted to it.
+// The policies apply to TenantRole: while a shared connection is acquired
+// for a context with an org, it runs as that role with the org set. Work
+// without an org (logins, background jobs scanning every org) runs as the
+// server's own role, which owns the tables and so isn't subject to them.
+// Isolated orgs' pools don't take on the role (see Resolver.open): their
+// storage holds no other org.
+
+// TenantRole is the role requests for an org run as on the shared pool.
+const TenantRole = "app_tenant"
+
+// ErrRowSecurity is returned when the database isn't set up for
+// EnforceRowSecurity.
+var ErrRowSecurity = errors.New("row-level security not set up")
+
+// rowSecurityOrg is the CustomData key of the org a connection is set to.
+const rowSecurityOrg = "tenancy.org"
+
+// EnforceRowSecurity makes the connections of the pool cfg configures
+// take on TenantRole and the org when acquired for a context with an org,
+// and drop them otherwise. A connection is only reconfigured when the org
+// differs from its last one.
+func EnforceRowSecurity(cfg *pgxpool.Config) {
+	cfg.PrepareConn = func(ctx context.Context, conn *pgx.Conn) (bool, error) {
+		org := OrgFrom(ctx)
+		data := conn.PgConn().CustomData()
+		if current, ok := data[rowSecurityOrg]; ok && current == org {
+			return true, nil
+		}
+		role := TenantRole
+		if org == "" {
+			role = "none"
+		}
+		if _, err := conn.Exec(ctx,
+			`SELECT set_config('role', $1, false), set_config('app.current_org', $2, false)`, role, org); err != nil {
+			return false, fmt.Errorf("set tenant for row-level security: %w", err)
+		}
+		data[rowSecurityOrg] = org
+		return true, nil
+	}
+}
+
+// CheckRowSecurity verifies that db is set up for EnforceRowSecurity:
+// TenantRole exists and the server's role may switch to it, the policies
+// are in place, and the server's role sees every row itself.
+func CheckRowSecurity(ctx context.Context, db *pgxpool.Pool) error {
+	var exists bool
+	if err := db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)`, TenantRole).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: role %s doesn't exist (apply migration 046)", ErrRowSecurity, TenantRole)
+	}
+	var member, restricted bool
+	var policies int
+	if err := db.QueryRow(ctx,
+		`SELECT pg_has_role(current_user, $1, 'MEMBER'),
+		        row_security_active('documents') OR row_security_active('users'),
+		        (SELECT count(*) FROM pg_policies WHERE tablename IN ('documents', 'users') AND policyname = 'tenant_rows')`,
+		TenantRole).Scan(&member, &restricted, &policies); err != nil {
+		return err
+	}
+	switch {
+	case !member:
+		return fmt.Errorf("%w: the server's role can't act as %s (GRANT %[2]s TO it)", ErrRowSecurity, TenantRole)
+	case restricted:
+		return fmt.Errorf("%w: the server's role is subject to the policies itself; it must own documents and users", ErrRowSecurity)
+	case policies < 2:
+		return fmt.Errorf("%w: policies missing on documents or users (apply migration 046)", ErrRowSecurity)
+	}
+	return nil
+}
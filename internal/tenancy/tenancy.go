@@ -0,0 +1,579 @@
+// Package tenancy decides where an org's content lives. By default every
+// org shares the same tables (pooled mode, rows separated by org_id). For
+// high-compliance customers an org can instead be placed in its own
+// Postgres schema or its own database; the Resolver then routes that org's
+// queries to a dedicated connection pool.
+//
+// Routing is driven by the org carried in the request context (WithOrg):
+// repositories for content tables are built on the Resolver instead of the
+// shared pool, so the same SQL runs against whichever storage the org
+// uses. Identity and configuration (orgs, users, API keys, assistants,
+// prompt templates, public sites, grants) always stay in the shared tables.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+)
+
+// Mode is where an org's content is stored.
+type Mode string
+
+const (
+	ModePooled   Mode = "pooled"
+	ModeSchema   Mode = "schema"
+	ModeDatabase Mode = "database"
+)
+
+var (
+	ErrInvalid         = errors.New("invalid storage placement")
+	ErrOrgNotFound     = errors.New("organization not found")
+	ErrAlreadyIsolated = errors.New("organization already has isolated storage")
+	// ErrHasData is returned when isolating an org that already stored
+	// content in the shared tables; moving existing data isn't supported.
+	ErrHasData = errors.New("organization already has content in shared storage")
+	// ErrNotMigrated is returned for isolated storage missing migrations
+	// of this build; its org's queries are refused until it is migrated.
+	ErrNotMigrated = errors.New("isolated storage is missing migrations")
+)
+
+// ContentTables hold an org's content and move with it to isolated
+// storage. The vector tables are created on first use by the vector store.
+var ContentTables = []string{"documents", "ingest_jobs", "document_imports", "embedding_batches", "reindexes", "connectors", "connector_items", "conversations", "conversation_messages", "collections", "collection_documents", "document_summaries", "query_log"}
+
+// tenantPoolConns caps each isolated org's pool, since there is one per org.
+const tenantPoolConns = 4
+
+var (
+	identRe  = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	envVarRe = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+)
+
+// Placement records where one org's content lives.
+type Placement struct {
+	OrgID string `json:"org_id"`
+	Mode  Mode   `json:"mode"`
+	// Schema is the tenant schema in the shared database (ModeSchema).
+	Schema string `json:"schema,omitempty"`
+	// DatabaseEnv names the environment variable holding the connection
+	// URL of the org's database (ModeDatabase), keeping credentials out
+	// of the shared database.
+	DatabaseEnv string    `json:"database_env,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+}
+
+type ctxKey struct{}
+
+// WithOrg marks ctx as acting on orgID's data.
+func WithOrg(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, orgID)
+}
+
+// OrgFrom returns the org set by WithOrg, or "".
+func OrgFrom(ctx context.Context) string {
+	org, _ := ctx.Value(ctxKey{}).(string)
+	return org
+}
+
+type actorKey struct{}
+
+type workspaceKey struct{}
+
+// WithActor records who the work in ctx is done for (auth.Claims.Actor),
+// so metered usage can be attributed within the org.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor, or "" for work no one
+// asked for directly (ingestion, syncs, public sites).
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithWorkspace records the workspace within the org that the work in
+// ctx belongs to.
+func WithWorkspace(ctx context.Context, workspaceID string) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, workspaceID)
+}
+
+// WorkspaceFrom returns the workspace set by WithWorkspace, or "".
+func WorkspaceFrom(ctx context.Context) string {
+	ws, _ := ctx.Value(workspaceKey{}).(string)
+	return ws
+}
+
+// Resolver maps orgs to connection pools. It satisfies database.DBTX and
+// can back a database.UnitOfWork.
+type Resolver struct {
+	shared *pgxpool.Pool
+	tls    database.TLS
+	// migrations are applied to isolated storage; see MigrateWith.
+	migrations []database.Migration
+
+	mu         sync.Mutex
+	placements map[string]Placement
+	pools      map[string]*pgxpool.Pool
+}
+
+func NewResolver(shared *pgxpool.Pool) *Resolver {
+	return &Resolver{
+		shared:     shared,
+		placements: map[string]Placement{},
+		pools:      map[string]*pgxpool.Pool{},
+	}
+}
+
+// SecureWith applies t to the connection strings of orgs placed in their
+// own database. Orgs placed in a schema share the shared pool's settings.
+func (r *Resolver) SecureWith(t database.TLS) {
+	r.tls = t
+}
+
+// MigrateWith sets the migrations isolated storage must have. Provision
+// applies them to new storage and MigrateAll to existing storage; an org's
+// pool isn't opened while its storage lacks any.
+func (r *Resolver) MigrateWith(migrations []database.Migration) {
+	r.migrations = migrations
+}
+
+// Load reads the placements from tenant_placements.
+func (r *Resolver) Load(ctx context.Context) error {
+	rows, err := r.shared.Query(ctx,
+		`SELECT org_id, COALESCE(schema_name, ''), COALESCE(database_env, ''), created_at FROM tenant_placements`)
+	if err != nil {
+		return fmt.Errorf("load tenant placements: %w", err)
+	}
+	list, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Placement, error) {
+		var p Placement
+		err := row.Scan(&p.OrgID, &p.Schema, &p.DatabaseEnv, &p.CreatedAt)
+		p.Mode = ModeDatabase
+		if p.Schema != "" {
+			p.Mode = ModeSchema
+		}
+		return p, err
+	})
+	if err != nil {
+		return fmt.Errorf("load tenant placements: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	loaded := make(map[string]Placement, len(list))
+	for _, p := range list {
+		loaded[p.OrgID] = p
+	}
+	// Pools of orgs that are gone (deleted elsewhere) are closed.
+	for org, pool := range r.pools {
+		if _, ok := loaded[org]; !ok {
+			pool.Close()
+			delete(r.pools, org)
+		}
+	}
+	r.placements = loaded
+	return nil
+}
+
+// Watch reloads the placements every interval until ctx is cancelled, so
+// an org provisioned on one replica is routed by all of them.
+func (r *Resolver) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := r.Load(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("tenant placements reload failed", "error", err)
+		}
+	}
+}
+
+// Placement returns where an org's content lives.
+func (r *Resolver) Placement(orgID string) Placement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.placements[orgID]; ok {
+		return p
+	}
+	return Placement{OrgID: orgID, Mode: ModePooled}
+}
+
+// Isolated reports whether an org has its own schema or database.
+func (r *Resolver) Isolated(orgID string) bool {
+	return r.Placement(orgID).Mode != ModePooled
+}
+
+// Scoper lists the storage scopes background work has to visit. Resolver
+// implements it.
+type Scoper interface {
+	Scopes(ctx context.Context) []context.Context
+}
+
+// Scopes returns ctx for the shared tables followed by one context per
+// isolated org, for background jobs that scan content across orgs.
+func (r *Resolver) Scopes(ctx context.Context) []context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scopes := []context.Context{ctx}
+	for org := range r.placements {
+		scopes = append(scopes, WithOrg(ctx, org))
+	}
+	return scopes
+}
+
+// Pool returns the pool holding the content of ctx's org: the shared pool
+// for pooled orgs and for contexts without an org.
+func (r *Resolver) Pool(ctx context.Context) (*pgxpool.Pool, error) {
+	org := OrgFrom(ctx)
+	if org == "" {
+		return r.shared, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.placements[org]
+	if !ok {
+		return r.shared, nil
+	}
+	if pool, ok := r.pools[org]; ok {
+		return pool, nil
+	}
+	pool, err := r.open(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("open storage of org %s: %w", org, err)
+	}
+	r.pools[org] = pool
+	return pool, nil
+}
+
+// open creates the pool for an isolated placement, refusing storage that
+// lacks migrations.
+func (r *Resolver) open(ctx context.Context, p Placement) (*pgxpool.Pool, error) {
+	cfg, err := r.poolConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxConns = tenantPoolConns
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkMigrated(ctx, pool, p); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// poolConfig returns the connection settings of an isolated placement: the
+// shared database with the tenant schema first on the search_path, or the
+// org's database.
+func (r *Resolver) poolConfig(p Placement) (*pgxpool.Config, error) {
+	var cfg *pgxpool.Config
+	switch p.Mode {
+	case ModeSchema:
+		cfg = r.shared.Config().Copy()
+		// The copy carries the shared pool's row-security hook, which would
+		// switch connections to TenantRole; that role has no rights on the
+		// tenant schema, which Postgres then silently drops from the path.
+		cfg.PrepareConn = nil
+		// Every table exists in the schema (see migrate), so none resolves
+		// to public, which stays on the path for the pgvector type and
+		// operators only.
+		cfg.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{p.Schema}.Sanitize() + ", public"
+	case ModeDatabase:
+		url := os.Getenv(p.DatabaseEnv)
+		if url == "" {
+			return nil, fmt.Errorf("%s is not set", p.DatabaseEnv)
+		}
+		url, err := r.tls.Apply(url)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.DatabaseEnv, err)
+		}
+		if cfg, err = pgxpool.ParseConfig(url); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p.DatabaseEnv, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: mode %q", ErrInvalid, p.Mode)
+	}
+	return cfg, nil
+}
+
+// migrate applies the pending migrations to p's storage, on a connection of
+// its own. In a tenant schema they run with the schema first on the path,
+// so the schema gets every table and its own schema_migrations.
+func (r *Resolver) migrate(ctx context.Context, p Placement, logger *slog.Logger) ([]database.Migration, error) {
+	if len(r.migrations) == 0 {
+		return nil, errors.New("no migrations to apply to isolated storage")
+	}
+	cfg, err := r.poolConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.ConnectConfig(ctx, cfg.ConnConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+	return database.NewMigrator(conn, r.migrations, logger).Up(ctx, 0)
+}
+
+// checkMigrated returns ErrNotMigrated unless p's storage has every
+// migration, read from its own schema_migrations: a tenant schema behind
+// the build would otherwise read the tables it lacks from public.
+func (r *Resolver) checkMigrated(ctx context.Context, pool *pgxpool.Pool, p Placement) error {
+	if len(r.migrations) == 0 {
+		return nil
+	}
+	history := "public.schema_migrations"
+	if p.Mode == ModeSchema {
+		history = pgx.Identifier{p.Schema, "schema_migrations"}.Sanitize()
+	}
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, history).Scan(&exists); err != nil {
+		return err
+	}
+	applied := map[int]bool{}
+	if exists {
+		rows, err := pool.Query(ctx, `SELECT version FROM `+history)
+		if err != nil {
+			return err
+		}
+		versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			applied[v] = true
+		}
+	}
+	var pending []database.Migration
+	for _, mig := range r.migrations {
+		if !applied[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending, the first %s; run `server migrate`", ErrNotMigrated, len(pending), pending[0].Name)
+	}
+	return nil
+}
+
+// MigrateAll applies the pending migrations to every isolated org's
+// storage. Orgs that fail don't stop the others; their errors are
+// returned together.
+func (r *Resolver) MigrateAll(ctx context.Context, logger *slog.Logger) error {
+	r.mu.Lock()
+	placements := make([]Placement, 0, len(r.placements))
+	for _, p := range r.placements {
+		placements = append(placements, p)
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, p := range placements {
+		applied, err := r.migrate(ctx, p, logger.With("org_id", p.OrgID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("migrate storage of org %s: %w", p.OrgID, err))
+			continue
+		}
+		if len(applied) > 0 {
+			logger.Info("isolated storage migrated", "org_id", p.OrgID, "applied", len(applied))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// database.DBTX, routed by the org in ctx.
+
+func (r *Resolver) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	pool, err := r.Pool(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return pool.Exec(ctx, sql, args...)
+}
+
+func (r *Resolver) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	pool, err := r.Pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Query(ctx, sql, args...)
+}
+
+func (r *Resolver) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	pool, err := r.Pool(ctx)
+	if err != nil {
+		return errRow{err}
+	}
+	return pool.QueryRow(ctx, sql, args...)
+}
+
+// Begin starts a transaction on the org's storage.
+func (r *Resolver) Begin(ctx context.Context) (pgx.Tx, error) {
+	pool, err := r.Pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Begin(ctx)
+}
+
+type errRow struct{ err error }
+
+func (e errRow) Scan(...any) error { return e.err }
+
+// Provision moves a new org to isolated storage: a tenant schema, created
+// here, or the org's database. Either is brought up to date with every
+// migration and gets a row for the org before it is routed to. Orgs that
+// already stored content in the shared tables are refused.
+func (r *Resolver) Provision(ctx context.Context, orgID string, p Placement) (Placement, error) {
+	p.OrgID = orgID
+	switch p.Mode {
+	case ModeSchema:
+		p.Schema = "tenant_" + strings.ReplaceAll(strings.ToLower(orgID), "-", "_")
+		p.DatabaseEnv = ""
+		if !identRe.MatchString(p.Schema) {
+			return Placement{}, fmt.Errorf("%w: org id can't be used as a schema name", ErrInvalid)
+		}
+	case ModeDatabase:
+		p.Schema = ""
+		if !envVarRe.MatchString(p.DatabaseEnv) {
+			return Placement{}, fmt.Errorf("%w: database_env must name an environment variable", ErrInvalid)
+		}
+	default:
+		return Placement{}, fmt.Errorf("%w: mode must be %q or %q", ErrInvalid, ModeSchema, ModeDatabase)
+	}
+	if r.Isolated(orgID) {
+		return Placement{}, ErrAlreadyIsolated
+	}
+
+	var orgName string
+	var orgCreated time.Time
+	err := r.shared.QueryRow(ctx,
+		`SELECT name, created_at FROM organizations WHERE id = $1`, orgID).Scan(&orgName, &orgCreated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Placement{}, ErrOrgNotFound
+	}
+	if err != nil {
+		return Placement{}, err
+	}
+
+	var hasData bool
+	if err := r.shared.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM documents WHERE org_id = $1)
+		     OR EXISTS (SELECT 1 FROM connectors WHERE org_id = $1)
+		     OR EXISTS (SELECT 1 FROM conversations WHERE org_id = $1)`, orgID).Scan(&hasData); err != nil {
+		return Placement{}, err
+	}
+	if hasData {
+		return Placement{}, ErrHasData
+	}
+
+	if _, err := r.poolConfig(p); err != nil {
+		return Placement{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	// A tenant schema left by a failed attempt is taken up by the next.
+	if p.Mode == ModeSchema {
+		if _, err := r.shared.Exec(ctx, `CREATE SCHEMA IF NOT EXISTS `+pgx.Identifier{p.Schema}.Sanitize()); err != nil {
+			return Placement{}, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	if _, err := r.migrate(ctx, p, slog.Default().With("org_id", orgID)); err != nil {
+		return Placement{}, fmt.Errorf("migrate isolated storage: %w", err)
+	}
+	pool, err := r.open(ctx, p)
+	if err != nil {
+		return Placement{}, err
+	}
+	// Content rows reference organizations, so the org needs a row in its
+	// own storage too.
+	_, err = pool.Exec(ctx,
+		`INSERT INTO organizations (id, name, created_at) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`,
+		orgID, orgName, orgCreated)
+	pool.Close()
+	if err != nil {
+		return Placement{}, fmt.Errorf("register org in its storage: %w", err)
+	}
+
+	err = r.shared.QueryRow(ctx,
+		`INSERT INTO tenant_placements (org_id, schema_name, database_env)
+		 VALUES ($1, NULLIF($2, ''), NULLIF($3, ''))
+		 ON CONFLICT (org_id) DO NOTHING
+		 RETURNING created_at`,
+		orgID, p.Schema, p.DatabaseEnv).Scan(&p.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Placement{}, ErrAlreadyIsolated // provisioned concurrently, e.g. on another replica
+	}
+	if err != nil {
+		return Placement{}, err
+	}
+
+	r.mu.Lock()
+	r.placements[orgID] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+// Release deletes an org's isolated content as part of deleting the org,
+// inside the shared transaction tx: a tenant schema is dropped with tx;
+// in an org database the org row (and with it every content row) is
+// deleted directly, which can't be rolled back with tx. Pooled orgs are a
+// no-op.
+func (r *Resolver) Release(ctx context.Context, tx pgx.Tx, orgID string) error {
+	p := r.Placement(orgID)
+	switch p.Mode {
+	case ModeSchema:
+		if _, err := tx.Exec(ctx, `DROP SCHEMA IF EXISTS `+pgx.Identifier{p.Schema}.Sanitize()+` CASCADE`); err != nil {
+			return fmt.Errorf("drop tenant schema: %w", err)
+		}
+	case ModeDatabase:
+		pool, err := r.Pool(WithOrg(ctx, orgID))
+		if err != nil {
+			return err
+		}
+		if _, err := pool.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, orgID); err != nil {
+			return fmt.Errorf("delete org from its database: %w", err)
+		}
+	default:
+		return nil
+	}
+
+	// The placement row goes with the org (ON DELETE CASCADE); forget the
+	// pool now so nothing reconnects to dropped storage.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pool, ok := r.pools[orgID]; ok {
+		pool.Close()
+		delete(r.pools, orgID)
+	}
+	delete(r.placements, orgID)
+	return nil
+}
+
+// Close closes the isolated orgs' pools; the shared pool belongs to the
+// caller.
+func (r *Resolver) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for org, pool := range r.pools {
+		pool.Close()
+		delete(r.pools, org)
+	}
+}
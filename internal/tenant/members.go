@@ -0,0 +1,375 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+)
+
+// Member management
+// Admins invite users by email, change their roles and remove them; members
+// can only see who is in the org. Changes are serialized per org by locking
+// the org row, so two admins demoting each other can't leave the org
+// without one. A role change bumps the user's token version, so their
+// access token stops working and the next refresh carries the new role.
+
+// inviteTTL is how long an invitation token can be accepted.
+const inviteTTL = 7 * 24 * time.Hour
+
+// Mailer sends a plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// InviteDelivery configures how invitations reach the invitee.
+type InviteDelivery struct {
+	// Mailer, if set, emails every invitation; the token is then not
+	// shown to the inviting admin.
+	Mailer Mailer
+	// AcceptURL is the link in the email, with {token} standing for the
+	// invitation token, e.g. https://app.example.com/accept-invite?token={token}.
+	AcceptURL string
+}
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidRole   = errors.New("role must be admin or member")
+	ErrInvalidEmail  = errors.New("invalid email")
+	ErrAlreadyMember = errors.New("user is already a member of this organization")
+	// ErrEmailTaken is returned for an email that belongs to another org;
+	// emails are globally unique.
+	ErrEmailTaken = errors.New("email belongs to another organization")
+	// ErrLastAdmin is returned when a change would leave the org without an
+	// active admin.
+	ErrLastAdmin = errors.New("an organization needs at least one active admin")
+	// ErrSelfChange is returned when an admin targets their own membership;
+	// another admin has to do it.
+	ErrSelfChange = errors.New("you can't change your own role or remove yourself")
+	// ErrInvalidInvite covers unknown, expired and already used invitation
+	// tokens alike.
+	ErrInvalidInvite = errors.New("invalid or expired invitation")
+)
+
+// GetUser loads a user of orgID.
+func (r *Repository) GetUser(ctx context.Context, id, orgID string) (*User, error) {
+	u, err := scanUser(r.db.QueryRow(ctx,
+		`SELECT `+r.userColumns()+` FROM users WHERE id = $1 AND org_id = $2`, id, orgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+// DeleteUser removes a user; their refresh tokens and invitation go with
+// them via ON DELETE CASCADE.
+func (r *Repository) DeleteUser(ctx context.Context, id, orgID string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM users WHERE id = $1 AND org_id = $2`, id, orgID)
+	return err
+}
+
+// CountActiveAdmins counts orgID's active admins other than exceptID.
+func (r *Repository) CountActiveAdmins(ctx context.Context, orgID, exceptID string) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM users
+		 WHERE org_id = $1 AND id <> $2
+		   AND `+r.schema.Read(usersTable, "role")+` = $3
+		   AND `+r.schema.Read(usersTable, "status")+` = $4`,
+		orgID, exceptID, RoleAdmin, UserActive,
+	).Scan(&n)
+	return n, err
+}
+
+// SetPassword sets a user's password hash and status.
+func (r *Repository) SetPassword(ctx context.Context, id, hash string, status UserStatus) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE users SET `+r.schema.Assign(usersTable, "password_hash", "$1")+`, `+
+			r.schema.Assign(usersTable, "status", "$2")+` WHERE id = $3`,
+		hash, status, id,
+	)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every live refresh token of a user.
+func (r *Repository) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// SaveInvite stores a user's invitation token, replacing any earlier one.
+func (r *Repository) SaveInvite(ctx context.Context, userID, hash, invitedBy string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO user_invites (token_hash, user_id, invited_by, expires_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (user_id) DO UPDATE SET
+			 token_hash = EXCLUDED.token_hash, invited_by = EXCLUDED.invited_by,
+			 expires_at = EXCLUDED.expires_at, created_at = NOW()`,
+		hash, userID, invitedBy, expiresAt)
+	return err
+}
+
+// TakeInvite deletes an unexpired invitation and returns its user ID, so a
+// token works once.
+func (r *Repository) TakeInvite(ctx context.Context, hash string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(ctx,
+		`DELETE FROM user_invites WHERE token_hash = $1 AND expires_at > NOW() RETURNING user_id`, hash,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidInvite
+	}
+	return userID, err
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"` // defaults to member
+}
+
+// Invitation is a freshly issued invitation. Unless it was emailed, Token
+// is only shown here and the admin passes it on to the invitee.
+type Invitation struct {
+	User      *User     `json:"user"`
+	Token     string    `json:"invite_token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Emailed   bool      `json:"emailed"`
+}
+
+// Invite adds an invited user to orgID, or issues a new token to one who
+// hasn't accepted yet.
+func (s *Service) Invite(ctx context.Context, orgID, actor string, req InviteRequest) (*Invitation, error) {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, ErrInvalidEmail
+	}
+	if req.Role == "" {
+		req.Role = RoleMember
+	}
+	if !validRole(req.Role) {
+		return nil, ErrInvalidRole
+	}
+	token, hash, err := auth.NewInviteToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		inv = &Invitation{Token: token, ExpiresAt: time.Now().Add(inviteTTL)}
+		org *Organization
+	)
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		var err error
+		if org, err = repo.GetOrgForUpdate(ctx, orgID); err != nil {
+			return err
+		}
+
+		user, err := repo.FindUserByEmail(ctx, email)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			user = &User{
+				ID:        uuid.NewString(),
+				OrgID:     orgID,
+				Email:     email,
+				Role:      req.Role,
+				Status:    UserInvited,
+				CreatedAt: time.Now(),
+			}
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case user.OrgID != orgID:
+			return ErrEmailTaken
+		case user.Status != UserInvited:
+			return ErrAlreadyMember
+		case user.Role != req.Role:
+			user.Role = req.Role
+			if err := repo.UpdateUserRoleAndStatus(ctx, user.ID, orgID, user.Role, user.Status); err != nil {
+				return err
+			}
+		}
+		inv.User = user
+		return repo.SaveInvite(ctx, user.ID, hash, actor, inv.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.invites.Mailer != nil {
+		// A failed email leaves the invitation valid; the admin gets the
+		// token to pass on instead.
+		if err := s.invites.Mailer.Send(ctx, email, "You're invited to "+org.Name, s.inviteEmail(org, inv)); err != nil {
+			slog.Warn("invitation email failed", "org_id", orgID, "user_id", inv.User.ID, "error", err)
+		} else {
+			inv.Token, inv.Emailed = "", true
+		}
+	}
+	return inv, nil
+}
+
+func (s *Service) inviteEmail(org *Organization, inv *Invitation) string {
+	link := strings.ReplaceAll(s.invites.AcceptURL, "{token}", url.QueryEscape(inv.Token))
+	return fmt.Sprintf(`You have been invited to join %s as %s.
+
+Accept the invitation and choose a password here:
+
+%s
+
+The link works once and expires on %s.
+`, org.Name, inv.User.Role, link, inv.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"))
+}
+
+// NotifyAdmins emails every active admin of orgID. Without a mailer it
+// does nothing.
+func (s *Service) NotifyAdmins(ctx context.Context, orgID, subject, body string) error {
+	if s.invites.Mailer == nil {
+		return nil
+	}
+	users, err := s.repo.ListUsersByOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range users {
+		if u.Role != RoleAdmin || u.Status != UserActive {
+			continue
+		}
+		if err := s.invites.Mailer.Send(ctx, u.Email, subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.Email, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type AcceptInviteRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// AcceptInvite sets the invitee's password, activates them and logs them
+// in.
+func (s *Service) AcceptInvite(ctx context.Context, req AcceptInviteRequest) (*AuthResponse, error) {
+	if req.Token == "" || req.Password == "" {
+		return nil, errors.New("token and password required")
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *AuthResponse
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		userID, err := repo.TakeInvite(ctx, auth.HashInviteToken(req.Token))
+		if err != nil {
+			return err
+		}
+		user, err := repo.FindUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// Deactivated since the invitation was sent.
+		if user.Status != UserInvited {
+			return ErrInvalidInvite
+		}
+		if err := s.allowPassword(ctx, repo, user.OrgID); err != nil {
+			return err
+		}
+		if err := repo.SetPassword(ctx, user.ID, hash, UserActive); err != nil {
+			return err
+		}
+		user.Status = UserActive
+		resp, err = s.issueTokens(ctx, repo, user, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SetRole changes a user's role. actorID can't change their own, and the
+// last active admin can't be demoted.
+func (s *Service) SetRole(ctx context.Context, orgID, actorID, userID, role string) (*User, error) {
+	if !validRole(role) {
+		return nil, ErrInvalidRole
+	}
+	if userID == actorID {
+		return nil, ErrSelfChange
+	}
+
+	var user *User
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if _, err := repo.GetOrgForUpdate(ctx, orgID); err != nil {
+			return err
+		}
+		var err error
+		user, err = repo.GetUser(ctx, userID, orgID)
+		if err != nil {
+			return err
+		}
+		if user.Role == role {
+			return nil
+		}
+		if err := s.keepAnAdmin(ctx, repo, user); err != nil {
+			return err
+		}
+		user.Role = role
+		if err := repo.UpdateUserRoleAndStatus(ctx, user.ID, orgID, role, user.Status); err != nil {
+			return err
+		}
+		return repo.BumpTokenVersion(ctx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RemoveUser deletes a user from orgID. actorID can't remove themselves,
+// and the last active admin can't be removed.
+func (s *Service) RemoveUser(ctx context.Context, orgID, actorID, userID string) error {
+	if userID == actorID {
+		return ErrSelfChange
+	}
+	return s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if _, err := repo.GetOrgForUpdate(ctx, orgID); err != nil {
+			return err
+		}
+		user, err := repo.GetUser(ctx, userID, orgID)
+		if err != nil {
+			return err
+		}
+		if err := s.keepAnAdmin(ctx, repo, user); err != nil {
+			return err
+		}
+		return repo.DeleteUser(ctx, user.ID, orgID)
+	})
+}
+
+// keepAnAdmin returns ErrLastAdmin if user is the org's only active admin.
+func (s *Service) keepAnAdmin(ctx context.Context, repo *Repository, user *User) error {
+	if user.Role != RoleAdmin || user.Status != UserActive {
+		return nil
+	}
+	n, err := repo.CountActiveAdmins(ctx, user.OrgID, user.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+)
+
+// Passwords and sessions
+// A forgotten password is reset with a one-time token emailed to the user.
+// Changing or resetting a password bumps the user's token version, which
+// every access token carries, so tokens issued before stop working at once,
+// and revokes their refresh tokens, ending every other session.
+
+// resetTTL is how long a password reset token can be used.
+const resetTTL = time.Hour
+
+var (
+	// ErrResetDisabled is returned for a password reset when no mailer is
+	// configured to deliver the token.
+	ErrResetDisabled = errors.New("password reset is not enabled")
+	// ErrInvalidReset covers unknown, expired and already used reset
+	// tokens alike.
+	ErrInvalidReset = errors.New("invalid or expired reset token")
+	// ErrWrongPassword is returned when the current password given to a
+	// password change doesn't match.
+	ErrWrongPassword = errors.New("current password is incorrect")
+	// ErrTokenRevoked is returned for an access token issued before the
+	// user's token version was bumped, or whose user is gone or inactive.
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// BumpTokenVersion invalidates every access token issued to a user.
+func (r *Repository) BumpTokenVersion(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE users SET `+r.schema.Assign(usersTable, "token_version", r.schema.Read(usersTable, "token_version")+" + 1")+
+			` WHERE id = $1`, id)
+	return err
+}
+
+// TokenState returns what an access token of a user is checked against.
+func (r *Repository) TokenState(ctx context.Context, id string) (version int, status UserStatus, suspended bool, err error) {
+	err = r.db.QueryRow(ctx,
+		`SELECT `+r.schema.Columns(usersTable, "token_version", "status")+`,
+		        EXISTS (SELECT 1 FROM organizations o WHERE o.id = users.org_id AND o.suspended_at IS NOT NULL)
+		 FROM users WHERE id = $1`, id,
+	).Scan(&version, &status, &suspended)
+	return version, status, suspended, err
+}
+
+// SaveReset stores a user's reset token, replacing any earlier one.
+func (r *Repository) SaveReset(ctx context.Context, userID, hash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id) DO UPDATE SET
+			 token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, created_at = NOW()`,
+		hash, userID, expiresAt)
+	return err
+}
+
+// TakeReset deletes an unexpired reset token and returns its user ID, so a
+// token works once.
+func (r *Repository) TakeReset(ctx context.Context, hash string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(ctx,
+		`DELETE FROM password_resets WHERE token_hash = $1 AND expires_at > NOW() RETURNING user_id`, hash,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidReset
+	}
+	return userID, err
+}
+
+// CheckToken verifies that an access token's user is still active and the
+// token wasn't issued before its user's token version moved on, and that
+// the user's org isn't suspended. Super-admins, and those impersonating
+// for support, may still act in a suspended org; an impersonation token
+// ends when its super-admin loses the role.
+func (s *Service) CheckToken(ctx context.Context, claims *auth.Claims) error {
+	version, status, suspended, err := s.repo.TokenState(ctx, claims.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if status != UserActive || version != claims.Version {
+		return ErrTokenRevoked
+	}
+	if claims.Impersonator != "" {
+		ok, err := s.repo.IsSuperAdmin(ctx, claims.Impersonator)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrTokenRevoked
+		}
+		return nil
+	}
+	if suspended && !claims.SuperAdmin {
+		return ErrOrgSuspended
+	}
+	return nil
+}
+
+// SendResetsTo enables password resets, emailed through the invitation
+// mailer with a link to resetURL, where {token} stands for the reset
+// token.
+func (s *Service) SendResetsTo(resetURL string) {
+	s.resetURL = resetURL
+}
+
+// ForgotPassword emails an active user a password reset link. Unknown and
+// inactive emails, and users of orgs enforcing SSO, are silently ignored,
+// and a failed email is only logged, so the endpoint doesn't reveal who
+// has an account.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	if s.invites.Mailer == nil || s.resetURL == "" {
+		return ErrResetDisabled
+	}
+	user, err := s.repo.FindUserByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Status != UserActive {
+		return nil
+	}
+	if enforced, err := s.repo.SSOEnforced(ctx, user.OrgID); err != nil || enforced {
+		return err
+	}
+
+	token, hash, err := auth.NewOneTimeToken(auth.ResetTokenPrefix)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(resetTTL)
+	if err := s.repo.SaveReset(ctx, user.ID, hash, expiresAt); err != nil {
+		return err
+	}
+
+	link := strings.ReplaceAll(s.resetURL, "{token}", url.QueryEscape(token))
+	body := fmt.Sprintf(`Someone asked to reset the password of your account (%s).
+
+Choose a new password here:
+
+%s
+
+The link works once and expires in an hour. If it wasn't you, ignore this
+email; your password stays as it is.
+`, user.Email, link)
+	if err := s.invites.Mailer.Send(ctx, user.Email, "Reset your password", body); err != nil {
+		slog.Warn("password reset email failed", "user_id", user.ID, "error", err)
+	}
+	return nil
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ResetPassword sets a new password with a reset token and signs the user
+// out everywhere.
+func (s *Service) ResetPassword(ctx context.Context, req ResetPasswordRequest) error {
+	if req.Token == "" || req.Password == "" {
+		return errors.New("token and password required")
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	return s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		userID, err := repo.TakeReset(ctx, auth.HashOneTimeToken(req.Token))
+		if err != nil {
+			return err
+		}
+		user, err := repo.FindUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// Deactivated since the reset was requested.
+		if user.Status != UserActive {
+			return ErrInvalidReset
+		}
+		if err := s.allowPassword(ctx, repo, user.OrgID); err != nil {
+			return err
+		}
+		return s.replacePassword(ctx, repo, user, hash)
+	})
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword replaces a signed-in user's password, signs them out
+// everywhere else and returns a fresh token pair for this session.
+func (s *Service) ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) (*AuthResponse, error) {
+	if req.NewPassword == "" {
+		return nil, errors.New("new_password required")
+	}
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *AuthResponse
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		user, err := repo.FindUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// A bcrypt hash can't be verified in FIPS mode; those users reset
+		// their password instead.
+		if err := auth.CheckPassword(user.PasswordHash, req.CurrentPassword); err != nil {
+			return ErrWrongPassword
+		}
+		if err := s.allowPassword(ctx, repo, user.OrgID); err != nil {
+			return err
+		}
+		if err := s.replacePassword(ctx, repo, user, hash); err != nil {
+			return err
+		}
+		user.TokenVersion++
+		resp, err = s.issueTokens(ctx, repo, user, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// replacePassword stores a new password hash, invalidates the user's
+// access tokens and revokes their refresh tokens.
+func (s *Service) replacePassword(ctx context.Context, repo *Repository, user *User, hash string) error {
+	if err := repo.SetPassword(ctx, user.ID, hash, user.Status); err != nil {
+		return err
+	}
+	if err := repo.BumpTokenVersion(ctx, user.ID); err != nil {
+		return err
+	}
+	return repo.RevokeUserRefreshTokens(ctx, user.ID)
+}
@@ -0,0 +1,157 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/auth"
+)
+
+// Platform administration
+// Super-admins administer every org (internal/admin). The operator grants
+// the flag, which bumps the user's token version so it takes effect, or
+// ends, with their next token. For support they can impersonate a user:
+// a short-lived access token of that user, without a refresh token, that
+// names the super-admin who holds it.
+
+const (
+	// DefaultImpersonationTTL and MaxImpersonationTTL bound how long an
+	// impersonation token lasts.
+	DefaultImpersonationTTL = 15 * time.Minute
+	MaxImpersonationTTL     = time.Hour
+)
+
+// ErrNoImpersonationTarget is returned when impersonating an org without
+// naming a user, and the org has no active admin to default to.
+var ErrNoImpersonationTarget = errors.New("organization has no active admin to impersonate; give a user_id")
+
+// OrgSuspended reports whether orgID is suspended.
+func (r *Repository) OrgSuspended(ctx context.Context, orgID string) (bool, error) {
+	var suspended bool
+	err := r.db.QueryRow(ctx,
+		`SELECT suspended_at IS NOT NULL FROM organizations WHERE id = $1`, orgID,
+	).Scan(&suspended)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrOrgNotFound
+	}
+	return suspended, err
+}
+
+// IsSuperAdmin reports whether id is an active super-admin.
+func (r *Repository) IsSuperAdmin(ctx context.Context, id string) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(ctx,
+		`SELECT `+r.schema.Read(usersTable, "super_admin")+` AND `+r.schema.Read(usersTable, "status")+` = $2 FROM users WHERE id = $1`,
+		id, UserActive,
+	).Scan(&ok)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return ok, err
+}
+
+func (r *Repository) SetSuperAdmin(ctx context.Context, id string, on bool) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET `+r.schema.Assign(usersTable, "super_admin", "$2")+` WHERE id = $1`, id, on)
+	return err
+}
+
+func (r *Repository) ListSuperAdmins(ctx context.Context) ([]*User, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+r.userColumns()+` FROM users WHERE `+r.schema.Read(usersTable, "super_admin")+` ORDER BY `+r.schema.Read(usersTable, "created_at"))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*User, error) {
+		return scanUser(row)
+	})
+}
+
+// SetSuperAdmin grants or revokes a user's super-admin flag.
+func (s *Service) SetSuperAdmin(ctx context.Context, userID string, on bool) (*User, error) {
+	var user *User
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		var err error
+		user, err = repo.FindUserByID(ctx, userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if user.SuperAdmin == on {
+			return nil
+		}
+		user.SuperAdmin = on
+		if err := repo.SetSuperAdmin(ctx, user.ID, on); err != nil {
+			return err
+		}
+		return repo.BumpTokenVersion(ctx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) ListSuperAdmins(ctx context.Context) ([]*User, error) {
+	return s.repo.ListSuperAdmins(ctx)
+}
+
+// Impersonation is an access token issued to a super-admin to act as a
+// user.
+type Impersonation struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      *User     `json:"user"`
+}
+
+// Impersonate issues impersonatorID an access token of userID in orgID,
+// or of the org's longest-standing active admin when userID is empty,
+// valid for ttl (DefaultImpersonationTTL when 0, at most
+// MaxImpersonationTTL). The token never carries super-admin rights.
+func (s *Service) Impersonate(ctx context.Context, orgID, userID, impersonatorID string, ttl time.Duration) (*Impersonation, error) {
+	if ttl <= 0 {
+		ttl = DefaultImpersonationTTL
+	}
+	ttl = min(ttl, MaxImpersonationTTL)
+
+	var user *User
+	if userID != "" {
+		var err error
+		if user, err = s.repo.GetUser(ctx, userID, orgID); err != nil {
+			return nil, err
+		}
+		if user.Status != UserActive {
+			return nil, ErrUserDeactivated
+		}
+	} else {
+		users, err := s.repo.ListUsersByOrg(ctx, orgID)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			if u.Role == RoleAdmin && u.Status == UserActive {
+				user = u
+				break
+			}
+		}
+		if user == nil {
+			return nil, ErrNoImpersonationTarget
+		}
+	}
+
+	token, expiresAt, err := s.jwt.Issue(auth.Claims{
+		OrgID:        user.OrgID,
+		UserID:       user.ID,
+		Role:         user.Role,
+		Version:      user.TokenVersion,
+		Impersonator: impersonatorID,
+	}, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Impersonation{Token: token, ExpiresAt: expiresAt, User: user}, nil
+}
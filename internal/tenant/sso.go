@@ -0,0 +1,99 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Single sign-on
+// Users of an org with an identity provider sign in through it (see
+// package oidc). The first login creates the user with the provider's
+// default role and no password, or activates a pending invitation. An org
+// that enforces SSO refuses password logins, invitations accepted with a
+// password and password resets.
+
+var (
+	// ErrSSORequired is returned for a password login to an org that
+	// enforces single sign-on.
+	ErrSSORequired = errors.New("this organization signs in with single sign-on")
+	// ErrUserDeactivated is returned for an SSO login of a deactivated
+	// user; the IdP vouching for them doesn't bring them back.
+	ErrUserDeactivated = errors.New("user is deactivated")
+)
+
+// SSOEnforced reports whether orgID has turned off password logins.
+func (r *Repository) SSOEnforced(ctx context.Context, orgID string) (bool, error) {
+	var enforced bool
+	err := r.db.QueryRow(ctx, `SELECT enforced FROM sso_providers WHERE org_id = $1`, orgID).Scan(&enforced)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return enforced, err
+}
+
+// allowPassword returns ErrSSORequired if orgID enforces single sign-on.
+func (s *Service) allowPassword(ctx context.Context, repo *Repository, orgID string) error {
+	enforced, err := repo.SSOEnforced(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if enforced {
+		return ErrSSORequired
+	}
+	return nil
+}
+
+// LoginSSO signs in a user of orgID whose email its identity provider
+// vouched for, creating them with role on their first login.
+func (s *Service) LoginSSO(ctx context.Context, orgID, email, role string) (*AuthResponse, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !validRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	var resp *AuthResponse
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		// Serializes with member changes, like an invitation.
+		if _, err := repo.GetOrgForUpdate(ctx, orgID); err != nil {
+			return err
+		}
+		user, err := repo.FindUserByEmail(ctx, email)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			user = &User{
+				ID:        uuid.NewString(),
+				OrgID:     orgID,
+				Email:     email,
+				Role:      role,
+				Status:    UserActive,
+				CreatedAt: time.Now(),
+			}
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case user.OrgID != orgID:
+			return ErrEmailTaken
+		case user.Status == UserDeactivated:
+			return ErrUserDeactivated
+		case user.Status == UserInvited:
+			user.Status = UserActive
+			if err := repo.UpdateUserRoleAndStatus(ctx, user.ID, orgID, user.Role, user.Status); err != nil {
+				return err
+			}
+		}
+		resp, err = s.issueTokens(ctx, repo, user, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
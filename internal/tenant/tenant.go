@@ -3,12 +3,18 @@ package tenant
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pixell07/multi-tenant-ai/internal/auth"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/retrieval"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
 )
 
 type Organization struct {
@@ -17,21 +23,67 @@ type Organization struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Roles a user can hold within an org.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type UserStatus string
+
+const (
+	UserActive      UserStatus = "active"
+	UserInvited     UserStatus = "invited"
+	UserDeactivated UserStatus = "deactivated"
+)
+
 type User struct {
-	ID           string    `json:"id"`
-	OrgID        string    `json:"org_id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string     `json:"id"`
+	OrgID        string     `json:"org_id"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"-"`
+	Role         string     `json:"role"`
+	Status       UserStatus `json:"status"`
+	CreatedAt    time.Time  `json:"created_at"`
+	// TokenVersion must match an access token's version claim.
+	TokenVersion int `json:"-"`
+	// SuperAdmin is granted by the operator; see SetSuperAdmin.
+	SuperAdmin bool `json:"super_admin,omitempty"`
+}
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember
 }
 
 type Repository struct {
-	db *pgxpool.Pool
+	db database.DBTX
+	// schema routes non-key users columns through any expand/contract
+	// transition in progress.
+	schema *database.Schema
+}
+
+func NewRepository(db *pgxpool.Pool, schema *database.Schema) *Repository {
+	return &Repository{db: db, schema: schema}
+}
+
+// WithTx returns a copy of the repository bound to tx.
+func (r *Repository) WithTx(tx pgx.Tx) *Repository {
+	return &Repository{db: tx, schema: r.schema}
+}
+
+const usersTable = "users"
+
+// userColumns is the SELECT list scanUser expects.
+func (r *Repository) userColumns() string {
+	return r.schema.Columns(usersTable, "id", "org_id", "email", "password_hash", "role", "status", "created_at", "token_version", "super_admin")
 }
 
-func NewRepository(db *pgxpool.Pool) *Repository {
-	return &Repository{db: db}
+func scanUser(row pgx.Row) (*User, error) {
+	u := &User{}
+	if err := row.Scan(&u.ID, &u.OrgID, &u.Email, &u.PasswordHash, &u.Role, &u.Status, &u.CreatedAt, &u.TokenVersion, &u.SuperAdmin); err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
 func (r *Repository) CreateOrg(ctx context.Context, name string) (*Organization, error) {
@@ -47,35 +99,145 @@ func (r *Repository) CreateOrg(ctx context.Context, name string) (*Organization,
 	return org, err
 }
 
+// GetOrgForUpdate loads an org and locks its row for the rest of the tx.
+func (r *Repository) GetOrgForUpdate(ctx context.Context, id string) (*Organization, error) {
+	org := &Organization{}
+	err := r.db.QueryRow(ctx,
+		`SELECT id, name, created_at FROM organizations WHERE id = $1 FOR UPDATE`, id,
+	).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrgNotFound
+	}
+	return org, err
+}
+
+// DeleteOrg deletes an org; users, documents, keys and every other
+// org-owned row go with it via ON DELETE CASCADE.
+func (r *Repository) DeleteOrg(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, id)
+	return err
+}
+
 func (r *Repository) CreateUser(ctx context.Context, u *User) error {
+	cols, values := r.schema.Insert(usersTable, "id", "org_id", "email", "password_hash", "role", "status", "created_at")
 	_, err := r.db.Exec(ctx,
-		`INSERT INTO users (id, org_id, email, password_hash, role, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6)`,
-		u.ID, u.OrgID, u.Email, u.PasswordHash, u.Role, u.CreatedAt,
+		`INSERT INTO users (`+cols+`) VALUES (`+values+`)`,
+		u.ID, u.OrgID, u.Email, u.PasswordHash, u.Role, u.Status, u.CreatedAt,
 	)
+	// Row-level security hides other orgs' users from a request's lookup;
+	// the unique email still can't be taken.
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+		return ErrEmailTaken
+	}
 	return err
 }
 
 func (r *Repository) FindUserByEmail(ctx context.Context, email string) (*User, error) {
-	u := &User{}
-	err := r.db.QueryRow(ctx,
-		`SELECT id, org_id, email, password_hash, role, created_at
-		 FROM users WHERE email = $1`,
+	return scanUser(r.db.QueryRow(ctx,
+		`SELECT `+r.userColumns()+` FROM users WHERE `+r.schema.Read(usersTable, "email")+` = $1`,
 		email,
-	).Scan(&u.ID, &u.OrgID, &u.Email, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	))
+}
+
+func (r *Repository) ListUsersByOrg(ctx context.Context, orgID string) ([]*User, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT `+r.userColumns()+` FROM users WHERE org_id = $1 ORDER BY `+r.schema.Read(usersTable, "created_at"),
+		orgID,
+	)
 	if err != nil {
 		return nil, err
 	}
-	return u, nil
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*User, error) {
+		return scanUser(row)
+	})
+}
+
+func (r *Repository) UpdateUserRoleAndStatus(ctx context.Context, id, orgID, role string, status UserStatus) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE users SET `+r.schema.Assign(usersTable, "role", "$1")+`, `+
+			r.schema.Assign(usersTable, "status", "$2")+` WHERE id = $3 AND org_id = $4`,
+		role, status, id, orgID,
+	)
+	return err
+}
+
+// Refresh token storage
+
+// storedRefreshToken is a refresh_tokens row.
+type storedRefreshToken struct {
+	ID         string
+	UserID     string
+	FamilyID   string
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	ReplacedBy *string
+}
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, id, userID, familyID string, t *auth.RefreshToken) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, userID, familyID, t.Hash, t.ExpiresAt, time.Now(),
+	)
+	return err
+}
+
+// GetRefreshTokenForUpdate loads a refresh token by hash and locks it, so
+// two concurrent refreshes with the same token can't both rotate it.
+func (r *Repository) GetRefreshTokenForUpdate(ctx context.Context, hash string) (*storedRefreshToken, error) {
+	t := &storedRefreshToken{}
+	err := r.db.QueryRow(ctx,
+		`SELECT id, user_id, family_id, expires_at, revoked_at, replaced_by
+		 FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		hash,
+	).Scan(&t.ID, &t.UserID, &t.FamilyID, &t.ExpiresAt, &t.RevokedAt, &t.ReplacedBy)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MarkRefreshTokenRotated revokes a token and records its successor.
+func (r *Repository) MarkRefreshTokenRotated(ctx context.Context, id, replacedBy string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $2 WHERE id = $1`,
+		id, replacedBy,
+	)
+	return err
+}
+
+// RevokeRefreshFamily revokes every live token descended from one login.
+func (r *Repository) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`,
+		familyID,
+	)
+	return err
+}
+
+func (r *Repository) FindUserByID(ctx context.Context, id string) (*User, error) {
+	return scanUser(r.db.QueryRow(ctx,
+		`SELECT `+r.userColumns()+` FROM users WHERE id = $1`,
+		id,
+	))
 }
 
 type Service struct {
-	repo *Repository
-	jwt  *auth.JWTManager
+	repo    *Repository
+	uow     *database.UnitOfWork
+	jwt     *auth.JWTManager
+	vectors retrieval.VectorStore
+	tenants *tenancy.Resolver
+	invites InviteDelivery
+	// resetURL links password reset emails (see SendResetsTo).
+	resetURL string
 }
 
-func NewService(repo *Repository, jwt *auth.JWTManager) *Service {
-	return &Service{repo: repo, jwt: jwt}
+// NewService creates the service. Invitations are emailed through
+// invites.Mailer when it is set, and otherwise returned to the admin.
+func NewService(repo *Repository, uow *database.UnitOfWork, jwt *auth.JWTManager, vectors retrieval.VectorStore, tenants *tenancy.Resolver, invites InviteDelivery) *Service {
+	return &Service{repo: repo, uow: uow, jwt: jwt, vectors: vectors, tenants: tenants, invites: invites}
 }
 
 type RegisterRequest struct {
@@ -89,62 +251,393 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// AuthResponse carries a short-lived access JWT (Token) and a long-lived
+// refresh token that exchanges for a new pair at POST /api/v1/auth/refresh.
 type AuthResponse struct {
-	Token string        `json:"token"`
-	User  *User         `json:"user"`
-	Org   *Organization `json:"org"`
+	Token                 string        `json:"token"`
+	ExpiresAt             time.Time     `json:"expires_at"`
+	RefreshToken          string        `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time     `json:"refresh_token_expires_at"`
+	User                  *User         `json:"user"`
+	Org                   *Organization `json:"org,omitempty"`
+
+	refreshTokenID string
 }
 
-func (s *Service) Register(ctx context.Context, req RegisterRequest) (*AuthResponse, error) {
-	if req.Email == "" || req.Password == "" || req.OrgName == "" {
-		return nil, errors.New("all fields required")
-	}
+// ErrInvalidRefreshToken covers unknown, expired, revoked and reused
+// refresh tokens alike, so callers learn nothing about which it was.
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrOrgNotFound         = errors.New("organization not found")
+	ErrConfirmMismatch     = errors.New("confirm must match the organization name")
+	// ErrOrgSuspended is returned when the user's org was suspended by a
+	// platform administrator.
+	ErrOrgSuspended = errors.New("organization is suspended")
+)
 
-	org, err := s.repo.CreateOrg(ctx, req.OrgName)
+// issueTokens mints an access/refresh pair for user. The refresh token
+// joins familyID, or starts a new family when it is empty (a fresh login).
+func (s *Service) issueTokens(ctx context.Context, repo *Repository, user *User, familyID string) (*AuthResponse, error) {
+	// Super-admins keep their access to administer the platform.
+	if !user.SuperAdmin {
+		suspended, err := repo.OrgSuspended(ctx, user.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if suspended {
+			return nil, ErrOrgSuspended
+		}
+	}
+	token, expiresAt, err := s.jwt.Issue(auth.Claims{
+		OrgID:      user.OrgID,
+		UserID:     user.ID,
+		Role:       user.Role,
+		Version:    user.TokenVersion,
+		SuperAdmin: user.SuperAdmin,
+	}, 0)
 	if err != nil {
 		return nil, err
 	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	refresh, err := s.jwt.NewRefreshToken()
 	if err != nil {
 		return nil, err
 	}
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	refreshID := uuid.NewString()
+	if err := repo.CreateRefreshToken(ctx, refreshID, user.ID, familyID, refresh); err != nil {
+		return nil, err
+	}
+	return &AuthResponse{
+		refreshTokenID:        refreshID,
+		Token:                 token,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refresh.Token,
+		RefreshTokenExpiresAt: refresh.ExpiresAt,
+		User:                  user,
+	}, nil
+}
 
-	user := &User{
-		ID:           uuid.NewString(),
-		OrgID:        org.ID,
-		Email:        req.Email,
-		PasswordHash: string(hash),
-		Role:         "admin",
-		CreatedAt:    time.Now(),
+func (s *Service) Register(ctx context.Context, req RegisterRequest) (*AuthResponse, error) {
+	if req.Email == "" || req.Password == "" || req.OrgName == "" {
+		return nil, errors.New("all fields required")
 	}
-	if err := s.repo.CreateUser(ctx, user); err != nil {
+
+	// Hash outside the transaction so hashing doesn't hold a connection open.
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
 		return nil, err
 	}
 
-	token, err := s.jwt.Generate(org.ID, user.ID, user.Role)
+	// Org and admin user are created together: a failed user insert
+	// (e.g. duplicate email) must not leave an orphaned organization.
+	var (
+		org  *Organization
+		user *User
+	)
+	err = s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+
+		var err error
+		org, err = repo.CreateOrg(ctx, req.OrgName)
+		if err != nil {
+			return err
+		}
+
+		user = &User{
+			ID:           uuid.NewString(),
+			OrgID:        org.ID,
+			Email:        req.Email,
+			PasswordHash: hash,
+			Role:         RoleAdmin,
+			Status:       UserActive,
+			CreatedAt:    time.Now(),
+		}
+		return repo.CreateUser(ctx, user)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &AuthResponse{Token: token, User: user, Org: org}, nil
+	resp, err := s.issueTokens(ctx, s.repo, user, "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Org = org
+	return resp, nil
 }
 
-// Login authenticates a user and returns a JWT.
+// Login authenticates a user and returns an access/refresh token pair.
 func (s *Service) Login(ctx context.Context, req LoginRequest) (*AuthResponse, error) {
 	user, err := s.repo.FindUserByEmail(ctx, req.Email)
-	if err != nil {
+	if err != nil || user.Status != UserActive {
 		return nil, errors.New("invalid credentials")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	if err := auth.CheckPassword(user.PasswordHash, req.Password); err != nil {
+		if errors.Is(err, auth.ErrUnapprovedHash) {
+			slog.Warn("login refused: password hash not approved in FIPS mode", "user_id", user.ID)
+		}
 		return nil, errors.New("invalid credentials")
 	}
+	if err := s.allowPassword(ctx, s.repo, user.OrgID); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, s.repo, user, "")
+}
 
-	token, err := s.jwt.Generate(user.OrgID, user.ID, user.Role)
+// Refresh rotates a refresh token: the presented token is revoked and a
+// new access/refresh pair is issued in the same family. The access token
+// is built from the current user record, so role changes and
+// deactivations take effect on the next refresh. Presenting a token that
+// was already rotated revokes its whole family.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+	var (
+		resp   *AuthResponse
+		reused string
+	)
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+
+		stored, err := repo.GetRefreshTokenForUpdate(ctx, auth.HashRefreshToken(refreshToken))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		if stored.ReplacedBy != nil {
+			reused = stored.FamilyID
+			return ErrInvalidRefreshToken
+		}
+		if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		user, err := repo.FindUserByID(ctx, stored.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Status != UserActive {
+			return ErrInvalidRefreshToken
+		}
+
+		resp, err = s.issueTokens(ctx, repo, user, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+		return repo.MarkRefreshTokenRotated(ctx, stored.ID, resp.refreshTokenID)
+	})
+	if reused != "" {
+		// Outside the rolled-back transaction so the revocation sticks.
+		if rerr := s.repo.RevokeRefreshFamily(ctx, reused); rerr != nil {
+			return nil, rerr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	return resp, nil
+}
+
+// Logout revokes the refresh token's family, ending that login session on
+// every device it was rotated to. Unknown tokens are ignored.
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	return s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		stored, err := repo.GetRefreshTokenForUpdate(ctx, auth.HashRefreshToken(refreshToken))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return repo.RevokeRefreshFamily(ctx, stored.FamilyID)
+	})
+}
 
-	return &AuthResponse{Token: token, User: user}, nil
+func (s *Service) ListUsers(ctx context.Context, orgID string) ([]*User, error) {
+	return s.repo.ListUsersByOrg(ctx, orgID)
+}
+
+// DeleteOrg permanently deletes an org with all its data, including its
+// vectors, which live outside the cascading foreign keys. confirmName must
+// repeat the org's name as a guard against a mistaken call. Returns the
+// number of vectors purged. An org with isolated storage has its schema
+// or database content released as well.
+func (s *Service) DeleteOrg(ctx context.Context, orgID, confirmName string) (int64, error) {
+	var purged int64
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		org, err := repo.GetOrgForUpdate(ctx, orgID)
+		if err != nil {
+			return err
+		}
+		if confirmName != org.Name {
+			return ErrConfirmMismatch
+		}
+		if err := repo.DeleteOrg(ctx, orgID); err != nil {
+			return err
+		}
+		if !s.tenants.Isolated(orgID) {
+			purged, err = s.vectors.WithTx(tx).PurgeOrg(ctx, orgID)
+			return err
+		}
+		// The vectors live in the org's own storage, outside tx.
+		if purged, err = s.vectors.PurgeOrg(tenancy.WithOrg(ctx, orgID), orgID); err != nil {
+			return err
+		}
+		s.vectors.ForgetOrg(orgID)
+		return s.tenants.Release(ctx, tx, orgID)
+	})
+	return purged, err
+}
+
+// Membership sync
+// An org syncing from an HR system sends its full desired member list; we
+// reconcile against the users table in one transaction: unknown emails are
+// invited, roles are brought in line, and anyone absent is deactivated.
+
+type MemberSpec struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type SyncMembersRequest struct {
+	Members []MemberSpec `json:"members"`
+	DryRun  bool         `json:"dry_run"`
+}
+
+type SyncError struct {
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+type SyncMembersResult struct {
+	Invited     []string    `json:"invited"`
+	Updated     []string    `json:"updated"`
+	Reactivated []string    `json:"reactivated"`
+	Deactivated []string    `json:"deactivated"`
+	Unchanged   int         `json:"unchanged"`
+	Errors      []SyncError `json:"errors"`
+	DryRun      bool        `json:"dry_run"`
+}
+
+// SyncMembers reconciles the org's membership to req.Members. The calling
+// admin (actorID) is never deactivated or demoted so a bad payload can't
+// lock the org out. With DryRun the plan is computed and rolled back.
+func (s *Service) SyncMembers(ctx context.Context, orgID, actorID string, req SyncMembersRequest) (*SyncMembersResult, error) {
+	desired := make(map[string]string, len(req.Members))
+	res := &SyncMembersResult{DryRun: req.DryRun}
+	for _, m := range req.Members {
+		email := strings.ToLower(strings.TrimSpace(m.Email))
+		if email == "" || !strings.Contains(email, "@") {
+			res.Errors = append(res.Errors, SyncError{Email: m.Email, Error: "invalid email"})
+			continue
+		}
+		if m.Role == "" {
+			m.Role = RoleMember
+		}
+		if !validRole(m.Role) {
+			res.Errors = append(res.Errors, SyncError{Email: m.Email, Error: "invalid role"})
+			continue
+		}
+		desired[email] = m.Role
+	}
+
+	err := s.uow.Do(ctx, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+
+		existing, err := repo.ListUsersByOrg(ctx, orgID)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool, len(existing))
+		for _, u := range existing {
+			email := strings.ToLower(u.Email)
+			seen[email] = true
+			role, wanted := desired[email]
+
+			switch {
+			case !wanted && u.ID == actorID:
+				res.Unchanged++
+			case !wanted && u.Status != UserDeactivated:
+				if err := repo.UpdateUserRoleAndStatus(ctx, u.ID, orgID, u.Role, UserDeactivated); err != nil {
+					return err
+				}
+				res.Deactivated = append(res.Deactivated, u.Email)
+			case !wanted:
+				res.Unchanged++
+			case u.Status == UserDeactivated:
+				status := UserActive
+				if u.PasswordHash == "" {
+					status = UserInvited
+				}
+				if err := repo.UpdateUserRoleAndStatus(ctx, u.ID, orgID, role, status); err != nil {
+					return err
+				}
+				res.Reactivated = append(res.Reactivated, u.Email)
+			case u.Role != role && u.ID != actorID:
+				if err := repo.UpdateUserRoleAndStatus(ctx, u.ID, orgID, role, u.Status); err != nil {
+					return err
+				}
+				res.Updated = append(res.Updated, u.Email)
+			default:
+				res.Unchanged++
+			}
+		}
+
+		for email, role := range desired {
+			if seen[email] {
+				continue
+			}
+			// Emails are globally unique; one that belongs to another org
+			// can't be pulled in here.
+			if other, err := repo.FindUserByEmail(ctx, email); err == nil && other.OrgID != orgID {
+				res.Errors = append(res.Errors, SyncError{Email: email, Error: ErrEmailTaken.Error()})
+				continue
+			}
+			// Row-level security hides the other org's user from the
+			// lookup, leaving the insert to find the email taken; the
+			// savepoint keeps that from aborting the whole sync.
+			sp, err := tx.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			err = s.repo.WithTx(sp).CreateUser(ctx, &User{
+				ID:        uuid.NewString(),
+				OrgID:     orgID,
+				Email:     email,
+				Role:      role,
+				Status:    UserInvited,
+				CreatedAt: time.Now(),
+			})
+			if errors.Is(err, ErrEmailTaken) {
+				if err := sp.Rollback(ctx); err != nil {
+					return err
+				}
+				res.Errors = append(res.Errors, SyncError{Email: email, Error: ErrEmailTaken.Error()})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := sp.Commit(ctx); err != nil {
+				return err
+			}
+			res.Invited = append(res.Invited, email)
+		}
+
+		if req.DryRun {
+			return database.ErrRollback
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
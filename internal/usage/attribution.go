@@ -0,0 +1,106 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Attribution
+// Metered usage and answered queries are also counted per actor (the user
+// or API key a request was made with) and per workspace within the org,
+// taken from the request context (tenancy.WithActor, WithWorkspace), so
+// admins can see who is driving the org's cost. Work nobody asked for
+// directly, like queued ingestion or connector syncs, is unattributed.
+
+// Breakdown dimensions.
+const (
+	ByUser      = "user"
+	ByWorkspace = "workspace"
+)
+
+// ErrInvalidBreakdown is returned for an unknown breakdown dimension.
+var ErrInvalidBreakdown = errors.New(`by must be "user" or "workspace"`)
+
+// Attribution is the usage of one actor or workspace in a month.
+type Attribution struct {
+	// Actor is a user ID or "apikey:<id>"; empty for unattributed usage
+	// and in breakdowns by workspace.
+	Actor string `json:"actor,omitempty"`
+	// Email is the actor's address when it is a user of the org.
+	Email            string  `json:"email,omitempty"`
+	WorkspaceID      string  `json:"workspace_id,omitempty"`
+	EmbeddingTokens  int64   `json:"embedding_tokens"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Queries          int64   `json:"queries"`
+	SpendUSD         float64 `json:"spend_usd"`
+}
+
+// attribute adds usage to an actor and workspace for the period.
+func (r *Repository) attribute(ctx context.Context, orgID string, p time.Time, actor, workspaceID string, embedding, prompt, completion, queries int64, cost float64) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO usage_attribution
+			 (org_id, period, actor, workspace_id, embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT (org_id, period, actor, workspace_id) DO UPDATE SET
+			 embedding_tokens = usage_attribution.embedding_tokens + EXCLUDED.embedding_tokens,
+			 prompt_tokens = usage_attribution.prompt_tokens + EXCLUDED.prompt_tokens,
+			 completion_tokens = usage_attribution.completion_tokens + EXCLUDED.completion_tokens,
+			 queries = usage_attribution.queries + EXCLUDED.queries,
+			 spend_usd = usage_attribution.spend_usd + EXCLUDED.spend_usd,
+			 updated_at = NOW()`,
+		orgID, p, actor, workspaceID, embedding, prompt, completion, queries, cost)
+	return err
+}
+
+// breakdown sums an org's usage in the period by actor or workspace,
+// biggest spenders first.
+func (r *Repository) breakdown(ctx context.Context, orgID string, p time.Time, by string) ([]Attribution, error) {
+	key := "a.actor"
+	if by == ByWorkspace {
+		key = "a.workspace_id"
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT `+key+`, COALESCE(MAX(u.email), ''),
+			 SUM(a.embedding_tokens), SUM(a.prompt_tokens), SUM(a.completion_tokens),
+			 SUM(a.queries), SUM(a.spend_usd)
+		 FROM usage_attribution a
+		 LEFT JOIN (SELECT id, org_id, `+r.schema.Read("users", "email")+` AS email FROM users) u
+		   ON $3 = 'user' AND u.id = a.actor AND u.org_id = a.org_id
+		 WHERE a.org_id = $1 AND a.period = $2
+		 GROUP BY `+key+`
+		 ORDER BY SUM(a.spend_usd) DESC, `+key,
+		orgID, p, by)
+	if err != nil {
+		return nil, err
+	}
+	list := []Attribution{}
+	var a Attribution
+	var name string
+	_, err = pgx.ForEachRow(rows, []any{&name, &a.Email, &a.EmbeddingTokens, &a.PromptTokens, &a.CompletionTokens, &a.Queries, &a.SpendUSD}, func() error {
+		a.Actor, a.WorkspaceID = "", ""
+		if by == ByWorkspace {
+			a.WorkspaceID = name
+		} else {
+			a.Actor = name
+		}
+		list = append(list, a)
+		return nil
+	})
+	return list, err
+}
+
+// Breakdown returns an org's usage in the period split by user (ByUser)
+// or workspace (ByWorkspace). A zero period is the current month.
+func (s *Service) Breakdown(ctx context.Context, orgID string, p time.Time, by string) ([]Attribution, error) {
+	if by != ByUser && by != ByWorkspace {
+		return nil, ErrInvalidBreakdown
+	}
+	if p.IsZero() {
+		p = period(time.Now())
+	}
+	return s.repo.breakdown(ctx, orgID, p, by)
+}
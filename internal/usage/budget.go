@@ -0,0 +1,229 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Budgets
+// An org admin can cap what the org spends on models per month. Spend is
+// the metered tokens priced at the deployment's prices, counted from when
+// metering started pricing them; tokens of a model without a known price
+// cost nothing. Past the downgrade threshold completions switch to the
+// operator's fallback model, and once the cap is reached token-consuming
+// requests are refused like an exhausted quota. Admins are notified when
+// either line is crossed.
+
+// defaultDowngradeAt is the share of the cap after which completions use
+// the fallback model, unless the budget says otherwise.
+const defaultDowngradeAt = 0.8
+
+var (
+	// ErrInvalidBudget is returned for a non-positive cap or a downgrade
+	// threshold outside (0, 1].
+	ErrInvalidBudget = errors.New("monthly_cap_usd must be positive and downgrade_at between 0 and 1")
+	// ErrNoBudget is returned when an org without a budget deletes it.
+	ErrNoBudget = errors.New("no budget is set")
+)
+
+// Budget is an org's monthly spending cap.
+type Budget struct {
+	MonthlyCapUSD float64 `json:"monthly_cap_usd"`
+	// DowngradeAt is the share of the cap after which completions switch
+	// to the cheaper fallback model; 1 keeps the regular model up to the
+	// cap.
+	DowngradeAt float64   `json:"downgrade_at"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+}
+
+func (b *Budget) downgradeUSD() float64 {
+	return b.MonthlyCapUSD * b.DowngradeAt
+}
+
+// BudgetError reports that an org has spent its monthly budget. It
+// matches ErrQuotaExceeded.
+type BudgetError struct {
+	CapUSD   float64
+	SpentUSD float64
+	ResetAt  time.Time
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("monthly budget of $%.2f reached: $%.2f spent", e.CapUSD, e.SpentUSD)
+}
+
+func (e *BudgetError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// Notifier tells an org's admins about its spending.
+type Notifier interface {
+	NotifyAdmins(ctx context.Context, orgID, subject, body string) error
+}
+
+// Budget returns an org's budget, or nil if it has none.
+func (r *Repository) Budget(ctx context.Context, orgID string) (*Budget, error) {
+	b := &Budget{}
+	err := r.db.QueryRow(ctx,
+		`SELECT monthly_cap_usd, downgrade_at, updated_at FROM org_budgets WHERE org_id = $1`, orgID,
+	).Scan(&b.MonthlyCapUSD, &b.DowngradeAt, &b.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetBudget replaces an org's budget.
+func (r *Repository) SetBudget(ctx context.Context, orgID string, b *Budget) error {
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO org_budgets (org_id, monthly_cap_usd, downgrade_at)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (org_id) DO UPDATE SET
+			 monthly_cap_usd = EXCLUDED.monthly_cap_usd,
+			 downgrade_at = EXCLUDED.downgrade_at,
+			 updated_at = NOW()
+		 RETURNING updated_at`,
+		orgID, b.MonthlyCapUSD, b.DowngradeAt,
+	).Scan(&b.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		return ErrOrgNotFound
+	}
+	return err
+}
+
+func (r *Repository) DeleteBudget(ctx context.Context, orgID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM org_budgets WHERE org_id = $1`, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoBudget
+	}
+	return nil
+}
+
+// spend returns what an org has spent in the period.
+func (r *Repository) spend(ctx context.Context, orgID string, p time.Time) (float64, error) {
+	var usd float64
+	err := r.db.QueryRow(ctx,
+		`SELECT spend_usd FROM usage_counters WHERE org_id = $1 AND period = $2`, orgID, p,
+	).Scan(&usd)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return usd, err
+}
+
+// Budget returns an org's budget, or nil if it has none.
+func (s *Service) Budget(ctx context.Context, orgID string) (*Budget, error) {
+	return s.repo.Budget(ctx, orgID)
+}
+
+// SetBudget replaces an org's budget; a zero DowngradeAt takes the
+// default.
+func (s *Service) SetBudget(ctx context.Context, orgID string, b *Budget) error {
+	if b.DowngradeAt == 0 {
+		b.DowngradeAt = defaultDowngradeAt
+	}
+	if b.MonthlyCapUSD <= 0 || b.DowngradeAt < 0 || b.DowngradeAt > 1 {
+		return ErrInvalidBudget
+	}
+	return s.repo.SetBudget(ctx, orgID, b)
+}
+
+// DeleteBudget lifts an org's budget.
+func (s *Service) DeleteBudget(ctx context.Context, orgID string) error {
+	return s.repo.DeleteBudget(ctx, orgID)
+}
+
+// overBudget returns a *BudgetError if u has reached its budget.
+func overBudget(u *Usage) error {
+	if u.Budget == nil || u.SpendUSD < u.Budget.MonthlyCapUSD {
+		return nil
+	}
+	return &BudgetError{CapUSD: u.Budget.MonthlyCapUSD, SpentUSD: u.SpendUSD, ResetAt: u.Period.AddDate(0, 1, 0)}
+}
+
+// notifyTimeout bounds sending one budget notification.
+const notifyTimeout = time.Minute
+
+// NotifyThrough makes the meter tell org admins when spending crosses the
+// downgrade threshold or the cap of their budget. Notifications are sent
+// in the background.
+func (m *Meter) NotifyThrough(n Notifier) {
+	m.notifier = n
+}
+
+// downgrade returns the model a completion for orgID should use instead
+// of model, if the org is past its downgrade threshold.
+func (m *Meter) downgrade(ctx context.Context, orgID, model string) string {
+	if m.fallbackModel == "" || orgID == "" {
+		return model
+	}
+	b, err := m.repo.Budget(ctx, orgID)
+	if err != nil {
+		slog.Error("load budget failed", "org_id", orgID, "error", err)
+		return model
+	}
+	if b == nil || b.DowngradeAt >= 1 {
+		return model
+	}
+	spent, err := m.repo.spend(ctx, orgID, period(time.Now()))
+	if err != nil {
+		slog.Error("load spend failed", "org_id", orgID, "error", err)
+		return model
+	}
+	if spent < b.downgradeUSD() {
+		return model
+	}
+	return m.fallbackModel
+}
+
+// crossed notifies orgID's admins if spending cost took the org from
+// below a budget line to at or above it. Each increment is atomic, so
+// exactly one crosses a line each month.
+func (m *Meter) crossed(ctx context.Context, orgID string, spent, cost float64) {
+	if cost <= 0 {
+		return
+	}
+	b, err := m.repo.Budget(ctx, orgID)
+	if err != nil || b == nil {
+		if err != nil {
+			slog.Error("load budget failed", "org_id", orgID, "error", err)
+		}
+		return
+	}
+	before := spent - cost
+
+	var subject, body string
+	switch {
+	case before < b.MonthlyCapUSD && spent >= b.MonthlyCapUSD:
+		subject = "Monthly AI budget reached"
+		body = fmt.Sprintf("Your organization has spent $%.2f this month and reached its budget of $%.2f.\n\n"+
+			"Queries and uploads are refused until the budget is raised or the month ends.\n", spent, b.MonthlyCapUSD)
+	case m.fallbackModel != "" && b.DowngradeAt < 1 && before < b.downgradeUSD() && spent >= b.downgradeUSD():
+		subject = "Monthly AI budget almost used"
+		body = fmt.Sprintf("Your organization has spent $%.2f of its $%.2f monthly budget.\n\n"+
+			"Answers now use a cheaper model (%s) for the rest of the month.\n", spent, b.MonthlyCapUSD, m.fallbackModel)
+	default:
+		return
+	}
+	slog.Warn("budget line crossed", "org_id", orgID, "spend_usd", spent, "cap_usd", b.MonthlyCapUSD)
+	if m.notifier == nil {
+		return
+	}
+	// The request that crossed the line doesn't wait on the mail server.
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		defer cancel()
+		if err := m.notifier.NotifyAdmins(ctx, orgID, subject, body); err != nil {
+			slog.Error("budget notification failed", "org_id", orgID, "error", err)
+		}
+	}()
+}
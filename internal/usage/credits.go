@@ -0,0 +1,332 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Prepaid credits
+// The operator grants an org credits, optionally expiring; metered usage
+// then draws them down at the same prices as budgets, from the grant that
+// expires first. An org that holds or ever held a grant is prepaid: once
+// its balance is gone, token-consuming requests are refused like an
+// exhausted quota. Like quotas, the check runs before the work, so the
+// last request can cost more than was left; the ledger records what the
+// credits actually covered.
+
+// Metered operations, as recorded on consumption.
+const (
+	OpEmbedding      = "embedding"
+	OpBatchEmbedding = "batch_embedding"
+	OpCompletion     = "completion"
+)
+
+// Ledger transaction kinds.
+const (
+	TxGrant       = "grant"
+	TxConsumption = "consumption"
+	TxExpiry      = "expiry"
+)
+
+// maxTransactionsPage caps a page of the ledger.
+const maxTransactionsPage = 500
+
+var (
+	// ErrInvalidGrant is returned for a non-positive amount or an expiry in
+	// the past.
+	ErrInvalidGrant = errors.New("amount_usd must be positive and expires_at in the future")
+	// ErrCreditsExhausted matches every *CreditError.
+	ErrCreditsExhausted = errors.New("prepaid credits exhausted")
+)
+
+// CreditError reports that a prepaid org has no credits left. It matches
+// ErrQuotaExceeded and ErrCreditsExhausted.
+type CreditError struct{}
+
+func (e *CreditError) Error() string { return ErrCreditsExhausted.Error() }
+
+func (e *CreditError) Is(target error) bool {
+	return target == ErrQuotaExceeded || target == ErrCreditsExhausted
+}
+
+// Grant is credit given to an org.
+type Grant struct {
+	ID           string     `json:"id"`
+	OrgID        string     `json:"org_id"`
+	AmountUSD    float64    `json:"amount_usd"`
+	RemainingUSD float64    `json:"remaining_usd"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Note         string     `json:"note"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Transaction is one ledger entry; AmountUSD is negative for consumption
+// and expiry.
+type Transaction struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Operation string    `json:"operation,omitempty"`
+	AmountUSD float64   `json:"amount_usd"`
+	GrantID   string    `json:"grant_id,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Credits is a prepaid org's balance and the grants that make it up.
+type Credits struct {
+	BalanceUSD float64  `json:"balance_usd"`
+	Grants     []*Grant `json:"grants"`
+}
+
+func toMicros(usd float64) int64 { return int64(math.Round(usd * 1e6)) }
+
+func toUSD(micros int64) float64 { return float64(micros) / 1e6 }
+
+// expireDue zeroes the grants of orgID that expired with credit left and
+// records what they lost. Locking the grants makes concurrent calls
+// record each expiry once.
+func (r *Repository) expireDue(ctx context.Context, orgID string) error {
+	_, err := r.db.Exec(ctx,
+		`WITH due AS (
+		     SELECT id, remaining_micros FROM credit_grants
+		     WHERE org_id = $1 AND remaining_micros > 0 AND expires_at <= NOW()
+		     FOR UPDATE
+		 ), emptied AS (
+		     UPDATE credit_grants g SET remaining_micros = 0 FROM due WHERE g.id = due.id
+		 )
+		 INSERT INTO credit_transactions (org_id, kind, amount_micros, grant_id)
+		 SELECT $1, 'expiry', -remaining_micros, id FROM due`, orgID)
+	return err
+}
+
+// balance returns an org's unexpired credit and whether it is prepaid.
+func (r *Repository) balance(ctx context.Context, orgID string) (int64, bool, error) {
+	var (
+		micros  int64
+		prepaid bool
+	)
+	err := r.db.QueryRow(ctx,
+		`SELECT COALESCE(sum(remaining_micros) FILTER (WHERE expires_at IS NULL OR expires_at > NOW()), 0),
+		        count(*) > 0
+		 FROM credit_grants WHERE org_id = $1`, orgID,
+	).Scan(&micros, &prepaid)
+	return micros, prepaid, err
+}
+
+// AddGrant stores a grant and its ledger entry.
+func (r *Repository) AddGrant(ctx context.Context, g *Grant) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		amount := toMicros(g.AmountUSD)
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO credit_grants (id, org_id, amount_micros, remaining_micros, expires_at, note, created_at)
+			 VALUES ($1, $2, $3, $3, $4, $5, $6)`,
+			g.ID, g.OrgID, amount, g.ExpiresAt, g.Note, g.CreatedAt); err != nil {
+			if isForeignKeyViolation(err) {
+				return ErrOrgNotFound
+			}
+			return err
+		}
+		_, err := tx.Exec(ctx,
+			`INSERT INTO credit_transactions (org_id, kind, amount_micros, grant_id, note, created_at)
+			 VALUES ($1, 'grant', $2, $3, $4, $5)`,
+			g.OrgID, amount, g.ID, g.Note, g.CreatedAt)
+		return err
+	})
+}
+
+// OpenGrants returns an org's grants with credit left, in the order they
+// are drawn down.
+func (r *Repository) OpenGrants(ctx context.Context, orgID string) ([]*Grant, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT id, org_id, amount_micros, remaining_micros, expires_at, note, created_at
+		 FROM credit_grants
+		 WHERE org_id = $1 AND remaining_micros > 0 AND (expires_at IS NULL OR expires_at > NOW())
+		 ORDER BY expires_at NULLS LAST, created_at`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Grant, error) {
+		g := &Grant{}
+		var amount, remaining int64
+		if err := row.Scan(&g.ID, &g.OrgID, &amount, &remaining, &g.ExpiresAt, &g.Note, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		g.AmountUSD, g.RemainingUSD = toUSD(amount), toUSD(remaining)
+		return g, nil
+	})
+}
+
+// consume draws micros from orgID's grants, earliest expiry first, and
+// records the consumption of op. It does nothing for orgs without grants.
+func (r *Repository) consume(ctx context.Context, orgID, op string, micros int64) error {
+	var prepaid bool
+	if err := r.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM credit_grants WHERE org_id = $1)`, orgID).Scan(&prepaid); err != nil || !prepaid {
+		return err
+	}
+	if err := r.expireDue(ctx, orgID); err != nil {
+		return err
+	}
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx,
+			`SELECT id, remaining_micros FROM credit_grants
+			 WHERE org_id = $1 AND remaining_micros > 0 AND (expires_at IS NULL OR expires_at > NOW())
+			 ORDER BY expires_at NULLS LAST, created_at
+			 FOR UPDATE`, orgID)
+		if err != nil {
+			return err
+		}
+		type open struct {
+			id        string
+			remaining int64
+		}
+		var grants []open
+		var g open
+		if _, err := pgx.ForEachRow(rows, []any{&g.id, &g.remaining}, func() error {
+			grants = append(grants, g)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		left := micros
+		for _, g := range grants {
+			if left == 0 {
+				break
+			}
+			take := min(left, g.remaining)
+			if _, err := tx.Exec(ctx,
+				`UPDATE credit_grants SET remaining_micros = remaining_micros - $2 WHERE id = $1`, g.id, take); err != nil {
+				return err
+			}
+			left -= take
+		}
+		var note string
+		if left > 0 {
+			note = fmt.Sprintf("$%.6f not covered by credits", toUSD(left))
+		}
+		_, err = tx.Exec(ctx,
+			`INSERT INTO credit_transactions (org_id, kind, operation, amount_micros, note)
+			 VALUES ($1, 'consumption', $2, $3, $4)`,
+			orgID, op, -(micros - left), note)
+		return err
+	})
+}
+
+// Transactions returns a page of an org's ledger, newest first, and the
+// number of entries.
+func (r *Repository) Transactions(ctx context.Context, orgID string, limit, offset int) ([]*Transaction, int, error) {
+	var total int
+	if err := r.db.QueryRow(ctx,
+		`SELECT count(*) FROM credit_transactions WHERE org_id = $1`, orgID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT id, kind, operation, amount_micros, COALESCE(grant_id, ''), note, created_at
+		 FROM credit_transactions WHERE org_id = $1
+		 ORDER BY id DESC LIMIT $2 OFFSET $3`, orgID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	txs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Transaction, error) {
+		t := &Transaction{}
+		var amount int64
+		if err := row.Scan(&t.ID, &t.Kind, &t.Operation, &amount, &t.GrantID, &t.Note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.AmountUSD = toUSD(amount)
+		return t, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return txs, total, nil
+}
+
+// GrantInput is a grant an operator makes.
+type GrantInput struct {
+	AmountUSD float64    `json:"amount_usd"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Note      string     `json:"note"`
+}
+
+// Grant gives orgID credits.
+func (s *Service) Grant(ctx context.Context, orgID string, in GrantInput) (*Grant, error) {
+	now := time.Now()
+	if toMicros(in.AmountUSD) <= 0 || (in.ExpiresAt != nil && !in.ExpiresAt.After(now)) {
+		return nil, ErrInvalidGrant
+	}
+	g := &Grant{
+		ID:           uuid.NewString(),
+		OrgID:        orgID,
+		AmountUSD:    in.AmountUSD,
+		RemainingUSD: in.AmountUSD,
+		ExpiresAt:    in.ExpiresAt,
+		Note:         in.Note,
+		CreatedAt:    now,
+	}
+	if err := s.repo.AddGrant(ctx, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Credits returns an org's balance and open grants; nil if the org isn't
+// prepaid.
+func (s *Service) Credits(ctx context.Context, orgID string) (*Credits, error) {
+	if err := s.repo.expireDue(ctx, orgID); err != nil {
+		return nil, err
+	}
+	micros, prepaid, err := s.repo.balance(ctx, orgID)
+	if err != nil || !prepaid {
+		return nil, err
+	}
+	grants, err := s.repo.OpenGrants(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return &Credits{BalanceUSD: toUSD(micros), Grants: grants}, nil
+}
+
+// Transactions returns a page of an org's credit ledger, newest first.
+func (s *Service) Transactions(ctx context.Context, orgID string, limit, offset int) ([]*Transaction, int, error) {
+	if limit <= 0 || limit > maxTransactionsPage {
+		limit = maxTransactionsPage
+	}
+	if err := s.repo.expireDue(ctx, orgID); err != nil {
+		return nil, 0, err
+	}
+	return s.repo.Transactions(ctx, orgID, limit, max(offset, 0))
+}
+
+// checkCredits returns a *CreditError if orgID is prepaid and out of
+// credit.
+func (s *Service) checkCredits(ctx context.Context, orgID string) error {
+	micros, prepaid, err := s.repo.balance(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if prepaid && micros <= 0 {
+		return &CreditError{}
+	}
+	return nil
+}
+
+// consume charges cost to orgID's credits, if it is prepaid. Like the
+// rest of metering it only logs failures.
+func (m *Meter) consume(ctx context.Context, orgID, op string, cost float64) {
+	micros := toMicros(cost)
+	if micros <= 0 {
+		return
+	}
+	if err := m.repo.consume(ctx, orgID, op, micros); err != nil {
+		slog.Error("consume credits failed", "org_id", orgID, "operation", op, "error", err)
+	}
+}
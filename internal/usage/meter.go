@@ -0,0 +1,174 @@
+package usage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pixell07/multi-tenant-ai/internal/embedding"
+	"github.com/pixell07/multi-tenant-ai/internal/llm"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Metering
+// Model calls are metered by wrapping the embedder and the LLM client, so
+// every path that reaches a model (uploads, connector syncs, queries, MCP,
+// public sites, summaries) is counted against the org in ctx. Completions
+// are counted as the provider billed them when it reports usage (OpenAI
+// does, see llm.WithUsageReport). Otherwise, and for langchaingo's
+// embedder, which reports nothing, counts are estimates from the text sent
+// and received; batch embeddings are counted exactly from the Batch API's
+// output (see RecordEmbedding).
+
+// Meter records token usage and what it cost. It is separate from
+// Service, which reads the document inventory, because the services that
+// inventory comes from are built on the metered models.
+type Meter struct {
+	repo    *Repository
+	pricing Pricing
+	// fallbackModel is what completions switch to past an org's budget
+	// downgrade threshold; empty never switches.
+	fallbackModel string
+	notifier      Notifier
+}
+
+// NewMeter creates a meter pricing usage with pricing.
+func NewMeter(repo *Repository, pricing Pricing, fallbackModel string) *Meter {
+	return &Meter{repo: repo, pricing: pricing, fallbackModel: fallbackModel}
+}
+
+// record adds token usage of op and its cost for an org, charges the cost
+// to the org's credits and attributes it within the org. Metering must never fail the work it
+// measures, so errors are only logged.
+func (m *Meter) record(ctx context.Context, orgID, op string, embedding, prompt, completion int64, cost float64) {
+	if orgID == "" || embedding+prompt+completion == 0 {
+		return
+	}
+	tally(ctx, embedding, prompt, completion, cost)
+	// The work is done even if the request was cancelled meanwhile.
+	ctx = context.WithoutCancel(ctx)
+	spent, err := m.repo.add(ctx, orgID, period(time.Now()), embedding, prompt, completion, cost)
+	if err != nil {
+		slog.Error("record usage failed", "org_id", orgID, "error", err)
+		return
+	}
+	m.crossed(ctx, orgID, spent, cost)
+	m.consume(ctx, orgID, op, cost)
+	m.attribute(ctx, orgID, embedding, prompt, completion, 0, cost)
+}
+
+// CountQuery counts an answered query for orgID. It fits
+// retrieval.QueryCounter.
+func (m *Meter) CountQuery(ctx context.Context, orgID string) {
+	if orgID == "" {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := m.repo.addQuery(ctx, orgID, period(time.Now())); err != nil {
+		slog.Error("count query failed", "org_id", orgID, "error", err)
+		return
+	}
+	m.attribute(ctx, orgID, 0, 0, 0, 1, 0)
+}
+
+// attribute adds usage to the actor and workspace in ctx.
+func (m *Meter) attribute(ctx context.Context, orgID string, embedding, prompt, completion, queries int64, cost float64) {
+	actor := tenancy.ActorFrom(ctx)
+	err := m.repo.attribute(ctx, orgID, period(time.Now()), actor, tenancy.WorkspaceFrom(ctx), embedding, prompt, completion, queries, cost)
+	if err != nil {
+		slog.Error("attribute usage failed", "org_id", orgID, "actor", actor, "error", err)
+	}
+}
+
+// recordEmbedding adds tokens embedded directly for the org in ctx.
+func (m *Meter) recordEmbedding(ctx context.Context, tokens int64) {
+	m.record(ctx, tenancy.OrgFrom(ctx), OpEmbedding, tokens, 0, 0, m.pricing.embeddingCost(tokens))
+}
+
+// RecordEmbedding adds tokens embedded through the Batch API for the org
+// in ctx, at the batch discount. It fits embedding.UsageFunc.
+func (m *Meter) RecordEmbedding(ctx context.Context, tokens int64) {
+	m.record(ctx, tenancy.OrgFrom(ctx), OpBatchEmbedding, tokens, 0, 0, m.pricing.embeddingCost(tokens)*batchDiscount)
+}
+
+// Embedder counts the tokens embedded through inner.
+func (m *Meter) Embedder(inner embedding.Embedder) embedding.Embedder {
+	return &meteredEmbedder{inner: inner, meter: m}
+}
+
+type meteredEmbedder struct {
+	inner embedding.Embedder
+	meter *Meter
+}
+
+func (m *meteredEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs, err := m.inner.EmbedDocuments(ctx, texts)
+	if err == nil {
+		var tokens int64
+		for _, t := range texts {
+			tokens += embedding.EstimateTokens(t)
+		}
+		m.meter.recordEmbedding(ctx, tokens)
+	}
+	return vecs, err
+}
+
+func (m *meteredEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vec, err := m.inner.EmbedQuery(ctx, text)
+	if err == nil {
+		m.meter.recordEmbedding(ctx, embedding.EstimateTokens(text))
+	}
+	return vec, err
+}
+
+// LLM counts the prompt and completion tokens of completions run through
+// inner, and switches orgs past their budget's downgrade threshold to the
+// fallback model. Model aliases must already be resolved.
+func (m *Meter) LLM(inner llm.Client) llm.Client {
+	return &meteredLLM{inner: inner, meter: m}
+}
+
+// FallbackLLM counts completions run through inner like LLM, but never
+// switches their model: inner is a fallback provider answering with its
+// own.
+func (m *Meter) FallbackLLM(inner llm.Client) llm.Client {
+	return &meteredLLM{inner: inner, meter: m, keepModel: true}
+}
+
+type meteredLLM struct {
+	inner     llm.Client
+	meter     *Meter
+	keepModel bool
+}
+
+func (m *meteredLLM) StreamCompletion(ctx context.Context, systemPrompt, userMessage string, opts llm.CompletionOptions, out chan<- string) error {
+	defer close(out)
+
+	orgID := tenancy.OrgFrom(ctx)
+	if !m.keepModel {
+		opts.Model = m.meter.downgrade(ctx, orgID, opts.Model)
+	}
+
+	tokens := make(chan string)
+	errc := make(chan error, 1)
+	rctx, reported := llm.WithUsageReport(ctx)
+	go func() { errc <- m.inner.StreamCompletion(rctx, systemPrompt, userMessage, opts, tokens) }()
+
+	var completion int64
+	for t := range tokens {
+		completion += embedding.EstimateTokens(t)
+		select {
+		case out <- t:
+		case <-ctx.Done():
+			// The reader is gone; drain so the provider can finish.
+		}
+	}
+	err := <-errc
+
+	prompt := embedding.EstimateTokens(systemPrompt) + embedding.EstimateTokens(userMessage)
+	if reported.PromptTokens+reported.CompletionTokens > 0 {
+		prompt, completion = reported.PromptTokens, reported.CompletionTokens
+	}
+	m.meter.record(ctx, orgID, OpCompletion, 0, prompt, completion, m.meter.pricing.llmCost(opts.Model, prompt, completion))
+	return err
+}
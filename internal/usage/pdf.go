@@ -0,0 +1,80 @@
+package usage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// renderPDF lays lines out as monospaced text on A4 pages. Statements
+// are short tables, so a fixed-width built-in font is all they need and
+// no PDF library is pulled in.
+func renderPDF(lines []string) []byte {
+	const (
+		linesPerPage = 52
+		top          = 790
+		left         = 56
+		leading      = 14
+	)
+	var pages [][]string
+	for len(lines) > linesPerPage {
+		pages = append(pages, lines[:linesPerPage])
+		lines = lines[linesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects: 1 catalog, 2 page tree, 3 font, then a page and its
+	// content stream per page.
+	var buf bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] "+
+			"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i))
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 10 Tf\n%d TL\n%d %d Td\n", leading, left, top)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfString escapes s for a PDF literal string in WinAnsiEncoding;
+// characters outside Latin-1 become "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package usage
+
+import (
+	"context"
+	"math"
+
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+)
+
+// Cost estimates
+// Before a bulk import an admin can ask what it will cost: the content is
+// split like ingestion would split it, its tokens counted, and the counts
+// priced with the deployment's embedding and LLM models.
+
+// batchDiscount is what the embeddings Batch API charges relative to
+// direct calls.
+const batchDiscount = 0.5
+
+// Price is a model's price in USD per million tokens. Embedding models
+// only have a prompt price.
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// listPrices are public list prices of the default and common models.
+// Operators override them (negotiated rates, other models) through config.
+var listPrices = map[string]Price{
+	"text-embedding-3-small":  {Prompt: 0.02},
+	"text-embedding-3-large":  {Prompt: 0.13},
+	"text-embedding-ada-002":  {Prompt: 0.10},
+	"embed-v4.0":              {Prompt: 0.12},
+	"voyage-3.5":              {Prompt: 0.06},
+	"voyage-3.5-lite":         {Prompt: 0.02},
+	"gpt-4o-mini":             {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":                  {Prompt: 2.50, Completion: 10.00},
+	"gpt-4.1":                 {Prompt: 2.00, Completion: 8.00},
+	"gpt-4.1-mini":            {Prompt: 0.40, Completion: 1.60},
+	"gpt-4.1-nano":            {Prompt: 0.10, Completion: 0.40},
+	"claude-3-5-haiku-latest": {Prompt: 0.80, Completion: 4.00},
+	"claude-sonnet-4-0":       {Prompt: 3.00, Completion: 15.00},
+	"gemini-2.0-flash":        {Prompt: 0.10, Completion: 0.40},
+}
+
+// ListPrice returns the list price of model, or nil if it isn't known.
+func ListPrice(model string) *Price {
+	p, ok := listPrices[model]
+	if !ok {
+		return nil
+	}
+	return &p
+}
+
+// Pricing is what the deployment's models cost; a nil price is unknown.
+type Pricing struct {
+	EmbeddingModel string
+	Embedding      *Price
+	LLMModel       string
+	LLM            *Price
+}
+
+// Estimate is an ingestion's predicted consumption and cost.
+type Estimate struct {
+	*document.IngestEstimate
+	EmbeddingModel string `json:"embedding_model"`
+	// LLMModel is set when the summary tree makes LLM calls.
+	LLMModel string `json:"llm_model,omitempty"`
+	// CostUSD is nil when a model involved has no known price.
+	CostUSD *Cost `json:"cost_usd"`
+	// WithinQuota reports whether the org's remaining document and token
+	// quotas, and its budget, cover the ingestion; storage isn't predicted.
+	WithinQuota bool `json:"within_quota"`
+}
+
+type Cost struct {
+	Embedding float64 `json:"embedding"`
+	LLM       float64 `json:"llm"`
+	Total     float64 `json:"total"`
+}
+
+// Estimate prices an ingestion estimate for orgID and checks it against
+// the org's quota.
+func (s *Service) Estimate(ctx context.Context, orgID string, in *document.IngestEstimate) (*Estimate, error) {
+	est := &Estimate{IngestEstimate: in, EmbeddingModel: s.pricing.EmbeddingModel}
+	if in.SummaryCalls > 0 {
+		est.LLMModel = s.pricing.LLMModel
+	}
+	est.CostUSD = s.pricing.cost(in)
+
+	u, err := s.Usage(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	est.WithinQuota = fits(u.Quota.MaxDocuments, u.Documents, 1) &&
+		fits(u.Quota.MaxEmbeddingTokens, u.EmbeddingTokens, in.EmbeddingTokens) &&
+		fits(u.Quota.MaxLLMTokens, u.PromptTokens+u.CompletionTokens, in.PromptTokens+in.CompletionTokens) &&
+		(u.Budget == nil || est.CostUSD == nil || u.SpendUSD+est.CostUSD.Total <= u.Budget.MonthlyCapUSD)
+	return est, nil
+}
+
+func (p Pricing) cost(in *document.IngestEstimate) *Cost {
+	if p.Embedding == nil || (in.SummaryCalls > 0 && p.LLM == nil) {
+		return nil
+	}
+	chunks := perMillion(in.ChunkTokens, p.Embedding.Prompt)
+	if in.Batch {
+		chunks *= batchDiscount
+	}
+	c := &Cost{Embedding: chunks + perMillion(in.EmbeddingTokens-in.ChunkTokens, p.Embedding.Prompt)}
+	if in.SummaryCalls > 0 {
+		c.LLM = perMillion(in.PromptTokens, p.LLM.Prompt) + perMillion(in.CompletionTokens, p.LLM.Completion)
+	}
+	c.Embedding, c.LLM = roundUSD(c.Embedding), roundUSD(c.LLM)
+	c.Total = roundUSD(c.Embedding + c.LLM)
+	return c
+}
+
+// embeddingCost prices tokens embedded directly; zero without a price.
+func (p Pricing) embeddingCost(tokens int64) float64 {
+	if p.Embedding == nil {
+		return 0
+	}
+	return perMillion(tokens, p.Embedding.Prompt)
+}
+
+// llmCost prices a completion by model, empty meaning the deployment's
+// model; zero without a price.
+func (p Pricing) llmCost(model string, prompt, completion int64) float64 {
+	price := p.LLM
+	if model != "" && model != p.LLMModel {
+		price = ListPrice(model)
+	}
+	if price == nil {
+		return 0
+	}
+	return perMillion(prompt, price.Prompt) + perMillion(completion, price.Completion)
+}
+
+func perMillion(tokens int64, price float64) float64 {
+	return float64(tokens) * price / 1e6
+}
+
+// roundUSD rounds to a hundredth of a cent, enough to tell small
+// documents apart.
+func roundUSD(usd float64) float64 {
+	return math.Round(usd*1e4) / 1e4
+}
+
+// fits reports whether adding n to used stays within limit.
+func fits(limit *int64, used, n int64) bool {
+	return limit == nil || used+n <= *limit
+}
@@ -0,0 +1,432 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Statements
+// Once a month is over, each org gets a statement of it: queries, tokens,
+// spend, prepaid credits used, what it stored and where it went over its
+// limits. Statements are stored as issued and mailed to the org's billing
+// contacts (its admins if it has none). The month in progress can be
+// previewed from the live counters. Documents and storage aren't counted
+// per month, so a statement shows what the org stored when it was issued.
+
+const (
+	// maxBillingContacts bounds the addresses statements go to.
+	maxBillingContacts = 10
+	// periodLayout formats a statement's period in URLs, e.g. "2026-09".
+	periodLayout = "2006-01"
+)
+
+var (
+	// ErrInvalidPeriod is returned for a period that isn't YYYY-MM or is
+	// in the future.
+	ErrInvalidPeriod = errors.New("period must be a past or the current month, as YYYY-MM")
+	// ErrNoStatement is returned for a past month without a statement.
+	ErrNoStatement = errors.New("no statement for that month")
+	// ErrInvalidContacts is returned for an invalid billing contact list.
+	ErrInvalidContacts = fmt.Errorf("at most %d valid email addresses are allowed", maxBillingContacts)
+)
+
+// Mailer delivers a plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Statement is an org's usage in one month.
+type Statement struct {
+	OrgID   string    `json:"org_id"`
+	OrgName string    `json:"org_name"`
+	Period  time.Time `json:"period"`
+	// Final is false for a preview of the month in progress.
+	Final            bool      `json:"final"`
+	GeneratedAt      time.Time `json:"generated_at"`
+	Queries          int64     `json:"queries"`
+	EmbeddingTokens  int64     `json:"embedding_tokens"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	// Documents and StorageBytes are what the org stored at GeneratedAt.
+	Documents      int64      `json:"documents"`
+	StorageBytes   int64      `json:"storage_bytes"`
+	SpendUSD       float64    `json:"spend_usd"`
+	CreditsUsedUSD float64    `json:"credits_used_usd"`
+	Quota          Quota      `json:"quota"`
+	Budget         *Budget    `json:"budget"`
+	Overages       []*Overage `json:"overages"`
+}
+
+// Overage is usage past a quota or budget. Checks run before the work they
+// guard, so the last request of a month can overshoot.
+type Overage struct {
+	// Item is a Kind, or "spend_usd" for the budget.
+	Item  string  `json:"item"`
+	Limit float64 `json:"limit"`
+	Used  float64 `json:"used"`
+	Over  float64 `json:"over"`
+}
+
+// ParsePeriod parses a YYYY-MM period.
+func ParsePeriod(s string) (time.Time, error) {
+	p, err := time.Parse(periodLayout, s)
+	if err != nil || p.After(period(time.Now())) {
+		return time.Time{}, ErrInvalidPeriod
+	}
+	return p, nil
+}
+
+// orgName returns an org's name.
+func (r *Repository) orgName(ctx context.Context, orgID string) (string, error) {
+	var name string
+	err := r.db.QueryRow(ctx, `SELECT name FROM organizations WHERE id = $1`, orgID).Scan(&name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrOrgNotFound
+	}
+	return name, err
+}
+
+// creditsUsed returns what an org's credits covered in the period.
+func (r *Repository) creditsUsed(ctx context.Context, orgID string, p time.Time) (float64, error) {
+	var micros int64
+	err := r.db.QueryRow(ctx,
+		`SELECT COALESCE(-sum(amount_micros), 0) FROM credit_transactions
+		 WHERE org_id = $1 AND kind = 'consumption' AND created_at >= $2 AND created_at < $3`,
+		orgID, p, p.AddDate(0, 1, 0)).Scan(&micros)
+	return toUSD(micros), err
+}
+
+// unbilled returns the orgs that existed in the period and have no
+// statement of their own for it; one merged in from another org doesn't
+// count.
+func (r *Repository) unbilled(ctx context.Context, p time.Time) ([]string, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT o.id FROM organizations o
+		 WHERE o.created_at < $2
+		   AND NOT EXISTS (SELECT 1 FROM usage_statements s WHERE s.org_id = o.id AND s.period = $1 AND s.merged_from = '')
+		 ORDER BY o.id`, p, p.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// saveStatement stores st unless the month already has a statement, and
+// reports whether it did.
+func (r *Repository) saveStatement(ctx context.Context, st *Statement) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`INSERT INTO usage_statements (org_id, period, statement, generated_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (org_id, period, merged_from) DO NOTHING`,
+		st.OrgID, st.Period, st, st.GeneratedAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Statement returns an org's stored statement of the period.
+func (r *Repository) Statement(ctx context.Context, orgID string, p time.Time) (*Statement, error) {
+	st := &Statement{}
+	err := r.db.QueryRow(ctx,
+		`SELECT statement FROM usage_statements WHERE org_id = $1 AND period = $2 AND merged_from = ''`, orgID, p).Scan(st)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoStatement
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Statements returns an org's stored statements, newest first, including
+// those of orgs merged into it.
+func (r *Repository) Statements(ctx context.Context, orgID string) ([]*Statement, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT statement FROM usage_statements WHERE org_id = $1 ORDER BY period DESC, merged_from`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Statement, error) {
+		st := &Statement{}
+		return st, row.Scan(st)
+	})
+}
+
+// BillingContacts returns the addresses an org's statements go to.
+func (r *Repository) BillingContacts(ctx context.Context, orgID string) ([]string, error) {
+	var emails []string
+	err := r.db.QueryRow(ctx,
+		`SELECT emails FROM org_billing_contacts WHERE org_id = $1`, orgID).Scan(&emails)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return []string{}, nil
+	}
+	return emails, err
+}
+
+// SetBillingContacts replaces an org's billing contacts; none removes
+// them.
+func (r *Repository) SetBillingContacts(ctx context.Context, orgID string, emails []string) error {
+	if len(emails) == 0 {
+		_, err := r.db.Exec(ctx, `DELETE FROM org_billing_contacts WHERE org_id = $1`, orgID)
+		return err
+	}
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO org_billing_contacts (org_id, emails) VALUES ($1, $2)
+		 ON CONFLICT (org_id) DO UPDATE SET emails = EXCLUDED.emails, updated_at = NOW()`,
+		orgID, emails)
+	if isForeignKeyViolation(err) {
+		return ErrOrgNotFound
+	}
+	return err
+}
+
+// MailStatementsThrough makes the service mail each statement it issues
+// to the org's billing contacts through mailer, or to its admins through
+// admins when it has none. A nil mailer only reaches admins.
+func (s *Service) MailStatementsThrough(mailer Mailer, admins Notifier) {
+	s.mailer, s.admins = mailer, admins
+}
+
+// build assembles orgID's statement of the period from its counters.
+func (s *Service) build(ctx context.Context, orgID string, p time.Time) (*Statement, error) {
+	name, err := s.repo.orgName(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	u := &Usage{}
+	if err := s.repo.counters(ctx, orgID, p, u); err != nil {
+		return nil, err
+	}
+	st := &Statement{
+		OrgID:            orgID,
+		OrgName:          name,
+		Period:           p,
+		Final:            p.Before(period(time.Now())),
+		GeneratedAt:      time.Now().UTC(),
+		Queries:          u.Queries,
+		EmbeddingTokens:  u.EmbeddingTokens,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		SpendUSD:         u.SpendUSD,
+		Overages:         []*Overage{},
+	}
+	inv, err := s.inventory.Stats(tenancy.WithOrg(ctx, orgID), orgID)
+	if err != nil {
+		return nil, err
+	}
+	st.Documents, st.StorageBytes = int64(inv.Documents), inv.VectorSize
+	if st.CreditsUsedUSD, err = s.repo.creditsUsed(ctx, orgID, p); err != nil {
+		return nil, err
+	}
+	q, err := s.repo.Quota(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	st.Quota = *q
+	if st.Budget, err = s.repo.Budget(ctx, orgID); err != nil {
+		return nil, err
+	}
+
+	used := map[Kind]int64{
+		Documents:       st.Documents,
+		StorageBytes:    st.StorageBytes,
+		EmbeddingTokens: st.EmbeddingTokens,
+		LLMTokens:       st.PromptTokens + st.CompletionTokens,
+	}
+	for _, k := range []Kind{EmbeddingTokens, LLMTokens, Documents, StorageBytes} {
+		if limit := q.limit(k); limit != nil && used[k] > *limit {
+			st.Overages = append(st.Overages, &Overage{
+				Item: string(k), Limit: float64(*limit), Used: float64(used[k]), Over: float64(used[k] - *limit),
+			})
+		}
+	}
+	if b := st.Budget; b != nil && st.SpendUSD > b.MonthlyCapUSD {
+		st.Overages = append(st.Overages, &Overage{
+			Item: "spend_usd", Limit: b.MonthlyCapUSD, Used: st.SpendUSD, Over: st.SpendUSD - b.MonthlyCapUSD,
+		})
+	}
+	return st, nil
+}
+
+// Statement returns orgID's statement of the period: a preview for the
+// month in progress, otherwise the one issued.
+func (s *Service) Statement(ctx context.Context, orgID string, p time.Time) (*Statement, error) {
+	if p.Equal(period(time.Now())) {
+		return s.build(ctx, orgID, p)
+	}
+	return s.repo.Statement(ctx, orgID, p)
+}
+
+// Statements lists orgID's issued statements, newest first.
+func (s *Service) Statements(ctx context.Context, orgID string) ([]*Statement, error) {
+	return s.repo.Statements(ctx, orgID)
+}
+
+// BillingContacts returns the addresses orgID's statements go to; empty
+// means its admins.
+func (s *Service) BillingContacts(ctx context.Context, orgID string) ([]string, error) {
+	return s.repo.BillingContacts(ctx, orgID)
+}
+
+// SetBillingContacts replaces orgID's billing contacts.
+func (s *Service) SetBillingContacts(ctx context.Context, orgID string, emails []string) ([]string, error) {
+	if len(emails) > maxBillingContacts {
+		return nil, ErrInvalidContacts
+	}
+	clean := make([]string, 0, len(emails))
+	for _, e := range emails {
+		addr, err := mail.ParseAddress(strings.TrimSpace(e))
+		if err != nil {
+			return nil, ErrInvalidContacts
+		}
+		clean = append(clean, addr.Address)
+	}
+	if err := s.repo.SetBillingContacts(ctx, orgID, clean); err != nil {
+		return nil, err
+	}
+	return clean, nil
+}
+
+// RunStatements issues the previous month's statements every interval
+// until ctx is cancelled. Each org's statement is issued once, whichever
+// replica gets to it first.
+func (s *Service) RunStatements(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.issueStatements(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) issueStatements(ctx context.Context) {
+	p := period(time.Now()).AddDate(0, -1, 0)
+	orgs, err := s.repo.unbilled(ctx, p)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("list unbilled orgs failed", "error", err)
+		}
+		return
+	}
+	for _, orgID := range orgs {
+		st, err := s.build(ctx, orgID, p)
+		if err != nil {
+			slog.Error("build statement failed", "org_id", orgID, "period", p.Format(periodLayout), "error", err)
+			continue
+		}
+		issued, err := s.repo.saveStatement(ctx, st)
+		if err != nil {
+			slog.Error("save statement failed", "org_id", orgID, "period", p.Format(periodLayout), "error", err)
+			continue
+		}
+		if issued {
+			slog.Info("statement issued", "org_id", orgID, "period", p.Format(periodLayout))
+			s.mailStatement(ctx, st)
+		}
+	}
+}
+
+func (s *Service) mailStatement(ctx context.Context, st *Statement) {
+	subject := fmt.Sprintf("Usage statement for %s", st.Period.Format("January 2006"))
+	body := st.Text() + "\nDownload it as PDF from /api/v1/billing/statements/" +
+		st.Period.Format(periodLayout) + "?format=pdf.\n"
+
+	contacts, err := s.repo.BillingContacts(ctx, st.OrgID)
+	if err != nil {
+		slog.Error("load billing contacts failed", "org_id", st.OrgID, "error", err)
+		return
+	}
+	if len(contacts) == 0 || s.mailer == nil {
+		if s.admins != nil {
+			err = s.admins.NotifyAdmins(ctx, st.OrgID, subject, body)
+		}
+	} else {
+		var errs []error
+		for _, to := range contacts {
+			if err := s.mailer.Send(ctx, to, subject, body); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", to, err))
+			}
+		}
+		err = errors.Join(errs...)
+	}
+	if err != nil {
+		slog.Error("mail statement failed", "org_id", st.OrgID, "error", err)
+	}
+}
+
+// Text renders the statement as plain text, for email and PDF.
+func (st *Statement) Text() string {
+	var b strings.Builder
+	title := "Usage statement"
+	if !st.Final {
+		title += " (preview, month in progress)"
+	}
+	end := st.Period.AddDate(0, 1, -1)
+	fmt.Fprintf(&b, "%s\n%s (%s)\n\n", title, st.OrgName, st.OrgID)
+	fmt.Fprintf(&b, "Period:     %s to %s (UTC)\n", st.Period.Format("2006-01-02"), end.Format("2006-01-02"))
+	fmt.Fprintf(&b, "Generated:  %s\n\n", st.GeneratedAt.UTC().Format("2006-01-02 15:04 MST"))
+
+	line := func(label, value string) { fmt.Fprintf(&b, "%-28s %18s\n", label, value) }
+	line("Queries answered", grouped(st.Queries))
+	line("Embedding tokens", grouped(st.EmbeddingTokens))
+	line("Prompt tokens", grouped(st.PromptTokens))
+	line("Completion tokens", grouped(st.CompletionTokens))
+	line("Model spend", fmt.Sprintf("$%.2f", st.SpendUSD))
+	if st.CreditsUsedUSD > 0 {
+		line("Prepaid credits used", fmt.Sprintf("$%.2f", st.CreditsUsedUSD))
+	}
+	if st.Budget != nil {
+		line("Monthly budget", fmt.Sprintf("$%.2f", st.Budget.MonthlyCapUSD))
+	}
+	line("Documents stored", grouped(st.Documents))
+	line("Vector storage", megabytes(st.StorageBytes))
+
+	b.WriteString("\nOverages\n")
+	if len(st.Overages) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, o := range st.Overages {
+		if o.Item == "spend_usd" {
+			fmt.Fprintf(&b, "  %-26s $%.2f of $%.2f ($%.2f over)\n", "budget", o.Used, o.Limit, o.Over)
+			continue
+		}
+		fmt.Fprintf(&b, "  %-26s %s of %s (%s over)\n", o.Item,
+			grouped(int64(o.Used)), grouped(int64(o.Limit)), grouped(int64(o.Over)))
+	}
+	return b.String()
+}
+
+// PDF renders the statement as a PDF document.
+func (st *Statement) PDF() []byte {
+	return renderPDF(strings.Split(strings.TrimRight(st.Text(), "\n"), "\n"))
+}
+
+// grouped formats n with thousands separators.
+func grouped(n int64) string {
+	s := fmt.Sprint(n)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
+
+func megabytes(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
@@ -0,0 +1,52 @@
+package usage
+
+import (
+	"context"
+	"sync"
+)
+
+// RequestUsage is what one request consumed, counted as the meter records
+// it: exact where the provider reported usage, estimated otherwise.
+type RequestUsage struct {
+	EmbeddingTokens  int64   `json:"embedding_tokens"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// Tally collects the usage metered under one context, so a handler can
+// tell its caller what the request cost.
+type Tally struct {
+	mu sync.Mutex
+	u  RequestUsage
+}
+
+type tallyKey struct{}
+
+// WithTally returns a context whose metered usage is also added to the
+// returned tally.
+func WithTally(ctx context.Context) (context.Context, *Tally) {
+	t := &Tally{}
+	return context.WithValue(ctx, tallyKey{}, t), t
+}
+
+// Usage returns what has been tallied so far.
+func (t *Tally) Usage() RequestUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.u
+}
+
+// tally adds usage to the tally in ctx, if any.
+func tally(ctx context.Context, embedding, prompt, completion int64, cost float64) {
+	t, _ := ctx.Value(tallyKey{}).(*Tally)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.u.EmbeddingTokens += embedding
+	t.u.PromptTokens += prompt
+	t.u.CompletionTokens += completion
+	t.u.CostUSD += cost
+}
@@ -0,0 +1,357 @@
+// Package usage meters what each org consumes (embedding tokens, LLM
+// prompt and completion tokens, documents and vector storage) and enforces
+// the hard quotas an operator sets per org. Token usage is counted per
+// calendar month (UTC); documents and storage are current totals.
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pixell07/multi-tenant-ai/internal/database"
+	"github.com/pixell07/multi-tenant-ai/internal/document"
+	"github.com/pixell07/multi-tenant-ai/internal/tenancy"
+)
+
+// Kind is one metered resource with a quota.
+type Kind string
+
+const (
+	Documents       Kind = "documents"
+	StorageBytes    Kind = "storage_bytes"
+	EmbeddingTokens Kind = "embedding_tokens"
+	// LLMTokens is prompt plus completion tokens.
+	LLMTokens Kind = "llm_tokens"
+)
+
+// IngestKinds are the quotas checked before anything that adds content.
+var IngestKinds = []Kind{Documents, StorageBytes, EmbeddingTokens}
+
+// monthly reports whether a kind's usage resets every period.
+func (k Kind) monthly() bool {
+	return k == EmbeddingTokens || k == LLMTokens
+}
+
+// ErrQuotaExceeded matches every *QuotaError and *BudgetError.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError reports which quota an org has used up.
+type QuotaError struct {
+	Kind  Kind
+	Limit int64
+	Used  int64
+	// ResetAt is when a monthly quota starts over; zero for documents and
+	// storage, which only free up when content is deleted.
+	ResetAt time.Time
+	// Requested is how much more the refused work needed (CheckRoom); zero
+	// when the quota is used up.
+	Requested int64
+}
+
+func (e *QuotaError) Error() string {
+	if e.Requested > 0 {
+		return fmt.Sprintf("%s quota exceeded: %d of %d used, %d more requested", e.Kind, e.Used, e.Limit, e.Requested)
+	}
+	return fmt.Sprintf("%s quota exceeded: %d of %d used", e.Kind, e.Used, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// Quota holds an org's limits; nil fields are unlimited.
+type Quota struct {
+	MaxDocuments       *int64    `json:"max_documents"`
+	MaxStorageBytes    *int64    `json:"max_storage_bytes"`
+	MaxEmbeddingTokens *int64    `json:"max_embedding_tokens"`
+	MaxLLMTokens       *int64    `json:"max_llm_tokens"`
+	UpdatedAt          time.Time `json:"updated_at,omitzero"`
+}
+
+func (q *Quota) limit(k Kind) *int64 {
+	switch k {
+	case Documents:
+		return q.MaxDocuments
+	case StorageBytes:
+		return q.MaxStorageBytes
+	case EmbeddingTokens:
+		return q.MaxEmbeddingTokens
+	case LLMTokens:
+		return q.MaxLLMTokens
+	}
+	return nil
+}
+
+// Usage is an org's consumption in the current period.
+type Usage struct {
+	OrgID string `json:"org_id"`
+	// Period is the first day of the month the token counts cover.
+	Period           time.Time `json:"period"`
+	EmbeddingTokens  int64     `json:"embedding_tokens"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	Documents        int64     `json:"documents"`
+	StorageBytes     int64     `json:"storage_bytes"`
+	// Queries counts the questions answered this month.
+	Queries int64 `json:"queries"`
+	// SpendUSD is what the month's tokens cost at the deployment's prices.
+	SpendUSD float64 `json:"spend_usd"`
+	Quota    Quota   `json:"quota"`
+	Budget   *Budget `json:"budget"`
+}
+
+func (u *Usage) used(k Kind) int64 {
+	switch k {
+	case Documents:
+		return u.Documents
+	case StorageBytes:
+		return u.StorageBytes
+	case EmbeddingTokens:
+		return u.EmbeddingTokens
+	case LLMTokens:
+		return u.PromptTokens + u.CompletionTokens
+	}
+	return 0
+}
+
+// period returns the first instant of t's month, in UTC.
+func period(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+	// schema routes the users columns read for attribution.
+	schema *database.Schema
+}
+
+func NewRepository(db *pgxpool.Pool, schema *database.Schema) *Repository {
+	return &Repository{db: db, schema: schema}
+}
+
+// add increments an org's counters for the period and returns its spend
+// so far.
+func (r *Repository) add(ctx context.Context, orgID string, p time.Time, embedding, prompt, completion int64, cost float64) (float64, error) {
+	var spent float64
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO usage_counters (org_id, period, embedding_tokens, prompt_tokens, completion_tokens, spend_usd)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (org_id, period) DO UPDATE SET
+			 embedding_tokens = usage_counters.embedding_tokens + EXCLUDED.embedding_tokens,
+			 prompt_tokens = usage_counters.prompt_tokens + EXCLUDED.prompt_tokens,
+			 completion_tokens = usage_counters.completion_tokens + EXCLUDED.completion_tokens,
+			 spend_usd = usage_counters.spend_usd + EXCLUDED.spend_usd,
+			 updated_at = NOW()
+		 RETURNING spend_usd`,
+		orgID, p, embedding, prompt, completion, cost).Scan(&spent)
+	return spent, err
+}
+
+// addQuery counts an answered query for the period.
+func (r *Repository) addQuery(ctx context.Context, orgID string, p time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO usage_counters (org_id, period, queries) VALUES ($1, $2, 1)
+		 ON CONFLICT (org_id, period) DO UPDATE SET
+			 queries = usage_counters.queries + 1,
+			 updated_at = NOW()`,
+		orgID, p)
+	return err
+}
+
+// counters loads an org's token counts for the period into u.
+func (r *Repository) counters(ctx context.Context, orgID string, p time.Time, u *Usage) error {
+	err := r.db.QueryRow(ctx,
+		`SELECT embedding_tokens, prompt_tokens, completion_tokens, queries, spend_usd
+		 FROM usage_counters WHERE org_id = $1 AND period = $2`, orgID, p,
+	).Scan(&u.EmbeddingTokens, &u.PromptTokens, &u.CompletionTokens, &u.Queries, &u.SpendUSD)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// Quota returns an org's limits; an org without any is unlimited.
+func (r *Repository) Quota(ctx context.Context, orgID string) (*Quota, error) {
+	q := &Quota{}
+	err := r.db.QueryRow(ctx,
+		`SELECT max_documents, max_storage_bytes, max_embedding_tokens, max_llm_tokens, updated_at
+		 FROM org_quotas WHERE org_id = $1`, orgID,
+	).Scan(&q.MaxDocuments, &q.MaxStorageBytes, &q.MaxEmbeddingTokens, &q.MaxLLMTokens, &q.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &Quota{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetQuota replaces an org's limits.
+func (r *Repository) SetQuota(ctx context.Context, orgID string, q *Quota) error {
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO org_quotas (org_id, max_documents, max_storage_bytes, max_embedding_tokens, max_llm_tokens)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (org_id) DO UPDATE SET
+			 max_documents = EXCLUDED.max_documents,
+			 max_storage_bytes = EXCLUDED.max_storage_bytes,
+			 max_embedding_tokens = EXCLUDED.max_embedding_tokens,
+			 max_llm_tokens = EXCLUDED.max_llm_tokens,
+			 updated_at = NOW()
+		 RETURNING updated_at`,
+		orgID, q.MaxDocuments, q.MaxStorageBytes, q.MaxEmbeddingTokens, q.MaxLLMTokens,
+	).Scan(&q.UpdatedAt)
+	if isForeignKeyViolation(err) {
+		return ErrOrgNotFound
+	}
+	return err
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// Inventory reports the content an org stores.
+type Inventory interface {
+	Stats(ctx context.Context, orgID string) (*document.Stats, error)
+}
+
+var (
+	// ErrOrgNotFound is returned when setting the quota of an unknown org.
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrInvalidQuota is returned for a negative limit.
+	ErrInvalidQuota = errors.New("quota limits can't be negative")
+)
+
+type Service struct {
+	repo      *Repository
+	inventory Inventory
+	pricing   Pricing
+	// mailer and admins deliver statements; see MailStatementsThrough.
+	mailer Mailer
+	admins Notifier
+}
+
+// NewService creates the service. pricing prices cost estimates.
+func NewService(repo *Repository, inventory Inventory, pricing Pricing) *Service {
+	return &Service{repo: repo, inventory: inventory, pricing: pricing}
+}
+
+// Usage returns an org's usage in the current period with its quota.
+func (s *Service) Usage(ctx context.Context, orgID string) (*Usage, error) {
+	u := &Usage{OrgID: orgID, Period: period(time.Now())}
+	if err := s.repo.counters(ctx, orgID, u.Period, u); err != nil {
+		return nil, err
+	}
+	st, err := s.inventory.Stats(tenancy.WithOrg(ctx, orgID), orgID)
+	if err != nil {
+		return nil, err
+	}
+	u.Documents, u.StorageBytes = int64(st.Documents), st.VectorSize
+
+	q, err := s.repo.Quota(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	u.Quota = *q
+	if u.Budget, err = s.repo.Budget(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Check returns a *QuotaError if the org has used up the quota of any of
+// kinds, or a *BudgetError if kinds include tokens and the org has spent
+// its budget. Checks run before the work they guard, so a single request
+// can overshoot a limit by its own size.
+func (s *Service) Check(ctx context.Context, orgID string, kinds ...Kind) error {
+	q, err := s.repo.Quota(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	b, err := s.repo.Budget(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	monthly := slices.ContainsFunc(kinds, Kind.monthly)
+	limited := monthly && b != nil
+	for _, k := range kinds {
+		limited = limited || q.limit(k) != nil
+	}
+	if !limited {
+		if monthly {
+			return s.checkCredits(ctx, orgID)
+		}
+		return nil // the common case: no quota, no further queries
+	}
+
+	u, err := s.Usage(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	for _, k := range kinds {
+		limit := q.limit(k)
+		if limit == nil || u.used(k) < *limit {
+			continue
+		}
+		qe := &QuotaError{Kind: k, Limit: *limit, Used: u.used(k)}
+		if k.monthly() {
+			qe.ResetAt = u.Period.AddDate(0, 1, 0)
+		}
+		return qe
+	}
+	if !monthly {
+		return nil
+	}
+	if err := overBudget(u); err != nil {
+		return err
+	}
+	return s.checkCredits(ctx, orgID)
+}
+
+// CheckRoom returns a *QuotaError if n more of kind k would take the org
+// past its limit. It is for work whose size is known before it starts,
+// such as an archive import creating a document per file, which Check
+// would let overshoot the limit by all of it.
+func (s *Service) CheckRoom(ctx context.Context, orgID string, k Kind, n int64) error {
+	q, err := s.repo.Quota(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	limit := q.limit(k)
+	if limit == nil {
+		return nil
+	}
+	u, err := s.Usage(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if u.used(k)+n <= *limit {
+		return nil
+	}
+	qe := &QuotaError{Kind: k, Limit: *limit, Used: u.used(k), Requested: n}
+	if k.monthly() {
+		qe.ResetAt = u.Period.AddDate(0, 1, 0)
+	}
+	return qe
+}
+
+// Quota returns an org's limits.
+func (s *Service) Quota(ctx context.Context, orgID string) (*Quota, error) {
+	return s.repo.Quota(ctx, orgID)
+}
+
+// SetQuota replaces an org's limits.
+func (s *Service) SetQuota(ctx context.Context, orgID string, q *Quota) error {
+	for _, limit := range []*int64{q.MaxDocuments, q.MaxStorageBytes, q.MaxEmbeddingTokens, q.MaxLLMTokens} {
+		if limit != nil && *limit < 0 {
+			return ErrInvalidQuota
+		}
+	}
+	return s.repo.SetQuota(ctx, orgID, q)
+}
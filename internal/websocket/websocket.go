@@ -0,0 +1,133 @@
+// Package websocket serves WebSocket connections for browsers and proxies
+// that handle them better than server-sent events. The protocol itself is
+// github.com/coder/websocket's; this package adds the server's policy on
+// top: which origins may connect, how large a message may be and how long
+// a write may take.
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// MaxMessageBytes bounds a message from the client, fragments included.
+const MaxMessageBytes = 1 << 20
+
+// writeTimeout bounds one write, so a client that stops reading can't
+// hold the connection forever.
+const writeTimeout = 10 * time.Second
+
+// Close codes.
+const (
+	CloseNormal        = int(websocket.StatusNormalClosure)
+	CloseGoingAway     = int(websocket.StatusGoingAway)
+	CloseProtocolError = int(websocket.StatusProtocolError)
+	CloseUnsupported   = int(websocket.StatusUnsupportedData)
+	CloseTooBig        = int(websocket.StatusMessageTooBig)
+)
+
+// ErrClosed is returned by ReadMessage once the peer has closed the
+// connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded connection. Reads must come from one goroutine;
+// writes may come from several.
+type Conn struct {
+	conn *websocket.Conn
+	ctx  context.Context
+	// readDeadline bounds the next read; zero waits indefinitely.
+	readDeadline time.Time
+}
+
+// IsUpgrade reports whether r asks to switch to WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// On failure it has already answered the request with an HTTP error.
+//
+// Browsers send the page's origin with the handshake. Unless it is the
+// server's own host or matches one of origins (host patterns as in
+// path.Match, e.g. "*.example.com"), the handshake is refused with 403,
+// so a page elsewhere can't open a connection with credentials it got
+// hold of. Clients that send no Origin (not browsers) are accepted.
+func Upgrade(w http.ResponseWriter, r *http.Request, origins []string) (*Conn, error) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: origins})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(MaxMessageBytes)
+	return &Conn{conn: conn, ctx: r.Context()}, nil
+}
+
+// SetReadDeadline bounds the wait for the next message. The connection is
+// closed when it passes.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings
+// on the way. It returns ErrClosed when the peer closes; a peer breaking
+// the protocol or sending a message over MaxMessageBytes is sent a close
+// frame and an error is returned.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	ctx := c.ctx
+	if !c.readDeadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.readDeadline)
+		defer cancel()
+	}
+	_, data, err := c.conn.Read(ctx)
+	if websocket.CloseStatus(err) != -1 {
+		return nil, ErrClosed
+	}
+	return data, err
+}
+
+// WriteText sends one text message.
+func (c *Conn) WriteText(data []byte) error {
+	ctx, cancel := context.WithTimeout(c.ctx, writeTimeout)
+	defer cancel()
+	return c.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// WriteJSON sends v as a JSON text message.
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteText(data)
+}
+
+// Close sends a close frame with code and reason, waits briefly for the
+// peer's, then closes the connection. Closing twice is harmless.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.conn.Close(websocket.StatusCode(code), reason[:min(len(reason), 123)])
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// echoServer upgrades with origins and echoes every message; each read
+// result ends up in reads.
+func echoServer(t *testing.T, origins []string) (url string, reads <-chan error) {
+	t.Helper()
+	errs := make(chan error, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, origins)
+		if err != nil {
+			return
+		}
+		defer conn.Close(CloseGoingAway, "")
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.WriteText(msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), errs
+}
+
+func dial(t *testing.T, url, origin string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	opts := &websocket.DialOptions{HTTPHeader: http.Header{}}
+	if origin != "" {
+		opts.HTTPHeader.Set("Origin", origin)
+	}
+	return websocket.Dial(t.Context(), url, opts)
+}
+
+func TestEcho(t *testing.T) {
+	url, reads := echoServer(t, nil)
+	conn, _, err := dial(t, url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadLimit(MaxMessageBytes)
+	// The server answers pings while it reads; the client sees the pong
+	// while reading the echoes.
+	pong := make(chan error, 1)
+	go func() { pong <- conn.Ping(t.Context()) }()
+	for _, msg := range []string{`{"type": "query"}`, strings.Repeat("x", 70000)} {
+		if err := conn.Write(t.Context(), websocket.MessageText, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		_, got, err := conn.Read(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Errorf("echoed %d bytes, want %d", len(got), len(msg))
+		}
+	}
+	if err := <-pong; err != nil {
+		t.Errorf("ping: %v", err)
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+	if err := <-reads; !errors.Is(err, ErrClosed) {
+		t.Errorf("server read after close: %v, want ErrClosed", err)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	url, _ := echoServer(t, []string{"*.acme.com"})
+	host := strings.TrimPrefix(url, "ws://")
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://" + host, true},
+		{"https://app.acme.com", true},
+		{"https://evil.example", false},
+		{"https://acme.com.evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			conn, resp, err := dial(t, url, tt.origin)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("refused: %v", err)
+				}
+				conn.CloseNow()
+				return
+			}
+			if err == nil {
+				conn.CloseNow()
+				t.Fatal("accepted")
+			}
+			if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("got %v, want 403", err)
+			}
+		})
+	}
+}
+
+func TestTooBig(t *testing.T) {
+	url, reads := echoServer(t, nil)
+	conn, _, err := dial(t, url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	w, err := conn.Writer(t.Context(), websocket.MessageText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fragments count towards the limit together.
+	for range 3 {
+		w.Write(bytes.Repeat([]byte("x"), MaxMessageBytes/2))
+	}
+	w.Close()
+	_, _, err = conn.Read(t.Context())
+	if got := websocket.CloseStatus(err); got != websocket.StatusMessageTooBig {
+		t.Errorf("close status %v (%v), want %v", got, err, websocket.StatusMessageTooBig)
+	}
+	if err := <-reads; err == nil {
+		t.Error("server read an oversized message")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = conn.ReadMessage()
+		done <- err
+	}))
+	defer srv.Close()
+	conn, _, err := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want the deadline", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read outlived its deadline")
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/query/ws", nil)
+		r.Header.Set("Connection", tt.connection)
+		r.Header.Set("Upgrade", tt.upgrade)
+		if got := IsUpgrade(r); got != tt.want {
+			t.Errorf("Connection %q, Upgrade %q: %v, want %v", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+-- Optimistic concurrency control for documents.
+-- version is bumped on every user edit and surfaced as the ETag; updates and
+-- deletes must send it back in If-Match so concurrent edits can't clobber
+-- each other.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,9 @@
+-- User lifecycle status for membership management.
+--   active      - can log in
+--   invited     - created by an admin/sync, has not set a password yet
+--   deactivated - removed from the org; kept for audit and reactivation
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'invited', 'deactivated'));
+
+CREATE INDEX IF NOT EXISTS idx_users_org_status ON users(org_id, status);
@@ -0,0 +1,18 @@
+-- Cross-org sharing
+-- A grant gives grantee_org_id read-only retrieval access to one document
+-- owned by owner_org_id. Isolation stays the default: without a grant row,
+-- retrieval never crosses org boundaries.
+
+CREATE TABLE IF NOT EXISTS document_grants (
+    id             TEXT PRIMARY KEY,
+    owner_org_id   TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    grantee_org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    document_id    TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    created_by     TEXT NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (document_id, grantee_org_id),
+    CHECK (owner_org_id <> grantee_org_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_grants_grantee ON document_grants(grantee_org_id);
+CREATE INDEX IF NOT EXISTS idx_grants_owner ON document_grants(owner_org_id);
@@ -0,0 +1,16 @@
+-- Public knowledge bases
+-- A public site exposes an org's knowledge base to unauthenticated
+-- visitors (docs-site search/chat) through its own token. The token is not
+-- a secret -- it ends up in page source -- so it only grants rate-limited,
+-- read-only querying and can be disabled or deleted at any time.
+
+CREATE TABLE IF NOT EXISTS public_sites (
+    id         TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    token      TEXT NOT NULL UNIQUE,
+    enabled    BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_public_sites_org ON public_sites(org_id);
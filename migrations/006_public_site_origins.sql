@@ -0,0 +1,5 @@
+-- Origin allow-list for public sites / the embeddable chat widget.
+-- Browser requests carrying an Origin header are only served (and only get
+-- CORS headers) when the origin is listed. An empty list allows any origin.
+
+ALTER TABLE public_sites ADD COLUMN IF NOT EXISTS allowed_origins TEXT[] NOT NULL DEFAULT '{}';
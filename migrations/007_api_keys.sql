@@ -0,0 +1,18 @@
+-- API keys
+-- Long-lived org-scoped credentials for machine access (MCP clients, ingestion
+-- pipelines). Only a SHA-256 hash of the key is stored; the plaintext is
+-- shown once at creation. prefix is kept for display ("rk_1a2b3c...").
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           TEXT PRIMARY KEY,
+    org_id       TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    created_by   TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_org ON api_keys(org_id);
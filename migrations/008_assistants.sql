@@ -0,0 +1,20 @@
+-- Assistant profiles
+-- An org can run several assistants ("HR Bot", "Eng Bot"), each with its
+-- own persona prompt, model and document scope. An empty document_ids scope
+-- means the whole org knowledge base.
+
+CREATE TABLE IF NOT EXISTS assistants (
+    id            TEXT PRIMARY KEY,
+    org_id        TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name          TEXT NOT NULL,
+    system_prompt TEXT NOT NULL DEFAULT '',
+    model         TEXT NOT NULL DEFAULT '',
+    document_ids  TEXT[] NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, name)
+);
+
+-- A public site bound to an assistant is that assistant's embed token.
+ALTER TABLE public_sites
+    ADD COLUMN IF NOT EXISTS assistant_id TEXT REFERENCES assistants(id) ON DELETE CASCADE;
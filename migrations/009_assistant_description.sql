@@ -0,0 +1,3 @@
+-- Assistant descriptions tell the query router what each assistant covers.
+
+ALTER TABLE assistants ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,43 @@
+-- Ticketing connectors
+-- A connector pulls items (Zendesk tickets/articles, Jira issues) from an
+-- external system into the org's documents. config holds the source-specific
+-- settings and credentials; sync_cursor is the source "updated since"
+-- watermark for incremental syncs. syncing_since doubles as a lease so only
+-- one replica syncs a connector at a time.
+
+CREATE TABLE IF NOT EXISTS connectors (
+    id            TEXT PRIMARY KEY,
+    org_id        TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind          TEXT NOT NULL CHECK (kind IN ('zendesk', 'jira')),
+    name          TEXT NOT NULL,
+    config        JSONB NOT NULL DEFAULT '{}',
+    enabled       BOOLEAN NOT NULL DEFAULT TRUE,
+    sync_cursor   TIMESTAMPTZ,
+    syncing_since TIMESTAMPTZ,
+    last_sync_at  TIMESTAMPTZ,
+    last_error    TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_connectors_org ON connectors(org_id);
+
+-- One row per synced external item, mapping it to the document holding its
+-- text. status is the item's state in the source system (open, solved,
+-- Done...). last_comment_at lets a sync append only new comments instead of
+-- re-ingesting the whole thread.
+CREATE TABLE IF NOT EXISTS connector_items (
+    connector_id      TEXT NOT NULL REFERENCES connectors(id) ON DELETE CASCADE,
+    external_id       TEXT NOT NULL,
+    document_id       TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    title             TEXT NOT NULL,
+    url               TEXT NOT NULL DEFAULT '',
+    status            TEXT NOT NULL DEFAULT '',
+    content_hash      TEXT NOT NULL,
+    last_comment_at   TIMESTAMPTZ,
+    source_updated_at TIMESTAMPTZ NOT NULL,
+    synced_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (connector_id, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_connector_items_document ON connector_items(document_id);
@@ -0,0 +1,11 @@
+-- GitHub connectors
+-- Widen the connector kind check; GitHub file items reuse connector_items
+-- with external_id "owner/repo:path".
+
+ALTER TABLE connectors DROP CONSTRAINT IF EXISTS connectors_kind_check;
+ALTER TABLE connectors
+    ADD CONSTRAINT connectors_kind_check CHECK (kind IN ('zendesk', 'jira', 'github'));
+
+-- Push webhooks look connectors up by installation.
+CREATE INDEX IF NOT EXISTS idx_connectors_github_installation
+    ON connectors (((config->>'installation_id')::bigint)) WHERE kind = 'github';
@@ -0,0 +1,8 @@
+-- API key roles and expiry
+-- Keys now authenticate the regular REST API (X-API-Key) as well as MCP.
+-- role mirrors user roles: member keys can ingest and query, admin keys can
+-- also manage org settings. expires_at NULL means the key never expires.
+
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member')),
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+-- Document metadata
+-- Free-form key/values set at upload (e.g. published_at for feed entries).
+-- Ingestion copies them onto every chunk's cmetadata.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,6 @@
+-- RSS/Atom feed connectors
+-- Entries are keyed by guid/id (falling back to the link) in connector_items.
+
+ALTER TABLE connectors DROP CONSTRAINT IF EXISTS connectors_kind_check;
+ALTER TABLE connectors
+    ADD CONSTRAINT connectors_kind_check CHECK (kind IN ('zendesk', 'jira', 'github', 'feed'));
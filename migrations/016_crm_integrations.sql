@@ -0,0 +1,13 @@
+-- CRM integrations
+-- Per-org credentials for looking up customer context (Intercom, HubSpot)
+-- from agent tools. One integration per provider per org; config holds the
+-- access token and is never returned to clients.
+
+CREATE TABLE IF NOT EXISTS crm_integrations (
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    provider   TEXT NOT NULL CHECK (provider IN ('intercom', 'hubspot')),
+    config     JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, provider)
+);
@@ -0,0 +1,20 @@
+-- Schema transitions
+-- Expand/contract column moves in progress on documents and users (see
+-- internal/database/schema.go). The app reloads this table periodically.
+-- Example rename of documents.name to title:
+--   ALTER TABLE documents ADD COLUMN title TEXT;
+--   INSERT INTO schema_transitions (table_name, column_name, new_column, phase)
+--   VALUES ('documents', 'name', 'title', 'expand');
+--   UPDATE documents SET title = name WHERE title IS NULL;   -- in batches
+--   UPDATE schema_transitions SET phase = 'migrated' ...;     -- then 'contract'
+
+CREATE TABLE IF NOT EXISTS schema_transitions (
+    table_name  TEXT NOT NULL CHECK (table_name IN ('documents', 'users')),
+    column_name TEXT NOT NULL,
+    new_column  TEXT NOT NULL,
+    phase       TEXT NOT NULL CHECK (phase IN ('expand', 'migrated', 'contract')),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (table_name, column_name),
+    -- Column names are spliced into SQL; keep them plain identifiers.
+    CHECK (new_column ~ '^[a-z_][a-z0-9_]*$' AND column_name ~ '^[a-z_][a-z0-9_]*$')
+);
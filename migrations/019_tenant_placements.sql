@@ -0,0 +1,14 @@
+-- Tenant placements
+-- Orgs whose content (documents, connectors, conversations, vectors) lives
+-- in a dedicated schema or database instead of the shared tables (see
+-- internal/tenancy). Orgs without a row are pooled. database_env names the
+-- environment variable holding the org database's URL, so credentials stay
+-- out of this table; that database must have these migrations applied.
+
+CREATE TABLE IF NOT EXISTS tenant_placements (
+    org_id       TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    schema_name  TEXT CHECK (schema_name ~ '^[a-z_][a-z0-9_]*$'),
+    database_env TEXT CHECK (database_env ~ '^[A-Z_][A-Z0-9_]*$'),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((schema_name IS NULL) <> (database_env IS NULL))
+);
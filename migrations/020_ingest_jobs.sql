@@ -0,0 +1,21 @@
+-- Ingest jobs
+-- Durable queue for document ingestion (see internal/document/queue.go).
+-- A row is written with the document change it ingests and deleted once the
+-- ingest succeeds or gives up. Workers claim the oldest runnable row with
+-- FOR UPDATE SKIP LOCKED and hold it until locked_until; a job whose worker
+-- died is picked up again after its lease expires.
+
+CREATE TABLE IF NOT EXISTS ingest_jobs (
+    id           BIGSERIAL PRIMARY KEY,
+    document_id  TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    org_id       TEXT NOT NULL,
+    text         TEXT,          -- appended text; NULL ingests the whole document
+    first_chunk  INT NOT NULL DEFAULT 0,
+    attempts     INT NOT NULL DEFAULT 0,
+    run_after    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    last_error   TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_runnable ON ingest_jobs(run_after, id);
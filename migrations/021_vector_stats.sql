@@ -0,0 +1,14 @@
+-- Vector stats
+-- Per-org vector count and row size, kept current by statement-level
+-- triggers on langchain_pg_embedding that the app installs at startup (the
+-- embedding table itself is created by langchaingo). See
+-- internal/retrieval/stats.go. No foreign key: rows are removed by the
+-- trigger once an org's vectors are gone, which happens after the org row
+-- is deleted.
+
+CREATE TABLE IF NOT EXISTS vector_stats (
+    org_id     TEXT PRIMARY KEY,
+    vectors    BIGINT NOT NULL DEFAULT 0,
+    bytes      BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
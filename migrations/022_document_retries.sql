@@ -0,0 +1,8 @@
+-- Document retries
+-- Ingestion attempts beyond the first, from queue retries and the
+-- stuck-document sweep (internal/document/queue.go).
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS retries INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_documents_unfinished ON documents(updated_at)
+    WHERE status IN ('pending', 'processing');
@@ -0,0 +1,22 @@
+-- Embedding batches
+-- Documents uploaded for batch ingestion are embedded through the OpenAI
+-- Batch API (see internal/document/batch.go). Their ingest jobs are marked
+-- batch and picked up by the batch submitter instead of the workers; each
+-- submitted batch is tracked here until its results are stored, and its
+-- documents point at it through batch_id. Replicas poll a batch under a
+-- lease, like ingest jobs.
+
+ALTER TABLE ingest_jobs ADD COLUMN IF NOT EXISTS batch BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS embedding_batches (
+    id           TEXT PRIMARY KEY,  -- the Batch API's batch id
+    org_id       TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    status       TEXT NOT NULL,     -- last status reported by the API
+    locked_until TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS batch_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_documents_batch ON documents(batch_id) WHERE batch_id IS NOT NULL;
@@ -0,0 +1,25 @@
+-- Usage metering and quotas
+-- usage_counters accumulates each org's model usage per calendar month
+-- (UTC); document counts and storage are read live from the document and
+-- vector tables. org_quotas holds the hard limits an operator set for an
+-- org, NULL meaning unlimited. Both live in the shared database, isolated
+-- orgs included, since they are billing data rather than content.
+
+CREATE TABLE IF NOT EXISTS usage_counters (
+    org_id            TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    period            DATE NOT NULL,   -- first day of the month
+    embedding_tokens  BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens     BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, period)
+);
+
+CREATE TABLE IF NOT EXISTS org_quotas (
+    org_id               TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    max_documents        BIGINT,
+    max_storage_bytes    BIGINT,
+    max_embedding_tokens BIGINT,   -- per month
+    max_llm_tokens       BIGINT,   -- prompt + completion, per month
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
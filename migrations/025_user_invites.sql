@@ -0,0 +1,12 @@
+-- User invitations
+-- An admin invites someone by email: the user row is created with status
+-- 'invited' and no password, and a one-time token (stored hashed) lets them
+-- set a password and join. Re-inviting replaces the user's token.
+
+CREATE TABLE IF NOT EXISTS user_invites (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    invited_by TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,7 @@
+-- Ingestion checkpoints
+-- checkpoint is the chunk index an ingest job resumes at: every chunk of
+-- the job below it is stored. Workers advance it as batches complete, so a
+-- job taken over after a crash, timeout or redeploy skips what was already
+-- embedded. NULL means nothing has been stored yet.
+
+ALTER TABLE ingest_jobs ADD COLUMN IF NOT EXISTS checkpoint INT;
@@ -0,0 +1,14 @@
+-- Password resets and token versions
+-- token_version is copied into every access token; bumping it (password
+-- change or reset, role change) makes the tokens issued before it invalid.
+-- Tokens issued before this migration carry no version and match 0.
+-- password_resets holds one pending reset per user, token stored hashed.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,32 @@
+-- Single sign-on (OpenID Connect)
+-- An org configures one identity provider and claims the email domains
+-- it owns; a domain belongs to at most one org, which is how a login
+-- finds its org. sso_logins holds started logins (state stored hashed,
+-- nonce and PKCE verifier) until the IdP redirects back.
+
+CREATE TABLE IF NOT EXISTS sso_providers (
+    org_id        TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    issuer        TEXT NOT NULL,
+    client_id     TEXT NOT NULL,
+    client_secret TEXT NOT NULL,
+    default_role  TEXT NOT NULL DEFAULT 'member',
+    enforced      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sso_domains (
+    domain TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL REFERENCES sso_providers(org_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sso_domains_org ON sso_domains (org_id);
+
+CREATE TABLE IF NOT EXISTS sso_logins (
+    state_hash    TEXT PRIMARY KEY,
+    org_id        TEXT NOT NULL REFERENCES sso_providers(org_id) ON DELETE CASCADE,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
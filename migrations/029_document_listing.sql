@@ -0,0 +1,5 @@
+-- Document listing
+-- Serves the default page of GET /api/v1/documents, an org's newest
+-- documents first, without sorting the whole org.
+
+CREATE INDEX IF NOT EXISTS idx_documents_org_created ON documents(org_id, created_at DESC, id DESC);
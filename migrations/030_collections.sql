@@ -0,0 +1,22 @@
+-- Collections
+-- Named groups of an org's documents, e.g. one per knowledge base. A
+-- document can be in several collections; queries can be scoped to some.
+
+CREATE TABLE IF NOT EXISTS collections (
+    id          TEXT PRIMARY KEY,
+    org_id      TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS collection_documents (
+    collection_id TEXT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+    document_id   TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    added_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, document_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_collection_documents_document ON collection_documents(document_id);
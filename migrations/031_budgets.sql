@@ -0,0 +1,15 @@
+-- Budgets
+-- usage_counters.spend_usd is what an org's metered tokens cost in the
+-- month at the deployment's prices. org_budgets holds the monthly cap an
+-- org admin set; past downgrade_at (a share of the cap) completions use the
+-- operator's fallback model, and at the cap token-consuming requests are
+-- refused (internal/usage/budget.go).
+
+ALTER TABLE usage_counters ADD COLUMN IF NOT EXISTS spend_usd DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS org_budgets (
+    org_id          TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    monthly_cap_usd DOUBLE PRECISION NOT NULL CHECK (monthly_cap_usd > 0),
+    downgrade_at    DOUBLE PRECISION NOT NULL DEFAULT 0.8 CHECK (downgrade_at > 0 AND downgrade_at <= 1),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
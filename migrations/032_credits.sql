@@ -0,0 +1,32 @@
+-- Prepaid credits
+-- Orgs on prepaid pricing hold credit grants (in millionths of a USD) that
+-- metered usage draws down, earliest expiry first. credit_transactions is
+-- the ledger: grants, consumption per metered operation, and the unused
+-- rest of grants that expired. Orgs that never had a grant are billed by
+-- subscription and aren't affected (internal/usage/credits.go).
+
+CREATE TABLE IF NOT EXISTS credit_grants (
+    id               TEXT PRIMARY KEY,
+    org_id           TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    amount_micros    BIGINT NOT NULL CHECK (amount_micros > 0),
+    remaining_micros BIGINT NOT NULL CHECK (remaining_micros >= 0),
+    expires_at       TIMESTAMPTZ,
+    note             TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_grants_open ON credit_grants(org_id, expires_at)
+    WHERE remaining_micros > 0;
+
+CREATE TABLE IF NOT EXISTS credit_transactions (
+    id            BIGSERIAL PRIMARY KEY,
+    org_id        TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    kind          TEXT NOT NULL CHECK (kind IN ('grant', 'consumption', 'expiry')),
+    operation     TEXT NOT NULL DEFAULT '', -- consumption: what was metered
+    amount_micros BIGINT NOT NULL,          -- signed: grants add, the rest subtract
+    grant_id      TEXT REFERENCES credit_grants(id) ON DELETE SET NULL,
+    note          TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_org ON credit_transactions(org_id, id DESC);
@@ -0,0 +1,8 @@
+-- Document tags
+-- User-defined labels set at upload. Listings filter on them, and
+-- ingestion copies them onto every chunk's cmetadata as "tags" so queries
+-- can filter on them too.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_documents_tags ON documents USING gin (tags);
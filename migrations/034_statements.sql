@@ -0,0 +1,22 @@
+-- Usage statements
+-- usage_counters.queries counts answered queries per month. At the start of
+-- each month a statement of the previous one is generated per org from the
+-- counters and stored as it was issued, so later price or quota changes
+-- don't rewrite history; it is mailed to the org's billing contacts, or
+-- its admins without any (internal/usage/statement.go).
+
+ALTER TABLE usage_counters ADD COLUMN IF NOT EXISTS queries BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS usage_statements (
+    org_id       TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    period       DATE NOT NULL,   -- first day of the month
+    statement    JSONB NOT NULL,
+    generated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, period)
+);
+
+CREATE TABLE IF NOT EXISTS org_billing_contacts (
+    org_id     TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    emails     TEXT[] NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
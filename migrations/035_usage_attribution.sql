@@ -0,0 +1,20 @@
+-- Usage attribution
+-- usage_attribution splits usage_counters within an org by who the work
+-- was done for: actor is a user ID or "apikey:<id>", '' for work nobody
+-- requested directly (ingestion, connector syncs, public sites), and
+-- workspace_id is the workspace within the org, '' until workspaces
+-- exist. Like usage_counters it is billing data in the shared database.
+
+CREATE TABLE IF NOT EXISTS usage_attribution (
+    org_id            TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    period            DATE NOT NULL,   -- first day of the month
+    actor             TEXT NOT NULL DEFAULT '',
+    workspace_id      TEXT NOT NULL DEFAULT '',
+    embedding_tokens  BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens     BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    queries           BIGINT NOT NULL DEFAULT 0,
+    spend_usd         DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, period, actor, workspace_id)
+);
@@ -0,0 +1,8 @@
+-- Ingestion fairness
+-- Claiming an ingest job counts each org's running jobs (live leases) to
+-- serve the org with the fewest first and to enforce the per-org limit
+-- (see internal/document/queue.go). Only leased rows are indexed, so the
+-- count stays cheap however deep a bulk import's backlog is.
+
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_leased ON ingest_jobs(locked_until, org_id)
+    WHERE locked_until IS NOT NULL;
@@ -0,0 +1,16 @@
+-- Direct uploads
+-- Large files are uploaded straight to object storage (see
+-- internal/document/upload.go). The document waits in status 'uploading'
+-- until the client reports the upload complete; its ingest job then names
+-- the object in object_key until a worker has extracted the file's text
+-- into the document.
+--
+-- The status check from the initial schema is rebuilt with every status
+-- documents can be in, the batch ingestion stages included.
+
+ALTER TABLE ingest_jobs ADD COLUMN IF NOT EXISTS object_key TEXT;
+
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_status_check;
+ALTER TABLE documents ADD CONSTRAINT documents_status_check CHECK (status IN (
+    'pending', 'processing', 'ready', 'failed', 'uploading',
+    'batch_validating', 'batch_in_progress', 'batch_finalizing'));
@@ -0,0 +1,18 @@
+-- Document imports
+-- An uploaded ZIP or TAR archive becomes one document per file (see
+-- internal/document/imports.go). document_imports records the archive and
+-- the files left out; its documents carry the import's id in
+-- metadata->>'import_id', indexed so an import's status can be read from
+-- them. Imports are content and move with the org to isolated storage.
+
+CREATE TABLE IF NOT EXISTS document_imports (
+    id         TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    files      INT NOT NULL,
+    skipped    JSONB NOT NULL DEFAULT '[]',  -- [{"path": ..., "reason": ...}]
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_documents_import ON documents ((metadata->>'import_id'))
+    WHERE metadata ? 'import_id';
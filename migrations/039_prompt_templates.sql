@@ -0,0 +1,19 @@
+-- Prompt templates
+-- An org's own wording of the system and user messages sent to the LLM,
+-- with {{variable}} placeholders filled in per query. At most one template
+-- per org is active; an org without one uses the built-in prompt.
+
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    id              TEXT PRIMARY KEY,
+    org_id          TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    system_template TEXT NOT NULL DEFAULT '',
+    user_template   TEXT NOT NULL DEFAULT '',
+    active          BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_templates_active
+    ON prompt_templates(org_id) WHERE active;
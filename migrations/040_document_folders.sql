@@ -0,0 +1,7 @@
+-- Document folders
+-- A document's folder is the directory part of metadata->>'path' (see
+-- internal/document/folders.go). Folder listings and ?folder= filters
+-- match paths by prefix, which this index serves.
+
+CREATE INDEX IF NOT EXISTS idx_documents_path
+    ON documents (org_id, (COALESCE(metadata->>'path', '')) text_pattern_ops);
@@ -0,0 +1,15 @@
+-- Generation settings
+-- An org's defaults for the sampling parameters of its queries and caps on
+-- what a query may ask for (internal/prompt/generation.go). NULL
+-- temperature/top_p and zero max_tokens leave the provider's defaults.
+
+CREATE TABLE IF NOT EXISTS generation_settings (
+    org_id          TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    temperature     DOUBLE PRECISION CHECK (temperature BETWEEN 0 AND 2),
+    top_p           DOUBLE PRECISION CHECK (top_p > 0 AND top_p <= 1),
+    max_tokens      INT NOT NULL DEFAULT 0 CHECK (max_tokens >= 0),
+    stop            TEXT[] NOT NULL DEFAULT '{}',
+    max_tokens_cap  INT NOT NULL DEFAULT 0 CHECK (max_tokens_cap >= 0),
+    max_temperature DOUBLE PRECISION CHECK (max_temperature BETWEEN 0 AND 2),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
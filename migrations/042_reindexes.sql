@@ -0,0 +1,23 @@
+-- Reindexes
+-- A reindex rebuilds all of an org's vectors from its stored documents
+-- (internal/document/reindex.go). Its ingest jobs carry its id, so its
+-- progress is the number of them left; finished_at is set once none are.
+-- At most one reindex per org runs at a time. Reindexes are content and
+-- move with the org to isolated storage.
+
+CREATE TABLE IF NOT EXISTS reindexes (
+    id              TEXT PRIMARY KEY,
+    org_id          TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    started_by      TEXT NOT NULL,
+    documents       INT NOT NULL,
+    vectors_dropped BIGINT NOT NULL DEFAULT 0,
+    failed          INT NOT NULL DEFAULT 0,
+    started_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at     TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reindexes_running ON reindexes (org_id) WHERE finished_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_reindexes_org ON reindexes (org_id, started_at DESC);
+
+ALTER TABLE ingest_jobs ADD COLUMN IF NOT EXISTS reindex_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_ingest_jobs_reindex ON ingest_jobs (reindex_id) WHERE reindex_id IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Model settings
+-- An org's choice of LLM and embedding model from those the operator
+-- offers (internal/modelpolicy). Empty keeps the deployment's default.
+
+CREATE TABLE IF NOT EXISTS model_settings (
+    org_id          TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    llm_model       TEXT NOT NULL DEFAULT '',
+    embedding_model TEXT NOT NULL DEFAULT '',
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
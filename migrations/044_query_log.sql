@@ -0,0 +1,23 @@
+-- Query log
+-- Every question asked, tagged with its intent by a cheap classification
+-- model (internal/querylog), for analytics. The log is content and moves
+-- with the org to isolated storage. Prompt templates can be made specific
+-- to an intent; '' applies to all, and one template per org and intent can
+-- be active.
+
+CREATE TABLE IF NOT EXISTS query_log (
+    id         TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    actor      TEXT NOT NULL DEFAULT '', -- a user id, "apikey:<id>", or '' for public sites
+    question   TEXT NOT NULL,
+    intent     TEXT NOT NULL DEFAULT '', -- '' when unclassified
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_log_org ON query_log(org_id, created_at DESC);
+
+ALTER TABLE prompt_templates ADD COLUMN IF NOT EXISTS intent TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_prompt_templates_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_templates_active_intent
+    ON prompt_templates(org_id, intent) WHERE active;
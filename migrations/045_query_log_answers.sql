@@ -0,0 +1,10 @@
+-- Query log answers
+-- The answer to each logged question is saved while it streams, every
+-- second or so, so an answer cut short by a crashed server or a dropped
+-- client can still be read back (GET /api/v1/queries/{id}). status is ''
+-- for queries whose answer isn't recorded (MCP tools), then 'answering',
+-- and finally 'answered', 'failed' or 'cancelled'.
+
+ALTER TABLE query_log ADD COLUMN IF NOT EXISTS answer TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_log ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_log ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
@@ -0,0 +1,43 @@
+-- Row-level security
+-- Requests for an org run on the shared pool as app_tenant, with the org in
+-- the app.current_org setting (internal/tenancy/rowsecurity.go). The
+-- policies below admit only that org's documents and users, plus documents
+-- granted to it for reading, so a query missing its org filter can't read
+-- or change another tenant's rows. The server's own role owns the tables
+-- and isn't subject to them: it must be the role running these migrations.
+-- The embedding table's policies are added by the app at startup, since
+-- langchaingo creates that table (internal/retrieval/rowsecurity.go).
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'app_tenant') THEN
+        CREATE ROLE app_tenant NOLOGIN;
+    END IF;
+END $$;
+GRANT app_tenant TO CURRENT_USER;
+
+GRANT USAGE ON SCHEMA public TO app_tenant;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO app_tenant;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO app_tenant;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO app_tenant;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO app_tenant;
+
+-- NULL outside a request for an org.
+CREATE OR REPLACE FUNCTION app_current_org() RETURNS TEXT LANGUAGE sql STABLE AS $$
+    SELECT NULLIF(current_setting('app.current_org', true), '')
+$$;
+
+ALTER TABLE documents ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_rows ON documents;
+CREATE POLICY tenant_rows ON documents TO app_tenant
+    USING (org_id = app_current_org())
+    WITH CHECK (org_id = app_current_org());
+DROP POLICY IF EXISTS tenant_granted ON documents;
+CREATE POLICY tenant_granted ON documents FOR SELECT TO app_tenant
+    USING (id = ANY (ARRAY(SELECT g.document_id FROM document_grants g WHERE g.grantee_org_id = app_current_org())));
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_rows ON users;
+CREATE POLICY tenant_rows ON users TO app_tenant
+    USING (org_id = app_current_org())
+    WITH CHECK (org_id = app_current_org());
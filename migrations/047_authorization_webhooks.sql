@@ -0,0 +1,15 @@
+-- Authorization webhooks
+-- An org's own policy engine (OPA, internal IAM), consulted before
+-- sensitive operations such as deleting or sharing documents. One webhook
+-- per org; secret signs the requests and is never returned to clients
+-- after it is generated.
+
+CREATE TABLE IF NOT EXISTS authorization_webhooks (
+    org_id            TEXT PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    url               TEXT NOT NULL,
+    fail_open         BOOLEAN NOT NULL DEFAULT FALSE,
+    cache_ttl_seconds INT NOT NULL DEFAULT 60 CHECK (cache_ttl_seconds BETWEEN 0 AND 3600),
+    secret            TEXT NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
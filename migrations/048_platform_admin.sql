@@ -0,0 +1,30 @@
+-- Platform administration
+-- Super-admins administer the deployment across orgs through
+-- /api/v1/admin: they list orgs and their usage, suspend and reactivate
+-- them, and impersonate users for support. Only the operator grants the
+-- flag. A suspended org's users, API keys and public sites are refused
+-- until it is reactivated; its data is kept.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS super_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_super_admin ON users(id) WHERE super_admin;
+
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS suspended_at     TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS suspended_reason TEXT NOT NULL DEFAULT '';
+
+-- Every administrative action, and every request made while impersonating.
+-- org_id and the user ids aren't foreign keys: the trail outlives the org.
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id             BIGSERIAL PRIMARY KEY,
+    actor_id       TEXT NOT NULL,           -- super-admin user id, or 'operator'
+    action         TEXT NOT NULL,
+    org_id         TEXT NOT NULL DEFAULT '',
+    target_user_id TEXT NOT NULL DEFAULT '',
+    reason         TEXT NOT NULL DEFAULT '',
+    details        JSONB NOT NULL DEFAULT '{}',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_org ON admin_audit_log(org_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_actor ON admin_audit_log(actor_id, id DESC);
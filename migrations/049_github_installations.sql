@@ -0,0 +1,24 @@
+-- GitHub App installations, bound to the org that installed them
+-- A GitHub connector may only use an installation its org owns. The
+-- binding is made by the install callback, after GitHub confirms the
+-- installing user has access to the installation; an installation belongs
+-- to one org at a time.
+
+CREATE TABLE IF NOT EXISTS github_installations (
+    installation_id BIGINT PRIMARY KEY,
+    org_id          TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    account         TEXT NOT NULL DEFAULT '',  -- GitHub user or organization login
+    created_by      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_github_installations_org ON github_installations(org_id);
+
+-- Pending installs: the state parameter handed to GitHub, stored hashed,
+-- names the org the callback binds the installation to. Single use.
+CREATE TABLE IF NOT EXISTS github_install_states (
+    state_hash TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
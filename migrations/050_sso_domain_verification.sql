@@ -0,0 +1,20 @@
+-- SSO domain verification
+-- A domain claimed for single sign-on routes logins only once the org
+-- proves it owns it with a DNS TXT record carrying verification_token.
+-- Several orgs may claim a domain while unverified; the first to verify
+-- keeps it, and a verified domain belongs to exactly one org.
+
+ALTER TABLE sso_domains
+    ADD COLUMN IF NOT EXISTS verification_token TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS verified_at        TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Domains claimed before verification existed were never checked: they
+-- stop routing logins until their org verifies them.
+UPDATE sso_domains SET verification_token = replace(gen_random_uuid()::text, '-', '')
+WHERE verification_token = '';
+
+ALTER TABLE sso_domains DROP CONSTRAINT IF EXISTS sso_domains_pkey;
+ALTER TABLE sso_domains ADD PRIMARY KEY (domain, org_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sso_domains_verified ON sso_domains (domain) WHERE verified_at IS NOT NULL;
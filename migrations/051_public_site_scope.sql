@@ -0,0 +1,9 @@
+-- Public site scope
+-- A public site answers only from the collections and documents it names;
+-- a site naming neither answers nothing. Sites created before scopes
+-- existed answered from the whole knowledge base and stay silent until an
+-- admin gives them a scope.
+
+ALTER TABLE public_sites
+    ADD COLUMN IF NOT EXISTS collection_ids TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS document_ids   TEXT[] NOT NULL DEFAULT '{}';
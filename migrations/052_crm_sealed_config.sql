@@ -0,0 +1,7 @@
+-- Encrypted CRM credentials
+-- An integration's config (its access token) is stored sealed with the
+-- server's secrets key (internal/secret) in sealed_config; config is left
+-- empty. Rows stored in plaintext before are sealed by the server at
+-- startup.
+
+ALTER TABLE crm_integrations ADD COLUMN IF NOT EXISTS sealed_config BYTEA;
@@ -0,0 +1,28 @@
+-- Collection sharing
+-- A collection grant gives grantee_org_id read-only retrieval access to the
+-- documents in one of owner_org_id's collections. Membership is resolved
+-- when a query runs, so documents added to the collection later are shared
+-- and documents removed from it stop being.
+
+CREATE TABLE IF NOT EXISTS collection_grants (
+    id             TEXT PRIMARY KEY,
+    owner_org_id   TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    grantee_org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    collection_id  TEXT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+    created_by     TEXT NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (collection_id, grantee_org_id),
+    CHECK (owner_org_id <> grantee_org_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_collection_grants_grantee ON collection_grants(grantee_org_id);
+CREATE INDEX IF NOT EXISTS idx_collection_grants_owner ON collection_grants(owner_org_id);
+
+-- Row-level security (046): the grantee reads the shared collection's
+-- documents. The embedding table gets the same policy at startup.
+DROP POLICY IF EXISTS tenant_collection_granted ON documents;
+CREATE POLICY tenant_collection_granted ON documents FOR SELECT TO app_tenant
+    USING (id = ANY (ARRAY(
+        SELECT cd.document_id FROM collection_grants g
+        JOIN collection_documents cd ON cd.collection_id = g.collection_id
+        WHERE g.grantee_org_id = app_current_org())));
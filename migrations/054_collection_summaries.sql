@@ -0,0 +1,22 @@
+-- Collection summaries
+-- With the summary index on, each collection gets a summary node built from
+-- the summaries at the top of its documents' trees, recorded here as they
+-- are built. summary_digest identifies the document summaries the node was
+-- last built from; the node is rebuilt once they change
+-- (internal/summary/collections.go).
+
+CREATE TABLE IF NOT EXISTS document_summaries (
+    document_id TEXT PRIMARY KEY REFERENCES documents(id) ON DELETE CASCADE,
+    summary     TEXT NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS summary_digest TEXT;
+
+-- Row-level security (046): an org sees the summaries of the documents it
+-- sees.
+ALTER TABLE document_summaries ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_rows ON document_summaries;
+CREATE POLICY tenant_rows ON document_summaries TO app_tenant
+    USING (EXISTS (SELECT 1 FROM documents d WHERE d.id = document_id))
+    WITH CHECK (EXISTS (SELECT 1 FROM documents d WHERE d.id = document_id));
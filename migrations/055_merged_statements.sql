@@ -0,0 +1,9 @@
+-- Statements of merged orgs
+-- Merging orgs (internal/orgmerge) moves the source org's usage
+-- statements to the target as they were issued. merged_from is the org a
+-- statement was issued to when that isn't the one holding it now, so the
+-- target can keep both its own and the source's statement of a month.
+
+ALTER TABLE usage_statements ADD COLUMN IF NOT EXISTS merged_from TEXT NOT NULL DEFAULT '';
+ALTER TABLE usage_statements DROP CONSTRAINT IF EXISTS usage_statements_pkey;
+ALTER TABLE usage_statements ADD PRIMARY KEY (org_id, period, merged_from);
@@ -0,0 +1,11 @@
+-- Maintenance mode
+-- The operator's maintenance switch, shared by every replica
+-- (internal/maintenance). The row exists while maintenance is on; replicas
+-- reload it every MAINTENANCE_RELOAD_INTERVAL.
+
+CREATE TABLE IF NOT EXISTS maintenance_mode (
+    id                  BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    message             TEXT NOT NULL DEFAULT '',
+    retry_after_seconds INTEGER NOT NULL,
+    since               TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
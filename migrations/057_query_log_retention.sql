@@ -0,0 +1,5 @@
+-- Query log retention
+-- Entries older than QUERY_LOG_RETENTION are purged across orgs
+-- (internal/querylog), which the per-org index doesn't serve.
+
+CREATE INDEX IF NOT EXISTS idx_query_log_created ON query_log(created_at);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations, applied in version order
+// by internal/migrate (see `server migrate`). Files are named
+// <version>_<name>.sql; a migration, once released, is never edited, only
+// followed by another.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS